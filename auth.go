@@ -2,6 +2,44 @@
 
 package ssb
 
+import (
+	"context"
+
+	"go.cryptoscope.co/muxrpc"
+)
+
 type Authorizer interface {
 	Authorize(remote *FeedRef) error
 }
+
+// ConnDirection tells if a peer dialed us or we dialed it
+type ConnDirection string
+
+const (
+	ConnIncoming ConnDirection = "incoming"
+	ConnOutgoing ConnDirection = "outgoing"
+)
+
+type connDirectionKey struct{}
+
+// WithConnDirection returns a context that tells handlers in which direction their connection was made
+func WithConnDirection(ctx context.Context, dir ConnDirection) context.Context {
+	return context.WithValue(ctx, connDirectionKey{}, dir)
+}
+
+// ConnDirectionFrom returns the direction stored with WithConnDirection, false if the context doesn't have one
+func ConnDirectionFrom(ctx context.Context) (ConnDirection, bool) {
+	dir, ok := ctx.Value(connDirectionKey{}).(ConnDirection)
+	return dir, ok
+}
+
+// ConnAuthorizer is asked after the secret-handshake if a connection may stay open.
+// Returning an error denies it, the error says why.
+type ConnAuthorizer interface {
+	AuthorizeConn(remote *FeedRef, dir ConnDirection) error
+}
+
+// CallAuthorizer is asked for every muxrpc call a peer makes. Returning an error denies the call, the error says why.
+type CallAuthorizer interface {
+	AuthorizeCall(remote *FeedRef, method muxrpc.Method) error
+}
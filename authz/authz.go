@@ -0,0 +1,55 @@
+// SPDX-License-Identifier: MIT
+
+// Package authz has policies for who may connect to a bot and what they may call.
+// They implement ssb.ConnAuthorizer and ssb.CallAuthorizer and are set with sbot.WithConnAuthorizer and sbot.WithCallAuthorizer.
+package authz
+
+import (
+	"fmt"
+
+	"go.cryptoscope.co/muxrpc"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// ErrDenied is returned by the authorizers of this package, Reason says why
+type ErrDenied struct {
+	Peer   *ssb.FeedRef
+	Reason string
+}
+
+func (e ErrDenied) Error() string {
+	return fmt.Sprintf("authz: %s denied: %s", e.Peer.ShortRef(), e.Reason)
+}
+
+// AllConns allows a connection only if all of the authorizers do. The first denial is returned.
+func AllConns(auths ...ssb.ConnAuthorizer) ssb.ConnAuthorizer {
+	return allConns(auths)
+}
+
+type allConns []ssb.ConnAuthorizer
+
+func (all allConns) AuthorizeConn(remote *ssb.FeedRef, dir ssb.ConnDirection) error {
+	for _, a := range all {
+		if err := a.AuthorizeConn(remote, dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AllCalls allows a call only if all of the authorizers do. The first denial is returned.
+func AllCalls(auths ...ssb.CallAuthorizer) ssb.CallAuthorizer {
+	return allCalls(auths)
+}
+
+type allCalls []ssb.CallAuthorizer
+
+func (all allCalls) AuthorizeCall(remote *ssb.FeedRef, method muxrpc.Method) error {
+	for _, a := range all {
+		if err := a.AuthorizeCall(remote, method); err != nil {
+			return err
+		}
+	}
+	return nil
+}
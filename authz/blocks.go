@@ -0,0 +1,35 @@
+// SPDX-License-Identifier: MIT
+
+package authz
+
+import (
+	"github.com/pkg/errors"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/graph"
+)
+
+// Blocks denies the connections with peers that self blocks, the ones we dial included.
+// The follow graph is built for every connection, so an unblock lets them in again right away.
+type Blocks struct {
+	b    graph.Builder
+	self *ssb.FeedRef
+}
+
+var _ ssb.ConnAuthorizer = (*Blocks)(nil)
+
+// NewBlocks returns a Blocks policy for the blocks of self
+func NewBlocks(b graph.Builder, self *ssb.FeedRef) *Blocks {
+	return &Blocks{b: b, self: self}
+}
+
+func (bl *Blocks) AuthorizeConn(remote *ssb.FeedRef, dir ssb.ConnDirection) error {
+	fg, err := bl.b.Build()
+	if err != nil {
+		return errors.Wrap(err, "authz: failed to build the follow graph")
+	}
+	if fg.Blocks(bl.self, remote) {
+		return ErrDenied{Peer: remote, Reason: "blocked"}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+// SPDX-License-Identifier: MIT
+
+package authz
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/graph"
+)
+
+// Graph lets peers connect that are at most maxHops away from self in the follow graph.
+// Peers that self blocks are always denied, also the ones we dial. Other peers we dial are allowed, like pubs we were told about.
+type Graph struct {
+	b       graph.Builder
+	self    *ssb.FeedRef
+	maxHops int
+}
+
+var _ ssb.ConnAuthorizer = (*Graph)(nil)
+
+// NewGraph returns a Graph policy for the follow graph of self
+func NewGraph(b graph.Builder, self *ssb.FeedRef, maxHops int) *Graph {
+	return &Graph{b: b, self: self, maxHops: maxHops}
+}
+
+func (g *Graph) AuthorizeConn(remote *ssb.FeedRef, dir ssb.ConnDirection) error {
+	fg, err := g.b.Build()
+	if err != nil {
+		return errors.Wrap(err, "authz: failed to build the follow graph")
+	}
+	if fg.Blocks(g.self, remote) {
+		return ErrDenied{Peer: remote, Reason: "blocked"}
+	}
+	if dir == ssb.ConnOutgoing {
+		return nil
+	}
+
+	err = g.b.Authorizer(g.self, g.maxHops).Authorize(remote)
+	if oor, ok := errors.Cause(err).(*ssb.ErrOutOfReach); ok {
+		return ErrDenied{Peer: remote, Reason: fmt.Sprintf("more than %d hops away", oor.Max)}
+	}
+	return err
+}
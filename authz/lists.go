@@ -0,0 +1,258 @@
+// SPDX-License-Identifier: MIT
+
+package authz
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/muxrpc"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+// ListsFile is the name of the file with the lists in a repo
+const ListsFile = "authz.json"
+
+// ListsPath returns where the lists of r are stored
+func ListsPath(r repo.Interface) string {
+	return r.GetPath(ListsFile)
+}
+
+// ListsData is what the lists file holds
+type ListsData struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+
+	// Restricted are methods (like blobs.add) or groups of them (like blobs) that only peers on the allow list may call
+	Restricted []string `json:"restricted"`
+}
+
+// Lists are static allow and deny lists, stored as JSON in a file.
+//
+// Denied peers can't connect at all. If the allow list isn't empty, only peers on it can connect to us, we can still dial others.
+// Restricted methods can only be called by peers on the allow list.
+// Changes of the file by other processes, like the sbotcli block subcommands, are picked up on the next check.
+type Lists struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	data    ListsData
+	allow   map[string]bool
+	deny    map[string]bool
+}
+
+var (
+	_ ssb.ConnAuthorizer = (*Lists)(nil)
+	_ ssb.CallAuthorizer = (*Lists)(nil)
+)
+
+// OpenLists loads the lists from path. A missing file is the same as empty lists.
+func OpenLists(path string) (*Lists, error) {
+	l := &Lists{path: path}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// reload reads the file again if it changed. l.mu needs to be held.
+func (l *Lists) reload() error {
+	fi, err := os.Stat(l.path)
+	if os.IsNotExist(err) {
+		l.set(ListsData{})
+		l.modTime, l.size = time.Time{}, 0
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "authz: failed to check lists file")
+	}
+	if fi.ModTime().Equal(l.modTime) && fi.Size() == l.size && l.allow != nil {
+		return nil
+	}
+
+	raw, err := ioutil.ReadFile(l.path)
+	if err != nil {
+		return errors.Wrap(err, "authz: failed to read lists file")
+	}
+	var data ListsData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return errors.Wrapf(err, "authz: invalid lists file %s", l.path)
+	}
+	for _, refs := range [][]string{data.Allow, data.Deny} {
+		for _, r := range refs {
+			if _, err := ssb.ParseFeedRef(r); err != nil {
+				return errors.Wrapf(err, "authz: invalid feed in %s", l.path)
+			}
+		}
+	}
+	l.set(data)
+	l.modTime, l.size = fi.ModTime(), fi.Size()
+	return nil
+}
+
+// set replaces the lists. l.mu needs to be held.
+func (l *Lists) set(data ListsData) {
+	l.data = data
+	l.allow = make(map[string]bool, len(data.Allow))
+	for _, r := range data.Allow {
+		l.allow[r] = true
+	}
+	l.deny = make(map[string]bool, len(data.Deny))
+	for _, r := range data.Deny {
+		l.deny[r] = true
+	}
+}
+
+func (l *Lists) AuthorizeConn(remote *ssb.FeedRef, dir ssb.ConnDirection) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.reload(); err != nil {
+		return err
+	}
+	if l.deny[remote.Ref()] {
+		return ErrDenied{Peer: remote, Reason: "on the deny list"}
+	}
+	if dir == ssb.ConnIncoming && len(l.allow) > 0 && !l.allow[remote.Ref()] {
+		return ErrDenied{Peer: remote, Reason: "not on the allow list"}
+	}
+	return nil
+}
+
+func (l *Lists) AuthorizeCall(remote *ssb.FeedRef, method muxrpc.Method) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.reload(); err != nil {
+		return err
+	}
+	if l.allow[remote.Ref()] {
+		return nil
+	}
+	name := method.String()
+	for _, r := range l.data.Restricted {
+		if name == r || strings.HasPrefix(name, r+".") {
+			return ErrDenied{Peer: remote, Reason: name + " is restricted to the allow list"}
+		}
+	}
+	return nil
+}
+
+// Data returns a copy of the lists
+func (l *Lists) Data() (ListsData, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.reload(); err != nil {
+		return ListsData{}, err
+	}
+	return ListsData{
+		Allow:      append([]string(nil), l.data.Allow...),
+		Deny:       append([]string(nil), l.data.Deny...),
+		Restricted: append([]string(nil), l.data.Restricted...),
+	}, nil
+}
+
+// Allow puts ref on the allow list and takes it off the deny list
+func (l *Lists) Allow(ref *ssb.FeedRef) error {
+	return l.update(func(d *ListsData) {
+		d.Deny = without(d.Deny, ref.Ref())
+		d.Allow = with(d.Allow, ref.Ref())
+	})
+}
+
+// Deny puts ref on the deny list and takes it off the allow list
+func (l *Lists) Deny(ref *ssb.FeedRef) error {
+	return l.update(func(d *ListsData) {
+		d.Allow = without(d.Allow, ref.Ref())
+		d.Deny = with(d.Deny, ref.Ref())
+	})
+}
+
+// Remove takes ref off both lists
+func (l *Lists) Remove(ref *ssb.FeedRef) error {
+	return l.update(func(d *ListsData) {
+		d.Allow = without(d.Allow, ref.Ref())
+		d.Deny = without(d.Deny, ref.Ref())
+	})
+}
+
+// Restrict makes method (or a group of methods like blobs) callable only by peers on the allow list, or lifts that
+func (l *Lists) Restrict(method string, restricted bool) error {
+	if method == "" {
+		return errors.New("authz: need a method to restrict")
+	}
+	return l.update(func(d *ListsData) {
+		if restricted {
+			d.Restricted = with(d.Restricted, method)
+		} else {
+			d.Restricted = without(d.Restricted, method)
+		}
+	})
+}
+
+// update changes the lists and writes them to the file
+func (l *Lists) update(change func(*ListsData)) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.reload(); err != nil {
+		return err
+	}
+	data := l.data
+	change(&data)
+
+	raw, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "authz: failed to encode lists")
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(l.path), ListsFile)
+	if err != nil {
+		return errors.Wrap(err, "authz: failed to create lists file")
+	}
+	_, err = tmp.Write(raw)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "authz: failed to write lists file")
+	}
+	if err := os.Rename(tmp.Name(), l.path); err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "authz: failed to replace lists file")
+	}
+	l.set(data)
+	// read it again on the next check, to get the new modification time
+	l.modTime = time.Time{}
+	return nil
+}
+
+func with(list []string, s string) []string {
+	for _, e := range list {
+		if e == s {
+			return list
+		}
+	}
+	list = append(append([]string(nil), list...), s)
+	sort.Strings(list)
+	return list
+}
+
+func without(list []string, s string) []string {
+	out := make([]string, 0, len(list))
+	for _, e := range list {
+		if e != s {
+			out = append(out, e)
+		}
+	}
+	return out
+}
@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: MIT
+
+package authz
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/muxrpc"
+
+	"go.cryptoscope.co/ssb"
+)
+
+func feedOf(b byte) *ssb.FeedRef {
+	return &ssb.FeedRef{
+		ID:   bytes.Repeat([]byte{b}, 32),
+		Algo: ssb.RefAlgoFeedSSB1,
+	}
+}
+
+func requireDenied(t *testing.T, err error) {
+	_, ok := errors.Cause(err).(ErrDenied)
+	require.True(t, ok, "expected a denial, got %v", err)
+}
+
+func TestLists(t *testing.T) {
+	r := require.New(t)
+
+	dir, err := ioutil.TempDir("", "authz")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, ListsFile)
+
+	alice, bob, claire := feedOf(1), feedOf(2), feedOf(3)
+
+	l, err := OpenLists(path)
+	r.NoError(err)
+
+	// empty lists allow everything
+	r.NoError(l.AuthorizeConn(alice, ssb.ConnIncoming))
+	r.NoError(l.AuthorizeCall(alice, muxrpc.Method{"blobs", "add"}))
+
+	r.NoError(l.Deny(bob))
+	requireDenied(t, l.AuthorizeConn(bob, ssb.ConnIncoming))
+	requireDenied(t, l.AuthorizeConn(bob, ssb.ConnOutgoing))
+	r.NoError(l.AuthorizeConn(alice, ssb.ConnIncoming))
+
+	// an allow list keeps everyone else from connecting to us, we can still dial them
+	r.NoError(l.Allow(alice))
+	r.NoError(l.AuthorizeConn(alice, ssb.ConnIncoming))
+	requireDenied(t, l.AuthorizeConn(claire, ssb.ConnIncoming))
+	r.NoError(l.AuthorizeConn(claire, ssb.ConnOutgoing))
+
+	// allowing takes a feed off the deny list
+	r.NoError(l.Allow(bob))
+	r.NoError(l.AuthorizeConn(bob, ssb.ConnIncoming))
+	r.NoError(l.Remove(bob))
+	requireDenied(t, l.AuthorizeConn(bob, ssb.ConnIncoming))
+
+	r.NoError(l.Restrict("blobs", true))
+	r.NoError(l.AuthorizeCall(alice, muxrpc.Method{"blobs", "add"}))
+	requireDenied(t, l.AuthorizeCall(claire, muxrpc.Method{"blobs", "add"}))
+	requireDenied(t, l.AuthorizeCall(claire, muxrpc.Method{"blobs"}))
+	r.NoError(l.AuthorizeCall(claire, muxrpc.Method{"blobsx", "get"}))
+	r.NoError(l.AuthorizeCall(claire, muxrpc.Method{"whoami"}))
+
+	// another process sees the changes
+	l2, err := OpenLists(path)
+	r.NoError(err)
+	data, err := l2.Data()
+	r.NoError(err)
+	r.Equal([]string{alice.Ref()}, data.Allow)
+	r.Empty(data.Deny)
+	r.Equal([]string{"blobs"}, data.Restricted)
+
+	// and the first one picks up the changes of the other
+	r.NoError(l2.Restrict("blobs", false))
+	r.NoError(l2.Deny(claire))
+	r.NoError(l.AuthorizeCall(claire, muxrpc.Method{"blobs", "add"}))
+	requireDenied(t, l.AuthorizeConn(claire, ssb.ConnOutgoing))
+}
+
+func TestListsInvalidFile(t *testing.T) {
+	r := require.New(t)
+
+	dir, err := ioutil.TempDir("", "authz")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, ListsFile)
+
+	r.NoError(ioutil.WriteFile(path, []byte(`{"deny":["not a feed"]}`), 0600))
+	_, err = OpenLists(path)
+	r.Error(err)
+}
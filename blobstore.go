@@ -6,6 +6,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"time"
 
 	"go.cryptoscope.co/luigi"
 	"go.cryptoscope.co/muxrpc"
@@ -28,9 +29,9 @@ type BlobStore interface {
 	// Put stores the data in the reader in the blob store and returns the address.
 	Put(blob io.Reader) (*BlobRef, error)
 
-	// PutExpected makes sure the added blob really is the passedBlobref
-	// helpful for want/get operations which don't want to wast resources
-	// PutExpected(io.Reader, *BlobRef) error
+	// PutExpected is like Put but only keeps the blob if it really has the passed reference.
+	// Replication uses it, so that a peer can't make us store something we didn't ask for.
+	PutExpected(blob io.Reader, ref *BlobRef) error
 
 	// Delete deletes a blob from the blob store.
 	Delete(ref *BlobRef) error
@@ -38,6 +39,13 @@ type BlobStore interface {
 	// List returns a source of the refs of all stored blobs.
 	List() luigi.Source
 
+	// ListMeta returns a source of the BlobMeta of all stored blobs, the oldest first.
+	// Unlike List it doesn't need to look at the files.
+	ListMeta() luigi.Source
+
+	// Stats returns the number of stored blobs and their total size.
+	Stats() (BlobStoreStats, error)
+
 	// Size returns the size of the blob with given ref.
 	Size(ref *BlobRef) (int64, error)
 
@@ -45,6 +53,19 @@ type BlobStore interface {
 	Changes() luigi.Broadcast
 }
 
+// BlobMeta is what the blob store knows about a stored blob without opening it.
+type BlobMeta struct {
+	Ref   *BlobRef  `json:"ref"`
+	Size  int64     `json:"size"`
+	Added time.Time `json:"added"`
+}
+
+// BlobStoreStats are the number of stored blobs and their total size in bytes.
+type BlobStoreStats struct {
+	Count int64 `json:"count"`
+	Size  int64 `json:"size"`
+}
+
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 -o mock/wantmanager.go . WantManager
 type WantManager interface {
 	io.Closer
@@ -56,6 +77,10 @@ type WantManager interface {
 	CreateWants(context.Context, luigi.Sink, muxrpc.Endpoint) luigi.Sink
 
 	AllWants() []BlobWant
+
+	// Announce tells the connected peers that we have the blob, without them asking for it.
+	// Only peers for which to returns true are told, all of them if it is nil. It returns how many peers were told.
+	Announce(ref *BlobRef, to func(*FeedRef) bool) (int, error)
 }
 
 type BlobWant struct {
@@ -72,9 +97,11 @@ func (w BlobWant) String() string {
 
 // BlobStoreNotification contains info on a single change of the blob store.
 // Op is either "rm" or "put".
+// Corrupt is set on removals of blobs that didn't match their reference anymore.
 type BlobStoreNotification struct {
-	Op  BlobStoreOp
-	Ref *BlobRef
+	Op      BlobStoreOp
+	Ref     *BlobRef
+	Corrupt bool
 }
 
 func (bn BlobStoreNotification) String() string {
@@ -0,0 +1,155 @@
+// SPDX-License-Identifier: MIT
+
+package blobstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// accessFile holds the last access times of the blobs, next to the sha256 directory
+const accessFile = "accessed.json"
+
+func (store *blobStore) accessPath() string {
+	return filepath.Join(store.basePath, accessFile)
+}
+
+// touch updates the access time of the blob with key. mu needs to be held.
+func (store *blobStore) touch(key string) {
+	store.accessed[key] = time.Now().Unix()
+	store.accessedDirty = true
+}
+
+// lastAccess returns when the blob with key was last read or written.
+// Blobs that were stored before access times were tracked fall back to when they were added.
+func (store *blobStore) lastAccess(key string, added time.Time) time.Time {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if ts, has := store.accessed[key]; has {
+		return time.Unix(ts, 0)
+	}
+	return added
+}
+
+// saveAccessTimes writes the access times to disk, if they changed since the last call
+func (store *blobStore) saveAccessTimes() error {
+	store.mu.Lock()
+	if !store.accessedDirty {
+		store.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(store.accessed)
+	store.accessedDirty = false
+	store.mu.Unlock()
+
+	// the next call tries again if this one failed
+	defer func() {
+		if err != nil {
+			store.mu.Lock()
+			store.accessedDirty = true
+			store.mu.Unlock()
+		}
+	}()
+	if err != nil {
+		return errors.Wrap(err, "blobstore: failed to encode access times")
+	}
+
+	tmpPath := store.getTmpPath()
+	if err = ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+		return errors.Wrap(err, "blobstore: failed to write access times")
+	}
+	if err = os.Rename(tmpPath, store.accessPath()); err != nil {
+		os.Remove(tmpPath)
+		return errors.Wrap(err, "blobstore: failed to replace access times")
+	}
+	return nil
+}
+
+func loadAccessTimes(p string) (map[string]int64, error) {
+	accessed := make(map[string]int64)
+	data, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return accessed, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &accessed); err != nil {
+		// it's only a hint for the GC, start over instead of failing
+		return make(map[string]int64), nil
+	}
+	return accessed, nil
+}
+
+// servedBlob is returned by Get. While it is open the GC keeps the blob.
+// It counts as closed once it was read to the end, for readers that don't close it.
+// It doesn't embed the file so that io.Copy can't bypass Read.
+type servedBlob struct {
+	f *os.File
+
+	store *blobStore
+	key   string
+	once  sync.Once
+
+	// set if the store verifies reads
+	ref  *ssb.BlobRef
+	hash hash.Hash
+}
+
+func (sb *servedBlob) Read(p []byte) (int, error) {
+	n, err := sb.f.Read(p)
+	if sb.hash != nil {
+		sb.hash.Write(p[:n])
+	}
+	if err == io.EOF {
+		sb.release()
+		if sb.hash != nil && !bytes.Equal(sb.hash.Sum(nil), sb.ref.Hash) {
+			sb.hash = nil
+			if qErr := sb.store.quarantine(sb.ref); qErr != nil {
+				level.Warn(sb.store.info).Log("event", "failed to quarantine corrupt blob", "ref", sb.ref.Ref(), "err", qErr)
+			}
+			return n, ErrCorruptBlob
+		}
+	}
+	return n, err
+}
+
+func (sb *servedBlob) Seek(offset int64, whence int) (int64, error) {
+	pos, err := sb.f.Seek(offset, whence)
+	if sb.hash != nil {
+		if err == nil && pos == 0 {
+			sb.hash.Reset()
+		} else {
+			// the hash can't cover what's skipped
+			sb.hash = nil
+		}
+	}
+	return pos, err
+}
+
+func (sb *servedBlob) Close() error {
+	sb.release()
+	return sb.f.Close()
+}
+
+func (sb *servedBlob) release() {
+	sb.once.Do(func() {
+		sb.store.mu.Lock()
+		defer sb.store.mu.Unlock()
+		sb.store.reading[sb.key]--
+		if sb.store.reading[sb.key] <= 0 {
+			delete(sb.store.reading, sb.key)
+		}
+	})
+}
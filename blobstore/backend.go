@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: MIT
+
+package blobstore
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// DefaultBackend is the name of the blob store that keeps the blobs in files, made by New.
+const DefaultBackend = "fs"
+
+// Backend opens a blob store. dir is a local directory the store can use, opts are its settings.
+type Backend func(dir string, opts map[string]string) (ssb.BlobStore, error)
+
+var (
+	backendsMu sync.Mutex
+	backends   = map[string]Backend{
+		DefaultBackend: openFS,
+		"s3":           openS3,
+	}
+)
+
+// openFS understands verify=true, see VerifyOnRead
+func openFS(dir string, opts map[string]string) (ssb.BlobStore, error) {
+	var verify bool
+	if v, has := opts["verify"]; has {
+		var err error
+		verify, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, errors.Wrap(err, "blobstore: invalid verify option")
+		}
+	}
+	return New(dir, VerifyOnRead(verify))
+}
+
+// RegisterBackend makes a blob store available to Open under name. It panics if the name is taken.
+func RegisterBackend(name string, b Backend) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	if b == nil {
+		panic("blobstore: RegisterBackend called with a nil backend")
+	}
+	if _, dup := backends[name]; dup {
+		panic("blobstore: RegisterBackend called twice for " + name)
+	}
+	backends[name] = b
+}
+
+// Backends returns the names of the registered blob stores
+func Backends() []string {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open opens the blob store registered as name. An empty name opens the DefaultBackend.
+func Open(name, dir string, opts map[string]string) (ssb.BlobStore, error) {
+	if name == "" {
+		name = DefaultBackend
+	}
+	backendsMu.Lock()
+	b, has := backends[name]
+	backendsMu.Unlock()
+	if !has {
+		return nil, errors.Errorf("blobstore: unknown backend %q (have: %s)", name, strings.Join(Backends(), ", "))
+	}
+	bs, err := b(dir, opts)
+	return bs, errors.Wrapf(err, "blobstore: failed to open %s backend", name)
+}
+
+// ParseBackendOptions reads options like "bucket=blobs,region=eu-west-1" into a map
+func ParseBackendOptions(s string) (map[string]string, error) {
+	opts := make(map[string]string)
+	if strings.TrimSpace(s) == "" {
+		return opts, nil
+	}
+	for _, kv := range strings.Split(s, ",") {
+		i := strings.Index(kv, "=")
+		if i < 1 {
+			return nil, errors.Errorf("blobstore: backend option %q isn't key=value", kv)
+		}
+		opts[strings.TrimSpace(kv[:i])] = strings.TrimSpace(kv[i+1:])
+	}
+	return opts, nil
+}
@@ -0,0 +1,142 @@
+// SPDX-License-Identifier: MIT
+
+package blobstore
+
+import (
+	"container/list"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// blobCache keeps copies of recently used blobs of a remote store in a directory.
+// Once they take more than max bytes, the least recently used ones are removed.
+type blobCache struct {
+	dir string
+	max int64
+
+	mu      sync.Mutex
+	size    int64
+	order   *list.List // of *cacheEntry, the most recently used first
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key  string
+	size int64
+}
+
+// newBlobCache opens the cache in dir and picks up the files that are there already, the newest counting as most recently used
+func newBlobCache(dir string, max int64) (*blobCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "blobstore: failed to make cache dir")
+	}
+	c := &blobCache{
+		dir:     dir,
+		max:     max,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "blobstore: failed to read cache dir")
+	}
+	sort.Slice(fis, func(i, j int) bool {
+		return fis[i].ModTime().After(fis[j].ModTime())
+	})
+	for _, fi := range fis {
+		if fi.IsDir() {
+			continue
+		}
+		c.entries[fi.Name()] = c.order.PushBack(&cacheEntry{key: fi.Name(), size: fi.Size()})
+		c.size += fi.Size()
+	}
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return c, nil
+}
+
+func (c *blobCache) path(key string) string {
+	return filepath.Join(c.dir, key)
+}
+
+// open returns the cached blob or nil if it isn't cached
+func (c *blobCache) open(key string) *os.File {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, has := c.entries[key]
+	if !has {
+		return nil
+	}
+	f, err := os.Open(c.path(key))
+	if err != nil {
+		c.drop(el)
+		return nil
+	}
+	c.order.MoveToFront(el)
+	return f
+}
+
+// sizeOf returns the size of the cached blob
+func (c *blobCache) sizeOf(key string) (int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, has := c.entries[key]
+	if !has {
+		return 0, false
+	}
+	return el.Value.(*cacheEntry).size, true
+}
+
+// add moves the complete blob at tmpPath into the cache. Blobs bigger than the whole cache are removed right away.
+func (c *blobCache) add(key, tmpPath string, size int64) error {
+	if size > c.max {
+		return os.Remove(tmpPath)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, has := c.entries[key]; has {
+		c.order.MoveToFront(el)
+		return os.Remove(tmpPath)
+	}
+	if err := os.Rename(tmpPath, c.path(key)); err != nil {
+		os.Remove(tmpPath)
+		return errors.Wrap(err, "blobstore: failed to move blob into cache")
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, size: size})
+	c.size += size
+	c.evict()
+	return nil
+}
+
+func (c *blobCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, has := c.entries[key]; has {
+		c.drop(el)
+	}
+}
+
+// evict removes the least recently used blobs until the cache fits. Readers that have them open can finish.
+func (c *blobCache) evict() {
+	for c.size > c.max {
+		el := c.order.Back()
+		if el == nil {
+			return
+		}
+		c.drop(el)
+	}
+}
+
+func (c *blobCache) drop(el *list.Element) {
+	e := c.order.Remove(el).(*cacheEntry)
+	delete(c.entries, e.key)
+	c.size -= e.size
+	os.Remove(c.path(e.key))
+}
@@ -0,0 +1,304 @@
+// SPDX-License-Identifier: MIT
+
+package blobstore
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+
+	"go.cryptoscope.co/ssb"
+)
+
+const (
+	// DefaultGCInterval is the pause between two passes of the GC
+	DefaultGCInterval = 10 * time.Minute
+
+	// DefaultGCBatch is how many blobs a pass deletes at most
+	DefaultGCBatch = 100
+)
+
+// Protection returns which blobs the GC has to keep. It is called once per pass.
+type Protection func() (func(*ssb.BlobRef) bool, error)
+
+// GC deletes the least recently used blobs once the store grows over its size limit and blobs that weren't accessed for longer than the maximum age.
+// Blobs that are being read or that are protected are kept.
+type GC struct {
+	store gcStore
+	info  log.Logger
+
+	maxSize  int64
+	maxAge   time.Duration
+	interval time.Duration
+	batch    int
+
+	protection Protection
+}
+
+type GCOption func(*GC) error
+
+// GCWithMaxSize sets the total size in bytes the blobs should stay under
+func GCWithMaxSize(sz int64) GCOption {
+	return func(gc *GC) error {
+		if sz < 0 {
+			return errors.Errorf("invalid max size: %d", sz)
+		}
+		gc.maxSize = sz
+		return nil
+	}
+}
+
+// GCWithMaxAge sets after which time without access a blob is deleted
+func GCWithMaxAge(age time.Duration) GCOption {
+	return func(gc *GC) error {
+		if age < 0 {
+			return errors.Errorf("invalid max age: %s", age)
+		}
+		gc.maxAge = age
+		return nil
+	}
+}
+
+// GCWithInterval sets the pause between two passes
+func GCWithInterval(d time.Duration) GCOption {
+	return func(gc *GC) error {
+		gc.interval = d
+		return nil
+	}
+}
+
+// GCWithBatch sets how many blobs a single pass deletes at most
+func GCWithBatch(n int) GCOption {
+	return func(gc *GC) error {
+		if n < 1 {
+			return errors.Errorf("invalid batch size: %d", n)
+		}
+		gc.batch = n
+		return nil
+	}
+}
+
+// GCWithProtection sets which blobs are never deleted
+func GCWithProtection(p Protection) GCOption {
+	return func(gc *GC) error {
+		gc.protection = p
+		return nil
+	}
+}
+
+func GCWithLogger(l log.Logger) GCOption {
+	return func(gc *GC) error {
+		gc.info = l
+		return nil
+	}
+}
+
+// gcStore is what the GC needs of a blob store
+type gcStore interface {
+	ListMeta() luigi.Source
+
+	// lastAccess returns when the blob with key was last used, added if that isn't known
+	lastAccess(key string, added time.Time) time.Time
+
+	// deleteUnused deletes ref unless it is being read or was used after lastAccess.
+	// It returns false if the blob was kept or is already gone.
+	deleteUnused(ref *ssb.BlobRef, lastAccess time.Time) (bool, error)
+
+	saveAccessTimes() error
+}
+
+// NewGC returns a GC for bs. It needs a max size or a max age.
+// The local store of New also keeps the blobs that are being read and tracks when they were last accessed,
+// on other stores the blobs are as old as when they were added.
+func NewGC(bs ssb.BlobStore, opts ...GCOption) (*GC, error) {
+	var store gcStore = addedOnly{bs}
+	if local, ok := bs.(*blobStore); ok {
+		store = local
+	}
+
+	gc := &GC{
+		store:    store,
+		info:     log.NewNopLogger(),
+		interval: DefaultGCInterval,
+		batch:    DefaultGCBatch,
+	}
+	for i, o := range opts {
+		if err := o(gc); err != nil {
+			return nil, errors.Wrapf(err, "blobstore: invalid GC option #%d", i)
+		}
+	}
+
+	if gc.maxSize == 0 && gc.maxAge == 0 {
+		return nil, errors.New("blobstore: GC needs a max size or a max age")
+	}
+	return gc, nil
+}
+
+// Serve runs a pass every interval until ctx is canceled.
+// If a pass hit the batch limit, the next one starts right away.
+func (gc *GC) Serve(ctx context.Context) error {
+	defer func() {
+		if err := gc.store.saveAccessTimes(); err != nil {
+			level.Warn(gc.info).Log("event", "blob gc", "err", err)
+		}
+	}()
+
+	for {
+		deleted, err := gc.Collect(ctx)
+		if err != nil {
+			level.Warn(gc.info).Log("event", "blob gc", "err", err)
+		} else if len(deleted) > 0 {
+			level.Info(gc.info).Log("event", "blob gc", "deleted", len(deleted))
+		}
+
+		wait := gc.interval
+		if len(deleted) == gc.batch {
+			wait = time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+type gcBlob struct {
+	ref        *ssb.BlobRef
+	size       int64
+	lastAccess time.Time
+}
+
+// Collect does a single pass and returns the blobs it deleted.
+// Each deletion is also emitted as a remove notification on the changes of the store.
+func (gc *GC) Collect(ctx context.Context) ([]*ssb.BlobRef, error) {
+	blobs, total, err := gc.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(blobs, func(i, j int) bool {
+		return blobs[i].lastAccess.Before(blobs[j].lastAccess)
+	})
+
+	keep := func(*ssb.BlobRef) bool { return false }
+	if gc.protection != nil {
+		keep, err = gc.protection()
+		if err != nil {
+			return nil, errors.Wrap(err, "blobstore: failed to get protected blobs")
+		}
+	}
+
+	now := time.Now()
+	var deleted []*ssb.BlobRef
+	for _, b := range blobs {
+		if len(deleted) == gc.batch || ctx.Err() != nil {
+			break
+		}
+
+		tooBig := gc.maxSize > 0 && total > gc.maxSize
+		tooOld := gc.maxAge > 0 && now.Sub(b.lastAccess) > gc.maxAge
+		if !tooBig && !tooOld {
+			// the rest was accessed more recently
+			break
+		}
+
+		if keep(b.ref) {
+			continue
+		}
+
+		removed, err := gc.store.deleteUnused(b.ref, b.lastAccess)
+		if err != nil {
+			return deleted, err
+		}
+		if !removed {
+			continue
+		}
+		total -= b.size
+		deleted = append(deleted, b.ref)
+	}
+
+	err = gc.store.saveAccessTimes()
+	return deleted, err
+}
+
+// list returns all stored blobs and their total size, from the index of the store
+func (gc *GC) list(ctx context.Context) ([]gcBlob, int64, error) {
+	var (
+		blobs []gcBlob
+		total int64
+	)
+	src := gc.store.ListMeta()
+	for {
+		v, err := src.Next(ctx)
+		if luigi.IsEOS(err) {
+			break
+		} else if err != nil {
+			return nil, 0, errors.Wrap(err, "blobstore: failed to list blobs")
+		}
+		m, ok := v.(ssb.BlobMeta)
+		if !ok {
+			return nil, 0, errors.Errorf("blobstore: unexpected list entry %T", v)
+		}
+
+		blobs = append(blobs, gcBlob{
+			ref:        m.Ref,
+			size:       m.Size,
+			lastAccess: gc.store.lastAccess(m.Ref.Ref(), m.Added),
+		})
+		total += m.Size
+	}
+	return blobs, total, nil
+}
+
+func (store *blobStore) deleteUnused(ref *ssb.BlobRef, lastAccess time.Time) (bool, error) {
+	p, err := store.getPath(ref)
+	if err != nil {
+		return false, err
+	}
+	key := ref.Ref()
+
+	store.mu.Lock()
+	if store.reading[key] > 0 {
+		store.mu.Unlock()
+		return false, nil
+	}
+	if ts, has := store.accessed[key]; has && time.Unix(ts, 0).After(lastAccess) {
+		store.mu.Unlock()
+		return false, nil
+	}
+	err = store.remove(ref, p)
+	store.mu.Unlock()
+	if err == ErrNoSuchBlob {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	err = store.sink.Pour(context.TODO(), ssb.BlobStoreNotification{
+		Op:  ssb.BlobStoreOpRm,
+		Ref: ref,
+	})
+	return true, errors.Wrap(err, "blobstore: error in delete notification handlers")
+}
+
+// addedOnly collects the blobs of stores that don't track access, like s3
+type addedOnly struct {
+	ssb.BlobStore
+}
+
+func (ao addedOnly) lastAccess(_ string, added time.Time) time.Time { return added }
+
+func (ao addedOnly) deleteUnused(ref *ssb.BlobRef, _ time.Time) (bool, error) {
+	err := ao.Delete(ref)
+	if errors.Cause(err) == ErrNoSuchBlob {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (ao addedOnly) saveAccessTimes() error { return nil }
@@ -0,0 +1,205 @@
+// SPDX-License-Identifier: MIT
+
+package blobstore
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/luigi"
+
+	"go.cryptoscope.co/ssb"
+)
+
+func TestGC(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "blobgc")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	bs, err := New(dir)
+	r.NoError(err)
+	store := bs.(*blobStore)
+
+	var removed []string
+	done := bs.Changes().Register(luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error {
+		if err != nil {
+			return err
+		}
+		if n := v.(ssb.BlobStoreNotification); n.Op == ssb.BlobStoreOpRm {
+			removed = append(removed, n.Ref.Ref())
+		}
+		return nil
+	}))
+	defer done()
+
+	// three blobs of ten bytes, a is the least recently used
+	put := func(content string, age time.Duration) *ssb.BlobRef {
+		ref, err := bs.Put(strings.NewReader(content))
+		r.NoError(err)
+		store.mu.Lock()
+		store.accessed[ref.Ref()] = time.Now().Add(-age).Unix()
+		store.mu.Unlock()
+		return ref
+	}
+	a := put("aaaaaaaaaa", 3*time.Hour)
+	b := put("bbbbbbbbbb", 2*time.Hour)
+	c := put("cccccccccc", time.Hour)
+
+	gc, err := NewGC(bs,
+		GCWithMaxSize(15),
+		GCWithProtection(func() (func(*ssb.BlobRef) bool, error) {
+			return func(ref *ssb.BlobRef) bool { return ref.Equal(a) }, nil
+		}))
+	r.NoError(err)
+
+	// b is being served, which also makes it the most recently used
+	rd, err := bs.Get(b)
+	r.NoError(err)
+
+	deleted, err := gc.Collect(ctx)
+	r.NoError(err)
+	r.Len(deleted, 1)
+	r.True(deleted[0].Equal(c))
+	_, err = bs.Size(c)
+	r.Equal(ErrNoSuchBlob, err)
+
+	// still over the limit but a is protected and b in use
+	deleted, err = gc.Collect(ctx)
+	r.NoError(err)
+	r.Len(deleted, 0)
+
+	r.NoError(rd.(io.Closer).Close())
+	deleted, err = gc.Collect(ctx)
+	r.NoError(err)
+	r.Len(deleted, 1)
+	r.True(deleted[0].Equal(b))
+
+	_, err = bs.Size(a)
+	r.NoError(err)
+	r.Equal([]string{c.Ref(), b.Ref()}, removed)
+
+	// the access times survive a restart
+	bs2, err := New(dir)
+	r.NoError(err)
+	_, has := bs2.(*blobStore).accessed[a.Ref()]
+	r.True(has)
+	_, has = bs2.(*blobStore).accessed[b.Ref()]
+	r.False(has)
+}
+
+func TestGCMaxAge(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "blobgc")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	bs, err := New(dir)
+	r.NoError(err)
+	store := bs.(*blobStore)
+
+	old, err := bs.Put(strings.NewReader("old"))
+	r.NoError(err)
+	fresh, err := bs.Put(strings.NewReader("fresh"))
+	r.NoError(err)
+	store.mu.Lock()
+	store.accessed[old.Ref()] = time.Now().Add(-2 * time.Hour).Unix()
+	store.mu.Unlock()
+
+	_, err = NewGC(bs)
+	r.Error(err, "needs a limit")
+
+	gc, err := NewGC(bs, GCWithMaxAge(time.Hour))
+	r.NoError(err)
+
+	deleted, err := gc.Collect(ctx)
+	r.NoError(err)
+	r.Len(deleted, 1)
+	r.True(deleted[0].Equal(old))
+
+	_, err = bs.Size(fresh)
+	r.NoError(err)
+}
+
+// wrapped hides the type of the local store, like the ones that don't track access
+type wrapped struct {
+	*blobStore
+}
+
+func TestGCOtherStore(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "blobgc")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	bs, err := New(dir)
+	r.NoError(err)
+
+	a, err := bs.Put(strings.NewReader("aaaaaaaaaa"))
+	r.NoError(err)
+	b, err := bs.Put(strings.NewReader("bbbbbbbbbb"))
+	r.NoError(err)
+	store := bs.(*blobStore)
+	store.mu.Lock()
+	old := store.meta.blobs[a.Ref()]
+	old.added = time.Now().Add(-time.Hour).Unix()
+	store.meta.blobs[a.Ref()] = old
+	store.mu.Unlock()
+
+	gc, err := NewGC(wrapped{store}, GCWithMaxSize(15))
+	r.NoError(err)
+
+	// without access times the oldest one goes
+	deleted, err := gc.Collect(ctx)
+	r.NoError(err)
+	r.Len(deleted, 1)
+	r.True(deleted[0].Equal(a))
+
+	_, err = bs.Size(b)
+	r.NoError(err)
+}
+
+func TestSaveAccessTimesRetry(t *testing.T) {
+	r := require.New(t)
+
+	dir, err := ioutil.TempDir("", "blobaccess")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	bs, err := New(dir)
+	r.NoError(err)
+	store := bs.(*blobStore)
+
+	ref, err := bs.Put(strings.NewReader("accessed"))
+	r.NoError(err)
+
+	// a directory in the way makes the rename fail
+	r.NoError(os.MkdirAll(filepath.Join(store.accessPath(), "blocker"), 0700))
+	r.Error(store.saveAccessTimes())
+	store.mu.Lock()
+	r.True(store.accessedDirty, "a failed save is tried again")
+	store.mu.Unlock()
+
+	r.NoError(os.RemoveAll(store.accessPath()))
+	r.NoError(store.saveAccessTimes())
+	store.mu.Lock()
+	r.False(store.accessedDirty)
+	store.mu.Unlock()
+
+	accessed, err := loadAccessTimes(store.accessPath())
+	r.NoError(err)
+	r.Contains(accessed, ref.Ref())
+}
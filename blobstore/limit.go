@@ -0,0 +1,38 @@
+// SPDX-License-Identifier: MIT
+
+package blobstore
+
+import (
+	stderr "errors"
+	"io"
+)
+
+// ErrBlobTooBig is returned by readers made with LimitReader once they read more than they may
+var ErrBlobTooBig = stderr.New("blobstore: blob is bigger than the maximum size")
+
+// LimitReader returns a reader of r that fails with ErrBlobTooBig as soon as r has more than max bytes,
+// unlike io.LimitReader which silently cuts it off. Put and PutExpected remove what they stored so far on that error.
+func LimitReader(r io.Reader, max int64) io.Reader {
+	return &limitedReader{r: r, left: max}
+}
+
+type limitedReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if lr.left < 0 {
+		return 0, ErrBlobTooBig
+	}
+	// read one byte more than allowed to notice blobs that are too big
+	if int64(len(p)) > lr.left+1 {
+		p = p[:lr.left+1]
+	}
+	n, err := lr.r.Read(p)
+	lr.left -= int64(n)
+	if lr.left < 0 {
+		return n, ErrBlobTooBig
+	}
+	return n, err
+}
@@ -0,0 +1,242 @@
+// SPDX-License-Identifier: MIT
+
+package blobstore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/ssb"
+)
+
+// metaFile is the journal of the stored blobs, next to the sha256 directory.
+// Each line adds or removes a blob. It is compacted when the store is opened
+// and rebuilt from the blob files if it is missing or unreadable.
+const metaFile = "blobs.ndjson"
+
+type metaEntry struct {
+	Op    ssb.BlobStoreOp `json:"op"`
+	Ref   string          `json:"ref"`
+	Size  int64           `json:"size,omitempty"`
+	Added int64           `json:"added,omitempty"` // unix seconds
+}
+
+type blobMeta struct {
+	size  int64
+	added int64
+}
+
+// metaIndex knows size and time of addition of all stored blobs, so that listing them doesn't need to stat every file.
+// It is guarded by the mutex of the store.
+type metaIndex struct {
+	path  string
+	blobs map[string]blobMeta
+	total int64
+}
+
+func (store *blobStore) metaPath() string {
+	return filepath.Join(store.basePath, metaFile)
+}
+
+// loadMeta reads the journal of the store and compacts it, or rebuilds it if there is none
+func (store *blobStore) loadMeta() (*metaIndex, error) {
+	mi := &metaIndex{
+		path:  store.metaPath(),
+		blobs: make(map[string]blobMeta),
+	}
+
+	data, err := ioutil.ReadFile(mi.path)
+	if os.IsNotExist(err) {
+		return mi, store.rebuildMeta(mi)
+	} else if err != nil {
+		return nil, errors.Wrap(err, "blobstore: failed to read blob index")
+	}
+
+	var lines int
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		lines++
+		var e metaEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			// for instance a line that was cut off by a crash
+			level.Warn(store.info).Log("event", "broken blob index, rebuilding it", "err", err)
+			return mi, store.rebuildMeta(mi)
+		}
+		mi.apply(e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, errors.Wrap(err, "blobstore: failed to scan blob index")
+	}
+
+	if lines > len(mi.blobs) {
+		if err := mi.compact(); err != nil {
+			return nil, err
+		}
+	}
+	return mi, nil
+}
+
+// rebuildMeta fills mi from the blob files. The modification time of a file stands in for when it was added.
+func (store *blobStore) rebuildMeta(mi *metaIndex) error {
+	mi.blobs = make(map[string]blobMeta)
+	mi.total = 0
+
+	ctx := context.Background()
+	src := store.List()
+	for {
+		v, err := src.Next(ctx)
+		if luigi.IsEOS(err) {
+			break
+		} else if err != nil {
+			return errors.Wrap(err, "blobstore: failed to list blobs for the index")
+		}
+		ref := v.(*ssb.BlobRef)
+
+		p, err := store.getPath(ref)
+		if err != nil {
+			return err
+		}
+		fi, err := os.Stat(p)
+		if err != nil {
+			return errors.Wrap(err, "blobstore: failed to stat blob for the index")
+		}
+		mi.apply(metaEntry{
+			Op:    ssb.BlobStoreOpPut,
+			Ref:   ref.Ref(),
+			Size:  fi.Size(),
+			Added: fi.ModTime().Unix(),
+		})
+	}
+
+	if err := mi.compact(); err != nil {
+		// the index is complete in memory, we try to write it again on the next start
+		level.Warn(store.info).Log("event", "failed to write rebuilt blob index", "err", err)
+	}
+	return nil
+}
+
+func (mi *metaIndex) apply(e metaEntry) {
+	old, has := mi.blobs[e.Ref]
+	if has {
+		mi.total -= old.size
+	}
+	switch e.Op {
+	case ssb.BlobStoreOpPut:
+		if has {
+			// keep when it was first added
+			e.Added = old.added
+		}
+		mi.blobs[e.Ref] = blobMeta{size: e.Size, added: e.Added}
+		mi.total += e.Size
+	case ssb.BlobStoreOpRm:
+		delete(mi.blobs, e.Ref)
+	}
+}
+
+// add records a newly stored blob. Blobs that are stored already are left alone.
+func (mi *metaIndex) add(key string, size int64, added time.Time) error {
+	if _, has := mi.blobs[key]; has {
+		return nil
+	}
+	e := metaEntry{Op: ssb.BlobStoreOpPut, Ref: key, Size: size, Added: added.Unix()}
+	mi.apply(e)
+	return mi.append(e)
+}
+
+// remove records that a blob was deleted
+func (mi *metaIndex) remove(key string) error {
+	if _, has := mi.blobs[key]; !has {
+		return nil
+	}
+	e := metaEntry{Op: ssb.BlobStoreOpRm, Ref: key}
+	mi.apply(e)
+	return mi.append(e)
+}
+
+func (mi *metaIndex) append(e metaEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "blobstore: failed to encode blob index entry")
+	}
+	f, err := os.OpenFile(mi.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "blobstore: failed to open blob index")
+	}
+	_, err = f.Write(append(line, '\n'))
+	if err != nil {
+		f.Close()
+		return errors.Wrap(err, "blobstore: failed to write blob index")
+	}
+	return errors.Wrap(f.Close(), "blobstore: failed to close blob index")
+}
+
+// compact replaces the journal with one line per stored blob
+func (mi *metaIndex) compact() error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for key, m := range mi.blobs {
+		err := enc.Encode(metaEntry{Op: ssb.BlobStoreOpPut, Ref: key, Size: m.size, Added: m.added})
+		if err != nil {
+			return errors.Wrap(err, "blobstore: failed to encode blob index")
+		}
+	}
+
+	tmpPath := mi.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, buf.Bytes(), 0600); err != nil {
+		return errors.Wrap(err, "blobstore: failed to write blob index")
+	}
+	err := os.Rename(tmpPath, mi.path)
+	return errors.Wrap(err, "blobstore: failed to replace blob index")
+}
+
+// ListMeta returns a source of ssb.BlobMeta for all stored blobs, the oldest first
+func (store *blobStore) ListMeta() luigi.Source {
+	store.mu.Lock()
+	metas := make([]ssb.BlobMeta, 0, len(store.meta.blobs))
+	for key, m := range store.meta.blobs {
+		ref, err := parseBlobRef(key)
+		if err != nil {
+			continue
+		}
+		metas = append(metas, ssb.BlobMeta{
+			Ref:   ref,
+			Size:  m.size,
+			Added: time.Unix(m.added, 0),
+		})
+	}
+	store.mu.Unlock()
+
+	sort.Slice(metas, func(i, j int) bool {
+		if metas[i].Added.Equal(metas[j].Added) {
+			return metas[i].Ref.Ref() < metas[j].Ref.Ref()
+		}
+		return metas[i].Added.Before(metas[j].Added)
+	})
+
+	vals := make([]interface{}, len(metas))
+	for i := range metas {
+		vals[i] = metas[i]
+	}
+	return (*luigi.SliceSource)(&vals)
+}
+
+// Stats returns the number and total size of the stored blobs without looking at the files
+func (store *blobStore) Stats() (ssb.BlobStoreStats, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return ssb.BlobStoreStats{
+		Count: int64(len(store.meta.blobs)),
+		Size:  store.meta.total,
+	}, nil
+}
@@ -0,0 +1,105 @@
+// SPDX-License-Identifier: MIT
+
+package blobstore
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/luigi"
+
+	"go.cryptoscope.co/ssb"
+)
+
+func TestListMeta(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "blobmeta")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	bs, err := New(dir)
+	r.NoError(err)
+	r.Equal(ssb.BlobStoreStats{}, statsOf(r, bs))
+
+	start := time.Now().Add(-time.Second)
+	a, err := bs.Put(strings.NewReader("aaa"))
+	r.NoError(err)
+	b, err := bs.Put(strings.NewReader("bbbbb"))
+	r.NoError(err)
+	c, err := bs.Put(strings.NewReader("ccccccc"))
+	r.NoError(err)
+	// storing it again doesn't count twice
+	_, err = bs.Put(strings.NewReader("aaa"))
+	r.NoError(err)
+	r.Equal(ssb.BlobStoreStats{Count: 3, Size: 15}, statsOf(r, bs))
+
+	r.NoError(bs.Delete(b))
+	r.Equal(ssb.BlobStoreStats{Count: 2, Size: 10}, statsOf(r, bs))
+
+	listed := func(bs ssb.BlobStore) map[string]ssb.BlobMeta {
+		metas := make(map[string]ssb.BlobMeta)
+		src := bs.ListMeta()
+		for {
+			v, err := src.Next(ctx)
+			if luigi.IsEOS(err) {
+				break
+			}
+			r.NoError(err)
+			m := v.(ssb.BlobMeta)
+			metas[m.Ref.Ref()] = m
+		}
+		return metas
+	}
+	metas := listed(bs)
+	r.Len(metas, 2)
+	r.EqualValues(3, metas[a.Ref()].Size)
+	r.EqualValues(7, metas[c.Ref()].Size)
+	r.True(metas[a.Ref()].Added.After(start))
+
+	// the journal is read again on the next start
+	bs2, err := New(dir)
+	r.NoError(err)
+	r.Equal(ssb.BlobStoreStats{Count: 2, Size: 10}, statsOf(r, bs2))
+	r.Equal(metas, listed(bs2))
+
+	// it was compacted to one line per blob
+	data, err := ioutil.ReadFile(filepath.Join(dir, metaFile))
+	r.NoError(err)
+	r.Equal(2, strings.Count(string(data), "\n"))
+
+	// without it the store looks at the files
+	r.NoError(os.Remove(filepath.Join(dir, metaFile)))
+	bs3, err := New(dir)
+	r.NoError(err)
+	r.Equal(ssb.BlobStoreStats{Count: 2, Size: 10}, statsOf(r, bs3))
+	r.Len(listed(bs3), 2)
+	_, err = os.Stat(filepath.Join(dir, metaFile))
+	r.NoError(err, "rebuilt index wasn't written")
+
+	// same for a broken one
+	r.NoError(ioutil.WriteFile(filepath.Join(dir, metaFile), []byte("{\"op\":\"put\",\"ref\":"), 0600))
+	bs4, err := New(dir)
+	r.NoError(err)
+	r.Equal(ssb.BlobStoreStats{Count: 2, Size: 10}, statsOf(r, bs4))
+
+	// a blob that vanished is dropped when it is deleted
+	p, err := bs4.(*blobStore).getPath(c)
+	r.NoError(err)
+	r.NoError(os.Remove(p))
+	r.Equal(ErrNoSuchBlob, bs4.Delete(c))
+	r.Equal(ssb.BlobStoreStats{Count: 1, Size: 3}, statsOf(r, bs4))
+}
+
+func statsOf(r *require.Assertions, bs ssb.BlobStore) ssb.BlobStoreStats {
+	stats, err := bs.Stats()
+	r.NoError(err)
+	return stats
+}
@@ -0,0 +1,81 @@
+// SPDX-License-Identifier: MIT
+
+package blobstore
+
+import (
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// partialDir holds the beginnings of blobs whose fetch was interrupted, one <hash>.partial file per blob.
+// The size of the file is the offset at which the fetch is resumed.
+const partialDir = "partial"
+
+func (store *blobStore) partialPath(ref *ssb.BlobRef) (string, error) {
+	if err := ref.IsValid(); err != nil {
+		return "", errors.Wrap(err, "blobs: invalid reference")
+	}
+	return filepath.Join(store.basePath, partialDir, hex.EncodeToString(ref.Hash)+".partial"), nil
+}
+
+// openPartial opens the partial file of ref for appending and returns how many bytes it already has
+func (store *blobStore) openPartial(ref *ssb.BlobRef) (*os.File, int64, error) {
+	p, err := store.partialPath(ref)
+	if err != nil {
+		return nil, 0, err
+	}
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "blobstore: failed to open partial blob")
+	}
+	n, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		f.Close()
+		return nil, 0, errors.Wrap(err, "blobstore: failed to seek partial blob")
+	}
+	return f, n, nil
+}
+
+// resetPartial throws away what f has so far
+func resetPartial(f *os.File) error {
+	if err := f.Truncate(0); err != nil {
+		return errors.Wrap(err, "blobstore: failed to truncate partial blob")
+	}
+	_, err := f.Seek(0, io.SeekStart)
+	return errors.Wrap(err, "blobstore: failed to seek partial blob")
+}
+
+// finishPartial stores the contents of f from offset on as ref and removes the partial file.
+// If they don't hash to ref the partial file is removed as well and ErrHashMismatch is returned.
+func (store *blobStore) finishPartial(ref *ssb.BlobRef, f *os.File, offset int64) error {
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return errors.Wrap(err, "blobstore: failed to seek partial blob")
+	}
+	_, err := store.put(f, ref)
+	if err != nil && err != ErrHashMismatch {
+		return err
+	}
+	if rmErr := store.dropPartial(ref); rmErr != nil && err == nil {
+		err = rmErr
+	}
+	return err
+}
+
+// dropPartial removes the partial file of ref, if there is one
+func (store *blobStore) dropPartial(ref *ssb.BlobRef) error {
+	p, err := store.partialPath(ref)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(p)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "blobstore: failed to remove partial blob")
+	}
+	return nil
+}
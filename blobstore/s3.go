@@ -0,0 +1,472 @@
+// SPDX-License-Identifier: MIT
+
+package blobstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/ssb"
+)
+
+// DefaultS3CacheSize is how many bytes of recently used blobs the s3 backend keeps on disk
+const DefaultS3CacheSize = 256 * 1024 * 1024
+
+// DefaultS3Timeout bounds the requests of the s3 backend that don't stream a blob
+// and how long it waits for the response to the ones that do.
+const DefaultS3Timeout = 30 * time.Second
+
+// openS3 opens a blob store in a bucket of an S3 compatible object storage.
+// The options are:
+//
+//	endpoint    URL of the API, like https://s3.eu-west-1.amazonaws.com or http://localhost:9000 for minio (required)
+//	bucket      name of the bucket, which has to exist (required)
+//	region      defaults to us-east-1
+//	access-key  defaults to $AWS_ACCESS_KEY_ID
+//	secret-key  defaults to $AWS_SECRET_ACCESS_KEY
+//	prefix      put in front of the object keys, for sharing a bucket
+//	cache-size  bytes of blobs kept in dir, DefaultS3CacheSize by default, 0 turns the cache off
+//	timeout     like 10s, DefaultS3Timeout by default
+//
+// Blobs are stored as <prefix>sha256/<hex of the hash>.
+func openS3(dir string, opts map[string]string) (ssb.BlobStore, error) {
+	get := func(key, def string) string {
+		if v, has := opts[key]; has {
+			return v
+		}
+		return def
+	}
+
+	endpoint, err := url.Parse(get("endpoint", ""))
+	if err != nil || endpoint.Host == "" {
+		return nil, errors.Errorf("s3: invalid or missing endpoint %q", get("endpoint", ""))
+	}
+	c := &s3Client{
+		endpoint:  endpoint,
+		bucket:    get("bucket", ""),
+		region:    get("region", "us-east-1"),
+		accessKey: get("access-key", os.Getenv("AWS_ACCESS_KEY_ID")),
+		secretKey: get("secret-key", os.Getenv("AWS_SECRET_ACCESS_KEY")),
+		now:       time.Now,
+	}
+	if c.bucket == "" {
+		return nil, errors.New("s3: missing bucket")
+	}
+
+	timeout, err := time.ParseDuration(get("timeout", DefaultS3Timeout.String()))
+	if err != nil || timeout <= 0 {
+		return nil, errors.Errorf("s3: invalid timeout %q", get("timeout", ""))
+	}
+	c.http = newS3HTTPClient(timeout)
+
+	cacheSize, err := strconv.ParseInt(get("cache-size", strconv.Itoa(DefaultS3CacheSize)), 10, 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "s3: invalid cache-size")
+	}
+
+	return newS3Store(c, get("prefix", ""), dir, cacheSize, timeout)
+}
+
+func newS3Store(c *s3Client, prefix, dir string, cacheSize int64, timeout time.Duration) (*s3Store, error) {
+	store := &s3Store{
+		client:  c,
+		prefix:  prefix + "sha256/",
+		tmpDir:  filepath.Join(dir, "tmp"),
+		timeout: timeout,
+	}
+	if err := os.MkdirAll(store.tmpDir, 0700); err != nil {
+		return nil, errors.Wrap(err, "s3: error making tmp dir")
+	}
+	if cacheSize > 0 {
+		var err error
+		store.cache, err = newBlobCache(filepath.Join(dir, "cache"), cacheSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+	store.sink, store.bcast = luigi.NewBroadcast()
+	return store, nil
+}
+
+// s3Store keeps the blobs in a bucket and the recently used ones in a local cache
+type s3Store struct {
+	client  *s3Client
+	prefix  string
+	tmpDir  string
+	timeout time.Duration
+
+	// nil if disabled
+	cache *blobCache
+
+	sink  luigi.Sink
+	bcast luigi.Broadcast
+}
+
+// requestCtx bounds a request that doesn't stream a blob.
+// The ssb.BlobStore methods don't take a context, the ones that do (like List) pass theirs through.
+func (store *s3Store) requestCtx() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), store.timeout)
+}
+
+func (store *s3Store) objectKey(ref *ssb.BlobRef) (string, error) {
+	if err := ref.IsValid(); err != nil {
+		return "", errors.Wrap(err, "blobs: invalid reference")
+	}
+	return store.prefix + hex.EncodeToString(ref.Hash), nil
+}
+
+// refOf returns the reference of an object key, nil if it isn't a blob
+func (store *s3Store) refOf(key string) *ssb.BlobRef {
+	h, err := hex.DecodeString(strings.TrimPrefix(key, store.prefix))
+	if err != nil || len(h) != sha256.Size {
+		return nil
+	}
+	return &ssb.BlobRef{Hash: h, Algo: ssb.RefAlgoBlobSSB1}
+}
+
+// Get streams the blob from the bucket and caches it once it was read completely
+func (store *s3Store) Get(ref *ssb.BlobRef) (io.Reader, error) {
+	key, err := store.objectKey(ref)
+	if err != nil {
+		return nil, err
+	}
+	cacheKey := hex.EncodeToString(ref.Hash)
+	if store.cache != nil {
+		if f := store.cache.open(cacheKey); f != nil {
+			return f, nil
+		}
+	}
+
+	// the body is read for as long as it takes, the client only bounds the wait for the response.
+	// Closing the reader or reading it to the end cancels the request.
+	ctx, cancel := context.WithCancel(context.Background())
+	resp, err := store.client.do(ctx, http.MethodGet, key, nil, nil, 0)
+	if err == errS3NotFound {
+		cancel()
+		return nil, ErrNoSuchBlob
+	} else if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	rd := &s3Reader{body: resp.Body, cancel: cancel}
+	if store.cache == nil {
+		return rd, nil
+	}
+	tmp, err := ioutil.TempFile(store.tmpDir, "get")
+	if err != nil {
+		// serving it is more important than caching it
+		return rd, nil
+	}
+	rd.tmp, rd.h, rd.done = tmp, sha256.New(), func(n int64, sum []byte) {
+		got := &ssb.BlobRef{Hash: sum, Algo: ssb.RefAlgoBlobSSB1}
+		if !got.Equal(ref) {
+			os.Remove(tmp.Name())
+			return
+		}
+		store.cache.add(cacheKey, tmp.Name(), n)
+	}
+	return rd, nil
+}
+
+// s3Reader closes the response once it was read completely, for readers that don't close it.
+// If tmp is set it also writes the blob into it and calls done with its size and hash at the end.
+type s3Reader struct {
+	body   io.ReadCloser
+	cancel context.CancelFunc
+
+	tmp  *os.File
+	h    hash.Hash
+	n    int64
+	done func(n int64, sum []byte)
+
+	once sync.Once
+}
+
+func (rd *s3Reader) Read(p []byte) (int, error) {
+	n, err := rd.body.Read(p)
+	if n > 0 && rd.tmp != nil {
+		rd.h.Write(p[:n])
+		rd.n += int64(n)
+		if _, werr := rd.tmp.Write(p[:n]); werr != nil {
+			rd.abort()
+		}
+	}
+	if err == io.EOF {
+		rd.finish(true)
+	}
+	return n, err
+}
+
+func (rd *s3Reader) Close() error {
+	rd.finish(false)
+	return nil
+}
+
+// abort stops caching
+func (rd *s3Reader) abort() {
+	rd.tmp.Close()
+	os.Remove(rd.tmp.Name())
+	rd.tmp = nil
+}
+
+func (rd *s3Reader) finish(complete bool) {
+	rd.once.Do(func() {
+		rd.body.Close()
+		rd.cancel()
+		if rd.tmp == nil {
+			return
+		}
+		if !complete || rd.tmp.Close() != nil {
+			os.Remove(rd.tmp.Name())
+			return
+		}
+		rd.done(rd.n, rd.h.Sum(nil))
+	})
+}
+
+func (store *s3Store) Put(blob io.Reader) (*ssb.BlobRef, error) {
+	return store.put(blob, nil)
+}
+
+// PutExpected only keeps the blob if it has the reference ref. Otherwise it returns ErrHashMismatch.
+func (store *s3Store) PutExpected(blob io.Reader, ref *ssb.BlobRef) error {
+	if err := ref.IsValid(); err != nil {
+		return errors.Wrap(err, "s3.PutExpected: invalid reference")
+	}
+	_, err := store.put(blob, ref)
+	return err
+}
+
+// put hashes the blob into a temporary file, since the name of the object depends on it, and uploads it from there.
+// The uploaded blob is kept in the cache.
+func (store *s3Store) put(blob io.Reader, expected *ssb.BlobRef) (*ssb.BlobRef, error) {
+	f, err := ioutil.TempFile(store.tmpDir, "put")
+	if err != nil {
+		return nil, errors.Wrap(err, "s3.Put: error creating tmp file")
+	}
+	tmpPath := f.Name()
+	cached := false
+	defer func() {
+		f.Close()
+		if !cached {
+			os.Remove(tmpPath)
+		}
+	}()
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), blob)
+	if err != nil && !luigi.IsEOS(err) {
+		return nil, errors.Wrap(err, "s3.Put: error copying")
+	}
+	ref := &ssb.BlobRef{Hash: h.Sum(nil), Algo: ssb.RefAlgoBlobSSB1}
+	if expected != nil && !ref.Equal(expected) {
+		return nil, ErrHashMismatch
+	}
+
+	key, err := store.objectKey(ref)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, errors.Wrap(err, "s3.Put: error rewinding tmp file")
+	}
+	// like Get, the upload takes as long as it takes
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	resp, err := store.client.do(ctx, http.MethodPut, key, nil, ioutil.NopCloser(f), n)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if store.cache != nil {
+		f.Close()
+		cached = true
+		if err := store.cache.add(hex.EncodeToString(ref.Hash), tmpPath, n); err != nil {
+			return ref, err
+		}
+	}
+
+	err = store.sink.Pour(context.TODO(), ssb.BlobStoreNotification{
+		Op:  ssb.BlobStoreOpPut,
+		Ref: ref,
+	})
+	return ref, errors.Wrap(err, "s3.Put: error in notification handler")
+}
+
+func (store *s3Store) Delete(ref *ssb.BlobRef) error {
+	key, err := store.objectKey(ref)
+	if err != nil {
+		return err
+	}
+	// deleting objects that don't exist isn't an error in S3
+	if _, err := store.Size(ref); err != nil {
+		return err
+	}
+	ctx, cancel := store.requestCtx()
+	defer cancel()
+	resp, err := store.client.do(ctx, http.MethodDelete, key, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if store.cache != nil {
+		store.cache.remove(hex.EncodeToString(ref.Hash))
+	}
+
+	err = store.sink.Pour(context.TODO(), ssb.BlobStoreNotification{
+		Op:  ssb.BlobStoreOpRm,
+		Ref: ref,
+	})
+	return errors.Wrap(err, "error in delete notification handlers")
+}
+
+func (store *s3Store) Size(ref *ssb.BlobRef) (int64, error) {
+	key, err := store.objectKey(ref)
+	if err != nil {
+		return 0, err
+	}
+	if store.cache != nil {
+		if sz, has := store.cache.sizeOf(hex.EncodeToString(ref.Hash)); has {
+			return sz, nil
+		}
+	}
+	ctx, cancel := store.requestCtx()
+	defer cancel()
+	resp, err := store.client.do(ctx, http.MethodHead, key, nil, nil, 0)
+	if err == errS3NotFound {
+		return 0, ErrNoSuchBlob
+	} else if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.ContentLength, nil
+}
+
+// List returns a source of the refs of all blobs in the bucket, fetching the listing page by page
+func (store *s3Store) List() luigi.Source {
+	return &s3ListSource{store: store}
+}
+
+type s3ListSource struct {
+	store *s3Store
+
+	l       sync.Mutex
+	started bool
+	token   string
+	objects []s3Object
+}
+
+func (src *s3ListSource) Next(ctx context.Context) (interface{}, error) {
+	src.l.Lock()
+	defer src.l.Unlock()
+
+	for {
+		for len(src.objects) == 0 {
+			if src.started && src.token == "" {
+				return nil, luigi.EOS{}
+			}
+			reqCtx, cancel := context.WithTimeout(ctx, src.store.timeout)
+			res, err := src.store.client.list(reqCtx, src.store.prefix, src.token)
+			cancel()
+			if err != nil {
+				return nil, errors.Wrap(err, "error listing blobs")
+			}
+			src.started = true
+			src.objects = res.Contents
+			src.token = ""
+			if res.IsTruncated {
+				src.token = res.NextContinuationToken
+			}
+		}
+
+		var obj s3Object
+		obj, src.objects = src.objects[0], src.objects[1:]
+		// skip objects that aren't blobs
+		if ref := src.store.refOf(obj.Key); ref != nil {
+			return ref, nil
+		}
+	}
+}
+
+// listObjects returns all blobs in the bucket, each page of the listing is bounded by the timeout
+func (store *s3Store) listObjects() ([]ssb.BlobMeta, error) {
+	var metas []ssb.BlobMeta
+	var token string
+	for {
+		ctx, cancel := store.requestCtx()
+		res, err := store.client.list(ctx, store.prefix, token)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range res.Contents {
+			if ref := store.refOf(obj.Key); ref != nil {
+				metas = append(metas, ssb.BlobMeta{Ref: ref, Size: obj.Size, Added: obj.LastModified})
+			}
+		}
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			return metas, nil
+		}
+		token = res.NextContinuationToken
+	}
+}
+
+// ListMeta returns a source of ssb.BlobMeta for all blobs in the bucket, the oldest first.
+// The time of the last upload of an object stands in for when it was added.
+func (store *s3Store) ListMeta() luigi.Source {
+	metas, err := store.listObjects()
+	if err != nil {
+		return errSource{err}
+	}
+	sort.Slice(metas, func(i, j int) bool {
+		if metas[i].Added.Equal(metas[j].Added) {
+			return metas[i].Ref.Ref() < metas[j].Ref.Ref()
+		}
+		return metas[i].Added.Before(metas[j].Added)
+	})
+	vals := make([]interface{}, len(metas))
+	for i := range metas {
+		vals[i] = metas[i]
+	}
+	return (*luigi.SliceSource)(&vals)
+}
+
+// Stats lists the bucket to count the blobs
+func (store *s3Store) Stats() (ssb.BlobStoreStats, error) {
+	var stats ssb.BlobStoreStats
+	metas, err := store.listObjects()
+	if err != nil {
+		return stats, errors.Wrap(err, "s3: failed to list blobs")
+	}
+	for _, m := range metas {
+		stats.Count++
+		stats.Size += m.Size
+	}
+	return stats, nil
+}
+
+type errSource struct{ err error }
+
+func (src errSource) Next(context.Context) (interface{}, error) { return nil, src.err }
+
+func (store *s3Store) Changes() luigi.Broadcast {
+	return store.bcast
+}
@@ -0,0 +1,233 @@
+// SPDX-License-Identifier: MIT
+
+package blobstore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// s3Client talks to an S3 compatible API with path style URLs and signature version 4.
+// Payloads aren't signed, so that blobs can be streamed.
+type s3Client struct {
+	endpoint  *url.URL
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+
+	http *http.Client
+	now  func() time.Time
+}
+
+// newS3HTTPClient returns a client that gives up on connections and responses that take longer than timeout.
+// It has no overall timeout, since bodies of big blobs can take longer than that to transfer.
+func newS3HTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   timeout,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSHandshakeTimeout:   timeout,
+			ResponseHeaderTimeout: timeout,
+			ExpectContinueTimeout: time.Second,
+			IdleConnTimeout:       90 * time.Second,
+			MaxIdleConns:          16,
+		},
+	}
+}
+
+// errS3NotFound is returned for objects that don't exist
+var errS3NotFound = errors.New("blobstore/s3: no such object")
+
+// s3Object is an entry of a bucket listing
+type s3Object struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+type s3ListResult struct {
+	Contents              []s3Object `xml:"Contents"`
+	IsTruncated           bool       `xml:"IsTruncated"`
+	NextContinuationToken string     `xml:"NextContinuationToken"`
+}
+
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// do sends a signed request for key, the bucket itself if key is empty.
+// Responses that aren't 2xx are turned into errors and their body is closed.
+func (c *s3Client) do(ctx context.Context, method, key string, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	u := *c.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + c.bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawQuery = s3CanonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, errors.Wrap(err, "blobstore/s3: failed to make request")
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.ContentLength = size
+		if size == 0 {
+			req.Body = http.NoBody
+		}
+	}
+	c.sign(req)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "blobstore/s3: %s %s failed", method, key)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errS3NotFound
+	}
+	var s3err s3Error
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if xml.Unmarshal(data, &s3err) == nil && s3err.Code != "" {
+		return nil, errors.Errorf("blobstore/s3: %s %s: %s (%s)", method, key, s3err.Code, s3err.Message)
+	}
+	return nil, errors.Errorf("blobstore/s3: %s %s: %s", method, key, resp.Status)
+}
+
+// list returns one page of the objects whose key starts with prefix, continuing after the token of the last one
+func (c *s3Client) list(ctx context.Context, prefix, token string) (*s3ListResult, error) {
+	q := url.Values{}
+	q.Set("list-type", "2")
+	q.Set("prefix", prefix)
+	if token != "" {
+		q.Set("continuation-token", token)
+	}
+	resp, err := c.do(ctx, http.MethodGet, "", q, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var res s3ListResult
+	if err := xml.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, errors.Wrap(err, "blobstore/s3: failed to decode bucket listing")
+	}
+	return &res, nil
+}
+
+const s3Unsigned = "UNSIGNED-PAYLOAD"
+
+// sign adds the headers of signature version 4. The payload is unsigned unless x-amz-content-sha256 is set already.
+// Besides host it signs range and the x-amz- headers.
+func (c *s3Client) sign(req *http.Request) {
+	now := c.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("x-amz-date", amzDate)
+	payload := req.Header.Get("x-amz-content-sha256")
+	if payload == "" {
+		payload = s3Unsigned
+		req.Header.Set("x-amz-content-sha256", payload)
+	}
+
+	signed := []string{"host"}
+	for h := range req.Header {
+		h = strings.ToLower(h)
+		if h == "range" || strings.HasPrefix(h, "x-amz-") {
+			signed = append(signed, h)
+		}
+	}
+	sort.Strings(signed)
+	var canonHeaders strings.Builder
+	for _, h := range signed {
+		v := req.Header.Get(h)
+		if h == "host" {
+			v = req.URL.Host
+		}
+		fmt.Fprintf(&canonHeaders, "%s:%s\n", h, strings.TrimSpace(v))
+	}
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonReq := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonHeaders.String(),
+		strings.Join(signed, ";"),
+		payload,
+	}, "\n")
+	reqHash := sha256.Sum256([]byte(canonReq))
+
+	scope := day + "/" + c.region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(reqHash[:])
+
+	key := s3HMAC([]byte("AWS4"+c.secretKey), day)
+	key = s3HMAC(key, c.region)
+	key = s3HMAC(key, "s3")
+	key = s3HMAC(key, "aws4_request")
+	sig := hex.EncodeToString(s3HMAC(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s,SignedHeaders=%s,Signature=%s",
+		c.accessKey, scope, strings.Join(signed, ";"), sig))
+}
+
+func s3HMAC(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3CanonicalQuery sorts the query by key and escapes it the way signature version 4 expects,
+// which isn't quite what url.Values.Encode does
+func s3CanonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range q[k] {
+			parts = append(parts, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -9,11 +9,14 @@ import (
 	stderr "errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
+	kitlog "github.com/go-kit/kit/log"
 	"github.com/pkg/errors"
 
 	"go.cryptoscope.co/luigi"
@@ -22,6 +25,9 @@ import (
 
 var (
 	ErrNoSuchBlob = stderr.New("no such blob")
+
+	// ErrHashMismatch is returned by PutExpected if the data doesn't have the expected reference
+	ErrHashMismatch = stderr.New("blobstore: data doesn't match the expected reference")
 )
 
 func parseBlobRef(refStr string) (*ssb.BlobRef, error) {
@@ -39,7 +45,7 @@ func parseBlobRef(refStr string) (*ssb.BlobRef, error) {
 	return br, nil
 }
 
-func New(basePath string) (ssb.BlobStore, error) {
+func New(basePath string, opts ...Option) (ssb.BlobStore, error) {
 	err := os.MkdirAll(filepath.Join(basePath, "sha256"), 0700)
 	if err != nil {
 		return nil, errors.Wrap(err, "error making dir for hash sha256")
@@ -50,8 +56,36 @@ func New(basePath string) (ssb.BlobStore, error) {
 		return nil, errors.Wrap(err, "error making tmp dir")
 	}
 
+	err = os.MkdirAll(filepath.Join(basePath, partialDir), 0700)
+	if err != nil {
+		return nil, errors.Wrap(err, "error making partial dir")
+	}
+
+	err = os.MkdirAll(filepath.Join(basePath, quarantineDir), 0700)
+	if err != nil {
+		return nil, errors.Wrap(err, "error making quarantine dir")
+	}
+
 	bs := &blobStore{
 		basePath: basePath,
+		reading:  make(map[string]int),
+		info:     kitlog.NewNopLogger(),
+	}
+
+	for i, o := range opts {
+		if err := o(bs); err != nil {
+			return nil, errors.Wrapf(err, "blobstore: invalid option #%d", i)
+		}
+	}
+
+	bs.accessed, err = loadAccessTimes(bs.accessPath())
+	if err != nil {
+		return nil, errors.Wrap(err, "error loading blob access times")
+	}
+
+	bs.meta, err = bs.loadMeta()
+	if err != nil {
+		return nil, errors.Wrap(err, "error loading blob index")
 	}
 
 	bs.sink, bs.bcast = luigi.NewBroadcast()
@@ -59,11 +93,33 @@ func New(basePath string) (ssb.BlobStore, error) {
 	return bs, nil
 }
 
+// WithLogger sets where the store reports the problems it works around, like a broken blob index
+func WithLogger(l kitlog.Logger) Option {
+	return func(store *blobStore) error {
+		store.info = l
+		return nil
+	}
+}
+
 type blobStore struct {
 	basePath string
+	info     kitlog.Logger
+
+	// hash blobs while they are read, see VerifyOnRead
+	verify bool
 
 	sink  luigi.Sink
 	bcast luigi.Broadcast
+
+	// mu guards the fields below and makes opening a blob and removing it by the GC exclusive
+	mu sync.Mutex
+	// last access of each blob (unix seconds), keyed by ref
+	accessed      map[string]int64
+	accessedDirty bool
+	// number of open readers of each blob
+	reading map[string]int
+	// size and time of addition of each blob
+	meta *metaIndex
 }
 
 func (store *blobStore) getPath(ref *ssb.BlobRef) (string, error) {
@@ -98,6 +154,9 @@ func (store *blobStore) Get(ref *ssb.BlobRef) (io.Reader, error) {
 		return nil, errors.Wrapf(err, "error getting path for ref %q", ref)
 	}
 
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
 	f, err := os.Open(blobPath)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -106,15 +165,46 @@ func (store *blobStore) Get(ref *ssb.BlobRef) (io.Reader, error) {
 		return nil, errors.Wrap(err, "error opening blob file")
 	}
 
-	return f, nil
+	key := ref.Ref()
+	store.touch(key)
+	store.reading[key]++
+	sb := &servedBlob{f: f, store: store, key: key}
+	if store.verify {
+		sb.ref = ref
+		sb.hash = sha256.New()
+	}
+	return sb, nil
 }
 
 func (store *blobStore) Put(blob io.Reader) (*ssb.BlobRef, error) {
-	tmpPath := store.getTmpPath()
-	f, err := os.Create(tmpPath)
+	return store.put(blob, nil)
+}
+
+// PutExpected only keeps the blob if it has the reference ref. Otherwise it returns ErrHashMismatch.
+func (store *blobStore) PutExpected(blob io.Reader, ref *ssb.BlobRef) error {
+	if err := ref.IsValid(); err != nil {
+		return errors.Wrap(err, "blobstore.PutExpected: invalid reference")
+	}
+	_, err := store.put(blob, ref)
+	return err
+}
+
+// put streams blob into a temporary file while hashing it and moves it to its place once it's complete.
+// If reading fails, which includes readers whose context was canceled, or the hash doesn't match expected,
+// the temporary file is removed.
+func (store *blobStore) put(blob io.Reader, expected *ssb.BlobRef) (*ssb.BlobRef, error) {
+	f, err := ioutil.TempFile(filepath.Join(store.basePath, "tmp"), "put")
 	if err != nil {
-		return nil, errors.Wrapf(err, "blobstore.Put: error creating tmp file at %q", tmpPath)
+		return nil, errors.Wrap(err, "blobstore.Put: error creating tmp file")
 	}
+	tmpPath := f.Name()
+	moved := false
+	defer func() {
+		if !moved {
+			f.Close()
+			os.Remove(tmpPath)
+		}
+	}()
 
 	h := sha256.New()
 	n, err := io.Copy(io.MultiWriter(f, h), blob)
@@ -131,6 +221,10 @@ func (store *blobStore) Put(blob io.Reader) (*ssb.BlobRef, error) {
 		return nil, errors.Wrap(err, "blobstore.Put: error closing tmp file")
 	}
 
+	if expected != nil && !ref.Equal(expected) {
+		return nil, ErrHashMismatch
+	}
+
 	hexDirPath, err := store.getHexDirPath(ref)
 	if err != nil {
 		return nil, errors.Wrap(err, "blobstore.Put: error getting hex dir path")
@@ -160,6 +254,15 @@ func (store *blobStore) Put(blob io.Reader) (*ssb.BlobRef, error) {
 		}
 		return nil, errors.Wrapf(err, "error moving blob from temp path %q to final path %q", tmpPath, finalPath)
 	}
+	moved = true
+
+	store.mu.Lock()
+	store.touch(ref.Ref())
+	err = store.meta.add(ref.Ref(), n, time.Now())
+	store.mu.Unlock()
+	if err != nil {
+		return ref, errors.Wrap(err, "blobstore.Put: error updating index")
+	}
 
 	err = store.sink.Pour(context.TODO(), ssb.BlobStoreNotification{
 		Op:  ssb.BlobStoreOpPut,
@@ -175,12 +278,11 @@ func (store *blobStore) Delete(ref *ssb.BlobRef) error {
 		return errors.Wrap(err, "error getting blob path")
 	}
 
-	err = os.Remove(p)
+	store.mu.Lock()
+	err = store.remove(ref, p)
+	store.mu.Unlock()
 	if err != nil {
-		if os.IsNotExist(err) {
-			return ErrNoSuchBlob
-		}
-		return errors.Wrap(err, "error removing file")
+		return err
 	}
 
 	err = store.sink.Pour(context.TODO(), ssb.BlobStoreNotification{
@@ -191,6 +293,24 @@ func (store *blobStore) Delete(ref *ssb.BlobRef) error {
 	return errors.Wrap(err, "error in delete notification handlers")
 }
 
+// remove deletes the file of ref and forgets its access time and index entry. mu needs to be held.
+func (store *blobStore) remove(ref *ssb.BlobRef, p string) error {
+	err := os.Remove(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// it might have been removed behind our back
+			if err := store.meta.remove(ref.Ref()); err != nil {
+				return err
+			}
+			return ErrNoSuchBlob
+		}
+		return errors.Wrap(err, "error removing file")
+	}
+	delete(store.accessed, ref.Ref())
+	store.accessedDirty = true
+	return store.meta.remove(ref.Ref())
+}
+
 func (store *blobStore) List() luigi.Source {
 	return &listSource{
 		basePath: filepath.Join(store.basePath, "sha256"),
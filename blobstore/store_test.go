@@ -5,10 +5,13 @@ package blobstore
 import (
 	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -187,3 +190,124 @@ func TestStore(t *testing.T) {
 		t.Run(fmt.Sprint(i), mkTest(tc))
 	}
 }
+
+func TestPutExpected(t *testing.T) {
+	r := require.New(t)
+
+	dir, err := ioutil.TempDir("", "putexpected")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	bs, err := New(dir)
+	r.NoError(err)
+
+	tmpEmpty := func() {
+		entries, err := ioutil.ReadDir(filepath.Join(dir, "tmp"))
+		r.NoError(err)
+		r.Len(entries, 0, "temporary files left over")
+	}
+
+	omg, err := parseBlobRef("&ZR3jMW+ifnTWqd5hnrrGjjt4HpUn/dAMXvcUOx+lgbY=.sha256")
+	r.NoError(err)
+
+	// something else than we asked for
+	err = bs.PutExpected(strings.NewReader("wat"), omg)
+	r.Equal(ErrHashMismatch, err)
+	_, err = bs.Size(omg)
+	r.Equal(ErrNoSuchBlob, err)
+	tmpEmpty()
+
+	// the reader breaks off
+	err = bs.PutExpected(io.MultiReader(strings.NewReader("om"), iotest.TimeoutReader(strings.NewReader("g"))), omg)
+	r.Error(err)
+	tmpEmpty()
+
+	r.NoError(bs.PutExpected(strings.NewReader("omg"), omg))
+	sz, err := bs.Size(omg)
+	r.NoError(err)
+	r.EqualValues(3, sz)
+	tmpEmpty()
+}
+
+func TestVerifyOnRead(t *testing.T) {
+	r := require.New(t)
+
+	dir, err := ioutil.TempDir("", "verify")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	bs, err := New(dir, VerifyOnRead(true))
+	r.NoError(err)
+	store := bs.(*blobStore)
+	wm := NewWantManager(bs)
+
+	var notes []ssb.BlobStoreNotification
+	bs.Changes().Register(luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error {
+		if err == nil {
+			notes = append(notes, v.(ssb.BlobStoreNotification))
+		}
+		return nil
+	}))
+
+	// bit rot on disk
+	rot := func(content string) *ssb.BlobRef {
+		ref, err := bs.Put(strings.NewReader(content))
+		r.NoError(err)
+		p, err := store.getPath(ref)
+		r.NoError(err)
+		r.NoError(ioutil.WriteFile(p, []byte(strings.ToUpper(content)), 0600))
+		return ref
+	}
+	quarantined := func() int {
+		fis, err := ioutil.ReadDir(filepath.Join(dir, quarantineDir))
+		r.NoError(err)
+		return len(fis)
+	}
+
+	good, err := bs.Put(strings.NewReader("fine"))
+	r.NoError(err)
+	rd, err := bs.Get(good)
+	r.NoError(err)
+	data, err := ioutil.ReadAll(rd)
+	r.NoError(err)
+	r.Equal("fine", string(data))
+
+	bad := rot("omg")
+	rd, err = bs.Get(bad)
+	r.NoError(err)
+	_, err = ioutil.ReadAll(rd)
+	r.Equal(ErrCorruptBlob, err)
+
+	_, err = bs.Size(bad)
+	r.Equal(ErrNoSuchBlob, err)
+	r.Equal(1, quarantined())
+	last := notes[len(notes)-1]
+	r.Equal(ssb.BlobStoreOpRm, last.Op)
+	r.True(last.Corrupt)
+	r.True(last.Ref.Equal(bad))
+	r.True(wm.Wants(bad), "not wanted again")
+
+	// seeking past the start skips the check
+	skipped := rot("wat")
+	rd, err = bs.Get(skipped)
+	r.NoError(err)
+	_, err = rd.(io.Seeker).Seek(1, io.SeekStart)
+	r.NoError(err)
+	data, err = ioutil.ReadAll(rd)
+	r.NoError(err)
+	r.Equal("AT", string(data))
+
+	// fsck finds it anyway
+	var progress []int
+	corrupt, err := store.Fsck(context.Background(), func(checked, total int) {
+		r.Equal(2, total)
+		progress = append(progress, checked)
+	})
+	r.NoError(err)
+	r.Len(corrupt, 1)
+	r.True(corrupt[0].Equal(skipped))
+	r.Equal([]int{1, 2}, progress)
+	r.Equal(2, quarantined())
+	_, err = bs.Size(good)
+	r.NoError(err)
+}
@@ -0,0 +1,152 @@
+// SPDX-License-Identifier: MIT
+
+package blobstore
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/hex"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/ssb"
+)
+
+// tarName is the name of the entry of ref in exported archives, the ref itself is also in the SSB.ref PAX record.
+// It is the same layout as in the directory of the fs backend, so that an archive can also be unpacked into it.
+func tarName(ref *ssb.BlobRef) string {
+	hexHash := hex.EncodeToString(ref.Hash)
+	return path.Join(ref.Algo, hexHash[:2], hexHash[2:])
+}
+
+// parseTarName reads the ref back from an entry name. Entries named like a ref (&...sha256) are understood, too.
+func parseTarName(name string) (*ssb.BlobRef, error) {
+	if strings.HasPrefix(name, "&") {
+		return ssb.ParseBlobRef(name)
+	}
+	parts := strings.Split(strings.TrimPrefix(path.Clean(name), "./"), "/")
+	if len(parts) != 3 || parts[0] != ssb.RefAlgoBlobSSB1 {
+		return nil, errors.Errorf("not a blob entry")
+	}
+	hash, err := hex.DecodeString(parts[1] + parts[2])
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid hash in name")
+	}
+	ref := &ssb.BlobRef{Hash: hash, Algo: ssb.RefAlgoBlobSSB1}
+	if err := ref.IsValid(); err != nil {
+		return nil, err
+	}
+	return ref, nil
+}
+
+// ExportTar writes the blobs of bs for which filter returns true, all if it is nil, to w as a tar archive.
+// It returns how many blobs were written.
+func ExportTar(ctx context.Context, bs ssb.BlobStore, w io.Writer, filter func(*ssb.BlobRef) bool) (int, error) {
+	tw := tar.NewWriter(w)
+	src := bs.ListMeta()
+	var n int
+	for {
+		v, err := src.Next(ctx)
+		if luigi.IsEOS(err) {
+			break
+		} else if err != nil {
+			return n, errors.Wrap(err, "blobstore.ExportTar: failed to list blobs")
+		}
+		meta, ok := v.(ssb.BlobMeta)
+		if !ok {
+			return n, errors.Errorf("blobstore.ExportTar: unexpected list entry %T", v)
+		}
+		if filter != nil && !filter(meta.Ref) {
+			continue
+		}
+
+		rd, err := bs.Get(meta.Ref)
+		if err == ErrNoSuchBlob {
+			// removed since it was listed
+			continue
+		} else if err != nil {
+			return n, errors.Wrapf(err, "blobstore.ExportTar: failed to open %s", meta.Ref.Ref())
+		}
+		err = tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     tarName(meta.Ref),
+			Mode:     0600,
+			Size:     meta.Size,
+			ModTime:  meta.Added,
+			PAXRecords: map[string]string{
+				"SSB.ref": meta.Ref.Ref(),
+			},
+		})
+		if err == nil {
+			_, err = io.Copy(tw, rd)
+		}
+		if c, ok := rd.(io.Closer); ok {
+			c.Close()
+		}
+		if err != nil {
+			return n, errors.Wrapf(err, "blobstore.ExportTar: failed to write %s", meta.Ref.Ref())
+		}
+		n++
+	}
+	return n, errors.Wrap(tw.Close(), "blobstore.ExportTar: failed to finish archive")
+}
+
+// TarImport counts what ImportTar did with the entries of an archive
+type TarImport struct {
+	// stored blobs and their total size
+	Imported int
+	Bytes    int64
+
+	// blobs that were there already
+	Skipped int
+
+	// entries that didn't match their name or didn't name a blob
+	Rejected []string
+}
+
+// ImportTar reads an archive made by ExportTar and puts its blobs into bs.
+// Every entry has to match the ref it is named after, others are rejected and listed in the result.
+func ImportTar(bs ssb.BlobStore, r io.Reader) (TarImport, error) {
+	var res TarImport
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return res, nil
+		} else if err != nil {
+			return res, errors.Wrap(err, "blobstore.ImportTar: failed to read archive")
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+
+		name := hdr.Name
+		if ref, has := hdr.PAXRecords["SSB.ref"]; has {
+			name = ref
+		}
+		ref, err := parseTarName(name)
+		if err != nil || hdr.Typeflag != tar.TypeReg {
+			res.Rejected = append(res.Rejected, hdr.Name)
+			continue
+		}
+
+		if _, err := bs.Size(ref); err == nil {
+			res.Skipped++
+			continue
+		}
+
+		err = bs.PutExpected(tr, ref)
+		if err == ErrHashMismatch {
+			res.Rejected = append(res.Rejected, hdr.Name)
+			continue
+		} else if err != nil {
+			return res, errors.Wrapf(err, "blobstore.ImportTar: failed to store %s", ref.Ref())
+		}
+		res.Imported++
+		res.Bytes += hdr.Size
+	}
+}
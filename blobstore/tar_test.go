@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: MIT
+
+package blobstore
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/ssb"
+)
+
+func TestTar(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "blobtar")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	src, err := New(filepath.Join(dir, "src"))
+	r.NoError(err)
+	dst, err := New(filepath.Join(dir, "dst"))
+	r.NoError(err)
+
+	var refs []*ssb.BlobRef
+	for _, s := range []string{"omg", "wat", "secret"} {
+		ref, err := src.Put(strings.NewReader(s))
+		r.NoError(err)
+		refs = append(refs, ref)
+	}
+	_, err = dst.Put(strings.NewReader("wat"))
+	r.NoError(err)
+
+	var archive bytes.Buffer
+	n, err := ExportTar(ctx, src, &archive, func(ref *ssb.BlobRef) bool {
+		return !ref.Equal(refs[2])
+	})
+	r.NoError(err)
+	r.Equal(2, n)
+
+	res, err := ImportTar(dst, &archive)
+	r.NoError(err)
+	r.Equal(1, res.Imported)
+	r.EqualValues(3, res.Bytes)
+	r.Equal(1, res.Skipped)
+	r.Empty(res.Rejected)
+
+	// an entry that lies about its content
+	var bad bytes.Buffer
+	tw := tar.NewWriter(&bad)
+	r.NoError(tw.WriteHeader(&tar.Header{Name: refs[2].Ref(), Mode: 0600, Size: 3}))
+	_, err = tw.Write([]byte("omg"))
+	r.NoError(err)
+	r.NoError(tw.Close())
+
+	res, err = ImportTar(dst, &bad)
+	r.NoError(err)
+	r.Equal(0, res.Imported)
+	r.Equal([]string{refs[2].Ref()}, res.Rejected)
+
+	rd, err := dst.Get(refs[0])
+	r.NoError(err)
+	data, err := ioutil.ReadAll(rd)
+	r.NoError(err)
+	r.Equal("omg", string(data))
+	_, err = dst.Size(refs[2])
+	r.Equal(ErrNoSuchBlob, err)
+	r.Equal(ssb.BlobStoreStats{Count: 2, Size: 6}, statsOf(r, dst))
+}
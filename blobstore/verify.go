@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: MIT
+
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	stderr "errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/ssb"
+)
+
+// ErrCorruptBlob is returned by readers of a blob store that verifies reads, once the data turned out to not match its reference.
+// The blob is moved to the quarantine directory by then.
+var ErrCorruptBlob = stderr.New("blobstore: blob doesn't match its reference")
+
+const quarantineDir = "quarantine"
+
+// Option changes how a blob store made by New works
+type Option func(*blobStore) error
+
+// VerifyOnRead makes readers returned by Get hash the blob while it is read.
+// If it doesn't match its reference at the end, the reader returns ErrCorruptBlob instead of io.EOF
+// and the blob is quarantined: it is moved out of the store, announced as removed and, through that, wanted again.
+// Readers that seek somewhere else than the start skip the check.
+func VerifyOnRead(yes bool) Option {
+	return func(store *blobStore) error {
+		store.verify = yes
+		return nil
+	}
+}
+
+// quarantine moves the file of a corrupt blob out of the way, so that it isn't served anymore but can still be looked at,
+// and emits a remove notification that is marked as corrupt.
+func (store *blobStore) quarantine(ref *ssb.BlobRef) error {
+	p, err := store.getPath(ref)
+	if err != nil {
+		return errors.Wrap(err, "blobstore: failed to get path for quarantine")
+	}
+	name := fmt.Sprintf("%s.%d", hex.EncodeToString(ref.Hash), time.Now().Unix())
+	qPath := filepath.Join(store.basePath, quarantineDir, name)
+
+	store.mu.Lock()
+	err = os.Rename(p, qPath)
+	if err == nil {
+		delete(store.accessed, ref.Ref())
+		store.accessedDirty = true
+		err = store.meta.remove(ref.Ref())
+	} else if os.IsNotExist(err) {
+		err = ErrNoSuchBlob
+	}
+	store.mu.Unlock()
+	if err != nil {
+		return errors.Wrapf(err, "blobstore: failed to quarantine %s", ref.Ref())
+	}
+
+	err = store.sink.Pour(context.TODO(), ssb.BlobStoreNotification{
+		Op:      ssb.BlobStoreOpRm,
+		Ref:     ref,
+		Corrupt: true,
+	})
+	return errors.Wrap(err, "blobstore: error in quarantine notification handlers")
+}
+
+// FsckProgress is called by Fsck after each blob with how many of all blobs are checked
+type FsckProgress func(checked, total int)
+
+// Checker is implemented by blob stores that can verify all their blobs
+type Checker interface {
+	// Fsck hashes all blobs, quarantines the ones that don't match their reference and returns them.
+	// It doesn't hold up reads or writes of the store while it runs.
+	Fsck(ctx context.Context, progress FsckProgress) ([]*ssb.BlobRef, error)
+}
+
+var _ Checker = (*blobStore)(nil)
+
+func (store *blobStore) Fsck(ctx context.Context, progress FsckProgress) ([]*ssb.BlobRef, error) {
+	var refs []*ssb.BlobRef
+	src := store.List()
+	for {
+		v, err := src.Next(ctx)
+		if luigi.IsEOS(err) {
+			break
+		} else if err != nil {
+			return nil, errors.Wrap(err, "blobstore.Fsck: failed to list blobs")
+		}
+		refs = append(refs, v.(*ssb.BlobRef))
+	}
+
+	var corrupt []*ssb.BlobRef
+	for i, ref := range refs {
+		if err := ctx.Err(); err != nil {
+			return corrupt, err
+		}
+
+		ok, err := store.check(ref)
+		if err != nil {
+			return corrupt, errors.Wrapf(err, "blobstore.Fsck: failed to check %s", ref.Ref())
+		}
+		if !ok {
+			if err := store.quarantine(ref); err != nil && errors.Cause(err) != ErrNoSuchBlob {
+				return corrupt, err
+			}
+			corrupt = append(corrupt, ref)
+		}
+
+		if progress != nil {
+			progress(i+1, len(refs))
+		}
+	}
+	return corrupt, nil
+}
+
+// check hashes the file of ref. Blobs that were removed in the meantime count as fine.
+func (store *blobStore) check(ref *ssb.BlobRef) (bool, error) {
+	p, err := store.getPath(ref)
+	if err != nil {
+		return false, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false, err
+	}
+	return bytes.Equal(h.Sum(nil), ref.Hash), nil
+}
@@ -2,9 +2,13 @@ package blobstore
 
 import (
 	"context"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/metrics"
+	"github.com/pkg/errors"
+
+	"go.cryptoscope.co/ssb"
 )
 
 type WantManagerOption func(*wantManager) error
@@ -39,3 +43,41 @@ func WantWithMetrics(g metrics.Gauge, ctr metrics.Counter) WantManagerOption {
 		return nil
 	}
 }
+
+// DefaultMaxWantHops is how far wants of other peers are passed on
+const DefaultMaxWantHops = 2
+
+// WantWithMaxHops sets how far wants travel. Our own wants are sent with distance -1,
+// wants of peers are passed on with one less, as long as that is not further than hops away.
+func WantWithMaxHops(hops int64) WantManagerOption {
+	return func(mgr *wantManager) error {
+		if hops < 1 {
+			return errors.Errorf("invalid max hops: %d", hops)
+		}
+		mgr.maxHops = hops
+		return nil
+	}
+}
+
+// DefaultWantExpiry is how long wants of other peers are kept without being renewed
+const DefaultWantExpiry = 10 * time.Minute
+
+// WantWithExpiry sets after which time wants of other peers are dropped, if they weren't sent again
+func WantWithExpiry(d time.Duration) WantManagerOption {
+	return func(mgr *wantManager) error {
+		if d <= 0 {
+			return errors.Errorf("invalid want expiry: %s", d)
+		}
+		mgr.expiry = d
+		return nil
+	}
+}
+
+// WantWithPushesFrom makes the manager fetch blobs it didn't want when a peer for which accept returns true announces them.
+// Blobs larger than the max size are still ignored.
+func WantWithPushesFrom(accept func(*ssb.FeedRef) bool) WantManagerOption {
+	return func(mgr *wantManager) error {
+		mgr.acceptPush = accept
+		return nil
+	}
+}
@@ -3,11 +3,14 @@
 package blobstore
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io"
 	"sync"
+	"time"
 
 	"github.com/cryptix/go/logging"
 	"github.com/go-kit/kit/log"
@@ -27,10 +30,15 @@ func NewWantManager(bs ssb.BlobStore, opts ...WantManagerOption) ssb.WantManager
 		bs:        bs,
 		info:      log.NewNopLogger(),
 		maxSize:   DefaultMaxSize,
+		maxHops:   DefaultMaxWantHops,
+		expiry:    DefaultWantExpiry,
 		longCtx:   context.Background(),
 		wants:     make(map[string]int64),
+		wantedAt:  make(map[string]time.Time),
 		blocked:   make(map[string]struct{}),
 		procs:     make(map[string]*wantProc),
+		noOffset:  make(map[string]struct{}),
+		servedBy:  make(map[string]*ssb.FeedRef),
 		available: make(chan *hasBlob),
 	}
 
@@ -55,18 +63,29 @@ func NewWantManager(bs ssb.BlobStore, opts ...WantManagerOption) ssb.WantManager
 			}
 			return err
 		}
-		wmgr.l.Lock()
-		defer wmgr.l.Unlock()
-
 		n, ok := v.(ssb.BlobStoreNotification)
 		if !ok {
 			return errors.Errorf("blob change: unhandled notification type: %T", v)
 		}
+
+		if n.Op == ssb.BlobStoreOpRm && n.Corrupt {
+			// get a good copy from our peers
+			wmgr.promEvent("corrupt", 1)
+			if err := wmgr.Want(n.Ref); err != nil {
+				level.Warn(wmgr.info).Log("event", "corrupt blob", "ref", n.Ref.Ref(), "err", err)
+			}
+			return nil
+		}
+
+		wmgr.l.Lock()
+		defer wmgr.l.Unlock()
+
 		wmgr.promEvent(n.Op.String(), 1)
 
 		if n.Op == ssb.BlobStoreOpPut {
 			if _, ok := wmgr.wants[n.Ref.Ref()]; ok {
 				delete(wmgr.wants, n.Ref.Ref())
+				delete(wmgr.wantedAt, n.Ref.Ref())
 
 				wmgr.promGaugeSet("nwants", len(wmgr.wants))
 			}
@@ -89,30 +108,84 @@ func NewWantManager(bs ssb.BlobStore, opts ...WantManagerOption) ssb.WantManager
 			// trying the one we got it from first
 			err := wmgr.getBlob(has.Proc.rootCtx, has.Proc.edp, has.Want.Ref)
 			if err == nil {
+				wmgr.served(has.Want.Ref, has.Proc.edp)
+				continue
+			}
+			if err == ErrBlobTooBig || has.pushed {
 				continue
 			}
 
 			wmgr.l.Lock()
-			// iterate through other open procs and try them
+			others := make([]*wantProc, 0, len(wmgr.procs))
 			for remote, proc := range wmgr.procs {
-				if remote == initialFrom {
-					continue
+				if remote != initialFrom {
+					others = append(others, proc)
 				}
+			}
+			wmgr.l.Unlock()
 
+			// iterate through other open procs and try them
+			for _, proc := range others {
 				err := wmgr.getBlob(proc.rootCtx, proc.edp, has.Want.Ref)
 				if err == nil {
+					wmgr.served(has.Want.Ref, proc.edp)
+					continue workChan
+				}
+				if err == ErrBlobTooBig {
 					continue workChan
 				}
 			}
+
+			wmgr.l.Lock()
 			delete(wmgr.wants, has.Want.Ref.Ref())
-			level.Warn(wmgr.info).Log("event", "blob retreive failed", "n", len(wmgr.procs))
+			delete(wmgr.wantedAt, has.Want.Ref.Ref())
+			level.Warn(wmgr.info).Log("event", "blob retreive failed", "n", len(others)+1)
 			wmgr.l.Unlock()
 		}
 	}()
 
+	go wmgr.expireLoop()
+
 	return wmgr
 }
 
+// expireLoop drops stale wants of other peers until the long context is done
+func (wmgr *wantManager) expireLoop() {
+	tick := time.NewTicker(wmgr.expiry / 2)
+	defer tick.Stop()
+	for {
+		select {
+		case <-wmgr.longCtx.Done():
+			return
+		case now := <-tick.C:
+			wmgr.expire(now)
+		}
+	}
+}
+
+// expire forgets the wants we passed on for other peers and the wants of peers that are older than the expiry.
+// Our own wants stay until we got the blob.
+func (wmgr *wantManager) expire(now time.Time) {
+	wmgr.l.Lock()
+	defer wmgr.l.Unlock()
+
+	var dropped []string
+	for ref, at := range wmgr.wantedAt {
+		if now.Sub(at) > wmgr.expiry {
+			delete(wmgr.wants, ref)
+			delete(wmgr.wantedAt, ref)
+			dropped = append(dropped, ref)
+		}
+	}
+	for _, proc := range wmgr.procs {
+		proc.expire(now, wmgr.expiry, dropped)
+	}
+	if len(dropped) > 0 {
+		wmgr.promGaugeSet("nwants", len(wmgr.wants))
+		level.Debug(wmgr.info).Log("event", "wants expired", "n", len(dropped))
+	}
+}
+
 type wantManager struct {
 	luigi.Broadcast
 
@@ -122,16 +195,32 @@ type wantManager struct {
 
 	maxSize uint
 
+	// how far wants of peers are passed on and when they are dropped
+	maxHops int64
+	expiry  time.Duration
+
 	// blob references that couldn't be fetched multiple times
 	blocked map[string]struct{}
 
-	// our own set of wants
+	// our own set of wants and the ones we pass on for other peers
 	wants    map[string]int64
 	wantSink luigi.Sink
+	// when the passed on wants were last renewed
+	wantedAt map[string]time.Time
 
 	// the set of peers we interact with
 	procs map[string]*wantProc
 
+	// the peers we fetch announced blobs from without wanting them, none if nil
+	acceptPush func(*ssb.FeedRef) bool
+
+	// peers that ignored the offset of a resumed fetch
+	noOffset map[string]struct{}
+
+	// who the last fetched blobs came from, servedOrder is oldest first
+	servedBy    map[string]*ssb.FeedRef
+	servedOrder []string
+
 	available chan *hasBlob
 
 	l sync.Mutex
@@ -144,7 +233,11 @@ type wantManager struct {
 func (wmgr *wantManager) getBlob(ctx context.Context, edp muxrpc.Endpoint, ref *ssb.BlobRef) error {
 	log := log.With(wmgr.info, "event", "blobs.get", "ref", ref.ShortRef())
 
-	arg := GetWithSize{ref, wmgr.maxSize}
+	if store, ok := wmgr.bs.(*blobStore); ok {
+		return wmgr.resumeBlob(ctx, log, edp, store, ref)
+	}
+
+	arg := GetWithSize{Key: ref, Max: wmgr.maxSize}
 	src, err := edp.Source(ctx, []byte{}, muxrpc.Method{"blobs", "get"}, arg)
 	if err != nil {
 		err = errors.Wrap(err, "blob create source failed")
@@ -153,28 +246,134 @@ func (wmgr *wantManager) getBlob(ctx context.Context, edp muxrpc.Endpoint, ref *
 	}
 
 	r := muxrpc.NewSourceReader(src)
-	r = io.LimitReader(r, int64(wmgr.maxSize))
-	newBr, err := wmgr.bs.Put(r)
-	if err != nil {
+	r = LimitReader(r, int64(wmgr.maxSize))
+	err = wmgr.bs.PutExpected(r, ref)
+	if err == ErrHashMismatch {
+		level.Warn(log).Log("msg", "discarded after missmatch", "want", ref.ShortRef())
+		return err
+	} else if errors.Cause(err) == ErrBlobTooBig {
+		return wmgr.tooBig(log, edp, ref)
+	} else if err != nil {
 		err = errors.Wrap(err, "blob data piping failed")
 		level.Warn(log).Log("err", err)
 		return err
 	}
+	sz, _ := wmgr.bs.Size(ref)
+	level.Info(log).Log("msg", "stored", "ref", ref.ShortRef(), "sz", sz)
+	return nil
+}
+
+// resumeBlob fetches ref into a partial file and continues where an earlier fetch of it stopped.
+// Peers that don't know the offset send the whole blob, which is noticed by hashing what they sent.
+// Those peers are asked for whole blobs from then on.
+func (wmgr *wantManager) resumeBlob(ctx context.Context, log log.Logger, edp muxrpc.Endpoint, store *blobStore, ref *ssb.BlobRef) error {
+	f, offset, err := store.openPartial(ref)
+	if err != nil {
+		level.Warn(log).Log("err", err)
+		return err
+	}
+	defer f.Close()
 
-	if !newBr.Equal(ref) {
-		// TODO: make this a type of error?
-		wmgr.bs.Delete(newBr)
-		level.Warn(log).Log("msg", "removed after missmatch", "want", ref.ShortRef())
-		return errors.New("blobs: inconsitency(or size limit)")
+	remote := edp.Remote().String()
+	wmgr.l.Lock()
+	_, noOffset := wmgr.noOffset[remote]
+	wmgr.l.Unlock()
+	if offset > 0 && (noOffset || uint(offset) >= wmgr.maxSize) {
+		offset = 0
+	}
+	if offset == 0 {
+		if err := resetPartial(f); err != nil {
+			level.Warn(log).Log("err", err)
+			return err
+		}
 	}
-	sz, _ := wmgr.bs.Size(newBr)
-	level.Info(log).Log("msg", "stored", "ref", ref.ShortRef(), "sz", sz)
+
+	arg := GetWithSize{Key: ref, Max: wmgr.maxSize, Offset: offset}
+	src, err := edp.Source(ctx, []byte{}, muxrpc.Method{"blobs", "get"}, arg)
+	if err != nil {
+		err = errors.Wrap(err, "blob create source failed")
+		level.Warn(log).Log("err", err)
+		return err
+	}
+
+	// hash what this peer sends to find out if it ignored the offset
+	fresh := sha256.New()
+	r := LimitReader(muxrpc.NewSourceReader(src), int64(wmgr.maxSize))
+	n, err := io.Copy(io.MultiWriter(f, fresh), r)
+	if err == ErrBlobTooBig {
+		store.dropPartial(ref)
+		return wmgr.tooBig(log, edp, ref)
+	} else if err != nil && !luigi.IsEOS(err) {
+		if offset > 0 && n == 0 {
+			// maybe the peer doesn't have the beginning we have
+			store.dropPartial(ref)
+		}
+		err = errors.Wrap(err, "blob data piping failed")
+		level.Warn(log).Log("err", err, "offset", offset, "received", n)
+		return err
+	}
+
+	ignoredOffset := offset > 0 && bytes.Equal(fresh.Sum(nil), ref.Hash)
+	if !ignoredOffset && uint(offset+n) > wmgr.maxSize {
+		store.dropPartial(ref)
+		return wmgr.tooBig(log, edp, ref)
+	}
+	if ignoredOffset {
+		wmgr.l.Lock()
+		wmgr.noOffset[remote] = struct{}{}
+		wmgr.l.Unlock()
+		level.Debug(log).Log("msg", "peer sent the whole blob, not resuming with it anymore", "remote", remote)
+		err = store.finishPartial(ref, f, offset)
+	} else {
+		err = store.finishPartial(ref, f, 0)
+	}
+	if err == ErrHashMismatch {
+		level.Warn(log).Log("msg", "discarded after missmatch (or size limit)", "want", ref.ShortRef())
+		return err
+	} else if err != nil {
+		err = errors.Wrap(err, "storing fetched blob failed")
+		level.Warn(log).Log("err", err)
+		return err
+	}
+	level.Info(log).Log("msg", "stored", "ref", ref.ShortRef(), "sz", offset+n, "resumed", offset)
 	return nil
 }
 
+// tooBig drops the want of a blob a peer sent more than the maximum size of.
+// It isn't blocked, since the peer could have sent garbage for a blob others have in the right size.
+func (wmgr *wantManager) tooBig(log log.Logger, edp muxrpc.Endpoint, ref *ssb.BlobRef) error {
+	peer := edp.Remote().String()
+	if fr, err := ssb.GetFeedRefFromAddr(edp.Remote()); err == nil {
+		peer = fr.Ref()
+	}
+	level.Warn(log).Log("msg", "discarded blob over the size limit", "want", ref.ShortRef(), "peer", peer, "max", wmgr.maxSize)
+
+	wmgr.l.Lock()
+	delete(wmgr.wants, ref.Ref())
+	delete(wmgr.wantedAt, ref.Ref())
+	wmgr.promGaugeSet("nwants", len(wmgr.wants))
+	wmgr.l.Unlock()
+	return ErrBlobTooBig
+}
+
 type hasBlob struct {
 	Want ssb.BlobWant
 	Proc *wantProc
+
+	// we didn't ask for it, only the peer that pushed it is asked for it
+	pushed bool
+}
+
+// acceptsPush returns true if we fetch blobs the peer announces without us wanting them
+func (proc *wantProc) acceptsPush() bool {
+	if proc.wmgr.acceptPush == nil {
+		return false
+	}
+	remote, err := ssb.GetFeedRefFromAddr(proc.edp.Remote())
+	if err != nil {
+		return false
+	}
+	return proc.wmgr.acceptPush(remote)
 }
 
 func (wmgr *wantManager) promEvent(name string, n float64) {
@@ -221,6 +420,40 @@ func (wmgr *wantManager) AllWants() []ssb.BlobWant {
 	return bws
 }
 
+// Announce sends the size of the blob to the peers we have a want stream with, like we do when they want it.
+// Peers that accept pushes fetch it right away, the others ignore it.
+func (wmgr *wantManager) Announce(ref *ssb.BlobRef, to func(*ssb.FeedRef) bool) (int, error) {
+	sz, err := wmgr.bs.Size(ref)
+	if err != nil {
+		return 0, errors.Wrap(err, "blobstore: can't announce blob")
+	}
+
+	wmgr.l.Lock()
+	procs := make([]*wantProc, 0, len(wmgr.procs))
+	for _, proc := range wmgr.procs {
+		procs = append(procs, proc)
+	}
+	wmgr.l.Unlock()
+
+	var told int
+	for _, proc := range procs {
+		remote, err := ssb.GetFeedRefFromAddr(proc.edp.Remote())
+		if err != nil {
+			continue
+		}
+		if to != nil && !to(remote) {
+			continue
+		}
+		if err := proc.announce(ref, sz); err != nil {
+			level.Debug(proc.info).Log("event", "announce failed", "ref", ref.ShortRef(), "err", err)
+			continue
+		}
+		told++
+	}
+	wmgr.promEvent("announced", float64(told))
+	return told, nil
+}
+
 func (wmgr *wantManager) Wants(ref *ssb.BlobRef) bool {
 	wmgr.l.Lock()
 	defer wmgr.l.Unlock()
@@ -255,6 +488,11 @@ func (wmgr *wantManager) WantWithDist(ref *ssb.BlobRef, dist int64) error {
 	}
 
 	wmgr.wants[ref.Ref()] = dist
+	if dist < -1 {
+		wmgr.wantedAt[ref.Ref()] = time.Now()
+	} else {
+		delete(wmgr.wantedAt, ref.Ref())
+	}
 	wmgr.promGaugeSet("nwants", len(wmgr.wants))
 
 	err = wmgr.wantSink.Pour(wmgr.longCtx, ssb.BlobWant{Ref: ref, Dist: dist})
@@ -262,6 +500,57 @@ func (wmgr *wantManager) WantWithDist(ref *ssb.BlobRef, dist int64) error {
 	return err
 }
 
+// maxServedBy is how many fetched blobs ServedBy remembers
+const maxServedBy = 256
+
+// served notes that ref was fetched from edp
+func (wmgr *wantManager) served(ref *ssb.BlobRef, edp muxrpc.Endpoint) {
+	from, err := ssb.GetFeedRefFromAddr(edp.Remote())
+	if err != nil {
+		return
+	}
+	wmgr.l.Lock()
+	defer wmgr.l.Unlock()
+	if _, has := wmgr.servedBy[ref.Ref()]; !has {
+		wmgr.servedOrder = append(wmgr.servedOrder, ref.Ref())
+	}
+	wmgr.servedBy[ref.Ref()] = from
+	if len(wmgr.servedOrder) > maxServedBy {
+		delete(wmgr.servedBy, wmgr.servedOrder[0])
+		wmgr.servedOrder = wmgr.servedOrder[1:]
+	}
+}
+
+// ServedBy returns the peer a recently fetched blob came from.
+// It only knows about blobs fetched by a want manager from NewWantManager.
+func ServedBy(wm ssb.WantManager, ref *ssb.BlobRef) (*ssb.FeedRef, bool) {
+	wmgr, ok := wm.(*wantManager)
+	if !ok {
+		return nil, false
+	}
+	wmgr.l.Lock()
+	defer wmgr.l.Unlock()
+	from, ok := wmgr.servedBy[ref.Ref()]
+	return from, ok
+}
+
+// Rewant sends our want of ref again to all connected peers, also to the ones that were already asked.
+// Peers drop wants after a while and the ones that had nothing to say might have gotten the blob by now.
+func Rewant(wm ssb.WantManager, ref *ssb.BlobRef) error {
+	wmgr, ok := wm.(*wantManager)
+	if !ok {
+		return wm.Want(ref)
+	}
+	wmgr.l.Lock()
+	for _, proc := range wmgr.procs {
+		proc.l.Lock()
+		delete(proc.sent, ref.Ref())
+		proc.l.Unlock()
+	}
+	wmgr.l.Unlock()
+	return wmgr.Want(ref)
+}
+
 func (wmgr *wantManager) CreateWants(ctx context.Context, sink luigi.Sink, edp muxrpc.Endpoint) luigi.Sink {
 	wmgr.l.Lock()
 	defer wmgr.l.Unlock()
@@ -278,9 +567,13 @@ func (wmgr *wantManager) CreateWants(ctx context.Context, sink luigi.Sink, edp m
 		bs:          wmgr.bs,
 		wmgr:        wmgr,
 		out:         sink,
-		remoteWants: make(map[string]int64),
+		remoteWants: make(map[string]remoteWant),
+		sent:        make(map[string]int64, len(wmgr.wants)),
 		edp:         edp,
 	}
+	for ref, dist := range wmgr.wants {
+		proc.sent[ref] = dist
+	}
 
 	var remote = "unknown"
 	if r, err := ssb.GetFeedRefFromAddr(proc.edp.Remote()); err == nil {
@@ -326,8 +619,41 @@ type wantProc struct {
 	done func(func())
 	edp  muxrpc.Endpoint
 
-	l           sync.Mutex
-	remoteWants map[string]int64
+	l sync.Mutex
+	// what the peer asked us for
+	remoteWants map[string]remoteWant
+	// the wants we sent to the peer, so that we don't repeat them
+	sent map[string]int64
+}
+
+type remoteWant struct {
+	dist int64
+	at   time.Time
+}
+
+// expire drops the wants of the peer older than expiry and forgets that we sent the dropped wants
+func (proc *wantProc) expire(now time.Time, expiry time.Duration, dropped []string) {
+	proc.l.Lock()
+	defer proc.l.Unlock()
+	for ref, rw := range proc.remoteWants {
+		if now.Sub(rw.at) > expiry {
+			delete(proc.remoteWants, ref)
+		}
+	}
+	for _, ref := range dropped {
+		delete(proc.sent, ref)
+	}
+}
+
+// announce sends a has for ref, unless the peer already got one
+func (proc *wantProc) announce(ref *ssb.BlobRef, sz int64) error {
+	proc.l.Lock()
+	defer proc.l.Unlock()
+	if sent, has := proc.sent[ref.Ref()]; has && sent == sz {
+		return nil
+	}
+	proc.sent[ref.Ref()] = sz
+	return proc.out.Pour(proc.rootCtx, map[string]int64{ref.Ref(): sz})
 }
 
 // updateFromBlobStore listens for adds and if they are wanted notifies the remote via it's sink
@@ -355,9 +681,14 @@ func (proc *wantProc) updateFromBlobStore(ctx context.Context, v interface{}, er
 
 	proc.wmgr.promEvent(notif.Op.String(), 1)
 
+	if notif.Op != ssb.BlobStoreOpPut {
+		return nil
+	}
+
 	if _, wants := proc.remoteWants[notif.Ref.Ref()]; !wants {
 		return nil
 	}
+	delete(proc.remoteWants, notif.Ref.Ref())
 
 	sz, err := proc.bs.Size(notif.Ref)
 	if err != nil {
@@ -396,25 +727,45 @@ func (proc *wantProc) updateWants(ctx context.Context, v interface{}, err error)
 	}
 
 	if w.Dist < 0 {
+		// don't send it back to where it came from
 		_, wants := proc.remoteWants[w.Ref.Ref()]
 		if wants {
 			return nil
 		}
 	}
 
+	if sentDist, sent := proc.sent[w.Ref.Ref()]; sent && sentDist >= w.Dist {
+		// they already know, at the same or a closer distance
+		return nil
+	}
+
 	if sz, err := proc.bs.Size(w.Ref); err == nil {
 		level.Info(proc.info).Log("local", "has size!", "sz", sz)
 		return nil
 	}
 
+	proc.sent[w.Ref.Ref()] = w.Dist
 	newW := WantMsg{w}
 	// dbg.Log("op", "sending want we now want", "wantCount", len(proc.wmgr.wants))
 	return proc.out.Pour(ctx, newW)
 }
 
+// GetWithSize are the arguments of blobs.get with a size limit.
+// Offset skips the start of the blob, to resume an interrupted fetch. Peers that don't know it send the whole blob.
+// Hash is accepted as another name for Key.
 type GetWithSize struct {
-	Key *ssb.BlobRef `json:"key"`
-	Max uint         `json:"max"`
+	Key    *ssb.BlobRef `json:"key"`
+	Max    uint         `json:"max"`
+	Offset int64        `json:"offset,omitempty"`
+	Hash   *ssb.BlobRef `json:"hash,omitempty"`
+}
+
+// GetSlice are the arguments of blobs.getSlice.
+// Start and End are byte offsets into the blob, End is exclusive and 0 means up to the end.
+type GetSlice struct {
+	Key   *ssb.BlobRef `json:"key"`
+	Start int64        `json:"start"`
+	End   int64        `json:"end,omitempty"`
 }
 
 func (proc *wantProc) Close() error {
@@ -436,19 +787,22 @@ func (proc *wantProc) Pour(ctx context.Context, v interface{}) error {
 		}
 
 		if w.Dist < 0 {
-			if w.Dist < -4 {
-				continue // ignore, too far off
-			}
+			// wants from too far off are still answered, they are only not forwarded (see below)
 			s, err := proc.bs.Size(w.Ref)
 			if err != nil {
 				if err == ErrNoSuchBlob {
 					proc.l.Lock()
-					proc.remoteWants[w.Ref.Ref()] = w.Dist
+					proc.remoteWants[w.Ref.Ref()] = remoteWant{dist: w.Dist, at: time.Now()}
 					proc.l.Unlock()
 
+					if w.Dist-1 < -proc.wmgr.maxHops {
+						// we tell them if we get it but don't ask further
+						continue
+					}
+
 					wErr := proc.wmgr.WantWithDist(w.Ref, w.Dist-1)
-					if wErr != nil {
-						return errors.Wrap(err, "forwarding want faild")
+					if wErr != nil && wErr != ErrBlobBlocked {
+						return errors.Wrap(wErr, "forwarding want faild")
 					}
 					continue
 				}
@@ -474,6 +828,16 @@ func (proc *wantProc) Pour(ctx context.Context, v interface{}) error {
 					Want: w,
 					Proc: proc,
 				}
+			} else if proc.acceptsPush() && uint(w.Dist) <= proc.wmgr.maxSize {
+				if _, err := proc.bs.Size(w.Ref); err == nil {
+					continue
+				}
+				dbg.Log("msg", "fetching pushed blob", "ref", w.Ref.ShortRef())
+				proc.wmgr.available <- &hasBlob{
+					Want:   w,
+					Proc:   proc,
+					pushed: true,
+				}
 			}
 		}
 	}
@@ -3,14 +3,18 @@
 package blobstore
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
@@ -19,6 +23,8 @@ import (
 	"go.cryptoscope.co/luigi"
 	"go.cryptoscope.co/muxrpc"
 	mmock "go.cryptoscope.co/muxrpc/mock"
+	"go.cryptoscope.co/netwrap"
+	"go.cryptoscope.co/secretstream"
 	"go.cryptoscope.co/ssb"
 	"go.cryptoscope.co/ssb/internal/testutils"
 )
@@ -93,7 +99,7 @@ func TestWantManager(t *testing.T) {
 			}()
 
 			log := testutils.NewRelativeTimeLogger(nil)
-			wmgr := NewWantManager(log, bs)
+			wmgr := NewWantManager(bs, WantWithLogger(log))
 
 			for _, str := range tc.localBlobs {
 				br, err := bs.Put(strings.NewReader(str))
@@ -215,3 +221,390 @@ func TestWantManager(t *testing.T) {
 		t.Run(fmt.Sprint(i), mkTest(tc))
 	}
 }
+
+// wantNode is a blob store with a want manager, like a peer in the network
+type wantNode struct {
+	bs ssb.BlobStore
+	wm *wantManager
+}
+
+func newWantNode(r *require.Assertions, opts ...WantManagerOption) (*wantNode, func()) {
+	dir, err := ioutil.TempDir("", "wantnode")
+	r.NoError(err)
+	bs, err := New(dir)
+	r.NoError(err)
+	wm := NewWantManager(bs, opts...).(*wantManager)
+	return &wantNode{bs: bs, wm: wm}, func() { os.RemoveAll(dir) }
+}
+
+// wire passes the messages of one side of blobs.createWants through JSON to the proc of the other side
+type wire chan interface{}
+
+func (w wire) Pour(ctx context.Context, v interface{}) error {
+	select {
+	case w <- v:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w wire) Close() error { return nil }
+
+func (w wire) forward(ctx context.Context, to luigi.Sink) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case v := <-w:
+			data, err := json.Marshal(v)
+			if err != nil {
+				panic(err)
+			}
+			var msg WantMsg
+			if err := json.Unmarshal(data, &msg); err != nil {
+				panic(err)
+			}
+			to.Pour(ctx, &msg)
+		}
+	}
+}
+
+// endpointOf lets blobs.get calls read from the store of n
+func endpointOf(n *wantNode, port int) muxrpc.Endpoint {
+	return &mmock.FakeEndpoint{
+		SourceStub: func(ctx context.Context, _ interface{}, method muxrpc.Method, args ...interface{}) (luigi.Source, error) {
+			arg, ok := args[0].(GetWithSize)
+			if !ok || method.String() != "blobs.get" {
+				return nil, errors.Errorf("unexpected call %s %v", method, args)
+			}
+			rd, err := n.bs.Get(arg.Key)
+			if err != nil {
+				return nil, err
+			}
+			data, err := ioutil.ReadAll(rd)
+			if err != nil {
+				return nil, err
+			}
+			return (*luigi.SliceSource)(&[]interface{}{data}), nil
+		},
+		RemoteStub: func() net.Addr {
+			return netwrap.WrapAddr(&net.TCPAddr{Port: port}, secretstream.Addr{PubKey: feedOf(port).ID})
+		},
+	}
+}
+
+// feedOf returns the identity of the peer on port
+func feedOf(port int) *ssb.FeedRef {
+	return &ssb.FeedRef{
+		ID:   bytes.Repeat([]byte{byte(port)}, 32),
+		Algo: ssb.RefAlgoFeedSSB1,
+	}
+}
+
+// connect makes a and b exchange wants like two connected peers
+func connect(ctx context.Context, a, b *wantNode, portA, portB int) {
+	toB, toA := make(wire, 64), make(wire, 64)
+	procA := a.wm.CreateWants(ctx, toB, endpointOf(b, portB))
+	procB := b.wm.CreateWants(ctx, toA, endpointOf(a, portA))
+	go toB.forward(ctx, procB)
+	go toA.forward(ctx, procA)
+}
+
+// remoteWanted returns true if a peer of n asked it for ref
+func remoteWanted(n *wantNode, ref *ssb.BlobRef) bool {
+	n.wm.l.Lock()
+	defer n.wm.l.Unlock()
+	for _, proc := range n.wm.procs {
+		proc.l.Lock()
+		_, has := proc.remoteWants[ref.Ref()]
+		proc.l.Unlock()
+		if has {
+			return true
+		}
+	}
+	return false
+}
+
+func TestWantChain(t *testing.T) {
+	r := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// alice - bob - claire, only claire has the blob
+	alice, done := newWantNode(r)
+	defer done()
+	bob, done := newWantNode(r)
+	defer done()
+	claire, done := newWantNode(r)
+	defer done()
+
+	connect(ctx, alice, bob, 1, 2)
+	connect(ctx, bob, claire, 2, 3)
+
+	ref, err := claire.bs.Put(strings.NewReader("from the edge"))
+	r.NoError(err)
+
+	r.NoError(alice.wm.Want(ref))
+
+	has := func(n *wantNode) func() bool {
+		return func() bool {
+			_, err := n.bs.Size(ref)
+			return err == nil
+		}
+	}
+	r.Eventually(has(alice), 5*time.Second, 10*time.Millisecond, "alice didn't get the blob")
+	r.True(has(bob)(), "bob fetched it on behalf of alice")
+	r.False(alice.wm.Wants(ref))
+	r.False(bob.wm.Wants(ref))
+}
+
+func TestWantHopLimit(t *testing.T) {
+	r := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// alice - bob - claire - dan, dan is too far away
+	alice, done := newWantNode(r)
+	defer done()
+	bob, done := newWantNode(r)
+	defer done()
+	claire, done := newWantNode(r)
+	defer done()
+	dan, done := newWantNode(r)
+	defer done()
+
+	connect(ctx, alice, bob, 1, 2)
+	connect(ctx, bob, claire, 2, 3)
+	connect(ctx, claire, dan, 3, 4)
+
+	ref, err := dan.bs.Put(strings.NewReader("out of reach"))
+	r.NoError(err)
+
+	r.NoError(alice.wm.Want(ref))
+	r.Eventually(func() bool { return remoteWanted(claire, ref) }, 5*time.Second, 10*time.Millisecond, "claire didn't get the want")
+
+	bob.wm.l.Lock()
+	r.EqualValues(-2, bob.wm.wants[ref.Ref()])
+	bob.wm.l.Unlock()
+	r.False(claire.wm.Wants(ref), "claire passed on a want that is two hops away")
+
+	time.Sleep(100 * time.Millisecond)
+	r.False(remoteWanted(dan, ref))
+	_, err = alice.bs.Size(ref)
+	r.Equal(ErrNoSuchBlob, err)
+
+	// a want from further away than we forward is still answered
+	near, err := bob.bs.Put(strings.NewReader("close by"))
+	r.NoError(err)
+	r.NoError(alice.wm.WantWithDist(near, -5))
+	r.Eventually(func() bool {
+		_, err := alice.bs.Size(near)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond, "bob didn't answer the far want")
+	r.False(claire.wm.Wants(near), "bob forwarded a want from too far off")
+}
+
+func TestWantExpiry(t *testing.T) {
+	r := require.New(t)
+
+	n, done := newWantNode(r, WantWithExpiry(time.Minute))
+	defer done()
+
+	own, err := ssb.ParseBlobRef("&ZR3jMW+ifnTWqd5hnrrGjjt4HpUn/dAMXvcUOx+lgbY=.sha256")
+	r.NoError(err)
+	proxied, err := ssb.ParseBlobRef("&8Ap4f3SSqV4WW0cHAvT+k3NYP73AJbLIvfAmLMSPz/Q=.sha256")
+	r.NoError(err)
+
+	r.NoError(n.wm.Want(own))
+	r.NoError(n.wm.WantWithDist(proxied, -2))
+
+	n.wm.expire(time.Now())
+	r.True(n.wm.Wants(proxied))
+
+	n.wm.expire(time.Now().Add(2 * time.Minute))
+	r.True(n.wm.Wants(own), "our own wants don't expire")
+	r.False(n.wm.Wants(proxied))
+}
+
+func TestWantPush(t *testing.T) {
+	r := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// alice takes pushes from bob, claire doesn't take any
+	alice, done := newWantNode(r, WantWithPushesFrom(func(fr *ssb.FeedRef) bool {
+		return fr.Equal(feedOf(2))
+	}))
+	defer done()
+	bob, done := newWantNode(r)
+	defer done()
+	claire, done := newWantNode(r)
+	defer done()
+
+	connect(ctx, alice, bob, 1, 2)
+	connect(ctx, bob, claire, 2, 3)
+
+	ref, err := bob.bs.Put(strings.NewReader("look at this"))
+	r.NoError(err)
+	has := func(n *wantNode) bool {
+		_, err := n.bs.Size(ref)
+		return err == nil
+	}
+
+	n, err := bob.wm.Announce(ref, func(fr *ssb.FeedRef) bool { return fr.Equal(feedOf(3)) })
+	r.NoError(err)
+	r.Equal(1, n)
+	time.Sleep(100 * time.Millisecond)
+	r.False(has(alice), "alice wasn't told")
+	r.False(has(claire), "claire doesn't take pushes")
+
+	n, err = bob.wm.Announce(ref, nil)
+	r.NoError(err)
+	r.Equal(2, n)
+	r.Eventually(func() bool { return has(alice) }, 5*time.Second, 10*time.Millisecond, "alice didn't fetch the pushed blob")
+	r.False(has(claire))
+	r.False(alice.wm.Wants(ref))
+
+	_, err = bob.wm.Announce(&ssb.BlobRef{Hash: bytes.Repeat([]byte{1}, 32), Algo: "sha256"}, nil)
+	r.Error(err, "announced a blob we don't have")
+}
+
+func TestWantResume(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	alice, done := newWantNode(r)
+	defer done()
+	bob, done := newWantNode(r)
+	defer done()
+
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	ref, err := bob.bs.Put(bytes.NewReader(content))
+	r.NoError(err)
+	store := alice.bs.(*blobStore)
+
+	// an earlier fetch got the first 300 bytes
+	writePartial := func(data []byte) {
+		p, err := store.partialPath(ref)
+		r.NoError(err)
+		r.NoError(ioutil.WriteFile(p, data, 0600))
+	}
+	writePartial(content[:300])
+
+	var offsets []int64
+	resuming := endpointOf(bob, 2).(*mmock.FakeEndpoint)
+	resuming.SourceStub = func(ctx context.Context, _ interface{}, method muxrpc.Method, args ...interface{}) (luigi.Source, error) {
+		arg := args[0].(GetWithSize)
+		offsets = append(offsets, arg.Offset)
+		return (*luigi.SliceSource)(&[]interface{}{content[arg.Offset:]}), nil
+	}
+	r.NoError(alice.wm.getBlob(ctx, resuming, ref))
+	r.Equal([]int64{300}, offsets)
+	sz, err := alice.bs.Size(ref)
+	r.NoError(err)
+	r.EqualValues(len(content), sz)
+	p, err := store.partialPath(ref)
+	r.NoError(err)
+	_, err = os.Stat(p)
+	r.True(os.IsNotExist(err), "partial file wasn't removed")
+
+	// claire ignores the offset and sends everything
+	r.NoError(alice.bs.Delete(ref))
+	writePartial(content[:300])
+	claire, done := newWantNode(r)
+	defer done()
+	_, err = claire.bs.Put(bytes.NewReader(content))
+	r.NoError(err)
+	r.NoError(alice.wm.getBlob(ctx, endpointOf(claire, 3), ref))
+	sz, err = alice.bs.Size(ref)
+	r.NoError(err)
+	r.EqualValues(len(content), sz)
+	_, noOffset := alice.wm.noOffset[endpointOf(claire, 3).Remote().String()]
+	r.True(noOffset, "claire wasn't marked")
+
+	// a partial that doesn't fit is thrown away
+	r.NoError(alice.bs.Delete(ref))
+	writePartial([]byte("not the beginning"))
+	offsets = nil
+	r.Equal(ErrHashMismatch, alice.wm.getBlob(ctx, resuming, ref))
+	_, err = os.Stat(p)
+	r.True(os.IsNotExist(err), "broken partial file wasn't removed")
+	r.NoError(alice.wm.getBlob(ctx, resuming, ref))
+	r.Equal([]int64{17, 0}, offsets)
+}
+
+func TestWantTooBig(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	alice, done := newWantNode(r, WantWithMaxSize(10))
+	defer done()
+	bob, done := newWantNode(r)
+	defer done()
+
+	ref, err := bob.bs.Put(strings.NewReader("more than ten bytes"))
+	r.NoError(err)
+
+	alice.wm.l.Lock()
+	alice.wm.wants[ref.Ref()] = -1
+	alice.wm.l.Unlock()
+
+	r.Equal(ErrBlobTooBig, alice.wm.getBlob(ctx, endpointOf(bob, 2), ref))
+	_, err = alice.bs.Size(ref)
+	r.Equal(ErrNoSuchBlob, err)
+	r.False(alice.wm.Wants(ref), "want wasn't dropped")
+
+	// nothing was left behind
+	store := alice.bs.(*blobStore)
+	for _, dir := range []string{"tmp", partialDir} {
+		entries, err := ioutil.ReadDir(filepath.Join(store.basePath, dir))
+		r.NoError(err)
+		r.Empty(entries, dir)
+	}
+
+	// local adds go through the same limit
+	_, err = alice.bs.Put(LimitReader(strings.NewReader("more than ten bytes"), 10))
+	r.Equal(ErrBlobTooBig, errors.Cause(err))
+	_, err = alice.bs.Put(LimitReader(strings.NewReader("ten bytes!"), 10))
+	r.NoError(err)
+}
+
+func TestWantRewant(t *testing.T) {
+	r := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	alice, done := newWantNode(r, WantWithExpiry(time.Minute))
+	defer done()
+	bob, done := newWantNode(r, WantWithExpiry(time.Minute))
+	defer done()
+
+	connect(ctx, alice, bob, 1, 2)
+
+	content := "late to the party"
+	sum := sha256.Sum256([]byte(content))
+	ref := &ssb.BlobRef{Hash: sum[:], Algo: "sha256"}
+	r.NoError(alice.wm.Want(ref))
+	r.Eventually(func() bool { return remoteWanted(bob, ref) }, 5*time.Second, 10*time.Millisecond, "bob didn't get the want")
+
+	// bob forgets about it before he gets the blob
+	bob.wm.expire(time.Now().Add(2 * time.Minute))
+	_, err := bob.bs.Put(strings.NewReader(content))
+	r.NoError(err)
+	time.Sleep(100 * time.Millisecond)
+	_, err = alice.bs.Size(ref)
+	r.Equal(ErrNoSuchBlob, err)
+	_, ok := ServedBy(alice.wm, ref)
+	r.False(ok)
+
+	r.NoError(Rewant(alice.wm, ref))
+	r.Eventually(func() bool {
+		_, err := alice.bs.Size(ref)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond, "alice didn't get the blob after asking again")
+
+	from, ok := ServedBy(alice.wm, ref)
+	r.True(ok)
+	r.True(from.Equal(feedOf(2)))
+}
@@ -0,0 +1,154 @@
+// SPDX-License-Identifier: MIT
+
+package client
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/json"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/muxrpc"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/message/legacy"
+)
+
+// WithMessageCache keeps the results of the last size Get calls in memory.
+// Only messages whose bytes hash to the reference they were asked for are kept, so the cache can't be poisoned by the server.
+func WithMessageCache(size int) Option {
+	return func(c *Client) error {
+		if size < 1 {
+			return errors.Errorf("ssbClient: invalid message cache size: %d", size)
+		}
+		c.msgCache = newMessageCache(size)
+		return nil
+	}
+}
+
+// CacheStats are the counters of the message cache, to see if its size fits the usage
+type CacheStats struct {
+	Hits, Misses int64
+	// how many messages are in the cache and how many fit
+	Len, Size int
+}
+
+// Get returns the value of a message, the JSON the author signed.
+// With WithMessageCache it is only fetched once.
+func (c Client) Get(ref *ssb.MessageRef) (json.RawMessage, error) {
+	if c.msgCache != nil {
+		if v, ok := c.msgCache.get(ref.Ref()); ok {
+			return v, nil
+		}
+	}
+
+	v, err := c.Async(c.rootCtx, json.RawMessage{}, muxrpc.Method{"get"}, ref.Ref())
+	if err != nil {
+		return nil, errors.Wrap(err, "ssbClient: get failed")
+	}
+	raw, ok := v.(json.RawMessage)
+	if !ok {
+		return nil, errors.Errorf("ssbClient: wrong get reply type: %T", v)
+	}
+
+	if c.msgCache != nil && ref.Algo == ssb.RefAlgoMessageSSB1 {
+		got, err := legacyMessageRef(raw)
+		if err != nil {
+			return nil, errors.Wrapf(err, "ssbClient: failed to verify %s", ref.Ref())
+		}
+		if !got.Equal(*ref) {
+			return nil, errors.Errorf("ssbClient: asked for %s but got %s", ref.Ref(), got.Ref())
+		}
+		c.msgCache.add(ref.Ref(), raw)
+	}
+	return raw, nil
+}
+
+// MessageCacheStats returns the counters of the cache set with WithMessageCache, or zero values without one
+func (c Client) MessageCacheStats() CacheStats {
+	if c.msgCache == nil {
+		return CacheStats{}
+	}
+	return c.msgCache.stats()
+}
+
+// legacyMessageRef computes the key of a signed message value in the ssb v1 format
+func legacyMessageRef(raw json.RawMessage) (*ssb.MessageRef, error) {
+	enc, err := legacy.EncodePreserveOrder(raw)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode message")
+	}
+	v8warp, err := legacy.InternalV8Binary(enc)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to hash message")
+	}
+	h := sha256.Sum256(v8warp)
+	return &ssb.MessageRef{
+		Hash: h[:],
+		Algo: ssb.RefAlgoMessageSSB1,
+	}, nil
+}
+
+type cachedMessage struct {
+	key string
+	raw json.RawMessage
+}
+
+// messageCache is a least recently used cache of message values
+type messageCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // front is the most recently used
+	entries map[string]*list.Element
+
+	hits, misses int64
+}
+
+func newMessageCache(size int) *messageCache {
+	return &messageCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (mc *messageCache) get(key string) (json.RawMessage, bool) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	el, has := mc.entries[key]
+	if !has {
+		mc.misses++
+		return nil, false
+	}
+	mc.hits++
+	mc.order.MoveToFront(el)
+	// copy so that callers can't change what's cached
+	return append(json.RawMessage(nil), el.Value.(cachedMessage).raw...), true
+}
+
+func (mc *messageCache) add(key string, raw json.RawMessage) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if el, has := mc.entries[key]; has {
+		mc.order.MoveToFront(el)
+		return
+	}
+	mc.entries[key] = mc.order.PushFront(cachedMessage{key: key, raw: append(json.RawMessage(nil), raw...)})
+	for mc.order.Len() > mc.size {
+		oldest := mc.order.Back()
+		mc.order.Remove(oldest)
+		delete(mc.entries, oldest.Value.(cachedMessage).key)
+	}
+}
+
+func (mc *messageCache) stats() CacheStats {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return CacheStats{
+		Hits:   mc.hits,
+		Misses: mc.misses,
+		Len:    mc.order.Len(),
+		Size:   mc.size,
+	}
+}
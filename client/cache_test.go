@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: MIT
+
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/client"
+	"go.cryptoscope.co/ssb/internal/testutils"
+	"go.cryptoscope.co/ssb/sbot"
+)
+
+func TestGetCache(t *testing.T) {
+	r := require.New(t)
+
+	srvRepo := filepath.Join("testrun", t.Name(), "serv")
+	os.RemoveAll(srvRepo)
+
+	srv, err := sbot.New(
+		sbot.WithInfo(testutils.NewRelativeTimeLogger(nil)),
+		sbot.WithRepoPath(srvRepo),
+		sbot.WithListenAddr(":0"))
+	r.NoError(err, "sbot srv init failed")
+
+	var srvErrc = make(chan error, 1)
+	go func() {
+		srvErrc <- srv.Network.Serve(context.TODO())
+		close(srvErrc)
+	}()
+
+	kp, err := ssb.LoadKeyPair(filepath.Join(srvRepo, "secret"))
+	r.NoError(err, "failed to load servers keypair")
+
+	_, err = client.NewTCP(kp, srv.Network.GetListenAddr(), client.WithMessageCache(0))
+	r.Error(err)
+
+	c, err := client.NewTCP(kp, srv.Network.GetListenAddr(), client.WithMessageCache(1))
+	r.NoError(err, "failed to make client connection")
+
+	first, err := c.Publish(map[string]interface{}{"type": "test", "i": 1})
+	r.NoError(err)
+	second, err := c.Publish(map[string]interface{}{"type": "test", "i": 2})
+	r.NoError(err)
+
+	get := func(ref *ssb.MessageRef) int {
+		raw, err := c.Get(ref)
+		r.NoError(err)
+		var v struct {
+			Content struct {
+				I int `json:"i"`
+			} `json:"content"`
+		}
+		r.NoError(json.Unmarshal(raw, &v))
+		return v.Content.I
+	}
+
+	r.Equal(1, get(first))
+	r.Equal(1, get(first))
+	r.Equal(2, get(second))
+	r.Equal(1, get(first)) // pushed out by the second one
+	r.Equal(client.CacheStats{Hits: 1, Misses: 3, Len: 1, Size: 1}, c.MessageCacheStats())
+
+	r.NoError(c.Close())
+	srv.Shutdown()
+	r.NoError(srv.Close())
+	<-srvErrc
+}
@@ -5,10 +5,13 @@ package client
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"os"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
@@ -23,6 +26,7 @@ import (
 	"go.cryptoscope.co/ssb"
 	"go.cryptoscope.co/ssb/blobstore"
 	"go.cryptoscope.co/ssb/message"
+	"go.cryptoscope.co/ssb/plugins/blobs"
 	"go.cryptoscope.co/ssb/plugins/whoami"
 )
 
@@ -35,6 +39,9 @@ type Client struct {
 	closer io.Closer
 
 	appKeyBytes []byte
+
+	// set by WithMessageCache
+	msgCache *messageCache
 }
 
 func newClientWithOptions(opts []Option) (*Client, error) {
@@ -189,6 +196,15 @@ func (c Client) ReplicateUpTo() (luigi.Source, error) {
 	return src, errors.Wrap(err, "ssbClient: failed to create stream")
 }
 
+// GossipLog streams the recent connection and replication events of the server (ssb.GossipEvent), with live the ones after them as well
+func (c Client) GossipLog(live bool) (luigi.Source, error) {
+	args := struct {
+		Live bool `json:"live"`
+	}{live}
+	src, err := c.Source(c.rootCtx, ssb.GossipEvent{}, muxrpc.Method{"ctrl", "gossipLog"}, args)
+	return src, errors.Wrap(err, "ssbClient: failed to create gossip log stream")
+}
+
 func (c Client) BlobsWant(ref ssb.BlobRef) error {
 	var v interface{}
 	v, err := c.Async(c.rootCtx, v, muxrpc.Method{"blobs", "want"}, ref.Ref())
@@ -200,15 +216,159 @@ func (c Client) BlobsWant(ref ssb.BlobRef) error {
 }
 
 func (c Client) BlobsHas(ref *ssb.BlobRef) (bool, error) {
-	v, err := c.Async(c.rootCtx, true, muxrpc.Method{"blobs", "want"}, ref.Ref())
+	v, err := c.Async(c.rootCtx, true, muxrpc.Method{"blobs", "has"}, ref.Ref())
 	if err != nil {
-		return false, errors.Wrap(err, "ssbClient: whoami failed")
+		return false, errors.Wrap(err, "ssbClient: blobs.has failed")
 	}
 	c.logger.Log("blob", "has", "v", v, "ref", ref.Ref())
 	return v.(bool), nil
 
 }
 
+// BlobsWaitOptions configure BlobsWantAndWait.
+// The server is asked every Interval if it got the blob. After every miss the want is sent to all its peers again
+// and the interval doubles, up to MaxInterval. It gives up after Deadline.
+type BlobsWaitOptions struct {
+	Interval    time.Duration
+	MaxInterval time.Duration
+	Deadline    time.Duration
+}
+
+// DefaultBlobsWaitOptions checks every second at first and waits two minutes at most.
+var DefaultBlobsWaitOptions = BlobsWaitOptions{
+	Interval:    time.Second,
+	MaxInterval: 30 * time.Second,
+	Deadline:    2 * time.Minute,
+}
+
+// BlobsWantAndWait wants ref and waits until the server got it.
+// It returns the peer that served the blob, which is nil if the server had it already or doesn't remember.
+func (c Client) BlobsWantAndWait(ref *ssb.BlobRef, opts BlobsWaitOptions) (*ssb.FeedRef, error) {
+	if opts.Interval <= 0 || opts.Deadline <= 0 {
+		return nil, errors.New("ssbClient: wait interval and deadline need to be positive")
+	}
+	if opts.MaxInterval < opts.Interval {
+		opts.MaxInterval = opts.Interval
+	}
+
+	has, err := c.BlobsHas(ref)
+	if err != nil {
+		return nil, err
+	}
+	if has {
+		return nil, nil
+	}
+	if err := c.BlobsWant(*ref); err != nil {
+		return nil, err
+	}
+
+	deadline := time.NewTimer(opts.Deadline)
+	defer deadline.Stop()
+	interval := opts.Interval
+	for {
+		select {
+		case <-c.rootCtx.Done():
+			return nil, errors.Wrap(c.rootCtx.Err(), "ssbClient: stopped waiting for blob")
+		case <-deadline.C:
+			return nil, errors.Errorf("ssbClient: %s didn't arrive within %s", ref.Ref(), opts.Deadline)
+		case <-time.After(interval):
+		}
+
+		has, err := c.BlobsHas(ref)
+		if err != nil {
+			return nil, err
+		}
+		if has {
+			return c.blobServedBy(ref)
+		}
+
+		var v interface{}
+		_, err = c.Async(c.rootCtx, v, muxrpc.Method{"blobs", "want"}, ref.Ref(), blobs.WantArgs{Again: true})
+		if err != nil {
+			return nil, errors.Wrap(err, "ssbClient: blobs.want again failed")
+		}
+		c.logger.Log("blob", "wanted again", "ref", ref.Ref(), "after", interval)
+
+		interval *= 2
+		if interval > opts.MaxInterval {
+			interval = opts.MaxInterval
+		}
+	}
+}
+
+// blobServedBy asks the server where it got ref from
+func (c Client) blobServedBy(ref *ssb.BlobRef) (*ssb.FeedRef, error) {
+	v, err := c.Async(c.rootCtx, blobs.BlobMeta{}, muxrpc.Method{"blobs", "meta"}, ref.Ref())
+	if err != nil {
+		return nil, errors.Wrap(err, "ssbClient: blobs.meta failed")
+	}
+	meta, ok := v.(blobs.BlobMeta)
+	if !ok {
+		return nil, errors.Errorf("ssbClient: unexpected blobs.meta reply: %T", v)
+	}
+	if meta.From == "" {
+		return nil, nil
+	}
+	from, err := ssb.ParseFeedRef(meta.From)
+	return from, errors.Wrap(err, "ssbClient: invalid peer in blobs.meta")
+}
+
+// BlobsList returns the refs of all the blobs the server has.
+func (c Client) BlobsList() ([]*ssb.BlobRef, error) {
+	src, err := c.Source(c.rootCtx, json.RawMessage{}, muxrpc.Method{"blobs", "ls"})
+	if err != nil {
+		return nil, errors.Wrap(err, "ssbClient: blobs.ls failed")
+	}
+	var refs []*ssb.BlobRef
+	for {
+		v, err := src.Next(c.rootCtx)
+		if luigi.IsEOS(err) {
+			return refs, nil
+		} else if err != nil {
+			return nil, errors.Wrap(err, "ssbClient: failed to read blobs.ls")
+		}
+		raw, ok := v.(json.RawMessage)
+		if !ok {
+			return nil, errors.Errorf("ssbClient: unexpected blobs.ls entry: %T", v)
+		}
+		var ref ssb.BlobRef
+		if err := json.Unmarshal(raw, &ref); err != nil {
+			return nil, errors.Wrap(err, "ssbClient: invalid blobs.ls entry")
+		}
+		refs = append(refs, &ref)
+	}
+}
+
+// BlobsHasAll checks a batch of blobs with one blobs.has call. The answers are in the order of refs.
+func (c Client) BlobsHasAll(refs []*ssb.BlobRef) ([]bool, error) {
+	args := make([]string, len(refs))
+	for i, ref := range refs {
+		args[i] = ref.Ref()
+	}
+	v, err := c.Async(c.rootCtx, []bool{}, muxrpc.Method{"blobs", "has"}, args)
+	if err != nil {
+		return nil, errors.Wrap(err, "ssbClient: blobs.has failed")
+	}
+	has, ok := v.([]bool)
+	if !ok || len(has) != len(refs) {
+		return nil, errors.Errorf("ssbClient: unexpected blobs.has reply: %v", v)
+	}
+	return has, nil
+}
+
+// BlobsSize returns the size of a blob the server has. It errors if the server doesn't have it.
+func (c Client) BlobsSize(ref *ssb.BlobRef) (int64, error) {
+	v, err := c.Async(c.rootCtx, blobs.BlobMeta{}, muxrpc.Method{"blobs", "size"}, ref.Ref())
+	if err != nil {
+		return -1, errors.Wrap(err, "ssbClient: blobs.size failed")
+	}
+	meta, ok := v.(blobs.BlobMeta)
+	if !ok {
+		return -1, errors.Errorf("ssbClient: unexpected blobs.size reply: %T", v)
+	}
+	return meta.Size, nil
+}
+
 func (c Client) BlobsGet(ref *ssb.BlobRef) (io.Reader, error) {
 	args := blobstore.GetWithSize{Key: ref, Max: blobstore.DefaultMaxSize}
 	v, err := c.Source(c.rootCtx, codec.Body{}, muxrpc.Method{"blobs", "get"}, args)
@@ -220,6 +380,41 @@ func (c Client) BlobsGet(ref *ssb.BlobRef) (io.Reader, error) {
 	return muxrpc.NewSourceReader(v), nil
 }
 
+// BlobsGetSlice returns the bytes from start up to end (exclusive, 0 means the end of the blob) of a blob.
+// Servers without blobs.getSlice send the whole blob, which is then cut down here.
+func (c Client) BlobsGetSlice(ref *ssb.BlobRef, start, end int64) ([]byte, error) {
+	if start < 0 || (end != 0 && end < start) {
+		return nil, errors.Errorf("ssbClient: invalid blob range %d-%d", start, end)
+	}
+
+	args := blobstore.GetSlice{Key: ref, Start: start, End: end}
+	src, err := c.Source(c.rootCtx, codec.Body{}, muxrpc.Method{"blobs", "getSlice"}, args)
+	if err == nil {
+		var data []byte
+		data, err = ioutil.ReadAll(muxrpc.NewSourceReader(src))
+		if err == nil {
+			return data, nil
+		}
+	}
+	c.logger.Log("blob", "getSlice failed, reading all of it", "ref", ref.Ref(), "err", err)
+
+	rd, err := c.BlobsGet(ref)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(ioutil.Discard, rd, start); err != nil {
+		if err == io.EOF {
+			return []byte{}, nil
+		}
+		return nil, errors.Wrap(err, "ssbClient: blobs.get failed")
+	}
+	if end > 0 {
+		rd = io.LimitReader(rd, end-start)
+	}
+	data, err := ioutil.ReadAll(rd)
+	return data, errors.Wrap(err, "ssbClient: blobs.get failed")
+}
+
 type NamesGetResult map[string]map[string]string
 
 func (ngr NamesGetResult) GetCommonName(feed *ssb.FeedRef) (string, bool) {
@@ -328,6 +523,20 @@ func (c Client) CreateHistoryStream(o message.CreateHistArgs) (luigi.Source, err
 	return src, errors.Wrapf(err, "ssbClient: failed to create stream (%T)", o)
 }
 
+// CreateUserStream streams the messages of ref, see message.CreateUserStreamArgs for how it differs from CreateHistoryStream.
+// go-sbot doesn't serve it yet, it's for talking to the JS server.
+func (c Client) CreateUserStream(ref *ssb.FeedRef, o message.CreateUserStreamArgs) (luigi.Source, error) {
+	o.ID = ref
+	src, err := c.Source(c.rootCtx, o.MarshalType, muxrpc.Method{"createUserStream"}, o)
+	return src, errors.Wrapf(err, "ssbClient: failed to create stream (%T)", o)
+}
+
+// CreateFeedStream streams the messages in the order of their (clamped) claimed timestamps, see message.CreateFeedArgs.
+func (c Client) CreateFeedStream(o message.CreateFeedArgs) (luigi.Source, error) {
+	src, err := c.Source(c.rootCtx, o.MarshalType, muxrpc.Method{"createFeedStream"}, o)
+	return src, errors.Wrapf(err, "ssbClient: failed to create stream (%T)", o)
+}
+
 func (c Client) MessagesByType(opts message.MessagesByTypeArgs) (luigi.Source, error) {
 	src, err := c.Source(c.rootCtx, opts.MarshalType, muxrpc.Method{"messagesByType"}, opts)
 	return src, errors.Wrapf(err, "ssbClient: failed to create stream (%T)", opts)
@@ -338,6 +547,13 @@ func (c Client) Tangles(o message.TanglesArgs) (luigi.Source, error) {
 	return src, errors.Wrap(err, "ssbClient/tangles: failed to create stream")
 }
 
+// BacklinksRead streams the messages that link to o.Dest, as the backlinks index of the server has them.
+// See Backlinks for replies and votes of a message on servers with or without the index.
+func (c Client) BacklinksRead(o message.BacklinksArgs) (luigi.Source, error) {
+	src, err := c.Source(c.rootCtx, o.MarshalType, muxrpc.Method{"backlinks", "read"}, o)
+	return src, errors.Wrap(err, "ssbClient/backlinks: failed to create stream")
+}
+
 type noopHandler struct {
 	logger log.Logger
 }
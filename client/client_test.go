@@ -512,3 +512,49 @@ func TestTangles(t *testing.T) {
 	r.NoError(srv.Close())
 	r.NoError(<-srvErrc)
 }
+
+func TestGetTangle(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	srvRepo := filepath.Join("testrun", t.Name(), "serv")
+	os.RemoveAll(srvRepo)
+	srvLog := testutils.NewRelativeTimeLogger(nil)
+
+	srv, err := sbot.New(
+		sbot.WithInfo(srvLog),
+		sbot.WithRepoPath(srvRepo),
+		sbot.WithListenAddr(":0"),
+		sbot.LateOption(sbot.WithUNIXSocket()),
+	)
+	r.NoError(err, "sbot srv init failed")
+
+	c, err := client.NewUnix(filepath.Join(srvRepo, "socket"))
+	r.NoError(err, "failed to make client connection")
+	// end test boilerplate
+
+	type testMsg struct {
+		Type   string            `json:"type"`
+		Root   *ssb.MessageRef   `json:"root,omitempty"`
+		Branch []*ssb.MessageRef `json:"branch,omitempty"`
+	}
+	rootRef, err := c.Publish(testMsg{Type: "post"})
+	r.NoError(err)
+	_, err = c.Publish(testMsg{Type: "post"})
+	r.NoError(err)
+	rep1Ref, err := c.Publish(testMsg{"post", rootRef, []*ssb.MessageRef{rootRef}})
+	r.NoError(err)
+	rep2Ref, err := c.Publish(testMsg{"post", rootRef, []*ssb.MessageRef{rep1Ref}})
+	r.NoError(err)
+
+	// go-sbot doesn't serve partialReplication.getTangle, so this scans the log
+	msgs, err := c.GetTangle(rootRef)
+	r.NoError(err)
+	r.Len(msgs, 3)
+	a.True(msgs[0].Key().Equal(*rootRef))
+	a.True(msgs[1].Key().Equal(*rep1Ref))
+	a.True(msgs[2].Key().Equal(*rep2Ref))
+
+	a.NoError(c.Close())
+	srv.Shutdown()
+	r.NoError(srv.Close())
+}
@@ -0,0 +1,156 @@
+// SPDX-License-Identifier: MIT
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/muxrpc"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/message"
+)
+
+// the fields of a message content Backlinks looks at
+const (
+	LinkRoot     = "root"
+	LinkBranch   = "branch"
+	LinkVote     = "vote"
+	LinkMentions = "mentions"
+)
+
+// Backlinks streams the messages that link to ref as ssb.KeyValueRaw, through rel (one of the Link constants) or any of them if rel is empty.
+// It asks the backlinks index of the server. If the server doesn't have one, it scans the whole log instead.
+func (c Client) Backlinks(ref *ssb.MessageRef, rel string) (luigi.Source, error) {
+	switch rel {
+	case "", LinkRoot, LinkBranch, LinkVote, LinkMentions:
+	default:
+		return nil, errors.Errorf("ssbClient: unsupported link relation %q", rel)
+	}
+
+	var args message.BacklinksArgs
+	args.Dest = ref.Ref()
+	args.Keys = true
+	args.Limit = -1
+	args.MarshalType = ssb.KeyValueRaw{}
+	src, err := c.BacklinksRead(args)
+	if err != nil {
+		return nil, err
+	}
+	return &linksSource{c: c, ref: ref.Ref(), rel: rel, src: src}, nil
+}
+
+// linksSource filters src for messages that link to ref.
+// If the first call fails because the server doesn't know backlinks.read, it switches to the whole log.
+type linksSource struct {
+	c   Client
+	ref string
+	rel string
+
+	src      luigi.Source
+	started  bool
+	scanning bool
+}
+
+func (ls *linksSource) Next(ctx context.Context) (interface{}, error) {
+	for {
+		v, err := ls.src.Next(ctx)
+		if err != nil {
+			if _, ok := errors.Cause(err).(*muxrpc.CallError); ok && !ls.started && !ls.scanning {
+				if err := ls.scan(err); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			return nil, err
+		}
+		ls.started = true
+
+		kv, ok := v.(ssb.KeyValueRaw)
+		if !ok {
+			return nil, errors.Errorf("ssbClient: wrong backlinks stream type: %T", v)
+		}
+		if linksTo(kv.Value.Content, ls.ref, ls.rel) {
+			return kv, nil
+		}
+	}
+}
+
+func (ls *linksSource) scan(reason error) error {
+	ls.c.logger.Log("event", "backlinks not supported, scanning the log", "err", reason)
+	var args message.CreateLogArgs
+	args.Keys = true
+	args.Limit = -1
+	args.MarshalType = ssb.KeyValueRaw{}
+	src, err := ls.c.CreateLogStream(args)
+	if err != nil {
+		return errors.Wrap(err, "ssbClient: failed to scan the log for backlinks")
+	}
+	ls.src = src
+	ls.scanning = true
+	return nil
+}
+
+// linksTo checks if content links to ref through rel, or any of the Link fields if rel is empty
+func linksTo(content json.RawMessage, ref, rel string) bool {
+	var c struct {
+		Vote     json.RawMessage `json:"vote"`
+		Mentions json.RawMessage `json:"mentions"`
+	}
+	if err := json.Unmarshal(content, &c); err != nil {
+		// private or broken content
+		return false
+	}
+
+	if rel == "" || rel == LinkRoot || rel == LinkBranch {
+		tc := tangleContentOf(content)
+		if (rel == "" || rel == LinkRoot) && tc.root == ref {
+			return true
+		}
+		if (rel == "" || rel == LinkBranch) && tc.hasBranch(ref) {
+			return true
+		}
+	}
+	if (rel == "" || rel == LinkVote) && len(c.Vote) > 0 {
+		var vote struct {
+			Link string `json:"link"`
+		}
+		if json.Unmarshal(c.Vote, &vote) == nil && vote.Link == ref {
+			return true
+		}
+	}
+	if (rel == "" || rel == LinkMentions) && len(c.Mentions) > 0 {
+		for _, m := range mentionLinks(c.Mentions) {
+			if m == ref {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mentionLinks reads mentions, which can be a list of refs or of objects with a link, or a single one of those
+func mentionLinks(raw json.RawMessage) []string {
+	var list []json.RawMessage
+	if err := json.Unmarshal(raw, &list); err != nil {
+		list = []json.RawMessage{raw}
+	}
+	var links []string
+	for _, elem := range list {
+		var s string
+		if json.Unmarshal(elem, &s) == nil {
+			links = append(links, s)
+			continue
+		}
+		var obj struct {
+			Link string `json:"link"`
+		}
+		if json.Unmarshal(elem, &obj) == nil && obj.Link != "" {
+			links = append(links, obj.Link)
+		}
+	}
+	return links
+}
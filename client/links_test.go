@@ -0,0 +1,38 @@
+// SPDX-License-Identifier: MIT
+
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLinksTo(t *testing.T) {
+	const (
+		msgA = "%EMr6LTquV6Y8qkSaQ96ncL6oymbx4IddLdQKVGqYgGI=.sha256"
+		msgB = "%rkJMoEspdU75c1RpGbwjEH7eZxM/PJPFubpZTtynhsg=.sha256"
+	)
+
+	tcs := []struct {
+		name    string
+		content string
+		rel     string
+		want    bool
+	}{
+		{"root", `{"type":"post","root":"` + msgA + `"}`, "", true},
+		{"root as root", `{"type":"post","root":"` + msgA + `"}`, LinkRoot, true},
+		{"root as branch", `{"type":"post","root":"` + msgA + `"}`, LinkBranch, false},
+		{"branch list", `{"type":"post","root":"` + msgB + `","branch":["` + msgB + `","` + msgA + `"]}`, LinkBranch, true},
+		{"vote", `{"type":"vote","vote":{"link":"` + msgA + `","value":1}}`, LinkVote, true},
+		{"vote as root", `{"type":"vote","vote":{"link":"` + msgA + `","value":1}}`, LinkRoot, false},
+		{"mention ref", `{"type":"post","mentions":["` + msgA + `"]}`, LinkMentions, true},
+		{"mention object", `{"type":"post","mentions":[{"link":"` + msgA + `","name":"a"}]}`, "", true},
+		{"single mention", `{"type":"post","mentions":{"link":"` + msgA + `"}}`, LinkMentions, true},
+		{"other message", `{"type":"post","root":"` + msgB + `","mentions":["` + msgB + `"]}`, "", false},
+		{"private", `"ASDASDASD.box"`, "", false},
+	}
+	for _, tc := range tcs {
+		assert.Equal(t, tc.want, linksTo([]byte(tc.content), msgA, tc.rel), "case: %s", tc.name)
+	}
+}
@@ -4,6 +4,7 @@ package client_test
 
 import (
 	"context"
+	"encoding/json"
 	"os"
 	"path/filepath"
 	"testing"
@@ -16,6 +17,7 @@ import (
 	"go.cryptoscope.co/ssb"
 	"go.cryptoscope.co/ssb/client"
 	"go.cryptoscope.co/ssb/internal/testutils"
+	"go.cryptoscope.co/ssb/internal/transform"
 	"go.cryptoscope.co/ssb/message"
 	"go.cryptoscope.co/ssb/sbot"
 )
@@ -127,3 +129,73 @@ func TestReadStreamAsInterfaceMessage(t *testing.T) {
 	r.NoError(srv.Close())
 	r.NoError(<-srvErrc)
 }
+
+func TestCreateLogStreamRXSeq(t *testing.T) {
+	r := require.New(t)
+
+	srvRepo := filepath.Join("testrun", t.Name(), "serv")
+	os.RemoveAll(srvRepo)
+
+	srv, err := sbot.New(
+		sbot.WithInfo(testutils.NewRelativeTimeLogger(nil)),
+		sbot.WithRepoPath(srvRepo),
+		sbot.WithListenAddr(":0"))
+	r.NoError(err, "sbot srv init failed")
+
+	var srvErrc = make(chan error, 1)
+	go func() {
+		err := srv.Network.Serve(context.TODO())
+		if err != nil {
+			srvErrc <- errors.Wrap(err, "ali serve exited")
+		}
+		close(srvErrc)
+	}()
+
+	kp, err := ssb.LoadKeyPair(filepath.Join(srvRepo, "secret"))
+	r.NoError(err, "failed to load servers keypair")
+	c, err := client.NewTCP(kp, srv.Network.GetListenAddr())
+	r.NoError(err, "failed to make client connection")
+
+	var refs []string
+	for i := 0; i < 10; i++ {
+		ref, err := c.Publish(struct{ I int }{i})
+		r.NoError(err)
+		refs = append(refs, ref.Ref())
+	}
+	// a gap, so that the position in the stream and the receive sequence differ
+	r.NoError(srv.RootLog.Null(margaret.BaseSeq(3)))
+
+	collect := func(opts message.CreateLogArgs) []int64 {
+		opts.RXSeq = true
+		opts.MarshalType = json.RawMessage{}
+		src, err := c.CreateLogStream(opts)
+		r.NoError(err)
+		var seqs []int64
+		for {
+			v, err := src.Next(context.TODO())
+			if luigi.IsEOS(err) {
+				return seqs
+			}
+			r.NoError(err)
+			var kv transform.KeyValueRXSeq
+			r.NoError(json.Unmarshal(v.(json.RawMessage), &kv))
+			r.Equal(refs[kv.RXSeq], kv.Key_.Ref(), "rxseq %d is not the message with that receive sequence", kv.RXSeq)
+			seqs = append(seqs, kv.RXSeq)
+		}
+	}
+
+	var opts message.CreateLogArgs
+	opts.Limit = -1
+	opts.Seq = 2
+	r.Equal([]int64{2, 4, 5, 6, 7, 8, 9}, collect(opts))
+
+	opts = message.CreateLogArgs{}
+	opts.Limit = 3
+	opts.Reverse = true
+	r.Equal([]int64{9, 8, 7}, collect(opts))
+
+	r.NoError(c.Close())
+	srv.Shutdown()
+	r.NoError(srv.Close())
+	r.NoError(<-srvErrc)
+}
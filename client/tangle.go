@@ -0,0 +1,208 @@
+// SPDX-License-Identifier: MIT
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/muxrpc"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/message"
+)
+
+// GetTangle returns the root message and all the messages of its thread, replies after the messages they reply to.
+// It asks the server with partialReplication.getTangle. Servers that don't have that method
+// make it scan the whole log for messages with a matching root or branch instead.
+func (c Client) GetTangle(root *ssb.MessageRef) ([]ssb.KeyValueRaw, error) {
+	v, err := c.Async(c.rootCtx, []json.RawMessage{}, muxrpc.Method{"partialReplication", "getTangle"}, root.Ref())
+	if err != nil {
+		if _, ok := errors.Cause(err).(*muxrpc.CallError); !ok {
+			return nil, errors.Wrap(err, "ssbClient: getTangle failed")
+		}
+		c.logger.Log("event", "getTangle not supported, scanning the log", "err", err)
+		msgs, err := c.scanTangle(root)
+		if err != nil {
+			return nil, err
+		}
+		return sortTangle(root, msgs), nil
+	}
+
+	raws, ok := v.([]json.RawMessage)
+	if !ok {
+		return nil, errors.Errorf("ssbClient: wrong getTangle reply type: %T", v)
+	}
+	msgs := make([]ssb.KeyValueRaw, len(raws))
+	for i, raw := range raws {
+		msgs[i], err = decodeTangleMessage(raw)
+		if err != nil {
+			return nil, errors.Wrapf(err, "ssbClient: getTangle message %d", i)
+		}
+	}
+	return sortTangle(root, msgs), nil
+}
+
+// decodeTangleMessage takes a message with key and value or only the value, in which case it computes the key
+func decodeTangleMessage(raw json.RawMessage) (ssb.KeyValueRaw, error) {
+	var kv ssb.KeyValueRaw
+	var probe struct {
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		return kv, errors.Wrap(err, "not a message")
+	}
+	if len(probe.Value) > 0 {
+		err := json.Unmarshal(raw, &kv)
+		return kv, errors.Wrap(err, "failed to decode message")
+	}
+
+	if err := json.Unmarshal(raw, &kv.Value); err != nil {
+		return kv, errors.Wrap(err, "failed to decode message value")
+	}
+	var err error
+	kv.Key_, err = legacyMessageRef(raw)
+	if err != nil {
+		return kv, err
+	}
+	kv.Timestamp = kv.Value.Timestamp
+	return kv, nil
+}
+
+// scanTangle goes through the whole log and keeps the root and the messages that point to it
+func (c Client) scanTangle(root *ssb.MessageRef) ([]ssb.KeyValueRaw, error) {
+	var args message.CreateLogArgs
+	args.Keys = true
+	args.Limit = -1
+	args.MarshalType = ssb.KeyValueRaw{}
+	src, err := c.CreateLogStream(args)
+	if err != nil {
+		return nil, err
+	}
+
+	var msgs []ssb.KeyValueRaw
+	for {
+		v, err := src.Next(c.rootCtx)
+		if luigi.IsEOS(err) {
+			return msgs, nil
+		} else if err != nil {
+			return nil, errors.Wrap(err, "ssbClient: failed to scan the log for the tangle")
+		}
+		kv, ok := v.(ssb.KeyValueRaw)
+		if !ok {
+			return nil, errors.Errorf("ssbClient: wrong log stream type: %T", v)
+		}
+		if kv.Key_.Equal(*root) {
+			msgs = append(msgs, kv)
+			continue
+		}
+		tc := tangleContentOf(kv.Value.Content)
+		if tc.root == root.Ref() || tc.hasBranch(root.Ref()) {
+			msgs = append(msgs, kv)
+		}
+	}
+}
+
+type tangleContent struct {
+	root   string
+	branch []string
+}
+
+func (tc tangleContent) hasBranch(ref string) bool {
+	for _, b := range tc.branch {
+		if b == ref {
+			return true
+		}
+	}
+	return false
+}
+
+// tangleContentOf reads root and branch, which can be a single ref or a list of them.
+// Private messages and other content without them return empty fields.
+func tangleContentOf(content json.RawMessage) tangleContent {
+	var tc tangleContent
+	var c struct {
+		Root   string          `json:"root"`
+		Branch json.RawMessage `json:"branch"`
+	}
+	if err := json.Unmarshal(content, &c); err != nil {
+		return tc
+	}
+	tc.root = c.Root
+	if len(c.Branch) == 0 {
+		return tc
+	}
+	var one string
+	if err := json.Unmarshal(c.Branch, &one); err == nil {
+		tc.branch = []string{one}
+		return tc
+	}
+	json.Unmarshal(c.Branch, &tc.branch)
+	return tc
+}
+
+// sortTangle puts the root first and every message after the ones in its branch that are part of the thread.
+// Messages that don't depend on each other are ordered by their claimed timestamp.
+func sortTangle(root *ssb.MessageRef, msgs []ssb.KeyValueRaw) []ssb.KeyValueRaw {
+	sort.SliceStable(msgs, func(i, j int) bool {
+		ti, tj := time.Time(msgs[i].Value.Timestamp), time.Time(msgs[j].Value.Timestamp)
+		if ti.Equal(tj) {
+			return bytes.Compare(msgs[i].Key_.Hash, msgs[j].Key_.Hash) < 0
+		}
+		return ti.Before(tj)
+	})
+
+	byKey := make(map[string]int, len(msgs))
+	for i, m := range msgs {
+		byKey[m.Key_.Ref()] = i
+	}
+
+	// the number of unsorted messages in the branch of each message
+	waiting := make([]int, len(msgs))
+	next := make(map[int][]int)
+	for i, m := range msgs {
+		if m.Key_.Equal(*root) {
+			continue
+		}
+		// everything comes after the root, also replies without a branch
+		deps := append(tangleContentOf(m.Value.Content).branch, root.Ref())
+		for _, d := range deps {
+			j, has := byKey[d]
+			if !has || j == i {
+				continue
+			}
+			waiting[i]++
+			next[j] = append(next[j], i)
+		}
+	}
+
+	sorted := make([]ssb.KeyValueRaw, 0, len(msgs))
+	done := make([]bool, len(msgs))
+	for len(sorted) < len(msgs) {
+		// the earliest message that isn't waiting for another one,
+		// or the earliest one left if the branches form a cycle
+		pick := -1
+		for i := range msgs {
+			if done[i] {
+				continue
+			}
+			if waiting[i] == 0 {
+				pick = i
+				break
+			}
+			if pick == -1 {
+				pick = i
+			}
+		}
+		done[pick] = true
+		sorted = append(sorted, msgs[pick])
+		for _, j := range next[pick] {
+			waiting[j]--
+		}
+	}
+	return sorted
+}
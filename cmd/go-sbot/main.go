@@ -20,6 +20,7 @@ import (
 	_ "net/http/pprof"
 
 	"github.com/cryptix/go/logging"
+	"github.com/dustin/go-humanize"
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/pkg/errors"
@@ -28,13 +29,20 @@ import (
 	"go.cryptoscope.co/muxrpc/debug"
 
 	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/authz"
+	"go.cryptoscope.co/ssb/blobstore"
 	"go.cryptoscope.co/ssb/indexes"
 	"go.cryptoscope.co/ssb/internal/ctxutils"
 	"go.cryptoscope.co/ssb/multilogs"
+	"go.cryptoscope.co/ssb/network"
 	"go.cryptoscope.co/ssb/plugins2"
+	"go.cryptoscope.co/ssb/plugins2/backlinks"
 	"go.cryptoscope.co/ssb/plugins2/bytype"
+	"go.cryptoscope.co/ssb/plugins2/feedstream"
 	"go.cryptoscope.co/ssb/plugins2/names"
+	"go.cryptoscope.co/ssb/plugins2/query"
 	"go.cryptoscope.co/ssb/plugins2/tangles"
+	"go.cryptoscope.co/ssb/private/keys"
 	"go.cryptoscope.co/ssb/repo"
 	mksbot "go.cryptoscope.co/ssb/sbot"
 )
@@ -48,13 +56,59 @@ var (
 	flagFatBot   bool
 	flagHops     uint
 	flagEnAdv    bool
+	flagMaxConns int
+	flagSticky   string
 	flagEnDiscov bool
 	flagPromisc  bool
+	flagEBT      bool
+	flagStranger bool
+
+	flagPartialHops  uint
+	flagPartialTypes string
+
+	flagScheduleHops float64
+	flagScheduleLag  float64
+	flagScheduleFair int
+
+	flagAuthzHops  int
+	flagAuthzLists bool
 
 	flagDecryptPrivate  bool
+	flagDeleteBlocked   bool
+	flagNoAutoBox       bool
+	flagAutoBox2        bool
 	flagDisableUNIXSock bool
+	flagPluginHost      bool
+
+	flagBlobsMaxSize int64
+	flagBlobsMaxAge  time.Duration
+	flagBlobsLimit   uint
+	flagBlobsPush    time.Duration
+	flagBlobsBackend string
+	flagBlobsOpts    string
+
+	flagSOCKS5    string
+	flagSOCKS5All bool
+
+	flagPubAddress      string
+	flagPubPrivateAddrs bool
+
+	flagBandwidthGlobal   string
+	flagBandwidthFollowed string
+	flagBandwidthStranger string
+
+	flagAcceptLimits     bool
+	flagAcceptHandshakes int
+	flagAcceptRate       float64
+	flagAcceptBurst      int
+	flagAcceptBanAfter   int
+	flagAcceptBanFor     time.Duration
+	flagTrustedProxies   string
 
 	listenAddr string
+	wsAddr     string
+	wsTLSCert  string
+	wsTLSKey   string
 	debugAddr  string
 	repoDir    string
 	dbgLogDir  string
@@ -90,28 +144,66 @@ func initFlags() {
 
 	flag.UintVar(&flagHops, "hops", 1, "how many hops to fetch (1: friends, 2:friends of friends)")
 	flag.BoolVar(&flagPromisc, "promisc", false, "bypass graph auth and fetch remote's feed")
+	flag.BoolVar(&flagStranger, "strangers", false, "also fetch the feeds of peers that connect but are further away than -hops")
+	flag.UintVar(&flagPartialHops, "partial-hops", 0, "if set, only store the messages of -partial-types of the feeds that are this many hops or further away")
+	flag.StringVar(&flagPartialTypes, "partial-types", "contact,about", "comma separated content types to store of partially replicated feeds")
+	flag.Float64Var(&flagScheduleHops, "schedule-hops", mksbot.DefaultScheduleWeights.Hops, "how much closer feeds in the follow graph are fetched first")
+	flag.Float64Var(&flagScheduleLag, "schedule-lag", mksbot.DefaultScheduleWeights.Lag, "how much the feeds we are the furthest behind with are fetched first")
+	flag.IntVar(&flagScheduleFair, "schedule-fair", mksbot.DefaultScheduleWeights.FairEvery, "give every n-th place of the fetch order to one of the less urgent feeds, 0 turns it off")
+	flag.BoolVar(&flagEBT, "ebt", false, "replicate with epidemic broadcast trees (ebt.replicate) where the peer supports it")
+	flag.IntVar(&flagAuthzHops, "authz-hops", 0, "if set, only let peers connect that are at most this many hops away and not blocked")
+	flag.BoolVar(&flagAuthzLists, "authz-lists", false, "check connections and calls against the allow and deny lists in the repo (edit them with the sbotcli block subcommands)")
 
 	flag.StringVar(&appKey, "shscap", "1KHLiKZvAvjbY1ziZEHMXawbCEIM6qwjCDm3VYRan/s=", "secret-handshake app-key (or capability)")
 	flag.StringVar(&hmacSec, "hmac", "", "if set, sign with hmac hash of msg, instead of plain message object, using this key")
 
-	flag.StringVar(&listenAddr, "l", ":8008", "address to listen on")
+	flag.StringVar(&listenAddr, "l", ":8008", "address to listen on, or a comma separated list of them like 0.0.0.0:8008,[::]:8008")
+	flag.StringVar(&wsAddr, "wslisten", "", "if set, also accept connections over websockets on this address, like :8989")
+	flag.StringVar(&wsTLSCert, "wstlscert", "", "certificate file to serve wss with (leave empty behind a TLS terminating proxy)")
+	flag.StringVar(&wsTLSKey, "wstlskey", "", "key file of -wstlscert")
+	flag.StringVar(&flagSOCKS5, "socks5", "", "dial onion: addresses through this SOCKS5 proxy, like the tor daemon on localhost:9050")
+	flag.BoolVar(&flagSOCKS5All, "socks5-all", false, "dial all peers through the -socks5 proxy, also resolving their names there")
+	flag.StringVar(&flagPubAddress, "pub-address", "", "publish a pub message with this host:port, the address the bot is reachable on from the internet, when it changes")
+	flag.BoolVar(&flagPubPrivateAddrs, "pub-private-addrs", false, "accept pub addresses on private networks and loopback, for test networks")
+	flag.StringVar(&flagBandwidthGlobal, "bw-global", "", "limit the bytes per second of all peers together, like 1MB for both directions or 2MB/512KB for in/out")
+	flag.StringVar(&flagBandwidthFollowed, "bw-followed", "", "limit the bytes per second of each connection with a peer we follow, like -bw-global")
+	flag.StringVar(&flagBandwidthStranger, "bw-stranger", "", "limit the bytes per second of each connection with a peer we don't follow, like -bw-global")
+	flag.BoolVar(&flagAcceptLimits, "accept-limits", false, "limit the incoming connections with the -accept-* flags below, behind a reverse proxy only together with -ws-trusted-proxies")
+	flag.StringVar(&flagTrustedProxies, "ws-trusted-proxies", "", "comma separated IP addresses of reverse proxies in front of -wslisten, the accept limits go by their X-Forwarded-For")
+	flag.IntVar(&flagAcceptHandshakes, "accept-max-handshakes", 0, fmt.Sprintf("how many incoming handshakes may run at once, more connections are closed (0 is %d, -1 no limit)", network.DefaultMaxHandshakes))
+	flag.Float64Var(&flagAcceptRate, "accept-rate", 0, fmt.Sprintf("how many new connections per second one IP address may open after -accept-burst (0 is %g, -1 no limit)", network.DefaultPerIPRate))
+	flag.IntVar(&flagAcceptBurst, "accept-burst", 0, fmt.Sprintf("how many connections one IP address may open in a row (0 is %d)", network.DefaultPerIPBurst))
+	flag.IntVar(&flagAcceptBanAfter, "accept-ban-after", 0, fmt.Sprintf("ban an IP address after this many failed handshakes in a row (0 is %d, -1 never)", network.DefaultBanAfter))
+	flag.DurationVar(&flagAcceptBanFor, "accept-ban-for", 0, fmt.Sprintf("how long -accept-ban-after bans an address (0 is %s)", network.DefaultBanFor))
+	flag.IntVar(&flagMaxConns, "maxconns", 0, "if set, keep at most this many connections and dial known peers on our own")
+	flag.StringVar(&flagSticky, "sticky", "", "comma separated @feeds whose connections are never closed to make room (needs -maxconns)")
 	flag.BoolVar(&flagEnAdv, "localadv", false, "enable sending local UDP brodcasts")
 	flag.BoolVar(&flagEnDiscov, "localdiscov", false, "enable connecting to incomming UDP brodcasts")
 
 	flag.BoolVar(&flagDecryptPrivate, "decryptprivate", false, "store which messages can be decrypted")
+	flag.BoolVar(&flagNoAutoBox, "noautobox", false, "publish content with recps as it is, instead of encrypting it to them")
+	flag.BoolVar(&flagAutoBox2, "autobox2", false, "encrypt published content with recps of feeds only with box2 instead of box1")
 	flag.BoolVar(&flagDisableUNIXSock, "nounixsock", false, "disable the UNIX socket RPC interface")
+	flag.BoolVar(&flagPluginHost, "pluginhost", false, "let other processes serve methods through plugins.sock in the repo")
+	flag.Int64Var(&flagBlobsMaxSize, "blobs-maxsize", 0, "if set, delete the least recently used blobs once all of them take more bytes than this")
+	flag.DurationVar(&flagBlobsMaxAge, "blobs-maxage", 0, "if set, delete blobs that weren't accessed for this long")
+	flag.UintVar(&flagBlobsLimit, "blobs-limit", blobstore.DefaultMaxSize, "the size in bytes of the biggest blob that is fetched from peers or stored with blobs.add")
+	flag.StringVar(&flagBlobsBackend, "blobs-backend", blobstore.DefaultBackend, "where to keep blobs, one of: "+strings.Join(blobstore.Backends(), ", "))
+	flag.StringVar(&flagBlobsOpts, "blobs-backend-opts", "", "settings of the blob backend, like endpoint=http://localhost:9000,bucket=blobs for s3 or verify=true for fs")
+	flag.DurationVar(&flagBlobsPush, "blobs-push", 0, "if set, announce blobs our recent messages link to to peers within -hops, one every this long, and fetch the ones they announce")
 
 	flag.StringVar(&repoDir, "repo", filepath.Join(u.HomeDir, ".ssb-go"), "where to put the log and indexes")
 
 	flag.StringVar(&debugAddr, "dbg", "localhost:6078", "listen addr for metrics and pprof HTTP server")
 	flag.StringVar(&dbgLogDir, "dbgdir", "", "where to write debug output to")
 
-	flag.BoolVar(&flagFatBot, "fatbot", false, "if set, sbot loads additional index plugins (bytype, get, tangles)")
+	flag.BoolVar(&flagFatBot, "fatbot", false, "if set, sbot loads additional index plugins (bytype, get, tangles, backlinks, query, feedstream)")
 	flag.BoolVar(&flagReindex, "reindex", false, "if set, sbot exits after having its indicies updated")
 
 	flag.BoolVar(&flagCleanup, "cleanup", false, "remove blocked feeds")
+	flag.BoolVar(&flagDeleteBlocked, "delete-blocked", false, "remove the messages of feeds while the bot runs, once they are blocked")
 
-	flag.StringVar(&flagFSCK, "fsck", "", "run a filesystem check on the repo (possible values: length, sequences)")
+	flag.StringVar(&flagFSCK, "fsck", "", "run a filesystem check on the repo (possible values: length, sequences, verify)")
 	flag.BoolVar(&flagRepair, "repair", false, "run repo healing if fsck fails")
 
 	flag.BoolVar(&flagPrintVersion, "version", false, "print version number and build date")
@@ -160,18 +252,155 @@ func runSbot() error {
 	opts := []mksbot.Option{
 		mksbot.WithHops(flagHops),
 		mksbot.WithPromisc(flagPromisc),
+		mksbot.EnableEBT(flagEBT),
+		mksbot.WithReplicateStrangers(flagStranger),
 		mksbot.WithInfo(log),
 		mksbot.WithAppKey(ak),
 		mksbot.WithRepoPath(repoDir),
-		mksbot.WithListenAddr(listenAddr),
+		mksbot.WithListenAddrs(strings.Split(listenAddr, ",")...),
 		mksbot.EnableAdvertismentBroadcasts(flagEnAdv),
 		mksbot.EnableAdvertismentDialing(flagEnDiscov),
+		mksbot.EnableAutoBox(!flagNoAutoBox),
+		mksbot.WithAutoBox2(flagAutoBox2),
+		mksbot.DeleteBlockedFeeds(flagDeleteBlocked),
+		mksbot.WithScheduleWeights(mksbot.ScheduleWeights{
+			Hops:      flagScheduleHops,
+			Lag:       flagScheduleLag,
+			FairEvery: flagScheduleFair,
+		}),
+	}
+
+	if flagPartialHops > 0 {
+		var types []string
+		for _, t := range strings.Split(flagPartialTypes, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				types = append(types, t)
+			}
+		}
+		if len(types) == 0 {
+			return errors.New("partial-types: need at least one type")
+		}
+		opts = append(opts, mksbot.WithHopPolicy(flagPartialHops, mksbot.HopPolicy{Types: types}))
+	}
+
+	if flagMaxConns > 0 {
+		var sticky []*ssb.FeedRef
+		for _, ref := range strings.Split(flagSticky, ",") {
+			if ref = strings.TrimSpace(ref); ref == "" {
+				continue
+			}
+			fr, err := ssb.ParseFeedRef(ref)
+			if err != nil {
+				return errors.Wrap(err, "sticky")
+			}
+			sticky = append(sticky, fr)
+		}
+		opts = append(opts, mksbot.WithConnManager(sticky, network.MaxConnections(flagMaxConns)))
+	} else if flagSticky != "" {
+		return errors.New("-sticky needs -maxconns")
+	}
+
+	if flagSOCKS5 != "" {
+		opts = append(opts, mksbot.WithSOCKS5Proxy(flagSOCKS5, flagSOCKS5All))
+	} else if flagSOCKS5All {
+		return errors.New("-socks5-all needs -socks5")
+	}
+
+	if flagBandwidthGlobal != "" || flagBandwidthFollowed != "" || flagBandwidthStranger != "" {
+		var limits [3]network.RateLimit
+		for i, f := range []struct{ name, value string }{
+			{"bw-global", flagBandwidthGlobal},
+			{"bw-followed", flagBandwidthFollowed},
+			{"bw-stranger", flagBandwidthStranger},
+		} {
+			rl, err := parseRateLimit(f.value)
+			if err != nil {
+				return errors.Wrap(err, f.name)
+			}
+			limits[i] = rl
+		}
+		opts = append(opts, mksbot.WithBandwidthLimits(limits[0], limits[1], limits[2]))
+	}
+
+	if flagAcceptLimits {
+		limits := network.AcceptLimits{
+			Enabled:       true,
+			MaxHandshakes: flagAcceptHandshakes,
+			PerIPRate:     flagAcceptRate,
+			PerIPBurst:    flagAcceptBurst,
+			BanAfter:      flagAcceptBanAfter,
+			BanFor:        flagAcceptBanFor,
+		}
+		if flagTrustedProxies != "" {
+			limits.TrustedProxies = strings.Split(flagTrustedProxies, ",")
+		}
+		opts = append(opts, mksbot.WithAcceptLimits(limits))
+	}
+
+	if flagPubAddress != "" {
+		opts = append(opts, mksbot.WithPubAnnouncement(flagPubAddress))
+	}
+	if flagPubPrivateAddrs {
+		opts = append(opts, mksbot.WithPrivatePubAddresses(true))
+	}
+
+	if flagAuthzHops > 0 {
+		opts = append(opts, mksbot.WithAuthzHops(flagAuthzHops))
+	}
+
+	if flagAuthzLists {
+		lists, err := authz.OpenLists(authz.ListsPath(repo.New(repoDir)))
+		if err != nil {
+			return err
+		}
+		opts = append(opts,
+			mksbot.WithConnAuthorizer(lists),
+			mksbot.WithCallAuthorizer(lists),
+		)
+	}
+
+	if wsAddr != "" {
+		opts = append(opts, mksbot.WithWebsocketAddr(wsAddr))
+		if wsTLSCert != "" || wsTLSKey != "" {
+			opts = append(opts, mksbot.WithWebsocketTLS(wsTLSCert, wsTLSKey))
+		}
 	}
 
 	if !flagDisableUNIXSock {
 		opts = append(opts, mksbot.LateOption(mksbot.WithUNIXSocket()))
 	}
 
+	if flagPluginHost {
+		opts = append(opts, mksbot.LateOption(mksbot.WithPluginHost()))
+	}
+
+	if flagBlobsMaxSize > 0 || flagBlobsMaxAge > 0 {
+		opts = append(opts, mksbot.WithBlobGC(
+			blobstore.GCWithMaxSize(flagBlobsMaxSize),
+			blobstore.GCWithMaxAge(flagBlobsMaxAge),
+		))
+	}
+
+	if flagBlobsLimit != blobstore.DefaultMaxSize {
+		opts = append(opts, mksbot.WithBlobSizeLimit(flagBlobsLimit))
+	}
+
+	if flagBlobsPush > 0 {
+		opts = append(opts, mksbot.WithBlobPush(flagHops, flagBlobsPush))
+	}
+
+	if flagBlobsBackend != blobstore.DefaultBackend || flagBlobsOpts != "" {
+		backendOpts, err := blobstore.ParseBackendOptions(flagBlobsOpts)
+		if err != nil {
+			return errors.Wrap(err, "blobs-backend-opts")
+		}
+		bs, err := repo.OpenBlobBackend(repo.New(repoDir), flagBlobsBackend, backendOpts)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, mksbot.WithBlobStore(bs))
+	}
+
 	if flagDecryptPrivate {
 		// TODO: refactor into plugins2
 		r := repo.New(repoDir)
@@ -180,20 +409,33 @@ func runSbot() error {
 			return errors.Wrap(err, "sbot: failed to open all keypairs in repo")
 		}
 
-		var kps []*ssb.KeyPair
+		var kms []*keys.Manager
 		for _, v := range kpsByPath {
-			kps = append(kps, v)
+			kms = append(kms, keys.NewManager(v))
 		}
 
 		defKP, err := repo.DefaultKeyPair(r)
 		if err != nil {
 			return errors.Wrap(err, "sbot: failed to open default keypair")
 		}
-		kps = append(kps, defKP)
+		// shared with the private plugin, so that the index reads what it publishes to groups and the groups it learns are kept
+		defKeys, err := keys.OpenManager(defKP, r.GetPath("groups.json"))
+		if err != nil {
+			return errors.Wrap(err, "sbot: failed to open the keys of the groups")
+		}
+		kms = append(kms, defKeys)
+		opts = append(opts, mksbot.WithKeyManager(defKeys), mksbot.WithPrivateIndexKeys(kms...))
 
-		mlogPriv := multilogs.NewPrivateRead(kitlog.With(log, "module", "privLogs"), kps...)
+		mlogPriv := multilogs.NewPrivateReadWithKeys(kitlog.With(log, "module", "privLogs"), kms...)
 
-		opts = append(opts, mksbot.LateOption(mksbot.MountMultiLog("privLogs", mlogPriv.OpenRoaring)))
+		// the groups we are added to are checked against their init messages, which are looked up by key
+		if !flagFatBot {
+			opts = append(opts, mksbot.LateOption(mksbot.MountSimpleIndex("get", indexes.OpenGet)))
+		}
+		opts = append(opts, mksbot.LateOption(func(s *mksbot.Sbot) error {
+			mlogPriv.SetGetter(s)
+			return mksbot.MountMultiLog("privLogs", mlogPriv.OpenRoaring)(s)
+		}))
 	}
 
 	if flagFatBot {
@@ -202,6 +444,9 @@ func runSbot() error {
 			mksbot.LateOption(mksbot.MountPlugin(&tangles.Plugin{}, plugins2.AuthMaster)),
 			mksbot.LateOption(mksbot.MountPlugin(&names.Plugin{}, plugins2.AuthMaster)),
 			mksbot.LateOption(mksbot.MountPlugin(&bytype.Plugin{}, plugins2.AuthMaster)),
+			mksbot.LateOption(mksbot.MountPlugin(&backlinks.Plugin{}, plugins2.AuthMaster)),
+			mksbot.LateOption(mksbot.MountPlugin(&query.Plugin{}, plugins2.AuthMaster)),
+			mksbot.LateOption(mksbot.MountPlugin(&feedstream.Plugin{}, plugins2.AuthMaster)),
 		)
 	}
 
@@ -227,6 +472,7 @@ func runSbot() error {
 	if debugAddr != "" {
 		opts = append(opts,
 			mksbot.WithEventMetrics(SystemEvents, RepoStats, SystemSummary),
+			mksbot.WithTrafficMetrics(PeerTraffic),
 			mksbot.WithPreSecureConnWrapper(promCountConn()),
 		)
 	}
@@ -250,13 +496,12 @@ func runSbot() error {
 		sig := <-c
 		level.Warn(log).Log("event", "killed", "msg", "received signal, shutting down", "signal", sig.String())
 		cancel()
-		sbot.Shutdown()
-		time.Sleep(2 * time.Second)
 
-		err := sbot.Close()
+		shutdownCtx, shutdownDone := context.WithTimeout(context.Background(), 10*time.Second)
+		err := sbot.GracefulShutdown(shutdownCtx)
+		shutdownDone()
 		checkAndLog(err)
 
-		time.Sleep(2 * time.Second)
 		os.Exit(0)
 	}()
 	logging.SetCloseChan(c)
@@ -279,6 +524,8 @@ func runSbot() error {
 			fsckMode = mksbot.FSCKModeSequences
 		case "length":
 			fsckMode = mksbot.FSCKModeLength
+		case "verify":
+			fsckMode = mksbot.FSCKModeVerify
 		default:
 			return fmt.Errorf("unknown fsck mode: %q", flagFSCK)
 		}
@@ -319,6 +566,19 @@ func runSbot() error {
 			if err != nil {
 				return errors.Wrap(err, "fsck: failed to halt sbot after repo heal")
 			}
+		case mksbot.ErrBrokenFeeds:
+			for _, p := range report.Feeds {
+				err = sbot.TruncateFeed(p.Feed, p.FirstBad-1)
+				if err != nil {
+					return errors.Wrap(err, "fsck: failed to truncate broken feed")
+				}
+				level.Info(log).Log("fsck", "truncated", "feed", p.Feed.Ref(), "seq", p.FirstBad-1)
+			}
+			sbot.Shutdown()
+			err := sbot.Close()
+			if err != nil {
+				return errors.Wrap(err, "fsck: failed to halt sbot after truncating feeds")
+			}
 		default:
 			level.Error(log).Log("fsck", "wrong report type", "T", fmt.Sprintf("%T", err))
 
@@ -417,6 +677,28 @@ func runSbot() error {
 	}
 }
 
+// parseRateLimit parses IN[/OUT] bytes per second, like 1MB or 2MB/512KB. The empty string doesn't limit.
+func parseRateLimit(s string) (network.RateLimit, error) {
+	var rl network.RateLimit
+	if s == "" {
+		return rl, nil
+	}
+	in, out := s, s
+	if i := strings.Index(s, "/"); i >= 0 {
+		in, out = s[:i], s[i+1:]
+	}
+	inBytes, err := humanize.ParseBytes(in)
+	if err != nil {
+		return rl, errors.Wrapf(err, "invalid rate %q", in)
+	}
+	outBytes, err := humanize.ParseBytes(out)
+	if err != nil {
+		return rl, errors.Wrapf(err, "invalid rate %q", out)
+	}
+	rl.In, rl.Out = int64(inBytes), int64(outBytes)
+	return rl, nil
+}
+
 func main() {
 	if err := runSbot(); err != nil {
 		fmt.Fprintf(os.Stderr, "go-sbot: %s\n", err)
@@ -22,6 +22,7 @@ var (
 	SystemEvents  *prometheus.Counter
 	SystemSummary *prometheus.Summary
 	RepoStats     *prometheus.Gauge
+	PeerTraffic   *prometheus.Counter
 
 	muxrpcSummary *prometheus.Summary
 )
@@ -70,6 +71,12 @@ func startDebug() {
 		Name:      "ssb_repostats",
 	}, []string{"part"})
 
+	PeerTraffic = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "gossb",
+		Subsystem: "network",
+		Name:      "peer_traffic_bytes",
+	}, []string{"direction", "class"})
+
 	muxrpcSummary = prometheus.NewSummaryFrom(stdprometheus.SummaryOpts{
 		Namespace: "gossb",
 		Subsystem: "muxrpc",
@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/muxrpc"
+	"go.cryptoscope.co/ssb"
+	ssbClient "go.cryptoscope.co/ssb/client"
+	cli "gopkg.in/urfave/cli.v2"
+)
+
+var afterFlag = cli.StringFlag{Name: "after", Usage: "only stream messages that come after this message key"}
+
+var untilKeyFlag = cli.StringFlag{Name: "until-key", Usage: "stop the stream at this message key, without it. with --reverse: the messages newer than it"}
+
+// resolveAfter looks up the message behind --after with get and returns its author and sequence
+func resolveAfter(client *ssbClient.Client, key string) (*ssb.FeedRef, int64, error) {
+	ref, err := ssb.ParseMessageRef(key)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "after: invalid message reference %q", key)
+	}
+
+	v, err := client.Async(longctx, mapMsg{}, muxrpc.Method{"get"}, ref.Ref())
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "after: failed to get %s", ref.Ref())
+	}
+	msg, ok := asMapMsg(v)
+	if !ok {
+		return nil, 0, errors.Errorf("after: unexpected get reply %T", v)
+	}
+
+	authorStr, ok := msg["author"].(string)
+	if !ok {
+		return nil, 0, errors.Errorf("after: get reply for %s has no author", ref.Ref())
+	}
+	author, err := ssb.ParseFeedRef(authorStr)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "after: invalid author in get reply for %s", ref.Ref())
+	}
+	seq, ok := msg["sequence"].(float64)
+	if !ok {
+		return nil, 0, errors.Errorf("after: get reply for %s has no sequence", ref.Ref())
+	}
+	return author, int64(seq), nil
+}
+
+// keySkipper drops everything up to and including the message with the wanted key.
+// It's used for the receive log, where get can't tell us the sequence of a message.
+// The stream has to be opened with keys:true; if keepKeys is false only the values are passed on.
+type keySkipper struct {
+	snk      luigi.Sink
+	key      string
+	keepKeys bool
+
+	found bool
+}
+
+func (ks *keySkipper) Pour(ctx context.Context, v interface{}) error {
+	kv, ok := asMapMsg(v)
+	if !ok {
+		return errors.Errorf("after: unexpected stream value %T", v)
+	}
+	if !ks.found {
+		ks.found = kv["key"] == ks.key
+		return nil
+	}
+
+	if !ks.keepKeys {
+		return ks.snk.Pour(ctx, kv["value"])
+	}
+	return ks.snk.Pour(ctx, kv)
+}
+
+func (ks *keySkipper) Close() error { return ks.snk.Close() }
+
+// keyStopper ends the stream right before the message with the wanted key and cancels the call.
+// Like with keySkipper the stream has to be opened with keys:true.
+type keyStopper struct {
+	src      luigi.Source
+	cancel   context.CancelFunc
+	key      string
+	keepKeys bool
+
+	found bool
+}
+
+func (ks *keyStopper) Next(ctx context.Context) (interface{}, error) {
+	if ks.found {
+		return nil, luigi.EOS{}
+	}
+	v, err := ks.src.Next(ctx)
+	if err != nil {
+		return nil, err
+	}
+	kv, ok := asMapMsg(v)
+	if !ok {
+		return nil, errors.Errorf("until-key: unexpected stream value %T", v)
+	}
+	if kv["key"] == ks.key {
+		ks.found = true
+		ks.cancel()
+		return nil, luigi.EOS{}
+	}
+
+	if !ks.keepKeys {
+		return kv["value"], nil
+	}
+	return kv, nil
+}
+
+// asMapMsg deals with muxrpc returning either the type or a pointer to it
+func asMapMsg(v interface{}) (mapMsg, bool) {
+	switch tv := v.(type) {
+	case mapMsg:
+		return tv, true
+	case *mapMsg:
+		return *tv, tv != nil
+	case map[string]interface{}:
+		return mapMsg(tv), true
+	}
+	return nil, false
+}
@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/ssb"
+	cli "gopkg.in/urfave/cli.v2"
+)
+
+var backlinksCmd = &cli.Command{
+	Name:      "backlinks",
+	Usage:     "stream the messages that link to a message, like replies, votes and mentions",
+	ArgsUsage: "%key",
+	UsageText: "uses the backlinks index of the bot (go-sbot -fatbot) if it has one and scans the whole log otherwise",
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "rel", Usage: "only follow links of this field: root, branch, vote or mentions"},
+	},
+	Action: func(ctx *cli.Context) error {
+		ref := ctx.Args().Get(0)
+		if ref == "" {
+			return errors.New("backlinks: need the message ref to find links to")
+		}
+		dest, err := ssb.ParseMessageRef(ref)
+		if err != nil {
+			return errors.Wrap(err, "backlinks: failed to parse message ref")
+		}
+
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		src, err := client.Backlinks(dest, ctx.String("rel"))
+		if err != nil {
+			return errors.Wrap(err, "backlinks: failed to start query")
+		}
+
+		out, err := newRenderer(ctx, os.Stdout)
+		if err != nil {
+			return err
+		}
+		for {
+			v, err := src.Next(longctx)
+			if luigi.IsEOS(err) {
+				return nil
+			} else if err != nil {
+				return errors.Wrap(err, "backlinks: failed to get links")
+			}
+			if err := out.Render(v); err != nil {
+				return err
+			}
+		}
+	},
+}
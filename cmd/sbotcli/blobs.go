@@ -1,14 +1,18 @@
 package main
 
 import (
+	"encoding/json"
 	"io"
+	"io/ioutil"
 	"os"
+	"time"
 
 	"github.com/pkg/errors"
-	"github.com/shurcooL/go-goon"
+	"go.cryptoscope.co/luigi"
 	"go.cryptoscope.co/muxrpc"
 	"go.cryptoscope.co/ssb"
 	"go.cryptoscope.co/ssb/blobstore"
+	ssbClient "go.cryptoscope.co/ssb/client"
 	"gopkg.in/urfave/cli.v2"
 )
 
@@ -22,8 +26,8 @@ var blobsCmd = &cli.Command{
 	Before: func(ctx *cli.Context) error {
 		var localRepo = ctx.String("localstore")
 		if localRepo == "" {
-			//blobsStore, err = newClient(ctx)
-			return errors.Errorf("TODO: implement more blobs features on client")
+			// has, want and peek go through the client, the others check for blobsStore
+			return nil
 		}
 		var err error
 		blobsStore, err = blobstore.New(localRepo)
@@ -38,6 +42,13 @@ var blobsCmd = &cli.Command{
 		blobsWantCmd,
 		blobsAddCmd,
 		blobsGetCmd,
+		blobsCatCmd,
+		blobsPeekCmd,
+		blobsListCmd,
+		blobsRefsCmd,
+		blobsOrphansCmd,
+		blobsFsckCmd,
+		blobsAuditCmd,
 	},
 }
 
@@ -63,7 +74,9 @@ var blobsHasCmd = &cli.Command{
 			return errors.Wrapf(err, "connect: async call failed.")
 		}
 		log.Log("event", "blob.has", "r", resp)
-		goon.Dump(resp)
+		if err := render(ctx, resp); err != nil {
+			return err
+		}
 		var ok bool
 		has, ok = resp.(bool)
 		if !ok {
@@ -82,6 +95,12 @@ var blobsHasCmd = &cli.Command{
 var blobsWantCmd = &cli.Command{
 	Name:  "want",
 	Usage: "try to get it from other peers",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{Name: "wait", Usage: "wait until the bot got it and print who sent it"},
+		&cli.DurationFlag{Name: "interval", Value: ssbClient.DefaultBlobsWaitOptions.Interval, Usage: "how often to check at first, doubles after every miss"},
+		&cli.DurationFlag{Name: "max-interval", Value: ssbClient.DefaultBlobsWaitOptions.MaxInterval, Usage: "how long to wait between checks at most"},
+		&cli.DurationFlag{Name: "deadline", Value: ssbClient.DefaultBlobsWaitOptions.Deadline, Usage: "when to give up"},
+	},
 	Action: func(ctx *cli.Context) error {
 		ref := ctx.Args().Get(0)
 		if ref == "" {
@@ -96,13 +115,39 @@ var blobsWantCmd = &cli.Command{
 		if err != nil {
 			return err
 		}
-		return client.BlobsWant(*br)
+		if !ctx.Bool("wait") {
+			return client.BlobsWant(*br)
+		}
+
+		from, err := client.BlobsWantAndWait(br, ssbClient.BlobsWaitOptions{
+			Interval:    ctx.Duration("interval"),
+			MaxInterval: ctx.Duration("max-interval"),
+			Deadline:    ctx.Duration("deadline"),
+		})
+		if err != nil {
+			return errors.Wrap(err, "blobs.want: waiting failed")
+		}
+		got := blobWantResult{Ref: br.Ref()}
+		if from != nil {
+			got.From = from.Ref()
+		}
+		return render(ctx, got)
 	},
 }
 
+// blobWantResult is what want --wait prints, From is empty if the bot had it already
+type blobWantResult struct {
+	Ref  string `json:"ref"`
+	From string `json:"from,omitempty"`
+}
+
 var blobsAddCmd = &cli.Command{
 	Name:  "add",
 	Usage: "add a file to the store (use - to open stdin)",
+	Flags: []cli.Flag{
+		&cli.Int64Flag{Name: "max-size", Value: blobstore.DefaultMaxSize, Usage: "refuse files bigger than this, since peers won't fetch them"},
+		&cli.BoolFlag{Name: "no-limit", Usage: "store it no matter how big it is, for instance for private blobs"},
+	},
 	Action: func(ctx *cli.Context) error {
 		if blobsStore == nil {
 			return errors.Errorf("no blobstore use 'blobs --localstore $repo/blobs add -' for now")
@@ -123,9 +168,18 @@ var blobsAddCmd = &cli.Command{
 			}
 		}
 
+		maxSize := ctx.Int64("max-size")
+		if !ctx.Bool("no-limit") {
+			rd = blobstore.LimitReader(rd, maxSize)
+		}
 		ref, err := blobsStore.Put(rd)
+		if errors.Cause(err) == blobstore.ErrBlobTooBig {
+			return errors.Errorf("blobs.add: %s is bigger than the maximum blob size of %d bytes (use --no-limit to store it anyway)", fname, maxSize)
+		} else if err != nil {
+			return errors.Wrap(err, "blobs.add: failed to store blob")
+		}
 		log.Log("blobs.add", ref.Ref())
-		return err
+		return nil
 	},
 }
 
@@ -151,6 +205,10 @@ var blobsGetCmd = &cli.Command{
 		if err != nil {
 			return errors.Wrap(err, "blobs: failed to parse argument ref")
 		}
+		if c, ok := rd.(io.Closer); ok {
+			defer c.Close()
+		}
+		size, _ := blobsStore.Size(br) // zero leaves the progress without a total
 
 		var out io.Writer
 		outName := ctx.String("out")
@@ -164,8 +222,141 @@ var blobsGetCmd = &cli.Command{
 			}
 		}
 
-		n, err := io.Copy(out, rd)
-		log.Log("blobs.get", br.Ref(), "written", n)
+		// the data might go to stdout, so only the progress says how it went
+		prog := newProgress(ctx, "bytes", size)
+		_, err = io.Copy(out, io.TeeReader(rd, prog))
+		prog.Done()
+		return err
+	},
+}
+
+var blobsPeekCmd = &cli.Command{
+	Name:  "peek",
+	Usage: "prints the first bytes of a blob to stdout",
+	Flags: []cli.Flag{
+		&cli.Int64Flag{Name: "bytes", Value: 1024, Usage: "how many bytes to print"},
+	},
+	Action: func(ctx *cli.Context) error {
+		ref := ctx.Args().Get(0)
+		if ref == "" {
+			return errors.New("blobs.peek: need a blob ref")
+		}
+		br, err := ssb.ParseBlobRef(ref)
+		if err != nil {
+			return errors.Wrap(err, "blobs: failed to parse argument ref")
+		}
+		n := ctx.Int64("bytes")
+		if n <= 0 {
+			return errors.New("blobs.peek: --bytes needs to be positive")
+		}
+
+		var data []byte
+		if blobsStore != nil {
+			rd, err := blobsStore.Get(br)
+			if err != nil {
+				return errors.Wrap(err, "blobs.peek: failed to open blob")
+			}
+			if c, ok := rd.(io.Closer); ok {
+				defer c.Close()
+			}
+			data, err = ioutil.ReadAll(io.LimitReader(rd, n))
+			if err != nil {
+				return errors.Wrap(err, "blobs.peek: failed to read blob")
+			}
+		} else {
+			client, err := newClient(ctx)
+			if err != nil {
+				return err
+			}
+			data, err = client.BlobsGetSlice(br, 0, n)
+			if err != nil {
+				return errors.Wrap(err, "blobs.peek: failed to get blob")
+			}
+		}
+
+		_, err = os.Stdout.Write(data)
 		return err
 	},
 }
+
+var blobsListCmd = &cli.Command{
+	Name:  "ls",
+	Usage: "list the stored blobs",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{Name: "long", Usage: "also print size and when each blob was added"},
+	},
+	Action: func(ctx *cli.Context) error {
+		long := ctx.Bool("long")
+		out, err := newRenderer(ctx, os.Stdout)
+		if err != nil {
+			return err
+		}
+
+		var src luigi.Source
+		if blobsStore != nil {
+			if long {
+				src = blobsStore.ListMeta()
+			} else {
+				src = blobsStore.List()
+			}
+		} else {
+			client, err := newClient(ctx)
+			if err != nil {
+				return err
+			}
+			var args []interface{}
+			if long {
+				args = append(args, map[string]interface{}{"meta": true})
+			}
+			src, err = client.Source(longctx, json.RawMessage{}, muxrpc.Method{"blobs", "ls"}, args...)
+			if err != nil {
+				return errors.Wrap(err, "blobs.ls: source stream call failed")
+			}
+		}
+
+		for {
+			v, err := src.Next(longctx)
+			if luigi.IsEOS(err) {
+				return nil
+			} else if err != nil {
+				return errors.Wrap(err, "blobs.ls: failed to read list")
+			}
+			if err := out.Render(blobListEntry(v)); err != nil {
+				return err
+			}
+		}
+	},
+}
+
+// blobListEntry turns what the local store or the bot returns into the same shape
+func blobListEntry(v interface{}) interface{} {
+	switch tv := v.(type) {
+	case *ssb.BlobRef:
+		return tv.Ref()
+	case ssb.BlobMeta:
+		return blobMeta{ID: tv.Ref.Ref(), Size: tv.Size, Added: tv.Added}
+	case json.RawMessage:
+		var m struct {
+			ID   string `json:"id"`
+			Size int64  `json:"size"`
+			TS   int64  `json:"ts"`
+		}
+		if err := json.Unmarshal(tv, &m); err != nil || m.ID == "" {
+			// just the ref
+			var ref string
+			if json.Unmarshal(tv, &ref) == nil {
+				return ref
+			}
+			return tv
+		}
+		return blobMeta{ID: m.ID, Size: m.Size, Added: time.Unix(0, m.TS*int64(time.Millisecond))}
+	default:
+		return v
+	}
+}
+
+type blobMeta struct {
+	ID    string    `json:"id"`
+	Size  int64     `json:"size"`
+	Added time.Time `json:"added"`
+}
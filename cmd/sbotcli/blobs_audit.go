@@ -0,0 +1,212 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"net"
+	"os"
+	"sort"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/netwrap"
+	"go.cryptoscope.co/secretstream"
+	"go.cryptoscope.co/ssb"
+	ssbClient "go.cryptoscope.co/ssb/client"
+	cli "gopkg.in/urfave/cli.v2"
+)
+
+var blobsAuditCmd = &cli.Command{
+	Name:  "audit",
+	Usage: "compare the blobs we have with the ones of a peer, without transferring any",
+	Description: `The peer only lists its blobs if our key is its master, like for our own pub.
+Otherwise only the blobs it is missing are reported, with blobs.has.`,
+	UsageText: "blobs audit --via @peer.ed25519 --via-addr host:8008",
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "via", Usage: "the @feed of the peer to compare with"},
+		&cli.StringFlag{Name: "via-addr", Value: "localhost:8008", Usage: "tcp address of the peer"},
+		&cli.IntFlag{Name: "batch", Value: 100, Usage: "how many blobs to check with one blobs.has call"},
+	},
+	Action: func(ctx *cli.Context) error {
+		peerRef, err := ssb.ParseFeedRef(ctx.String("via"))
+		if err != nil {
+			return errors.Wrap(err, "blobs.audit: need the @feed of the peer with --via")
+		}
+		batch := ctx.Int("batch")
+		if batch < 1 {
+			return errors.New("blobs.audit: --batch has to be at least one")
+		}
+
+		// our inventory comes from the local store or our bot
+		var local []*ssb.BlobRef
+		var localHas func([]*ssb.BlobRef) ([]bool, error)
+		if blobsStore != nil {
+			local, err = storeBlobs(blobsStore)
+			if err != nil {
+				return errors.Wrap(err, "blobs.audit: failed to list local blobs")
+			}
+			localHas = func(refs []*ssb.BlobRef) ([]bool, error) {
+				has := make([]bool, len(refs))
+				for i, ref := range refs {
+					_, err := blobsStore.Size(ref)
+					has[i] = err == nil
+				}
+				return has, nil
+			}
+		} else {
+			client, err := newClient(ctx)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+			local, err = client.BlobsList()
+			if err != nil {
+				return errors.Wrap(err, "blobs.audit: failed to list the blobs of our bot")
+			}
+			localHas = client.BlobsHasAll
+		}
+
+		peer, err := dialPeer(ctx, peerRef, ctx.String("via-addr"))
+		if err != nil {
+			return err
+		}
+		defer peer.Close()
+		// blobs.ls is only for the master of the peer, without it all our blobs are checked with blobs.has
+		remote, err := peer.BlobsList()
+		listed := err == nil
+		if !listed {
+			level.Warn(log).Log("event", "blobs.audit", "msg", "peer doesn't list its blobs to us, only checking ours",
+				"peer", peerRef.ShortRef(), "err", err)
+		}
+
+		localOnly, remoteOnly := diffBlobs(local, remote)
+
+		// the lists can be outdated by the time they are compared, so ask again before reporting
+		localOnly, err = stillMissing(localOnly, peer.BlobsHasAll, batch)
+		if err != nil {
+			return errors.Wrap(err, "blobs.audit: failed to check blobs at the peer")
+		}
+		remoteOnly, err = stillMissing(remoteOnly, localHas, batch)
+		if err != nil {
+			return errors.Wrap(err, "blobs.audit: failed to check local blobs")
+		}
+
+		out, err := newRenderer(ctx, os.Stdout)
+		if err != nil {
+			return err
+		}
+		for _, ref := range localOnly {
+			if err := out.Render(blobAuditEntry{Ref: ref.Ref(), Missing: "remote"}); err != nil {
+				return err
+			}
+		}
+		for _, ref := range remoteOnly {
+			if err := out.Render(blobAuditEntry{Ref: ref.Ref(), Missing: "local"}); err != nil {
+				return err
+			}
+		}
+		if !listed {
+			log.Log("event", "blobs.audit", "peer", peerRef.ShortRef(),
+				"local", len(local), "missing-remote", len(localOnly))
+			return nil
+		}
+		log.Log("event", "blobs.audit", "peer", peerRef.ShortRef(),
+			"local", len(local), "remote", len(remote),
+			"missing-remote", len(localOnly), "missing-local", len(remoteOnly))
+		return nil
+	},
+}
+
+type blobAuditEntry struct {
+	Ref     string `json:"ref"`
+	Missing string `json:"missing"`
+}
+
+// dialPeer connects to another peer with our key instead of to our own bot
+func dialPeer(ctx *cli.Context, peer *ssb.FeedRef, addr string) (*ssbClient.Client, error) {
+	localKey, err := ssb.LoadKeyPair(ctx.String("key"))
+	if err != nil {
+		return nil, err
+	}
+	plainAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to resolve address of %s", peer.ShortRef())
+	}
+	shsAddr := netwrap.WrapAddr(plainAddr, secretstream.Addr{PubKey: peer.PubKey()})
+	client, err := ssbClient.NewTCP(localKey, shsAddr,
+		ssbClient.WithSHSAppKey(ctx.String("shscap")),
+		ssbClient.WithContext(longctx))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to %s", shsAddr.String())
+	}
+	return client, nil
+}
+
+// storeBlobs lists the refs in a local store
+func storeBlobs(bs ssb.BlobStore) ([]*ssb.BlobRef, error) {
+	src := bs.List()
+	var refs []*ssb.BlobRef
+	for {
+		v, err := src.Next(longctx)
+		if luigi.IsEOS(err) {
+			return refs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		ref, ok := v.(*ssb.BlobRef)
+		if !ok {
+			return nil, errors.Errorf("unexpected list entry %T", v)
+		}
+		refs = append(refs, ref)
+	}
+}
+
+// diffBlobs returns the refs only in a and the ones only in b, sorted
+func diffBlobs(a, b []*ssb.BlobRef) ([]*ssb.BlobRef, []*ssb.BlobRef) {
+	inA := make(map[string]*ssb.BlobRef, len(a))
+	for _, ref := range a {
+		inA[ref.Ref()] = ref
+	}
+	inB := make(map[string]*ssb.BlobRef, len(b))
+	for _, ref := range b {
+		inB[ref.Ref()] = ref
+	}
+
+	only := func(these, other map[string]*ssb.BlobRef) []*ssb.BlobRef {
+		var keys []string
+		for k := range these {
+			if _, has := other[k]; !has {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		refs := make([]*ssb.BlobRef, len(keys))
+		for i, k := range keys {
+			refs[i] = these[k]
+		}
+		return refs
+	}
+	return only(inA, inB), only(inB, inA)
+}
+
+// stillMissing asks has in batches and keeps the refs it doesn't have
+func stillMissing(refs []*ssb.BlobRef, has func([]*ssb.BlobRef) ([]bool, error), batch int) ([]*ssb.BlobRef, error) {
+	var missing []*ssb.BlobRef
+	for start := 0; start < len(refs); start += batch {
+		end := start + batch
+		if end > len(refs) {
+			end = len(refs)
+		}
+		answers, err := has(refs[start:end])
+		if err != nil {
+			return nil, err
+		}
+		for i, yes := range answers {
+			if !yes {
+				missing = append(missing, refs[start+i])
+			}
+		}
+	}
+	return missing, nil
+}
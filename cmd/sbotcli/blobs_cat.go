@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/ssb"
+	cli "gopkg.in/urfave/cli.v2"
+)
+
+var blobsCatCmd = &cli.Command{
+	Name:      "cat",
+	Usage:     "print a small blob after checking its hash, for instance base64 encoded",
+	UsageText: "blobs cat --base64 &...sha256",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{Name: "base64", Usage: "print the blob base64 encoded"},
+		&cli.Int64Flag{Name: "max-size", Value: 64 * 1024, Usage: "refuse blobs bigger than this"},
+	},
+	Action: func(ctx *cli.Context) error {
+		br, err := ssb.ParseBlobRef(ctx.Args().Get(0))
+		if err != nil {
+			return errors.Wrap(err, "blobs.cat: need a blob ref")
+		}
+		maxSize := ctx.Int64("max-size")
+		if maxSize <= 0 {
+			return errors.New("blobs.cat: --max-size needs to be positive")
+		}
+
+		var (
+			size int64
+			rd   io.Reader
+		)
+		if blobsStore != nil {
+			size, err = blobsStore.Size(br)
+			if err != nil {
+				return errors.Wrap(err, "blobs.cat: failed to get blob size")
+			}
+			if size > maxSize {
+				return errBlobTooBigToCat(br, size, maxSize)
+			}
+			rd, err = blobsStore.Get(br)
+		} else {
+			client, cerr := newClient(ctx)
+			if cerr != nil {
+				return cerr
+			}
+			defer client.Close()
+			size, err = client.BlobsSize(br)
+			if err != nil {
+				return errors.Wrap(err, "blobs.cat: failed to get blob size")
+			}
+			if size > maxSize {
+				return errBlobTooBigToCat(br, size, maxSize)
+			}
+			rd, err = client.BlobsGet(br)
+		}
+		if err != nil {
+			return errors.Wrap(err, "blobs.cat: failed to open blob")
+		}
+		if c, ok := rd.(io.Closer); ok {
+			defer c.Close()
+		}
+
+		// one more byte to notice if it grew
+		data, err := ioutil.ReadAll(io.LimitReader(rd, maxSize+1))
+		if err != nil {
+			return errors.Wrap(err, "blobs.cat: failed to read blob")
+		}
+		if int64(len(data)) > maxSize {
+			return errBlobTooBigToCat(br, int64(len(data)), maxSize)
+		}
+		if sum := sha256.Sum256(data); !bytes.Equal(sum[:], br.Hash) {
+			return errors.Errorf("blobs.cat: %s doesn't match its hash", br.Ref())
+		}
+
+		if ctx.Bool("base64") {
+			_, err = io.WriteString(os.Stdout, base64.StdEncoding.EncodeToString(data)+"\n")
+			return err
+		}
+		if isTerminal(os.Stdout) {
+			data = terminalSafe(data)
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	},
+}
+
+func errBlobTooBigToCat(br *ssb.BlobRef, size, max int64) error {
+	return errors.Errorf("blobs.cat: %s has %d bytes, more than --max-size %d (use blobs get --out for big blobs)", br.Ref(), size, max)
+}
+
+// isTerminal tells if f is a character device, which is all we need to know to not mess up someones terminal
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// terminalSafe escapes control characters and invalid utf8 so that binary blobs can't send escape sequences
+func terminalSafe(data []byte) []byte {
+	var out bytes.Buffer
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		switch {
+		case r == '\n' || r == '\t':
+			out.WriteRune(r)
+		case r == utf8.RuneError && size == 1:
+			fmt.Fprintf(&out, `\x%02x`, data[0])
+		case !strconv.IsPrint(r):
+			quoted := strconv.QuoteRuneToASCII(r)
+			out.WriteString(quoted[1 : len(quoted)-1])
+		default:
+			out.Write(data[:size])
+		}
+		data = data[size:]
+	}
+	return out.Bytes()
+}
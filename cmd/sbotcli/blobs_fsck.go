@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"os"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	cli "gopkg.in/urfave/cli.v2"
+
+	"go.cryptoscope.co/ssb/blobstore"
+)
+
+var blobsFsckCmd = &cli.Command{
+	Name:      "fsck",
+	Usage:     "hash all stored blobs and quarantine the ones that don't match their reference",
+	UsageText: "needs --localstore, the corrupt blobs are moved to its quarantine directory and printed",
+	Action: func(ctx *cli.Context) error {
+		if blobsStore == nil {
+			return errors.Errorf("no blobstore use 'blobs --localstore $repo/blobs fsck' for now")
+		}
+		checker, ok := blobsStore.(blobstore.Checker)
+		if !ok {
+			return errors.Errorf("blobs.fsck: can't check blobs of %T", blobsStore)
+		}
+
+		corrupt, err := checker.Fsck(longctx, func(checked, total int) {
+			if checked%100 == 0 || checked == total {
+				level.Info(log).Log("event", "blobs-fsck-progress", "checked", checked, "total", total)
+			}
+		})
+		if err != nil {
+			return errors.Wrap(err, "blobs.fsck: check failed")
+		}
+
+		out, err := newRenderer(ctx, os.Stdout)
+		if err != nil {
+			return err
+		}
+		for _, ref := range corrupt {
+			if err := out.Render(ref.Ref()); err != nil {
+				return err
+			}
+		}
+		if len(corrupt) > 0 {
+			return errors.Errorf("blobs.fsck: %d corrupt blobs", len(corrupt))
+		}
+		return nil
+	},
+}
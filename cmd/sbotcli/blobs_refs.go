@@ -0,0 +1,151 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/margaret/multilog"
+	"go.cryptoscope.co/muxrpc"
+	"go.cryptoscope.co/ssb"
+	cli "gopkg.in/urfave/cli.v2"
+
+	"go.cryptoscope.co/ssb/multilogs"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+var blobsRefsCmd = &cli.Command{
+	Name:      "refs",
+	Usage:     "list the messages that link to a blob",
+	UsageText: "looks up the messages that mention the blob (in mentions, about and the other link fields) in the blobRefs index of the bot and prints their keys",
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "repo", Usage: "read the index of this repo directly instead of asking the bot (works while the bot is stopped)"},
+	},
+	Action: func(ctx *cli.Context) error {
+		ref := ctx.Args().Get(0)
+		if ref == "" {
+			return errors.New("blobs.refs: need a blob ref")
+		}
+		br, err := ssb.ParseBlobRef(ref)
+		if err != nil {
+			return errors.Wrap(err, "blobs.refs: failed to parse argument ref")
+		}
+
+		var keys []string
+		if repoPath := ctx.String("repo"); repoPath != "" {
+			err = withBlobRefs(repoPath, func(rootLog margaret.Log, refs multilog.MultiLog) error {
+				referrers, err := multilogs.BlobReferrers(longctx, rootLog, refs, br)
+				for _, mr := range referrers {
+					keys = append(keys, mr.Ref())
+				}
+				return err
+			})
+		} else {
+			client, cerr := newClient(ctx)
+			if cerr != nil {
+				return cerr
+			}
+			defer client.Close()
+			var v interface{}
+			v, err = client.Async(longctx, []string{}, muxrpc.Method{"blobs", "refs"}, br.Ref())
+			keys, _ = v.([]string)
+		}
+		if err != nil {
+			return errors.Wrap(err, "blobs.refs: failed to look up referrers")
+		}
+
+		out, err := newRenderer(ctx, os.Stdout)
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := out.Render(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// withBlobRefs calls fn with the log and the blobRefs index of the repo at repoPath, opened read-only
+func withBlobRefs(repoPath string, fn func(margaret.Log, multilog.MultiLog) error) error {
+	r := repo.NewReadOnly(repoPath)
+	rootLog, err := repo.OpenLog(r)
+	if err != nil {
+		return errors.Wrap(err, "failed to open log")
+	}
+	defer rootLog.(io.Closer).Close()
+
+	refs, _, err := multilogs.OpenBlobRefs(r)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s index", multilogs.IndexNameBlobRefs)
+	}
+	defer refs.Close()
+	return fn(rootLog, refs)
+}
+
+var blobsOrphansCmd = &cli.Command{
+	Name:      "orphans",
+	Usage:     "list the stored blobs that no message links to",
+	UsageText: "prints the stored blobs nothing links to according to the blobRefs index of the bot, for cleaning them up by hand",
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "repo", Usage: "read the index and blobs of this repo directly instead of asking the bot (works while the bot is stopped)"},
+	},
+	Action: func(ctx *cli.Context) error {
+		out, err := newRenderer(ctx, os.Stdout)
+		if err != nil {
+			return err
+		}
+
+		if repoPath := ctx.String("repo"); repoPath != "" {
+			bs, err := repo.OpenBlobStore(repo.NewReadOnly(repoPath))
+			if err != nil {
+				return errors.Wrap(err, "blobs.orphans: failed to open blob store")
+			}
+			var orphans []*ssb.BlobRef
+			err = withBlobRefs(repoPath, func(rootLog margaret.Log, refs multilog.MultiLog) error {
+				orphans, err = multilogs.BlobOrphans(longctx, rootLog, refs, bs)
+				return err
+			})
+			if err != nil {
+				return errors.Wrap(err, "blobs.orphans: failed to look up orphans")
+			}
+			for _, ref := range orphans {
+				if err := out.Render(ref.Ref()); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		src, err := client.Source(longctx, json.RawMessage{}, muxrpc.Method{"blobs", "orphans"})
+		if err != nil {
+			return errors.Wrap(err, "blobs.orphans: source stream call failed")
+		}
+		for {
+			v, err := src.Next(longctx)
+			if luigi.IsEOS(err) {
+				return nil
+			} else if err != nil {
+				return errors.Wrap(err, "blobs.orphans: failed to list orphans")
+			}
+			ref, ok := blobListEntry(v).(string)
+			if !ok {
+				return errors.Errorf("blobs.orphans: unexpected orphan entry %T", v)
+			}
+			if err := out.Render(ref); err != nil {
+				return err
+			}
+		}
+	},
+}
@@ -0,0 +1,116 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/authz"
+	"go.cryptoscope.co/ssb/repo"
+	cli "gopkg.in/urfave/cli.v2"
+)
+
+// the allow and deny lists go-sbot -authz-lists checks connections against.
+// They are edited in the repo directly, the bot picks up changes on the next connection.
+
+func defaultRepoPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".ssb-go")
+}
+
+var listsRepoFlag = &cli.StringFlag{Name: "repo", Value: defaultRepoPath(), Usage: "repo of the bot whose lists to edit"}
+
+func openLists(ctx *cli.Context) (*authz.Lists, error) {
+	repoPath := ctx.String("repo")
+	if repoPath == "" {
+		return nil, errors.Errorf("lists: --repo is required")
+	}
+	return authz.OpenLists(authz.ListsPath(repo.New(repoPath)))
+}
+
+var blockListsCmd = &cli.Command{
+	Name:  "lists",
+	Usage: "print the allow and deny lists",
+	Flags: []cli.Flag{listsRepoFlag},
+	Action: func(ctx *cli.Context) error {
+		lists, err := openLists(ctx)
+		if err != nil {
+			return err
+		}
+		data, err := lists.Data()
+		if err != nil {
+			return err
+		}
+		return render(ctx, data)
+	},
+}
+
+// listFeedCmd edits the lists for every @feed argument
+func listFeedCmd(name, usage string, edit func(*authz.Lists, *ssb.FeedRef) error) *cli.Command {
+	return &cli.Command{
+		Name:      name,
+		Usage:     usage,
+		ArgsUsage: "@feed...",
+		Flags:     []cli.Flag{listsRepoFlag},
+		Action: func(ctx *cli.Context) error {
+			if ctx.Args().Len() == 0 {
+				return errors.Errorf("%s: need at least one feed", name)
+			}
+			lists, err := openLists(ctx)
+			if err != nil {
+				return err
+			}
+			for _, arg := range ctx.Args().Slice() {
+				ref, err := ssb.ParseFeedRef(arg)
+				if err != nil {
+					return errors.Wrapf(err, "%s: invalid feed", name)
+				}
+				if err := edit(lists, ref); err != nil {
+					return err
+				}
+				log.Log("event", name, "feed", ref.Ref())
+			}
+			return nil
+		},
+	}
+}
+
+// listMethodCmd restricts or frees every method argument
+func listMethodCmd(name, usage string, restricted bool) *cli.Command {
+	return &cli.Command{
+		Name:      name,
+		Usage:     usage,
+		ArgsUsage: "method...",
+		Flags:     []cli.Flag{listsRepoFlag},
+		Action: func(ctx *cli.Context) error {
+			if ctx.Args().Len() == 0 {
+				return errors.Errorf("%s: need at least one method, like blobs.add or blobs", name)
+			}
+			lists, err := openLists(ctx)
+			if err != nil {
+				return err
+			}
+			for _, m := range ctx.Args().Slice() {
+				if err := lists.Restrict(m, restricted); err != nil {
+					return err
+				}
+				log.Log("event", name, "method", m)
+			}
+			return nil
+		},
+	}
+}
+
+var (
+	blockAllowCmd      = listFeedCmd("allow", "put feeds on the allow list, if it isn't empty only they can connect", (*authz.Lists).Allow)
+	blockDenyCmd       = listFeedCmd("deny", "put feeds on the deny list, they can't connect", (*authz.Lists).Deny)
+	blockRemoveCmd     = listFeedCmd("remove", "take feeds off both lists", (*authz.Lists).Remove)
+	blockRestrictCmd   = listMethodCmd("restrict", "only let feeds on the allow list call these methods", true)
+	blockUnrestrictCmd = listMethodCmd("unrestrict", "let everyone that can connect call these methods again", false)
+)
@@ -0,0 +1,241 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/muxrpc"
+	cli "gopkg.in/urfave/cli.v2"
+
+	ssbClient "go.cryptoscope.co/ssb/client"
+)
+
+var callFlags = []cli.Flag{
+	&cli.StringFlag{Name: "method", Usage: `the method as a JSON array like '["foo","bar.baz"]' or dotted, then all arguments are passed to it`},
+	&cli.IntFlag{Name: "paginate", Usage: "print array replies and streams incrementally, waiting for enter after this many elements on a terminal"},
+	&cli.StringFlag{Name: "out", Usage: "write the reply to this file instead of stdout, one element at a time"},
+	&cli.BoolFlag{Name: "source", Usage: "call the method as a source and stream the reply (done on its own for known source methods)"},
+}
+
+// parseMethod reads a method name, either dotted like blobs.has or a JSON array of its parts for names that contain dots themselves
+func parseMethod(s string) (muxrpc.Method, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "[") {
+		var m muxrpc.Method
+		if err := json.Unmarshal([]byte(s), &m); err != nil {
+			return nil, errors.Wrapf(err, "call: invalid method %s", s)
+		}
+		if len(m) == 0 {
+			return nil, errors.New("call: method can't be empty")
+		}
+		for i, part := range m {
+			if part == "" {
+				return nil, errors.Errorf("call: part %d of method %s is empty", i, s)
+			}
+		}
+		return m, nil
+	}
+	if s == "" {
+		return nil, errors.New("call: method can't be empty")
+	}
+	return muxrpc.Method(strings.Split(s, ".")), nil
+}
+
+// errStopPaging is returned when the user quits the pager
+var errStopPaging = errors.New("call: stopped paging")
+
+// pager renders the elements of a reply and, when it talks to a terminal, waits for enter after every page
+type pager struct {
+	r    *renderer
+	size int
+
+	n     int
+	input *bufio.Reader // nil if nobody can press enter
+}
+
+func newPager(ctx *cli.Context) (*pager, io.Closer, error) {
+	var (
+		w      io.Writer = os.Stdout
+		closer io.Closer = nopCloser{}
+		p                = &pager{size: ctx.Int("paginate")}
+	)
+	if out := ctx.String("out"); out != "" {
+		f, err := os.Create(out)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "call: failed to create --out file")
+		}
+		w, closer = f, f
+	} else if p.size > 0 && isTerminal(os.Stdout) && isTerminal(os.Stdin) {
+		p.input = bufio.NewReader(os.Stdin)
+	}
+	r, err := newRenderer(ctx, w)
+	if err != nil {
+		closer.Close()
+		return nil, nil, err
+	}
+	p.r = r
+	return p, closer, nil
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+func (p *pager) Render(v interface{}) error {
+	if err := p.r.Render(v); err != nil {
+		return err
+	}
+	p.n++
+	if p.input == nil || p.size <= 0 || p.n%p.size != 0 {
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "-- %d elements, enter for more, q to quit --", p.n)
+	line, err := p.input.ReadString('\n')
+	if err != nil || strings.TrimSpace(line) == "q" {
+		return errStopPaging
+	}
+	return nil
+}
+
+// Sink renders every value of a stream
+func (p *pager) Sink() luigi.Sink {
+	return luigi.FuncSink(func(ctx context.Context, val interface{}, err error) error {
+		if luigi.IsEOS(err) {
+			return nil
+		} else if err != nil {
+			return errors.Wrapf(err, "call: failed to read element %d", p.n)
+		}
+		return p.Render(decodeElement(val))
+	})
+}
+
+// RenderReply renders the elements of an array reply one after the other, other replies as one value.
+// The reply is decoded element by element so that a huge array is never held twice, once raw and once decoded.
+func (p *pager) RenderReply(raw []byte) error {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || raw[0] != '[' {
+		return p.r.Render(decodeElement(json.RawMessage(raw)))
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if _, err := dec.Token(); err != nil {
+		return errors.Wrap(err, "call: invalid array reply")
+	}
+	for dec.More() {
+		var elem interface{}
+		if err := dec.Decode(&elem); err != nil {
+			return errors.Wrapf(err, "call: invalid element %d of array reply", p.n)
+		}
+		if err := p.Render(elem); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeElement turns raw JSON into values the renderer knows how to format
+func decodeElement(v interface{}) interface{} {
+	var raw []byte
+	switch tv := v.(type) {
+	case json.RawMessage:
+		raw = tv
+	case []byte:
+		raw = tv
+	default:
+		return v
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var decoded interface{}
+	if err := dec.Decode(&decoded); err != nil {
+		return string(raw)
+	}
+	return decoded
+}
+
+// isSourceMethod guesses from the name if a method is a source, like createLogStream or messagesByType.
+// Calling those as async makes the server buffer the whole stream or fail.
+func isSourceMethod(m muxrpc.Method) bool {
+	if len(m) == 0 {
+		return false
+	}
+	last := m[len(m)-1]
+	if strings.HasPrefix(last, "create") && strings.HasSuffix(last, "Stream") {
+		return true
+	}
+	switch m.String() {
+	case "messagesByType", "links", "query.read", "tangles.replies",
+		"blobs.ls", "blobs.changes", "blobs.createWants", "gossip.changes", "replicate.upto", "replicate.changes":
+		return true
+	}
+	return false
+}
+
+// isNotAsyncError tells if the server refused an async call because the method is a stream
+func isNotAsyncError(err error) bool {
+	ce, ok := errors.Cause(err).(*muxrpc.CallError)
+	if !ok {
+		return false
+	}
+	msg := strings.ToLower(ce.Error())
+	return strings.Contains(msg, "source") || strings.Contains(msg, "stream")
+}
+
+// callPaginated makes the call of callCmd, streaming sources and array replies through a pager.
+// With asSource the method is called as a source right away.
+func callPaginated(ctx *cli.Context, client *ssbClient.Client, method muxrpc.Method, args []interface{}, asSource bool) error {
+	p, closer, err := newPager(ctx)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	stream := func() error {
+		src, err := client.Source(longctx, json.RawMessage{}, method, args...)
+		if err != nil {
+			return errors.Wrapf(err, "%s: source call failed", method)
+		}
+		err = luigi.Pump(longctx, p.Sink(), src)
+		if errors.Cause(err) == errStopPaging {
+			return nil
+		}
+		return err
+	}
+
+	if asSource {
+		return stream()
+	}
+
+	val, err := client.Async(longctx, json.RawMessage{}, method, args...)
+	if err != nil {
+		if isNotAsyncError(err) {
+			return stream()
+		}
+		return errors.Wrapf(err, "%s: call failed", method)
+	}
+	if src, ok := val.(luigi.Source); ok {
+		// the server answered with a stream after all
+		err = luigi.Pump(longctx, p.Sink(), src)
+	} else {
+		raw, ok := val.(json.RawMessage)
+		if !ok {
+			return p.r.Render(val)
+		}
+		err = p.RenderReply(raw)
+	}
+	if errors.Cause(err) == errStopPaging {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,107 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	cli "gopkg.in/urfave/cli.v2"
+
+	"go.cryptoscope.co/ssb/message"
+)
+
+var channelCmd = &cli.Command{
+	Name:  "channel",
+	Usage: "work with the channels of posts",
+	Subcommands: []*cli.Command{
+		channelReadCmd,
+	},
+}
+
+var channelReadCmd = &cli.Command{
+	Name:      "read",
+	Usage:     "print the posts in a channel",
+	ArgsUsage: "#name",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{Name: "live", Usage: "keep printing new posts"},
+		&stallTimeoutFlag,
+	},
+	Action: func(ctx *cli.Context) error {
+		channel, err := normalizeChannel(ctx.Args().First())
+		if err != nil {
+			return err
+		}
+
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		var args message.MessagesByTypeArgs
+		args.Type = "post"
+		args.Keys = true
+		args.Live = ctx.Bool("live")
+		args.MarshalType = mapMsg{}
+		src, err := client.MessagesByType(args)
+		if err != nil {
+			return errors.Wrap(err, "channel: source stream call failed")
+		}
+
+		out, err := newRenderer(ctx, os.Stdout)
+		if err != nil {
+			return err
+		}
+		snk := luigi.FuncSink(func(_ context.Context, v interface{}, err error) error {
+			if err != nil {
+				if luigi.IsEOS(err) {
+					return nil
+				}
+				return err
+			}
+			if !inChannel(v, channel) {
+				return nil
+			}
+			return out.Render(v)
+		})
+		err = pumpStream(ctx, client, snk, src)
+		return errors.Wrap(err, "channel read failed")
+	},
+}
+
+// normalizeChannel strips the leading # and lowercases the name, like the JS clients do
+func normalizeChannel(name string) (string, error) {
+	name = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), "#"))
+	if name == "" {
+		return "", errors.New("channel: need a channel name")
+	}
+	if strings.IndexFunc(name, unicode.IsSpace) >= 0 {
+		return "", errors.Errorf("channel: name %q can't contain whitespace", name)
+	}
+	return name, nil
+}
+
+// inChannel checks the channel of the post v. Posts by other clients might not be normalized.
+func inChannel(v interface{}, channel string) bool {
+	msg, ok := asMapMsg(v)
+	if !ok {
+		return false
+	}
+	if val, ok := msg["value"].(map[string]interface{}); ok {
+		msg = val
+	}
+	content, ok := msg["content"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	c, ok := content["channel"].(string)
+	if !ok {
+		return false
+	}
+	got, err := normalizeChannel(c)
+	return err == nil && got == channel
+}
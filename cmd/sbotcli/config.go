@@ -0,0 +1,46 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/muxrpc"
+	cli "gopkg.in/urfave/cli.v2"
+
+	"go.cryptoscope.co/ssb"
+)
+
+var configCmd = &cli.Command{
+	Name:  "config",
+	Usage: "look at the configuration of the server",
+	Subcommands: []*cli.Command{
+		configShowCmd,
+	},
+}
+
+var configShowCmd = &cli.Command{
+	Name:  "show",
+	Usage: "print the configuration the server runs with, without its secrets",
+	UsageText: `the networkKey of the server has to be the --shscap of the client for connections over TCP,
+a warning is logged if they differ.`,
+	Action: func(ctx *cli.Context) error {
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		v, err := client.Async(longctx, ssb.Config{}, muxrpc.Method{"config"})
+		if err != nil {
+			return errors.Wrap(err, "config: call failed")
+		}
+		cfg, ok := v.(ssb.Config)
+		if !ok {
+			return errors.Errorf("config: unexpected reply %T", v)
+		}
+		if shscap := ctx.String("shscap"); cfg.NetworkKey != shscap {
+			level.Warn(log).Log("event", "network key mismatch", "server", cfg.NetworkKey, "shscap", shscap)
+		}
+		return render(ctx, cfg)
+	},
+}
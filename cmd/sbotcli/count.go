@@ -0,0 +1,222 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/muxrpc"
+	"go.cryptoscope.co/ssb"
+	ssbClient "go.cryptoscope.co/ssb/client"
+	"go.cryptoscope.co/ssb/message"
+	cli "gopkg.in/urfave/cli.v2"
+)
+
+var countCmd = &cli.Command{
+	Name:      "count",
+	Usage:     "count the messages of a feed",
+	UsageText: "count @feed [--by-type]. the counts are cached by the latest message of the feed, only the new messages are read once it grows",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{Name: "by-type", Usage: "also count the messages of each content type (encrypted ones are \"private\")"},
+		&cli.StringFlag{Name: "cache", Value: defaultCountCache(), Usage: "where to keep the counts (empty to not cache)"},
+	},
+	Action: func(ctx *cli.Context) error {
+		feed, err := ssb.ParseFeedRef(ctx.Args().First())
+		if err != nil {
+			return errors.Wrap(err, "count: needs a feed reference as argument")
+		}
+
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		cachePath := ctx.String("cache")
+		cache, err := loadCountCache(cachePath)
+		if err != nil {
+			return err
+		}
+
+		tipKey, tipSeq := feedTip(client, feed)
+		entry, ok := cache[feed.Ref()]
+		switch {
+		case !ok || tipKey == "":
+			entry = countEntry{Types: make(map[string]int64)}
+		case entry.Key == tipKey && entry.Sequence == tipSeq:
+			return render(ctx, entry.report(feed, ctx.Bool("by-type"), true))
+		case entry.Sequence >= tipSeq:
+			// not the messages from before, start over
+			entry = countEntry{Types: make(map[string]int64)}
+		}
+
+		if err := entry.fold(ctx, client, feed, tipSeq); err != nil {
+			return err
+		}
+
+		if cachePath != "" && entry.Key != "" {
+			cache[feed.Ref()] = entry
+			if err := saveCountCache(cachePath, cache); err != nil {
+				level.Warn(log).Log("event", "failed to save count cache", "err", err)
+			}
+		}
+		return render(ctx, entry.report(feed, ctx.Bool("by-type"), false))
+	},
+}
+
+// countEntry is what is known about a feed up to the message Key at Sequence
+type countEntry struct {
+	Key      string           `json:"key"`
+	Sequence int64            `json:"sequence"`
+	Total    int64            `json:"total"`
+	Types    map[string]int64 `json:"types"`
+}
+
+type countReport struct {
+	Feed   string           `json:"feed"`
+	Total  int64            `json:"total"`
+	Types  map[string]int64 `json:"types,omitempty"`
+	Cached bool             `json:"cached"`
+}
+
+func (e countEntry) report(feed *ssb.FeedRef, byType, cached bool) countReport {
+	r := countReport{Feed: feed.Ref(), Total: e.Total, Cached: cached}
+	if byType {
+		r.Types = e.Types
+	}
+	return r
+}
+
+// fold reads the messages of feed after the ones that are counted already
+func (e *countEntry) fold(ctx *cli.Context, client *ssbClient.Client, feed *ssb.FeedRef, tipSeq int64) error {
+	var args message.CreateHistArgs
+	args.ID = feed
+	args.Seq = e.Sequence + 1
+	args.Keys = true
+	args.Limit = -1
+
+	src, err := client.Source(longctx, mapMsg{}, muxrpc.Method{"createHistoryStream"}, args)
+	if err != nil {
+		return errors.Wrap(err, "count: source stream call failed")
+	}
+
+	if e.Types == nil {
+		e.Types = make(map[string]int64)
+	}
+	prog := newProgress(ctx, "messages", tipSeq-e.Sequence)
+	defer prog.Done()
+	for {
+		v, err := src.Next(longctx)
+		if luigi.IsEOS(err) {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "count: failed to read feed")
+		}
+		kv, ok := asMapMsg(v)
+		if !ok {
+			return errors.Errorf("count: unexpected stream value %T", v)
+		}
+		val, ok := kv["value"].(map[string]interface{})
+		if !ok {
+			return errors.Errorf("count: message without value")
+		}
+
+		tipe := "private"
+		if content, ok := val["content"].(map[string]interface{}); ok {
+			tipe, _ = content["type"].(string)
+		}
+		e.Types[tipe]++
+		e.Total++
+		if key, ok := kv["key"].(string); ok {
+			e.Key = key
+		}
+		if seq, ok := val["sequence"].(float64); ok {
+			e.Sequence = int64(seq)
+		}
+		prog.Add(1)
+	}
+}
+
+// feedTip returns the key and sequence of the latest message of feed, or an empty key if the server doesn't tell.
+// go-sbot has no getLatest, the last message of a reverse createHistoryStream stands in for it there.
+func feedTip(client *ssbClient.Client, feed *ssb.FeedRef) (string, int64) {
+	v, err := client.Async(longctx, mapMsg{}, muxrpc.Method{"getLatest"}, feed.Ref())
+	if err != nil {
+		v, err = lastOfHistory(client, feed)
+		if err != nil {
+			return "", 0
+		}
+	}
+	msg, ok := asMapMsg(v)
+	if !ok {
+		return "", 0
+	}
+	key, _ := msg["key"].(string)
+	if val, ok := msg["value"].(map[string]interface{}); ok {
+		msg = val
+	}
+	seq, _ := msg["sequence"].(float64)
+	return key, int64(seq)
+}
+
+// lastOfHistory returns the latest message of feed with its key
+func lastOfHistory(client *ssbClient.Client, feed *ssb.FeedRef) (interface{}, error) {
+	var args message.CreateHistArgs
+	args.ID = feed
+	args.Keys = true
+	args.Reverse = true
+	args.Limit = 1
+
+	src, err := client.Source(longctx, mapMsg{}, muxrpc.Method{"createHistoryStream"}, args)
+	if err != nil {
+		return nil, err
+	}
+	return src.Next(longctx)
+}
+
+func defaultCountCache() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "sbotcli", "counts.json")
+}
+
+func loadCountCache(path string) (map[string]countEntry, error) {
+	cache := make(map[string]countEntry)
+	if path == "" {
+		return cache, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "count: failed to read cache")
+	}
+	if err := json.Unmarshal(data, &cache); err != nil {
+		// it's only a cache
+		level.Warn(log).Log("event", "ignoring broken count cache", "file", path, "err", err)
+		return make(map[string]countEntry), nil
+	}
+	return cache, nil
+}
+
+func saveCountCache(path string, cache map[string]countEntry) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return errors.Wrap(err, "count: failed to create cache directory")
+	}
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return errors.Wrap(err, "count: failed to encode cache")
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return errors.Wrap(err, "count: failed to write cache")
+	}
+	return errors.Wrap(os.Rename(tmp, path), "count: failed to replace cache")
+}
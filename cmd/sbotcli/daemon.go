@@ -0,0 +1,356 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/muxrpc"
+	ssbClient "go.cryptoscope.co/ssb/client"
+	cli "gopkg.in/urfave/cli.v2"
+)
+
+const (
+	daemonMinBackoff = time.Second
+	daemonMaxBackoff = 5 * time.Minute
+)
+
+var daemonSocketFlag = cli.StringFlag{Name: "socket", Usage: "path of the unix socket with the status of the daemon (default: ~/.ssb-go/sbotcli-daemon.sock)"}
+
+var daemonCmd = &cli.Command{
+	Name:  "daemon",
+	Usage: "background helpers that keep running, next to serve-local",
+	Subcommands: []*cli.Command{
+		daemonConnectCmd,
+		daemonStatusCmd,
+	},
+}
+
+var daemonConnectCmd = &cli.Command{
+	Name:  "connect",
+	Usage: "keep the server connected to a set of peers, reconnecting them when a connection drops",
+	UsageText: `daemon connect --from addrs.txt
+
+addrs.txt has one multiserver address per line, like net:example.org:8008~shs:<key>; empty lines and lines starting with # are skipped.
+Every --check the connections of the server are looked at and the missing peers are dialed with ctrl.connect.
+Failed dials are retried with an exponential backoff of up to 5 minutes.
+The state of each peer is served as daemon.status on --socket, see daemon status.`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "from", Usage: "file with the addresses of the peers"},
+		&cli.DurationFlag{Name: "check", Value: 30 * time.Second, Usage: "how often to look if the peers are still connected"},
+		&daemonSocketFlag,
+	},
+	Action: func(ctx *cli.Context) error {
+		addrs, err := readAddrs(ctx.String("from"))
+		if err != nil {
+			return err
+		}
+		if len(addrs) == 0 {
+			return errors.New("daemon: no addresses in --from")
+		}
+		check := ctx.Duration("check")
+		if check <= 0 {
+			return errors.New("daemon: --check needs to be positive")
+		}
+		sockPath, err := localSocketPath(ctx.String("socket"), "sbotcli-daemon.sock")
+		if err != nil {
+			return errors.Wrap(err, "daemon")
+		}
+
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		d := &connDaemon{client: client, check: check}
+		for _, addr := range addrs {
+			key := shsKey(addr)
+			if key == "" {
+				return errors.Errorf("daemon: %s has no ~shs: key", addr)
+			}
+			d.peers = append(d.peers, &daemonPeer{addr: addr, key: key})
+		}
+
+		workCtx, cancel := context.WithCancel(longctx)
+		var wg sync.WaitGroup
+		for _, p := range d.peers {
+			wg.Add(1)
+			go func(p *daemonPeer) {
+				defer wg.Done()
+				d.supervise(workCtx, p)
+			}(p)
+		}
+		err = serveUnix(sockPath, daemonHandler{d})
+		cancel()
+		wg.Wait()
+		return errors.Wrap(err, "daemon")
+	},
+}
+
+var daemonStatusCmd = &cli.Command{
+	Name:  "status",
+	Usage: "print the state of the peers of a running daemon connect",
+	Flags: []cli.Flag{&daemonSocketFlag},
+	Action: func(ctx *cli.Context) error {
+		sockPath, err := localSocketPath(ctx.String("socket"), "sbotcli-daemon.sock")
+		if err != nil {
+			return errors.Wrap(err, "daemon")
+		}
+		client, err := ssbClient.NewUnix(sockPath, ssbClient.WithContext(longctx))
+		if err != nil {
+			return errors.Wrap(err, "daemon: is it running?")
+		}
+		defer client.Close()
+
+		v, err := client.Async(longctx, []daemonPeerStatus{}, muxrpc.Method{"daemon", "status"})
+		if err != nil {
+			return errors.Wrap(err, "daemon: status call failed")
+		}
+		return render(ctx, v)
+	},
+}
+
+// readAddrs returns the addresses in the file at path, one per line
+func readAddrs(path string) ([]string, error) {
+	if path == "" {
+		return nil, errors.New("daemon: --from is required")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "daemon: failed to open --from")
+	}
+	defer f.Close()
+
+	var addrs []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		addrs = append(addrs, line)
+	}
+	return addrs, errors.Wrap(sc.Err(), "daemon: failed to read --from")
+}
+
+// shsKey returns the public key of a multiserver address, which is how the peers in the status of the server are told apart
+func shsKey(addr string) string {
+	i := strings.Index(addr, "shs:")
+	if i < 0 {
+		return ""
+	}
+	key := addr[i+len("shs:"):]
+	if j := strings.IndexAny(key, ";~:"); j >= 0 {
+		key = key[:j]
+	}
+	return key
+}
+
+// connDaemon keeps the server connected to its peers
+type connDaemon struct {
+	client *ssbClient.Client
+	check  time.Duration
+	peers  []*daemonPeer
+
+	// the keys of the peers the server is connected to, from its status
+	mu        sync.Mutex
+	connected map[string]bool
+	checked   time.Time
+}
+
+type daemonPeer struct {
+	addr string
+	key  string
+
+	mu        sync.Mutex
+	connected bool
+	since     time.Time // when it was connected, or when it was seen disconnected
+	attempts  int       // failed dials since the last connection
+	lastErr   string
+	nextTry   time.Time
+}
+
+type daemonPeerStatus struct {
+	Addr      string `json:"addr"`
+	Connected bool   `json:"connected"`
+	Since     string `json:"since,omitempty"`
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"lastError,omitempty"`
+	NextTry   string `json:"nextTry,omitempty"`
+}
+
+// supervise runs keep for p until ctx is done and restarts it if it panics
+func (d *connDaemon) supervise(ctx context.Context, p *daemonPeer) {
+	for ctx.Err() == nil {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					level.Error(log).Log("event", "daemon worker crashed", "peer", p.addr, "err", fmt.Sprint(r))
+					wait(ctx, daemonMinBackoff)
+				}
+			}()
+			d.keep(ctx, p)
+		}()
+	}
+}
+
+// keep dials p whenever the server isn't connected to it, with a backoff between failed dials
+func (d *connDaemon) keep(ctx context.Context, p *daemonPeer) {
+	backoff := daemonMinBackoff
+	for {
+		connected, err := d.isConnected(p.key)
+		if err != nil {
+			level.Warn(log).Log("event", "daemon status failed", "err", err)
+		}
+		if connected {
+			p.update(true, nil, time.Now().Add(d.check))
+			backoff = daemonMinBackoff
+			if !wait(ctx, d.check) {
+				return
+			}
+			continue
+		}
+
+		_, err = d.client.Async(ctx, mapMsg{}, muxrpc.Method{"ctrl", "connect"}, p.addr)
+		if err != nil {
+			// like connect, for the js server
+			_, err = d.client.Async(ctx, mapMsg{}, muxrpc.Method{"gossip", "connect"}, p.addr)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		next := d.check
+		if err != nil {
+			level.Debug(log).Log("event", "daemon dial failed", "peer", p.addr, "err", err)
+			// some jitter, so that the peers don't retry in lockstep
+			next = backoff/2 + time.Duration(rand.Int63n(int64(backoff)))
+			if backoff *= 2; backoff > daemonMaxBackoff {
+				backoff = daemonMaxBackoff
+			}
+		} else {
+			level.Info(log).Log("event", "daemon connected", "peer", p.addr)
+			d.invalidate()
+			backoff = daemonMinBackoff
+		}
+		p.update(err == nil, err, time.Now().Add(next))
+		if !wait(ctx, next) {
+			return
+		}
+	}
+}
+
+// isConnected looks at the status of the server, at most every half --check for all peers together
+func (d *connDaemon) isConnected(key string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.connected != nil && time.Since(d.checked) < d.check/2 {
+		return d.connected[key], nil
+	}
+
+	v, err := d.client.Async(longctx, mapMsg{}, muxrpc.Method{"status"})
+	if err != nil {
+		return false, err
+	}
+	st, ok := asMapMsg(v)
+	if !ok {
+		return false, errors.Errorf("unexpected status reply %T", v)
+	}
+	connected := make(map[string]bool)
+	peers, _ := st["Peers"].([]interface{})
+	for _, pv := range peers {
+		if p, ok := pv.(map[string]interface{}); ok {
+			addr, _ := p["Addr"].(string)
+			connected[shsKey(addr)] = true
+		}
+	}
+	d.connected, d.checked = connected, time.Now()
+	return connected[key], nil
+}
+
+// invalidate makes the next isConnected ask the server again
+func (d *connDaemon) invalidate() {
+	d.mu.Lock()
+	d.connected = nil
+	d.mu.Unlock()
+}
+
+func (p *daemonPeer) update(connected bool, err error, next time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if connected != p.connected || p.since.IsZero() {
+		p.since = time.Now()
+	}
+	p.connected = connected
+	p.nextTry = next
+	if err != nil {
+		p.attempts++
+		p.lastErr = err.Error()
+	} else if connected {
+		p.attempts = 0
+		p.lastErr = ""
+	}
+}
+
+func (d *connDaemon) status() []daemonPeerStatus {
+	var st []daemonPeerStatus
+	for _, p := range d.peers {
+		p.mu.Lock()
+		ps := daemonPeerStatus{
+			Addr:      p.addr,
+			Connected: p.connected,
+			Attempts:  p.attempts,
+			LastError: p.lastErr,
+		}
+		if !p.since.IsZero() {
+			ps.Since = humanize.Time(p.since)
+		}
+		if !p.connected && !p.nextTry.IsZero() {
+			ps.NextTry = humanize.Time(p.nextTry)
+		}
+		p.mu.Unlock()
+		st = append(st, ps)
+	}
+	sort.Slice(st, func(i, j int) bool { return st[i].Addr < st[j].Addr })
+	return st
+}
+
+// wait sleeps for d and returns false if ctx is done before
+func wait(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// daemonHandler serves daemon.status on the local socket
+type daemonHandler struct {
+	d *connDaemon
+}
+
+func (daemonHandler) HandleConnect(context.Context, muxrpc.Endpoint) {}
+
+func (h daemonHandler) HandleCall(ctx context.Context, req *muxrpc.Request, _ muxrpc.Endpoint) {
+	if req.Method.String() != "daemon.status" {
+		req.CloseWithError(errors.Errorf("daemon: unknown method %s", req.Method))
+		return
+	}
+	if err := req.Return(ctx, h.d.status()); err != nil {
+		level.Warn(log).Log("event", "daemon status return failed", "err", err)
+	}
+}
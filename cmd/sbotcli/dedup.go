@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	cli "gopkg.in/urfave/cli.v2"
+)
+
+var dedupContentFlag = cli.BoolFlag{Name: "dedup-content", Usage: "only print the first message of each distinct content, like the same post reposted by several feeds"}
+
+// contentDeduper drops messages whose content was passed on already.
+// Contents are compared by the hash of their JSON encoding with sorted keys, so the formatting of the author doesn't matter.
+// Values without content are passed on as they are.
+type contentDeduper struct {
+	snk  luigi.Sink
+	seen map[[sha256.Size]byte]struct{}
+
+	dropped int
+}
+
+func newContentDeduper(snk luigi.Sink) *contentDeduper {
+	return &contentDeduper{
+		snk:  snk,
+		seen: make(map[[sha256.Size]byte]struct{}),
+	}
+}
+
+func (cd *contentDeduper) Pour(ctx context.Context, v interface{}) error {
+	content, ok := contentOf(v)
+	if !ok {
+		return cd.snk.Pour(ctx, v)
+	}
+	// encoding/json sorts map keys, which makes this independent of the field order of the message
+	b, err := json.Marshal(content)
+	if err != nil {
+		return errors.Wrap(err, "dedup: failed to encode content")
+	}
+	h := sha256.Sum256(b)
+	if _, has := cd.seen[h]; has {
+		cd.dropped++
+		return nil
+	}
+	cd.seen[h] = struct{}{}
+	return cd.snk.Pour(ctx, v)
+}
+
+func (cd *contentDeduper) Close() error {
+	if cd.dropped > 0 {
+		log.Log("event", "dedup", "dropped", cd.dropped, "distinct", len(cd.seen))
+	}
+	return cd.snk.Close()
+}
+
+// contentOf returns the content of a message, with or without key
+func contentOf(v interface{}) (interface{}, bool) {
+	msg, ok := asMapMsg(v)
+	if !ok {
+		return nil, false
+	}
+	if val, ok := msg["value"].(map[string]interface{}); ok {
+		msg = val
+	}
+	content, has := msg["content"]
+	return content, has && content != nil
+}
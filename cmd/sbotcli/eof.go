@@ -0,0 +1,37 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+
+	"go.cryptoscope.co/luigi"
+	cli "gopkg.in/urfave/cli.v2"
+)
+
+var eofMarkerFlag = cli.BoolFlag{Name: "emit-eof-marker", Usage: `without --live: print {"$eof":true,"count":N} after the last message if the stream ended normally`}
+
+// eofMarker tells scripts that a stream was read to its end and not cut off by an error
+type eofMarker struct {
+	EOF   bool `json:"$eof"`
+	Count int  `json:"count"`
+}
+
+// pumpWithEOFMarker pumps src into snk and renders an eofMarker with the number of messages if the stream ended normally
+func pumpWithEOFMarker(ctx *cli.Context, snk luigi.Sink, src luigi.Source) error {
+	var n int
+	counted := luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error {
+		if err != nil {
+			if luigi.IsEOS(err) {
+				return snk.Close()
+			}
+			return err
+		}
+		n++
+		return snk.Pour(ctx, v)
+	})
+	if err := luigi.Pump(longctx, counted, src); err != nil {
+		return err
+	}
+	return render(ctx, eofMarker{EOF: true, Count: n})
+}
@@ -0,0 +1,609 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/muxrpc"
+	"go.cryptoscope.co/ssb"
+	ssbClient "go.cryptoscope.co/ssb/client"
+	"go.cryptoscope.co/ssb/message"
+	cli "gopkg.in/urfave/cli.v2"
+)
+
+var exportCmd = &cli.Command{
+	Name:  "export",
+	Usage: "write messages into a SQLite database, to query them with SQL, or into a file with one message per line",
+	UsageText: `the messages table has the columns key, author, sequence, timestamp (claimed, in milliseconds), type and content (JSON).
+Private messages keep their boxed content and have no type. Messages that are already in the database are skipped.
+With --out the messages are written as newline delimited JSON, like createLogStream with keys returns them.
+
+--from and --to take dates (2006-01-02, --to includes the whole day) or RFC 3339 times and filter by the timestamp the author claims.
+Without --id and --type the bot looks those up with createFeedStream, so only the messages in the range are sent, ordered by that timestamp.
+The claims can't be trusted, so --by-received filters by when the bot got the message instead. The streams come in the order of the log,
+so with it the export also stops at the first message after --to.
+
+	sbotcli export --author @id --from 2023-01-01 --to 2023-06-30 --out q2.ndjson
+
+--append continues the export of a feed: only the messages after the last one of it in the file are fetched,
+and they have to chain onto it. Run it again to keep a backup of the feed up to date.
+
+	sbotcli export --author @id --append --out backup.ndjson`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "sqlite", Usage: "the database file to write to, created if it doesn't exist"},
+		&cli.StringFlag{Name: "out", Usage: "the file to write the messages to as newline delimited JSON, instead of --sqlite"},
+		&cli.StringFlag{Name: "id", Aliases: []string{"author"}, Usage: "only export this feed"},
+		&cli.StringFlag{Name: "type", Usage: "only export messages of this type"},
+		&cli.StringFlag{Name: "from", Usage: "only export messages from this date or time on"},
+		&cli.StringFlag{Name: "to", Usage: "only export messages up to this date or time"},
+		&cli.BoolFlag{Name: "by-received", Usage: "apply --from and --to to when the bot received the messages"},
+		&cli.BoolFlag{Name: "append", Usage: "only add the messages of --id after the last one that was exported already"},
+		&cli.Int64Flag{Name: "limit", Value: -1, Usage: "export at most this many messages"},
+		&cli.IntFlag{Name: "batch", Value: 1000, Usage: "how many messages to insert per transaction"},
+	},
+	Action: func(ctx *cli.Context) error {
+		dbPath, outPath := ctx.String("sqlite"), ctx.String("out")
+		if (dbPath == "") == (outPath == "") {
+			return errors.New("export: one of --sqlite and --out is required")
+		}
+		if ctx.String("id") != "" && ctx.String("type") != "" {
+			return errors.New("export: --id and --type can't be combined")
+		}
+		batch := ctx.Int("batch")
+		if batch < 1 {
+			return errors.New("export: --batch needs to be positive")
+		}
+		rng, err := parseExportRange(ctx)
+		if err != nil {
+			return err
+		}
+
+		// with --append the export picks up after the last message of the feed that is there already
+		var (
+			appending = ctx.Bool("append")
+			feed      *ssb.FeedRef
+			last      exportCursor
+		)
+		if appending {
+			feed, err = ssb.ParseFeedRef(ctx.String("id"))
+			if err != nil {
+				return errors.Wrap(err, "export: --append needs the feed as --id")
+			}
+			if !rng.isZero() {
+				return errors.New("export: --append can't be combined with --from and --to, the messages have to chain")
+			}
+			if outPath != "" {
+				last, err = lastNDJSONCursor(outPath, feed)
+			} else {
+				last, err = lastSQLiteCursor(dbPath, feed)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		if appending && last.Sequence > 0 {
+			tipKey, tipSeq := feedTip(client, feed)
+			switch {
+			case tipKey == "":
+				// the server doesn't tell, the chain check below has to do
+			case tipSeq < last.Sequence:
+				return errors.Errorf("export: the export is at %d but the bot only has %s up to %d", last.Sequence, feed.Ref(), tipSeq)
+			case tipSeq == last.Sequence && tipKey != last.Key:
+				return errors.Errorf("export: message %d of the export is %s, the bot has %s", last.Sequence, last.Key, tipKey)
+			}
+		}
+
+		// only createFeedStream knows the range, the other streams send all messages and they are filtered here
+		streamLimit := ctx.Int64("limit")
+		if !rng.isZero() && !rng.byServer(ctx) {
+			streamLimit = -1
+		}
+		src, err := exportSource(ctx, client, last.Sequence+1, streamLimit, rng)
+		if err != nil {
+			return errors.Wrap(err, "export: failed to start stream")
+		}
+
+		var (
+			out  exportWriter
+			file = dbPath
+		)
+		if outPath != "" {
+			out, err = createNDJSONExport(outPath, appending)
+			file = outPath
+		} else {
+			out, err = openExport(dbPath, batch)
+		}
+		if err != nil {
+			return err
+		}
+
+		// messagesByType has no limit, so it is applied here. With a range it counts the messages in it.
+		limit := ctx.Int64("limit")
+		total := exportTotal(ctx, client)
+		if appending && total > 0 {
+			total -= last.Sequence
+		}
+		if limit >= 0 && rng.isZero() && (total <= 0 || limit < total) {
+			total = limit
+		}
+		prog := newProgress(ctx, "messages", total)
+		start := time.Now()
+		var outside int64
+		for n := int64(0); limit < 0 || n < limit; {
+			v, err := src.Next(longctx)
+			if luigi.IsEOS(err) {
+				break
+			} else if err != nil {
+				prog.Done()
+				out.Close()
+				return errors.Wrap(err, "export: stream failed")
+			}
+			prog.Add(1)
+			kv, ok := v.(ssb.KeyValueRaw)
+			if !ok {
+				out.Close()
+				return errors.Errorf("export: unexpected stream type %T", v)
+			}
+			if !rng.contains(kv) {
+				if rng.past(kv) {
+					break
+				}
+				outside++
+				continue
+			}
+			if appending {
+				// what was written so far chains, so it stays in the export
+				if err := last.chain(kv); err != nil {
+					prog.Done()
+					out.Close()
+					return err
+				}
+			}
+			if err := out.Add(kv); err != nil {
+				out.Close()
+				return err
+			}
+			n++
+		}
+		prog.Done()
+		if err := out.Close(); err != nil {
+			return err
+		}
+		written, skipped := out.Counts()
+		rep := exportReport{
+			File:    file,
+			Written: written,
+			Skipped: skipped,
+			Outside: outside,
+			Took:    time.Since(start).Round(time.Millisecond).String(),
+		}
+		if appending {
+			rep.Latest = last.Sequence
+		}
+		return render(ctx, rep)
+	},
+}
+
+type exportReport struct {
+	File    string `json:"file"`
+	Written int64  `json:"written"`
+	Skipped int64  `json:"skipped"`
+	Outside int64  `json:"outside,omitempty"` // not in --from and --to
+	Latest  int64  `json:"latest,omitempty"`  // the last sequence in the export with --append
+	Took    string `json:"took"`
+}
+
+// exportCursor is the last message of a feed in an export, the zero value if there is none yet
+type exportCursor struct {
+	Key      string
+	Sequence int64
+}
+
+// chain checks that kv is the message after the cursor and moves it on to kv
+func (c *exportCursor) chain(kv ssb.KeyValueRaw) error {
+	seq := kv.Value.Sequence.Seq()
+	if seq != c.Sequence+1 {
+		return errors.Errorf("export: expected message %d of the feed, got %d", c.Sequence+1, seq)
+	}
+	var prev string
+	if kv.Value.Previous != nil {
+		prev = kv.Value.Previous.Ref()
+	}
+	if prev != c.Key {
+		return errors.Errorf("export: message %d (%s) doesn't chain onto %s, the export and the feed of the bot differ", seq, kv.Key_.Ref(), c.Key)
+	}
+	c.Key, c.Sequence = kv.Key_.Ref(), seq
+	return nil
+}
+
+// lastNDJSONCursor reads the last line of the file at path, which has to be a message of feed.
+// A file that doesn't exist yet or is empty starts the export at the first message.
+func lastNDJSONCursor(path string, feed *ssb.FeedRef) (exportCursor, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return exportCursor{}, nil
+	} else if err != nil {
+		return exportCursor{}, errors.Wrap(err, "export: failed to open --out")
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return exportCursor{}, errors.Wrap(err, "export: failed to open --out")
+	}
+	size := fi.Size()
+	if size == 0 {
+		return exportCursor{}, nil
+	}
+
+	// read more of the end until it has the start of the last line
+	var line []byte
+	for chunk := int64(64 << 10); ; chunk *= 2 {
+		off := size - chunk
+		if off < 0 {
+			off = 0
+		}
+		buf := make([]byte, size-off)
+		if _, err := f.ReadAt(buf, off); err != nil {
+			return exportCursor{}, errors.Wrap(err, "export: failed to read --out")
+		}
+		if buf[len(buf)-1] != '\n' {
+			return exportCursor{}, errors.Errorf("export: the last line of %s is incomplete, remove it to append", path)
+		}
+		buf = buf[:len(buf)-1]
+		if i := bytes.LastIndexByte(buf, '\n'); i >= 0 {
+			line = buf[i+1:]
+			break
+		}
+		if off == 0 {
+			line = buf
+			break
+		}
+	}
+
+	var kv ssb.KeyValueRaw
+	if err := json.Unmarshal(line, &kv); err != nil || kv.Key_ == nil {
+		return exportCursor{}, errors.Errorf("export: the last line of %s is not a message", path)
+	}
+	if !kv.Value.Author.Equal(feed) {
+		return exportCursor{}, errors.Errorf("export: %s ends with a message of %s, not of %s", path, kv.Value.Author.Ref(), feed.Ref())
+	}
+	return exportCursor{Key: kv.Key_.Ref(), Sequence: kv.Value.Sequence.Seq()}, nil
+}
+
+// lastSQLiteCursor looks up the latest message of feed in the database at path
+func lastSQLiteCursor(path string, feed *ssb.FeedRef) (exportCursor, error) {
+	var c exportCursor
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return c, nil
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return c, errors.Wrap(err, "export: failed to open database")
+	}
+	defer db.Close()
+	if _, err := db.Exec(exportSchema); err != nil {
+		return c, errors.Wrap(err, "export: failed to create table")
+	}
+	err = db.QueryRow(`SELECT key, sequence FROM messages WHERE author = ? ORDER BY sequence DESC LIMIT 1`, feed.Ref()).Scan(&c.Key, &c.Sequence)
+	if err == sql.ErrNoRows {
+		return exportCursor{}, nil
+	}
+	return c, errors.Wrap(err, "export: failed to find the latest message of the feed")
+}
+
+// exportWriter is where the export goes, a database or a file
+type exportWriter interface {
+	Add(ssb.KeyValueRaw) error
+	Close() error
+
+	// Counts returns how many messages were written and how many were there already
+	Counts() (written, skipped int64)
+}
+
+// exportRange is the time range of --from and --to, both ends are zero if they aren't set
+type exportRange struct {
+	from, to   time.Time // to is the first moment that isn't in the range
+	byReceived bool
+}
+
+func parseExportRange(ctx *cli.Context) (exportRange, error) {
+	rng := exportRange{byReceived: ctx.Bool("by-received")}
+	if s := ctx.String("from"); s != "" {
+		t, _, err := parseExportTime(s)
+		if err != nil {
+			return rng, errors.Wrap(err, "export: invalid --from")
+		}
+		rng.from = t
+	}
+	if s := ctx.String("to"); s != "" {
+		t, isDate, err := parseExportTime(s)
+		if err != nil {
+			return rng, errors.Wrap(err, "export: invalid --to")
+		}
+		if isDate {
+			rng.to = t.AddDate(0, 0, 1)
+		} else {
+			rng.to = t.Add(time.Millisecond)
+		}
+	}
+	if !rng.from.IsZero() && !rng.to.IsZero() && !rng.from.Before(rng.to) {
+		return rng, errors.New("export: --from has to be before --to")
+	}
+	return rng, nil
+}
+
+// parseExportTime takes an RFC 3339 time or a date in the local time zone, isDate tells which one it was
+func parseExportTime(s string) (time.Time, bool, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, false, errors.Errorf("expected 2006-01-02 or an RFC 3339 time, got %q", s)
+	}
+	return t, false, nil
+}
+
+func (rng exportRange) isZero() bool { return rng.from.IsZero() && rng.to.IsZero() }
+
+func (rng exportRange) time(kv ssb.KeyValueRaw) time.Time {
+	if rng.byReceived {
+		return time.Time(kv.Timestamp)
+	}
+	return time.Time(kv.Value.Timestamp)
+}
+
+func (rng exportRange) contains(kv ssb.KeyValueRaw) bool {
+	t := rng.time(kv)
+	if !rng.from.IsZero() && t.Before(rng.from) {
+		return false
+	}
+	return rng.to.IsZero() || t.Before(rng.to)
+}
+
+// byServer is true if the bot filters the range, which createFeedStream does for the claimed times of the whole log
+func (rng exportRange) byServer(ctx *cli.Context) bool {
+	return !rng.isZero() && !rng.byReceived && ctx.String("id") == "" && ctx.String("type") == ""
+}
+
+// past is true if no message after kv can be in the range, which is only known for the receive times
+func (rng exportRange) past(kv ssb.KeyValueRaw) bool {
+	return rng.byReceived && !rng.to.IsZero() && !rng.time(kv).Before(rng.to)
+}
+
+// ndjsonExport writes one message per line
+type ndjsonExport struct {
+	f   *os.File
+	w   *bufio.Writer
+	enc *json.Encoder
+
+	written int64
+}
+
+// createNDJSONExport truncates the file at path, unless the lines are appended to it
+func createNDJSONExport(path string, appending bool) (*ndjsonExport, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appending {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := os.OpenFile(path, flags, 0666)
+	if err != nil {
+		return nil, errors.Wrap(err, "export: failed to create --out")
+	}
+	w := bufio.NewWriter(f)
+	return &ndjsonExport{f: f, w: w, enc: json.NewEncoder(w)}, nil
+}
+
+func (ne *ndjsonExport) Add(kv ssb.KeyValueRaw) error {
+	if err := ne.enc.Encode(kv); err != nil {
+		return errors.Wrapf(err, "export: failed to write %s", kv.Key_.Ref())
+	}
+	ne.written++
+	return nil
+}
+
+func (ne *ndjsonExport) Counts() (int64, int64) { return ne.written, 0 }
+
+// Close flushes the last lines
+func (ne *ndjsonExport) Close() error {
+	err := ne.w.Flush()
+	if cerr := ne.f.Close(); err == nil {
+		err = cerr
+	}
+	return errors.Wrap(err, "export: failed to write --out")
+}
+
+// exportTotal is how many messages the export gets, from the latest message of the feed or the length of the log.
+// It is zero if the server doesn't tell, and for types.
+func exportTotal(ctx *cli.Context, client *ssbClient.Client) int64 {
+	if id := ctx.String("id"); id != "" {
+		// not every server has getLatest
+		v, err := client.Async(longctx, mapMsg{}, muxrpc.Method{"getLatest"}, id)
+		if err != nil {
+			return 0
+		}
+		msg, ok := asMapMsg(v)
+		if !ok {
+			return 0
+		}
+		if val, ok := msg["value"].(map[string]interface{}); ok {
+			msg = val
+		}
+		seq, _ := msg["sequence"].(float64)
+		return int64(seq)
+	}
+	if ctx.String("type") != "" {
+		return 0
+	}
+	v, err := client.Async(longctx, mapMsg{}, muxrpc.Method{"status"})
+	if err != nil {
+		return 0
+	}
+	st, ok := asMapMsg(v)
+	if !ok {
+		return 0
+	}
+	root, ok := st["Root"].(float64)
+	if !ok {
+		return 0
+	}
+	return int64(root) + 1
+}
+
+// exportSource picks the stream for the flags: a feed from message seq on, a type, the claimed times in rng or the whole log, always with keys and at most limit messages
+func exportSource(ctx *cli.Context, client *ssbClient.Client, seq, limit int64, rng exportRange) (luigi.Source, error) {
+	if id := ctx.String("id"); id != "" {
+		ref, err := ssb.ParseFeedRef(id)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid --id")
+		}
+		var args message.CreateHistArgs
+		args.ID = ref
+		args.Seq = seq
+		args.Keys = true
+		args.Limit = limit
+		args.MarshalType = ssb.KeyValueRaw{}
+		return client.CreateHistoryStream(args)
+	}
+
+	if tipe := ctx.String("type"); tipe != "" {
+		var args message.MessagesByTypeArgs
+		args.Type = tipe
+		args.Keys = true
+		args.MarshalType = ssb.KeyValueRaw{}
+		return client.MessagesByType(args)
+	}
+
+	if rng.byServer(ctx) {
+		// gt and lt are exclusive, from isn't
+		var args message.CreateFeedArgs
+		if !rng.from.IsZero() {
+			args.Gt = float64(rng.from.UnixNano()/int64(time.Millisecond) - 1)
+		}
+		if !rng.to.IsZero() {
+			args.Lt = float64(rng.to.UnixNano() / int64(time.Millisecond))
+		}
+		args.Keys = true
+		args.Limit = limit
+		args.MarshalType = ssb.KeyValueRaw{}
+		return client.CreateFeedStream(args)
+	}
+
+	var args message.CreateLogArgs
+	args.Keys = true
+	args.Limit = limit
+	args.MarshalType = ssb.KeyValueRaw{}
+	return client.CreateLogStream(args)
+}
+
+const exportSchema = `
+CREATE TABLE IF NOT EXISTS messages (
+	key       TEXT PRIMARY KEY,
+	author    TEXT NOT NULL,
+	sequence  INTEGER NOT NULL,
+	timestamp INTEGER NOT NULL,
+	type      TEXT,
+	content   TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS messages_author ON messages (author, sequence);
+CREATE INDEX IF NOT EXISTS messages_type ON messages (type);
+`
+
+// sqliteExport inserts messages in transactions of batch rows
+type sqliteExport struct {
+	db    *sql.DB
+	batch int
+
+	tx     *sql.Tx
+	insert *sql.Stmt
+	inTx   int
+
+	written, skipped int64
+}
+
+func openExport(path string, batch int) (*sqliteExport, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, errors.Wrap(err, "export: failed to open database")
+	}
+	if _, err := db.Exec(exportSchema); err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "export: failed to create table")
+	}
+	return &sqliteExport{db: db, batch: batch}, nil
+}
+
+func (se *sqliteExport) Add(kv ssb.KeyValueRaw) error {
+	if se.tx == nil {
+		var err error
+		se.tx, err = se.db.Begin()
+		if err != nil {
+			return errors.Wrap(err, "export: failed to start transaction")
+		}
+		se.insert, err = se.tx.Prepare(`INSERT OR IGNORE INTO messages (key, author, sequence, timestamp, type, content) VALUES (?, ?, ?, ?, ?, ?)`)
+		if err != nil {
+			return errors.Wrap(err, "export: failed to prepare insert")
+		}
+	}
+
+	var typed struct {
+		Type string `json:"type"`
+	}
+	var tipe interface{} // NULL for private messages
+	if json.Unmarshal(kv.Value.Content, &typed) == nil && typed.Type != "" {
+		tipe = typed.Type
+	}
+	ts := time.Time(kv.Value.Timestamp).UnixNano() / int64(time.Millisecond)
+
+	res, err := se.insert.Exec(kv.Key_.Ref(), kv.Value.Author.Ref(), kv.Value.Sequence.Seq(), ts, tipe, string(kv.Value.Content))
+	if err != nil {
+		return errors.Wrapf(err, "export: failed to insert %s", kv.Key_.Ref())
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		se.skipped++
+	} else {
+		se.written++
+	}
+
+	se.inTx++
+	if se.inTx >= se.batch {
+		return se.commit()
+	}
+	return nil
+}
+
+func (se *sqliteExport) Counts() (int64, int64) { return se.written, se.skipped }
+
+func (se *sqliteExport) commit() error {
+	if se.tx == nil {
+		return nil
+	}
+	se.insert.Close()
+	err := se.tx.Commit()
+	se.tx, se.insert, se.inTx = nil, nil, 0
+	return errors.Wrap(err, "export: failed to commit")
+}
+
+// Close commits the last batch
+func (se *sqliteExport) Close() error {
+	err := se.commit()
+	if cerr := se.db.Close(); err == nil && cerr != nil {
+		err = errors.Wrap(cerr, "export: failed to close database")
+	}
+	return err
+}
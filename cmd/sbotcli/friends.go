@@ -1,12 +1,12 @@
 package main
 
 import (
-	"os"
-
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/luigi"
 	"go.cryptoscope.co/muxrpc"
 	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/graph"
+	"go.cryptoscope.co/ssb/message"
 	"go.cryptoscope.co/ssb/plugins/friends"
 	"gopkg.in/urfave/cli.v2"
 )
@@ -17,6 +17,7 @@ var friendsCmd = &cli.Command{
 		friendsIsFollowingCmd,
 		friendsBlocksCmd,
 		friendsHopsCmd,
+		friendsGraphCmd,
 	},
 }
 
@@ -65,8 +66,7 @@ var friendsIsFollowingCmd = &cli.Command{
 		}
 
 		log.Log("event", "friends.isFollowing", "is", is)
-
-		return nil
+		return render(ctx, is)
 	},
 }
 var friendsHopsCmd = &cli.Command{
@@ -97,7 +97,10 @@ var friendsHopsCmd = &cli.Command{
 			return err
 		}
 
-		snk := jsonDrain(os.Stdout)
+		snk, err := outputDrain(ctx)
+		if err != nil {
+			return err
+		}
 
 		err = luigi.Pump(longctx, snk, src)
 		log.Log("done", err)
@@ -126,10 +129,53 @@ var friendsBlocksCmd = &cli.Command{
 			return err
 		}
 
-		snk := jsonDrain(os.Stdout)
+		snk, err := outputDrain(ctx)
+		if err != nil {
+			return err
+		}
 
 		err = luigi.Pump(longctx, snk, src)
 		log.Log("done", err)
 		return err
 	},
 }
+
+var friendsGraphCmd = &cli.Command{
+	Name:      "graph",
+	Usage:     "export the follow graph as a list of edges",
+	UsageText: "graph [--include-self-follows]. every pair of feeds is one edge {from,to,following,blocking} with the state of the latest contact message, unfollowed ones are left out",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{Name: "include-self-follows", Usage: "keep the edges of feeds that follow or block themselves"},
+	},
+	Action: func(ctx *cli.Context) error {
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		var args message.MessagesByTypeArgs
+		args.Type = "contact"
+		args.Keys = true
+		args.MarshalType = ssb.KeyValueRaw{}
+		src, err := client.MessagesByType(args)
+		if err != nil {
+			return errors.Wrap(err, "friends graph: failed to stream contact messages")
+		}
+
+		exp := graph.NewExport(ctx.Bool("include-self-follows"))
+		for {
+			v, err := src.Next(longctx)
+			if luigi.IsEOS(err) {
+				break
+			} else if err != nil {
+				return errors.Wrap(err, "friends graph: stream failed")
+			}
+			msg, ok := v.(ssb.Message)
+			if !ok {
+				return errors.Errorf("friends graph: unexpected stream type %T", v)
+			}
+			exp.Add(msg)
+		}
+		return render(ctx, exp.Edges())
+	},
+}
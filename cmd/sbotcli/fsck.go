@@ -0,0 +1,107 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/base64"
+	"runtime"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	mksbot "go.cryptoscope.co/ssb/sbot"
+	cli "gopkg.in/urfave/cli.v2"
+)
+
+var fsckCmd = &cli.Command{
+	Name:  "fsck",
+	Usage: "verify the signatures and hash chains of all feeds in an offline repo",
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "repo", Usage: "path of the repo to check (the bot using it needs to be stopped)"},
+		&cli.IntFlag{Name: "parallel", Value: runtime.NumCPU(), Usage: "how many feeds to verify at the same time"},
+		&cli.StringFlag{Name: "hmac", Usage: "base64 encoded hmac key, if the network signs with one"},
+		&cli.BoolFlag{Name: "fix", Usage: "truncate broken feeds after their last valid message"},
+	},
+	Action: func(ctx *cli.Context) error {
+		repoPath := ctx.String("repo")
+		if repoPath == "" {
+			return errors.Errorf("fsck: --repo is required")
+		}
+
+		opts := []mksbot.Option{
+			mksbot.WithRepoPath(repoPath),
+			mksbot.WithInfo(kitlog.With(log, "unit", "sbot")),
+			mksbot.DisableNetworkNode(),
+			mksbot.DisableLiveIndexMode(),
+		}
+		if h := ctx.String("hmac"); h != "" {
+			key, err := base64.StdEncoding.DecodeString(h)
+			if err != nil {
+				return errors.Wrap(err, "fsck: invalid hmac key")
+			}
+			opts = append(opts, mksbot.WithHMACSigning(key))
+		}
+
+		sbot, err := mksbot.New(opts...)
+		if err != nil {
+			return errors.Wrap(err, "fsck: failed to open repo")
+		}
+		defer func() {
+			sbot.Shutdown()
+			if err := sbot.Close(); err != nil {
+				level.Error(log).Log("event", "failed to close repo", "err", err)
+			}
+		}()
+
+		level.Info(log).Log("event", "waiting for indexes to catch up")
+		sbot.WaitUntilIndexesAreSynced()
+
+		var report fsckReport
+		err = sbot.FSCK(
+			mksbot.FSCKWithMode(mksbot.FSCKModeVerify),
+			mksbot.FSCKWithParallel(ctx.Int("parallel")),
+			mksbot.FSCKWithProgress(func(percentage float64, timeLeft time.Duration) {
+				level.Info(log).Log("event", "fsck-progress", "done", percentage, "time-left", timeLeft.String())
+			}),
+		)
+		broken, ok := err.(mksbot.ErrBrokenFeeds)
+		if err != nil && !ok {
+			return errors.Wrap(err, "fsck: verification failed")
+		}
+
+		for _, p := range broken.Feeds {
+			fr := fsckFeedReport{
+				Feed:     p.Feed.Ref(),
+				FirstBad: p.FirstBad,
+				Reason:   p.Reason.Error(),
+			}
+			if ctx.Bool("fix") {
+				if err := sbot.TruncateFeed(p.Feed, p.FirstBad-1); err != nil {
+					return errors.Wrapf(err, "fsck: failed to truncate %s", fr.Feed)
+				}
+				fr.Truncated = true
+			}
+			report.Broken = append(report.Broken, fr)
+		}
+
+		if err := render(ctx, report); err != nil {
+			return err
+		}
+		if len(report.Broken) > 0 && !ctx.Bool("fix") {
+			return errors.Errorf("fsck: %d broken feeds", len(report.Broken))
+		}
+		return nil
+	},
+}
+
+type fsckReport struct {
+	Broken []fsckFeedReport `json:"broken"`
+}
+
+type fsckFeedReport struct {
+	Feed      string `json:"feed"`
+	FirstBad  int64  `json:"firstBad"`
+	Reason    string `json:"reason"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	cli "gopkg.in/urfave/cli.v2"
+
+	"go.cryptoscope.co/ssb"
+)
+
+var gossipCmd = &cli.Command{
+	Name:  "gossip",
+	Usage: "look at the connections and replication of the server",
+	Subcommands: []*cli.Command{
+		gossipLogCmd,
+	},
+}
+
+var gossipLogCmd = &cli.Command{
+	Name:  "log",
+	Usage: "print the recent connects, disconnects, failed dials and handshakes and replications of the server",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{Name: "live", Usage: "keep printing new events"},
+		&cli.BoolFlag{Name: "raw", Usage: "print the events with --output instead of one line each"},
+	},
+	Action: func(ctx *cli.Context) error {
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+		src, err := client.GossipLog(ctx.Bool("live"))
+		if err != nil {
+			return err
+		}
+
+		var snk luigi.Sink
+		if ctx.Bool("raw") {
+			snk, err = outputDrain(ctx)
+			if err != nil {
+				return err
+			}
+		} else {
+			snk = luigi.FuncSink(func(_ context.Context, v interface{}, err error) error {
+				if luigi.IsEOS(err) {
+					return nil
+				} else if err != nil {
+					return err
+				}
+				evt, ok := v.(ssb.GossipEvent)
+				if !ok {
+					return errors.Errorf("gossip log: unexpected event type %T", v)
+				}
+				_, err = fmt.Fprintln(os.Stdout, formatGossipEvent(evt))
+				return err
+			})
+		}
+		err = luigi.Pump(longctx, snk, src)
+		return errors.Wrap(err, "gossip log: stream failed")
+	},
+}
+
+// formatGossipEvent makes one line of evt: the local time, the event, the direction and who, and the error if there is one
+func formatGossipEvent(evt ssb.GossipEvent) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s  %-17s", evt.Time.Local().Format("2006-01-02 15:04:05.000"), evt.Event)
+	switch ssb.ConnDirection(evt.Dir) {
+	case ssb.ConnIncoming:
+		b.WriteString(" <- ")
+	case ssb.ConnOutgoing:
+		b.WriteString(" -> ")
+	default:
+		b.WriteString("    ")
+	}
+	var who []string
+	if evt.Peer != "" {
+		if ref, err := ssb.ParseFeedRef(evt.Peer); err == nil {
+			who = append(who, ref.ShortRef())
+		} else {
+			who = append(who, evt.Peer)
+		}
+	}
+	if evt.Addr != "" {
+		who = append(who, evt.Addr)
+	}
+	b.WriteString(strings.Join(who, " "))
+	if evt.Err != "" {
+		fmt.Fprintf(&b, "  (%s)", evt.Err)
+	}
+	return b.String()
+}
@@ -0,0 +1,44 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	cli "gopkg.in/urfave/cli.v2"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/qrcode"
+)
+
+var idCmd = &cli.Command{
+	Name:  "id",
+	Usage: "print the public identity of the key file, to share it with others",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{Name: "uri", Usage: "print the ssb:feed/... URI instead of the @feed"},
+		&cli.BoolFlag{Name: "qr", Usage: "also draw it as a QR code"},
+		&cli.BoolFlag{Name: "invert", Usage: "draw the QR code for terminals with a light background"},
+	},
+	Action: func(ctx *cli.Context) error {
+		kp, err := ssb.LoadKeyPair(ctx.String("key"))
+		if err != nil {
+			return errors.Wrap(err, "id: failed to load key pair")
+		}
+
+		id := kp.Id.Ref()
+		if ctx.Bool("uri") {
+			id = kp.Id.URI()
+		}
+		fmt.Println(id)
+
+		if ctx.Bool("qr") {
+			code, err := qrcode.Encode([]byte(id))
+			if err != nil {
+				return errors.Wrap(err, "id: failed to make QR code")
+			}
+			fmt.Print(code.Terminal(ctx.Bool("invert")))
+		}
+		return nil
+	},
+}
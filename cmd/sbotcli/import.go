@@ -0,0 +1,140 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+	cli "gopkg.in/urfave/cli.v2"
+
+	mksbot "go.cryptoscope.co/ssb/sbot"
+)
+
+var importCmd = &cli.Command{
+	Name:      "import",
+	Usage:     "import the feeds in the files of a directory into an offline repo",
+	UsageText: "each file holds the signed messages of one feed as JSON, plain or with key and value. Messages the repo already has are skipped. The indexes catch up on the next start of the bot.",
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "repo", Usage: "path of the repo to import into (the bot using it needs to be stopped)"},
+		&cli.IntFlag{Name: "parallel", Value: 1, Usage: "how many feeds to import at the same time (each feed is imported in order)"},
+		&cli.StringFlag{Name: "hmac", Usage: "base64 encoded hmac key, if the network signs with one"},
+	},
+	Action: func(ctx *cli.Context) error {
+		dir := ctx.Args().Get(0)
+		if dir == "" {
+			return errors.New("import: need a directory")
+		}
+		repoPath := ctx.String("repo")
+		if repoPath == "" {
+			return errors.Errorf("import: --repo is required")
+		}
+		parallel := ctx.Int("parallel")
+		if parallel < 1 {
+			return errors.Errorf("import: --parallel needs to be at least 1")
+		}
+
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return errors.Wrap(err, "import: failed to read directory")
+		}
+		var files []string
+		for _, e := range entries {
+			if e.Mode().IsRegular() {
+				files = append(files, filepath.Join(dir, e.Name()))
+			}
+		}
+
+		opts := []mksbot.Option{
+			mksbot.WithRepoPath(repoPath),
+			mksbot.WithInfo(kitlog.With(log, "unit", "sbot")),
+			mksbot.DisableNetworkNode(),
+		}
+		if h := ctx.String("hmac"); h != "" {
+			key, err := base64.StdEncoding.DecodeString(h)
+			if err != nil {
+				return errors.Wrap(err, "import: invalid hmac key")
+			}
+			opts = append(opts, mksbot.WithHMACSigning(key))
+		}
+
+		sbot, err := mksbot.New(opts...)
+		if err != nil {
+			return errors.Wrap(err, "import: failed to open repo")
+		}
+		defer func() {
+			sbot.Shutdown()
+			if err := sbot.Close(); err != nil {
+				level.Error(log).Log("event", "failed to close repo", "err", err)
+			}
+		}()
+
+		// the latest message of each feed comes from the userFeeds index
+		level.Info(log).Log("event", "waiting for indexes to catch up")
+		sbot.WaitUntilIndexesAreSynced()
+
+		var (
+			grp      errgroup.Group
+			slots    = make(chan struct{}, parallel)
+			imported int64
+			failed   int64
+		)
+		for _, fname := range files {
+			fname := fname
+			slots <- struct{}{}
+			grp.Go(func() error {
+				defer func() { <-slots }()
+				n, err := importFile(sbot, fname)
+				if err != nil {
+					atomic.AddInt64(&failed, 1)
+					level.Error(log).Log("event", "import failed", "file", fname, "err", err)
+					return nil
+				}
+				atomic.AddInt64(&imported, n)
+				return nil
+			})
+		}
+		grp.Wait()
+
+		level.Info(log).Log("event", "import done", "files", len(files), "messages", imported, "failed", failed)
+		if failed > 0 {
+			return errors.Errorf("import: %d of %d files failed", failed, len(files))
+		}
+		return nil
+	},
+}
+
+// importFile imports the feed in fname and logs its progress every few seconds
+func importFile(sbot *mksbot.Sbot, fname string) (int64, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	start := time.Now()
+	last := start
+	feedLog := kitlog.With(log, "file", filepath.Base(fname))
+	res, err := sbot.ImportFeed(longctx, f, func(seq int64) {
+		if time.Since(last) > 3*time.Second {
+			last = time.Now()
+			level.Info(feedLog).Log("event", "import-progress", "seq", seq)
+		}
+	})
+	if res.Feed != nil {
+		feedLog = kitlog.With(feedLog, "feed", res.Feed.Ref())
+	}
+	if err != nil {
+		return res.Imported, err
+	}
+	level.Info(feedLog).Log("event", "imported", "new", res.Imported, "skipped", res.Skipped, "took", time.Since(start))
+	return res.Imported, nil
+}
@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/muxrpc"
+	cli "gopkg.in/urfave/cli.v2"
+
+	"go.cryptoscope.co/ssb/invite"
+)
+
+var inviteCmd = &cli.Command{
+	Name:  "invite",
+	Usage: "join the network with the invite code of a pub",
+	Subcommands: []*cli.Command{
+		inviteAcceptCmd,
+	},
+}
+
+var inviteAcceptCmd = &cli.Command{
+	Name:      "accept",
+	ArgsUsage: "host:port:@pub.ed25519~seed",
+	UsageText: `redeems the invite with the pub, which follows the feed of the bot back.
+The bot then follows the pub and publishes its address, so that it connects to it.`,
+	Flags: []cli.Flag{
+		&cli.DurationFlag{Name: "timeout", Value: 30 * time.Second, Usage: "how long to wait for the pub"},
+	},
+	Action: func(ctx *cli.Context) error {
+		if ctx.NArg() != 1 {
+			return errors.New("invite/accept: expected the invite code as the only argument")
+		}
+		code := strings.Trim(strings.TrimSpace(ctx.Args().First()), `"`)
+		tok, err := invite.ParseLegacyToken(code)
+		if err != nil {
+			return errors.Wrapf(err, "invite/accept: invalid invite code, expected host:port:@key~seed")
+		}
+		pubMsg, err := invite.NewPubMessageFromToken(tok)
+		if err != nil {
+			return errors.Wrap(err, "invite/accept: invalid invite code")
+		}
+
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+		self, err := client.Whoami()
+		if err != nil {
+			return errors.Wrap(err, "invite/accept: failed to get the feed of the bot")
+		}
+
+		rctx, cancel := context.WithTimeout(longctx, ctx.Duration("timeout"))
+		defer cancel()
+		if err := invite.Redeem(rctx, tok, self); err != nil {
+			return errors.Wrapf(err, "invite/accept: pub %s didn't take the invite", tok.Peer.Ref())
+		}
+		log.Log("event", "redeemed", "pub", tok.Peer.Ref())
+
+		type reply map[string]interface{}
+		follow := map[string]interface{}{
+			"type":      "contact",
+			"contact":   tok.Peer.Ref(),
+			"following": true,
+			"pub":       true,
+		}
+		if _, err := client.Async(longctx, reply{}, muxrpc.Method{"publish"}, follow); err != nil {
+			return errors.Wrapf(err, "invite/accept: failed to follow pub %s", tok.Peer.Ref())
+		}
+		if _, err := client.Async(longctx, reply{}, muxrpc.Method{"publish"}, pubMsg); err != nil {
+			return errors.Wrapf(err, "invite/accept: failed to publish the address of pub %s", tok.Peer.Ref())
+		}
+		log.Log("event", "published", "type", "contact", "pub", tok.Peer.Ref())
+
+		return render(ctx, map[string]interface{}{
+			"pub":  tok.Peer.Ref(),
+			"feed": self.Ref(),
+		})
+	},
+}
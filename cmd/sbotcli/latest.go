@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/muxrpc"
+	"go.cryptoscope.co/ssb"
+	"golang.org/x/sync/errgroup"
+	cli "gopkg.in/urfave/cli.v2"
+)
+
+var latestCmd = &cli.Command{
+	Name:      "latest",
+	Usage:     "print the latest message of each feed",
+	UsageText: "uses getLatest for each --id (or one feed reference per line from stdin if none is passed), or the end of createHistoryStream if the bot has no getLatest. Feeds without messages are skipped, other failures are reported and make it exit with an error.",
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{Name: "id", Usage: "feed reference to look up (can be passed multiple times)"},
+		&cli.IntFlag{Name: "parallel", Value: 4, Usage: "how many getLatest calls to run at the same time"},
+	},
+	Action: func(ctx *cli.Context) error {
+		ids := ctx.StringSlice("id")
+		if len(ids) == 0 {
+			sc := bufio.NewScanner(os.Stdin)
+			for sc.Scan() {
+				if line := strings.TrimSpace(sc.Text()); line != "" {
+					ids = append(ids, line)
+				}
+			}
+			if err := sc.Err(); err != nil {
+				return errors.Wrap(err, "latest: failed to read feeds from stdin")
+			}
+		}
+
+		var feeds = make([]*ssb.FeedRef, len(ids))
+		for i, id := range ids {
+			var err error
+			feeds[i], err = ssb.ParseFeedRef(id)
+			if err != nil {
+				return errors.Wrapf(err, "latest: invalid feed reference %q", id)
+			}
+		}
+
+		parallel := ctx.Int("parallel")
+		if parallel < 1 {
+			return errors.Errorf("latest: --parallel needs to be at least 1")
+		}
+
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		out, err := newRenderer(ctx, os.Stdout)
+		if err != nil {
+			return err
+		}
+
+		var (
+			grp    errgroup.Group
+			slots  = make(chan struct{}, parallel)
+			failed int64
+		)
+		for _, feed := range feeds {
+			feed := feed
+			slots <- struct{}{}
+			grp.Go(func() error {
+				defer func() { <-slots }()
+
+				val, err := client.Async(longctx, mapMsg{}, muxrpc.Method{"getLatest"}, feed.Ref())
+				if err != nil {
+					// go-sbot has no getLatest and the JS one fails for feeds without messages, the history tells them apart
+					val, err = lastOfHistory(client, feed)
+				}
+				if luigi.IsEOS(err) {
+					level.Info(log).Log("event", "no messages", "feed", feed.Ref())
+					return nil
+				} else if err != nil {
+					level.Error(log).Log("event", "latest failed", "feed", feed.Ref(), "err", err)
+					atomic.AddInt64(&failed, 1)
+					return nil
+				}
+
+				return errors.Wrapf(out.Render(val), "latest: failed to print reply for %s", feed.Ref())
+			})
+		}
+		if err := grp.Wait(); err != nil {
+			return err
+		}
+		if failed > 0 {
+			return errors.Errorf("latest: failed to look up %d of %d feeds", failed, len(feeds))
+		}
+		return nil
+	},
+}
@@ -0,0 +1,206 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/ssb"
+	cli "gopkg.in/urfave/cli.v2"
+)
+
+var lintCmd = &cli.Command{
+	Name:      "lint",
+	Usage:     "check the envelope fields of the messages in a feed export, without verifying them",
+	UsageText: "reads one message per line (plain or with key and value, - for stdin) and reports messages where previous, author, sequence, timestamp, hash, content or signature are missing or have the wrong type",
+	Action: func(ctx *cli.Context) error {
+		fname := ctx.Args().Get(0)
+		if fname == "" {
+			return errors.New("lint: need a file (or - for stdin)")
+		}
+		var in io.Reader = os.Stdin
+		if fname != "-" {
+			f, err := os.Open(fname)
+			if err != nil {
+				return errors.Wrap(err, "lint: failed to open file")
+			}
+			defer f.Close()
+			in = f
+		}
+
+		out, err := newRenderer(ctx, os.Stdout)
+		if err != nil {
+			return err
+		}
+
+		sc := bufio.NewScanner(in)
+		sc.Buffer(make([]byte, 64*1024), 1024*1024)
+		var line, checked, broken int
+		for sc.Scan() {
+			line++
+			text := strings.TrimSpace(sc.Text())
+			if text == "" {
+				continue
+			}
+			checked++
+			problems := lintMessage([]byte(text))
+			if len(problems) == 0 {
+				continue
+			}
+			broken++
+			if err := out.Render(lintReport{Line: line, Problems: problems}); err != nil {
+				return err
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return errors.Wrapf(err, "lint: failed to read line %d", line+1)
+		}
+
+		if broken > 0 {
+			return errors.Errorf("lint: %d of %d messages have problems", broken, checked)
+		}
+		log.Log("event", "lint done", "messages", checked)
+		return nil
+	},
+}
+
+type lintReport struct {
+	Line     int      `json:"line"`
+	Problems []string `json:"problems"`
+}
+
+// lintMessage returns what is wrong with the envelope of a single message
+func lintMessage(data []byte) []string {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return []string{"not a JSON object: " + err.Error()}
+	}
+	if v, has := obj["value"]; has {
+		// key-value pair like createLogStream returns them
+		if err := json.Unmarshal(v, &obj); err != nil {
+			return []string{"value is not a JSON object"}
+		}
+	}
+
+	var problems []string
+	field := func(name string, check func(v interface{}) string) {
+		raw, has := obj[name]
+		if !has {
+			problems = append(problems, name+": missing")
+			return
+		}
+		var v interface{}
+		d := json.NewDecoder(strings.NewReader(string(raw)))
+		d.UseNumber()
+		if err := d.Decode(&v); err != nil {
+			problems = append(problems, name+": "+err.Error())
+			return
+		}
+		if p := check(v); p != "" {
+			problems = append(problems, name+": "+p)
+		}
+	}
+
+	var seq int64
+	field("sequence", func(v interface{}) string {
+		n, ok := v.(json.Number)
+		if !ok {
+			return fmt.Sprintf("expected a number, got %s", jsonType(v))
+		}
+		i, err := n.Int64()
+		if err != nil || i < 1 {
+			return fmt.Sprintf("expected a positive integer, got %s", n)
+		}
+		seq = i
+		return ""
+	})
+	field("previous", func(v interface{}) string {
+		if v == nil {
+			if seq > 1 {
+				return "is null but sequence is not 1"
+			}
+			return ""
+		}
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Sprintf("expected null or a message reference, got %s", jsonType(v))
+		}
+		if _, err := ssb.ParseMessageRef(s); err != nil {
+			return "not a message reference"
+		}
+		if seq == 1 {
+			return "set but sequence is 1"
+		}
+		return ""
+	})
+	field("author", func(v interface{}) string {
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Sprintf("expected a feed reference, got %s", jsonType(v))
+		}
+		if _, err := ssb.ParseFeedRef(s); err != nil {
+			return "not a feed reference"
+		}
+		return ""
+	})
+	field("timestamp", func(v interface{}) string {
+		if _, ok := v.(json.Number); !ok {
+			return fmt.Sprintf("expected a number, got %s", jsonType(v))
+		}
+		return ""
+	})
+	field("hash", func(v interface{}) string {
+		if s, ok := v.(string); !ok || s != "sha256" {
+			return fmt.Sprintf("expected \"sha256\", got %s", jsonType(v))
+		}
+		return ""
+	})
+	field("content", func(v interface{}) string {
+		switch tv := v.(type) {
+		case map[string]interface{}:
+			if _, ok := tv["type"].(string); !ok {
+				return "object without a type"
+			}
+		case string:
+			// encrypted content
+			if !strings.HasSuffix(tv, ".box") {
+				return "string content that isn't boxed"
+			}
+		default:
+			return fmt.Sprintf("expected an object or a boxed string, got %s", jsonType(v))
+		}
+		return ""
+	})
+	field("signature", func(v interface{}) string {
+		if s, ok := v.(string); !ok || !strings.HasSuffix(s, ".sig.ed25519") {
+			return fmt.Sprintf("expected an ed25519 signature, got %s", jsonType(v))
+		}
+		return ""
+	})
+	return problems
+}
+
+func jsonType(v interface{}) string {
+	switch tv := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "a boolean"
+	case json.Number:
+		return "a number"
+	case string:
+		return fmt.Sprintf("the string %q", tv)
+	case []interface{}:
+		return "an array"
+	case map[string]interface{}:
+		return "an object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
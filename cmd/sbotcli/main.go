@@ -5,12 +5,9 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"context"
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
-	"io"
 	"net"
 	"os"
 	"os/signal"
@@ -24,7 +21,6 @@ import (
 	"github.com/go-kit/kit/log/level"
 	"github.com/go-kit/kit/log/term"
 	"github.com/pkg/errors"
-	goon "github.com/shurcooL/go-goon"
 	"go.cryptoscope.co/muxrpc"
 	"go.cryptoscope.co/netwrap"
 	"go.cryptoscope.co/secretstream"
@@ -58,7 +54,7 @@ func init() {
 	keyFileFlag.Value = filepath.Join(u.HomeDir, ".ssb-go", "secret")
 	unixSockFlag.Value = filepath.Join(u.HomeDir, ".ssb-go", "socket")
 
-	log = term.NewColorLogger(os.Stdout, kitlog.NewLogfmtLogger, colorFn)
+	log = term.NewColorLogger(os.Stderr, kitlog.NewLogfmtLogger, colorFn)
 }
 
 var app = cli.App{
@@ -70,25 +66,52 @@ var app = cli.App{
 		&cli.StringFlag{Name: "shscap", Value: "1KHLiKZvAvjbY1ziZEHMXawbCEIM6qwjCDm3VYRan/s=", Usage: "shs key"},
 		&cli.StringFlag{Name: "addr", Value: "localhost:8008", Usage: "tcp address of the sbot to connect to (or listen on)"},
 		&cli.StringFlag{Name: "remoteKey", Value: "", Usage: "the remote pubkey you are connecting to (by default the local key)"},
+		&cli.StringFlag{Name: "expect-key", Value: "", Usage: "abort unless the server turns out to be this @feed.ed25519 after connecting"},
 		&keyFileFlag,
 		&unixSockFlag,
+		&outputFlag,
+		&unbufferedFlag,
+		&quietFlag,
 		&cli.BoolFlag{Name: "verbose,vv", Usage: "print muxrpc packets"},
 	},
 
 	Before: initClient,
+	After:  flushOutput,
 	Commands: []*cli.Command{
+		backlinksCmd,
 		blobsCmd,
 		blockCmd,
+		channelCmd,
+		countCmd,
+		daemonCmd,
 		friendsCmd,
+		exportCmd,
+		fsckCmd,
+		idCmd,
+		importCmd,
+		inviteCmd,
+		lintCmd,
+		threadCmd,
+		mentionsCmd,
 		logStreamCmd,
 		typeStreamCmd,
 		historyStreamCmd,
+		userStreamCmd,
+		latestCmd,
 		replicateUptoCmd,
+		replicateFetchCmd,
 		callCmd,
+		configCmd,
 		connectCmd,
+		gossipCmd,
+		pingCmd,
 		queryCmd,
 		privateCmd,
 		publishCmd,
+		selftestCmd,
+		serveLocalCmd,
+		verifyMessageCmd,
+		verifySecretCmd,
 	},
 }
 
@@ -134,12 +157,50 @@ func initClient(ctx *cli.Context) error {
 		level.Warn(log).Log("event", "shutting down", "sig", s)
 		shutdownFunc()
 		time.Sleep(1 * time.Second)
+		flushOutput(ctx)
 		os.Exit(0)
 	}()
 	return nil
 }
 
 func newClient(ctx *cli.Context) (*ssbClient.Client, error) {
+	client, err := dialClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if ek := ctx.String("expect-key"); ek != "" {
+		if err := expectRemote(client, ek); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// expectRemote checks that the server we are connected to is the feed expected.
+// Over TCP that is the key of the secret handshake, over the unix socket the server is asked with whoami.
+func expectRemote(client *ssbClient.Client, expected string) error {
+	want, err := ssb.ParseFeedRef(expected)
+	if err != nil {
+		return errors.Wrap(err, "init: failed to parse --expect-key")
+	}
+	var got *ssb.FeedRef
+	if ra := client.Remote(); ra != nil {
+		got, err = ssb.GetFeedRefFromAddr(ra)
+	}
+	if got == nil {
+		got, err = client.Whoami()
+		if err != nil {
+			return errors.Wrap(err, "init: failed to get the key of the server for --expect-key")
+		}
+	}
+	if !got.Equal(want) {
+		return errors.Errorf("init: server key mismatch: expected %s but connected to %s", want.Ref(), got.Ref())
+	}
+	return nil
+}
+
+func dialClient(ctx *cli.Context) (*ssbClient.Client, error) {
 	sockPath := ctx.String("unixsock")
 	if sockPath != "" {
 		client, err := ssbClient.NewUnix(sockPath, ssbClient.WithContext(longctx))
@@ -220,18 +281,31 @@ var callCmd = &cli.Command{
 see https://scuttlebot.io/apis/scuttlebot/ssb.html#createlogstream-source  for more
 
 CAVEAT: only one argument...
+
+Methods with dots in their names, or deeper namespaces of plugins, can be given as a JSON array:
+  sbotcli call --method '["foo","bar","baz"]' arg1 arg2
+
+Sources like createLogStream are streamed. Use --paginate for huge array replies.
 `,
+	Flags: callFlags,
 	Action: func(ctx *cli.Context) error {
-		cmd := ctx.Args().Get(0)
+		// with --method all positional arguments are for the call
+		args := ctx.Args().Slice()
+		cmd := ctx.String("method")
 		if cmd == "" {
-			return errors.New("call: cmd can't be empty")
+			cmd = ctx.Args().Get(0)
+			if len(args) > 0 {
+				args = args[1:]
+			}
+		}
+		v, err := parseMethod(cmd)
+		if err != nil {
+			return err
 		}
-		args := ctx.Args().Slice()
-		v := strings.Split(cmd, ".")
 		var sendArgs []interface{}
-		if len(args) > 1 {
-			sendArgs = make([]interface{}, len(args)-1)
-			for i, v := range args[1:] {
+		if len(args) > 0 {
+			sendArgs = make([]interface{}, len(args))
+			for i, v := range args {
 				sendArgs[i] = v
 			}
 		}
@@ -241,17 +315,21 @@ CAVEAT: only one argument...
 			return err
 		}
 
+		asSource := ctx.Bool("source") || isSourceMethod(v)
+		if asSource || ctx.Int("paginate") > 0 || ctx.String("out") != "" {
+			return callPaginated(ctx, client, v, sendArgs, asSource)
+		}
+
 		var reply interface{}
-		val, err := client.Async(longctx, reply, muxrpc.Method(v), sendArgs...) // TODO: args[1:]...
+		val, err := client.Async(longctx, reply, v, sendArgs...) // TODO: args[1:]...
 		if err != nil {
+			if isNotAsyncError(err) {
+				return callPaginated(ctx, client, v, sendArgs, true)
+			}
 			return errors.Wrapf(err, "%s: call failed.", cmd)
 		}
 		log.Log("event", "call reply")
-		jsonReply, err := json.MarshalIndent(val, "", "  ")
-		if err != nil {
-			return errors.Wrapf(err, "%s: call failed.", cmd)
-		}
-		_, err = io.Copy(os.Stdout, bytes.NewReader(jsonReply))
+		err = render(ctx, val)
 		return errors.Wrapf(err, "%s: result copy failed.", cmd)
 	},
 }
@@ -280,13 +358,21 @@ var connectCmd = &cli.Command{
 			}
 		}
 		log.Log("event", "connect reply")
-		goon.Dump(val)
-		return nil
+		return render(ctx, val)
 	},
 }
 
 var blockCmd = &cli.Command{
-	Name: "block",
+	Name:  "block",
+	Usage: "block the feeds read from stdin, or edit the allow and deny lists with the subcommands",
+	Subcommands: []*cli.Command{
+		blockListsCmd,
+		blockAllowCmd,
+		blockDenyCmd,
+		blockRemoveCmd,
+		blockRestrictCmd,
+		blockUnrestrictCmd,
+	},
 	Action: func(ctx *cli.Context) error {
 		client, err := newClient(ctx)
 		if err != nil {
@@ -311,19 +397,38 @@ var blockCmd = &cli.Command{
 			return err
 		}
 		log.Log("event", "block reply")
-		goon.Dump(val)
-		return nil
+		return render(ctx, val)
 	},
 }
 
-var queryCmd = &cli.Command{
-	Name:   "qry",
-	Action: todo, //query,
-}
-
 var privateCmd = &cli.Command{
 	Name: "private",
 	Subcommands: []*cli.Command{
 		privateReadCmd,
+		privateReindexCmd,
+	},
+}
+
+var privateReindexCmd = &cli.Command{
+	Name:  "reindex",
+	Usage: "read the encrypted messages again with the key of a group or the keypair of the server, the progress is in the status",
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "group", Usage: "the %....cloaked id of the group, without it the keypair is used"},
+	},
+	Action: func(ctx *cli.Context) error {
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		var args []interface{}
+		if group := ctx.String("group"); group != "" {
+			args = append(args, map[string]string{"group": group})
+		}
+		val, err := client.Async(longctx, mapMsg{}, muxrpc.Method{"private", "reindex"}, args...)
+		if err != nil {
+			return errors.Wrap(err, "private/reindex: async call failed")
+		}
+		return render(ctx, val)
 	},
 }
@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/message"
+	cli "gopkg.in/urfave/cli.v2"
+)
+
+var mentionsCmd = &cli.Command{
+	Name:      "mentions",
+	Usage:     "print the posts that mention a feed, ours by default",
+	UsageText: "scans the post messages once, or keeps waiting for new ones with --live",
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "id", Usage: "the @feed to look for instead of ours"},
+		&cli.BoolFlag{Name: "live", Usage: "keep printing new mentions"},
+		&stallTimeoutFlag,
+	},
+	Action: func(ctx *cli.Context) error {
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		var who *ssb.FeedRef
+		if id := ctx.String("id"); id != "" {
+			who, err = ssb.ParseFeedRef(id)
+			if err != nil {
+				return errors.Wrap(err, "mentions: invalid --id")
+			}
+		} else {
+			who, err = client.Whoami()
+			if err != nil {
+				return errors.Wrap(err, "mentions: failed to get our feed")
+			}
+		}
+
+		var args message.MessagesByTypeArgs
+		args.Type = "post"
+		args.Keys = true
+		args.Live = ctx.Bool("live")
+		args.MarshalType = mapMsg{}
+		src, err := client.MessagesByType(args)
+		if err != nil {
+			return errors.Wrap(err, "mentions: source stream call failed")
+		}
+
+		out, err := newRenderer(ctx, os.Stdout)
+		if err != nil {
+			return err
+		}
+		snk := luigi.FuncSink(func(_ context.Context, v interface{}, err error) error {
+			if err != nil {
+				if luigi.IsEOS(err) {
+					return nil
+				}
+				return err
+			}
+			m, ok := mentionOf(v, who.Ref())
+			if !ok {
+				return nil
+			}
+			return out.Render(m)
+		})
+		err = pumpStream(ctx, client, snk, src)
+		return errors.Wrap(err, "mentions failed")
+	},
+}
+
+type mention struct {
+	Key    string `json:"key"`
+	Author string `json:"author"`
+	Text   string `json:"text"`
+}
+
+// mentionOf returns the mention if the post v links to ref in its mentions
+func mentionOf(v interface{}, ref string) (mention, bool) {
+	var m mention
+	msg, ok := asMapMsg(v)
+	if !ok {
+		return m, false
+	}
+	m.Key, _ = msg["key"].(string)
+	if val, ok := msg["value"].(map[string]interface{}); ok {
+		msg = val
+	}
+	content, ok := msg["content"].(map[string]interface{})
+	if !ok {
+		// private or nulled
+		return m, false
+	}
+	if !mentionsLink(content["mentions"], ref) {
+		return m, false
+	}
+	m.Author, _ = msg["author"].(string)
+	m.Text, _ = content["text"].(string)
+	return m, true
+}
+
+// mentionsLink handles the shapes mentions come in: a list or a single one, of objects with a link or plain refs
+func mentionsLink(mentions interface{}, ref string) bool {
+	switch tv := mentions.(type) {
+	case []interface{}:
+		for _, m := range tv {
+			if mentionsLink(m, ref) {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		link, _ := tv["link"].(string)
+		return link == ref
+	case string:
+		return tv == ref
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+	goon "github.com/shurcooL/go-goon"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/ssb"
+	cli "gopkg.in/urfave/cli.v2"
+)
+
+var outputFlag = cli.StringFlag{Name: "output", Value: "json", Usage: "how to print results (json, goon or text)"}
+
+var unbufferedFlag = cli.BoolFlag{Name: "unbuffered", Usage: "write every message of a stream right away, also when stdout isn't a terminal (live streams always are)"}
+
+// streamOut is stdout for the messages of streams, buffered for throughput when it's a pipe or file.
+// It is flushed after every message of live streams, with --unbuffered and on a terminal, and when the command ends.
+var streamOut = &bufferedOut{w: bufio.NewWriterSize(os.Stdout, 64*1024)}
+
+type bufferedOut struct {
+	mu sync.Mutex
+	w  *bufio.Writer
+}
+
+func (b *bufferedOut) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.w.Write(p)
+}
+
+func (b *bufferedOut) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.w.Flush()
+}
+
+// flushOutput writes what is left in streamOut, after the command or when it is interrupted
+func flushOutput(*cli.Context) error {
+	return errors.Wrap(streamOut.Flush(), "output: failed to flush")
+}
+
+// renderer formats the results of all commands the same way.
+// It is safe for concurrent use, each value is written in one piece.
+type renderer struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format string
+
+	flushEach bool // flush w after every value, if it can be flushed
+}
+
+func newRenderer(ctx *cli.Context, w io.Writer) (*renderer, error) {
+	f := ctx.String("output")
+	switch f {
+	case "json", "goon", "text":
+	case "":
+		f = "json"
+	default:
+		return nil, errors.Errorf("output: unsupported format %q (use json, goon or text)", f)
+	}
+	return &renderer{w: w, format: f}, nil
+}
+
+// render prints the result of a command to stdout in the format selected with --output
+func render(ctx *cli.Context, v interface{}) error {
+	r, err := newRenderer(ctx, os.Stdout)
+	if err != nil {
+		return err
+	}
+	return r.Render(v)
+}
+
+func (r *renderer) Render(v interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.write(v); err != nil {
+		return err
+	}
+	if f, ok := r.w.(interface{ Flush() error }); ok && r.flushEach {
+		return errors.Wrap(f.Flush(), "output: failed to flush")
+	}
+	return nil
+}
+
+func (r *renderer) write(v interface{}) error {
+	switch r.format {
+	case "goon":
+		_, err := goon.Fdump(r.w, v)
+		return err
+
+	case "text":
+		switch tv := v.(type) {
+		case string:
+			_, err := fmt.Fprintln(r.w, tv)
+			return err
+		case bool, int, int64, uint, float64:
+			_, err := fmt.Fprintln(r.w, tv)
+			return err
+		case ssb.Ref:
+			_, err := fmt.Fprintln(r.w, tv.Ref())
+			return err
+		}
+		// everything else is printed as one JSON object per line
+		b, err := json.Marshal(v)
+		if err != nil {
+			return errors.Wrap(err, "output: failed to encode value")
+		}
+		_, err = fmt.Fprintln(r.w, string(b))
+		return err
+
+	default:
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "output: failed to encode value")
+		}
+		_, err = fmt.Fprintln(r.w, string(b))
+		return err
+	}
+}
+
+// Drain returns a sink that renders every value of a stream
+func (r *renderer) Drain() luigi.Sink {
+	i := 0
+	return luigi.FuncSink(func(ctx context.Context, val interface{}, err error) error {
+		if luigi.IsEOS(err) {
+			if f, ok := r.w.(interface{ Flush() error }); ok {
+				return errors.Wrap(f.Flush(), "output: failed to flush")
+			}
+			return nil
+		} else if err != nil {
+			return errors.Wrapf(err, "output: failed to drain message %d", i)
+		}
+		if err := r.Render(val); err != nil {
+			return errors.Wrapf(err, "output: failed to write msg %d", i)
+		}
+		i++
+		return nil
+	})
+}
+
+// outputDrain renders a stream to stdout in the format selected with --output.
+// With --dedup-content repeated contents are left out.
+// Unless the stream is live, --unbuffered is set or stdout is a terminal the output is buffered.
+func outputDrain(ctx *cli.Context) (luigi.Sink, error) {
+	r, err := newRenderer(ctx, streamOut)
+	if err != nil {
+		return nil, err
+	}
+	r.flushEach = ctx.Bool("unbuffered") || ctx.Bool("live") || isTerminal(os.Stdout)
+	if ctx.Bool("dedup-content") {
+		return newContentDeduper(r.Drain()), nil
+	}
+	return r.Drain(), nil
+}
@@ -0,0 +1,118 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/base64"
+	"net"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/secretstream"
+	"golang.org/x/crypto/ed25519"
+	cli "gopkg.in/urfave/cli.v2"
+
+	"go.cryptoscope.co/ssb"
+)
+
+var pingCmd = &cli.Command{
+	Name:      "ping",
+	Usage:     "measure how long connecting and the secret handshake with a peer take",
+	UsageText: "ping @feed.ed25519 --addr host:port --count 5",
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "addr", Value: "localhost:8008", Usage: "tcp address of the peer"},
+		&cli.IntFlag{Name: "count", Value: 5, Usage: "how many handshakes to make"},
+		&cli.DurationFlag{Name: "interval", Value: time.Second, Usage: "pause between two handshakes"},
+		&cli.DurationFlag{Name: "timeout", Value: 10 * time.Second, Usage: "give up on a handshake after this long"},
+	},
+	Action: func(ctx *cli.Context) error {
+		ref, err := ssb.ParseFeedRef(ctx.Args().Get(0))
+		if err != nil {
+			return errors.Wrap(err, "ping: need the @feed of the peer")
+		}
+		count := ctx.Int("count")
+		if count < 1 {
+			return errors.New("ping: count has to be at least one")
+		}
+
+		localKey, err := ssb.LoadKeyPair(ctx.String("key"))
+		if err != nil {
+			return err
+		}
+		appKey, err := base64.StdEncoding.DecodeString(ctx.String("shscap"))
+		if err != nil {
+			return errors.Wrap(err, "ping: failed to decode shscap")
+		}
+		shsClient, err := secretstream.NewClient(localKey.Pair, appKey)
+		if err != nil {
+			return errors.Wrap(err, "ping: failed to make shs client")
+		}
+		wrap := shsClient.ConnWrapper(ed25519.PublicKey(ref.ID))
+
+		addr := ctx.String("addr")
+		timeout := ctx.Duration("timeout")
+		var stats pingStats
+		for i := 0; i < count; i++ {
+			if i > 0 {
+				select {
+				case <-time.After(ctx.Duration("interval")):
+				case <-longctx.Done():
+					return longctx.Err()
+				}
+			}
+
+			dial, shake, err := pingOnce(addr, wrap, timeout)
+			if err != nil {
+				stats.failed++
+				level.Warn(log).Log("event", "ping", "seq", i+1, "err", err)
+				continue
+			}
+			stats.add(dial + shake)
+			log.Log("event", "ping", "seq", i+1, "connect", dial, "handshake", shake, "total", dial+shake)
+		}
+
+		if stats.n == 0 {
+			return errors.Errorf("ping: no handshake with %s succeeded", ref.Ref())
+		}
+		log.Log("event", "ping summary", "peer", ref.ShortRef(), "ok", stats.n, "failed", stats.failed,
+			"min", stats.min, "avg", stats.sum/time.Duration(stats.n), "max", stats.max)
+		return nil
+	},
+}
+
+// pingOnce connects to addr, does the handshake and hangs up. It returns how long the two steps took.
+func pingOnce(addr string, wrap func(net.Conn) (net.Conn, error), timeout time.Duration) (time.Duration, time.Duration, error) {
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "connect failed")
+	}
+	dial := time.Since(start)
+
+	conn.SetDeadline(start.Add(timeout))
+	shsConn, err := wrap(conn)
+	if err != nil {
+		conn.Close()
+		return 0, 0, errors.Wrap(err, "handshake failed")
+	}
+	shake := time.Since(start) - dial
+	shsConn.Close()
+	return dial, shake, nil
+}
+
+type pingStats struct {
+	n, failed     int
+	min, max, sum time.Duration
+}
+
+func (ps *pingStats) add(d time.Duration) {
+	if ps.n == 0 || d < ps.min {
+		ps.min = d
+	}
+	if d > ps.max {
+		ps.max = d
+	}
+	ps.sum += d
+	ps.n++
+}
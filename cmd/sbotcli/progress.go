@@ -0,0 +1,105 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	cli "gopkg.in/urfave/cli.v2"
+)
+
+var quietFlag = cli.BoolFlag{Name: "quiet", Usage: "don't show the progress of long transfers on stderr"}
+
+// how often the progress line is redrawn
+const progressInterval = 250 * time.Millisecond
+
+// progress keeps one line on stderr up to date with how much of a transfer is done and how fast it goes.
+// stdout is left alone, so that the data can be piped somewhere else.
+type progress struct {
+	w     io.Writer
+	unit  string // "messages" or "bytes"
+	total int64  // zero if it isn't known
+
+	mu      sync.Mutex
+	n       int64
+	start   time.Time
+	drawn   time.Time
+	lineLen int
+}
+
+// newProgress returns a progress for total things of unit, total is zero if it isn't known.
+// With --quiet it doesn't print anything.
+func newProgress(ctx *cli.Context, unit string, total int64) *progress {
+	p := &progress{
+		w:     os.Stderr,
+		unit:  unit,
+		total: total,
+		start: time.Now(),
+	}
+	if ctx.Bool("quiet") {
+		p.w = nil
+	}
+	return p
+}
+
+// Add counts n more and redraws the line if it wasn't for a while
+func (p *progress) Add(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.n += n
+	if now := time.Now(); now.Sub(p.drawn) >= progressInterval {
+		p.drawn = now
+		p.draw()
+	}
+}
+
+// Write counts the bytes written through it, to use it with io.TeeReader and such
+func (p *progress) Write(b []byte) (int, error) {
+	p.Add(int64(len(b)))
+	return len(b), nil
+}
+
+// Done draws the final state and ends the line
+func (p *progress) Done() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.draw()
+	if p.w != nil {
+		fmt.Fprintln(p.w)
+	}
+}
+
+// draw prints the current state over the last one. p.mu has to be held.
+func (p *progress) draw() {
+	if p.w == nil {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(p.format(p.n))
+	if p.total > 0 {
+		fmt.Fprintf(&b, " / %s (%.1f%%)", p.format(p.total), float64(p.n)/float64(p.total)*100)
+	}
+	if took := time.Since(p.start).Seconds(); took > 0 {
+		fmt.Fprintf(&b, "  %s/s", p.format(int64(float64(p.n)/took)))
+	}
+	line := b.String()
+	pad := p.lineLen - len(line)
+	if pad < 0 {
+		pad = 0
+	}
+	p.lineLen = len(line)
+	fmt.Fprintf(p.w, "\r%s%s", line, strings.Repeat(" ", pad))
+}
+
+func (p *progress) format(n int64) string {
+	if p.unit == "bytes" {
+		return humanize.Bytes(uint64(n))
+	}
+	return fmt.Sprintf("%d %s", n, p.unit)
+}
@@ -7,7 +7,6 @@ import (
 	"os"
 
 	"github.com/pkg/errors"
-	goon "github.com/shurcooL/go-goon"
 	"go.cryptoscope.co/muxrpc"
 	"go.cryptoscope.co/ssb"
 	cli "gopkg.in/urfave/cli.v2"
@@ -22,19 +21,36 @@ var publishCmd = &cli.Command{
 		publishAboutCmd,
 		publishContactCmd,
 		publishVoteCmd,
+		publishTemplateCmd,
 	},
 }
 
 var publishRawCmd = &cli.Command{
 	Name:      "raw",
-	UsageText: "reads JSON from stdin and publishes that as content",
+	ArgsUsage: "[key=value | key:json=value ...]",
+	UsageText: `reads JSON from stdin and publishes that as content.
+If arguments are given, the content is built from them instead:
+key=value sets key to the string value, key:json=value parses value as JSON.
+
+	sbotcli publish raw type=test count:json=3 tags:json='["a","b"]'`,
 	// TODO: add private
 
 	Action: func(ctx *cli.Context) error {
 		var content interface{}
-		err := json.NewDecoder(os.Stdin).Decode(&content)
-		if err != nil {
-			return errors.Wrapf(err, "publish/raw: invalid json input from stdin")
+		if ctx.NArg() > 0 {
+			pairs, err := parseContentPairs(ctx.Args().Slice())
+			if err != nil {
+				return err
+			}
+			if err := validateContent(pairs); err != nil {
+				return err
+			}
+			content = pairs
+		} else {
+			err := json.NewDecoder(os.Stdin).Decode(&content)
+			if err != nil {
+				return errors.Wrapf(err, "publish/raw: invalid json input from stdin")
+			}
 		}
 
 		client, err := newClient(ctx)
@@ -48,25 +64,74 @@ var publishRawCmd = &cli.Command{
 			return errors.Wrapf(err, "publish call failed.")
 		}
 		log.Log("event", "published", "type", "raw")
-		goon.Dump(v)
-		return nil
+		return render(ctx, v)
 	},
 }
 
 var publishPostCmd = &cli.Command{
 	Name:      "post",
-	ArgsUsage: "text of the post",
+	ArgsUsage: "text of the post | key=value key:json=value ...",
+	UsageText: `publishes the text as a post. The content can also be given as two or more pairs instead:
+key=value sets key to the string value, key:json=value parses value as JSON.
+A single argument is always the text, even if it looks like a pair.
+
+	sbotcli publish post text="hello" channel=ssb mentions:json='[{"link":"@...ed25519","name":"alice"}]'`,
 	Flags: []cli.Flag{
 		&cli.StringFlag{Name: "root", Value: "", Usage: "the ID of the first message of the thread"},
 		// TODO: Slice of branches
 		&cli.StringFlag{Name: "branch", Value: "", Usage: "the post ID that is beeing replied to"},
 
 		&cli.StringSliceFlag{Name: "recps", Usage: "as a PM to these feeds"},
+		&cli.StringFlag{Name: "channel", Usage: "post in this #channel"},
+		&cli.StringFlag{Name: "text", Usage: "the text of the post, instead of the argument"},
+
+		&cli.BoolFlag{Name: "verify-roundtrip", Usage: "get the published message and check its signature and content"},
+		&cli.StringFlag{Name: "hmac", Usage: "base64 encoded hmac key to verify with, if the network signs with one"},
 	},
 	Action: func(ctx *cli.Context) error {
-		arg := map[string]interface{}{
-			"text": ctx.Args().First(),
-			"type": "post",
+		arg, hasPairs, err := contentFromArgs(ctx.Args().Slice())
+		if err != nil {
+			return err
+		}
+		if text := ctx.String("text"); text != "" {
+			if ctx.NArg() > 0 {
+				return errors.Errorf("publish/post: use either --text or arguments")
+			}
+			arg = map[string]interface{}{
+				"text": text,
+				"type": "post",
+			}
+		} else if hasPairs {
+			if tipe, has := arg["type"]; has && tipe != "post" {
+				return errors.Errorf("publish/post: type needs to be post, use publish raw for other types")
+			}
+			arg["type"] = "post"
+			if _, ok := arg["text"].(string); !ok {
+				return errors.Errorf("publish/post: text needs to be set to a string")
+			}
+			if err := validateContent(arg); err != nil {
+				return err
+			}
+		} else {
+			arg = map[string]interface{}{
+				"text": ctx.Args().First(),
+				"type": "post",
+			}
+		}
+		if c := ctx.String("channel"); c != "" {
+			if _, has := arg["channel"]; has {
+				return errors.Errorf("publish/post: use either --channel or channel=")
+			}
+			arg["channel"] = c
+		}
+		if c, has := arg["channel"]; has {
+			name, ok := c.(string)
+			if !ok {
+				return errors.Errorf("publish/post: channel needs to be a string")
+			}
+			if arg["channel"], err = normalizeChannel(name); err != nil {
+				return err
+			}
 		}
 		if r := ctx.String("root"); r != "" {
 			arg["root"] = r
@@ -77,6 +142,11 @@ var publishPostCmd = &cli.Command{
 			}
 		}
 
+		hmacKey, err := parseHMACKey(ctx.String("hmac"))
+		if err != nil {
+			return errors.Wrap(err, "publish/post")
+		}
+
 		client, err := newClient(ctx)
 		if err != nil {
 			return err
@@ -84,7 +154,8 @@ var publishPostCmd = &cli.Command{
 
 		type reply map[string]interface{}
 		var v interface{}
-		if recps := ctx.StringSlice("recps"); len(recps) > 0 {
+		recps := ctx.StringSlice("recps")
+		if len(recps) > 0 {
 			v, err = client.Async(longctx, reply{},
 				muxrpc.Method{"private", "publish"}, arg, recps)
 		} else {
@@ -96,7 +167,17 @@ var publishPostCmd = &cli.Command{
 		}
 
 		log.Log("event", "published", "type", "post")
-		goon.Dump(v)
+		if !ctx.Bool("verify-roundtrip") {
+			return render(ctx, v)
+		}
+
+		report := verifyRoundtrip(client, v, arg, len(recps) > 0, hmacKey)
+		if err := render(ctx, report); err != nil {
+			return err
+		}
+		if report.Failed > 0 {
+			return errors.Errorf("publish/post: %d of %d roundtrip checks failed", report.Failed, len(report.Checks))
+		}
 		return nil
 	},
 }
@@ -157,8 +238,7 @@ var publishVoteCmd = &cli.Command{
 		}
 
 		log.Log("event", "published", "type", "vote")
-		goon.Dump(v)
-		return nil
+		return render(ctx, v)
 	},
 }
 
@@ -200,8 +280,7 @@ var publishAboutCmd = &cli.Command{
 			return errors.Wrapf(err, "publish call failed.")
 		}
 		log.Log("event", "published", "type", "about")
-		goon.Dump(v)
-		return nil
+		return render(ctx, v)
 	},
 }
 
@@ -240,7 +319,6 @@ var publishContactCmd = &cli.Command{
 			return errors.Wrapf(err, "publish call failed.")
 		}
 		log.Log("event", "published", "type", "contact")
-		goon.Dump(v)
-		return nil
+		return render(ctx, v)
 	},
 }
@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// contentPairRe matches key=value and key:json=value arguments
+var contentPairRe = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_-]*)(:json)?=`)
+
+// parseContentPairs builds a content object from key=value and key:json=value arguments.
+// The value of key=value is used as a string, the one of key:json=value is parsed as JSON,
+// so that numbers, booleans, arrays and objects can be set as well.
+func parseContentPairs(args []string) (map[string]interface{}, error) {
+	content := make(map[string]interface{}, len(args))
+	for _, arg := range args {
+		m := contentPairRe.FindStringSubmatch(arg)
+		if m == nil {
+			return nil, errors.Errorf("publish: invalid argument %q (want key=value or key:json=value)", arg)
+		}
+		key, isJSON, value := m[1], m[2] != "", arg[len(m[0]):]
+		if _, has := content[key]; has {
+			return nil, errors.Errorf("publish: %s is set more than once", key)
+		}
+
+		if !isJSON {
+			content[key] = value
+			continue
+		}
+
+		var v interface{}
+		dec := json.NewDecoder(strings.NewReader(value))
+		dec.UseNumber()
+		if err := dec.Decode(&v); err != nil {
+			return nil, errors.Wrapf(err, "publish: invalid JSON for %s", key)
+		}
+		if dec.More() {
+			return nil, errors.Errorf("publish: more than one JSON value for %s", key)
+		}
+		content[key] = v
+	}
+	return content, nil
+}
+
+// contentFromArgs returns the content given as pairs on the command line.
+// has is false for less than two arguments, a single one is the text of publish post even if it looks like key=value.
+func contentFromArgs(args []string) (content map[string]interface{}, has bool, err error) {
+	if len(args) < 2 {
+		return nil, false, nil
+	}
+	content, err = parseContentPairs(args)
+	return content, true, err
+}
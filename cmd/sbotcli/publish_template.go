@@ -0,0 +1,138 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/muxrpc"
+	cli "gopkg.in/urfave/cli.v2"
+)
+
+var publishTemplateCmd = &cli.Command{
+	Name:      "template",
+	ArgsUsage: "file.tmpl",
+	UsageText: `renders a text/template to JSON content and publishes it.
+Variables are set with --var name=value and used as {{.name}}, {{json .name}} quotes them as a JSON string.`,
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{Name: "var", Usage: "name=value, can be given multiple times"},
+		&cli.BoolFlag{Name: "dry-run", Usage: "only print the rendered content"},
+
+		&cli.StringSliceFlag{Name: "recps", Usage: "as a PM to these feeds"},
+	},
+	Action: func(ctx *cli.Context) error {
+		fname := ctx.Args().First()
+		if fname == "" {
+			return errors.New("publish/template: need a template file")
+		}
+		tmplText, err := ioutil.ReadFile(fname)
+		if err != nil {
+			return errors.Wrap(err, "publish/template: failed to read template")
+		}
+
+		vars, err := parseTemplateVars(ctx.StringSlice("var"))
+		if err != nil {
+			return err
+		}
+
+		content, err := renderContentTemplate(fname, string(tmplText), vars)
+		if err != nil {
+			return err
+		}
+
+		if ctx.Bool("dry-run") {
+			return render(ctx, content)
+		}
+
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		type reply map[string]interface{}
+		var v interface{}
+		if recps := ctx.StringSlice("recps"); len(recps) > 0 {
+			v, err = client.Async(longctx, reply{},
+				muxrpc.Method{"private", "publish"}, content, recps)
+		} else {
+			v, err = client.Async(longctx, reply{},
+				muxrpc.Method{"publish"}, content)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "publish call failed.")
+		}
+		log.Log("event", "published", "type", content["type"])
+		return render(ctx, v)
+	},
+}
+
+func parseTemplateVars(args []string) (map[string]string, error) {
+	vars := make(map[string]string, len(args))
+	for _, arg := range args {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, errors.Errorf("publish/template: invalid --var %q (want name=value)", arg)
+		}
+		vars[kv[0]] = kv[1]
+	}
+	return vars, nil
+}
+
+// renderContentTemplate executes the template and checks that the result can be published as content
+func renderContentTemplate(name, text string, vars map[string]string) (map[string]interface{}, error) {
+	tmpl, err := template.New(name).
+		Option("missingkey=error").
+		Funcs(template.FuncMap{
+			"json": func(v interface{}) (string, error) {
+				b, err := json.Marshal(v)
+				return string(b), err
+			},
+		}).
+		Parse(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "publish/template: failed to parse template")
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return nil, errors.Wrap(err, "publish/template: failed to render template")
+	}
+
+	var content map[string]interface{}
+	dec := json.NewDecoder(&buf)
+	dec.UseNumber()
+	if err := dec.Decode(&content); err != nil {
+		return nil, errors.Wrap(err, "publish/template: rendered content is not a JSON object")
+	}
+	if dec.More() {
+		return nil, errors.New("publish/template: rendered content has more than one JSON value")
+	}
+	return content, validateContent(content)
+}
+
+// maxContentSize leaves room for the rest of the message in the 8k limit of legacy messages
+const maxContentSize = 8192 - 512
+
+// validateContent checks the rules every peer applies to public content
+func validateContent(content map[string]interface{}) error {
+	tipe, ok := content["type"].(string)
+	if !ok {
+		return errors.New("publish: content needs a type string")
+	}
+	if l := len(tipe); l < 3 || l > 52 {
+		return errors.Errorf("publish: type needs to be 3 to 52 characters long, %q is %d", tipe, l)
+	}
+	b, err := json.Marshal(content)
+	if err != nil {
+		return errors.Wrap(err, "publish: failed to encode content")
+	}
+	if len(b) > maxContentSize {
+		return errors.Errorf("publish: content is too large (%d bytes)", len(b))
+	}
+	return nil
+}
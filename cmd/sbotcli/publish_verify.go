@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/ssb"
+	ssbClient "go.cryptoscope.co/ssb/client"
+	"go.cryptoscope.co/ssb/message/legacy"
+)
+
+// roundtripReport is what publish --verify-roundtrip prints
+type roundtripReport struct {
+	Key string `json:"key"`
+	selftestReport
+}
+
+// publishedKey reads the key of the new message from the reply of publish
+func publishedKey(v interface{}) (*ssb.MessageRef, error) {
+	var key string
+	switch tv := v.(type) {
+	case string:
+		key = tv
+	case []byte:
+		key = string(tv)
+	case json.RawMessage:
+		if err := json.Unmarshal(tv, &key); err != nil {
+			key = string(tv)
+		}
+	case map[string]interface{}:
+		key, _ = tv["key"].(string)
+	}
+	ref, err := ssb.ParseMessageRef(strings.TrimSpace(key))
+	return ref, errors.Wrapf(err, "unexpected reply %v", v)
+}
+
+// verifyRoundtrip gets the message the bot published for us and checks it like a peer would:
+// the signature, that it's the message of the key we got, that it's from the bot and that the content is what we sent.
+// The content of private messages can only be checked to be encrypted.
+func verifyRoundtrip(client *ssbClient.Client, reply interface{}, sent interface{}, private bool, hmacKey *[32]byte) roundtripReport {
+	var report roundtripReport
+
+	ref, err := publishedKey(reply)
+	if !report.add("key", err) {
+		return report
+	}
+	report.Key = ref.Ref()
+
+	raw, err := client.Get(ref)
+	if !report.add("get", err) {
+		return report
+	}
+
+	if ref.Algo != ssb.RefAlgoMessageSSB1 {
+		report.add("verify signature", errors.Errorf("can only verify %s messages, got %s", ssb.RefAlgoMessageSSB1, ref.Algo))
+		return report
+	}
+	gotKey, dmsg, err := legacy.Verify(raw, hmacKey)
+	if !report.add("verify signature", err) {
+		return report
+	}
+
+	err = nil
+	if !gotKey.Equal(*ref) {
+		err = errors.Errorf("published as %s but the message hashes to %s", ref.Ref(), gotKey.Ref())
+	}
+	report.add("message key", err)
+
+	self, err := client.Whoami()
+	if err == nil && !dmsg.Author.Equal(self) {
+		err = errors.Errorf("author is %s, not the bot %s", dmsg.Author.Ref(), self.Ref())
+	}
+	report.add("author", err)
+
+	if private {
+		var boxed string
+		err = json.Unmarshal(dmsg.Content, &boxed)
+		if err == nil && !strings.HasSuffix(boxed, ".box") {
+			err = errors.Errorf("content isn't a box")
+		}
+		report.add("content is encrypted", err)
+		return report
+	}
+	report.add("content", sameJSON(sent, dmsg.Content))
+	return report
+}
+
+// sameJSON compares what we sent with what was signed, after both went through JSON
+func sameJSON(sent interface{}, got json.RawMessage) error {
+	sentJSON, err := json.Marshal(sent)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode sent content")
+	}
+	var want, have interface{}
+	if err := json.Unmarshal(sentJSON, &want); err != nil {
+		return errors.Wrap(err, "failed to decode sent content")
+	}
+	if err := json.Unmarshal(got, &have); err != nil {
+		return errors.Wrap(err, "failed to decode published content")
+	}
+	if !reflect.DeepEqual(want, have) {
+		return errors.Errorf("sent %s but the message has %s", sentJSON, got)
+	}
+	return nil
+}
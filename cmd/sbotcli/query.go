@@ -0,0 +1,174 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/muxrpc"
+	"go.cryptoscope.co/ssb"
+	cli "gopkg.in/urfave/cli.v2"
+)
+
+var queryCmd = &cli.Command{
+	Name:  "qry",
+	Usage: "stream the results of a query.read (ssb-query) call",
+	UsageText: `either send a flumeview-query from a file (- for stdin), like
+	[{"$filter":{"value":{"content":{"type":"post"}}}}]
+or build one with --type, --author, --channel and --since`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "filter-file", Usage: "json file with the query: a list of $filter/$map/$sort/$reduce stages or a single one"},
+		&cli.StringFlag{Name: "type", Usage: "only messages with this content type"},
+		&cli.StringFlag{Name: "author", Usage: "only messages of this @feed"},
+		&cli.StringFlag{Name: "channel", Usage: "only messages in this channel"},
+		&cli.DurationFlag{Name: "since", Usage: "only messages claimed to be younger than this"},
+		&cli.IntFlag{Name: "limit", Value: -1},
+		&cli.BoolFlag{Name: "reverse"},
+		&cli.BoolFlag{Name: "live"},
+		&cli.BoolFlag{Name: "print-query", Usage: "print the query instead of sending it"},
+		&dedupContentFlag,
+	},
+	Action: func(ctx *cli.Context) error {
+		query, err := queryFromFlags(ctx)
+		if err != nil {
+			return err
+		}
+		if ctx.Bool("print-query") {
+			return json.NewEncoder(os.Stdout).Encode(query)
+		}
+
+		args := map[string]interface{}{
+			"query":   query,
+			"reverse": ctx.Bool("reverse"),
+			"live":    ctx.Bool("live"),
+		}
+		if l := ctx.Int("limit"); l >= 0 {
+			args["limit"] = l
+		}
+
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+		src, err := client.Source(longctx, mapMsg{}, muxrpc.Method{"query", "read"}, args)
+		if err != nil {
+			return queryError(err)
+		}
+		snk, err := outputDrain(ctx)
+		if err != nil {
+			return err
+		}
+		return queryError(pumpStream(ctx, client, snk, src))
+	},
+}
+
+// queryStages are the operators of flumeview-query
+var queryStages = map[string]bool{"$filter": true, "$map": true, "$sort": true, "$reduce": true}
+
+// queryFromFlags reads and checks the query file or builds one from the other flags
+func queryFromFlags(ctx *cli.Context) ([]map[string]json.RawMessage, error) {
+	built := ctx.String("type") != "" || ctx.String("author") != "" || ctx.String("channel") != "" || ctx.Duration("since") > 0
+	path := ctx.String("filter-file")
+	switch {
+	case path != "" && built:
+		return nil, errors.New("qry: use either --filter-file or the query builder flags")
+	case path == "" && !built:
+		return nil, errors.New("qry: need --filter-file or one of --type, --author, --channel and --since")
+	case built:
+		return buildQuery(ctx)
+	}
+
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "qry: failed to read filter file")
+	}
+	return parseQuery(data)
+}
+
+// parseQuery accepts a list of stages or a single one and makes sure every stage has exactly one known operator
+func parseQuery(data []byte) ([]map[string]json.RawMessage, error) {
+	var stages []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &stages); err != nil {
+		var single map[string]json.RawMessage
+		if err2 := json.Unmarshal(data, &single); err2 != nil {
+			return nil, errors.Wrap(err, "qry: the query isn't a json list or object")
+		}
+		stages = append(stages, single)
+	}
+	if len(stages) == 0 {
+		return nil, errors.New("qry: the query is empty")
+	}
+	for i, stage := range stages {
+		if len(stage) != 1 {
+			return nil, errors.Errorf("qry: stage %d needs exactly one operator but has %d", i, len(stage))
+		}
+		for op, arg := range stage {
+			if !queryStages[op] {
+				return nil, errors.Errorf("qry: stage %d: unknown operator %q (use $filter, $map, $sort or $reduce)", i, op)
+			}
+			var v interface{}
+			if err := json.Unmarshal(arg, &v); err != nil {
+				return nil, errors.Wrapf(err, "qry: stage %d: invalid argument of %s", i, op)
+			}
+		}
+	}
+	return stages, nil
+}
+
+// buildQuery makes a single $filter stage out of the builder flags
+func buildQuery(ctx *cli.Context) ([]map[string]json.RawMessage, error) {
+	value := map[string]interface{}{}
+	content := map[string]interface{}{}
+
+	if t := ctx.String("type"); t != "" {
+		content["type"] = t
+	}
+	if c := ctx.String("channel"); c != "" {
+		content["channel"] = strings.TrimPrefix(c, "#")
+	}
+	if a := ctx.String("author"); a != "" {
+		author, err := ssb.ParseFeedRef(a)
+		if err != nil {
+			return nil, errors.Wrap(err, "qry: invalid --author")
+		}
+		value["author"] = author.Ref()
+	}
+	if since := ctx.Duration("since"); since > 0 {
+		from := time.Now().Add(-since).UnixNano() / int64(time.Millisecond)
+		value["timestamp"] = map[string]interface{}{"$gt": from}
+	}
+	if len(content) > 0 {
+		value["content"] = content
+	}
+
+	filter, err := json.Marshal(map[string]interface{}{"value": value})
+	if err != nil {
+		return nil, errors.Wrap(err, "qry: failed to build query")
+	}
+	return []map[string]json.RawMessage{{"$filter": filter}}, nil
+}
+
+// queryError points out errors the server sent back
+func queryError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if ce, ok := errors.Cause(err).(*muxrpc.CallError); ok {
+		if strings.Contains(ce.Error(), "no such command") || strings.Contains(ce.Error(), "not found") {
+			return errors.Errorf("qry: the bot has no query.read, is the ssb-query plugin installed? (%s)", ce.Error())
+		}
+		return errors.Errorf("qry: the bot rejected the query: %s", ce.Error())
+	}
+	return errors.Wrap(err, "qry: query failed")
+}
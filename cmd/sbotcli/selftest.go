@@ -0,0 +1,150 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/message/legacy"
+	cli "gopkg.in/urfave/cli.v2"
+)
+
+var selftestCmd = &cli.Command{
+	Name:  "selftest",
+	Usage: "sign and verify a test message offline with the loaded key, to check encoding and signing of this build",
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "hmac", Usage: "base64 encoded hmac key, if the network signs with one"},
+	},
+	Action: func(ctx *cli.Context) error {
+		kp, err := ssb.LoadKeyPair(ctx.String("key"))
+		if err != nil {
+			return errors.Wrap(err, "selftest: failed to load keypair")
+		}
+
+		hmacKey, err := parseHMACKey(ctx.String("hmac"))
+		if err != nil {
+			return errors.Wrap(err, "selftest")
+		}
+
+		report := runSelftest(kp, hmacKey)
+		if err := render(ctx, report); err != nil {
+			return err
+		}
+		if report.Failed > 0 {
+			return errors.Errorf("selftest: %d of %d checks failed", report.Failed, len(report.Checks))
+		}
+		return nil
+	},
+}
+
+// parseHMACKey decodes the base64 hmac key of a network, nil if h is empty
+func parseHMACKey(h string) (*[32]byte, error) {
+	if h == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(h)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid hmac key")
+	}
+	if len(key) != 32 {
+		return nil, errors.Errorf("hmac key needs to be 32 bytes, got %d", len(key))
+	}
+	hmacKey := new([32]byte)
+	copy(hmacKey[:], key)
+	return hmacKey, nil
+}
+
+type selftestReport struct {
+	Checks []selftestCheck `json:"checks"`
+	Failed int             `json:"failed"`
+}
+
+type selftestCheck struct {
+	Name  string `json:"name"`
+	Pass  bool   `json:"pass"`
+	Error string `json:"error,omitempty"`
+}
+
+func (r *selftestReport) add(name string, err error) bool {
+	c := selftestCheck{Name: name, Pass: err == nil}
+	if err != nil {
+		c.Error = err.Error()
+		r.Failed++
+	}
+	r.Checks = append(r.Checks, c)
+	return err == nil
+}
+
+// runSelftest signs a test message with kp and checks it the way a receiving peer would.
+// Checks that depend on an earlier, failed one are skipped.
+func runSelftest(kp *ssb.KeyPair, hmacKey *[32]byte) selftestReport {
+	var report selftestReport
+
+	msg := legacy.LegacyMessage{
+		Hash:      "sha256",
+		Author:    kp.Id.Ref(),
+		Sequence:  margaret.BaseSeq(1),
+		Timestamp: time.Now().UnixNano() / 1000000,
+		Content: map[string]interface{}{
+			"type": "selftest",
+			"text": "unicode: ✓ ☃ 日本語, escapes: \"\\\t, numbers:",
+			"n":    []interface{}{0, -1, 1.5, 1e21},
+			"nested": map[string]interface{}{
+				"z": true,
+				"a": nil,
+			},
+		},
+	}
+
+	wantKey, signed, err := msg.Sign(kp.Pair.Secret[:], hmacKey)
+	if !report.add("sign", err) {
+		return report
+	}
+
+	first, err := legacy.EncodePreserveOrder(signed)
+	if report.add("encode", err) {
+		second, err := legacy.EncodePreserveOrder(signed)
+		if err == nil && !bytes.Equal(first, second) {
+			err = errors.Errorf("encoding twice gave different bytes (%d vs %d)", len(first), len(second))
+		}
+		report.add("encode is stable", err)
+
+		again, err := legacy.EncodePreserveOrder(first)
+		if err == nil && !bytes.Equal(first, again) {
+			err = errors.Errorf("encoding the encoded message changed it")
+		}
+		report.add("encode is idempotent", err)
+	}
+
+	gotKey, dmsg, err := legacy.Verify(signed, hmacKey)
+	if report.add("verify signature", err) {
+		err = nil
+		if !dmsg.Author.Equal(kp.Id) {
+			err = errors.Errorf("author is %s, not the loaded key", dmsg.Author.Ref())
+		}
+		report.add("author", err)
+
+		err = nil
+		if !gotKey.Equal(*wantKey) {
+			err = errors.Errorf("recomputed %s but signing produced %s", gotKey.Ref(), wantKey.Ref())
+		}
+		report.add("message key", err)
+	}
+
+	tampered := bytes.Replace(signed, []byte(`"selftest"`), []byte(`"selfTest"`), 1)
+	_, _, err = legacy.Verify(tampered, hmacKey)
+	if err == nil {
+		err = fmt.Errorf("changed content still verified")
+	} else {
+		err = nil
+	}
+	report.add("tampered message is rejected", err)
+
+	return report
+}
@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/muxrpc"
+	cli "gopkg.in/urfave/cli.v2"
+
+	"go.cryptoscope.co/ssb/internal/muxmux"
+	"go.cryptoscope.co/ssb/internal/netwraputil"
+)
+
+var serveLocalCmd = &cli.Command{
+	Name:  "serve-local",
+	Usage: "keep one connection to the sbot open and proxy calls from a local unix socket through it",
+	UsageText: `Scripts that call sbotcli many times can use this to do the handshake only once:
+
+	sbotcli --unixsock "" --addr example.org:8008 serve-local --listen /tmp/sbot.sock &
+	sbotcli --unixsock /tmp/sbot.sock publish post "hello"
+
+The upstream connection is opened like for every other command, so --unixsock needs to be empty to use --addr.
+Every call on the local socket is made on the upstream connection with the identity of serve-local.
+Binary streams (like blobs.get) are not supported, their packets are passed as JSON.`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "listen", Usage: "path of the unix socket to listen on (default: ~/.ssb-go/sbotcli.sock)"},
+	},
+	Action: func(ctx *cli.Context) error {
+		sockPath, err := localSocketPath(ctx.String("listen"), "sbotcli.sock")
+		if err != nil {
+			return errors.Wrap(err, "serve-local")
+		}
+
+		upstream, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+		defer upstream.Close()
+
+		return errors.Wrap(serveUnix(sockPath, proxyHandler{upstream: upstream}), "serve-local")
+	},
+}
+
+// localSocketPath returns path, or name in ~/.ssb-go if it is empty
+func localSocketPath(path, name string) (string, error) {
+	if path != "" {
+		return path, nil
+	}
+	u, err := user.Current()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to find home directory")
+	}
+	return filepath.Join(u.HomeDir, ".ssb-go", name), nil
+}
+
+// serveUnix serves h to every connection on the unix socket sockPath until longctx is done
+func serveUnix(sockPath string, h muxrpc.Handler) error {
+	if c, err := net.Dial("unix", sockPath); err == nil {
+		c.Close()
+		return errors.Errorf("%s is already in use", sockPath)
+	}
+	os.Remove(sockPath)
+
+	// the calls are made with our identity, other users of the machine shouldn't be able to
+	lis, err := netwraputil.ListenUnixPrivate(sockPath)
+	if err != nil {
+		return err
+	}
+	defer lis.Close()
+
+	go func() {
+		<-longctx.Done()
+		lis.Close()
+	}()
+	level.Info(log).Log("event", "serving", "socket", sockPath)
+
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			if longctx.Err() != nil {
+				return nil
+			}
+			return errors.Wrap(err, "accept failed")
+		}
+
+		go func(conn net.Conn) {
+			defer conn.Close()
+			edp := muxrpc.HandleWithLogger(muxrpc.NewPacker(conn), h, log)
+
+			connCtx, cancel := context.WithCancel(longctx)
+			defer cancel()
+			srv := edp.(muxrpc.Server)
+			if err := srv.Serve(connCtx); err != nil {
+				level.Debug(log).Log("event", "local conn closed", "err", err)
+			}
+			edp.Terminate()
+		}(conn)
+	}
+}
+
+// proxyHandler makes every call it gets on the upstream endpoint and passes the results back
+type proxyHandler struct {
+	upstream muxrpc.Endpoint
+}
+
+func (proxyHandler) HandleConnect(context.Context, muxrpc.Endpoint) {}
+
+func (ph proxyHandler) HandleCall(ctx context.Context, req *muxrpc.Request, _ muxrpc.Endpoint) {
+	typ := req.Type
+	if typ == "" {
+		typ = "async"
+	}
+	level.Debug(log).Log("event", "proxy call", "method", req.Method.String(), "type", typ)
+	muxmux.Forward(ctx, req, typ, ph.upstream)
+}
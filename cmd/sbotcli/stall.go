@@ -0,0 +1,87 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/muxrpc"
+	ssbClient "go.cryptoscope.co/ssb/client"
+	cli "gopkg.in/urfave/cli.v2"
+)
+
+var stallTimeoutFlag = cli.DurationFlag{Name: "stall-timeout", Usage: "with --live: error out if no message arrives for this long and the server doesn't answer a ping either"}
+
+// pumpStream is luigi.Pump with a watchdog for live streams.
+// If --stall-timeout is set and nothing arrives within it, the server is pinged.
+// An answer means we are just caught up and keep waiting, no answer means the stream is stalled.
+// Streams that aren't live end with an eofMarker if --emit-eof-marker is set.
+func pumpStream(ctx *cli.Context, client *ssbClient.Client, snk luigi.Sink, src luigi.Source) error {
+	if ctx.Bool("emit-eof-marker") && !ctx.Bool("live") {
+		return pumpWithEOFMarker(ctx, snk, src)
+	}
+
+	window := ctx.Duration("stall-timeout")
+	if window <= 0 || !ctx.Bool("live") {
+		return luigi.Pump(longctx, snk, src)
+	}
+
+	pumpCtx, cancel := context.WithCancel(longctx)
+	defer cancel()
+
+	activity := make(chan struct{}, 1)
+	watched := luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error {
+		if err != nil {
+			if luigi.IsEOS(err) {
+				return snk.Close()
+			}
+			return err
+		}
+		select {
+		case activity <- struct{}{}:
+		default:
+		}
+		return snk.Pour(ctx, v)
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- luigi.Pump(pumpCtx, watched, src)
+	}()
+
+	timer := time.NewTimer(window)
+	defer timer.Stop()
+	for {
+		select {
+		case err := <-done:
+			return err
+
+		case <-activity:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(window)
+
+		case <-timer.C:
+			if err := ping(client, window); err != nil {
+				cancel()
+				<-done
+				return errors.Errorf("no messages for %s (stalled?): keepalive ping failed: %s", window, err)
+			}
+			level.Debug(log).Log("event", "stream idle", "msg", "no new messages but server answers pings")
+			timer.Reset(window)
+		}
+	}
+}
+
+// ping uses whoami as a keepalive, it's cheap and every server has it
+func ping(client *ssbClient.Client, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(longctx, timeout)
+	defer cancel()
+	_, err := client.Async(ctx, mapMsg{}, muxrpc.Method{"whoami"})
+	return err
+}
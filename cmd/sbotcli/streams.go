@@ -4,14 +4,13 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
-	"io"
-	"os"
+	"time"
 
 	"github.com/pkg/errors"
-	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/luigi/mfr"
 	"go.cryptoscope.co/muxrpc"
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/message"
 	cli "gopkg.in/urfave/cli.v2"
 )
 
@@ -22,6 +21,9 @@ var streamFlags = []cli.Flag{
 	&cli.BoolFlag{Name: "live"},
 	&cli.BoolFlag{Name: "keys", Value: false},
 	&cli.BoolFlag{Name: "values", Value: false},
+	&stallTimeoutFlag,
+	&dedupContentFlag,
+	&eofMarkerFlag,
 }
 
 type mapMsg map[string]interface{}
@@ -40,16 +42,29 @@ var typeStreamCmd = &cli.Command{
 		if err != nil {
 			return errors.Wrap(err, "source stream call failed")
 		}
-		err = luigi.Pump(longctx, jsonDrain(os.Stdout), src)
+		snk, err := outputDrain(ctx)
+		if err != nil {
+			return err
+		}
+		err = pumpStream(ctx, client, snk, src)
 		return errors.Wrap(err, "byType failed")
 	},
 }
 
 var historyStreamCmd = &cli.Command{
 	Name:  "hist",
-	Flags: append(streamFlags, &cli.StringFlag{Name: "id"}, &cli.BoolFlag{Name: "asJSON"}),
+	Flags: append(streamFlags, &cli.StringFlag{Name: "id"}, &cli.BoolFlag{Name: "asJSON"}, &afterFlag, &untilKeyFlag),
 	Action: func(ctx *cli.Context) error {
-		if ctx.String("id") == "" {
+		after := ctx.String("after")
+		until := ctx.String("until-key")
+		if until != "" {
+			ref, err := ssb.ParseMessageRef(until)
+			if err != nil {
+				return errors.Wrapf(err, "hist: invalid --until-key %q", until)
+			}
+			until = ref.Ref()
+		}
+		if ctx.String("id") == "" && after == "" {
 			return errors.Errorf("--id flag is unset but required")
 		}
 
@@ -59,53 +74,192 @@ var historyStreamCmd = &cli.Command{
 		}
 
 		var args = getStreamArgs(ctx)
-		src, err := client.Source(longctx, mapMsg{}, muxrpc.Method{"createHistoryStream"}, args)
+		if after != "" {
+			author, seq, err := resolveAfter(client, after)
+			if err != nil {
+				return err
+			}
+			if args.ID != nil && !args.ID.Equal(author) {
+				return errors.Errorf("hist: %s was published by %s and not by --id", after, author.Ref())
+			}
+			args.ID = author
+			args.Seq = seq + 1
+		}
+		if until != "" {
+			args.Keys = true
+		}
+
+		callCtx, cancel := context.WithCancel(longctx)
+		defer cancel()
+		src, err := client.Source(callCtx, mapMsg{}, muxrpc.Method{"createHistoryStream"}, args)
 		if err != nil {
 			return errors.Wrap(err, "source stream call failed")
 		}
-		err = luigi.Pump(longctx, jsonDrain(os.Stdout), src)
+		if until != "" {
+			// the server doesn't know about it, the rest of the feed is dropped here
+			src = &keyStopper{src: src, cancel: cancel, key: until, keepKeys: ctx.Bool("keys")}
+		}
+		snk, err := outputDrain(ctx)
+		if err != nil {
+			return err
+		}
+		err = pumpStream(ctx, client, snk, src)
 		return errors.Wrap(err, "feed hist failed")
 	},
 }
 
+var userStreamCmd = &cli.Command{
+	Name:      "user",
+	UsageText: "aka createUserStream, like hist but with --gt/--lt and decrypted private messages if the server does that",
+	Flags: append(streamFlags,
+		&cli.StringFlag{Name: "id", Usage: "the feed to stream"},
+		&cli.Int64Flag{Name: "gt", Usage: "only messages with a sequence greater than this"},
+		&cli.Int64Flag{Name: "lt", Usage: "only messages with a sequence less than this"},
+		&cli.BoolFlag{Name: "private", Usage: "ask the server to include decrypted private messages"},
+	),
+	Action: func(ctx *cli.Context) error {
+		ref, err := ssb.ParseFeedRef(ctx.String("id"))
+		if err != nil {
+			return errors.Wrap(err, "user: --id needs to be a feed")
+		}
+
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		var args message.CreateUserStreamArgs
+		args.Limit = ctx.Int64("limit")
+		args.Reverse = ctx.Bool("reverse")
+		args.Live = ctx.Bool("live")
+		args.Keys = ctx.Bool("keys")
+		args.Values = ctx.Bool("values")
+		args.Gt = ctx.Int64("gt")
+		args.Lt = ctx.Int64("lt")
+		args.Private = ctx.Bool("private")
+		args.MarshalType = mapMsg{}
+
+		src, err := client.CreateUserStream(ref, args)
+		if err != nil {
+			return errors.Wrap(err, "source stream call failed")
+		}
+		snk, err := outputDrain(ctx)
+		if err != nil {
+			return err
+		}
+		err = pumpStream(ctx, client, snk, src)
+		return errors.Wrap(err, "user stream failed")
+	},
+}
+
 var logStreamCmd = &cli.Command{
-	Name:  "log",
-	Flags: streamFlags,
+	Name: "log",
+	Flags: append(streamFlags, &afterFlag,
+		&cli.BoolFlag{Name: "with-rxseq", Usage: "add the receive log sequence of each message as rxseq (implies --keys, needs a server that supports it)"},
+	),
 	Action: func(ctx *cli.Context) error {
 		client, err := newClient(ctx)
 		if err != nil {
 			return err
 		}
 
-		var args = getStreamArgs(ctx)
+		var hist = getStreamArgs(ctx)
+		var args message.CreateLogArgs
+		args.CommonArgs = hist.CommonArgs
+		args.StreamArgs = hist.StreamArgs
+		args.Seq = hist.Seq
+		if ctx.Bool("with-rxseq") {
+			args.RXSeq = true
+			args.Keys = true
+		}
+
+		after := ctx.String("after")
+		if after != "" {
+			// make sure it exists before we go through the whole log
+			if _, _, err := resolveAfter(client, after); err != nil {
+				return err
+			}
+			args.Keys = true
+		}
+
 		src, err := client.Source(longctx, mapMsg{}, muxrpc.Method{"createLogStream"}, args)
 		if err != nil {
 			return errors.Wrap(err, "source stream call failed")
 		}
-		err = luigi.Pump(longctx, jsonDrain(os.Stdout), src)
-		return errors.Wrap(err, "log failed")
+		snk, err := outputDrain(ctx)
+		if err != nil {
+			return err
+		}
+
+		if after == "" {
+			err = pumpStream(ctx, client, snk, src)
+			return errors.Wrap(err, "log failed")
+		}
+
+		skipper := &keySkipper{snk: snk, key: after, keepKeys: ctx.Bool("keys") || args.RXSeq}
+		err = pumpStream(ctx, client, skipper, src)
+		if err != nil {
+			return errors.Wrap(err, "log failed")
+		}
+		if !skipper.found {
+			return errors.Errorf("log: %s is not in the stream (check --seq and --reverse)", after)
+		}
+		return nil
 	},
 }
 
 var privateReadCmd = &cli.Command{
 	Name:  "read",
-	Flags: streamFlags,
+	Usage: "stream the decrypted private messages of the server",
+	Flags: append(streamFlags,
+		&cli.StringFlag{Name: "from", Usage: "only the messages authored by this @feed, the others aren't decrypted"},
+		&cli.BoolFlag{Name: "inbox", Usage: "print only {key, author, sequence, content} of each message"},
+		&cli.BoolFlag{Name: "show-recipients", Usage: "print the messages like --inbox, with who they were for"},
+	),
 	Action: func(ctx *cli.Context) error {
 		client, err := newClient(ctx)
 		if err != nil {
 			return err
 		}
 
-		var args = getStreamArgs(ctx)
+		var args struct {
+			message.CreateHistArgs
+			Recipients bool `json:"recipients,omitempty"`
+		}
+		args.CreateHistArgs = getStreamArgs(ctx)
+		if from := ctx.String("from"); from != "" {
+			args.ID, err = ssb.ParseFeedRef(from)
+			if err != nil {
+				return errors.Wrap(err, "private/read: --from is not a feed")
+			}
+		}
+		showRecps := ctx.Bool("show-recipients")
+		args.Recipients = showRecps || ctx.Bool("inbox")
 		src, err := client.Source(longctx, mapMsg{}, muxrpc.Method{"private", "read"}, args)
 		if err != nil {
 			return errors.Wrap(err, "source stream call failed")
 		}
-		err = luigi.Pump(longctx, jsonDrain(os.Stdout), src)
+		if args.Recipients && !showRecps {
+			src = mfr.SourceMap(src, redactRecipients)
+		}
+		snk, err := outputDrain(ctx)
+		if err != nil {
+			return err
+		}
+		err = pumpStream(ctx, client, snk, src)
 		return errors.Wrap(err, "private/read failed")
 	},
 }
 
+// redactRecipients drops the recipients of a private.Decrypted message, for the minimal --inbox output
+func redactRecipients(_ context.Context, v interface{}) (interface{}, error) {
+	if msg, ok := asMapMsg(v); ok {
+		delete(msg, "recipients")
+		return msg, nil
+	}
+	return v, nil
+}
+
 var replicateUptoCmd = &cli.Command{
 	Name:  "upto",
 	Flags: streamFlags,
@@ -120,30 +274,43 @@ var replicateUptoCmd = &cli.Command{
 		if err != nil {
 			return errors.Wrap(err, "source stream call failed")
 		}
-		err = luigi.Pump(longctx, jsonDrain(os.Stdout), src)
+		snk, err := outputDrain(ctx)
+		if err != nil {
+			return err
+		}
+		err = pumpStream(ctx, client, snk, src)
 		return errors.Wrap(err, "replicate/upto failed")
 	},
 }
 
-func jsonDrain(w io.Writer) luigi.Sink {
-	i := 0
-	return luigi.FuncSink(func(ctx context.Context, val interface{}, err error) error {
-		if luigi.IsEOS(err) {
-			return nil
-		} else if err != nil {
-			return errors.Wrapf(err, "jsonDrain: failed to drain message %d", i)
+var replicateFetchCmd = &cli.Command{
+	Name:      "fetch",
+	Usage:     "fetch one feed from the connected peers right away",
+	ArgsUsage: "@feed",
+	Flags: []cli.Flag{
+		&cli.DurationFlag{Name: "timeout", Value: 30 * time.Second, Usage: "how long to try at most"},
+		&cli.BoolFlag{Name: "dial", Usage: "also dial the pubs the bot knows"},
+	},
+	Action: func(ctx *cli.Context) error {
+		feed, err := ssb.ParseFeedRef(ctx.Args().First())
+		if err != nil {
+			return errors.Wrap(err, "fetch: expected a feed ref")
 		}
-		b, err := json.MarshalIndent(val, "", "  ")
+		client, err := newClient(ctx)
 		if err != nil {
-			return errors.Wrapf(err, "jsonDrain: failed to encode msg %d", i)
+			return err
 		}
-		_, err = fmt.Fprintln(w, string(b))
+
+		opts := map[string]interface{}{
+			"timeout": ctx.Duration("timeout").Milliseconds(),
+			"dial":    ctx.Bool("dial"),
+		}
+		v, err := client.Async(longctx, ssb.FetchResult{}, muxrpc.Method{"replicate", "fetch"}, feed.Ref(), opts)
 		if err != nil {
-			return errors.Wrapf(err, "jsonDrain: failed to write msg %d", i)
+			return errors.Wrap(err, "replicate/fetch failed")
 		}
-		i++
-		return nil
-	})
+		return render(ctx, v)
+	},
 }
 
 /*
@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/ssb"
+	cli "gopkg.in/urfave/cli.v2"
+)
+
+var threadCmd = &cli.Command{
+	Name:      "thread",
+	Usage:     "print the root message and all replies of a thread",
+	UsageText: "uses partialReplication.getTangle if the bot has it and scans the whole log for the replies otherwise",
+	Action: func(ctx *cli.Context) error {
+		ref := ctx.Args().Get(0)
+		if ref == "" {
+			return errors.New("thread: need the message ref of the root")
+		}
+		root, err := ssb.ParseMessageRef(ref)
+		if err != nil {
+			return errors.Wrap(err, "thread: failed to parse root ref")
+		}
+
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		msgs, err := client.GetTangle(root)
+		if err != nil {
+			return errors.Wrap(err, "thread: failed to get the thread")
+		}
+		if len(msgs) == 0 {
+			return errors.Errorf("thread: %s not found", root.Ref())
+		}
+
+		out, err := newRenderer(ctx, os.Stdout)
+		if err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			if err := out.Render(msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+	cli "gopkg.in/urfave/cli.v2"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/message/legacy"
+)
+
+var verifyMessageCmd = &cli.Command{
+	Name:      "verify-message",
+	Usage:     "check the signature and key of a message, without a server",
+	UsageText: "verify-message [--author @feed] < message.json. the message is {key,value} like get and the streams print it, or only the value",
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "author", Usage: "the @feed.ed25519 the message has to be from"},
+		&cli.StringFlag{Name: "hmac", Usage: "base64 encoded hmac key, if the network signs with one"},
+	},
+	Action: func(ctx *cli.Context) error {
+		var want *ssb.FeedRef
+		if a := ctx.String("author"); a != "" {
+			var err error
+			want, err = ssb.ParseFeedRef(a)
+			if err != nil {
+				return errors.Wrap(err, "verify-message: failed to parse --author")
+			}
+		}
+		hmacKey, err := parseHMACKey(ctx.String("hmac"))
+		if err != nil {
+			return errors.Wrap(err, "verify-message")
+		}
+
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return errors.Wrap(err, "verify-message: failed to read stdin")
+		}
+		report, err := verifyMessage(data, want, hmacKey)
+		if err != nil {
+			return errors.Wrap(err, "verify-message")
+		}
+		return render(ctx, report)
+	},
+}
+
+type verifyMessageReport struct {
+	Key      string `json:"key"`
+	Author   string `json:"author"`
+	Sequence int64  `json:"sequence"`
+	OK       bool   `json:"ok"`
+}
+
+// verifyMessage checks the signature of a message against its author and, if it came with one, that it hashes to its key
+func verifyMessage(data []byte, want *ssb.FeedRef, hmacKey *[32]byte) (verifyMessageReport, error) {
+	var report verifyMessageReport
+
+	var kv struct {
+		Key   string          `json:"key"`
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(data, &kv); err != nil {
+		return report, errors.Wrap(err, "not a json object")
+	}
+	raw := kv.Value
+	if len(raw) == 0 {
+		// only the value
+		raw = data
+	}
+
+	key, dmsg, err := legacy.Verify(raw, hmacKey)
+	if err != nil {
+		return report, err
+	}
+	report.Key = key.Ref()
+	report.Author = dmsg.Author.Ref()
+	report.Sequence = dmsg.Sequence.Seq()
+
+	if kv.Key != "" {
+		claimed, err := ssb.ParseMessageRef(kv.Key)
+		if err != nil {
+			return report, errors.Wrap(err, "invalid key")
+		}
+		if !claimed.Equal(*key) {
+			return report, errors.Errorf("the message hashes to %s, not its key %s", key.Ref(), claimed.Ref())
+		}
+	}
+	if want != nil && !dmsg.Author.Equal(want) {
+		return report, errors.Errorf("the message is signed by %s, not %s", dmsg.Author.Ref(), want.Ref())
+	}
+	report.OK = true
+	return report, nil
+}
@@ -0,0 +1,73 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ed25519"
+	cli "gopkg.in/urfave/cli.v2"
+
+	"go.cryptoscope.co/ssb"
+)
+
+var verifySecretCmd = &cli.Command{
+	Name:  "verify-secret",
+	Usage: "check that a secret file belongs to the expected feed before publishing with it",
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "key", Usage: "the secret file to check (defaults to the global --key)"},
+		&cli.StringFlag{Name: "expect", Usage: "the @feed.ed25519 the secret should be for"},
+	},
+	Action: func(ctx *cli.Context) error {
+		if ctx.String("expect") == "" {
+			return errors.New("verify-secret: need the expected feed with --expect")
+		}
+		want, err := ssb.ParseFeedRef(ctx.String("expect"))
+		if err != nil {
+			return errors.Wrap(err, "verify-secret: failed to parse --expect")
+		}
+
+		keyFile := ctx.String("key")
+		for _, parent := range ctx.Lineage() {
+			if keyFile != "" {
+				break
+			}
+			keyFile = parent.String("key")
+		}
+		kp, err := ssb.LoadKeyPair(keyFile)
+		if err != nil {
+			return errors.Wrap(err, "verify-secret: failed to load key pair")
+		}
+
+		// don't trust the id and public fields of the file, a corrupt or hand edited one can have a private key of some other feed.
+		// The second half of the secret is the public key again, only the seed in the first half is the key itself.
+		secret := kp.Pair.Secret[:]
+		if len(secret) != ed25519.PrivateKeySize {
+			return errors.Errorf("verify-secret: %s is corrupt: its private key has %d bytes", keyFile, len(secret))
+		}
+		fromSeed := ed25519.NewKeyFromSeed(secret[:ed25519.SeedSize])
+		if !bytes.Equal(fromSeed, secret) {
+			return errors.Errorf("verify-secret: %s is corrupt: the two halves of its private key don't belong together", keyFile)
+		}
+		derived := &ssb.FeedRef{
+			ID:   fromSeed.Public().(ed25519.PublicKey),
+			Algo: kp.Id.Algo,
+		}
+		if !bytes.Equal(kp.Pair.Public[:], derived.ID) {
+			return errors.Errorf("verify-secret: %s is corrupt: its private key is for %s but the public key is %s", keyFile, derived.Ref(), (&ssb.FeedRef{ID: kp.Pair.Public[:], Algo: kp.Id.Algo}).Ref())
+		}
+		if !kp.Id.Equal(derived) {
+			return errors.Errorf("verify-secret: %s is corrupt: its private key is for %s but the id says %s", keyFile, derived.Ref(), kp.Id.Ref())
+		}
+		if !derived.Equal(want) {
+			return errors.Errorf("verify-secret: mismatch: %s is the secret of %s, not %s", keyFile, derived.Ref(), want.Ref())
+		}
+
+		return render(ctx, map[string]interface{}{
+			"ok":   true,
+			"file": keyFile,
+			"feed": derived.Ref(),
+		})
+	},
+}
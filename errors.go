@@ -71,3 +71,26 @@ func (e ErrWrongSequence) Error() string {
 		e.Stored.Seq(),
 		e.Logical.Seq())
 }
+
+// ErrForkDetected is returned when a peer has a different message at a sequence of a feed than the one we have.
+// Replication of that feed stops there, nothing of the other version is stored.
+type ErrForkDetected struct {
+	Feed *FeedRef
+	Seq  int64
+
+	LocalKey, RemoteKey *MessageRef
+}
+
+func (e ErrForkDetected) Error() string {
+	return fmt.Sprintf("ssb/consistency error: feed %s is forked at sequence %d (local:%s remote:%s)",
+		e.Feed.Ref(),
+		e.Seq,
+		e.LocalKey.Ref(),
+		e.RemoteKey.Ref())
+}
+
+// IsForkDetected returns the ErrForkDetected err is caused by, if it is
+func IsForkDetected(err error) (ErrForkDetected, bool) {
+	fork, is := errors.Cause(err).(ErrForkDetected)
+	return fork, is
+}
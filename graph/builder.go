@@ -10,15 +10,18 @@ import (
 
 	"github.com/dgraph-io/badger"
 	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/librarian"
 	libbadger "go.cryptoscope.co/librarian/badger"
+	"go.cryptoscope.co/luigi"
 	"go.cryptoscope.co/margaret"
 	"gonum.org/v1/gonum/graph"
 	"gonum.org/v1/gonum/graph/path"
 	"gonum.org/v1/gonum/graph/simple"
 
 	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/repo"
 )
 
 // Builder can build a trust graph and answer other questions
@@ -27,6 +30,12 @@ type Builder interface {
 	// Build a complete graph of all follow/block relations
 	Build() (*Graph, error)
 
+	// Snapshot returns an immutable copy of the current graph
+	Snapshot() (*Snapshot, error)
+
+	// Changes emits a ContactChange for each contact message that was added to the graph
+	Changes() luigi.Broadcast
+
 	// Follows returns a set of all people ref follows
 	Follows(*ssb.FeedRef) (*ssb.StrFeedSet, error)
 
@@ -53,6 +62,9 @@ type builder struct {
 
 	cacheLock   sync.Mutex
 	cachedGraph *Graph
+
+	changesSink luigi.Sink
+	changes     luigi.Broadcast
 }
 
 // NewBuilder creates a Builder that is backed by a badger database
@@ -62,61 +74,117 @@ func NewBuilder(log kitlog.Logger, db *badger.DB) *builder {
 		idx: libbadger.NewIndex(db, 0),
 		log: log,
 	}
+	b.changesSink, b.changes = luigi.NewBroadcast()
 	return b
 }
 
-func (b *builder) indexUpdateFunc(ctx context.Context, seq margaret.Seq, val interface{}, idx librarian.SetterIndex) error {
+func (b *builder) Changes() luigi.Broadcast { return b.changes }
+
+func (b *builder) Snapshot() (*Snapshot, error) {
+	g, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+	return g.Snapshot(), nil
+}
+
+// keyIndexedSeq is where the batch sink stores up to which message the graph is updated.
+// It's not 66 bytes long like the contact entries.
+var keyIndexedSeq = []byte("contacts-seq")
+
+// updateTxn stores the contact state of a message in the transaction of a batch, see notifyBatch
+func (b *builder) updateTxn(txn *badger.Txn, seq margaret.Seq, val interface{}) error {
+	addr, chg, err := contactOf(b.log, val)
+	if err != nil || chg == nil {
+		return err
+	}
+	// the JSON numbers librarian's index wrote before, see Build
+	stored := []byte("0")
+	switch chg.State {
+	case ContactStateFollowing:
+		stored = []byte("1")
+	case ContactStateBlocking:
+		stored = []byte("2")
+	}
+	err = txn.Set([]byte(addr), stored)
+	return errors.Wrapf(err, "db/idx contacts: failed to update index. %+v", chg)
+}
+
+// notifyBatch drops the cached graph and emits the changes once a batch was committed,
+// outside of the cache lock so that listeners can call Build()
+func (b *builder) notifyBatch(batch []margaret.SeqWrapper) {
+	var chgs []ContactChange
+	for _, sw := range batch {
+		if _, chg, err := contactOf(b.log, sw.Value()); err == nil && chg != nil {
+			chgs = append(chgs, *chg)
+		}
+	}
+	if len(chgs) == 0 {
+		return
+	}
+
 	b.cacheLock.Lock()
-	defer b.cacheLock.Unlock()
+	b.cachedGraph = nil
+	b.cacheLock.Unlock()
+
+	for _, chg := range chgs {
+		if err := b.changesSink.Pour(context.TODO(), chg); err != nil {
+			level.Warn(b.log).Log("msg", "failed to send contact change", "err", err)
+		}
+	}
+}
 
+// contactOf returns the index address and the change of a contact message, no change for other messages
+func contactOf(log kitlog.Logger, val interface{}) (librarian.Addr, *ContactChange, error) {
 	if nulled, ok := val.(error); ok {
 		if margaret.IsErrNulled(nulled) {
-			return nil
+			return "", nil, nil
 		}
-		return nulled
+		return "", nil, nulled
 	}
 
 	abs, ok := val.(ssb.Message)
 	if !ok {
 		err := errors.Errorf("graph/idx: invalid msg value %T", val)
-		b.log.Log("msg", "contact eval failed", "reason", err)
-		return err
+		log.Log("msg", "contact eval failed", "reason", err)
+		return "", nil, err
 	}
 
 	var c ssb.Contact
 	err := c.UnmarshalJSON(abs.ContentBytes())
 	if err != nil {
 		// just ignore invalid messages, nothing to do with them (unless you are debugging something)
-		//level.Warn(b.log).Log("msg", "skipped contact message", "reason", err)
-		return nil
+		//level.Warn(log).Log("msg", "skipped contact message", "reason", err)
+		return "", nil, nil
+	}
+
+	chg := ContactChange{
+		Author:  abs.Author(),
+		Contact: c.Contact,
 	}
 
 	addr := abs.Author().StoredAddr()
 	addr += c.Contact.StoredAddr()
 	switch {
 	case c.Following:
-		err = idx.Set(ctx, addr, 1)
+		chg.State = ContactStateFollowing
 	case c.Blocking:
-		err = idx.Set(ctx, addr, 2)
+		chg.State = ContactStateBlocking
 	default:
-		err = idx.Set(ctx, addr, 0)
-		// cryptix: not sure why this doesn't work
+		chg.State = ContactStateNone
+		// cryptix: not sure why deleting doesn't work
 		// it also removes the node if this is the only follow from that peer
 		// 3 state handling seems saner
-		// err = idx.Delete(ctx, librarian.Addr(addr))
-	}
-	if err != nil {
-		return errors.Wrapf(err, "db/idx contacts: failed to update index. %+v", c)
 	}
 
-	b.cachedGraph = nil
 	// TODO: patch existing graph instead of invalidating
-	return nil
+	return addr, &chg, nil
 }
 
+// OpenIndex returns the index and a repo.BatchSinkIndex that updates it
 func (b *builder) OpenIndex() (librarian.SeqSetterIndex, librarian.SinkIndex) {
 	if b.idxSink == nil {
-		b.idxSink = librarian.NewSinkIndex(b.indexUpdateFunc, b.idx)
+		b.idxSink = repo.NewBadgerBatchSink(b.kv, keyIndexedSeq, b.idx, b.updateTxn, repo.AfterBatch(b.notifyBatch))
 	}
 	return b.idx, b.idxSink
 }
@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: MIT
+
+package graph
+
+import (
+	"sort"
+
+	"go.cryptoscope.co/librarian"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// Edge is the latest contact state of one feed towards another one
+type Edge struct {
+	From  *ssb.FeedRef `json:"from"`
+	To    *ssb.FeedRef `json:"to"`
+	State ContactState `json:"-"`
+
+	// Following and Blocking are State for the JSON of the edge, like the contact messages have them
+	Following bool `json:"following"`
+	Blocking  bool `json:"blocking"`
+}
+
+// Export folds contact messages into one edge per pair of feeds, for an export of the whole graph.
+// Unlike the Builder it works on any stream of messages and can keep the follows of feeds on themselves.
+type Export struct {
+	includeSelf bool
+
+	edges map[[2]librarian.Addr]*exportEdge
+}
+
+type exportEdge struct {
+	Edge
+	seq int64 // of the contact message the state is from
+}
+
+// NewExport returns an empty export. Feeds that follow or block themselves are dropped unless includeSelf is true.
+func NewExport(includeSelf bool) *Export {
+	return &Export{
+		includeSelf: includeSelf,
+		edges:       make(map[[2]librarian.Addr]*exportEdge),
+	}
+}
+
+// Add takes msg into account if it is a contact message, others are ignored.
+// The state of an edge is the one of the latest message of its author about the contact, in the order of the feed.
+func (e *Export) Add(msg ssb.Message) {
+	var c ssb.Contact
+	if err := c.UnmarshalJSON(msg.ContentBytes()); err != nil {
+		return
+	}
+	author := msg.Author()
+	if !e.includeSelf && author.Equal(c.Contact) {
+		return
+	}
+
+	state := ContactStateNone
+	if c.Following {
+		state = ContactStateFollowing
+	} else if c.Blocking {
+		state = ContactStateBlocking
+	}
+
+	k := [2]librarian.Addr{author.StoredAddr(), c.Contact.StoredAddr()}
+	edg, has := e.edges[k]
+	if !has {
+		edg = &exportEdge{Edge: Edge{From: author.Copy(), To: c.Contact.Copy()}}
+		e.edges[k] = edg
+	} else if msg.Seq() < edg.seq {
+		// an older message that arrived late
+		return
+	}
+	edg.seq = msg.Seq()
+	edg.State = state
+}
+
+// Edges returns the edges that follow or block, sorted by their feeds
+func (e *Export) Edges() []Edge {
+	edges := make([]Edge, 0, len(e.edges))
+	for _, edg := range e.edges {
+		if edg.State == ContactStateNone {
+			continue
+		}
+		out := edg.Edge
+		out.Following = out.State == ContactStateFollowing
+		out.Blocking = out.State == ContactStateBlocking
+		edges = append(edges, out)
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if a, b := edges[i].From.Ref(), edges[j].From.Ref(); a != b {
+			return a < b
+		}
+		return edges[i].To.Ref() < edges[j].To.Ref()
+	})
+	return edges
+}
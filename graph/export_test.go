@@ -0,0 +1,86 @@
+// SPDX-License-Identifier: MIT
+
+package graph
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/margaret"
+
+	"go.cryptoscope.co/ssb"
+)
+
+func TestExportEdges(t *testing.T) {
+	r := require.New(t)
+
+	feed := func(b byte) *ssb.FeedRef {
+		return &ssb.FeedRef{ID: bytes.Repeat([]byte{b}, 32), Algo: ssb.RefAlgoFeedSSB1}
+	}
+	alice, bob, claire := feed(1), feed(2), feed(3)
+
+	seqs := make(map[string]int64)
+	contact := func(author, who *ssb.FeedRef, content map[string]interface{}) ssb.Message {
+		content["type"] = "contact"
+		content["contact"] = who.Ref()
+		c, err := json.Marshal(content)
+		r.NoError(err)
+		seqs[author.Ref()]++
+		var kv ssb.KeyValueRaw
+		kv.Value.Author = *author
+		kv.Value.Sequence = margaret.BaseSeq(seqs[author.Ref()])
+		kv.Value.Content = c
+		return kv
+	}
+
+	msgs := []ssb.Message{
+		// follow, unfollow, follow again
+		contact(alice, bob, map[string]interface{}{"following": true}),
+		contact(alice, bob, map[string]interface{}{"following": false}),
+		contact(alice, bob, map[string]interface{}{"following": true}),
+
+		// twice the same
+		contact(bob, alice, map[string]interface{}{"following": true}),
+		contact(bob, alice, map[string]interface{}{"following": true}),
+
+		contact(alice, alice, map[string]interface{}{"following": true}),
+		contact(bob, claire, map[string]interface{}{"blocking": true}),
+
+		// unfollowed in the end
+		contact(claire, bob, map[string]interface{}{"following": true}),
+		contact(claire, bob, map[string]interface{}{"following": false}),
+	}
+	// an older message of alice that comes late doesn't change the edge
+	late := contact(alice, bob, map[string]interface{}{"following": false})
+	lateKV := late.(ssb.KeyValueRaw)
+	lateKV.Value.Sequence = 2
+	msgs = append(msgs, lateKV)
+
+	exp := NewExport(false)
+	for _, msg := range msgs {
+		exp.Add(msg)
+	}
+	edges := exp.Edges()
+	r.Len(edges, 3)
+	r.Equal(alice.Ref(), edges[0].From.Ref())
+	r.Equal(bob.Ref(), edges[0].To.Ref())
+	r.Equal(ContactStateFollowing, edges[0].State)
+	r.True(edges[0].Following)
+	r.Equal(bob.Ref(), edges[1].From.Ref())
+	r.Equal(alice.Ref(), edges[1].To.Ref())
+	r.Equal(bob.Ref(), edges[2].From.Ref())
+	r.Equal(claire.Ref(), edges[2].To.Ref())
+	r.Equal(ContactStateBlocking, edges[2].State)
+	r.True(edges[2].Blocking)
+
+	withSelf := NewExport(true)
+	for _, msg := range msgs {
+		withSelf.Add(msg)
+	}
+	edges = withSelf.Edges()
+	r.Len(edges, 4)
+	r.Equal(alice.Ref(), edges[0].From.Ref())
+	r.Equal(alice.Ref(), edges[0].To.Ref())
+}
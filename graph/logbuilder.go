@@ -27,6 +27,9 @@ type logBuilder struct {
 	current *Graph
 
 	currentQueryCancel context.CancelFunc
+
+	changesSink luigi.Sink
+	changes     luigi.Broadcast
 }
 
 // NewLogBuilder is a much nicer abstraction than the direct k:v implementation.
@@ -38,6 +41,7 @@ func NewLogBuilder(logger kitlog.Logger, contacts margaret.Log) (*logBuilder, er
 		contactsLog: contacts,
 		current:     NewGraph(),
 	}
+	lb.changesSink, lb.changes = luigi.NewBroadcast()
 
 	_, err := lb.Build()
 
@@ -46,6 +50,16 @@ func NewLogBuilder(logger kitlog.Logger, contacts margaret.Log) (*logBuilder, er
 
 func (b *logBuilder) Close() error { return nil }
 
+func (b *logBuilder) Changes() luigi.Broadcast { return b.changes }
+
+func (b *logBuilder) Snapshot() (*Snapshot, error) {
+	g, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+	return g.Snapshot(), nil
+}
+
 func (b *logBuilder) startQuery(ctx context.Context) {
 	src, err := b.contactsLog.Query(margaret.Live(true))
 	if err != nil {
@@ -103,6 +117,18 @@ func (b *logBuilder) buildGraph(ctx context.Context, v interface{}, err error) e
 		return err
 	}
 
+	chg, err := b.addContact(v)
+	if err != nil || chg == nil {
+		return err
+	}
+
+	if err := b.changesSink.Pour(ctx, *chg); err != nil {
+		level.Warn(b.logger).Log("msg", "failed to send contact change", "err", err)
+	}
+	return nil
+}
+
+func (b *logBuilder) addContact(v interface{}) (*ContactChange, error) {
 	b.current.Lock()
 	defer b.current.Unlock()
 	dg := b.current.WeightedDirectedGraph
@@ -110,14 +136,14 @@ func (b *logBuilder) buildGraph(ctx context.Context, v interface{}, err error) e
 	abs, ok := v.(ssb.Message)
 	if !ok {
 		err := errors.Errorf("graph/idx: invalid msg value %T", v)
-		return err
+		return nil, err
 	}
 
 	var c ssb.Contact
-	err = c.UnmarshalJSON(abs.ContentBytes())
+	err := c.UnmarshalJSON(abs.ContentBytes())
 	if err != nil {
 		// ignore invalid messages
-		return nil
+		return nil, nil
 	}
 
 	author := abs.Author()
@@ -125,7 +151,7 @@ func (b *logBuilder) buildGraph(ctx context.Context, v interface{}, err error) e
 
 	if author.Equal(contact) {
 		// contact self?!
-		return nil
+		return nil, nil
 	}
 
 	bfrom := author.StoredAddr()
@@ -134,11 +160,11 @@ func (b *logBuilder) buildGraph(ctx context.Context, v interface{}, err error) e
 		// stupid copy
 		sr, err := ssb.NewStorageRef(author)
 		if err != nil {
-			return errors.Wrap(err, "failed to create graph node for author")
+			return nil, errors.Wrap(err, "failed to create graph node for author")
 		}
 		fr, err := sr.FeedRef()
 		if err != nil {
-			return errors.Wrap(err, "failed to create graph node for author")
+			return nil, errors.Wrap(err, "failed to create graph node for author")
 		}
 		nFrom = &contactNode{dg.NewNode(), fr, ""}
 		dg.AddNode(nFrom)
@@ -150,27 +176,34 @@ func (b *logBuilder) buildGraph(ctx context.Context, v interface{}, err error) e
 	if !has {
 		sr, err := ssb.NewStorageRef(contact)
 		if err != nil {
-			return errors.Wrap(err, "failed to create graph node for contact")
+			return nil, errors.Wrap(err, "failed to create graph node for contact")
 		}
 		fr, err := sr.FeedRef()
 		if err != nil {
-			return errors.Wrap(err, "failed to create graph node for author")
+			return nil, errors.Wrap(err, "failed to create graph node for author")
 		}
 		nTo = &contactNode{dg.NewNode(), fr, ""}
 		dg.AddNode(nTo)
 		b.current.lookup[bto] = nTo
 	}
 
+	chg := ContactChange{
+		Author:  nFrom.feed,
+		Contact: nTo.feed,
+	}
+
 	w := math.Inf(-1)
 	if c.Following {
 		w = 1
+		chg.State = ContactStateFollowing
 	} else if c.Blocking {
 		w = math.Inf(1)
+		chg.State = ContactStateBlocking
 	} else if !c.Following && !c.Blocking {
 		if dg.HasEdgeFromTo(nFrom.ID(), nTo.ID()) {
 			dg.RemoveEdge(nFrom.ID(), nTo.ID())
 		}
-		return nil
+		return &chg, nil
 	}
 
 	edg := simple.WeightedEdge{F: nFrom, T: nTo, W: w}
@@ -179,7 +212,7 @@ func (b *logBuilder) buildGraph(ctx context.Context, v interface{}, err error) e
 		isBlock:      c.Blocking,
 	})
 
-	return nil
+	return &chg, nil
 }
 
 func (b *logBuilder) Follows(from *ssb.FeedRef) (*ssb.StrFeedSet, error) {
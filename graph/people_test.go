@@ -496,6 +496,7 @@ func TestPeople(t *testing.T) {
 	tcs = append(tcs, blockScenarios...)
 	tcs = append(tcs, hopsScenarios...)
 	tcs = append(tcs, deleteScenarios...)
+	tcs = append(tcs, snapshotScenarios...)
 
 	for _, tc := range tcs {
 		t.Run(tc.name+"/badger", tc.run(makeBadger))
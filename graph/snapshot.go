@@ -0,0 +1,169 @@
+// SPDX-License-Identifier: MIT
+
+package graph
+
+import (
+	"math"
+
+	"go.cryptoscope.co/librarian"
+	"gonum.org/v1/gonum/graph"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// ContactState is the state of the relation between two feeds.
+// The latest contact message from one feed about another one wins.
+type ContactState int8
+
+const (
+	ContactStateBlocking  ContactState = -1
+	ContactStateNone      ContactState = 0
+	ContactStateFollowing ContactState = 1
+)
+
+func (cs ContactState) String() string {
+	switch cs {
+	case ContactStateBlocking:
+		return "blocking"
+	case ContactStateFollowing:
+		return "following"
+	}
+	return "none"
+}
+
+// ContactChange is emitted by the Changes() broadcast of a Builder whenever a contact message changed the graph.
+type ContactChange struct {
+	Author  *ssb.FeedRef
+	Contact *ssb.FeedRef
+	State   ContactState
+}
+
+// Snapshot is an immutable copy of the follow/block graph at one point in time.
+// Unlike *Graph it doesn't change when new contact messages are indexed, so it is safe to keep it around and query it from multiple goroutines.
+type Snapshot struct {
+	feeds map[librarian.Addr]*ssb.FeedRef
+	edges map[librarian.Addr]map[librarian.Addr]ContactState
+}
+
+// Snapshot copies the current state of the graph
+func (g *Graph) Snapshot() *Snapshot {
+	g.Mutex.Lock()
+	defer g.Mutex.Unlock()
+
+	s := &Snapshot{
+		feeds: make(map[librarian.Addr]*ssb.FeedRef, len(g.lookup)),
+		edges: make(map[librarian.Addr]map[librarian.Addr]ContactState, len(g.lookup)),
+	}
+
+	for addr, nFrom := range g.lookup {
+		s.feeds[addr] = nFrom.feed.Copy()
+
+		edgs := g.From(nFrom.ID())
+		if edgs.Len() == 0 {
+			continue
+		}
+		out := make(map[librarian.Addr]ContactState, edgs.Len())
+		for edgs.Next() {
+			nTo := edgs.Node().(*contactNode)
+			edg := g.Edge(nFrom.ID(), nTo.ID()).(graph.WeightedEdge)
+			switch w := edg.Weight(); {
+			case w == 1:
+				out[nTo.feed.StoredAddr()] = ContactStateFollowing
+			case math.IsInf(w, 1):
+				out[nTo.feed.StoredAddr()] = ContactStateBlocking
+			}
+		}
+		s.edges[addr] = out
+	}
+	return s
+}
+
+// State returns how from relates to to
+func (s *Snapshot) State(from, to *ssb.FeedRef) ContactState {
+	out, has := s.edges[from.StoredAddr()]
+	if !has {
+		return ContactStateNone
+	}
+	return out[to.StoredAddr()]
+}
+
+// Follows returns true if from follows to
+func (s *Snapshot) Follows(from, to *ssb.FeedRef) bool {
+	return s.State(from, to) == ContactStateFollowing
+}
+
+// Blocks returns true if from blocks to
+func (s *Snapshot) Blocks(from, to *ssb.FeedRef) bool {
+	return s.State(from, to) == ContactStateBlocking
+}
+
+// Distances does a breadth-first walk from from and returns the hop count for each feed in range.
+// The hop semantics are the same as Builder.Hops: direct follows are at distance 0 and
+// the walk only continues through a feed if it follows back (is a friend).
+// Feeds that are blocked by from are never reached and sever all paths that would go through them.
+func (s *Snapshot) Distances(from *ssb.FeedRef, max int) map[librarian.Addr]int {
+	self := from.StoredAddr()
+	dists := make(map[librarian.Addr]int)
+
+	blockedBySelf := func(a librarian.Addr) bool {
+		return s.edges[self][a] == ContactStateBlocking
+	}
+
+	type step struct {
+		addr librarian.Addr
+		dist int
+	}
+
+	queue := []step{{self, -1}}
+	expanded := map[librarian.Addr]struct{}{self: {}}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+
+		next := cur.dist + 1
+		if next > max {
+			continue
+		}
+
+		for to, state := range s.edges[cur.addr] {
+			if state != ContactStateFollowing || to == self || blockedBySelf(to) {
+				continue
+			}
+			if _, seen := dists[to]; !seen {
+				dists[to] = next
+			}
+
+			// only recurse through friends
+			if s.edges[to][cur.addr] != ContactStateFollowing {
+				continue
+			}
+			if _, done := expanded[to]; done {
+				continue
+			}
+			expanded[to] = struct{}{}
+			queue = append(queue, step{to, next})
+		}
+	}
+	return dists
+}
+
+// Hops returns the set of feeds that are in range of from, see Distances for the semantics of max.
+func (s *Snapshot) Hops(from *ssb.FeedRef, max int) *ssb.StrFeedSet {
+	dists := s.Distances(from, max)
+	fs := ssb.NewFeedSet(len(dists))
+	for addr := range dists {
+		if ref, has := s.feeds[addr]; has {
+			fs.AddRef(ref)
+		}
+	}
+	return fs
+}
+
+// Distance returns the hop count between from and to or -1 if to is not in range.
+func (s *Snapshot) Distance(from, to *ssb.FeedRef, max int) int {
+	d, has := s.Distances(from, max)[to.StoredAddr()]
+	if !has {
+		return -1
+	}
+	return d
+}
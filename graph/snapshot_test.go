@@ -0,0 +1,164 @@
+// SPDX-License-Identifier: MIT
+
+package graph
+
+import (
+	"fmt"
+)
+
+var snapshotScenarios = []PeopleTestCase{
+	{
+		name: "snapshot states",
+		ops: []PeopleOp{
+			PeopleOpNewPeer{"alice"},
+			PeopleOpNewPeer{"bob"},
+			PeopleOpNewPeer{"claire"},
+
+			PeopleOpFollow{"alice", "bob"},
+			PeopleOpBlock{"alice", "claire"},
+
+			// latest message wins
+			PeopleOpFollow{"bob", "claire"},
+			PeopleOpUnfollow{"bob", "claire"},
+		},
+		asserts: []PeopleAssertMaker{
+			PeopleAssertSnapshotState("alice", "bob", ContactStateFollowing),
+			PeopleAssertSnapshotState("alice", "claire", ContactStateBlocking),
+			PeopleAssertSnapshotState("bob", "claire", ContactStateNone),
+			PeopleAssertSnapshotState("bob", "alice", ContactStateNone),
+		},
+	},
+
+	{
+		name: "snapshot hops",
+		ops: []PeopleOp{
+			PeopleOpNewPeer{"alice"},
+			PeopleOpNewPeer{"bob"},
+			PeopleOpNewPeer{"claire"},
+			PeopleOpNewPeer{"dora"},
+			PeopleOpNewPeer{"eve"},
+
+			// friends
+			PeopleOpFollow{"alice", "bob"},
+			PeopleOpFollow{"bob", "alice"},
+
+			// friends
+			PeopleOpFollow{"bob", "claire"},
+			PeopleOpFollow{"claire", "bob"},
+
+			PeopleOpFollow{"claire", "dora"},
+
+			// not a friend, so eve's follows are not in range
+			PeopleOpFollow{"alice", "eve"},
+			PeopleOpFollow{"eve", "dora"},
+		},
+		asserts: []PeopleAssertMaker{
+			PeopleAssertSnapshotHops("alice", 0, "bob", "eve"),
+			PeopleAssertSnapshotHops("alice", 1, "bob", "eve", "claire"),
+			PeopleAssertSnapshotHops("alice", 2, "bob", "eve", "claire", "dora"),
+			PeopleAssertSnapshotDist("alice", "dora", 2, 2),
+			PeopleAssertSnapshotDist("alice", "dora", 1, -1),
+		},
+	},
+
+	{
+		name: "snapshot blocks sever",
+		ops: []PeopleOp{
+			PeopleOpNewPeer{"alice"},
+			PeopleOpNewPeer{"bob"},
+			PeopleOpNewPeer{"claire"},
+			PeopleOpNewPeer{"dora"},
+
+			// friends
+			PeopleOpFollow{"alice", "bob"},
+			PeopleOpFollow{"bob", "alice"},
+
+			// friends
+			PeopleOpFollow{"bob", "claire"},
+			PeopleOpFollow{"claire", "bob"},
+
+			PeopleOpFollow{"claire", "dora"},
+
+			PeopleOpBlock{"alice", "claire"},
+		},
+		asserts: []PeopleAssertMaker{
+			PeopleAssertSnapshotHops("alice", 2, "bob"),
+			PeopleAssertSnapshotDist("alice", "claire", 2, -1),
+			PeopleAssertSnapshotDist("alice", "dora", 2, -1),
+		},
+	},
+}
+
+func PeopleAssertSnapshotState(from, to string, want ContactState) PeopleAssertMaker {
+	return func(state *testState) PeopleAssert {
+		a, b, err := getAliceBob(from, to, state)
+		return func(bld Builder) error {
+			if err != nil {
+				return fmt.Errorf("snapshot state: no such peers: %w", err)
+			}
+			snap, err := bld.Snapshot()
+			if err != nil {
+				return err
+			}
+			if got := snap.State(a.key.Id, b.key.Id); got != want {
+				return fmt.Errorf("snapshot state: %s->%s is %s, wanted %s", from, to, got, want)
+			}
+			return nil
+		}
+	}
+}
+
+func PeopleAssertSnapshotHops(from string, hops int, tos ...string) PeopleAssertMaker {
+	return func(state *testState) PeopleAssert {
+		return func(bld Builder) error {
+			alice, ok := state.peers[from]
+			if !ok {
+				return fmt.Errorf("no such from peer")
+			}
+
+			snap, err := bld.Snapshot()
+			if err != nil {
+				return err
+			}
+
+			hopSet := snap.Hops(alice.key.Id, hops)
+			if n, m := hopSet.Count(), len(tos); n != m {
+				lst, _ := hopSet.List()
+				var got []string
+				for _, r := range lst {
+					got = append(got, state.refToName[r.Ref()])
+				}
+				return fmt.Errorf("snapshot hops(%d): wanted %d feeds but got %d: %v", hops, m, n, got)
+			}
+			for _, nick := range tos {
+				bob, ok := state.peers[nick]
+				if !ok {
+					return fmt.Errorf("wanted peer not in known-peers list: %s", nick)
+				}
+				if !hopSet.Has(bob.key.Id) {
+					return fmt.Errorf("snapshot hops(%d): %s not in range", hops, nick)
+				}
+			}
+			return nil
+		}
+	}
+}
+
+func PeopleAssertSnapshotDist(from, to string, max, want int) PeopleAssertMaker {
+	return func(state *testState) PeopleAssert {
+		a, b, err := getAliceBob(from, to, state)
+		return func(bld Builder) error {
+			if err != nil {
+				return fmt.Errorf("snapshot dist: no such peers: %w", err)
+			}
+			snap, err := bld.Snapshot()
+			if err != nil {
+				return err
+			}
+			if got := snap.Distance(a.key.Id, b.key.Id, max); got != want {
+				return fmt.Errorf("snapshot dist: %s->%s is %d, wanted %d", from, to, got, want)
+			}
+			return nil
+		}
+	}
+}
@@ -3,8 +3,6 @@
 package indexes
 
 import (
-	"context"
-
 	"github.com/dgraph-io/badger"
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/librarian"
@@ -18,24 +16,36 @@ import (
 
 const FolderNameGet = "get"
 
+// keyGetSeq is where the get index stores up to which message it is updated
+var keyGetSeq = []byte("get-seq")
+
 // OpenGet supplies the get(msgRef) -> rootLogSeq idx
 func OpenGet(r repo.Interface) (librarian.Index, librarian.SinkIndex, error) {
+	var readIdx librarian.Index
 	updateFn := func(db *badger.DB) (librarian.SeqSetterIndex, librarian.SinkIndex) {
 		idx := libbadger.NewIndex(db, margaret.BaseSeq(0))
-		sink := librarian.NewSinkIndex(func(ctx context.Context, seq margaret.Seq, val interface{}, idx librarian.SetterIndex) error {
-			msg, ok := val.(ssb.Message)
-			if !ok {
-				return errors.Errorf("index/get: unexpected message type: %T", val)
-			}
-			err := idx.Set(ctx, librarian.Addr(msg.Key().Hash), seq.Seq())
-			return errors.Wrapf(err, "index/get: failed to update message %s (seq: %d)", msg.Key().Ref(), seq.Seq())
-		}, idx)
-		return idx, sink
+		readIdx = repo.NewBadgerReadIndex(db, margaret.BaseSeq(0))
+		return idx, repo.NewBadgerBatchSink(db, keyGetSeq, idx, updateGet)
 	}
 
-	_, idx, sinkIdx, err := repo.OpenBadgerIndex(r, FolderNameGet, updateFn)
+	_, _, sinkIdx, err := repo.OpenBadgerIndex(r, FolderNameGet, updateFn)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "error getting get() index")
 	}
-	return idx, sinkIdx, nil
+	return readIdx, sinkIdx, nil
+}
+
+func updateGet(txn *badger.Txn, seq margaret.Seq, val interface{}) error {
+	if nulled, ok := val.(error); ok {
+		if margaret.IsErrNulled(nulled) {
+			return nil
+		}
+		return nulled
+	}
+	msg, ok := val.(ssb.Message)
+	if !ok {
+		return errors.Errorf("index/get: unexpected message type: %T", val)
+	}
+	err := repo.BadgerSetJSON(txn, msg.Key().Hash, seq.Seq())
+	return errors.Wrapf(err, "index/get: failed to update message %s (seq: %d)", msg.Key().Ref(), seq.Seq())
 }
@@ -0,0 +1,391 @@
+// SPDX-License-Identifier: MIT
+
+// Package livelog sits between a margaret log and the consumers of its live queries.
+//
+// Every consumer gets a queue of DefaultQueueSize (or the size passed to New) entries.
+// New entries are read from the log once and handed to all consumers that have room for them.
+// A consumer that falls behind by more than that is dropped from the broadcast and
+// reads from the log directly, from the last entry it got, until it has caught up again.
+// That way a slow consumer neither stalls the writer nor makes the queue grow without bound.
+package livelog
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/librarian"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+)
+
+// DefaultQueueSize is how many entries a live consumer can fall behind before it has to catch up from the log
+const DefaultQueueSize = 256
+
+// Log is a margaret.Log whose live queries go through a Broadcaster.
+// Everything else is passed to the wrapped log.
+type Log struct {
+	margaret.Log
+
+	bc *Broadcaster
+}
+
+// New wraps l so that its live queries are served by a shared broadcaster with queues of queueSize entries.
+// Close stops the broadcaster, it doesn't close l.
+func New(l margaret.Log, queueSize int) *Log {
+	return &Log{
+		Log: l,
+		bc:  NewBroadcaster(l, queueSize),
+	}
+}
+
+// Query is like the Query of the wrapped log. Live queries are served from the broadcaster.
+func (l *Log) Query(specs ...margaret.QuerySpec) (luigi.Source, error) {
+	qry := newQuery()
+	for _, spec := range specs {
+		if err := spec(qry); err != nil {
+			return nil, err
+		}
+	}
+	if !qry.live {
+		return l.Log.Query(specs...)
+	}
+	return l.bc.source(qry)
+}
+
+// Close stops serving live queries
+func (l *Log) Close() error {
+	l.bc.Close()
+	return nil
+}
+
+// Query runs a single query on l.
+// Live queries get their own broadcaster with a queue of queueSize entries, which stops with ctx or when the query ends.
+// This is meant for logs that are only queried now and then, like the sublogs of an index.
+func Query(ctx context.Context, l margaret.Log, queueSize int, specs ...margaret.QuerySpec) (luigi.Source, error) {
+	qry := newQuery()
+	for _, spec := range specs {
+		if err := spec(qry); err != nil {
+			return nil, err
+		}
+	}
+	if !qry.live {
+		return l.Query(specs...)
+	}
+
+	bc := NewBroadcaster(l, queueSize)
+	go func() {
+		select {
+		case <-ctx.Done():
+			bc.Close()
+		case <-bc.closed:
+		}
+	}()
+	src, err := bc.source(qry)
+	if err != nil {
+		bc.Close()
+		return nil, err
+	}
+	src.closeBroadcaster = true
+	return src, nil
+}
+
+// Broadcaster reads new entries of a log and hands them to the queues of its consumers
+type Broadcaster struct {
+	log       margaret.Log
+	queueSize int
+
+	wake      chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+	unreg     func()
+
+	mu         sync.Mutex
+	dispatched int64
+	subs       map[*subscription]struct{}
+}
+
+type subscription struct {
+	queue   chan margaret.SeqWrapper
+	dropped chan struct{}
+}
+
+// NewBroadcaster starts reading new entries of l
+func NewBroadcaster(l margaret.Log, queueSize int) *Broadcaster {
+	if queueSize < 1 {
+		queueSize = DefaultQueueSize
+	}
+	bc := &Broadcaster{
+		log:       l,
+		queueSize: queueSize,
+
+		wake:   make(chan struct{}, 1),
+		closed: make(chan struct{}),
+
+		dispatched: currentSeq(l),
+		subs:       make(map[*subscription]struct{}),
+	}
+
+	// the log notifies us while appending, so this must not block.
+	// the actual reading happens in run.
+	bc.unreg = l.Seq().Register(luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error {
+		select {
+		case bc.wake <- struct{}{}:
+		default:
+		}
+		return nil
+	}))
+
+	go bc.run()
+	return bc
+}
+
+// Close stops the broadcaster. Its sources return luigi.EOS.
+func (bc *Broadcaster) Close() {
+	bc.closeOnce.Do(func() {
+		bc.unreg()
+		close(bc.closed)
+	})
+}
+
+func (bc *Broadcaster) run() {
+	for {
+		select {
+		case <-bc.closed:
+			return
+		case <-bc.wake:
+		}
+
+		bc.mu.Lock()
+		next := bc.dispatched + 1
+		bc.mu.Unlock()
+
+		current := currentSeq(bc.log)
+		for ; next <= current; next++ {
+			v, err := bc.log.Get(margaret.BaseSeq(next))
+			if err != nil {
+				if !margaret.IsErrNulled(err) {
+					// try again with the next notification
+					break
+				}
+				v = err
+			}
+			bc.dispatch(margaret.WrapWithSeq(v, margaret.BaseSeq(next)))
+		}
+	}
+}
+
+func (bc *Broadcaster) dispatch(sw margaret.SeqWrapper) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.dispatched = sw.Seq().Seq()
+	for sub := range bc.subs {
+		select {
+		case sub.queue <- sw:
+		default:
+			// too slow, it has to catch up from the log
+			close(sub.dropped)
+			delete(bc.subs, sub)
+		}
+	}
+}
+
+// subscribe registers a new queue for entries after the returned sequence
+func (bc *Broadcaster) subscribe() (*subscription, int64) {
+	sub := &subscription{
+		queue:   make(chan margaret.SeqWrapper, bc.queueSize),
+		dropped: make(chan struct{}),
+	}
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.subs[sub] = struct{}{}
+	return sub, bc.dispatched
+}
+
+func (bc *Broadcaster) unsubscribe(sub *subscription) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	delete(bc.subs, sub)
+}
+
+func (bc *Broadcaster) source(qry *query) (*source, error) {
+	if qry.reverse {
+		return nil, errors.Errorf("livelog: live queries can't be reversed")
+	}
+	src := &source{
+		bc:      bc,
+		next:    qry.gt + 1,
+		lt:      -1,
+		limit:   -1,
+		seqWrap: qry.seqWrap,
+	}
+	if qry.hasLt {
+		src.lt = qry.lt
+	}
+	if qry.limit > 0 {
+		src.limit = qry.limit
+	}
+	return src, nil
+}
+
+// source is a live query. It reads from the log until it caught up and then from its queue.
+type source struct {
+	bc *Broadcaster
+
+	next    int64 // the sequence of the entry that is returned next
+	lt      int64 // stop before this, -1 for no end
+	limit   int   // how many entries are left to return, -1 for no limit
+	seqWrap bool
+
+	sub *subscription
+
+	// set if the broadcaster only exists for this source
+	closeBroadcaster bool
+}
+
+func (src *source) Next(ctx context.Context) (interface{}, error) {
+	if src.limit == 0 || (src.lt >= 0 && src.next >= src.lt) {
+		return nil, src.end(luigi.EOS{})
+	}
+
+	for {
+		if src.sub == nil {
+			// catching up from the log
+			if src.next <= currentSeq(src.bc.log) {
+				v, err := src.bc.log.Get(margaret.BaseSeq(src.next))
+				if err != nil {
+					if !margaret.IsErrNulled(err) {
+						return nil, src.end(errors.Wrapf(err, "livelog: failed to get entry %d", src.next))
+					}
+					v = err
+				}
+				return src.deliver(margaret.WrapWithSeq(v, margaret.BaseSeq(src.next))), nil
+			}
+
+			sub, dispatched := src.bc.subscribe()
+			if dispatched >= src.next {
+				// something was appended in the meantime, read that from the log first
+				src.bc.unsubscribe(sub)
+				if dispatched > currentSeq(src.bc.log) {
+					return nil, src.end(errors.Errorf("livelog: broadcast is ahead of the log"))
+				}
+				continue
+			}
+			src.sub = sub
+		}
+
+		select {
+		case sw := <-src.sub.queue:
+			seq := sw.Seq().Seq()
+			if seq < src.next {
+				continue
+			}
+			if seq > src.next {
+				// shouldn't happen since we check after subscribing, but the log is the authority
+				src.bc.unsubscribe(src.sub)
+				src.sub = nil
+				continue
+			}
+			return src.deliver(sw), nil
+
+		case <-src.sub.dropped:
+			src.sub = nil
+
+		case <-src.bc.closed:
+			return nil, src.end(luigi.EOS{})
+
+		case <-ctx.Done():
+			return nil, src.end(ctx.Err())
+		}
+	}
+}
+
+func (src *source) deliver(sw margaret.SeqWrapper) interface{} {
+	src.next++
+	if src.limit > 0 {
+		src.limit--
+	}
+	if src.seqWrap {
+		return sw
+	}
+	return sw.Value()
+}
+
+// end stops the subscription and returns err
+func (src *source) end(err error) error {
+	if src.sub != nil {
+		src.bc.unsubscribe(src.sub)
+		src.sub = nil
+	}
+	if src.closeBroadcaster {
+		src.bc.Close()
+	}
+	return err
+}
+
+// currentSeq returns the sequence of the last entry in l or -1 if it's empty
+func currentSeq(l margaret.Log) int64 {
+	v, err := l.Seq().Value()
+	if err != nil {
+		return margaret.SeqEmpty.Seq()
+	}
+	switch sv := v.(type) {
+	case margaret.Seq:
+		return sv.Seq()
+	case librarian.UnsetValue:
+		// empty sublog
+	}
+	return margaret.SeqEmpty.Seq()
+}
+
+// query records the query specs, it implements margaret.Query
+type query struct {
+	gt, lt  int64
+	hasLt   bool
+	limit   int
+	live    bool
+	seqWrap bool
+	reverse bool
+}
+
+func newQuery() *query {
+	return &query{gt: margaret.SeqEmpty.Seq(), limit: -1}
+}
+
+func (q *query) Gt(s margaret.Seq) error {
+	if s.Seq() > q.gt {
+		q.gt = s.Seq()
+	}
+	return nil
+}
+
+func (q *query) Gte(s margaret.Seq) error { return q.Gt(margaret.BaseSeq(s.Seq() - 1)) }
+
+func (q *query) Lt(s margaret.Seq) error {
+	if !q.hasLt || s.Seq() < q.lt {
+		q.lt, q.hasLt = s.Seq(), true
+	}
+	return nil
+}
+
+func (q *query) Lte(s margaret.Seq) error { return q.Lt(margaret.BaseSeq(s.Seq() + 1)) }
+
+func (q *query) Limit(n int) error {
+	q.limit = n
+	return nil
+}
+
+func (q *query) Live(live bool) error {
+	q.live = live
+	return nil
+}
+
+func (q *query) SeqWrap(wrap bool) error {
+	q.seqWrap = wrap
+	return nil
+}
+
+func (q *query) Reverse(yes bool) error {
+	q.reverse = yes
+	return nil
+}
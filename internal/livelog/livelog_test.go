@@ -0,0 +1,208 @@
+// SPDX-License-Identifier: MIT
+
+package livelog
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/margaret"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/message/legacy"
+	"go.cryptoscope.co/ssb/message/multimsg"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+type testLog struct {
+	multimsg.AlterableLog
+
+	kp   *ssb.KeyPair
+	prev *ssb.MessageRef
+	n    int
+}
+
+func newTestLog(t *testing.T) *testLog {
+	r := require.New(t)
+
+	// the memory backend doesn't write the log to disk
+	testPath := filepath.Join("testrun", t.Name())
+	rl, err := repo.OpenLog(repo.New(testPath, repo.WithLogBackend(repo.NewMemoryLogBackend())))
+	r.NoError(err)
+
+	kp, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+	return &testLog{AlterableLog: rl, kp: kp}
+}
+
+func (tl *testLog) add(t *testing.T, cnt int) {
+	r := require.New(t)
+	for i := 0; i < cnt; i++ {
+		tl.n++
+		lm := legacy.LegacyMessage{
+			Previous:  tl.prev,
+			Author:    tl.kp.Id.Ref(),
+			Sequence:  margaret.BaseSeq(tl.n),
+			Timestamp: int64(tl.n),
+			Hash:      "sha256",
+			Content:   map[string]interface{}{"type": "test", "i": tl.n},
+		}
+		ref, raw, err := lm.Sign(tl.kp.Pair.Secret[:], nil)
+		r.NoError(err)
+
+		_, err = tl.Append(&legacy.StoredMessage{
+			Author_:   tl.kp.Id,
+			Previous_: tl.prev,
+			Key_:      ref,
+			Sequence_: margaret.BaseSeq(tl.n),
+			Raw_:      raw,
+		})
+		r.NoError(err)
+		tl.prev = ref
+	}
+}
+
+// nextSeq reads the next value of src and returns its log sequence
+func nextSeq(ctx context.Context, r *require.Assertions, src interface {
+	Next(context.Context) (interface{}, error)
+}) int64 {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	v, err := src.Next(ctx)
+	r.NoError(err)
+	sw, ok := v.(margaret.SeqWrapper)
+	r.True(ok, "not seq wrapped: %T", v)
+	msg, ok := sw.Value().(ssb.Message)
+	r.True(ok, "not a message: %T", sw.Value())
+	// the feed sequence starts at 1, the log at 0
+	r.EqualValues(sw.Seq().Seq()+1, msg.Seq())
+	return sw.Seq().Seq()
+}
+
+func TestLiveQuery(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	tl := newTestLog(t)
+	tl.add(t, 3)
+
+	ll := New(tl, 4)
+	defer ll.Close()
+
+	src, err := ll.Query(margaret.Gt(margaret.BaseSeq(0)), margaret.Live(true), margaret.SeqWrap(true), margaret.Limit(4))
+	r.NoError(err)
+
+	// the backlog
+	r.EqualValues(1, nextSeq(ctx, r, src))
+	r.EqualValues(2, nextSeq(ctx, r, src))
+
+	// and new ones
+	go tl.add(t, 2)
+	r.EqualValues(3, nextSeq(ctx, r, src))
+	r.EqualValues(4, nextSeq(ctx, r, src))
+
+	_, err = src.Next(ctx)
+	r.Error(err, "expected the end after the limit")
+
+	// non-live queries go to the log
+	src, err = ll.Query(margaret.SeqWrap(true))
+	r.NoError(err)
+	for i := int64(0); i < 5; i++ {
+		r.Equal(i, nextSeq(ctx, r, src))
+	}
+
+	_, err = ll.Query(margaret.Live(true), margaret.Reverse(true))
+	r.Error(err)
+}
+
+func TestSlowConsumer(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	tl := newTestLog(t)
+	ll := New(tl, 2)
+	defer ll.Close()
+
+	fast, err := ll.Query(margaret.Live(true), margaret.SeqWrap(true))
+	r.NoError(err)
+	slow, err := ll.Query(margaret.Live(true), margaret.SeqWrap(true))
+	r.NoError(err)
+
+	// both are subscribed once they are waiting for the first message
+	slowFirst := make(chan error, 1)
+	go func() {
+		_, err := slow.Next(ctx)
+		slowFirst <- err
+	}()
+	r.EqualValues(0, nextSeqAfter(ctx, r, fast, func() { tl.add(t, 1) }))
+	r.NoError(<-slowFirst)
+
+	// the writer doesn't wait for the slow one
+	const total = 50
+	appended := make(chan struct{})
+	go func() {
+		tl.add(t, total)
+		close(appended)
+	}()
+	for i := int64(1); i <= total; i++ {
+		r.Equal(i, nextSeq(ctx, r, fast))
+	}
+	select {
+	case <-appended:
+	case <-time.After(5 * time.Second):
+		t.Fatal("appending blocked")
+	}
+
+	// the queue of the slow one overflowed, it was dropped from the broadcast
+	slowSrc := slow.(*source)
+	r.NotNil(slowSrc.sub)
+	ll.bc.mu.Lock()
+	_, subscribed := ll.bc.subs[slowSrc.sub]
+	ll.bc.mu.Unlock()
+	r.False(subscribed)
+
+	// but it still gets everything in order, from the log
+	for i := int64(1); i <= total; i++ {
+		r.Equal(i, nextSeq(ctx, r, slow))
+	}
+
+	// and new ones after catching up
+	go tl.add(t, 1)
+	r.EqualValues(total+1, nextSeq(ctx, r, slow))
+	r.EqualValues(total+1, nextSeq(ctx, r, fast))
+}
+
+// nextSeqAfter is nextSeq, with do running while src is waiting
+func nextSeqAfter(ctx context.Context, r *require.Assertions, src interface {
+	Next(context.Context) (interface{}, error)
+}, do func()) int64 {
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		do()
+	}()
+	return nextSeq(ctx, r, src)
+}
+
+func TestQueryStopsWithContext(t *testing.T) {
+	r := require.New(t)
+
+	tl := newTestLog(t)
+	tl.add(t, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	src, err := Query(ctx, tl, 2, margaret.Live(true), margaret.SeqWrap(true))
+	r.NoError(err)
+	r.EqualValues(0, nextSeq(ctx, r, src))
+
+	cancel()
+	_, err = src.Next(context.Background())
+	r.Error(err)
+	select {
+	case <-src.(*source).bc.closed:
+	case <-time.After(time.Second):
+		t.Fatal("broadcaster not closed")
+	}
+}
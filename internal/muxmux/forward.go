@@ -0,0 +1,81 @@
+// SPDX-License-Identifier: MIT
+
+package muxmux
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/muxrpc"
+)
+
+// Forward makes the call req on edp as a call of type typ and passes the results back, for proxies.
+// The arguments and results are passed as JSON, binary streams are not supported.
+func Forward(ctx context.Context, req *muxrpc.Request, typ string, edp muxrpc.Endpoint) {
+	var args []interface{}
+	if len(req.RawArgs) > 0 {
+		var raw []json.RawMessage
+		if err := json.Unmarshal(req.RawArgs, &raw); err != nil {
+			req.CloseWithError(errors.Wrap(err, "forward: invalid arguments"))
+			return
+		}
+		for _, a := range raw {
+			args = append(args, a)
+		}
+	}
+
+	switch typ {
+	case "async":
+		v, err := edp.Async(ctx, json.RawMessage{}, req.Method, args...)
+		if err != nil {
+			req.CloseWithError(err)
+			return
+		}
+		if err := req.Return(ctx, v); err != nil {
+			req.CloseWithError(err)
+		}
+
+	case "source":
+		src, err := edp.Source(ctx, json.RawMessage{}, req.Method, args...)
+		if err != nil {
+			req.CloseWithError(err)
+			return
+		}
+		closeWith(req, luigi.Pump(ctx, req.Stream, src))
+
+	case "sink":
+		snk, err := edp.Sink(ctx, req.Method, args...)
+		if err != nil {
+			req.CloseWithError(err)
+			return
+		}
+		err = luigi.Pump(ctx, snk, req.Stream)
+		snk.Close()
+		closeWith(req, err)
+
+	case "duplex":
+		src, snk, err := edp.Duplex(ctx, json.RawMessage{}, req.Method, args...)
+		if err != nil {
+			req.CloseWithError(err)
+			return
+		}
+		go func() {
+			luigi.Pump(ctx, snk, req.Stream)
+			snk.Close()
+		}()
+		closeWith(req, luigi.Pump(ctx, req.Stream, src))
+
+	default:
+		req.CloseWithError(errors.Errorf("forward: unhandled call type %q", typ))
+	}
+}
+
+func closeWith(req *muxrpc.Request, err error) {
+	if err != nil {
+		req.CloseWithError(err)
+		return
+	}
+	req.Stream.Close()
+}
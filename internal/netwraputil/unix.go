@@ -0,0 +1,54 @@
+// SPDX-License-Identifier: MIT
+
+package netwraputil
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// ListenUnixPrivate listens on the unix socket sockPath, which only the current user can connect to.
+// The socket is created in a new 0700 directory next to sockPath, restricted and then moved into place,
+// so there is no moment in which other users could connect to it. Closing the listener removes the socket.
+func ListenUnixPrivate(sockPath string) (net.Listener, error) {
+	dir, err := ioutil.TempDir(filepath.Dir(sockPath), ".sock")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create private socket directory")
+	}
+	defer os.RemoveAll(dir)
+
+	tmpPath := filepath.Join(dir, "sock")
+	lis, err := net.Listen("unix", tmpPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to listen")
+	}
+	// it would try to remove the socket from its old path
+	if ul, ok := lis.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(false)
+	}
+	if err := os.Chmod(tmpPath, 0600); err != nil {
+		lis.Close()
+		return nil, errors.Wrap(err, "failed to restrict socket")
+	}
+	if err := os.Rename(tmpPath, sockPath); err != nil {
+		lis.Close()
+		return nil, errors.Wrap(err, "failed to move socket into place")
+	}
+	return privateListener{Listener: lis, path: sockPath}, nil
+}
+
+type privateListener struct {
+	net.Listener
+
+	path string
+}
+
+func (pl privateListener) Close() error {
+	err := pl.Listener.Close()
+	os.Remove(pl.path)
+	return err
+}
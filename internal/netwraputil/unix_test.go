@@ -0,0 +1,50 @@
+// SPDX-License-Identifier: MIT
+
+package netwraputil
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenUnixPrivate(t *testing.T) {
+	r := require.New(t)
+
+	dir, err := ioutil.TempDir("", t.Name())
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+	sockPath := filepath.Join(dir, "test.sock")
+
+	lis, err := ListenUnixPrivate(sockPath)
+	r.NoError(err)
+
+	fi, err := os.Stat(sockPath)
+	r.NoError(err)
+	r.Equal(os.FileMode(0600), fi.Mode().Perm())
+
+	accepted := make(chan error, 1)
+	go func() {
+		c, err := lis.Accept()
+		if err == nil {
+			c.Close()
+		}
+		accepted <- err
+	}()
+	c, err := net.Dial("unix", sockPath)
+	r.NoError(err)
+	c.Close()
+	r.NoError(<-accepted)
+
+	// only the socket is left, until it's closed
+	entries, err := ioutil.ReadDir(dir)
+	r.NoError(err)
+	r.Len(entries, 1)
+	r.NoError(lis.Close())
+	_, err = os.Stat(sockPath)
+	r.True(os.IsNotExist(err))
+}
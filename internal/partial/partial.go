@@ -0,0 +1,190 @@
+// SPDX-License-Identifier: MIT
+
+// Package partial keeps track of the feeds of which only some content types are stored.
+//
+// Their messages are still fetched and verified in order, but only the ones of the kept types end up in the receive log.
+// So the sublog of such a feed has gaps and can't be used to continue its chain,
+// the Marker of the feed has the latest verified message for that instead.
+package partial
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/message/legacy"
+)
+
+// Marker is the state of one partially replicated feed
+type Marker struct {
+	// Types are the content types that are stored
+	Types []string `json:"types"`
+
+	// Seq is the latest message that was verified, stored or not. Raw is that message, null if Seq is 0.
+	Seq int64           `json:"seq"`
+	Raw json.RawMessage `json:"raw,omitempty"`
+}
+
+// Keeps returns true if messages with content of type tipe are stored.
+// Encrypted content has the type "private".
+func (m Marker) Keeps(tipe string) bool {
+	for _, t := range m.Types {
+		if t == tipe {
+			return true
+		}
+	}
+	return false
+}
+
+// Latest returns the message in Raw, to check the chain of the next one against. nil if there is none.
+func (m Marker) Latest(hmacKey *[32]byte) (ssb.Message, error) {
+	if len(m.Raw) == 0 || string(m.Raw) == "null" {
+		return nil, nil
+	}
+	ref, dmsg, err := legacy.Verify(m.Raw, hmacKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "partial: stored latest message is broken")
+	}
+	return &legacy.StoredMessage{
+		Author_:    &dmsg.Author,
+		Previous_:  dmsg.Previous,
+		Key_:       ref,
+		Sequence_:  dmsg.Sequence,
+		Timestamp_: time.Now(),
+		Raw_:       m.Raw,
+	}, nil
+}
+
+// ContentType returns the type of the content of msg, "private" if it is encrypted and "" if it has none
+func ContentType(msg ssb.Message) string {
+	var typed struct {
+		Type string `json:"type"`
+	}
+	content := msg.ContentBytes()
+	if len(content) > 0 && content[0] == '"' {
+		return "private"
+	}
+	if err := json.Unmarshal(content, &typed); err != nil {
+		return ""
+	}
+	return typed.Type
+}
+
+// Store has the markers of all partially replicated feeds, in a JSON file
+type Store struct {
+	path   string
+	saveMu sync.Mutex
+
+	mu    sync.Mutex
+	feeds map[string]Marker
+}
+
+// Open loads the store at path, which doesn't have to exist yet
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path:  path,
+		feeds: make(map[string]Marker),
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "partial: failed to read markers")
+	}
+	if err := json.Unmarshal(data, &s.feeds); err != nil {
+		return nil, errors.Wrap(err, "partial: failed to decode markers")
+	}
+	return s, nil
+}
+
+// Get returns the marker of feed, false if it is replicated fully
+func (s *Store) Get(feed *ssb.FeedRef) (Marker, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.feeds[feed.Ref()]
+	return m, ok
+}
+
+// IsPartial returns true if not all messages of feed are stored. A nil store has no partial feeds.
+func (s *Store) IsPartial(feed *ssb.FeedRef) bool {
+	if s == nil {
+		return false
+	}
+	_, ok := s.Get(feed)
+	return ok
+}
+
+// List returns the feeds that are replicated partially
+func (s *Store) List() []*ssb.FeedRef {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var feeds []*ssb.FeedRef
+	for f := range s.feeds {
+		if ref, err := ssb.ParseFeedRef(f); err == nil {
+			feeds = append(feeds, ref)
+		}
+	}
+	return feeds
+}
+
+// Mark makes feed partial from the message at seq on, which is raw. Marking a partial feed again only changes its types.
+func (s *Store) Mark(feed *ssb.FeedRef, types []string, seq int64, raw json.RawMessage) error {
+	s.mu.Lock()
+	m, ok := s.feeds[feed.Ref()]
+	if !ok {
+		m = Marker{Seq: seq, Raw: raw}
+	}
+	m.Types = types
+	s.feeds[feed.Ref()] = m
+	s.mu.Unlock()
+	return s.save()
+}
+
+// Unmark makes feed a normal one again. Its stored messages still have gaps, they need to be deleted.
+func (s *Store) Unmark(feed *ssb.FeedRef) error {
+	s.mu.Lock()
+	delete(s.feeds, feed.Ref())
+	s.mu.Unlock()
+	return s.save()
+}
+
+// Advance moves the marker of feed to msg, after it was verified. It is only written to disk with Flush.
+func (s *Store) Advance(msg ssb.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	feed := msg.Author().Ref()
+	m, ok := s.feeds[feed]
+	if !ok || msg.Seq() <= m.Seq {
+		return
+	}
+	m.Seq = msg.Seq()
+	m.Raw = msg.ValueContentJSON()
+	s.feeds[feed] = m
+}
+
+// Flush writes the markers to disk
+func (s *Store) Flush() error {
+	return s.save()
+}
+
+func (s *Store) save() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.Lock()
+	data, err := json.Marshal(s.feeds)
+	s.mu.Unlock()
+	if err != nil {
+		return errors.Wrap(err, "partial: failed to encode markers")
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return errors.Wrap(err, "partial: failed to write markers")
+	}
+	return errors.Wrap(os.Rename(tmp, s.path), "partial: failed to replace markers")
+}
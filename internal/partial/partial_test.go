@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: MIT
+
+package partial
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/margaret"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/message/legacy"
+)
+
+func TestMarkers(t *testing.T) {
+	r := require.New(t)
+
+	dir, err := ioutil.TempDir("", t.Name())
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "partial.json")
+
+	kp, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+
+	var (
+		prev *ssb.MessageRef
+		msgs []ssb.Message
+	)
+	for i, content := range []interface{}{
+		map[string]interface{}{"type": "contact", "contact": kp.Id.Ref(), "following": true},
+		map[string]interface{}{"type": "post", "text": "hello"},
+		"c2VjcmV0.box",
+	} {
+		lm := legacy.LegacyMessage{
+			Previous:  prev,
+			Author:    kp.Id.Ref(),
+			Sequence:  margaret.BaseSeq(i + 1),
+			Timestamp: int64(i+1) * 1000,
+			Hash:      "sha256",
+			Content:   content,
+		}
+		ref, raw, err := lm.Sign(kp.Pair.Secret[:], nil)
+		r.NoError(err)
+		msgs = append(msgs, &legacy.StoredMessage{
+			Author_:   kp.Id,
+			Previous_: prev,
+			Key_:      ref,
+			Sequence_: margaret.BaseSeq(i + 1),
+			Raw_:      raw,
+		})
+		prev = ref
+	}
+	r.Equal("contact", ContentType(msgs[0]))
+	r.Equal("post", ContentType(msgs[1]))
+	r.Equal("private", ContentType(msgs[2]))
+
+	s, err := Open(path)
+	r.NoError(err)
+	r.False(s.IsPartial(kp.Id))
+	r.Len(s.List(), 0)
+
+	// partial from the first message on
+	r.NoError(s.Mark(kp.Id, []string{"contact"}, 1, msgs[0].ValueContentJSON()))
+	r.True(s.IsPartial(kp.Id))
+	m, ok := s.Get(kp.Id)
+	r.True(ok)
+	r.True(m.Keeps("contact"))
+	r.False(m.Keeps("post"))
+
+	s.Advance(msgs[1])
+	s.Advance(msgs[0]) // doesn't go back
+	r.NoError(s.Flush())
+
+	// marking it again only changes the types
+	r.NoError(s.Mark(kp.Id, []string{"contact", "about"}, 0, nil))
+
+	s, err = Open(path)
+	r.NoError(err)
+	m, ok = s.Get(kp.Id)
+	r.True(ok)
+	r.Equal([]string{"contact", "about"}, m.Types)
+	r.EqualValues(2, m.Seq)
+
+	latest, err := m.Latest(nil)
+	r.NoError(err)
+	r.EqualValues(2, latest.Seq())
+	r.True(latest.Key().Equal(*msgs[1].Key()))
+
+	r.NoError(s.Unmark(kp.Id))
+	r.False(s.IsPartial(kp.Id))
+
+	var none *Store
+	r.False(none.IsPartial(kp.Id))
+}
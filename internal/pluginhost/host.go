@@ -0,0 +1,341 @@
+// SPDX-License-Identifier: MIT
+
+// Package pluginhost lets other processes add muxrpc methods to the bot.
+//
+// An extension connects to the socket of the host and calls plugins.register with the token
+// from the token file, a name and the methods it serves:
+//
+//	plugins.register({"token": "...", "name": "mybot", "methods": [{"name": "mybot.hello", "type": "async", "public": false}]})
+//
+// Afterwards calls to these methods, from peers (if public) and local clients, are passed to the extension.
+// All other calls the extension makes on the connection go to the methods of the bot, like on its unix socket.
+// Once the connection of an extension ends, its methods return errors until it registers them again.
+package pluginhost
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/muxrpc"
+	"go.cryptoscope.co/netwrap"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/muxmux"
+)
+
+// Options are the parts of the bot the host needs
+type Options struct {
+	Logger log.Logger
+
+	// the registered methods are added to these.
+	// Master also serves the calls of the extensions to the bot.
+	Master, Public ssb.PluginManager
+
+	// WrapConn makes the connection of an extension look like the bot itself to the master plugins
+	WrapConn netwrap.ConnWrapper
+
+	// where the token is written to, readable only by the user of the bot
+	TokenPath string
+}
+
+// MethodSpec is a method that an extension serves
+type MethodSpec struct {
+	Name string `json:"name"`
+
+	// async, source, sink or duplex
+	Type string `json:"type"`
+
+	// if peers can call it, too
+	Public bool `json:"public"`
+}
+
+// RegisterArgs is the argument of plugins.register
+type RegisterArgs struct {
+	Token   string       `json:"token"`
+	Name    string       `json:"name"`
+	Methods []MethodSpec `json:"methods"`
+}
+
+// Host keeps track of the connected extensions and the methods they serve
+type Host struct {
+	logger         log.Logger
+	master, public ssb.PluginManager
+	wrapConn       netwrap.ConnWrapper
+
+	token []byte
+
+	mu sync.Mutex
+	// which extension serves a method
+	methods map[string]*extMethod
+	// the methods that have a forwarding plugin in the plugin managers, they stay there once added
+	mounted map[string]bool
+}
+
+type extension struct {
+	name   string
+	edp    muxrpc.Endpoint
+	authed bool
+
+	// closed once the connection ended
+	gone chan struct{}
+}
+
+type extMethod struct {
+	ext  *extension
+	spec MethodSpec
+}
+
+// New creates a host and writes a new token to opts.TokenPath
+func New(opts Options) (*Host, error) {
+	if opts.Master == nil || opts.Public == nil {
+		return nil, errors.New("pluginhost: need plugin managers")
+	}
+
+	var tok [32]byte
+	if _, err := rand.Read(tok[:]); err != nil {
+		return nil, errors.Wrap(err, "pluginhost: failed to make token")
+	}
+	token := []byte(hex.EncodeToString(tok[:]))
+	if err := ioutil.WriteFile(opts.TokenPath, token, 0600); err != nil {
+		return nil, errors.Wrap(err, "pluginhost: failed to write token file")
+	}
+
+	h := &Host{
+		logger:   opts.Logger,
+		master:   opts.Master,
+		public:   opts.Public,
+		wrapConn: opts.WrapConn,
+
+		token: token,
+
+		methods: make(map[string]*extMethod),
+		mounted: make(map[string]bool),
+	}
+	if h.logger == nil {
+		h.logger = log.NewNopLogger()
+	}
+	if h.wrapConn == nil {
+		h.wrapConn = func(c net.Conn) (net.Conn, error) { return c, nil }
+	}
+	return h, nil
+}
+
+// Serve accepts extensions on lis until ctx is canceled or lis is closed
+func (h *Host) Serve(ctx context.Context, lis net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		lis.Close()
+	}()
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if nerr, ok := err.(*net.OpError); ok && nerr.Err.Error() == "use of closed network connection" {
+				return nil
+			}
+			return errors.Wrap(err, "pluginhost: accept failed")
+		}
+		go h.serveConn(ctx, conn)
+	}
+}
+
+func (h *Host) serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	wc, err := h.wrapConn(conn)
+	if err != nil {
+		level.Warn(h.logger).Log("event", "extension conn", "err", err)
+		return
+	}
+
+	botHandler, err := h.master.MakeHandler(wc)
+	if err != nil {
+		level.Warn(h.logger).Log("event", "extension conn", "err", err)
+		return
+	}
+
+	ext := &extension{gone: make(chan struct{})}
+	eh := &extHandler{host: h, ext: ext, bot: botHandler}
+	edp := muxrpc.HandleWithLogger(muxrpc.NewPacker(wc), eh, h.logger)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	srv := edp.(muxrpc.Server)
+	if err := srv.Serve(ctx); err != nil {
+		level.Debug(h.logger).Log("event", "extension conn closed", "name", ext.name, "err", err)
+	}
+	edp.Terminate()
+
+	close(ext.gone)
+	h.drop(ext)
+}
+
+// register adds the methods of args for ext, either all of them or none
+func (h *Host) register(ext *extension, edp muxrpc.Endpoint, args RegisterArgs) ([]string, error) {
+	if subtle.ConstantTimeCompare([]byte(args.Token), h.token) != 1 {
+		return nil, errors.New("pluginhost: invalid token")
+	}
+	if args.Name == "" {
+		return nil, errors.New("pluginhost: extension needs a name")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	builtin := make(map[string]bool)
+	for _, mgr := range []ssb.PluginManager{h.master, h.public} {
+		lister, ok := mgr.(ssb.PluginLister)
+		if !ok {
+			return nil, errors.Errorf("pluginhost: can't check the methods of %T", mgr)
+		}
+		for _, m := range lister.Methods() {
+			if !h.mounted[m.String()] {
+				builtin[m[0]] = true
+			}
+		}
+	}
+
+	var names []string
+	seen := make(map[string]bool)
+	for _, spec := range args.Methods {
+		switch spec.Type {
+		case "async", "source", "sink", "duplex":
+		default:
+			return nil, errors.Errorf("pluginhost: %s has invalid type %q", spec.Name, spec.Type)
+		}
+
+		m := muxrpc.Method(strings.Split(spec.Name, "."))
+		if spec.Name == "" || m[0] == "" {
+			return nil, errors.Errorf("pluginhost: invalid method name %q", spec.Name)
+		}
+		if m[0] == "plugins" || builtin[m[0]] {
+			return nil, errors.Errorf("pluginhost: %s conflicts with the built-in %s methods", spec.Name, m[0])
+		}
+		if em, has := h.methods[spec.Name]; has && em.ext != ext {
+			return nil, errors.Errorf("pluginhost: %s is already served by %s", spec.Name, em.ext.name)
+		}
+		if seen[spec.Name] {
+			return nil, errors.Errorf("pluginhost: %s is listed twice", spec.Name)
+		}
+		seen[spec.Name] = true
+		names = append(names, spec.Name)
+	}
+
+	ext.name = args.Name
+	ext.edp = edp
+	ext.authed = true
+	for _, spec := range args.Methods {
+		h.methods[spec.Name] = &extMethod{ext: ext, spec: spec}
+		if h.mounted[spec.Name] {
+			continue
+		}
+		m := muxrpc.Method(strings.Split(spec.Name, "."))
+		h.master.Register(extPlugin{host: h, method: m})
+		h.public.Register(extPlugin{host: h, method: m, public: true})
+		h.mounted[spec.Name] = true
+	}
+	level.Info(h.logger).Log("event", "extension registered", "name", ext.name, "methods", strings.Join(names, ","))
+	return names, nil
+}
+
+// drop forgets the methods of ext
+func (h *Host) drop(ext *extension) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for name, em := range h.methods {
+		if em.ext == ext {
+			delete(h.methods, name)
+		}
+	}
+}
+
+// lookup returns who serves method
+func (h *Host) lookup(method muxrpc.Method) (*extMethod, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	em, has := h.methods[method.String()]
+	return em, has
+}
+
+// extHandler serves the connection of an extension
+type extHandler struct {
+	host *Host
+	ext  *extension
+	bot  muxrpc.Handler
+}
+
+func (eh *extHandler) HandleConnect(context.Context, muxrpc.Endpoint) {}
+
+func (eh *extHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
+	if req.Method.String() == "plugins.register" {
+		var args []RegisterArgs
+		if err := json.Unmarshal(req.RawArgs, &args); err != nil || len(args) != 1 {
+			req.CloseWithError(errors.New("pluginhost: plugins.register expects one object as argument"))
+			return
+		}
+		names, err := eh.host.register(eh.ext, edp, args[0])
+		if err != nil {
+			req.CloseWithError(err)
+			return
+		}
+		if err := req.Return(ctx, names); err != nil {
+			level.Warn(eh.host.logger).Log("event", "register reply failed", "err", err)
+		}
+		return
+	}
+
+	eh.host.mu.Lock()
+	authed := eh.ext.authed
+	eh.host.mu.Unlock()
+	if !authed {
+		req.CloseWithError(errors.New("pluginhost: call plugins.register first"))
+		return
+	}
+	eh.bot.HandleCall(ctx, req, edp)
+}
+
+// extPlugin is mounted on the plugin managers and passes calls on to the extension that serves the method
+type extPlugin struct {
+	host   *Host
+	method muxrpc.Method
+	public bool
+}
+
+func (p extPlugin) Name() string            { return "extension:" + p.method.String() }
+func (p extPlugin) Method() muxrpc.Method   { return p.method }
+func (p extPlugin) Handler() muxrpc.Handler { return p }
+
+func (extPlugin) HandleConnect(context.Context, muxrpc.Endpoint) {}
+
+func (p extPlugin) HandleCall(ctx context.Context, req *muxrpc.Request, _ muxrpc.Endpoint) {
+	em, has := p.host.lookup(p.method)
+	if !has || (p.public && !em.spec.Public) {
+		req.CloseWithError(errors.Errorf("pluginhost: no extension serves %s", p.method))
+		return
+	}
+
+	// don't wait for an extension that is gone
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-em.ext.gone:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	muxmux.Forward(ctx, req, em.spec.Type, em.ext.edp)
+}
@@ -0,0 +1,153 @@
+// SPDX-License-Identifier: MIT
+
+package pluginhost
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/muxrpc"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// funcHandler answers async calls with fn
+type funcHandler func(req *muxrpc.Request) (interface{}, error)
+
+func (funcHandler) HandleConnect(context.Context, muxrpc.Endpoint) {}
+
+func (fh funcHandler) HandleCall(ctx context.Context, req *muxrpc.Request, _ muxrpc.Endpoint) {
+	if fh == nil {
+		req.CloseWithError(errors.New("no calls expected"))
+		return
+	}
+	v, err := fh(req)
+	if err != nil {
+		req.CloseWithError(err)
+		return
+	}
+	req.Return(ctx, v)
+}
+
+type testPlugin struct {
+	method muxrpc.Method
+	h      muxrpc.Handler
+}
+
+func (p testPlugin) Name() string            { return p.method.String() }
+func (p testPlugin) Method() muxrpc.Method   { return p.method }
+func (p testPlugin) Handler() muxrpc.Handler { return p.h }
+
+func serve(conn net.Conn, h muxrpc.Handler) muxrpc.Endpoint {
+	edp := muxrpc.HandleWithLogger(muxrpc.NewPacker(conn), h, log.NewNopLogger())
+	go edp.(muxrpc.Server).Serve(context.Background())
+	return edp
+}
+
+// client connects to the handler mgr makes, like a peer or a local client would
+func client(r *require.Assertions, mgr ssb.PluginManager) muxrpc.Endpoint {
+	botSide, clientSide := net.Pipe()
+	h, err := mgr.MakeHandler(botSide)
+	r.NoError(err)
+	serve(botSide, h)
+	return serve(clientSide, funcHandler(nil))
+}
+
+type reply map[string]interface{}
+
+func TestHost(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", t.Name())
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	master, public := ssb.NewPluginManager(), ssb.NewPluginManager()
+	master.Register(testPlugin{
+		method: muxrpc.Method{"bot", "echo"},
+		h: funcHandler(func(req *muxrpc.Request) (interface{}, error) {
+			return reply{"echo": req.Args()[0]}, nil
+		}),
+	})
+
+	tokenPath := filepath.Join(dir, "plugins.token")
+	host, err := New(Options{Master: master, Public: public, TokenPath: tokenPath})
+	r.NoError(err)
+	token, err := ioutil.ReadFile(tokenPath)
+	r.NoError(err)
+
+	lis, err := net.Listen("unix", filepath.Join(dir, "plugins.sock"))
+	r.NoError(err)
+	srvCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go host.Serve(srvCtx, lis)
+
+	conn, err := net.Dial("unix", filepath.Join(dir, "plugins.sock"))
+	r.NoError(err)
+	ext := serve(conn, funcHandler(func(req *muxrpc.Request) (interface{}, error) {
+		return reply{"hello": "from the extension"}, nil
+	}))
+
+	register := func(args RegisterArgs) error {
+		_, err := ext.Async(ctx, []string{}, muxrpc.Method{"plugins", "register"}, args)
+		return err
+	}
+	hello := []MethodSpec{{Name: "mybot.hello", Type: "async"}}
+
+	// calls before registering are refused
+	_, err = ext.Async(ctx, reply{}, muxrpc.Method{"bot", "echo"}, "hi")
+	r.Error(err)
+
+	r.Error(register(RegisterArgs{Token: "wrong", Name: "mybot", Methods: hello}))
+	r.Error(register(RegisterArgs{Token: string(token), Name: "mybot", Methods: []MethodSpec{{Name: "bot.other", Type: "async"}}}), "built-in namespace")
+	r.Error(register(RegisterArgs{Token: string(token), Name: "mybot", Methods: []MethodSpec{{Name: "mybot.x", Type: "stream"}}}), "invalid type")
+	r.NoError(register(RegisterArgs{Token: string(token), Name: "mybot", Methods: hello}))
+
+	// the extension can call the bot
+	v, err := ext.Async(ctx, reply{}, muxrpc.Method{"bot", "echo"}, "hi")
+	r.NoError(err)
+	r.Equal(reply{"echo": "hi"}, v)
+
+	// local clients can call the extension
+	local := client(r, master)
+	v, err = local.Async(ctx, reply{}, muxrpc.Method{"mybot", "hello"})
+	r.NoError(err)
+	r.Equal(reply{"hello": "from the extension"}, v)
+
+	// peers can't, it's not public
+	peer := client(r, public)
+	_, err = peer.Async(ctx, reply{}, muxrpc.Method{"mybot", "hello"})
+	r.Error(err)
+
+	// a second extension can't take the method
+	conn2, err := net.Dial("unix", filepath.Join(dir, "plugins.sock"))
+	r.NoError(err)
+	ext2 := serve(conn2, funcHandler(nil))
+	_, err = ext2.Async(ctx, []string{}, muxrpc.Method{"plugins", "register"}, RegisterArgs{Token: string(token), Name: "other", Methods: hello})
+	r.Error(err)
+
+	// once the extension is gone its methods return errors
+	ext.Terminate()
+	conn.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	local = client(r, master)
+	callCtx, callCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer callCancel()
+	_, err = local.Async(callCtx, reply{}, muxrpc.Method{"mybot", "hello"})
+	r.Error(err)
+	r.NoError(callCtx.Err(), "call didn't return")
+
+	// and someone else can register them
+	_, err = ext2.Async(ctx, []string{}, muxrpc.Method{"plugins", "register"}, RegisterArgs{Token: string(token), Name: "other", Methods: hello})
+	r.NoError(err)
+}
@@ -0,0 +1,383 @@
+// SPDX-License-Identifier: MIT
+
+// Package qrcode is a small QR code encoder, just enough to show feed ids and ssb: URIs in a terminal.
+//
+// It only does byte mode with the lowest error correction level (L) and the versions 1 to 5,
+// which all use a single block of codewords. That holds up to 106 bytes.
+package qrcode
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// MaxLen is the most bytes that fit into the largest supported version
+const MaxLen = 106
+
+// ErrTooLong is returned for data that doesn't fit into version 5
+var ErrTooLong = errors.Errorf("qrcode: data longer than %d bytes", MaxLen)
+
+// versions holds the data and error correction codewords for each version at level L
+var versions = []struct {
+	data, ecc int
+}{
+	{}, // no version 0
+	{19, 7},
+	{34, 10},
+	{55, 15},
+	{80, 20},
+	{108, 26},
+}
+
+// Code is an encoded QR code
+type Code struct {
+	Version int
+	Size    int
+
+	mask     int
+	modules  [][]bool // [y][x], true is dark
+	function [][]bool // finder, timing, alignment and format modules
+}
+
+// Encode picks the smallest version data fits into
+func Encode(data []byte) (*Code, error) {
+	version := 0
+	for v := 1; v < len(versions); v++ {
+		// 4 bits mode, 8 bits count
+		if 12+8*len(data) <= 8*versions[v].data {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, ErrTooLong
+	}
+
+	c := newCode(version)
+	c.drawFunctionPatterns()
+
+	codewords := encodeData(data, versions[version].data)
+	codewords = append(codewords, rsRemainder(codewords, versions[version].ecc)...)
+	c.drawCodewords(codewords)
+
+	best, lowest := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		c.applyMask(mask)
+		c.drawFormatBits(mask)
+		if p := c.penalty(); lowest < 0 || p < lowest {
+			best, lowest = mask, p
+		}
+		c.applyMask(mask) // xor again to undo
+	}
+	c.mask = best
+	c.applyMask(best)
+	c.drawFormatBits(best)
+	return c, nil
+}
+
+// Dark tells if the module at column x and row y is dark
+func (c *Code) Dark(x, y int) bool {
+	return c.modules[y][x]
+}
+
+// Terminal draws the code with half blocks, two rows per line, and a quiet zone of two modules.
+// Dark modules are printed as blanks, which is right for light text on a dark background.
+// Use invert for dark text on a light background.
+func (c *Code) Terminal(invert bool) string {
+	const quiet = 2
+	blank := func(x, y int) bool {
+		if x < 0 || y < 0 || x >= c.Size || y >= c.Size {
+			return invert
+		}
+		return c.modules[y][x] != invert
+	}
+
+	var sb strings.Builder
+	for y := -quiet; y < c.Size+quiet; y += 2 {
+		for x := -quiet; x < c.Size+quiet; x++ {
+			top, bottom := blank(x, y), blank(x, y+1)
+			switch {
+			case top && bottom:
+				sb.WriteString(" ")
+			case top:
+				sb.WriteString("▄")
+			case bottom:
+				sb.WriteString("▀")
+			default:
+				sb.WriteString("█")
+			}
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+func newCode(version int) *Code {
+	c := &Code{Version: version, Size: 17 + 4*version}
+	c.modules = make([][]bool, c.Size)
+	c.function = make([][]bool, c.Size)
+	for i := range c.modules {
+		c.modules[i] = make([]bool, c.Size)
+		c.function[i] = make([]bool, c.Size)
+	}
+	return c
+}
+
+func (c *Code) setFunction(x, y int, dark bool) {
+	c.modules[y][x] = dark
+	c.function[y][x] = true
+}
+
+func (c *Code) drawFunctionPatterns() {
+	for i := 0; i < c.Size; i++ {
+		c.setFunction(6, i, i%2 == 0)
+		c.setFunction(i, 6, i%2 == 0)
+	}
+
+	c.drawFinder(3, 3)
+	c.drawFinder(c.Size-4, 3)
+	c.drawFinder(3, c.Size-4)
+
+	// below version 7 there is just the one alignment pattern that doesn't overlap the finders
+	if c.Version > 1 {
+		c.drawAlignment(c.Size-7, c.Size-7)
+	}
+
+	// reserve the format modules, the real bits are drawn after masking
+	c.drawFormatBits(0)
+}
+
+func (c *Code) drawFinder(cx, cy int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			x, y := cx+dx, cy+dy
+			if x < 0 || y < 0 || x >= c.Size || y >= c.Size {
+				continue
+			}
+			dist := max(abs(dx), abs(dy))
+			c.setFunction(x, y, dist != 2 && dist != 4)
+		}
+	}
+}
+
+func (c *Code) drawAlignment(cx, cy int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			c.setFunction(cx+dx, cy+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+func (c *Code) drawFormatBits(mask int) {
+	bits := formatBits(mask)
+	bit := func(i uint) bool { return (bits>>i)&1 != 0 }
+
+	// around the top left finder
+	for i := uint(0); i <= 5; i++ {
+		c.setFunction(8, int(i), bit(i))
+	}
+	c.setFunction(8, 7, bit(6))
+	c.setFunction(8, 8, bit(7))
+	c.setFunction(7, 8, bit(8))
+	for i := uint(9); i < 15; i++ {
+		c.setFunction(14-int(i), 8, bit(i))
+	}
+
+	// split between the other two finders
+	for i := uint(0); i < 8; i++ {
+		c.setFunction(c.Size-1-int(i), 8, bit(i))
+	}
+	for i := uint(8); i < 15; i++ {
+		c.setFunction(8, c.Size-15+int(i), bit(i))
+	}
+	c.setFunction(8, c.Size-8, true) // always dark
+}
+
+// formatBits returns the 15 bits for level L and the mask, with their BCH code and the fixed xor pattern
+func formatBits(mask int) uint {
+	data := uint(1<<3 | mask) // 01 is level L
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	return (data<<10 | rem&0x3ff) ^ 0x5412
+}
+
+// encodeData puts the bytes in byte mode and pads them up to capacity codewords
+func encodeData(data []byte, capacity int) []byte {
+	var bb bitBuffer
+	bb.append(0x4, 4)
+	bb.append(uint(len(data)), 8)
+	for _, b := range data {
+		bb.append(uint(b), 8)
+	}
+
+	free := capacity*8 - bb.n
+	if free > 4 {
+		free = 4
+	}
+	bb.append(0, free) // terminator
+	if rest := bb.n % 8; rest != 0 {
+		bb.append(0, 8-rest)
+	}
+	for pad := uint(0xec); bb.n < capacity*8; pad ^= 0xec ^ 0x11 {
+		bb.append(pad, 8)
+	}
+	return bb.bytes
+}
+
+// drawCodewords fills the non-function modules in the zigzag order, two columns at a time from the bottom right
+func (c *Code) drawCodewords(codewords []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // skip the vertical timing pattern
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < c.Size; vert++ {
+			y := vert
+			if upward {
+				y = c.Size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if c.function[y][x] || i >= len(codewords)*8 {
+					continue
+				}
+				c.modules[y][x] = (codewords[i>>3]>>(7-uint(i&7)))&1 != 0
+				i++
+			}
+		}
+	}
+}
+
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.function[y][x] {
+				continue
+			}
+			var flip bool
+			switch mask {
+			case 0:
+				flip = (x+y)%2 == 0
+			case 1:
+				flip = y%2 == 0
+			case 2:
+				flip = x%3 == 0
+			case 3:
+				flip = (x+y)%3 == 0
+			case 4:
+				flip = (x/3+y/2)%2 == 0
+			case 5:
+				flip = x*y%2+x*y%3 == 0
+			case 6:
+				flip = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				flip = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if flip {
+				c.modules[y][x] = !c.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores how hard the code is to scan, following the four rules of the spec
+func (c *Code) penalty() int {
+	var score, darkCount int
+
+	line := func(get func(i int) bool) {
+		// rule 1: runs of five or more
+		run := 1
+		for i := 1; i <= c.Size; i++ {
+			if i < c.Size && get(i) == get(i-1) {
+				run++
+				continue
+			}
+			if run >= 5 {
+				score += 3 + run - 5
+			}
+			run = 1
+		}
+		// rule 3: things that look like finders
+		for i := 0; i+11 <= c.Size; i++ {
+			if matches(get, i, finderLike) || matches(get, i, finderLikeReversed) {
+				score += 40
+			}
+		}
+	}
+
+	for y := 0; y < c.Size; y++ {
+		line(func(x int) bool { return c.modules[y][x] })
+	}
+	for x := 0; x < c.Size; x++ {
+		line(func(y int) bool { return c.modules[y][x] })
+	}
+
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.modules[y][x] {
+				darkCount++
+			}
+			// rule 2: two by two blocks
+			if x+1 < c.Size && y+1 < c.Size {
+				m := c.modules[y][x]
+				if c.modules[y][x+1] == m && c.modules[y+1][x] == m && c.modules[y+1][x+1] == m {
+					score += 3
+				}
+			}
+		}
+	}
+
+	// rule 4: balance of dark and light
+	percent := darkCount * 100 / (c.Size * c.Size)
+	score += abs(percent-50) / 5 * 10
+	return score
+}
+
+var (
+	finderLike         = []bool{true, false, true, true, true, false, true, false, false, false, false}
+	finderLikeReversed = []bool{false, false, false, false, true, false, true, true, true, false, true}
+)
+
+func matches(get func(i int) bool, start int, pattern []bool) bool {
+	for j, p := range pattern {
+		if get(start+j) != p {
+			return false
+		}
+	}
+	return true
+}
+
+type bitBuffer struct {
+	bytes []byte
+	n     int
+}
+
+func (bb *bitBuffer) append(v uint, bits int) {
+	for i := bits - 1; i >= 0; i-- {
+		if bb.n%8 == 0 {
+			bb.bytes = append(bb.bytes, 0)
+		}
+		if (v>>uint(i))&1 != 0 {
+			bb.bytes[len(bb.bytes)-1] |= 1 << (7 - uint(bb.n%8))
+		}
+		bb.n++
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,118 @@
+// SPDX-License-Identifier: MIT
+
+package qrcode
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReedSolomon(t *testing.T) {
+	r := require.New(t)
+
+	// HELLO WORLD as 1-M, from the thonky.com tutorial
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	r.Equal([]byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}, rsRemainder(data, 10))
+}
+
+func TestFormatBits(t *testing.T) {
+	r := require.New(t)
+	r.Equal(uint(0x77c4), formatBits(0)) // 111011111000100
+	r.Equal(uint(0x72f3), formatBits(1)) // 111001011110011
+}
+
+func TestEncode(t *testing.T) {
+	r := require.New(t)
+
+	feed := "@ye+QM09iPcDJD6YvQYjoQc7sLF/IFhmNbEqgdzQo3lQ=.ed25519"
+	for _, tc := range []struct {
+		data    string
+		version int
+	}{
+		{"ssb", 1},
+		{feed, 3},
+		{"ssb:feed/ed25519/ye-QM09iPcDJD6YvQYjoQc7sLF_IFhmNbEqgdzQo3lQ=", 4},
+		{strings.Repeat("x", MaxLen), 5},
+	} {
+		c, err := Encode([]byte(tc.data))
+		r.NoError(err, tc.data)
+		r.Equal(tc.version, c.Version, tc.data)
+		r.Equal(17+4*tc.version, c.Size)
+
+		// the finders are in the corners
+		for _, corner := range [][2]int{{0, 0}, {c.Size - 7, 0}, {0, c.Size - 7}} {
+			x, y := corner[0], corner[1]
+			r.True(c.Dark(x, y))
+			r.False(c.Dark(x+1, y+1))
+			r.True(c.Dark(x+3, y+3))
+		}
+
+		// the data reads back
+		want := encodeData([]byte(tc.data), versions[tc.version].data)
+		want = append(want, rsRemainder(want, versions[tc.version].ecc)...)
+		r.Equal(want, readCodewords(c), tc.data)
+	}
+
+	_, err := Encode(bytes.Repeat([]byte("x"), MaxLen+1))
+	r.Equal(ErrTooLong, err)
+}
+
+func TestTerminal(t *testing.T) {
+	r := require.New(t)
+
+	c, err := Encode([]byte("ssb"))
+	r.NoError(err)
+
+	lines := strings.Split(strings.TrimSuffix(c.Terminal(false), "\n"), "\n")
+	r.Len(lines, (c.Size+4+1)/2)
+	for _, l := range lines {
+		r.Equal(c.Size+4, len([]rune(l)))
+	}
+	// quiet zone is light, the top left finder is dark
+	r.Equal("█", string([]rune(lines[0])[0]))
+	r.Equal(" ", string([]rune(lines[1])[2]))
+	r.Equal("█", string([]rune(c.Terminal(true))[2+c.Size+4+1]))
+}
+
+// readCodewords undoes the mask on a copy and reads the modules in the zigzag order
+func readCodewords(c *Code) []byte {
+	cp := newCode(c.Version)
+	cp.drawFunctionPatterns()
+	for y := range c.modules {
+		copy(cp.modules[y], c.modules[y])
+	}
+	cp.applyMask(c.mask)
+
+	var out []byte
+	i := 0
+	for right := cp.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < cp.Size; vert++ {
+			y := vert
+			if upward {
+				y = cp.Size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if cp.function[y][x] {
+					continue
+				}
+				if i%8 == 0 {
+					out = append(out, 0)
+				}
+				if cp.modules[y][x] {
+					out[i/8] |= 1 << (7 - uint(i%8))
+				}
+				i++
+			}
+		}
+	}
+	// drop the remainder bits
+	return out[:i/8]
+}
@@ -0,0 +1,44 @@
+// SPDX-License-Identifier: MIT
+
+package qrcode
+
+// rsRemainder computes the Reed-Solomon error correction codewords for data over GF(256) with the polynomial 0x11d
+func rsRemainder(data []byte, n int) []byte {
+	gen := rsGenerator(n)
+	rem := make([]byte, n)
+	for _, b := range data {
+		factor := b ^ rem[0]
+		copy(rem, rem[1:])
+		rem[n-1] = 0
+		for i := range rem {
+			rem[i] ^= gfMul(gen[i], factor)
+		}
+	}
+	return rem
+}
+
+// rsGenerator returns the coefficients of (x - a^0)(x - a^1)...(x - a^(n-1)) without the leading one, highest first
+func rsGenerator(n int) []byte {
+	gen := make([]byte, n)
+	gen[n-1] = 1
+	root := byte(1)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			gen[j] = gfMul(gen[j], root)
+			if j+1 < n {
+				gen[j] ^= gen[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+	return gen
+}
+
+func gfMul(x, y byte) byte {
+	var z uint
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11d)
+		z ^= ((uint(y) >> uint(i)) & 1) * uint(x)
+	}
+	return byte(z)
+}
@@ -59,3 +59,51 @@ func NewKeyValueWrapper(snk luigi.Sink, wrap bool) luigi.Sink {
 
 	return mfr.SinkFilter(toJSON, noNulled)
 }
+
+// KeyValueRXSeq is the key-value form of a message together with the receive log sequence it has on the server
+type KeyValueRXSeq struct {
+	ssb.KeyValueRaw
+	RXSeq int64 `json:"rxseq"`
+}
+
+// NewKeyValueRXSeqWrapper is like NewKeyValueWrapper with wrapping enabled but it expects margaret.SeqWrapper values
+// and adds their sequence as rxseq to each message.
+func NewKeyValueRXSeqWrapper(snk luigi.Sink) luigi.Sink {
+	noNulled := mfr.FilterFunc(func(ctx context.Context, v interface{}) (bool, error) {
+		if sw, ok := v.(margaret.SeqWrapper); ok {
+			v = sw.Value()
+		}
+		if err, ok := v.(error); ok {
+			if margaret.IsErrNulled(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	})
+	toJSON := mfr.SinkMap(snk, func(ctx context.Context, v interface{}) (interface{}, error) {
+		seqWrap, ok := v.(margaret.SeqWrapper)
+		if !ok {
+			return nil, errors.Errorf("kvwrap: expected a seqWrapper - got %T", v)
+		}
+		sv := seqWrap.Value()
+		abs, ok := sv.(ssb.Message)
+		if !ok {
+			return nil, errors.Errorf("kvwrap: wrong message type in seqWrapper - got %T", sv)
+		}
+
+		var kv KeyValueRXSeq
+		kv.Key_ = abs.Key()
+		kv.Value = *abs.ValueContent()
+		kv.Timestamp = encodedTime.Millisecs(abs.Received())
+		kv.RXSeq = seqWrap.Seq().Seq()
+		kvMsg, err := json.Marshal(kv)
+		if err != nil {
+			return nil, errors.Wrapf(err, "kvwrap: failed to k:v map message")
+		}
+
+		return json.RawMessage(kvMsg), nil
+	})
+
+	return mfr.SinkFilter(toJSON, noNulled)
+}
@@ -0,0 +1,318 @@
+// SPDX-License-Identifier: MIT
+
+// Package websock is a small websocket (RFC 6455) implementation, just enough to run secret-handshake and muxrpc over it.
+//
+// The connections are byte streams: every Write is sent as one binary message
+// and Read returns the payloads of incoming messages without their boundaries.
+// Pings are answered, extensions and subprotocols aren't supported.
+package websock
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotWebsocket is returned by Upgrade for plain HTTP requests
+var ErrNotWebsocket = errors.New("websock: not a websocket upgrade request")
+
+// ErrProtocol is returned when the other side sends frames that break the spec
+var ErrProtocol = errors.New("websock: protocol error")
+
+// from the spec, to prove that the server understood the handshake
+const acceptMagic = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// maxControlPayload is the biggest allowed payload of close, ping and pong frames
+const maxControlPayload = 125
+
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + acceptMagic))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// headerHas checks for token in the comma separated values of header name, ignoring case
+func headerHas(h http.Header, name, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Upgrade answers a websocket upgrade request and takes over its connection.
+// Requests that aren't upgrades get an error response.
+func Upgrade(w http.ResponseWriter, req *http.Request) (net.Conn, error) {
+	if req.Method != http.MethodGet || !headerHas(req.Header, "Connection", "upgrade") || !headerHas(req.Header, "Upgrade", "websocket") {
+		http.Error(w, "expected a websocket upgrade", http.StatusBadRequest)
+		return nil, ErrNotWebsocket
+	}
+	if v := req.Header.Get("Sec-WebSocket-Version"); v != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, errors.Errorf("websock: unsupported version %q", v)
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing websocket key", http.StatusBadRequest)
+		return nil, errors.New("websock: missing Sec-WebSocket-Key")
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, errors.Errorf("websock: can't take over connection of %T", w)
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, errors.Wrap(err, "websock: failed to take over connection")
+	}
+
+	fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", acceptKey(key))
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "websock: failed to send handshake response")
+	}
+	return newConn(conn, brw.Reader, false), nil
+}
+
+// Client does the opening handshake for u on an established connection, which is TLS for wss.
+func Client(conn net.Conn, u *url.URL) (net.Conn, error) {
+	var nonce [16]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, errors.Wrap(err, "websock: failed to make key")
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	_, err := fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n",
+		u.RequestURI(), u.Host, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "websock: failed to send handshake")
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodGet})
+	if err != nil {
+		return nil, errors.Wrap(err, "websock: failed to read handshake response")
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, errors.Errorf("websock: server refused upgrade: %s", resp.Status)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		return nil, errors.New("websock: server sent wrong accept key")
+	}
+	return newConn(conn, br, true), nil
+}
+
+// conn keeps the addresses and deadlines of the underlying connection
+type conn struct {
+	net.Conn
+
+	br *bufio.Reader
+
+	// clients mask what they send, servers only accept masked frames
+	client bool
+
+	rmu       sync.Mutex
+	remaining uint64 // of the current data frame
+	masked    bool
+	mask      [4]byte
+	maskPos   int
+	readErr   error
+
+	wmu       sync.Mutex
+	closeOnce sync.Once
+}
+
+func newConn(c net.Conn, br *bufio.Reader, client bool) *conn {
+	return &conn{Conn: c, br: br, client: client}
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	for c.remaining == 0 {
+		if c.readErr != nil {
+			return 0, c.readErr
+		}
+		if err := c.nextFrame(); err != nil {
+			c.readErr = err
+			return 0, err
+		}
+	}
+
+	if uint64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.br.Read(p)
+	if c.masked {
+		for i := range p[:n] {
+			p[i] ^= c.mask[c.maskPos%4]
+			c.maskPos++
+		}
+	}
+	c.remaining -= uint64(n)
+	if err == io.EOF && c.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// nextFrame reads frame headers and handles control frames until a data frame starts
+func (c *conn) nextFrame() error {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		return err
+	}
+	fin := hdr[0]&0x80 != 0
+	if hdr[0]&0x70 != 0 {
+		return errors.Wrap(ErrProtocol, "reserved bits set")
+	}
+	op := hdr[0] & 0x0f
+	masked := hdr[1]&0x80 != 0
+	if masked == c.client {
+		return errors.Wrapf(ErrProtocol, "unexpected masking (%v)", masked)
+	}
+
+	length := uint64(hdr[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return err
+		}
+	}
+
+	switch op {
+	case opContinuation, opText, opBinary:
+		c.remaining = length
+		c.masked = masked
+		c.mask = mask
+		c.maskPos = 0
+		return nil
+	case opClose, opPing, opPong:
+	default:
+		return errors.Wrapf(ErrProtocol, "unknown opcode %d", op)
+	}
+
+	if !fin || length > maxControlPayload {
+		return errors.Wrap(ErrProtocol, "fragmented or oversized control frame")
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+
+	switch op {
+	case opPing:
+		return c.writeFrame(opPong, payload)
+	case opClose:
+		// echo the status code and hang up
+		if len(payload) > 2 {
+			payload = payload[:2]
+		}
+		c.closeOnce.Do(func() {
+			c.writeFrame(opClose, payload)
+		})
+		return io.EOF
+	}
+	return nil // pong
+}
+
+func (c *conn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(opBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *conn) writeFrame(op byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|op)
+
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, maskBit|126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, maskBit|127)
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(n))
+		frame = append(frame, ext[:]...)
+	}
+
+	if !c.client {
+		frame = append(frame, payload...)
+	} else {
+		var mask [4]byte
+		if _, err := io.ReadFull(rand.Reader, mask[:]); err != nil {
+			return errors.Wrap(err, "websock: failed to make mask")
+		}
+		frame = append(frame, mask[:]...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i%4])
+		}
+	}
+
+	_, err := c.Conn.Write(frame)
+	return err
+}
+
+// Close sends a close frame with normal closure and closes the connection
+func (c *conn) Close() error {
+	c.closeOnce.Do(func() {
+		c.writeFrame(opClose, []byte{0x03, 0xe8}) // 1000
+	})
+	return c.Conn.Close()
+}
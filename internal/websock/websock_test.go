@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: MIT
+
+package websock
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// the example from the spec
+	require.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", acceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func TestEcho(t *testing.T) {
+	r := require.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c, err := Upgrade(w, req)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(c, c)
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	r.NoError(err)
+	r.Equal(http.StatusBadRequest, resp.StatusCode)
+	resp.Body.Close()
+
+	u, err := url.Parse(srv.URL)
+	r.NoError(err)
+	u.Scheme = "ws"
+	tcp, err := net.Dial("tcp", u.Host)
+	r.NoError(err)
+	c, err := Client(tcp, u)
+	r.NoError(err)
+
+	for _, size := range []int{0, 1, 125, 126, 4096, 70000} {
+		msg := bytes.Repeat([]byte{byte(size)}, size)
+		_, err = c.Write(msg)
+		r.NoError(err)
+		got := make([]byte, size)
+		_, err = io.ReadFull(c, got)
+		r.NoError(err, "size %d", size)
+		r.Equal(msg, got, "size %d", size)
+	}
+
+	// the server answers pings on its own, the client drops the pong
+	r.NoError(c.(*conn).writeFrame(opPing, []byte("hello")))
+	_, err = c.Write([]byte("after ping"))
+	r.NoError(err)
+	got := make([]byte, 10)
+	_, err = io.ReadFull(c, got)
+	r.NoError(err)
+	r.Equal("after ping", string(got))
+
+	// closing ends the echo loop, which closes from the other side
+	r.NoError(c.(*conn).writeFrame(opClose, []byte{0x03, 0xe8}))
+	_, err = c.Read(got)
+	r.Equal(io.EOF, err)
+	c.Close()
+}
+
+func TestUnmaskedFromClient(t *testing.T) {
+	r := require.New(t)
+
+	srvConn, cliConn := net.Pipe()
+	srv := newConn(srvConn, bufio.NewReader(srvConn), false)
+
+	go func() {
+		// a client pretending to be a server doesn't mask
+		bad := newConn(cliConn, bufio.NewReader(cliConn), false)
+		bad.Write([]byte("nope"))
+	}()
+
+	_, err := srv.Read(make([]byte, 4))
+	r.Error(err)
+	r.Contains(err.Error(), "masking")
+	srvConn.Close()
+	cliConn.Close()
+}
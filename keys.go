@@ -3,15 +3,16 @@
 package ssb
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
 
-	"github.com/keks/nocomment"
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/secretstream/secrethandshake"
 )
@@ -104,18 +105,24 @@ func LoadKeyPair(fname string) (*KeyPair, error) {
 	if err != nil {
 		return nil, errors.Wrapf(err, "ssb.LoadKeyPair: could not stat key file %s", fname)
 	}
-	if perms := info.Mode().Perm(); perms != SecretPerms {
+	if perms := info.Mode().Perm(); !secretPermsOK(perms) {
 		return nil, fmt.Errorf("ssb.LoadKeyPair: expected key file permissions %s, but got %s", SecretPerms, perms)
 	}
 
-	return ParseKeyPair(nocomment.NewReader(f))
+	return ParseKeyPair(f)
 }
 
 // ParseKeyPair json decodes an object from the reader.
 // It expects std base64 encoded data under the `private` and `public` fields.
+// Lines starting with # are skipped, like the comments around the JSON in the secret files of the JS implementation.
 func ParseKeyPair(r io.Reader) (*KeyPair, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "ssb.Parse: failed to read secret")
+	}
+
 	var s ssbSecret
-	if err := json.NewDecoder(r).Decode(&s); err != nil {
+	if err := json.NewDecoder(bytes.NewReader(stripSecretComments(data))).Decode(&s); err != nil {
 		return nil, errors.Wrapf(err, "ssb.Parse: JSON decoding failed")
 	}
 
@@ -144,3 +151,15 @@ func ParseKeyPair(r io.Reader) (*KeyPair, error) {
 	}
 	return &ssbkp, errors.Wrap(err, "ssb.Parse: broken keypair?")
 }
+
+// stripSecretComments blanks out the lines that start with #, leading whitespace and windows line endings are fine.
+// The lines are kept so that the offsets in JSON errors still match the file.
+func stripSecretComments(data []byte) []byte {
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		if bytes.HasPrefix(bytes.TrimLeft(line, " \t"), []byte("#")) {
+			lines[i] = nil
+		}
+	}
+	return bytes.Join(lines, []byte("\n"))
+}
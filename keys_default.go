@@ -7,3 +7,9 @@ import "os"
 // SecretPerms are the file permissions for holding SSB secrets.
 // We expect the file to only be accessable by the owner.
 var SecretPerms = os.FileMode(0600)
+
+// secretPermsOK checks that only the owner can access the secret.
+// The JS implementation writes it read-only (0400), which is fine, too.
+func secretPermsOK(perms os.FileMode) bool {
+	return perms&0077 == 0
+}
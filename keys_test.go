@@ -1,8 +1,11 @@
 package ssb
 
 import (
+	"bytes"
+	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -62,3 +65,32 @@ func TestLoadKeyPair(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadKeyPairJSFormat(t *testing.T) {
+	r := require.New(t)
+
+	fixture, err := ioutil.ReadFile(filepath.Join("testdata", "secret_js"))
+	r.NoError(err)
+	const wantID = "@Z9VZfAWEFjNyo2SfuPu6dkbarqalYELwARCE4nKXyY0=.ed25519"
+
+	kp, err := ParseKeyPair(bytes.NewReader(fixture))
+	r.NoError(err)
+	r.Equal(wantID, kp.Id.Ref())
+
+	// windows line endings and indented comments
+	crlf := bytes.Replace(fixture, []byte("\n"), []byte("\r\n"), -1)
+	crlf = bytes.Replace(crlf, []byte("# NEVER"), []byte("  # NEVER"), 1)
+	kp, err = ParseKeyPair(bytes.NewReader(crlf))
+	r.NoError(err)
+	r.Equal(wantID, kp.Id.Ref())
+
+	// the JS implementation writes the file read-only
+	fname := path.Join(os.TempDir(), "secret_js")
+	r.NoError(ioutil.WriteFile(fname, fixture, 0400))
+	defer os.Remove(fname)
+	r.NoError(os.Chmod(fname, 0400))
+
+	kp, err = LoadKeyPair(fname)
+	r.NoError(err)
+	r.Equal(wantID, kp.Id.Ref())
+}
@@ -9,3 +9,8 @@ import (
 // SecretPerms are the file permissions for holding SSB secrets.
 // Windows has it's own permission system apart from UNIX (owner, group, others)
 var SecretPerms = os.FileMode(0666)
+
+// secretPermsOK accepts SecretPerms and read-only files
+func secretPermsOK(perms os.FileMode) bool {
+	return perms == SecretPerms || perms == 0444
+}
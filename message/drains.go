@@ -118,6 +118,23 @@ func (ld *streamDrain) Pour(ctx context.Context, v interface{}) error {
 		return errors.Wrapf(err, "muxDrain(%s:%d) verify failed", ld.who.ShortRef(), ld.latestSeq.Seq())
 	}
 
+	if ld.latestMsg != nil && ld.latestMsg.Author().Equal(next.Author()) {
+		switch next.Seq() {
+		case ld.latestMsg.Seq():
+			// the peer sent the newest message we have, which is fine as long as it's the same one
+			if !next.Key().Equal(*ld.latestMsg.Key()) {
+				return ld.forked(next.Key())
+			}
+			return nil
+
+		case ld.latestMsg.Seq() + 1:
+			// the peer's chain doesn't continue ours
+			if prev := next.Previous(); prev != nil && !prev.Equal(*ld.latestMsg.Key()) {
+				return ld.forked(prev)
+			}
+		}
+	}
+
 	err = ValidateNext(ld.latestMsg, next)
 	if err != nil {
 		return err
@@ -133,6 +150,16 @@ func (ld *streamDrain) Pour(ctx context.Context, v interface{}) error {
 	return nil
 }
 
+// forked returns the error for a peer that has remoteKey where we have our latest message
+func (ld *streamDrain) forked(remoteKey *ssb.MessageRef) error {
+	return ssb.ErrForkDetected{
+		Feed:      ld.who,
+		Seq:       ld.latestMsg.Seq(),
+		LocalKey:  ld.latestMsg.Key(),
+		RemoteKey: remoteKey,
+	}
+}
+
 func (ld streamDrain) Close() error { return ld.storage.Close() }
 
 // ValidateNext checks the author stays the same across the feed,
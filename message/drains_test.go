@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: MIT
+
+package message
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/message/legacy"
+)
+
+func TestVerifySinkDetectsFork(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	kp, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+
+	// sign returns the message with seq after prev and its raw JSON
+	sign := func(prev *legacy.StoredMessage, seq int, text string) (*legacy.StoredMessage, json.RawMessage) {
+		lm := legacy.LegacyMessage{
+			Author:    kp.Id.Ref(),
+			Sequence:  margaret.BaseSeq(seq),
+			Timestamp: int64(seq),
+			Hash:      "sha256",
+			Content:   map[string]interface{}{"type": "test", "text": text},
+		}
+		if prev != nil {
+			lm.Previous = prev.Key_
+		}
+		ref, raw, err := lm.Sign(kp.Pair.Secret[:], nil)
+		r.NoError(err)
+		return &legacy.StoredMessage{
+			Author_:   kp.Id,
+			Previous_: lm.Previous,
+			Key_:      ref,
+			Sequence_: margaret.BaseSeq(seq),
+			Raw_:      raw,
+		}, raw
+	}
+
+	one, _ := sign(nil, 1, "one")
+	two, rawTwo := sign(one, 2, "two")
+	three, rawThree := sign(two, 3, "three")
+
+	// someone published another second message with the same key
+	otherTwo, rawOtherTwo := sign(one, 2, "other two")
+	_, rawOtherThree := sign(otherTwo, 3, "other three")
+
+	var stored []ssb.Message
+	store := luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error {
+		if err != nil {
+			return err
+		}
+		stored = append(stored, v.(ssb.Message))
+		return nil
+	})
+	newSink := func() luigi.Sink {
+		stored = nil
+		return NewVerifySink(kp.Id, two.Seq(), two, store, nil)
+	}
+
+	// a message that doesn't continue our chain
+	err = newSink().Pour(ctx, rawOtherThree)
+	fork, ok := ssb.IsForkDetected(err)
+	r.True(ok, "expected a fork, got %v", err)
+	r.EqualValues(2, fork.Seq)
+	r.True(fork.LocalKey.Equal(*two.Key_))
+	r.True(fork.RemoteKey.Equal(*otherTwo.Key_))
+	r.Len(stored, 0)
+
+	// a different message at the sequence we have
+	snk := newSink()
+	err = snk.Pour(ctx, rawOtherTwo)
+	fork, ok = ssb.IsForkDetected(err)
+	r.True(ok, "expected a fork, got %v", err)
+	r.EqualValues(2, fork.Seq)
+	r.True(fork.RemoteKey.Equal(*otherTwo.Key_))
+	r.Len(stored, 0)
+
+	// the same message we have is skipped and the chain continues
+	snk = newSink()
+	r.NoError(snk.Pour(ctx, rawTwo))
+	r.NoError(snk.Pour(ctx, rawThree))
+	r.Len(stored, 1)
+	r.True(stored[0].Key().Equal(*three.Key_))
+}
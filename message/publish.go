@@ -6,7 +6,6 @@ import (
 	"bytes"
 	"encoding/base64"
 	"fmt"
-	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -20,9 +19,11 @@ import (
 )
 
 type publishLog struct {
-	sync.Mutex
 	margaret.Log
 	rootLog margaret.Log
+	author  *ssb.FeedRef
+
+	head feedHead
 
 	create creater
 }
@@ -71,8 +72,8 @@ TODO: do the same for Query()? but how?
 => just overwrite publish on the authorLog for now
 */
 func (pl *publishLog) Append(val interface{}) (margaret.Seq, error) {
-	pl.Lock()
-	defer pl.Unlock()
+	pl.head.Lock()
+	defer pl.head.Unlock()
 
 	// current state of the local sig-chain
 	var (
@@ -109,6 +110,11 @@ func (pl *publishLog) Append(val interface{}) (margaret.Seq, error) {
 		nextSequence = margaret.BaseSeq(mm.Seq() + 1)
 	}
 
+	// the sublog might not have seen the last message that was published
+	if err := pl.head.check(pl.rootLog, pl.author, nextSequence.Seq()); err != nil {
+		return nil, err
+	}
+
 	nextMsg, err := pl.create.Create(val, nextPrevious, nextSequence)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create next msg")
@@ -118,6 +124,7 @@ func (pl *publishLog) Append(val interface{}) (margaret.Seq, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to append new msg")
 	}
+	pl.head.advance(rlSeq)
 
 	return rlSeq, nil
 }
@@ -128,6 +135,10 @@ func (pl *publishLog) Append(val interface{}) (margaret.Seq, error) {
 // these messages are constructed in the legacy SSB way: The poured object is JSON v8-like pretty printed and then NaCL signed,
 // then it's pretty printed again (now with the signature inside the message) to construct it's SHA256 hash,
 // which is used to reference it (by replys and it's previous)
+//
+// The appends of the returned log are serialized. Open it once per keypair and share it,
+// two publish logs for the same feed don't know about each other's messages.
+// If the author sublog lags behind what was already published, Append returns ErrConcurrentPublish.
 func OpenPublishLog(rootLog margaret.Log, sublogs multilog.MultiLog, kp *ssb.KeyPair, opts ...PublishOption) (ssb.Publisher, error) {
 
 	if sublogs == nil {
@@ -142,6 +153,7 @@ func OpenPublishLog(rootLog margaret.Log, sublogs multilog.MultiLog, kp *ssb.Key
 	pl := &publishLog{
 		Log:     authorLog,
 		rootLog: rootLog,
+		author:  kp.Id,
 	}
 
 	switch kp.Id.Algo {
@@ -151,7 +163,8 @@ func OpenPublishLog(rootLog margaret.Log, sublogs multilog.MultiLog, kp *ssb.Key
 		}
 	case ssb.RefAlgoFeedGabby:
 		pl.create = &gabbyCreate{
-			enc: gabbygrove.NewEncoder(kp),
+			author: kp.Id,
+			enc:    gabbygrove.NewEncoder(kp),
 		}
 	default:
 		return nil, errors.Errorf("publish: unsupported feed algorithm: %s", kp.Id.Algo)
@@ -202,6 +215,12 @@ func UseNowTimestamps(yes bool) PublishOption {
 	}
 }
 
+// ContentBoxer is private content that can only be encrypted once its author and previous message are known, like box2.
+// BoxContent returns the ciphertext with a box2: prefix.
+type ContentBoxer interface {
+	BoxContent(author *ssb.FeedRef, prev *ssb.MessageRef) ([]byte, error)
+}
+
 type creater interface {
 	Create(val interface{}, prev *ssb.MessageRef, seq margaret.Seq) (ssb.Message, error)
 }
@@ -225,9 +244,22 @@ func (lc legacyCreate) Create(val interface{}, prev *ssb.MessageRef, seq margare
 	newMsg.Previous = prev
 	newMsg.Sequence = margaret.BaseSeq(seq.Seq())
 
+	if cb, ok := val.(ContentBoxer); ok {
+		boxed, err := cb.BoxContent(lc.key.Id, prev)
+		if err != nil {
+			return nil, errors.Wrap(err, "publish: failed to box content")
+		}
+		val = boxed
+	}
+
 	if bindata, ok := val.([]byte); ok {
-		bindata = bytes.TrimPrefix(bindata, []byte("box1:"))
-		newMsg.Content = base64.StdEncoding.EncodeToString(bindata) + ".box"
+		if bytes.HasPrefix(bindata, []byte("box2:")) {
+			bindata = bytes.TrimPrefix(bindata, []byte("box2:"))
+			newMsg.Content = base64.StdEncoding.EncodeToString(bindata) + ".box2"
+		} else {
+			bindata = bytes.TrimPrefix(bindata, []byte("box1:"))
+			newMsg.Content = base64.StdEncoding.EncodeToString(bindata) + ".box"
+		}
 	} else {
 		newMsg.Content = val
 	}
@@ -249,7 +281,8 @@ func (lc legacyCreate) Create(val interface{}, prev *ssb.MessageRef, seq margare
 }
 
 type gabbyCreate struct {
-	enc *gabbygrove.Encoder
+	author *ssb.FeedRef
+	enc    *gabbygrove.Encoder
 }
 
 func (pc gabbyCreate) Create(val interface{}, prev *ssb.MessageRef, seq margaret.Seq) (ssb.Message, error) {
@@ -261,6 +294,13 @@ func (pc gabbyCreate) Create(val interface{}, prev *ssb.MessageRef, seq margaret
 			return nil, err
 		}
 	}
+	if cb, ok := val.(ContentBoxer); ok {
+		boxed, err := cb.BoxContent(pc.author, prev)
+		if err != nil {
+			return nil, errors.Wrap(err, "gabby: failed to box content")
+		}
+		val = boxed
+	}
 	nextSeq := uint64(seq.Seq())
 	tr, _, err := pc.enc.Encode(nextSeq, br, val)
 	if err != nil {
@@ -0,0 +1,59 @@
+// SPDX-License-Identifier: MIT
+
+package message
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/margaret"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// ErrConcurrentPublish is returned by the publish log if the head of the feed moved while the next message was created.
+// Nothing was appended, the caller can retry with the fresh state.
+var ErrConcurrentPublish = errors.New("publish: feed head changed concurrently, retry")
+
+// feedHead serializes the appends of a publish log.
+// It remembers where the last message it appended is stored,
+// so that an author sublog which didn't see it yet can't lead to a second message with the same sequence.
+type feedHead struct {
+	sync.Mutex
+
+	rootSeq margaret.Seq // nil if nothing was published through it
+}
+
+// check returns ErrConcurrentPublish if nextSeq doesn't follow the last message of author that was published,
+// as it is stored in rootLog. The caller needs to hold the lock.
+func (h *feedHead) check(rootLog margaret.Log, author *ssb.FeedRef, nextSeq int64) error {
+	if h.rootSeq == nil {
+		return nil
+	}
+
+	v, err := rootLog.Get(h.rootSeq)
+	if margaret.IsErrNulled(err) {
+		// the feed was truncated (fsck) since then
+		h.rootSeq = nil
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "publish: failed to get the last published message")
+	}
+
+	msg, ok := v.(ssb.Message)
+	if !ok || !msg.Author().Equal(author) {
+		// not ours anymore, the log was replaced
+		h.rootSeq = nil
+		return nil
+	}
+
+	if nextSeq > msg.Seq() {
+		return nil
+	}
+	return errors.Wrapf(ErrConcurrentPublish, "wanted to publish %d but %d is already published", nextSeq, msg.Seq())
+}
+
+// advance records where a newly published message was stored. The caller needs to hold the lock.
+func (h *feedHead) advance(rootSeq margaret.Seq) {
+	h.rootSeq = rootSeq
+}
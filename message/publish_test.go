@@ -105,3 +105,71 @@ func TestSignMessages(t *testing.T) {
 
 	}
 }
+
+func TestPublishConcurrent(t *testing.T) {
+	r := require.New(t)
+
+	rpath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(rpath)
+
+	testRepo := repo.New(rpath)
+	rl, err := repo.OpenLog(testRepo)
+	r.NoError(err, "failed to open root log")
+
+	userFeeds, userFeedsServe, err := multilogs.OpenUserFeeds(testRepo)
+	r.NoError(err, "failed to get user feeds multilog")
+
+	killServe, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	go userFeedsServe(killServe, rl, true)
+
+	testAuthor, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+
+	const (
+		workers = 16
+		each    = 10
+	)
+
+	// like the publishers of the bot, all workers share one publish log
+	pl, err := OpenPublishLog(rl, userFeeds, testAuthor)
+	r.NoError(err)
+
+	errc := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		go func(w int) {
+			var err error
+			for i := 0; i < each; i++ {
+				for {
+					_, err = pl.Publish(map[string]interface{}{"type": "test", "worker": w, "i": i})
+					if errors.Cause(err) != ErrConcurrentPublish {
+						break
+					}
+				}
+				if err != nil {
+					errc <- errors.Wrapf(err, "worker %d failed to publish %d", w, i)
+					return
+				}
+			}
+			errc <- nil
+		}(w)
+	}
+	for w := 0; w < workers; w++ {
+		r.NoError(<-errc)
+	}
+
+	sv, err := rl.Seq().Value()
+	r.NoError(err)
+	r.EqualValues(workers*each-1, sv.(margaret.Seq).Seq())
+
+	// every message follows the one before it
+	var prev ssb.Message
+	for i := int64(0); i < workers*each; i++ {
+		v, err := rl.Get(margaret.BaseSeq(i))
+		r.NoError(err)
+		msg, ok := v.(ssb.Message)
+		r.True(ok, "wrong type: %T", v)
+		r.NoError(ValidateNext(prev, msg), "forked at %d", i)
+		prev = msg
+	}
+}
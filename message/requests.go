@@ -105,12 +105,42 @@ type CreateHistArgs struct {
 	AsJSON bool `json:"asJSON,omitempty"`
 }
 
+// CreateUserStreamArgs defines the query parameters for the createUserStream rpc call.
+// Unlike createHistoryStream, which is what peers use to replicate, it's meant for clients:
+// Gt and Lt bound the feed sequence from both sides and,
+// if the server has private messages enabled, Private asks it to include decrypted messages.
+type CreateUserStreamArgs struct {
+	CommonArgs
+	StreamArgs
+
+	ID *ssb.FeedRef `json:"id"`
+
+	Gt int64 `json:"gt,omitempty"`
+	Lt int64 `json:"lt,omitempty"`
+
+	Private bool `json:"private,omitempty"`
+}
+
 // CreateLogArgs defines the query parameters for the createLogStream rpc call
 type CreateLogArgs struct {
 	CommonArgs
 	StreamArgs
 
 	Seq int64 `json:"seq"`
+
+	// RXSeq adds the receive log sequence of each message as rxseq.
+	// It implies Keys, since the plain values have no room for it.
+	RXSeq bool `json:"rxseq,omitempty"`
+}
+
+// CreateFeedArgs defines the query parameters for the createFeedStream rpc call.
+// Gt and Lt bound the claimed timestamps of the messages, in milliseconds since the epoch.
+type CreateFeedArgs struct {
+	CommonArgs
+	StreamArgs
+
+	Gt float64 `json:"gt,omitempty"`
+	Lt float64 `json:"lt,omitempty"`
 }
 
 // MessagesByTypeArgs defines the query parameters for the messagesByType rpc call
@@ -124,3 +154,15 @@ type TanglesArgs struct {
 	StreamArgs
 	Root ssb.MessageRef `json:"root"`
 }
+
+// BacklinksArgs defines the query parameters for the backlinks.read rpc call
+type BacklinksArgs struct {
+	CommonArgs
+	StreamArgs
+
+	// Dest is the message, blob or feed reference the returned messages link to
+	Dest string `json:"dest"`
+
+	// Type optionally only returns linking messages of this type
+	Type string `json:"type,omitempty"`
+}
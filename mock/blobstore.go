@@ -54,6 +54,16 @@ type FakeBlobStore struct {
 	listReturnsOnCall map[int]struct {
 		result1 luigi.Source
 	}
+	ListMetaStub        func() luigi.Source
+	listMetaMutex       sync.RWMutex
+	listMetaArgsForCall []struct {
+	}
+	listMetaReturns struct {
+		result1 luigi.Source
+	}
+	listMetaReturnsOnCall map[int]struct {
+		result1 luigi.Source
+	}
 	PutStub        func(io.Reader) (*ssb.BlobRef, error)
 	putMutex       sync.RWMutex
 	putArgsForCall []struct {
@@ -67,6 +77,18 @@ type FakeBlobStore struct {
 		result1 *ssb.BlobRef
 		result2 error
 	}
+	PutExpectedStub        func(io.Reader, *ssb.BlobRef) error
+	putExpectedMutex       sync.RWMutex
+	putExpectedArgsForCall []struct {
+		arg1 io.Reader
+		arg2 *ssb.BlobRef
+	}
+	putExpectedReturns struct {
+		result1 error
+	}
+	putExpectedReturnsOnCall map[int]struct {
+		result1 error
+	}
 	SizeStub        func(*ssb.BlobRef) (int64, error)
 	sizeMutex       sync.RWMutex
 	sizeArgsForCall []struct {
@@ -80,6 +102,18 @@ type FakeBlobStore struct {
 		result1 int64
 		result2 error
 	}
+	StatsStub        func() (ssb.BlobStoreStats, error)
+	statsMutex       sync.RWMutex
+	statsArgsForCall []struct {
+	}
+	statsReturns struct {
+		result1 ssb.BlobStoreStats
+		result2 error
+	}
+	statsReturnsOnCall map[int]struct {
+		result1 ssb.BlobStoreStats
+		result2 error
+	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
@@ -279,6 +313,8 @@ func (fake *FakeBlobStore) List() luigi.Source {
 func (fake *FakeBlobStore) ListCallCount() int {
 	fake.listMutex.RLock()
 	defer fake.listMutex.RUnlock()
+	fake.listMetaMutex.RLock()
+	defer fake.listMetaMutex.RUnlock()
 	return len(fake.listArgsForCall)
 }
 
@@ -311,6 +347,58 @@ func (fake *FakeBlobStore) ListReturnsOnCall(i int, result1 luigi.Source) {
 	}{result1}
 }
 
+func (fake *FakeBlobStore) ListMeta() luigi.Source {
+	fake.listMetaMutex.Lock()
+	ret, specificReturn := fake.listMetaReturnsOnCall[len(fake.listMetaArgsForCall)]
+	fake.listMetaArgsForCall = append(fake.listMetaArgsForCall, struct {
+	}{})
+	fake.recordInvocation("ListMeta", []interface{}{})
+	fake.listMetaMutex.Unlock()
+	if fake.ListMetaStub != nil {
+		return fake.ListMetaStub()
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	fakeReturns := fake.listMetaReturns
+	return fakeReturns.result1
+}
+
+func (fake *FakeBlobStore) ListMetaCallCount() int {
+	fake.listMetaMutex.RLock()
+	defer fake.listMetaMutex.RUnlock()
+	return len(fake.listMetaArgsForCall)
+}
+
+func (fake *FakeBlobStore) ListMetaCalls(stub func() luigi.Source) {
+	fake.listMetaMutex.Lock()
+	defer fake.listMetaMutex.Unlock()
+	fake.ListMetaStub = stub
+}
+
+func (fake *FakeBlobStore) ListMetaReturns(result1 luigi.Source) {
+	fake.listMetaMutex.Lock()
+	defer fake.listMetaMutex.Unlock()
+	fake.ListMetaStub = nil
+	fake.listMetaReturns = struct {
+		result1 luigi.Source
+	}{result1}
+}
+
+func (fake *FakeBlobStore) ListMetaReturnsOnCall(i int, result1 luigi.Source) {
+	fake.listMetaMutex.Lock()
+	defer fake.listMetaMutex.Unlock()
+	fake.ListMetaStub = nil
+	if fake.listMetaReturnsOnCall == nil {
+		fake.listMetaReturnsOnCall = make(map[int]struct {
+			result1 luigi.Source
+		})
+	}
+	fake.listMetaReturnsOnCall[i] = struct {
+		result1 luigi.Source
+	}{result1}
+}
+
 func (fake *FakeBlobStore) Put(arg1 io.Reader) (*ssb.BlobRef, error) {
 	fake.putMutex.Lock()
 	ret, specificReturn := fake.putReturnsOnCall[len(fake.putArgsForCall)]
@@ -374,6 +462,67 @@ func (fake *FakeBlobStore) PutReturnsOnCall(i int, result1 *ssb.BlobRef, result2
 	}{result1, result2}
 }
 
+func (fake *FakeBlobStore) PutExpected(arg1 io.Reader, arg2 *ssb.BlobRef) error {
+	fake.putExpectedMutex.Lock()
+	ret, specificReturn := fake.putExpectedReturnsOnCall[len(fake.putExpectedArgsForCall)]
+	fake.putExpectedArgsForCall = append(fake.putExpectedArgsForCall, struct {
+		arg1 io.Reader
+		arg2 *ssb.BlobRef
+	}{arg1, arg2})
+	fake.recordInvocation("PutExpected", []interface{}{arg1, arg2})
+	fake.putExpectedMutex.Unlock()
+	if fake.PutExpectedStub != nil {
+		return fake.PutExpectedStub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	fakeReturns := fake.putExpectedReturns
+	return fakeReturns.result1
+}
+
+func (fake *FakeBlobStore) PutExpectedCallCount() int {
+	fake.putExpectedMutex.RLock()
+	defer fake.putExpectedMutex.RUnlock()
+	return len(fake.putExpectedArgsForCall)
+}
+
+func (fake *FakeBlobStore) PutExpectedCalls(stub func(io.Reader, *ssb.BlobRef) error) {
+	fake.putExpectedMutex.Lock()
+	defer fake.putExpectedMutex.Unlock()
+	fake.PutExpectedStub = stub
+}
+
+func (fake *FakeBlobStore) PutExpectedArgsForCall(i int) (io.Reader, *ssb.BlobRef) {
+	fake.putExpectedMutex.RLock()
+	defer fake.putExpectedMutex.RUnlock()
+	argsForCall := fake.putExpectedArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeBlobStore) PutExpectedReturns(result1 error) {
+	fake.putExpectedMutex.Lock()
+	defer fake.putExpectedMutex.Unlock()
+	fake.PutExpectedStub = nil
+	fake.putExpectedReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeBlobStore) PutExpectedReturnsOnCall(i int, result1 error) {
+	fake.putExpectedMutex.Lock()
+	defer fake.putExpectedMutex.Unlock()
+	fake.PutExpectedStub = nil
+	if fake.putExpectedReturnsOnCall == nil {
+		fake.putExpectedReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.putExpectedReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *FakeBlobStore) Size(arg1 *ssb.BlobRef) (int64, error) {
 	fake.sizeMutex.Lock()
 	ret, specificReturn := fake.sizeReturnsOnCall[len(fake.sizeArgsForCall)]
@@ -437,6 +586,61 @@ func (fake *FakeBlobStore) SizeReturnsOnCall(i int, result1 int64, result2 error
 	}{result1, result2}
 }
 
+func (fake *FakeBlobStore) Stats() (ssb.BlobStoreStats, error) {
+	fake.statsMutex.Lock()
+	ret, specificReturn := fake.statsReturnsOnCall[len(fake.statsArgsForCall)]
+	fake.statsArgsForCall = append(fake.statsArgsForCall, struct {
+	}{})
+	fake.recordInvocation("Stats", []interface{}{})
+	fake.statsMutex.Unlock()
+	if fake.StatsStub != nil {
+		return fake.StatsStub()
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	fakeReturns := fake.statsReturns
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeBlobStore) StatsCallCount() int {
+	fake.statsMutex.RLock()
+	defer fake.statsMutex.RUnlock()
+	return len(fake.statsArgsForCall)
+}
+
+func (fake *FakeBlobStore) StatsCalls(stub func() (ssb.BlobStoreStats, error)) {
+	fake.statsMutex.Lock()
+	defer fake.statsMutex.Unlock()
+	fake.StatsStub = stub
+}
+
+func (fake *FakeBlobStore) StatsReturns(result1 ssb.BlobStoreStats, result2 error) {
+	fake.statsMutex.Lock()
+	defer fake.statsMutex.Unlock()
+	fake.StatsStub = nil
+	fake.statsReturns = struct {
+		result1 ssb.BlobStoreStats
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeBlobStore) StatsReturnsOnCall(i int, result1 ssb.BlobStoreStats, result2 error) {
+	fake.statsMutex.Lock()
+	defer fake.statsMutex.Unlock()
+	fake.StatsStub = nil
+	if fake.statsReturnsOnCall == nil {
+		fake.statsReturnsOnCall = make(map[int]struct {
+			result1 ssb.BlobStoreStats
+			result2 error
+		})
+	}
+	fake.statsReturnsOnCall[i] = struct {
+		result1 ssb.BlobStoreStats
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeBlobStore) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
@@ -448,10 +652,16 @@ func (fake *FakeBlobStore) Invocations() map[string][][]interface{} {
 	defer fake.getMutex.RUnlock()
 	fake.listMutex.RLock()
 	defer fake.listMutex.RUnlock()
+	fake.listMetaMutex.RLock()
+	defer fake.listMetaMutex.RUnlock()
 	fake.putMutex.RLock()
 	defer fake.putMutex.RUnlock()
+	fake.putExpectedMutex.RLock()
+	defer fake.putExpectedMutex.RUnlock()
 	fake.sizeMutex.RLock()
 	defer fake.sizeMutex.RUnlock()
+	fake.statsMutex.RLock()
+	defer fake.statsMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
@@ -21,6 +21,20 @@ type FakeWantManager struct {
 	allWantsReturnsOnCall map[int]struct {
 		result1 []ssb.BlobWant
 	}
+	AnnounceStub        func(*ssb.BlobRef, func(*ssb.FeedRef) bool) (int, error)
+	announceMutex       sync.RWMutex
+	announceArgsForCall []struct {
+		arg1 *ssb.BlobRef
+		arg2 func(*ssb.FeedRef) bool
+	}
+	announceReturns struct {
+		result1 int
+		result2 error
+	}
+	announceReturnsOnCall map[int]struct {
+		result1 int
+		result2 error
+	}
 	CloseStub        func() error
 	closeMutex       sync.RWMutex
 	closeArgsForCall []struct {
@@ -145,6 +159,70 @@ func (fake *FakeWantManager) AllWantsReturnsOnCall(i int, result1 []ssb.BlobWant
 	}{result1}
 }
 
+func (fake *FakeWantManager) Announce(arg1 *ssb.BlobRef, arg2 func(*ssb.FeedRef) bool) (int, error) {
+	fake.announceMutex.Lock()
+	ret, specificReturn := fake.announceReturnsOnCall[len(fake.announceArgsForCall)]
+	fake.announceArgsForCall = append(fake.announceArgsForCall, struct {
+		arg1 *ssb.BlobRef
+		arg2 func(*ssb.FeedRef) bool
+	}{arg1, arg2})
+	fake.recordInvocation("Announce", []interface{}{arg1, arg2})
+	fake.announceMutex.Unlock()
+	if fake.AnnounceStub != nil {
+		return fake.AnnounceStub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	fakeReturns := fake.announceReturns
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeWantManager) AnnounceCallCount() int {
+	fake.announceMutex.RLock()
+	defer fake.announceMutex.RUnlock()
+	return len(fake.announceArgsForCall)
+}
+
+func (fake *FakeWantManager) AnnounceCalls(stub func(*ssb.BlobRef, func(*ssb.FeedRef) bool) (int, error)) {
+	fake.announceMutex.Lock()
+	defer fake.announceMutex.Unlock()
+	fake.AnnounceStub = stub
+}
+
+func (fake *FakeWantManager) AnnounceArgsForCall(i int) (*ssb.BlobRef, func(*ssb.FeedRef) bool) {
+	fake.announceMutex.RLock()
+	defer fake.announceMutex.RUnlock()
+	argsForCall := fake.announceArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeWantManager) AnnounceReturns(result1 int, result2 error) {
+	fake.announceMutex.Lock()
+	defer fake.announceMutex.Unlock()
+	fake.AnnounceStub = nil
+	fake.announceReturns = struct {
+		result1 int
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeWantManager) AnnounceReturnsOnCall(i int, result1 int, result2 error) {
+	fake.announceMutex.Lock()
+	defer fake.announceMutex.Unlock()
+	fake.AnnounceStub = nil
+	if fake.announceReturnsOnCall == nil {
+		fake.announceReturnsOnCall = make(map[int]struct {
+			result1 int
+			result2 error
+		})
+	}
+	fake.announceReturnsOnCall[i] = struct {
+		result1 int
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeWantManager) Close() error {
 	fake.closeMutex.Lock()
 	ret, specificReturn := fake.closeReturnsOnCall[len(fake.closeArgsForCall)]
@@ -163,6 +241,8 @@ func (fake *FakeWantManager) Close() error {
 }
 
 func (fake *FakeWantManager) CloseCallCount() int {
+	fake.announceMutex.RLock()
+	defer fake.announceMutex.RUnlock()
 	fake.closeMutex.RLock()
 	defer fake.closeMutex.RUnlock()
 	return len(fake.closeArgsForCall)
@@ -0,0 +1,143 @@
+// SPDX-License-Identifier: MIT
+
+package multilogs
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/librarian"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/margaret/multilog"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/mutil"
+	"go.cryptoscope.co/ssb/plugins2/backlinks"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+// IndexNameBlobRefs holds the messages that mention a blob, the blob GC uses it to keep them
+const IndexNameBlobRefs = "blobRefs"
+
+func OpenBlobRefs(r repo.Interface) (multilog.MultiLog, librarian.SinkIndex, error) {
+	return repo.OpenMultiLog(r, IndexNameBlobRefs, BlobRefsUpdate)
+}
+
+// BlobRefsUpdate adds seq to the sublog of each blob the message links to
+func BlobRefsUpdate(ctx context.Context, seq margaret.Seq, value interface{}, mlog multilog.MultiLog) error {
+	if nulled, ok := value.(error); ok {
+		if margaret.IsErrNulled(nulled) {
+			return nil
+		}
+		return nulled
+	}
+
+	msg, ok := value.(ssb.Message)
+	if !ok {
+		return errors.Errorf("error casting message. got type %T", value)
+	}
+
+	for _, ref := range backlinks.ExtractRefs(msg.ContentBytes()) {
+		br, ok := ref.(*ssb.BlobRef)
+		if !ok {
+			continue
+		}
+		addr, err := BlobRefAddr(br)
+		if err != nil {
+			continue
+		}
+
+		blobLog, err := mlog.Get(addr)
+		if err != nil {
+			return errors.Wrap(err, "error opening sublog")
+		}
+
+		_, err = blobLog.Append(seq)
+		if err != nil {
+			return errors.Wrapf(err, "error appending mention of %s", br.Ref())
+		}
+	}
+	return nil
+}
+
+// BlobReferrers returns the keys of the messages that link to ref, oldest first.
+// Messages that were nulled, or whose content was, don't count anymore, so the index only ever has to grow.
+func BlobReferrers(ctx context.Context, rootLog margaret.Log, refs multilog.MultiLog, ref *ssb.BlobRef) ([]*ssb.MessageRef, error) {
+	addr, err := BlobRefAddr(ref)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := refs.Get(addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening sublog")
+	}
+	src, err := mutil.Indirect(rootLog, sub).Query()
+	if err != nil {
+		return nil, errors.Wrap(err, "error querying sublog")
+	}
+
+	var referrers []*ssb.MessageRef
+	seen := make(map[string]struct{})
+	for {
+		v, err := src.Next(ctx)
+		if luigi.IsEOS(err) {
+			return referrers, nil
+		} else if err != nil {
+			return nil, errors.Wrap(err, "error reading sublog")
+		}
+		msg, ok := v.(ssb.Message)
+		if !ok {
+			// nulled messages
+			continue
+		}
+		if _, has := seen[msg.Key().Ref()]; has {
+			continue
+		}
+		for _, r := range backlinks.ExtractRefs(msg.ContentBytes()) {
+			if br, ok := r.(*ssb.BlobRef); ok && br.Equal(ref) {
+				seen[msg.Key().Ref()] = struct{}{}
+				referrers = append(referrers, msg.Key())
+				break
+			}
+		}
+	}
+}
+
+// BlobOrphans returns the blobs of bs that no message links to, see BlobReferrers
+func BlobOrphans(ctx context.Context, rootLog margaret.Log, refs multilog.MultiLog, bs ssb.BlobStore) ([]*ssb.BlobRef, error) {
+	var orphans []*ssb.BlobRef
+	src := bs.List()
+	for {
+		v, err := src.Next(ctx)
+		if luigi.IsEOS(err) {
+			return orphans, nil
+		} else if err != nil {
+			return nil, errors.Wrap(err, "error listing blobs")
+		}
+		ref, ok := v.(*ssb.BlobRef)
+		if !ok {
+			return nil, errors.Errorf("unexpected blob list entry %T", v)
+		}
+		referrers, err := BlobReferrers(ctx, rootLog, refs, ref)
+		if err != nil {
+			return nil, err
+		}
+		if len(referrers) == 0 {
+			orphans = append(orphans, ref)
+		}
+	}
+}
+
+// BlobRefAddr returns the sublog address of ref in the blobRefs index
+func BlobRefAddr(ref *ssb.BlobRef) (librarian.Addr, error) {
+	sr, err := ssb.NewStorageRef(ref)
+	if err != nil {
+		return "", err
+	}
+	b, err := sr.Marshal()
+	if err != nil {
+		return "", err
+	}
+	return librarian.Addr(b), nil
+}
@@ -3,9 +3,8 @@
 package multilogs
 
 import (
-	"bytes"
 	"context"
-	"encoding/base64"
+	"sync"
 
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
@@ -18,6 +17,8 @@ import (
 	"go.cryptoscope.co/ssb"
 	"go.cryptoscope.co/ssb/message/multimsg"
 	"go.cryptoscope.co/ssb/private"
+	"go.cryptoscope.co/ssb/private/groups"
+	"go.cryptoscope.co/ssb/private/keys"
 	"go.cryptoscope.co/ssb/repo"
 )
 
@@ -25,16 +26,67 @@ const IndexNamePrivates = "privates"
 
 // not strictly a multilog but allows multiple keys and gives us the good resumption
 func NewPrivateRead(log kitlog.Logger, kps ...*ssb.KeyPair) *Private {
+	kms := make([]*keys.Manager, len(kps))
+	for i, kp := range kps {
+		kms[i] = keys.NewManager(kp)
+	}
+	return NewPrivateReadWithKeys(log, kms...)
+}
+
+// NewPrivateReadWithKeys is NewPrivateRead with the group keys of the managers, for box2 messages to groups.
+// The managers learn the groups they are added to from the messages that are indexed.
+// Messages to a group that were indexed before its key was added aren't looked at again, see PrivateReplays.
+// The groups are only learned once their init messages can be looked up, see SetGetter.
+func NewPrivateReadWithKeys(log kitlog.Logger, kms ...*keys.Manager) *Private {
+	kps := make([]*ssb.KeyPair, len(kms))
+	byFeed := make(map[string]*keys.Manager, len(kms))
+	for i, km := range kms {
+		kps[i] = km.KeyPair()
+		byFeed[kps[i].Id.Ref()] = km
+	}
 	return &Private{
-		logger:   log,
-		keyPairs: kps,
+		logger:  log,
+		keys:    kms,
+		byFeed:  byFeed,
+		unboxer: private.NewUnboxer(kps...),
+		get:     new(lateGetter),
+	}
+}
+
+// SetGetter sets where the init messages of the groups the managers are added to are looked up, usually the bot that mounts the index.
+// Until it is set, add-member messages are indexed but their groups aren't learned.
+func (pr *Private) SetGetter(get ssb.Getter) {
+	pr.get.mu.Lock()
+	pr.get.g = get
+	pr.get.mu.Unlock()
+}
+
+// lateGetter is the getter of Private, it is shared by the copies that were handed out before it was set
+type lateGetter struct {
+	mu sync.Mutex
+	g  ssb.Getter
+}
+
+func (lg *lateGetter) Get(ref ssb.MessageRef) (ssb.Message, error) {
+	lg.mu.Lock()
+	g := lg.g
+	lg.mu.Unlock()
+	if g == nil {
+		return nil, errors.New("private/readidx: no getter for the init messages of groups")
 	}
+	return g.Get(ref)
 }
 
 type Private struct {
 	logger kitlog.Logger
 
-	keyPairs []*ssb.KeyPair
+	keys   []*keys.Manager
+	byFeed map[string]*keys.Manager
+
+	// box1 is tried with every keypair, their curve25519 secrets are converted once
+	unboxer *private.Unboxer
+
+	get *lateGetter
 }
 
 // OpenRoaring uses roaring bitmaps with a slim key-value store backend
@@ -61,24 +113,8 @@ func (pr Private) update(ctx context.Context, seq margaret.Seq, val interface{},
 		return err
 	}
 
-	var boxedContent []byte
-	switch msg.Author().Algo {
-	case ssb.RefAlgoFeedSSB1:
-		input := msg.ContentBytes()
-		if !(input[0] == '"' && input[len(input)-1] == '"') {
-			return nil // not a json string
-		}
-		b64data := bytes.TrimSuffix(input[1:], []byte(".box\""))
-		boxedData := make([]byte, len(b64data))
-		n, err := base64.StdEncoding.Decode(boxedData, b64data)
-		if err != nil {
-			err = errors.Wrap(err, "private/readidx: invalid b64 encoding")
-			level.Debug(pr.logger).Log("msg", "unboxLog b64 decode failed", "err", err)
-			return nil
-		}
-		boxedContent = boxedData[:n]
-
-	case ssb.RefAlgoFeedGabby:
+	if msg.Author().Algo == ssb.RefAlgoFeedGabby {
+		// only arbitrary content can be boxed
 		mm, ok := val.(multimsg.MultiMessage)
 		if !ok {
 			mmPtr, ok := val.(*multimsg.MultiMessage)
@@ -100,26 +136,61 @@ func (pr Private) update(ctx context.Context, seq margaret.Seq, val interface{},
 		if evt.Content.Type != gabbygrove.ContentTypeArbitrary {
 			return nil
 		}
-		boxedContent = bytes.TrimPrefix(tr.Content, []byte("box1:"))
+	}
 
+	// a box1 message goes to the sublogs of all the identities that can read it
+	readers, plain, err := pr.unboxer.ReadersOf(msg)
+	switch err {
+	case nil:
+		for _, kp := range readers {
+			if err := pr.indexPrivate(mlog, pr.byFeed[kp.Id.Ref()], seq, msg, plain); err != nil {
+				return err
+			}
+		}
+		return nil
+	case private.ErrNotBoxed:
+		// box2 or not encrypted
 	default:
-		err := errors.Errorf("private/readidx: unknown feed type: %s", msg.Author().Algo)
-		level.Warn(pr.logger).Log("msg", "unahndled type", "err", err)
-		return err
+		return nil
 	}
 
-	for _, kp := range pr.keyPairs {
-		if _, err := private.Unbox(kp, boxedContent); err != nil {
+	// box1 and box2 end up in the same sublogs, the unboxer log reads both
+	for _, km := range pr.keys {
+		plain, err := private.Open(km, msg)
+		if err == private.ErrNotBoxed {
+			return nil
+		} else if err != nil {
 			continue
 		}
-		userPrivs, err := mlog.Get(kp.Id.StoredAddr())
-		if err != nil {
-			return errors.Wrapf(err, "private/readidx: error opening priv sublog for %s", kp.Id.Ref())
-		}
-		_, err = userPrivs.Append(seq.Seq())
-		if err != nil {
-			return errors.Wrapf(err, "private/readidx: error appending PM for %s", kp.Id.Ref())
+		if err := pr.indexPrivate(mlog, km, seq, msg, plain); err != nil {
+			return err
 		}
 	}
 	return nil
 }
+
+// indexPrivate adds seq to the sublog of km and learns the groups it is added to by plain
+func (pr Private) indexPrivate(mlog multilog.MultiLog, km *keys.Manager, seq margaret.Seq, msg ssb.Message, plain []byte) error {
+	if err := appendPrivate(mlog, km, seq, plain); err != nil {
+		return err
+	}
+	if grp, isNew, err := groups.Learn(km, pr.get, plain); err != nil {
+		level.Warn(pr.logger).Log("event", "invalid group message", "msg", msg.Key().Ref(), "err", err)
+	} else if isNew {
+		level.Info(pr.logger).Log("event", "added to group", "group", grp.ID, "by", msg.Author().Ref())
+	}
+	return nil
+}
+
+func appendPrivate(mlog multilog.MultiLog, km *keys.Manager, seq margaret.Seq, plain []byte) error {
+	kp := km.KeyPair()
+	userPrivs, err := mlog.Get(kp.Id.StoredAddr())
+	if err != nil {
+		return errors.Wrapf(err, "private/readidx: error opening priv sublog for %s", kp.Id.Ref())
+	}
+	_, err = userPrivs.Append(seq.Seq())
+	if err != nil {
+		return errors.Wrapf(err, "private/readidx: error appending PM for %s", kp.Id.Ref())
+	}
+	return nil
+}
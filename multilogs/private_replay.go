@@ -0,0 +1,292 @@
+// SPDX-License-Identifier: MIT
+
+package multilogs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/margaret/multilog"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/private"
+	"go.cryptoscope.co/ssb/private/box2"
+	"go.cryptoscope.co/ssb/private/groups"
+	"go.cryptoscope.co/ssb/private/keys"
+)
+
+// how many messages a replay looks at between saving how far it got
+const replaySaveEvery = 1000
+
+// ReplayJob reads the receive log again with one key, for the private messages that were indexed before it was known.
+// Group is the id of the group whose key is used, without one it's the keypair of Feed for box1 and box2 direct messages.
+type ReplayJob struct {
+	Feed  string `json:"feed"`
+	Group string `json:"group,omitempty"`
+
+	Next  int64 `json:"next"`  // the next sequence of the receive log to look at
+	Until int64 `json:"until"` // the last sequence when the job was added, the ones after it are indexed as usual
+}
+
+// Name tells the job apart from the others of the same feed
+func (j ReplayJob) Name() string {
+	if j.Group != "" {
+		return j.Feed + " " + j.Group
+	}
+	return j.Feed
+}
+
+// Done is true once the job looked at all of its messages
+func (j ReplayJob) Done() bool { return j.Next > j.Until }
+
+// Percent is how much of its messages the job looked at
+func (j ReplayJob) Percent() float64 {
+	if j.Done() || j.Until < 0 {
+		return 100
+	}
+	return float64(j.Next) / float64(j.Until+1) * 100
+}
+
+// String is the state of the job for the status of the bot
+func (j ReplayJob) String() string {
+	if j.Done() {
+		return "done"
+	}
+	return fmt.Sprintf("%.2f%%", j.Percent())
+}
+
+// PrivateReplays runs the replay jobs of the privates index, see ReplayJob.
+// The unfinished jobs are kept in a file, so that they continue after a restart.
+type PrivateReplays struct {
+	logger kitlog.Logger
+	path   string
+	rxlog  margaret.Log
+	mlog   multilog.MultiLog
+	get    ssb.Getter
+	keys   map[string]*keys.Manager
+
+	mu      sync.Mutex
+	jobs    map[string]*ReplayJob
+	running map[string]bool
+}
+
+// OpenPrivateReplays loads the unfinished jobs from path.
+// mlog is the privates index of the managers, their keys are read from rxlog. get looks up the init messages of the groups they learn.
+func OpenPrivateReplays(logger kitlog.Logger, path string, rxlog margaret.Log, mlog multilog.MultiLog, get ssb.Getter, kms ...*keys.Manager) (*PrivateReplays, error) {
+	pr := &PrivateReplays{
+		logger:  logger,
+		path:    path,
+		rxlog:   rxlog,
+		mlog:    mlog,
+		get:     get,
+		keys:    make(map[string]*keys.Manager),
+		jobs:    make(map[string]*ReplayJob),
+		running: make(map[string]bool),
+	}
+	for _, km := range kms {
+		pr.keys[km.KeyPair().Id.Ref()] = km
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return pr, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "private/replay: failed to read jobs")
+	}
+	var jobs []ReplayJob
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		return nil, errors.Wrapf(err, "private/replay: failed to decode jobs of %s", path)
+	}
+	for i := range jobs {
+		pr.jobs[jobs[i].Name()] = &jobs[i]
+	}
+	return pr, nil
+}
+
+// Add records a job for the group of feed, or its keypair if group is empty, and returns its name.
+// A job that is already there starts over, unless it is running.
+func (pr *PrivateReplays) Add(feed *ssb.FeedRef, group string) (string, error) {
+	km, ok := pr.keys[feed.Ref()]
+	if !ok {
+		return "", errors.Errorf("private/replay: no keys for %s", feed.Ref())
+	}
+	if group != "" {
+		if _, ok := km.GroupKey(group); !ok {
+			return "", errors.Errorf("private/replay: no key for group %s", group)
+		}
+	}
+
+	sv, err := pr.rxlog.Seq().Value()
+	if err != nil {
+		return "", errors.Wrap(err, "private/replay: failed to get receive log sequence")
+	}
+	seq, ok := sv.(margaret.Seq)
+	if !ok {
+		return "", errors.Errorf("private/replay: unexpected sequence type %T", sv)
+	}
+
+	job := ReplayJob{Feed: feed.Ref(), Group: group, Until: seq.Seq()}
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	if pr.running[job.Name()] {
+		// the key was known before it started, the messages after it are in the index already
+		return job.Name(), nil
+	}
+	pr.jobs[job.Name()] = &job
+	return job.Name(), pr.save()
+}
+
+// Pending returns the names of the jobs that aren't done yet
+func (pr *PrivateReplays) Pending() []string {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	var names []string
+	for name, job := range pr.jobs {
+		if !job.Done() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Jobs returns the state of all jobs, the ones that finished since the start included
+func (pr *PrivateReplays) Jobs() []ReplayJob {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	jobs := make([]ReplayJob, 0, len(pr.jobs))
+	for _, job := range pr.jobs {
+		jobs = append(jobs, *job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name() < jobs[j].Name() })
+	return jobs
+}
+
+// Run works on the job name until it is done or ctx is canceled. It returns right away if the job already runs.
+func (pr *PrivateReplays) Run(ctx context.Context, name string) error {
+	pr.mu.Lock()
+	job, ok := pr.jobs[name]
+	if !ok {
+		pr.mu.Unlock()
+		return errors.Errorf("private/replay: no job %s", name)
+	}
+	if pr.running[name] || job.Done() {
+		pr.mu.Unlock()
+		return nil
+	}
+	pr.running[name] = true
+	next, until, feed, group := job.Next, job.Until, job.Feed, job.Group
+	pr.mu.Unlock()
+
+	defer func() {
+		pr.mu.Lock()
+		delete(pr.running, name)
+		pr.mu.Unlock()
+	}()
+
+	km, ok := pr.keys[feed]
+	if !ok {
+		return errors.Errorf("private/replay: no keys for %s", feed)
+	}
+	// only the keypair, without the groups
+	dmKeys := keys.NewManager(km.KeyPair())
+	open := func(msg ssb.Message) ([]byte, error) {
+		return private.Open(dmKeys, msg)
+	}
+	if group != "" {
+		grp, ok := km.GroupKey(group)
+		if !ok {
+			return errors.Errorf("private/replay: no key for group %s", group)
+		}
+		open = func(msg ssb.Message) ([]byte, error) {
+			plain, _, err := private.OpenWithKeys(msg, []box2.Recipient{grp})
+			return plain, err
+		}
+	}
+
+	src, err := pr.rxlog.Query(margaret.Gte(margaret.BaseSeq(next)), margaret.SeqWrap(true))
+	if err != nil {
+		return errors.Wrap(err, "private/replay: failed to query receive log")
+	}
+
+	var found, looked int
+	for {
+		v, err := src.Next(ctx)
+		if luigi.IsEOS(err) {
+			break
+		} else if err != nil {
+			// keep what is done so far, for the next start
+			pr.mu.Lock()
+			pr.save()
+			pr.mu.Unlock()
+			return errors.Wrap(err, "private/replay: failed to read receive log")
+		}
+		sw, ok := v.(margaret.SeqWrapper)
+		if !ok {
+			return errors.Errorf("private/replay: unexpected receive log value %T", v)
+		}
+		seq := sw.Seq().Seq()
+		if seq > until {
+			break
+		}
+
+		if msg, ok := sw.Value().(ssb.Message); ok {
+			if plain, err := open(msg); err == nil {
+				if err := appendPrivate(pr.mlog, km, sw.Seq(), plain); err != nil {
+					return err
+				}
+				found++
+				// the groups learned here get jobs of their own, through the hooks of km
+				groups.Learn(km, pr.get, plain)
+			}
+		}
+
+		looked++
+		pr.mu.Lock()
+		job.Next = seq + 1
+		if looked%replaySaveEvery == 0 {
+			err = pr.save()
+		}
+		pr.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	pr.mu.Lock()
+	job.Next = until + 1
+	err = pr.save()
+	pr.mu.Unlock()
+	level.Info(pr.logger).Log("event", "private replay done", "job", name, "found", found)
+	return err
+}
+
+// save writes the unfinished jobs to the file. pr.mu has to be held.
+func (pr *PrivateReplays) save() error {
+	jobs := []ReplayJob{}
+	for _, job := range pr.jobs {
+		if !job.Done() {
+			jobs = append(jobs, *job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name() < jobs[j].Name() })
+	data, err := json.Marshal(jobs)
+	if err != nil {
+		return errors.Wrap(err, "private/replay: failed to encode jobs")
+	}
+	tmp := pr.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return errors.Wrap(err, "private/replay: failed to write jobs")
+	}
+	return errors.Wrap(os.Rename(tmp, pr.path), "private/replay: failed to replace jobs")
+}
@@ -17,6 +17,9 @@ type EndpointStat struct {
 	Addr     net.Addr
 	Since    time.Duration
 	Endpoint muxrpc.Endpoint
+
+	// Listener is the address of the listener the peer connected to, nil if we dialed it
+	Listener net.Addr
 }
 
 type Network interface {
@@ -32,6 +35,48 @@ type Network interface {
 	io.Closer
 }
 
+// ConnScheduler is implemented by connection trackers that also decide which peers to dial.
+type ConnScheduler interface {
+	// AddAddress remembers an address to dial. source says where it came from, like pub, discovery or manual.
+	AddAddress(addr net.Addr, source string) error
+
+	// SetSticky protects the connection to a peer from being closed to make room for others.
+	SetSticky(ref *FeedRef, sticky bool)
+}
+
+// DialBackoffs is implemented by networks that stop dialing addresses for a while after they failed.
+type DialBackoffs interface {
+	// DialBackoffs returns the addresses that failed the last time they were dialed
+	DialBackoffs() []DialBackoff
+
+	// ResetBackoff lets addr be dialed right away again, all addresses if it is nil. It returns how many were reset.
+	ResetBackoff(addr net.Addr) int
+}
+
+// TrafficStats is implemented by networks that count the bytes they exchange with each peer.
+type TrafficStats interface {
+	Traffic() []PeerTraffic
+}
+
+// PeerTraffic is how many bytes were received from and sent to a connected peer, over all its open connections
+type PeerTraffic struct {
+	Feed  string `json:"feed"`
+	Class string `json:"class"` // followed or stranger, which rate limit applies
+	In    uint64 `json:"in"`
+	Out   uint64 `json:"out"`
+}
+
+// DialBackoff is what a network remembers about an address it failed to dial
+type DialBackoff struct {
+	Addr string `json:"addr"`
+
+	// Failure is how the last dial failed: refused, timeout, wrong-key, rejected or other
+	Failure  string    `json:"failure"`
+	Failures int       `json:"failures"`
+	NextTry  time.Time `json:"nextTry"`
+	LastErr  string    `json:"lastErr"`
+}
+
 // ConnTracker decides if connections should be established and keeps track of them
 type ConnTracker interface {
 	// Active returns true and since when a peer connection is active
@@ -51,3 +96,34 @@ type ConnTracker interface {
 	// CloseAll closes all tracked connections
 	CloseAll()
 }
+
+// GossipEvent is something that happened with a connection or the replication with a peer
+type GossipEvent struct {
+	Time  time.Time `json:"time"`
+	Event string    `json:"event"` // one of the Gossip* constants
+	Peer  string    `json:"peer,omitempty"`
+	Addr  string    `json:"addr,omitempty"`
+	Dir   string    `json:"dir,omitempty"` // incoming or outgoing, for connections
+	Err   string    `json:"err,omitempty"`
+}
+
+// the events of GossipEvent
+const (
+	GossipConnect          = "connect"
+	GossipDisconnect       = "disconnect"
+	GossipDialFailed       = "dial-failed"
+	GossipHandshakeFailed  = "handshake-failed"
+	GossipReplicationStart = "replication-start"
+	GossipReplicationDone  = "replication-done"
+)
+
+// GossipEventRecorder takes the gossip events of a bot, to look at them when debugging connectivity
+type GossipEventRecorder interface {
+	RecordGossipEvent(GossipEvent)
+}
+
+// GossipEventStreamer is implemented by networks that keep a log of the gossip events.
+// GossipEvents returns the recent ones and a channel with the ones after them. cancel has to be called once done with it.
+type GossipEventStreamer interface {
+	GossipEvents() (recent []GossipEvent, live <-chan GossipEvent, cancel func())
+}
@@ -0,0 +1,323 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	stderrors "errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/netwrap"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/neterr"
+)
+
+// AcceptLimits protect the accept path from clients that connect too often, which would keep the CPU busy with secret-handshakes.
+// They are off unless Enabled is set. Zero values mean the defaults below, negative ones turn that limit off.
+type AcceptLimits struct {
+	// Enabled turns the limits on. They are opt-in because behind a reverse proxy that doesn't forward the
+	// address of the client, all connections seem to come from the proxy and would share one limit.
+	Enabled bool
+
+	// TrustedProxies are the IP addresses of reverse proxies in front of the websocket listener.
+	// For requests from them, the last address in X-Forwarded-For is the one that is limited.
+	TrustedProxies []string
+	// MaxHandshakes is how many handshakes with incoming connections may run at the same time.
+	// Connections that arrive while all of them are busy are closed right away.
+	MaxHandshakes int
+
+	// PerIPRate is how many new connections per second one IP address may open, PerIPBurst how many in a row before the rate applies.
+	// The burst lets peers reconnect a few times after their network was gone for a moment.
+	PerIPRate  float64
+	PerIPBurst int
+
+	// BanAfter failed handshakes in a row, connections from that IP are closed without a handshake for BanFor.
+	BanAfter int
+	BanFor   time.Duration
+}
+
+// the defaults of AcceptLimits
+const (
+	DefaultMaxHandshakes = 32
+	DefaultPerIPRate     = 1.0
+	DefaultPerIPBurst    = 10
+	DefaultBanAfter      = 5
+	DefaultBanFor        = time.Minute
+)
+
+// errThrottled is the cause of the errors for connections that the accept limits closed without a handshake
+var errThrottled = errors.New("connection throttled")
+
+// why connections were closed without a handshake
+const (
+	throttledHandshakes = "handshakes"
+	throttledRate       = "rate"
+	throttledBanned     = "banned"
+)
+
+type acceptLimiter struct {
+	limits AcceptLimits
+	off    bool          // nothing is limited
+	slots  chan struct{} // nil if the handshakes aren't capped
+
+	mu        sync.Mutex
+	ips       map[string]*ipState
+	lastPrune time.Time
+}
+
+type ipState struct {
+	tokens      float64
+	last        time.Time
+	failures    int
+	bannedUntil time.Time
+}
+
+func newAcceptLimiter(l AcceptLimits) *acceptLimiter {
+	if !l.Enabled {
+		return &acceptLimiter{limits: l, off: true}
+	}
+	if l.MaxHandshakes == 0 {
+		l.MaxHandshakes = DefaultMaxHandshakes
+	}
+	if l.PerIPRate == 0 {
+		l.PerIPRate = DefaultPerIPRate
+	}
+	if l.PerIPBurst == 0 {
+		l.PerIPBurst = DefaultPerIPBurst
+	} else if l.PerIPBurst < 0 {
+		l.PerIPBurst = 1 // can't be turned off, without it nobody would get in
+	}
+	if l.BanAfter == 0 {
+		l.BanAfter = DefaultBanAfter
+	}
+	if l.BanFor == 0 {
+		l.BanFor = DefaultBanFor
+	}
+	al := &acceptLimiter{
+		limits: l,
+		ips:    make(map[string]*ipState),
+	}
+	if l.MaxHandshakes > 0 {
+		al.slots = make(chan struct{}, l.MaxHandshakes)
+	}
+	return al
+}
+
+// allow decides if a new connection from ip may start a handshake. If not, it returns why.
+func (al *acceptLimiter) allow(ip string, now time.Time) (string, bool) {
+	if al.off {
+		return "", true
+	}
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	al.prune(now)
+
+	st, ok := al.ips[ip]
+	if !ok {
+		st = &ipState{tokens: float64(al.limits.PerIPBurst), last: now}
+		al.ips[ip] = st
+	}
+	if now.Before(st.bannedUntil) {
+		return throttledBanned, false
+	}
+	if al.limits.PerIPRate > 0 {
+		st.tokens += now.Sub(st.last).Seconds() * al.limits.PerIPRate
+		if max := float64(al.limits.PerIPBurst); st.tokens > max {
+			st.tokens = max
+		}
+		st.last = now
+		if st.tokens < 1 {
+			return throttledRate, false
+		}
+		st.tokens--
+	}
+	return "", true
+}
+
+// prune forgets the addresses that are back to a full burst and not banned, at most once a minute. al.mu needs to be held.
+func (al *acceptLimiter) prune(now time.Time) {
+	if now.Sub(al.lastPrune) < time.Minute {
+		return
+	}
+	al.lastPrune = now
+	refill := time.Duration(0)
+	if al.limits.PerIPRate > 0 {
+		refill = time.Duration(float64(al.limits.PerIPBurst) / al.limits.PerIPRate * float64(time.Second))
+	}
+	for ip, st := range al.ips {
+		if st.failures == 0 && now.After(st.bannedUntil) && now.Sub(st.last) >= refill {
+			delete(al.ips, ip)
+		}
+	}
+}
+
+// acquire takes one of the handshake slots, false if all of them are busy
+func (al *acceptLimiter) acquire() bool {
+	if al.slots == nil {
+		return true
+	}
+	select {
+	case al.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (al *acceptLimiter) release() {
+	if al.slots != nil {
+		<-al.slots
+	}
+}
+
+// failed counts a failed handshake of ip and returns true if it got banned because of it
+func (al *acceptLimiter) failed(ip string, now time.Time) bool {
+	if al.off || al.limits.BanAfter <= 0 || al.limits.BanFor <= 0 {
+		return false
+	}
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	st, ok := al.ips[ip]
+	if !ok {
+		st = &ipState{tokens: float64(al.limits.PerIPBurst), last: now}
+		al.ips[ip] = st
+	}
+	st.failures++
+	if st.failures < al.limits.BanAfter {
+		return false
+	}
+	st.failures = 0
+	st.bannedUntil = now.Add(al.limits.BanFor)
+	return true
+}
+
+// succeeded resets the failures of ip
+func (al *acceptLimiter) succeeded(ip string) {
+	if al.off {
+		return
+	}
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	if st, ok := al.ips[ip]; ok {
+		st.failures = 0
+	}
+}
+
+// remoteIP is the IP of addr without the port, or all of it if it has none
+func remoteIP(addr net.Addr) string {
+	if tcpAddr, ok := netwrap.GetAddr(addr, "tcp").(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// forwardedFor returns the address of the client if req came through one of the trusted proxies, nil if it didn't
+func (al *acceptLimiter) forwardedFor(req *http.Request) net.Addr {
+	fwd := req.Header.Get("X-Forwarded-For")
+	if fwd == "" || len(al.limits.TrustedProxies) == 0 {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	proxy := net.ParseIP(host)
+	trusted := false
+	for _, p := range al.limits.TrustedProxies {
+		if ip := net.ParseIP(p); ip != nil && ip.Equal(proxy) {
+			trusted = true
+			break
+		}
+	}
+	if !trusted {
+		return nil
+	}
+	// the proxy appends the address it got the request from, the ones before it could be made up by the client
+	hops := strings.Split(fwd, ",")
+	client := net.ParseIP(strings.TrimSpace(hops[len(hops)-1]))
+	if client == nil {
+		return nil
+	}
+	return &net.TCPAddr{IP: client}
+}
+
+// isHandshakeFailure is false for connections that went away or timed out during the handshake,
+// like port scans and health checks do. Only the other errors count towards a ban.
+func isHandshakeFailure(err error) bool {
+	if stderrors.Is(err, io.EOF) || stderrors.Is(err, io.ErrUnexpectedEOF) || neterr.IsConnBrokenErr(err) {
+		return false
+	}
+	var ne net.Error
+	if stderrors.As(err, &ne) && ne.Timeout() {
+		return false
+	}
+	return true
+}
+
+// acceptHandshake runs the secret-handshake with an incoming connection, unless the accept limits say no.
+// remote is the address the connection came from, for websockets that can be the one of the HTTP request.
+func (n *node) acceptHandshake(raw net.Conn, remote net.Addr, wrappers []netwrap.ConnWrapper) (net.Conn, error) {
+	ip := remoteIP(remote)
+	if reason, ok := n.acceptLimiter.allow(ip, time.Now()); !ok {
+		n.throttled(reason, ip)
+		return nil, errors.Wrapf(errThrottled, "%s from %s", reason, ip)
+	}
+	if !n.acceptLimiter.acquire() {
+		n.throttled(throttledHandshakes, ip)
+		return nil, errors.Wrapf(errThrottled, "%s from %s", throttledHandshakes, ip)
+	}
+	defer n.acceptLimiter.release()
+
+	if n.opts.HandshakeTimeout > 0 {
+		if err := raw.SetDeadline(time.Now().Add(n.opts.HandshakeTimeout)); err != nil {
+			return nil, errors.Wrap(err, "failed to set handshake deadline")
+		}
+	}
+	conn := raw
+	for _, cw := range wrappers {
+		var err error
+		conn, err = cw(conn)
+		if err != nil {
+			n.gossipEvent(ssb.GossipEvent{Event: ssb.GossipHandshakeFailed, Addr: remote.String(), Dir: string(ssb.ConnIncoming), Err: err.Error()})
+			if isHandshakeFailure(err) && n.acceptLimiter.failed(ip, time.Now()) {
+				level.Warn(n.log).Log("event", "accept", "msg", "banning address after repeated handshake failures",
+					"ip", ip, "for", n.acceptLimiter.limits.BanFor)
+				if n.evtCtr != nil {
+					n.evtCtr.With("event", "accept.ban").Add(1)
+				}
+			}
+			return nil, err
+		}
+	}
+	if n.opts.HandshakeTimeout > 0 {
+		if err := raw.SetDeadline(time.Time{}); err != nil {
+			return nil, errors.Wrap(err, "failed to clear handshake deadline")
+		}
+	}
+	n.acceptLimiter.succeeded(ip)
+	return conn, nil
+}
+
+// throttled counts and logs a connection that was closed because of the accept limits
+func (n *node) throttled(reason, ip string) {
+	if n.evtCtr != nil {
+		n.evtCtr.With("event", "accept.throttled."+reason).Add(1)
+	}
+	lvl := level.Debug
+	if reason == throttledHandshakes {
+		// not the fault of one address, the bot is probably overwhelmed
+		lvl = level.Info
+	}
+	lvl(n.log).Log("event", "accept", "msg", "connection throttled", "reason", reason, "ip", ip)
+}
@@ -0,0 +1,168 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcceptLimiterOff(t *testing.T) {
+	r := require.New(t)
+
+	// without Enabled, the defaults don't apply either
+	al := newAcceptLimiter(AcceptLimits{})
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		_, ok := al.allow("10.0.0.1", now)
+		r.True(ok, "conn %d", i)
+		r.True(al.acquire())
+		r.False(al.failed("10.0.0.1", now))
+	}
+}
+
+func TestAcceptLimiterForwarded(t *testing.T) {
+	r := require.New(t)
+
+	req := func(remote, fwd string) *http.Request {
+		req, err := http.NewRequest("GET", "/", nil)
+		r.NoError(err)
+		req.RemoteAddr = remote
+		if fwd != "" {
+			req.Header.Set("X-Forwarded-For", fwd)
+		}
+		return req
+	}
+
+	al := newAcceptLimiter(AcceptLimits{Enabled: true, TrustedProxies: []string{"127.0.0.1"}})
+	addr := al.forwardedFor(req("127.0.0.1:5678", "10.1.1.1, 10.0.0.7"))
+	r.NotNil(addr)
+	r.Equal("10.0.0.7", remoteIP(addr))
+
+	// only from the proxies
+	r.Nil(al.forwardedFor(req("10.0.0.9:5678", "10.0.0.7")))
+	r.Nil(al.forwardedFor(req("127.0.0.1:5678", "")))
+	r.Nil(al.forwardedFor(req("127.0.0.1:5678", "garbage")))
+}
+
+func TestHandshakeFailure(t *testing.T) {
+	r := require.New(t)
+
+	r.False(isHandshakeFailure(io.EOF))
+	r.False(isHandshakeFailure(errors.Wrap(io.ErrUnexpectedEOF, "shs")))
+	r.False(isHandshakeFailure(&net.OpError{Op: "read", Err: timeoutErr{}}))
+	r.True(isHandshakeFailure(errors.New("secretstream: wrong app key")))
+}
+
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "i/o timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
+
+func TestAcceptLimiterRate(t *testing.T) {
+	r := require.New(t)
+
+	al := newAcceptLimiter(AcceptLimits{Enabled: true, PerIPRate: 2, PerIPBurst: 3})
+	now := time.Now()
+
+	// the burst gets in right away
+	for i := 0; i < 3; i++ {
+		_, ok := al.allow("10.0.0.1", now)
+		r.True(ok, "conn %d", i)
+	}
+	reason, ok := al.allow("10.0.0.1", now)
+	r.False(ok)
+	r.Equal(throttledRate, reason)
+
+	// other addresses have their own
+	_, ok = al.allow("10.0.0.2", now)
+	r.True(ok)
+
+	// one more after half a second
+	later := now.Add(time.Second / 2)
+	_, ok = al.allow("10.0.0.1", later)
+	r.True(ok)
+	_, ok = al.allow("10.0.0.1", later)
+	r.False(ok)
+
+	// but not more than the burst after a long pause
+	later = later.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		_, ok := al.allow("10.0.0.1", later)
+		r.True(ok, "conn %d", i)
+	}
+	_, ok = al.allow("10.0.0.1", later)
+	r.False(ok)
+
+	// a negative rate doesn't limit
+	al = newAcceptLimiter(AcceptLimits{Enabled: true, PerIPRate: -1})
+	for i := 0; i < 100; i++ {
+		_, ok := al.allow("10.0.0.1", now)
+		r.True(ok, "conn %d", i)
+	}
+}
+
+func TestAcceptLimiterBan(t *testing.T) {
+	r := require.New(t)
+
+	al := newAcceptLimiter(AcceptLimits{Enabled: true, PerIPRate: -1, BanAfter: 3, BanFor: time.Minute})
+	now := time.Now()
+
+	r.False(al.failed("10.0.0.1", now))
+	r.False(al.failed("10.0.0.1", now))
+
+	// a good handshake in between starts over
+	al.succeeded("10.0.0.1")
+	r.False(al.failed("10.0.0.1", now))
+	r.False(al.failed("10.0.0.1", now))
+	r.True(al.failed("10.0.0.1", now))
+
+	reason, ok := al.allow("10.0.0.1", now.Add(time.Second))
+	r.False(ok)
+	r.Equal(throttledBanned, reason)
+	_, ok = al.allow("10.0.0.2", now.Add(time.Second))
+	r.True(ok)
+
+	// the ban ends and isn't pruned before that
+	_, ok = al.allow("10.0.0.1", now.Add(59*time.Second))
+	r.False(ok)
+	_, ok = al.allow("10.0.0.1", now.Add(61*time.Second))
+	r.True(ok)
+
+	// without a ban, failures are only counted
+	al = newAcceptLimiter(AcceptLimits{Enabled: true, BanAfter: -1})
+	for i := 0; i < 10; i++ {
+		r.False(al.failed("10.0.0.1", now))
+	}
+}
+
+func TestAcceptLimiterHandshakes(t *testing.T) {
+	r := require.New(t)
+
+	al := newAcceptLimiter(AcceptLimits{Enabled: true, MaxHandshakes: 2})
+	r.True(al.acquire())
+	r.True(al.acquire())
+	r.False(al.acquire())
+	al.release()
+	r.True(al.acquire())
+
+	al = newAcceptLimiter(AcceptLimits{Enabled: true, MaxHandshakes: -1})
+	for i := 0; i < 100; i++ {
+		r.True(al.acquire())
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+	r := require.New(t)
+
+	r.Equal("10.0.0.1", remoteIP(&net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 8008}))
+	r.Equal("fe80::1", remoteIP(&net.TCPAddr{IP: net.ParseIP("fe80::1"), Port: 8008}))
+	r.Equal("10.0.0.1", remoteIP(&HostAddr{Net: "ws", Host: "10.0.0.1", Port: 8989}))
+}
@@ -0,0 +1,155 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/netwrap"
+	"go.cryptoscope.co/secretstream"
+	multiserver "go.mindeco.de/ssb-multiserver"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// HostAddr is the address of a peer by name, which is resolved when it is dialed.
+// Through a SOCKS5 proxy that happens on the proxy, so there are no lookups that could leak who we talk to.
+// For onion addresses Net is "onion", they can only be dialed through a proxy like tor.
+type HostAddr struct {
+	Net  string
+	Host string
+	Port int
+}
+
+func (ha *HostAddr) Network() string { return ha.Net }
+
+func (ha *HostAddr) String() string {
+	return net.JoinHostPort(ha.Host, strconv.Itoa(ha.Port))
+}
+
+// IsOnion says if the host is a tor onion service
+func (ha *HostAddr) IsOnion() bool {
+	return ha.Net == "onion"
+}
+
+// ParseAddress parses a multiserver address like net:host:port~shs:key, net:[ipv6]:port~shs:key or onion:host.onion:port~shs:key into an address Connect can dial.
+// Of addresses with several parts, separated by ;, the first one we can dial is used.
+// Unlike multiserver.ParseNetAddress, host names are kept to be resolved when dialing instead of now.
+func ParseAddress(addr string) (net.Addr, error) {
+	var firstErr error
+	for _, part := range strings.Split(addr, ";") {
+		a, err := parseAddressPart(strings.TrimSpace(part))
+		if err == nil {
+			return a, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, errors.Wrapf(firstErr, "network: invalid address %q", addr)
+}
+
+func parseAddressPart(part string) (net.Addr, error) {
+	var proto, rest string
+	switch {
+	case strings.HasPrefix(part, "onion:"):
+		proto, rest = "onion", strings.TrimPrefix(part, "onion:")
+	case strings.HasPrefix(part, "net:"):
+		proto, rest = "tcp", strings.TrimPrefix(part, "net:")
+	default:
+		return parseMultiserver(part)
+	}
+
+	hostPort, key, ok := splitShs(rest)
+	if !ok {
+		return nil, errors.Errorf("missing ~shs:key in %q", part)
+	}
+	host, portStr, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, errors.Errorf("invalid port %q", portStr)
+	}
+	if proto == "onion" && !strings.HasSuffix(host, ".onion") {
+		return nil, errors.Errorf("onion address %q doesn't end in .onion", host)
+	}
+	pubKey, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(pubKey) != 32 {
+		return nil, errors.Errorf("invalid shs key %q", key)
+	}
+
+	var addr net.Addr = &HostAddr{Net: proto, Host: host, Port: port}
+	if proto == "tcp" {
+		if ip, zone := splitZone(host); ip != nil {
+			// nothing to resolve, keep the address the way the rest of the code knows it
+			addr = &net.TCPAddr{IP: ip, Port: port, Zone: zone}
+		}
+	}
+	return netwrap.WrapAddr(addr, secretstream.Addr{PubKey: pubKey}), nil
+}
+
+// ParseNetAddress is multiserver.ParseNetAddress that also takes IPv6 addresses, in brackets like net:[2001:db8::1]:8008~shs:key
+func ParseNetAddress(part []byte) (*multiserver.NetAddress, error) {
+	addr, err := parseAddressPart(string(part))
+	if err != nil {
+		return nil, err
+	}
+	tcpAddr, ok := netwrap.GetAddr(addr, "tcp").(*net.TCPAddr)
+	if !ok {
+		return nil, errors.Errorf("not an IP address: %s", part)
+	}
+	ref, err := ssb.GetFeedRefFromAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &multiserver.NetAddress{Addr: *tcpAddr, Ref: ref}, nil
+}
+
+// splitZone parses an IP with an optional %zone, like fe80::1%eth0
+func splitZone(host string) (net.IP, string) {
+	var zone string
+	if i := strings.LastIndex(host, "%"); i >= 0 {
+		host, zone = host[:i], host[i+1:]
+	}
+	return net.ParseIP(host), zone
+}
+
+// splitShs splits host:port~shs:key
+func splitShs(s string) (string, string, bool) {
+	i := strings.Index(s, "~shs:")
+	if i < 0 {
+		return "", "", false
+	}
+	return s[:i], s[i+len("~shs:"):], true
+}
+
+func parseMultiserver(part string) (net.Addr, error) {
+	msaddr, err := multiserver.ParseNetAddress([]byte(part))
+	if err != nil {
+		return nil, err
+	}
+	return netwrap.WrapAddr(&msaddr.Addr, secretstream.Addr{PubKey: msaddr.Ref.PubKey()}), nil
+}
+
+// FormatAddress returns the multiserver address of ref at a HostAddr, or false if addr has none
+func FormatAddress(addr net.Addr, ref *ssb.FeedRef) (string, bool) {
+	ha, ok := netwrap.GetAddr(addr, "onion").(*HostAddr)
+	if !ok {
+		ha, ok = netwrap.GetAddr(addr, "tcp").(*HostAddr)
+	}
+	if !ok {
+		return "", false
+	}
+	proto := "net"
+	if ha.IsOnion() {
+		proto = "onion"
+	}
+	return fmt.Sprintf("%s:%s~shs:%s", proto, ha, base64.StdEncoding.EncodeToString(ref.PubKey())), true
+}
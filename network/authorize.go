@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/muxrpc"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// denyGrace is how long a denied peer can still call us, to learn why it was denied, before the connection is closed
+const denyGrace = 3 * time.Second
+
+// authorizeConn asks the ConnAuthorizer of the options about conn. Our own key is always allowed.
+func (n *node) authorizeConn(remote *ssb.FeedRef, dir ssb.ConnDirection) error {
+	if n.opts.ConnAuthorizer == nil || remote.Equal(n.opts.KeyPair.Id) {
+		return nil
+	}
+	err := n.opts.ConnAuthorizer.AuthorizeConn(remote, dir)
+	if err != nil {
+		level.Info(n.log).Log("event", "connection denied", "peer", remote.Ref(), "direction", dir, "reason", err)
+		return err
+	}
+	level.Debug(n.log).Log("event", "connection allowed", "peer", remote.Ref(), "direction", dir)
+	return nil
+}
+
+// serveDenied answers all calls of a denied peer with the reason, until the grace period is over
+func (n *node) serveDenied(ctx context.Context, conn net.Conn, reason error) {
+	ctx, cancel := context.WithTimeout(ctx, denyGrace)
+	defer cancel()
+
+	pkr := muxrpc.NewPacker(conn)
+	edp := muxrpc.HandleWithLogger(pkr, deniedHandler{reason: reason}, log.NewNopLogger())
+	defer edp.Terminate()
+	edp.(muxrpc.Server).Serve(ctx)
+}
+
+type deniedHandler struct {
+	reason error
+}
+
+func (deniedHandler) HandleConnect(context.Context, muxrpc.Endpoint) {}
+
+func (dh deniedHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
+	req.CloseWithError(errors.Wrap(dh.reason, "connection not authorized"))
+}
+
+// dirHandler tells HandleConnect in which direction the connection was made, see ssb.ConnDirectionFrom
+type dirHandler struct {
+	muxrpc.Handler
+
+	dir ssb.ConnDirection
+}
+
+func (dh dirHandler) HandleConnect(ctx context.Context, edp muxrpc.Endpoint) {
+	dh.Handler.HandleConnect(ssb.WithConnDirection(ctx, dh.dir), edp)
+}
+
+// callAuthHandler asks a CallAuthorizer before passing a call on
+type callAuthHandler struct {
+	muxrpc.Handler
+
+	remote *ssb.FeedRef
+	auth   ssb.CallAuthorizer
+	log    log.Logger
+}
+
+func (n *node) authorizeCalls(remote *ssb.FeedRef, h muxrpc.Handler) muxrpc.Handler {
+	if n.opts.CallAuthorizer == nil || remote.Equal(n.opts.KeyPair.Id) {
+		return h
+	}
+	return callAuthHandler{
+		Handler: h,
+		remote:  remote,
+		auth:    n.opts.CallAuthorizer,
+		log:     n.log,
+	}
+}
+
+func (ch callAuthHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
+	if err := ch.auth.AuthorizeCall(ch.remote, req.Method); err != nil {
+		level.Info(ch.log).Log("event", "call denied", "peer", ch.remote.Ref(), "method", req.Method.String(), "reason", err)
+		req.CloseWithError(errors.Wrapf(err, "%s not authorized", req.Method))
+		return
+	}
+	ch.Handler.HandleCall(ctx, req, edp)
+}
@@ -0,0 +1,249 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// RateLimit is how many bytes per second may be received and sent. Zero doesn't limit that direction.
+type RateLimit struct {
+	In  int64
+	Out int64
+}
+
+// BandwidthLimits are the rate limits of connections.
+// They are applied on the boxstream connection, below muxrpc, so that blob transfers and history streams are limited alike.
+// Small packets, like muxrpc calls and stream ends, don't wait until a burst worth of them was sent without pause.
+// The bigger ones pay for them, so that control packets get through while a peer uses up its share with data.
+type BandwidthLimits struct {
+	// Global is shared by all connections
+	Global RateLimit
+
+	// Followed is the limit of each connection with a peer we follow, Stranger the one of all others
+	Followed RateLimit
+	Stranger RateLimit
+
+	// IsFollowed decides which of the two a peer gets. If it is nil all peers are strangers.
+	IsFollowed func(*ssb.FeedRef) bool
+}
+
+// the classes of peers, as reported in ssb.PeerTraffic
+const (
+	PeerClassFollowed = "followed"
+	PeerClassStranger = "stranger"
+)
+
+const (
+	// smallPacket is the most a read or write can have to count as a control packet
+	smallPacket = 1024
+
+	// minBurst is the smallest size of a bucket, so that a full box (4k) and some small packets always fit
+	minBurst = 16 * 1024
+)
+
+// bucket is a token bucket of bytes
+type bucket struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newBucket returns a full bucket that refills with rate bytes per second, nil if rate doesn't limit
+func newBucket(rate int64) *bucket {
+	if rate <= 0 {
+		return nil
+	}
+	burst := float64(rate)
+	if burst < minBurst {
+		burst = minBurst
+	}
+	return &bucket{
+		rate:   float64(rate),
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// take removes n bytes from the bucket and returns how long to wait until they are paid back.
+// Small packets may go up to a burst into debt before they have to wait.
+func (b *bucket) take(n int, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+	b.tokens -= float64(n)
+
+	var allowed float64
+	if n <= smallPacket {
+		allowed = -b.burst
+	}
+	if b.tokens >= allowed {
+		return 0
+	}
+	return time.Duration((allowed - b.tokens) / b.rate * float64(time.Second))
+}
+
+// peerTraffic is what all open connections with a peer transferred
+type peerTraffic struct {
+	in, out uint64 // accessed atomically
+
+	// guarded by node.trafficMu
+	class string
+	conns int
+}
+
+// meteredConn counts the bytes of a connection and waits for its buckets
+type meteredConn struct {
+	net.Conn
+
+	traffic *peerTraffic
+	in, out []*bucket
+	release func()
+
+	inCtr, outCtr metrics.Counter
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (c *meteredConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		atomic.AddUint64(&c.traffic.in, uint64(n))
+		if c.inCtr != nil {
+			c.inCtr.Add(float64(n))
+		}
+		// the bytes are already here, waiting slows down the next read and with it the sender
+		c.wait(n, c.in)
+	}
+	return n, err
+}
+
+func (c *meteredConn) Write(b []byte) (int, error) {
+	c.wait(len(b), c.out)
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		atomic.AddUint64(&c.traffic.out, uint64(n))
+		if c.outCtr != nil {
+			c.outCtr.Add(float64(n))
+		}
+	}
+	return n, err
+}
+
+func (c *meteredConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.release()
+	})
+	return c.Conn.Close()
+}
+
+// wait blocks until all buckets allow n more bytes or the connection is closed
+func (c *meteredConn) wait(n int, buckets []*bucket) {
+	now := time.Now()
+	var d time.Duration
+	for _, b := range buckets {
+		if w := b.take(n, now); w > d {
+			d = w
+		}
+	}
+	if d <= 0 {
+		return
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-c.closed:
+	}
+}
+
+// meter counts the traffic of conn with remote and applies the rate limits of Options.Bandwidth to it
+func (n *node) meter(conn net.Conn, remote *ssb.FeedRef) net.Conn {
+	limits := n.opts.Bandwidth
+	class, limit := PeerClassStranger, limits.Stranger
+	if limits.IsFollowed != nil && limits.IsFollowed(remote) {
+		class, limit = PeerClassFollowed, limits.Followed
+	}
+
+	key := remote.Ref()
+	n.trafficMu.Lock()
+	t, ok := n.traffic[key]
+	if !ok {
+		t = new(peerTraffic)
+		n.traffic[key] = t
+	}
+	t.class = class
+	t.conns++
+	n.trafficMu.Unlock()
+
+	mc := &meteredConn{
+		Conn:    conn,
+		traffic: t,
+		in:      []*bucket{newBucket(limit.In), n.globalIn},
+		out:     []*bucket{newBucket(limit.Out), n.globalOut},
+		closed:  make(chan struct{}),
+		// peers that are gone don't stay in the map, anyone can connect with new keys
+		release: func() {
+			n.trafficMu.Lock()
+			t.conns--
+			if t.conns == 0 && n.traffic[key] == t {
+				delete(n.traffic, key)
+			}
+			n.trafficMu.Unlock()
+		},
+	}
+	// a label per peer would be a new series for every key that connects
+	if ctr := n.opts.TrafficCounter; ctr != nil {
+		mc.inCtr = ctr.With("direction", "in", "class", class)
+		mc.outCtr = ctr.With("direction", "out", "class", class)
+	}
+	return mc
+}
+
+var _ ssb.TrafficStats = (*node)(nil)
+
+// Traffic returns how many bytes were received from and sent to each connected peer since it connected, the busiest first
+func (n *node) Traffic() []ssb.PeerTraffic {
+	n.trafficMu.Lock()
+	defer n.trafficMu.Unlock()
+	pts := make([]ssb.PeerTraffic, 0, len(n.traffic))
+	for ref, t := range n.traffic {
+		pts = append(pts, ssb.PeerTraffic{
+			Feed:  ref,
+			Class: t.class,
+			In:    atomic.LoadUint64(&t.in),
+			Out:   atomic.LoadUint64(&t.out),
+		})
+	}
+	sort.Slice(pts, func(i, j int) bool {
+		ti, tj := pts[i].In+pts[i].Out, pts[j].In+pts[j].Out
+		if ti != tj {
+			return ti > tj
+		}
+		return pts[i].Feed < pts[j].Feed
+	})
+	return pts
+}
@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/ssb"
+)
+
+func TestBucket(t *testing.T) {
+	r := require.New(t)
+
+	r.Nil(newBucket(0))
+	var unlimited *bucket
+	r.Zero(unlimited.take(1<<20, time.Now()))
+
+	b := newBucket(64 * 1024) // burst is one second
+	now := b.last
+
+	// the burst is free
+	r.Zero(b.take(64*1024, now))
+
+	// big packets wait until the debt is paid back
+	r.Equal(time.Second/2, b.take(32*1024, now))
+
+	// half a second later it is
+	later := now.Add(time.Second / 2)
+	r.Zero(b.take(0, later))
+
+	// small packets go into debt without waiting, up to a burst
+	for i := 0; i < 64; i++ {
+		r.Zero(b.take(smallPacket, later), "packet %d", i)
+	}
+	r.NotZero(b.take(smallPacket, later))
+
+	// small limits still fit a box
+	r.Zero(newBucket(100).take(4096, time.Now()))
+}
+
+func TestMeteredConn(t *testing.T) {
+	r := require.New(t)
+
+	followed := makeRandPubkey(t).Id
+	stranger := makeRandPubkey(t).Id
+	n := &node{
+		opts: Options{
+			Bandwidth: BandwidthLimits{
+				Stranger:   RateLimit{Out: 16 * 1024},
+				IsFollowed: func(ref *ssb.FeedRef) bool { return ref.Equal(followed) },
+			},
+		},
+		traffic: make(map[string]*peerTraffic),
+	}
+
+	var open []net.Conn
+	count := func(ref *ssb.FeedRef, size int) time.Duration {
+		a, b := net.Pipe()
+		go io.Copy(ioutil.Discard, b)
+
+		c := n.meter(a, ref)
+		open = append(open, c)
+		start := time.Now()
+		for i := 0; i < 4; i++ {
+			_, err := c.Write(make([]byte, size))
+			r.NoError(err)
+		}
+		return time.Since(start)
+	}
+
+	r.True(count(followed, 8*1024) < time.Second/2, "followed peers are not limited")
+	r.True(count(stranger, 8*1024) > 900*time.Millisecond, "16k of 32k are over the burst, that takes a second")
+
+	traffic := n.Traffic()
+	r.Len(traffic, 2)
+	for _, pt := range traffic {
+		r.EqualValues(32*1024, pt.Out)
+		r.EqualValues(0, pt.In)
+		if pt.Feed == followed.Ref() {
+			r.Equal(PeerClassFollowed, pt.Class)
+		} else {
+			r.Equal(stranger.Ref(), pt.Feed)
+			r.Equal(PeerClassStranger, pt.Class)
+		}
+	}
+
+	// peers are forgotten once their connections are closed
+	for _, c := range open {
+		c.Close()
+	}
+	r.Empty(n.Traffic())
+
+	// closing stops the wait
+	a, b := net.Pipe()
+	defer b.Close()
+	go io.Copy(ioutil.Discard, b)
+	c := n.meter(a, stranger)
+	_, err := c.Write(make([]byte, 16*1024))
+	r.NoError(err)
+	done := make(chan struct{})
+	go func() {
+		c.Write(make([]byte, 32*1024))
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	c.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("write still waits after close")
+	}
+}
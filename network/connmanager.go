@@ -0,0 +1,506 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// ConnManager is a ConnTracker that keeps the number of connections below a maximum and dials known addresses.
+//
+// When it is full, a new connection replaces the one that was idle the longest, unless that peer is sticky.
+// Addresses that failed are retried with exponential backoff.
+// If there is room for more connections, the peers whose feeds we are most behind on are dialed first.
+type ConnManager struct {
+	logger log.Logger
+
+	max          int
+	dialInterval time.Duration
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+	lag          func(*ssb.FeedRef) time.Duration
+
+	now func() time.Time
+
+	mu     sync.Mutex
+	conns  map[[32]byte]*managedConn
+	known  map[[32]byte]*knownAddr
+	sticky map[[32]byte]bool
+}
+
+type managedConn struct {
+	c       net.Conn
+	feed    *ssb.FeedRef
+	started time.Time
+	last    *int64 // unix nanoseconds of the last read or write, shared with the activityConn
+	evicted bool
+	cancel  context.CancelFunc
+}
+
+type knownAddr struct {
+	addr     net.Addr
+	feed     *ssb.FeedRef
+	source   string
+	failures int
+	nextTry  time.Time
+	dialing  bool
+	lastErr  error
+}
+
+// ConnManagerOption changes the defaults of NewConnManager
+type ConnManagerOption func(*ConnManager) error
+
+// MaxConnections sets how many connections are kept open at most. The default is 16.
+func MaxConnections(n int) ConnManagerOption {
+	return func(cm *ConnManager) error {
+		if n < 1 {
+			return errors.Errorf("connmanager: need room for at least one connection, got %d", n)
+		}
+		cm.max = n
+		return nil
+	}
+}
+
+// WithDialInterval sets how often the known addresses are checked for ones to dial. The default is five seconds.
+func WithDialInterval(d time.Duration) ConnManagerOption {
+	return func(cm *ConnManager) error {
+		if d <= 0 {
+			return errors.New("connmanager: dial interval needs to be positive")
+		}
+		cm.dialInterval = d
+		return nil
+	}
+}
+
+// WithBackoff sets the wait after the first failure to dial an address and the most it grows to.
+func WithBackoff(min, max time.Duration) ConnManagerOption {
+	return func(cm *ConnManager) error {
+		if min <= 0 || max < min {
+			return errors.Errorf("connmanager: invalid backoff %s-%s", min, max)
+		}
+		cm.minBackoff, cm.maxBackoff = min, max
+		return nil
+	}
+}
+
+// WithLag tells the manager how far behind we are on a feed, peers with a bigger lag are dialed first.
+func WithLag(lag func(*ssb.FeedRef) time.Duration) ConnManagerOption {
+	return func(cm *ConnManager) error {
+		cm.lag = lag
+		return nil
+	}
+}
+
+// WithConnLogger sets the logger for dials and evictions
+func WithConnLogger(l log.Logger) ConnManagerOption {
+	return func(cm *ConnManager) error {
+		cm.logger = l
+		return nil
+	}
+}
+
+// NewConnManager returns a ConnManager. Use it as the ConnTracker of the network and run Serve to dial.
+func NewConnManager(opts ...ConnManagerOption) (*ConnManager, error) {
+	cm := &ConnManager{
+		logger:       log.NewNopLogger(),
+		max:          16,
+		dialInterval: 5 * time.Second,
+		minBackoff:   10 * time.Second,
+		maxBackoff:   30 * time.Minute,
+		now:          time.Now,
+
+		conns:  make(map[[32]byte]*managedConn),
+		known:  make(map[[32]byte]*knownAddr),
+		sticky: make(map[[32]byte]bool),
+	}
+	for i, o := range opts {
+		if err := o(cm); err != nil {
+			return nil, errors.Wrapf(err, "connmanager: option %d failed", i)
+		}
+	}
+	return cm, nil
+}
+
+var _ ssb.ConnTracker = (*ConnManager)(nil)
+var _ ssb.ConnScheduler = (*ConnManager)(nil)
+
+// AddAddress remembers addr to dial it when there is room. A new address of a known peer replaces the old one.
+func (cm *ConnManager) AddAddress(addr net.Addr, source string) error {
+	feed, err := ssb.GetFeedRefFromAddr(addr)
+	if err != nil {
+		return errors.Wrap(err, "connmanager: not an shs address")
+	}
+	k := toActive(addr)
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	ka, has := cm.known[k]
+	if has {
+		if ka.addr.String() != addr.String() {
+			// a new address deserves a fresh start
+			ka.failures = 0
+			ka.nextTry = time.Time{}
+			ka.lastErr = nil
+		}
+		ka.addr = addr
+		ka.source = source
+		return nil
+	}
+	cm.known[k] = &knownAddr{
+		addr:   addr,
+		feed:   feed,
+		source: source,
+	}
+	return nil
+}
+
+// SetSticky protects the connection to ref from being evicted, or lifts that protection.
+func (cm *ConnManager) SetSticky(ref *ssb.FeedRef, sticky bool) {
+	var k [32]byte
+	copy(k[:], ref.PubKey())
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if sticky {
+		cm.sticky[k] = true
+	} else {
+		delete(cm.sticky, k)
+	}
+}
+
+// WrapConn is a netwrap.ConnWrapper that lets the manager see when a connection was last used.
+// Add it to the wrappers after the secret handshake, otherwise the time since connecting is used.
+func (cm *ConnManager) WrapConn(c net.Conn) (net.Conn, error) {
+	last := cm.now().UnixNano()
+	return &activityConn{Conn: c, last: &last, now: cm.now}, nil
+}
+
+type activityConn struct {
+	net.Conn
+	last *int64
+	now  func() time.Time
+}
+
+func (ac *activityConn) Read(b []byte) (int, error) {
+	n, err := ac.Conn.Read(b)
+	if n > 0 {
+		atomic.StoreInt64(ac.last, ac.now().UnixNano())
+	}
+	return n, err
+}
+
+func (ac *activityConn) Write(b []byte) (int, error) {
+	n, err := ac.Conn.Write(b)
+	if n > 0 {
+		atomic.StoreInt64(ac.last, ac.now().UnixNano())
+	}
+	return n, err
+}
+
+// OnAccept takes the connection if there is room or a connection that can be evicted.
+// Only one connection per peer is allowed.
+func (cm *ConnManager) OnAccept(ctx context.Context, conn net.Conn) (bool, context.Context) {
+	feed, err := ssb.GetFeedRefFromAddr(conn.RemoteAddr())
+	if err != nil {
+		return false, nil
+	}
+	k := toActive(conn.RemoteAddr())
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if _, has := cm.conns[k]; has {
+		return false, nil
+	}
+
+	if cm.openCount() >= cm.max {
+		victim := cm.idlest()
+		if victim == nil {
+			if !cm.sticky[k] {
+				level.Debug(cm.logger).Log("event", "connection refused", "msg", "full and nothing to evict", "peer", feed.ShortRef())
+				return false, nil
+			}
+			// sticky peers get in anyway
+		} else {
+			level.Debug(cm.logger).Log("event", "evicting", "peer", victim.feed.ShortRef(), "for", feed.ShortRef())
+			victim.evicted = true
+			victim.cancel()
+			victim.c.Close()
+		}
+	}
+
+	mc := &managedConn{
+		c:       conn,
+		feed:    feed,
+		started: cm.now(),
+	}
+	if ac, ok := conn.(*activityConn); ok {
+		mc.last = ac.last
+	}
+	ctx, mc.cancel = context.WithCancel(ctx)
+	cm.conns[k] = mc
+	return true, ctx
+}
+
+// openCount doesn't count evicted connections that are still shutting down. cm.mu needs to be held.
+func (cm *ConnManager) openCount() int {
+	n := 0
+	for _, mc := range cm.conns {
+		if !mc.evicted {
+			n++
+		}
+	}
+	return n
+}
+
+// idlest returns the non-sticky connection that was idle the longest. cm.mu needs to be held.
+func (cm *ConnManager) idlest() *managedConn {
+	var (
+		victim   *managedConn
+		victimAt int64
+	)
+	for k, mc := range cm.conns {
+		if mc.evicted || cm.sticky[k] {
+			continue
+		}
+		at := mc.lastActive()
+		if victim == nil || at < victimAt {
+			victim, victimAt = mc, at
+		}
+	}
+	return victim
+}
+
+func (mc *managedConn) lastActive() int64 {
+	if mc.last != nil {
+		return atomic.LoadInt64(mc.last)
+	}
+	return mc.started.UnixNano()
+}
+
+// OnClose forgets the connection
+func (cm *ConnManager) OnClose(conn net.Conn) time.Duration {
+	k := toActive(conn.RemoteAddr())
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	mc, has := cm.conns[k]
+	if !has || mc.c != conn {
+		return 0
+	}
+	mc.cancel()
+	delete(cm.conns, k)
+	return cm.now().Sub(mc.started)
+}
+
+// Active tells if there is a connection to the peer of a and since how long
+func (cm *ConnManager) Active(a net.Addr) (bool, time.Duration) {
+	k := toActive(a)
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	mc, has := cm.conns[k]
+	if !has {
+		return false, 0
+	}
+	return true, cm.now().Sub(mc.started)
+}
+
+// Count returns the number of open connections
+func (cm *ConnManager) Count() uint {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	return uint(len(cm.conns))
+}
+
+// CloseAll closes all connections, sticky ones included
+func (cm *ConnManager) CloseAll() {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	for _, mc := range cm.conns {
+		mc.cancel()
+		mc.c.Close()
+	}
+}
+
+// Serve dials known addresses with dial until ctx is canceled.
+func (cm *ConnManager) Serve(ctx context.Context, dial func(context.Context, net.Addr) error) error {
+	tick := time.NewTicker(cm.dialInterval)
+	defer tick.Stop()
+	for {
+		for _, ka := range cm.dueDials() {
+			go cm.dial(ctx, dial, ka)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick.C:
+		}
+	}
+}
+
+func (cm *ConnManager) dial(ctx context.Context, dial func(context.Context, net.Addr) error, ka *knownAddr) {
+	cm.mu.Lock()
+	addr := ka.addr
+	cm.mu.Unlock()
+
+	err := dial(ctx, addr)
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	ka.dialing = false
+	if errors.Cause(err) == ErrBackingOff {
+		// the network waits for an earlier failure, that's not another one
+		ka.nextTry = cm.now().Add(cm.minBackoff)
+		return
+	}
+	if err != nil {
+		ka.failures++
+		ka.lastErr = err
+		ka.nextTry = cm.now().Add(cm.backoff(ka.failures))
+		level.Debug(cm.logger).Log("event", "dial failed", "peer", ka.feed.ShortRef(), "failures", ka.failures, "failure", DialFailureOf(err), "err", err)
+		return
+	}
+	ka.failures = 0
+	ka.lastErr = nil
+	ka.nextTry = cm.now().Add(cm.minBackoff)
+}
+
+func (cm *ConnManager) backoff(failures int) time.Duration {
+	d := cm.minBackoff
+	for i := 1; i < failures && d < cm.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > cm.maxBackoff {
+		d = cm.maxBackoff
+	}
+	return d
+}
+
+// dueDials picks the known addresses to dial now, as many as there is room for.
+// The ones we are most behind on come first.
+func (cm *ConnManager) dueDials() []*knownAddr {
+	cm.mu.Lock()
+	now := cm.now()
+	free := cm.max - cm.openCount()
+	var due []*knownAddr
+	for k, ka := range cm.known {
+		if ka.dialing || now.Before(ka.nextTry) {
+			continue
+		}
+		if _, open := cm.conns[k]; open {
+			continue
+		}
+		due = append(due, ka)
+	}
+	for _, ka := range cm.known {
+		if ka.dialing {
+			free--
+		}
+	}
+	cm.mu.Unlock()
+
+	if free <= 0 || len(due) == 0 {
+		return nil
+	}
+
+	// lag can be slow, so it's called without holding the lock
+	lags := make(map[*knownAddr]time.Duration, len(due))
+	if cm.lag != nil {
+		for _, ka := range due {
+			lags[ka] = cm.lag(ka.feed)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		if lags[due[i]] != lags[due[j]] {
+			return lags[due[i]] > lags[due[j]]
+		}
+		if due[i].failures != due[j].failures {
+			return due[i].failures < due[j].failures
+		}
+		return due[i].feed.Ref() < due[j].feed.Ref()
+	})
+	if len(due) > free {
+		due = due[:free]
+	}
+
+	cm.mu.Lock()
+	for _, ka := range due {
+		ka.dialing = true
+	}
+	cm.mu.Unlock()
+	return due
+}
+
+// ConnState is what a ConnManager knows at one point
+type ConnState struct {
+	Max   int
+	Open  []OpenConnState
+	Known []KnownAddrState
+}
+
+// OpenConnState is one open connection
+type OpenConnState struct {
+	Feed       *ssb.FeedRef
+	Since      time.Time
+	LastActive time.Time
+	Sticky     bool
+}
+
+// KnownAddrState is one address the manager dials
+type KnownAddrState struct {
+	Addr     net.Addr
+	Feed     *ssb.FeedRef
+	Source   string
+	Failures int
+	NextTry  time.Time
+	Failure  DialFailure // how the last dial failed, empty if it didn't
+	LastErr  error
+}
+
+// State returns the open connections, the oldest first, and the known addresses
+func (cm *ConnManager) State() ConnState {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	st := ConnState{Max: cm.max}
+	for k, mc := range cm.conns {
+		if mc.evicted {
+			continue
+		}
+		st.Open = append(st.Open, OpenConnState{
+			Feed:       mc.feed,
+			Since:      mc.started,
+			LastActive: time.Unix(0, mc.lastActive()),
+			Sticky:     cm.sticky[k],
+		})
+	}
+	sort.Slice(st.Open, func(i, j int) bool { return st.Open[i].Since.Before(st.Open[j].Since) })
+
+	for _, ka := range cm.known {
+		ks := KnownAddrState{
+			Addr:     ka.addr,
+			Feed:     ka.feed,
+			Source:   ka.source,
+			Failures: ka.failures,
+			NextTry:  ka.nextTry,
+			LastErr:  ka.lastErr,
+		}
+		if ka.lastErr != nil {
+			ks.Failure = DialFailureOf(ka.lastErr)
+		}
+		st.Known = append(st.Known, ks)
+	}
+	sort.Slice(st.Known, func(i, j int) bool { return st.Known[i].Feed.Ref() < st.Known[j].Feed.Ref() })
+	return st
+}
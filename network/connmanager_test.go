@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/netwrap"
+	"go.cryptoscope.co/secretstream"
+
+	"go.cryptoscope.co/ssb"
+)
+
+type fakeConn struct {
+	net.Conn
+	remote net.Addr
+	closed bool
+}
+
+func (fc *fakeConn) RemoteAddr() net.Addr { return fc.remote }
+func (fc *fakeConn) Close() error         { fc.closed = true; return nil }
+
+func shsAddrOf(t *testing.T, kp *ssb.KeyPair, port int) net.Addr {
+	return netwrap.WrapAddr(&net.TCPAddr{IP: net.IPv4(192, 168, 1, 2), Port: port}, secretstream.Addr{PubKey: kp.Id.PubKey()})
+}
+
+func TestConnManagerEviction(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	var clock = time.Unix(1000, 0)
+	cm, err := NewConnManager(MaxConnections(2))
+	r.NoError(err)
+	cm.now = func() time.Time { return clock }
+
+	accept := func(kp *ssb.KeyPair) (*fakeConn, bool) {
+		fc := &fakeConn{remote: shsAddrOf(t, kp, 8008)}
+		wrapped, err := cm.WrapConn(fc)
+		r.NoError(err)
+		ok, _ := cm.OnAccept(ctx, wrapped)
+		return fc, ok
+	}
+
+	a, b, c, d := makeRandPubkey(t), makeRandPubkey(t), makeRandPubkey(t), makeRandPubkey(t)
+
+	connA, ok := accept(a)
+	r.True(ok)
+	clock = clock.Add(time.Second)
+	connB, ok := accept(b)
+	r.True(ok)
+
+	// only one connection per peer
+	_, ok = accept(a)
+	r.False(ok)
+
+	// a was idle longer and goes
+	clock = clock.Add(time.Second)
+	_, ok = accept(c)
+	r.True(ok)
+	r.True(connA.closed)
+	r.False(connB.closed)
+	r.Len(cm.State().Open, 2)
+
+	// b and c are sticky, d has to wait
+	cm.SetSticky(b.Id, true)
+	cm.SetSticky(c.Id, true)
+	_, ok = accept(d)
+	r.False(ok)
+
+	// unless it's sticky itself
+	cm.SetSticky(d.Id, true)
+	_, ok = accept(d)
+	r.True(ok)
+	r.Len(cm.State().Open, 3)
+}
+
+func TestConnManagerDialing(t *testing.T) {
+	r := require.New(t)
+
+	var clock = time.Unix(1000, 0)
+	a, b, c := makeRandPubkey(t), makeRandPubkey(t), makeRandPubkey(t)
+	lags := map[string]time.Duration{
+		a.Id.Ref(): time.Minute,
+		b.Id.Ref(): time.Hour,
+		c.Id.Ref(): time.Second,
+	}
+	cm, err := NewConnManager(
+		MaxConnections(2),
+		WithBackoff(time.Second, 4*time.Second),
+		WithLag(func(ref *ssb.FeedRef) time.Duration { return lags[ref.Ref()] }),
+	)
+	r.NoError(err)
+	cm.now = func() time.Time { return clock }
+
+	for _, kp := range []*ssb.KeyPair{a, b, c} {
+		r.NoError(cm.AddAddress(shsAddrOf(t, kp, 8008), "test"))
+	}
+	r.Error(cm.AddAddress(&net.TCPAddr{Port: 8008}, "test"), "needs a key")
+
+	// the ones we are furthest behind on, as many as there is room for
+	due := cm.dueDials()
+	r.Len(due, 2)
+	r.True(due[0].feed.Equal(b.Id))
+	r.True(due[1].feed.Equal(a.Id))
+
+	// while they are dialed there is no room
+	r.Len(cm.dueDials(), 0)
+
+	failing := func(context.Context, net.Addr) error { return errors.New("nope") }
+	cm.dial(context.Background(), failing, due[0])
+	cm.dial(context.Background(), failing, due[1])
+
+	// c is next, the failed ones back off
+	due = cm.dueDials()
+	r.Len(due, 1)
+	r.True(due[0].feed.Equal(c.Id))
+	cm.dial(context.Background(), failing, due[0])
+
+	clock = clock.Add(time.Second)
+	due = cm.dueDials()
+	r.Len(due, 2)
+	for _, ka := range due {
+		cm.dial(context.Background(), failing, ka)
+	}
+
+	// the second failure doubles the wait
+	clock = clock.Add(time.Second)
+	r.Len(cm.dueDials(), 1, "only c, which failed once")
+	r.Equal(4*time.Second, cm.backoff(3))
+	r.Equal(4*time.Second, cm.backoff(10))
+
+	// a new address starts over
+	r.NoError(cm.AddAddress(shsAddrOf(t, b, 8009), "test"))
+	for _, k := range cm.State().Known {
+		if k.Feed.Equal(b.Id) {
+			r.Equal(0, k.Failures)
+		}
+	}
+}
@@ -0,0 +1,369 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/netwrap"
+	"go.cryptoscope.co/secretstream/secrethandshake"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// The defaults for the dial timeouts and the backoff of Options
+const (
+	DefaultDialTimeout      = 10 * time.Second
+	DefaultHandshakeTimeout = 10 * time.Second
+	DefaultDialBackoffMin   = 5 * time.Second
+	DefaultDialBackoffMax   = 30 * time.Minute
+)
+
+// DialFailure says how dialing a peer failed
+type DialFailure string
+
+const (
+	// DialRefused means nothing listens on the address or a firewall refuses the connection
+	DialRefused DialFailure = "refused"
+
+	// DialTimeout means the TCP connect or the secret-handshake took too long, typical for firewalls that drop packets
+	DialTimeout DialFailure = "timeout"
+
+	// DialWrongKey means the peer answered our challenge but hung up after we authenticated.
+	// That is what a peer does that has another key than the one we dialed, for instance because it rotated it.
+	DialWrongKey DialFailure = "wrong-key"
+
+	// DialRejected means the peer hung up before it answered our challenge or answered with garbage,
+	// for instance because it is on another network (app key).
+	DialRejected DialFailure = "rejected"
+
+	// DialOther is everything else, like unreachable networks or failed DNS lookups
+	DialOther DialFailure = "other"
+)
+
+// ErrBackingOff is returned by Connect for addresses that failed recently, until their backoff is over
+var ErrBackingOff = errors.New("network: backing off from address")
+
+// DialError is returned by Connect if dialing or the handshake failed
+type DialError struct {
+	Addr    net.Addr
+	Failure DialFailure
+	Err     error
+}
+
+func (de *DialError) Error() string {
+	return fmt.Sprintf("dialing %s failed (%s): %s", de.Addr, de.Failure, de.Err)
+}
+
+// Cause is for errors.Cause, which then returns the error of the dialer or the handshake
+func (de *DialError) Cause() error { return de.Err }
+
+func (de *DialError) Unwrap() error { return de.Err }
+
+// DialFailureOf returns how a dial failed if err is or wraps a DialError, and DialOther otherwise
+func DialFailureOf(err error) DialFailure {
+	var de *DialError
+	if errors.As(err, &de) {
+		return de.Failure
+	}
+	return DialOther
+}
+
+// challengeLength is the size of the answer of the server to our hello
+const challengeLength = 64
+
+// dialTrace follows a dial, to tell when the TCP connection was established and how far the handshake got
+type dialTrace struct {
+	handshakeTimeout time.Duration
+
+	connected chan struct{}
+	raw       net.Conn
+	deadline  time.Time
+	read      int64 // what the peer sent, atomically
+}
+
+// start is the first connection wrapper, it runs once TCP is connected
+func (dt *dialTrace) start(c net.Conn) (net.Conn, error) {
+	dt.raw = c
+	if dt.handshakeTimeout > 0 {
+		dt.deadline = time.Now().Add(dt.handshakeTimeout)
+		if err := c.SetDeadline(dt.deadline); err != nil {
+			return nil, errors.Wrap(err, "failed to set handshake deadline")
+		}
+	}
+	close(dt.connected)
+	return &countingConn{Conn: c, n: &dt.read}, nil
+}
+
+// countingConn counts the bytes read from it
+type countingConn struct {
+	net.Conn
+	n *int64
+}
+
+func (cc *countingConn) Read(b []byte) (int, error) {
+	n, err := cc.Conn.Read(b)
+	atomic.AddInt64(cc.n, int64(n))
+	return n, err
+}
+
+// classify sorts err into a DialFailure. It can only be called once the dialer returned.
+func (dt *dialTrace) classify(err error) DialFailure {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return DialTimeout
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return DialRefused
+	}
+	if dt.raw == nil {
+		return DialOther
+	}
+
+	// the handshake doesn't always keep the error of the connection
+	if !dt.deadline.IsZero() && !time.Now().Before(dt.deadline) {
+		return DialTimeout
+	}
+	read := atomic.LoadInt64(&dt.read)
+	_, badData := errors.Cause(err).(secrethandshake.ErrProtocol)
+	if read < challengeLength || (badData && read == challengeLength) {
+		return DialRejected
+	}
+	return DialWrongKey
+}
+
+type dialResult struct {
+	conn net.Conn
+	err  error
+}
+
+// dialerFor picks the proxy for onion addresses, and all others if the options say so, and the part of addr to dial
+func (n *node) dialerFor(addr net.Addr) (netwrap.Dialer, net.Addr, error) {
+	if onion := netwrap.GetAddr(addr, "onion"); onion != nil {
+		if n.proxyDialer == nil {
+			return nil, nil, errors.Errorf("can't dial onion address %s without a SOCKS5 proxy", onion)
+		}
+		return n.proxyDialer, onion, nil
+	}
+	tcp := netwrap.GetAddr(addr, "tcp")
+	if tcp == nil {
+		return nil, nil, errors.Errorf("no tcp address in %s", addr)
+	}
+	if n.proxyDialer != nil && n.opts.SOCKS5ProxyAll {
+		return n.proxyDialer, tcp, nil
+	}
+	return n.dialer, tcp, nil
+}
+
+// dial connects to addr, directly or through the proxy, and runs the wrappers, including the handshake, with the timeouts of the options.
+// The dialer runs on its own, so that connects that hang can be given up on.
+func (n *node) dial(ctx context.Context, addr net.Addr, wrappers ...netwrap.ConnWrapper) (net.Conn, error) {
+	dt := &dialTrace{
+		handshakeTimeout: n.opts.HandshakeTimeout,
+		connected:        make(chan struct{}),
+	}
+	wrappers = append([]netwrap.ConnWrapper{dt.start}, wrappers...)
+
+	dialer, target, err := n.dialerFor(addr)
+	if err != nil {
+		return nil, &DialError{Addr: addr, Failure: DialOther, Err: err}
+	}
+
+	done := make(chan dialResult, 1)
+	go func() {
+		conn, err := dialer(target, wrappers...)
+		done <- dialResult{conn, err}
+	}()
+
+	giveUp := func(err error) (net.Conn, error) {
+		go func() {
+			if res := <-done; res.conn != nil {
+				res.conn.Close()
+			}
+		}()
+		return nil, err
+	}
+
+	var connectTimeout <-chan time.Time
+	if n.opts.DialTimeout > 0 {
+		t := time.NewTimer(n.opts.DialTimeout)
+		defer t.Stop()
+		connectTimeout = t.C
+	}
+	connected := dt.connected
+	for {
+		select {
+		case res := <-done:
+			if res.err != nil {
+				if res.conn != nil {
+					res.conn.Close()
+				}
+				return nil, &DialError{Addr: addr, Failure: dt.classify(res.err), Err: res.err}
+			}
+			if err := dt.raw.SetDeadline(time.Time{}); err != nil {
+				res.conn.Close()
+				return nil, errors.Wrap(err, "failed to clear handshake deadline")
+			}
+			return res.conn, nil
+
+		case <-connected:
+			// the handshake deadline takes over
+			connected, connectTimeout = nil, nil
+
+		case <-connectTimeout:
+			return giveUp(&DialError{Addr: addr, Failure: DialTimeout, Err: errors.Errorf("no connection after %s", n.opts.DialTimeout)})
+
+		case <-ctx.Done():
+			return giveUp(ctx.Err())
+		}
+	}
+}
+
+// dialBackoffs remembers the addresses that failed to dial and when to try them again.
+// The wait doubles with every failure, up to max, and is jittered so that peers that went away together don't come back in lockstep.
+type dialBackoffs struct {
+	min, max time.Duration
+
+	now    func() time.Time
+	jitter func(time.Duration) time.Duration
+
+	mu    sync.Mutex
+	addrs map[string]*backoffState
+}
+
+type backoffState struct {
+	addr     net.Addr
+	failure  DialFailure
+	failures int
+	nextTry  time.Time
+	lastErr  error
+}
+
+func newDialBackoffs(min, max time.Duration) *dialBackoffs {
+	return &dialBackoffs{
+		min: min,
+		max: max,
+		now: time.Now,
+		jitter: func(d time.Duration) time.Duration {
+			return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+		},
+		addrs: make(map[string]*backoffState),
+	}
+}
+
+// check returns ErrBackingOff if addr shouldn't be dialed yet
+func (db *dialBackoffs) check(addr net.Addr) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	bs, has := db.addrs[addr.String()]
+	if !has || !db.now().Before(bs.nextTry) {
+		return nil
+	}
+	return errors.Wrapf(ErrBackingOff, "%s failed %d times (%s), next try in %s", addr, bs.failures, bs.failure, bs.nextTry.Sub(db.now()).Round(time.Second))
+}
+
+// failed notes a failure and returns how the address failed before and the state after it
+func (db *dialBackoffs) failed(addr net.Addr, failure DialFailure, err error) (DialFailure, backoffState) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	bs, has := db.addrs[addr.String()]
+	if !has {
+		bs = &backoffState{addr: addr}
+		db.addrs[addr.String()] = bs
+	}
+	before := bs.failure
+	bs.failures++
+	bs.failure = failure
+	bs.lastErr = err
+
+	wait := db.min
+	for i := 1; i < bs.failures && wait < db.max; i++ {
+		wait *= 2
+	}
+	if wait > db.max {
+		wait = db.max
+	}
+	bs.nextTry = db.now().Add(db.jitter(wait))
+	return before, *bs
+}
+
+// succeeded forgets the failures of addr
+func (db *dialBackoffs) succeeded(addr net.Addr) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	delete(db.addrs, addr.String())
+}
+
+func (db *dialBackoffs) reset(addr net.Addr) int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if addr == nil {
+		n := len(db.addrs)
+		db.addrs = make(map[string]*backoffState)
+		return n
+	}
+	if _, has := db.addrs[addr.String()]; !has {
+		return 0
+	}
+	delete(db.addrs, addr.String())
+	return 1
+}
+
+func (db *dialBackoffs) list() []ssb.DialBackoff {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	l := make([]ssb.DialBackoff, 0, len(db.addrs))
+	for _, bs := range db.addrs {
+		l = append(l, ssb.DialBackoff{
+			Addr:     bs.addr.String(),
+			Failure:  string(bs.failure),
+			Failures: bs.failures,
+			NextTry:  bs.nextTry,
+			LastErr:  bs.lastErr.Error(),
+		})
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].Addr < l[j].Addr })
+	return l
+}
+
+var _ ssb.DialBackoffs = (*node)(nil)
+
+// DialBackoffs returns the addresses that failed the last time they were dialed
+func (n *node) DialBackoffs() []ssb.DialBackoff {
+	return n.backoffs.list()
+}
+
+// ResetBackoff lets addr be dialed right away again, all addresses if it is nil
+func (n *node) ResetBackoff(addr net.Addr) int {
+	return n.backoffs.reset(addr)
+}
+
+// dialFailed backs off from addr and logs why it failed.
+// Changes in how an address fails are logged as info, to tell a peer that went offline from one that rotated its key.
+func (n *node) dialFailed(addr net.Addr, de *DialError) {
+	before, bs := n.backoffs.failed(addr, de.Failure, de.Err)
+	logger := level.Debug(n.log)
+	if before != de.Failure {
+		logger = level.Info(n.log)
+	}
+	logger.Log("event", "dial failed", "addr", addr.String(), "failure", de.Failure, "failures", bs.failures, "retry", bs.nextTry.Sub(n.backoffs.now()).Round(time.Second), "err", de.Err)
+
+	evt := ssb.GossipEvent{Event: ssb.GossipDialFailed, Addr: addr.String(), Dir: string(ssb.ConnOutgoing), Err: fmt.Sprintf("%s: %s", de.Failure, de.Err)}
+	if de.Failure == DialWrongKey || de.Failure == DialRejected {
+		evt.Event = ssb.GossipHandshakeFailed
+	}
+	if remote, err := ssb.GetFeedRefFromAddr(addr); err == nil {
+		evt.Peer = remote.Ref()
+	}
+	n.gossipEvent(evt)
+}
@@ -0,0 +1,158 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/netwrap"
+	"go.cryptoscope.co/secretstream"
+)
+
+func TestDialBackoffs(t *testing.T) {
+	r := require.New(t)
+
+	var clock = time.Unix(1000, 0)
+	db := newDialBackoffs(time.Second, 4*time.Second)
+	db.now = func() time.Time { return clock }
+	db.jitter = func(d time.Duration) time.Duration { return d }
+
+	a := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 8008}
+	b := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 8008}
+
+	r.NoError(db.check(a))
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		_, st := db.failed(a, DialTimeout, errors.New("slow"))
+		r.Equal(i+1, st.failures)
+		r.Equal(clock.Add(want), st.nextTry, "failure %d", i+1)
+	}
+	err := db.check(a)
+	r.Equal(ErrBackingOff, errors.Cause(err))
+	r.NoError(db.check(b), "only a is backed off")
+
+	clock = clock.Add(4 * time.Second)
+	r.NoError(db.check(a))
+
+	before, _ := db.failed(a, DialWrongKey, errors.New("eof"))
+	r.Equal(DialTimeout, before)
+	db.failed(b, DialRefused, errors.New("refused"))
+	l := db.list()
+	r.Len(l, 2)
+	r.Equal(a.String(), l[0].Addr)
+	r.Equal("wrong-key", l[0].Failure)
+	r.Equal(5, l[0].Failures)
+
+	r.Equal(1, db.reset(a))
+	r.NoError(db.check(a))
+	r.Equal(0, db.reset(a))
+	r.Equal(1, db.reset(nil))
+	r.Len(db.list(), 0)
+
+	// the jitter stays between half and all of the wait
+	db = newDialBackoffs(time.Second, time.Minute)
+	for i := 0; i < 100; i++ {
+		d := db.jitter(time.Second)
+		r.True(d >= time.Second/2 && d <= time.Second, "jitter out of range: %s", d)
+	}
+}
+
+func TestDialFailures(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	appKey := make([]byte, 32)
+	self, other, dialed := makeRandPubkey(t), makeRandPubkey(t), makeRandPubkey(t)
+
+	nw, err := New(Options{
+		Logger:           log.NewNopLogger(),
+		KeyPair:          self,
+		AppKey:           appKey,
+		HandshakeTimeout: 250 * time.Millisecond,
+	})
+	r.NoError(err)
+	n := nw.(*node)
+
+	// serve runs handle for every connection to a new listener
+	var listeners []net.Listener
+	defer func() {
+		for _, lis := range listeners {
+			lis.Close()
+		}
+	}()
+	serve := func(handle func(net.Conn)) net.Addr {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		r.NoError(err)
+		listeners = append(listeners, lis)
+		go func() {
+			for {
+				c, err := lis.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					handle(c)
+					c.Close()
+				}()
+			}
+		}()
+		return netwrap.WrapAddr(lis.Addr(), secretstream.Addr{PubKey: dialed.Id.PubKey()})
+	}
+
+	try := func(addr net.Addr) DialFailure {
+		n.ResetBackoff(nil)
+		err := n.Connect(ctx, addr)
+		r.Error(err)
+		return DialFailureOf(err)
+	}
+
+	closed := serve(func(net.Conn) {})
+	r.Equal(DialRejected, try(closed), "hung up right away")
+
+	silent := serve(func(c net.Conn) { io.Copy(ioutil.Discard, c) })
+	start := time.Now()
+	r.Equal(DialTimeout, try(silent))
+	r.True(time.Since(start) < 5*time.Second, "handshake timeout didn't apply")
+
+	// a server with another key than the one we dial can't read our authentication
+	srv, err := secretstream.NewServer(other.Pair, appKey)
+	r.NoError(err)
+	wrongKey := serve(func(c net.Conn) {
+		if sc, err := srv.ConnWrapper()(c); err == nil {
+			sc.Close()
+		}
+	})
+	r.Equal(DialWrongKey, try(wrongKey))
+
+	// another network doesn't answer the challenge
+	otherNet, err := secretstream.NewServer(dialed.Pair, append([]byte{1}, appKey[1:]...))
+	r.NoError(err)
+	rejecting := serve(func(c net.Conn) {
+		if sc, err := otherNet.ConnWrapper()(c); err == nil {
+			sc.Close()
+		}
+	})
+	r.Equal(DialRejected, try(rejecting))
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	r.NoError(err)
+	refused := netwrap.WrapAddr(lis.Addr(), secretstream.Addr{PubKey: dialed.Id.PubKey()})
+	lis.Close()
+	r.Equal(DialRefused, try(refused))
+
+	// the failure is remembered until it is reset
+	err = n.Connect(ctx, refused)
+	r.Equal(ErrBackingOff, errors.Cause(err))
+	backoffs := n.DialBackoffs()
+	r.Len(backoffs, 1)
+	r.Equal("refused", backoffs[0].Failure)
+	r.Equal(1, n.ResetBackoff(refused))
+	r.Equal(DialRefused, DialFailureOf(n.Connect(ctx, refused)))
+}
@@ -0,0 +1,103 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"sync"
+	"time"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// DefaultGossipLogSize is how many events a GossipLog keeps if the size isn't set
+const DefaultGossipLogSize = 256
+
+// GossipLog keeps the recent gossip events and passes new ones on to whoever follows it.
+// Followers that don't keep up miss events instead of holding up the connections they are about.
+type GossipLog struct {
+	mu     sync.Mutex
+	recent []ssb.GossipEvent // ring buffer, next is the oldest once it is full
+	next   int
+	full   bool
+
+	followers map[chan ssb.GossipEvent]struct{}
+}
+
+var (
+	_ ssb.GossipEventRecorder = (*GossipLog)(nil)
+	_ ssb.GossipEventStreamer = (*GossipLog)(nil)
+)
+
+// NewGossipLog returns a log that keeps the last size events, DefaultGossipLogSize if size isn't positive
+func NewGossipLog(size int) *GossipLog {
+	if size <= 0 {
+		size = DefaultGossipLogSize
+	}
+	return &GossipLog{
+		recent:    make([]ssb.GossipEvent, size),
+		followers: make(map[chan ssb.GossipEvent]struct{}),
+	}
+}
+
+// RecordGossipEvent adds evt to the log, with the current time if it has none
+func (gl *GossipLog) RecordGossipEvent(evt ssb.GossipEvent) {
+	if evt.Time.IsZero() {
+		evt.Time = time.Now()
+	}
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+	gl.recent[gl.next] = evt
+	gl.next++
+	if gl.next == len(gl.recent) {
+		gl.next, gl.full = 0, true
+	}
+	for ch := range gl.followers {
+		select {
+		case ch <- evt:
+		default:
+		}
+	}
+}
+
+// GossipEvents returns the recent events, oldest first, and the ones that come after them until cancel is called
+func (gl *GossipLog) GossipEvents() ([]ssb.GossipEvent, <-chan ssb.GossipEvent, func()) {
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+
+	var recent []ssb.GossipEvent
+	if gl.full {
+		recent = append(recent, gl.recent[gl.next:]...)
+	}
+	recent = append(recent, gl.recent[:gl.next]...)
+
+	ch := make(chan ssb.GossipEvent, 64)
+	gl.followers[ch] = struct{}{}
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			gl.mu.Lock()
+			delete(gl.followers, ch)
+			gl.mu.Unlock()
+			close(ch)
+		})
+	}
+	return recent, ch, cancel
+}
+
+// gossipEvent records evt if the network has a gossip log
+func (n *node) gossipEvent(evt ssb.GossipEvent) {
+	if n.opts.GossipLog != nil {
+		n.opts.GossipLog.RecordGossipEvent(evt)
+	}
+}
+
+var _ ssb.GossipEventStreamer = (*node)(nil)
+
+// GossipEvents streams the events of Options.GossipLog. Without one there are none.
+func (n *node) GossipEvents() ([]ssb.GossipEvent, <-chan ssb.GossipEvent, func()) {
+	if n.opts.GossipLog == nil {
+		ch := make(chan ssb.GossipEvent)
+		return nil, ch, func() {}
+	}
+	return n.opts.GossipLog.GossipEvents()
+}
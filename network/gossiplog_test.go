@@ -0,0 +1,47 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/ssb"
+)
+
+func TestGossipLog(t *testing.T) {
+	r := require.New(t)
+
+	gl := NewGossipLog(3)
+	recent, _, cancel := gl.GossipEvents()
+	r.Len(recent, 0)
+	cancel()
+
+	for i := 0; i < 5; i++ {
+		gl.RecordGossipEvent(ssb.GossipEvent{Event: ssb.GossipConnect, Addr: fmt.Sprint(i)})
+	}
+
+	// only the last three, oldest first
+	recent, live, cancel := gl.GossipEvents()
+	r.Len(recent, 3)
+	for i, evt := range recent {
+		r.Equal(fmt.Sprint(i+2), evt.Addr)
+		r.False(evt.Time.IsZero())
+	}
+
+	gl.RecordGossipEvent(ssb.GossipEvent{Event: ssb.GossipDisconnect, Addr: "5"})
+	evt := <-live
+	r.Equal(ssb.GossipDisconnect, evt.Event)
+	r.Equal("5", evt.Addr)
+
+	// followers that don't read don't block
+	for i := 0; i < 100; i++ {
+		gl.RecordGossipEvent(ssb.GossipEvent{Event: ssb.GossipConnect})
+	}
+
+	cancel()
+	cancel()
+	gl.RecordGossipEvent(ssb.GossipEvent{Event: ssb.GossipConnect})
+}
@@ -14,7 +14,6 @@ import (
 	"github.com/libp2p/go-reuseport"
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/ssb"
-	multiserver "go.mindeco.de/ssb-multiserver"
 )
 
 type Advertiser struct {
@@ -25,6 +24,14 @@ type Advertiser struct {
 
 	waitTime time.Duration
 	ticker   *time.Ticker
+	done     chan struct{}
+
+	// more listeners to announce, see advertisement
+	listeners []*net.TCPAddr
+
+	// if set, the websocket listener is announced as well
+	wsPort   int
+	wsSecure bool
 }
 
 func newPublicKeyString(keyPair *ssb.KeyPair) string {
@@ -42,10 +49,19 @@ func newAdvertisement(local *net.UDPAddr, keyPair *ssb.KeyPair) (string, error)
 
 	// crunchy way of making a https://github.com/ssbc/multiserver/
 	msg := fmt.Sprintf("net:%s~shs:%s", &withoutZone, newPublicKeyString(keyPair))
-	_, err := multiserver.ParseNetAddress([]byte(msg))
+	_, err := ParseNetAddress([]byte(msg))
 	return msg, err
 }
 
+// newWebsocketAddress makes the multiserver address of a websocket listener, like ws://192.168.1.2:8989~shs:<pubkey>
+func newWebsocketAddress(ip net.IP, port int, secure bool, keyPair *ssb.KeyPair) string {
+	scheme := "ws"
+	if secure {
+		scheme = "wss"
+	}
+	return fmt.Sprintf("%s://%s~shs:%s", scheme, net.JoinHostPort(ip.String(), strconv.Itoa(port)), newPublicKeyString(keyPair))
+}
+
 func NewAdvertiser(local net.Addr, keyPair *ssb.KeyPair) (*Advertiser, error) {
 
 	var udpAddr *net.UDPAddr
@@ -117,13 +133,13 @@ func (b *Advertiser) advertise() error {
 			return errors.Wrapf(err, "ssb: failed to resolve broadcast dest addr for advertiser: %s", dstStr)
 		}
 
-		msg, err := newAdvertisement(
-			localUDP,
-			b.keyPair,
-		)
+		msg, err := b.advertisement(localUDP)
 		if err != nil {
 			return err
 		}
+		if b.wsPort != 0 {
+			msg += ";" + newWebsocketAddress(localUDP.IP, b.wsPort, b.wsSecure, b.keyPair)
+		}
 		broadcastConn, err := reuseport.Dial("udp", localUDP.String(), remoteUDP.String())
 		if err != nil {
 			// err = errors.Wrap(err, "adv dial failed")
@@ -147,13 +163,51 @@ func (b *Advertiser) advertise() error {
 	return nil
 }
 
+// advertisement lists how peers can reach us at the interface address local: on its port,
+// on the ports of the other listeners that accept on all interfaces or on local itself,
+// and on the listeners bound to global IPv6 addresses.
+func (b *Advertiser) advertisement(local *net.UDPAddr) (string, error) {
+	msg, err := newAdvertisement(local, b.keyPair)
+	if err != nil {
+		return "", err
+	}
+	seen := map[string]bool{msg: true}
+	for _, l := range b.listeners {
+		var ip net.IP
+		switch {
+		case l.IP == nil || l.IP.IsUnspecified():
+			if l.IP != nil && isIPv4(l.IP) != isIPv4(local.IP) {
+				// 0.0.0.0 doesn't take IPv6 connections and next to other listeners [::] doesn't take IPv4 ones
+				continue
+			}
+			ip = local.IP
+		case l.IP.Equal(local.IP):
+			ip = local.IP
+		case !isIPv4(l.IP) && l.IP.IsGlobalUnicast():
+			ip = l.IP
+		default:
+			continue
+		}
+		part, err := newAdvertisement(&net.UDPAddr{IP: ip, Port: l.Port}, b.keyPair)
+		if err != nil {
+			return "", err
+		}
+		if !seen[part] {
+			seen[part] = true
+			msg += ";" + part
+		}
+	}
+	return msg, nil
+}
+
 func (b *Advertiser) Start() {
 	b.ticker = time.NewTicker(b.waitTime)
+	b.done = make(chan struct{})
 	// TODO: notice interface changes
 	// net.IPv6linklocalallnodes
 
-	go func() {
-		for range b.ticker.C {
+	go func(ticker *time.Ticker, done <-chan struct{}) {
+		for {
 			err := b.advertise()
 			if err != nil {
 				if !os.IsTimeout(err) {
@@ -161,12 +215,23 @@ func (b *Advertiser) Start() {
 					// log.Printf("tx adv err (%s)", err.Error())
 				}
 			}
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
 		}
-	}()
+	}(b.ticker, b.done)
 }
 
+// Stop ends the broadcasting, Start can be called again afterwards.
 func (b *Advertiser) Stop() {
 	if b.ticker != nil {
 		b.ticker.Stop()
+		b.ticker = nil
+	}
+	if b.done != nil {
+		close(b.done)
+		b.done = nil
 	}
 }
@@ -61,6 +61,35 @@ func TestNewAdvertisement(t *testing.T) {
 	}
 }
 
+func TestAdvertisementListeners(t *testing.T) {
+	r := require.New(t)
+
+	kp := makeTestPubKey(t)
+	key := newPublicKeyString(kp)
+	adv := &Advertiser{
+		keyPair: kp,
+		listeners: []*net.TCPAddr{
+			{IP: net.IPv6unspecified, Port: 8008},
+			{IP: net.IPv4zero, Port: 8009},
+			{IP: net.ParseIP("2001:db8::1"), Port: 8010},
+			{IP: net.ParseIP("192.168.1.2"), Port: 8011},
+			{IP: net.ParseIP("192.168.1.3"), Port: 8012},
+		},
+	}
+
+	msg, err := adv.advertisement(&net.UDPAddr{IP: net.ParseIP("192.168.1.2"), Port: 8008})
+	r.NoError(err)
+	r.Equal("net:192.168.1.2:8008~shs:"+key+
+		";net:192.168.1.2:8009~shs:"+key+
+		";net:[2001:db8::1]:8010~shs:"+key+
+		";net:192.168.1.2:8011~shs:"+key, msg)
+
+	msg, err = adv.advertisement(&net.UDPAddr{IP: net.ParseIP("fd00::2"), Port: 8008})
+	r.NoError(err)
+	r.Equal("net:[fd00::2]:8008~shs:"+key+
+		";net:[2001:db8::1]:8010~shs:"+key, msg)
+}
+
 func XTestBendTCPAddr(t *testing.T) {
 	r := require.New(t)
 
@@ -116,3 +145,13 @@ r.NoError(err)
 r.NoError(adv.Start(), "couldn't start 1")
 // r.NoError(adv2.Start(), "couldn't start 2")
 */
+
+func TestNewWebsocketAddress(t *testing.T) {
+	r := require.New(t)
+	pk := makeTestPubKey(t)
+
+	r.Equal("ws://192.168.1.2:8989~shs:LtQ3tOuLoeQFi5s/ic7U6wDBxWS3t2yxauc4/AwqfWc=",
+		newWebsocketAddress(net.ParseIP("192.168.1.2"), 8989, false, pk))
+	r.Equal("wss://[fe80::1]:443~shs:LtQ3tOuLoeQFi5s/ic7U6wDBxWS3t2yxauc4/AwqfWc=",
+		newWebsocketAddress(net.ParseIP("fe80::1"), 443, true, pk))
+}
@@ -3,6 +3,7 @@
 package network
 
 import (
+	"bytes"
 	"fmt"
 	"net"
 	"os"
@@ -17,6 +18,13 @@ import (
 	multiserver "go.mindeco.de/ssb-multiserver"
 )
 
+// RediscoverAfter is how long a peer that keeps announcing itself under the same address isn't passed on again.
+// JS peers announce every second, the dialer ignores peers it is connected to anyway.
+const RediscoverAfter = time.Minute
+
+// maxAdvertisementSize fits announcements with several addresses, like net and ws ones
+const maxAdvertisementSize = 1024
+
 type Discoverer struct {
 	local *ssb.KeyPair // to ignore our own
 
@@ -25,12 +33,22 @@ type Discoverer struct {
 
 	brLock    sync.Mutex
 	brodcasts map[int]chan net.Addr
+
+	// when a peer was last passed on and under which address, keyed by its public key
+	seenLock sync.Mutex
+	seen     map[string]seenPeer
+}
+
+type seenPeer struct {
+	addr string
+	at   time.Time
 }
 
 func NewDiscoverer(local *ssb.KeyPair) (*Discoverer, error) {
 	d := &Discoverer{
 		local:     local,
 		brodcasts: make(map[int]chan net.Addr),
+		seen:      make(map[string]seenPeer),
 	}
 	return d, d.start()
 }
@@ -68,51 +86,83 @@ func makePktConn(n string) (net.PacketConn, error) {
 }
 
 func (d *Discoverer) work(rx net.PacketConn) {
-
 	for {
 		rx.SetReadDeadline(time.Now().Add(time.Second * 1))
-		buf := make([]byte, 128)
+		buf := make([]byte, maxAdvertisementSize)
 		n, addr, err := rx.ReadFrom(buf)
 		if err != nil {
 			if !os.IsTimeout(err) {
-				// log.Printf("rx adv err, breaking (%s)", err.Error())
+				// closed by Stop
 				break
 			}
 			continue
 		}
 
-		buf = buf[:n] // strip of zero bytes
-		// log.Printf("dbg adv raw: %q", string(buf))
-		na, err := multiserver.ParseNetAddress(buf)
-		if err != nil {
-			// log.Println("rx adv err", err.Error())
-			// TODO: _could_ try to get key out if just ws://[::]~shs:... and dial pkt origin
+		ua, ok := addr.(*net.UDPAddr)
+		if !ok {
 			continue
 		}
-
-		if na.Ref.Equal(d.local.Id) {
+		wrappedAddr, ok := d.handle(buf[:n], ua, time.Now())
+		if !ok {
 			continue
 		}
 
-		ua := addr.(*net.UDPAddr)
-
-		// skip advertisments not from source
-		if !ua.IP.Equal(na.Addr.IP) {
-			continue
+		d.brLock.Lock()
+		for _, ch := range d.brodcasts {
+			ch <- wrappedAddr
 		}
+		d.brLock.Unlock()
+	}
+}
 
-		na.Addr.Zone = ua.Zone
+// handle parses an announcement that came from the address from.
+// It returns the address to dial if the announcement is from someone else and wasn't passed on recently.
+func (d *Discoverer) handle(pkt []byte, from *net.UDPAddr, now time.Time) (net.Addr, bool) {
+	na, ok := parseAdvertisement(pkt, from.IP)
+	if !ok {
+		return nil, false
+	}
 
-		// fmt.Printf("[localadv debug] %s (claimed:%s) %s\n", addr.String(), na.Addr.String(), na.Ref.Ref())
+	if na.Ref.Equal(d.local.Id) {
+		return nil, false
+	}
 
-		wrappedAddr := netwrap.WrapAddr(&na.Addr, secretstream.Addr{PubKey: na.Ref.PubKey()})
+	// skip advertisments not from source
+	if !from.IP.Equal(na.Addr.IP) {
+		return nil, false
+	}
+	na.Addr.Zone = from.Zone
+
+	key := na.Ref.Ref()
+	d.seenLock.Lock()
+	last, seen := d.seen[key]
+	if seen && last.addr == na.Addr.String() && now.Sub(last.at) < RediscoverAfter {
+		d.seenLock.Unlock()
+		return nil, false
+	}
+	d.seen[key] = seenPeer{addr: na.Addr.String(), at: now}
+	d.seenLock.Unlock()
 
-		d.brLock.Lock()
-		for _, ch := range d.brodcasts {
-			ch <- wrappedAddr
+	return netwrap.WrapAddr(&na.Addr, secretstream.Addr{PubKey: na.Ref.PubKey()}), true
+}
+
+// parseAdvertisement takes the net address of an announcement that is on the IP it came from, or the first one.
+// Peers with several transports or listeners separate them with ; like net:...~shs:...;net:[::1]:8008~shs:...;ws:...~shs:...
+func parseAdvertisement(pkt []byte, from net.IP) (*multiserver.NetAddress, bool) {
+	var first *multiserver.NetAddress
+	for _, part := range bytes.Split(bytes.TrimSpace(pkt), []byte(";")) {
+		na, err := ParseNetAddress(part)
+		if err != nil {
+			continue
+		}
+		if na.Addr.IP.Equal(from) {
+			return na, true
+		}
+		if first == nil {
+			first = na
 		}
-		d.brLock.Unlock()
 	}
+	return first, first != nil
 }
 
 func (d *Discoverer) Stop() {
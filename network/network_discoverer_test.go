@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/netwrap"
+	"go.cryptoscope.co/ssb"
+)
+
+func TestDiscovererHandle(t *testing.T) {
+	r := require.New(t)
+
+	local := makeRandPubkey(t)
+	other := makeTestPubKey(t)
+	d := &Discoverer{
+		local: local,
+		seen:  make(map[string]seenPeer),
+	}
+
+	from := &net.UDPAddr{IP: net.ParseIP("192.168.1.42"), Port: DefaultPort}
+	src := &net.UDPAddr{IP: net.ParseIP("192.168.1.42"), Port: 8008}
+	adv, err := newAdvertisement(src, other)
+	r.NoError(err)
+
+	own, err := newAdvertisement(src, local)
+	r.NoError(err)
+
+	now := time.Now()
+
+	// our own announcements
+	_, ok := d.handle([]byte(own), from, now)
+	r.False(ok)
+
+	// garbage
+	_, ok = d.handle([]byte("hello"), from, now)
+	r.False(ok)
+
+	// someone claiming another address
+	_, ok = d.handle([]byte(adv), &net.UDPAddr{IP: net.ParseIP("192.168.1.23"), Port: DefaultPort}, now)
+	r.False(ok)
+
+	addr, ok := d.handle([]byte(adv), from, now)
+	r.True(ok)
+	remote, err := ssb.GetFeedRefFromAddr(addr)
+	r.NoError(err)
+	r.True(remote.Equal(other.Id))
+
+	// repeated announcements are dropped for a while
+	_, ok = d.handle([]byte(adv), from, now.Add(time.Second))
+	r.False(ok)
+	_, ok = d.handle([]byte(adv), from, now.Add(RediscoverAfter+time.Second))
+	r.True(ok)
+
+	// unless the address changed
+	moved := &net.UDPAddr{IP: net.ParseIP("192.168.1.42"), Port: 8009}
+	adv2, err := newAdvertisement(moved, other)
+	r.NoError(err)
+	_, ok = d.handle([]byte(adv2), from, now.Add(RediscoverAfter+2*time.Second))
+	r.True(ok)
+
+	// several addresses, the net one is used
+	multi := "ws://192.168.1.42:8989~shs:" + newPublicKeyString(makeRandPubkey(t)) + ";"
+	kp := makeRandPubkey(t)
+	netAdv, err := newAdvertisement(src, kp)
+	r.NoError(err)
+	addr, ok = d.handle([]byte(multi+netAdv), from, now)
+	r.True(ok)
+	remote, err = ssb.GetFeedRefFromAddr(addr)
+	r.NoError(err)
+	r.True(remote.Equal(kp.Id))
+
+	// several listeners, the one on the address it came from is used
+	kp = makeRandPubkey(t)
+	v6Adv, err := newAdvertisement(&net.UDPAddr{IP: net.ParseIP("2001:db8::42"), Port: 8008}, kp)
+	r.NoError(err)
+	v4Adv, err := newAdvertisement(&net.UDPAddr{IP: net.ParseIP("192.168.1.42"), Port: 8009}, kp)
+	r.NoError(err)
+	addr, ok = d.handle([]byte(v6Adv+";"+v4Adv), from, now)
+	r.True(ok)
+	tcpAddr, ok := netwrap.GetAddr(addr, "tcp").(*net.TCPAddr)
+	r.True(ok)
+	r.Equal(8009, tcpAddr.Port)
+}
@@ -8,8 +8,10 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"net/http"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
@@ -33,15 +35,62 @@ type Options struct {
 	Dialer     netwrap.Dialer
 	ListenAddr net.Addr
 
+	// ExtraListenAddrs are accepted on as well, like an IPv6 address next to an IPv4 ListenAddr or a second port for a test network.
+	// All of them are advertised, GetListenAddr only returns ListenAddr.
+	ExtraListenAddrs []net.Addr
+
+	// SOCKS5Proxy is the address of a SOCKS5 proxy, like a local tor daemon, to dial onion addresses (see ParseAddress) through.
+	// With SOCKS5ProxyAll all other addresses are dialed through it, too. Onion addresses can't be dialed without it.
+	SOCKS5Proxy    string
+	SOCKS5ProxyAll bool
+
 	AdvertsSend      bool
 	AdvertsConnectTo bool
 
+	// WebsocketAddr is where to accept connections over websockets (ws://host:port~shs:key) for browsers and rooms.
+	// Nil disables it.
+	WebsocketAddr net.Addr
+
+	// WebsocketTLSCert and WebsocketTLSKey are the files to serve wss with.
+	// Leave them empty for plain ws, also behind a reverse proxy that terminates TLS.
+	WebsocketTLSCert string
+	WebsocketTLSKey  string
+
+	// DiscoveryFilter decides which locally discovered peers are dialed. All of them are if it is nil.
+	DiscoveryFilter func(*ssb.FeedRef) bool
+
+	// DialTimeout limits how long Connect waits for the TCP connection and HandshakeTimeout how long the secret-handshake can take after that.
+	// Zero means DefaultDialTimeout and DefaultHandshakeTimeout, negative no limit.
+	DialTimeout      time.Duration
+	HandshakeTimeout time.Duration
+
+	// DialBackoffMin and DialBackoffMax bound how long Connect refuses to dial an address after it failed.
+	// The wait doubles with every failure. Zero means DefaultDialBackoffMin and DefaultDialBackoffMax.
+	DialBackoffMin time.Duration
+	DialBackoffMax time.Duration
+
 	KeyPair     *ssb.KeyPair
 	AppKey      []byte
 	MakeHandler func(net.Conn) (muxrpc.Handler, error)
 
 	ConnTracker ssb.ConnTracker
 
+	// ConnAuthorizer is asked after the handshake if a peer may stay connected, CallAuthorizer for each of its calls.
+	// Either can be nil to allow everything. Connections with our own key are always allowed.
+	ConnAuthorizer ssb.ConnAuthorizer
+	CallAuthorizer ssb.CallAuthorizer
+
+	// Bandwidth limits how fast peers may send and receive, the zero value doesn't limit.
+	// The traffic of each peer is counted either way, see Traffic, and added to TrafficCounter with direction and class (followed or stranger) labels if it is set.
+	Bandwidth      BandwidthLimits
+	TrafficCounter metrics.Counter
+
+	// GossipLog gets the connects, disconnects and failed dials and handshakes, see GossipEvents
+	GossipLog *GossipLog
+
+	// AcceptLimits protect against peers that open lots of connections, see the type for the defaults
+	AcceptLimits AcceptLimits
+
 	// PreSecureWrappers are applied before the shs+boxstream wrapping takes place
 	// usefull for accessing the sycall.Conn to apply control options on the socket
 	BefreCryptoWrappers []netwrap.ConnWrapper
@@ -63,12 +112,16 @@ type node struct {
 	lisClose sync.Once
 
 	dialer        netwrap.Dialer
-	l             net.Listener
+	proxyDialer   netwrap.Dialer
+	ls            []net.Listener
 	localDiscovRx *Discoverer
 	localDiscovTx *Advertiser
+	wsSrv         *http.Server
 	secretServer  *secretstream.Server
 	secretClient  *secretstream.Client
 	connTracker   ssb.ConnTracker
+	backoffs      *dialBackoffs
+	acceptLimiter *acceptLimiter
 
 	beforeCryptoConnWrappers []netwrap.ConnWrapper
 	afterSecureConnWrappers  []netwrap.ConnWrapper
@@ -77,6 +130,11 @@ type node struct {
 
 	remotesLock sync.Mutex
 	remotes     map[string]muxrpc.Endpoint
+	listeners   map[string]net.Addr // which listener the incoming ones arrived on
+
+	trafficMu           sync.Mutex
+	traffic             map[string]*peerTraffic
+	globalIn, globalOut *bucket
 
 	edpWrapper func(muxrpc.Endpoint) muxrpc.Endpoint
 	evtCtr     metrics.Counter
@@ -86,8 +144,12 @@ type node struct {
 
 func New(opts Options) (ssb.Network, error) {
 	n := &node{
-		opts:    opts,
-		remotes: make(map[string]muxrpc.Endpoint),
+		opts:      opts,
+		remotes:   make(map[string]muxrpc.Endpoint),
+		listeners: make(map[string]net.Addr),
+		traffic:   make(map[string]*peerTraffic),
+		globalIn:  newBucket(opts.Bandwidth.Global.In),
+		globalOut: newBucket(opts.Bandwidth.Global.Out),
 	}
 
 	if opts.ConnTracker == nil {
@@ -102,6 +164,9 @@ func New(opts Options) (ssb.Network, error) {
 	} else {
 		n.dialer = netwrap.Dial
 	}
+	if opts.SOCKS5Proxy != "" {
+		n.proxyDialer = SOCKS5Dialer(opts.SOCKS5Proxy)
+	}
 
 	n.secretClient, err = secretstream.NewClient(opts.KeyPair.Pair, opts.AppKey)
 	if err != nil {
@@ -113,11 +178,42 @@ func New(opts Options) (ssb.Network, error) {
 		return nil, errors.Wrap(err, "error creating secretstream.Server")
 	}
 
+	if n.opts.DialTimeout == 0 {
+		n.opts.DialTimeout = DefaultDialTimeout
+	}
+	if n.opts.HandshakeTimeout == 0 {
+		n.opts.HandshakeTimeout = DefaultHandshakeTimeout
+	}
+	if n.opts.DialBackoffMin == 0 {
+		n.opts.DialBackoffMin = DefaultDialBackoffMin
+	}
+	if n.opts.DialBackoffMax == 0 {
+		n.opts.DialBackoffMax = DefaultDialBackoffMax
+	}
+	if n.opts.DialBackoffMin < 0 || n.opts.DialBackoffMax < n.opts.DialBackoffMin {
+		return nil, errors.Errorf("invalid dial backoff %s-%s", n.opts.DialBackoffMin, n.opts.DialBackoffMax)
+	}
+	n.backoffs = newDialBackoffs(n.opts.DialBackoffMin, n.opts.DialBackoffMax)
+	n.acceptLimiter = newAcceptLimiter(n.opts.AcceptLimits)
+
+	if (opts.WebsocketTLSCert == "") != (opts.WebsocketTLSKey == "") {
+		return nil, errors.New("websocket TLS needs both the certificate and the key file")
+	}
+
 	if n.opts.AdvertsSend {
 		n.localDiscovTx, err = NewAdvertiser(n.opts.ListenAddr, opts.KeyPair)
 		if err != nil {
 			return nil, errors.Wrap(err, "error creating Advertiser")
 		}
+		for _, extra := range opts.ExtraListenAddrs {
+			if tcpAddr, ok := extra.(*net.TCPAddr); ok {
+				n.localDiscovTx.listeners = append(n.localDiscovTx.listeners, tcpAddr)
+			}
+		}
+		if wsAddr, ok := opts.WebsocketAddr.(*net.TCPAddr); ok {
+			n.localDiscovTx.wsPort = wsAddr.Port
+			n.localDiscovTx.wsSecure = opts.WebsocketTLSCert != ""
+		}
 	}
 
 	if n.opts.AdvertsConnectTo {
@@ -185,13 +281,14 @@ func (n *node) GetAllEndpoints() []ssb.EndpointStat {
 			Addr:     remote,
 			Since:    durr,
 			Endpoint: edp,
+			Listener: n.listeners[ref],
 		})
 	}
 	return stats
 }
 
 // TODO: merge with conntracker
-func (n *node) addRemote(edp muxrpc.Endpoint) {
+func (n *node) addRemote(edp muxrpc.Endpoint, lis net.Addr) {
 	n.remotesLock.Lock()
 	defer n.remotesLock.Unlock()
 	r, err := ssb.GetFeedRefFromAddr(edp.Remote())
@@ -210,6 +307,11 @@ func (n *node) addRemote(edp muxrpc.Endpoint) {
 	// }
 	// replace with new
 	n.remotes[r.Ref()] = edp
+	if lis != nil {
+		n.listeners[r.Ref()] = lis
+	} else {
+		delete(n.listeners, r.Ref())
+	}
 }
 
 // TODO: merge with conntracker
@@ -221,9 +323,11 @@ func (n *node) removeRemote(edp muxrpc.Endpoint) {
 		panic(err)
 	}
 	delete(n.remotes, r.Ref())
+	delete(n.listeners, r.Ref())
 }
 
-func (n *node) handleConnection(ctx context.Context, origConn net.Conn, hws ...muxrpc.HandlerWrapper) {
+// handleConnection serves a new connection. lis is the address of the listener it arrived on, nil for the ones we dialed.
+func (n *node) handleConnection(ctx context.Context, origConn net.Conn, dir ssb.ConnDirection, lis net.Addr, hws ...muxrpc.HandlerWrapper) {
 	// TODO: overhaul events and logging levels
 	conn, err := n.applyConnWrappers(origConn)
 	if err != nil {
@@ -232,6 +336,21 @@ func (n *node) handleConnection(ctx context.Context, origConn net.Conn, hws ...m
 		return
 	}
 
+	remote, err := ssb.GetFeedRefFromAddr(conn.RemoteAddr())
+	if err != nil {
+		level.Warn(n.log).Log("conn", "no remote key", "err", err, "peer", conn.RemoteAddr())
+		conn.Close()
+		origConn.Close()
+		return
+	}
+	// before the tracker, denied peers don't take up connection slots
+	if err := n.authorizeConn(remote, dir); err != nil {
+		n.serveDenied(ctx, conn, err)
+		conn.Close()
+		origConn.Close()
+		return
+	}
+
 	ok, ctx := n.connTracker.OnAccept(ctx, conn)
 	if !ok {
 		err := conn.Close()
@@ -250,7 +369,20 @@ func (n *node) handleConnection(ctx context.Context, origConn net.Conn, hws ...m
 		n.evtCtr.With("event", "connection").Add(1)
 	}
 
-	h, err := n.opts.MakeHandler(conn)
+	n.gossipEvent(ssb.GossipEvent{Event: ssb.GossipConnect, Peer: remote.Ref(), Addr: conn.RemoteAddr().String(), Dir: string(dir)})
+	var serveErr error
+	defer func() {
+		evt := ssb.GossipEvent{Event: ssb.GossipDisconnect, Peer: remote.Ref(), Addr: conn.RemoteAddr().String(), Dir: string(dir)}
+		if serveErr != nil {
+			evt.Err = serveErr.Error()
+		}
+		n.gossipEvent(evt)
+	}()
+
+	mconn := n.meter(conn, remote)
+	defer mconn.Close()
+
+	h, err := n.opts.MakeHandler(mconn)
 	if err != nil {
 		if _, ok := errors.Cause(err).(*ssb.ErrOutOfReach); ok {
 			return // ignore silently
@@ -258,19 +390,21 @@ func (n *node) handleConnection(ctx context.Context, origConn net.Conn, hws ...m
 		level.Warn(n.log).Log("conn", "mkHandler", "err", err, "peer", conn.RemoteAddr())
 		return
 	}
+	h = n.authorizeCalls(remote, h)
+	h = dirHandler{Handler: h, dir: dir}
 
 	for _, hw := range hws {
 		h = hw(h)
 	}
 
-	pkr := muxrpc.NewPacker(conn)
+	pkr := muxrpc.NewPacker(mconn)
 	filtered := level.NewFilter(n.log, level.AllowInfo())
 	edp := muxrpc.HandleWithLogger(pkr, h, filtered)
 
 	if n.edpWrapper != nil {
 		edp = n.edpWrapper(edp)
 	}
-	n.addRemote(edp)
+	n.addRemote(edp, lis)
 
 	defer edp.Terminate()
 	srv := edp.(muxrpc.Server)
@@ -280,6 +414,7 @@ func (n *node) handleConnection(ctx context.Context, origConn net.Conn, hws ...m
 		causeErr := errors.Cause(err)
 		if !neterr.IsConnBrokenErr(causeErr) && causeErr != context.Canceled {
 			level.Debug(n.log).Log("conn", "serve", "err", err)
+			serveErr = err
 		}
 	}
 	n.removeRemote(edp)
@@ -289,21 +424,36 @@ func (n *node) handleConnection(ctx context.Context, origConn net.Conn, hws ...m
 // Canceling the passed context makes the function return. Defers take care of stopping these resources.
 func (n *node) Serve(ctx context.Context, wrappers ...muxrpc.HandlerWrapper) error {
 	evtLog := log.With(n.log, "event", "network.Serve")
-	// TODO: make multiple listeners (localhost:8008 should not restrict or kill connections)
-	lisWrap := netwrap.NewListenerWrapper(n.secretServer.Addr(), append(n.opts.BefreCryptoWrappers, n.secretServer.ConnWrapper())...)
-	var err error
-
-	n.l, err = netwrap.Listen(n.opts.ListenAddr, lisWrap)
-	if err != nil {
-
-		return errors.Wrap(err, "error creating listener")
+	n.ls = nil
+	addrs := append([]net.Addr{n.opts.ListenAddr}, n.opts.ExtraListenAddrs...)
+	for _, addr := range addrs {
+		if len(addrs) > 1 {
+			addr = v6only(addr)
+		}
+		// the handshake runs in accept and not in the listener, so that it can be limited
+		l, err := net.Listen(addr.Network(), addr.String())
+		if err != nil {
+			n.closeListeners()
+			return errors.Wrapf(err, "error creating listener on %s", addr)
+		}
+		n.ls = append(n.ls, shsListener{Listener: l, shs: n.secretServer.Addr()})
 	}
 	n.lisClose = sync.Once{} // reset once
+
+	if n.opts.WebsocketAddr != nil {
+		var err error
+		n.wsSrv, err = n.serveWebsocket(ctx, wrappers...)
+		if err != nil {
+			n.closeListeners()
+			return err
+		}
+		defer n.wsSrv.Close()
+	}
 	close(n.listening)
 
 	defer func() {
 		n.lisClose.Do(func() {
-			n.l.Close()
+			n.closeListeners()
 		})
 		n.listening = make(chan struct{})
 	}()
@@ -318,11 +468,25 @@ func (n *node) Serve(ctx context.Context, wrappers ...muxrpc.HandlerWrapper) err
 		ch, done := n.localDiscovRx.Notify()
 		defer done()
 		go func() {
+			sched, scheduled := n.opts.ConnTracker.(ssb.ConnScheduler)
 			for a := range ch {
 				if is, _ := n.connTracker.Active(a); is {
 					//n.log.Log("event", "debug", "msg", "ignoring active", "addr", a.String())
 					continue
 				}
+				if n.opts.DiscoveryFilter != nil {
+					remote, err := ssb.GetFeedRefFromAddr(a)
+					if err != nil || !n.opts.DiscoveryFilter(remote) {
+						continue
+					}
+				}
+				if scheduled {
+					// the scheduler dials when there is room
+					if err := sched.AddAddress(a, "discovery"); err != nil {
+						level.Debug(evtLog).Log("msg", "discovery schedule", "err", err, "addr", a.String())
+					}
+					continue
+				}
 				err := n.Connect(ctx, a)
 				if err == nil {
 					continue
@@ -341,49 +505,130 @@ func (n *node) Serve(ctx context.Context, wrappers ...muxrpc.HandlerWrapper) err
 		}()
 	}
 
-	// accept in a goroutine so that we can react to context cancel and close the listener
-	newConn := make(chan net.Conn)
+	// accept in goroutines so that we can react to context cancel and close the listeners
+	newConn := make(chan acceptedConn)
+	var accepting sync.WaitGroup
+	for _, l := range n.ls {
+		accepting.Add(1)
+		go func(l net.Listener) {
+			defer accepting.Done()
+			n.accept(ctx, l, newConn, evtLog)
+		}(l)
+	}
 	go func() {
-		defer close(newConn)
-		for {
-			conn, err := n.l.Accept()
-			if err != nil {
-				if strings.Contains(err.Error(), "use of closed network connection") {
-					// yikes way of handling this
-					// but means this needs to be restarted anyway
-					return
-				}
+		accepting.Wait()
+		close(newConn)
+	}()
 
-				switch cause := errors.Cause(err).(type) {
+	defer level.Debug(n.log).Log("event", "network listen loop exited")
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ac, ok := <-newConn:
+			if !ok {
+				return nil
+			}
+			go n.handleConnection(ctx, ac.conn, ssb.ConnIncoming, ac.lis, wrappers...)
+		}
+	}
+}
+
+// v6only makes IPv6 listeners, also on [::], only take IPv6 connections.
+// Otherwise they would take IPv4 ones as well and a listener on 0.0.0.0 with the same port couldn't be opened next to them.
+func v6only(addr net.Addr) net.Addr {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok && tcpAddr.IP != nil && !isIPv4(tcpAddr.IP) {
+		return tcp6Addr{tcpAddr}
+	}
+	return addr
+}
+
+type tcp6Addr struct{ *net.TCPAddr }
+
+func (tcp6Addr) Network() string { return "tcp6" }
+
+// shsListener is a listener whose address has our key, like the one of netwrap.Listen, but that doesn't do the handshake itself
+type shsListener struct {
+	net.Listener
+	shs net.Addr
+}
+
+func (l shsListener) Addr() net.Addr {
+	return netwrap.WrapAddr(l.Listener.Addr(), l.shs)
+}
+
+type acceptedConn struct {
+	conn net.Conn
+	lis  net.Addr
+}
+
+// accept does the handshakes with the connections of l and passes them on until it is closed.
+// The handshakes run concurrently, as many as the accept limits allow, so that a slow peer doesn't hold up the others.
+func (n *node) accept(ctx context.Context, l net.Listener, newConn chan<- acceptedConn, evtLog log.Logger) {
+	// the address the listener was asked for, like 0.0.0.0:8008, and not the one with the key
+	lis := netwrap.GetAddr(l.Addr(), "tcp")
+	if lis == nil {
+		lis = l.Addr()
+	}
+	wrappers := append(append([]netwrap.ConnWrapper{}, n.beforeCryptoConnWrappers...), n.secretServer.ConnWrapper())
+
+	// newConn is closed once all accept calls returned
+	var handshakes sync.WaitGroup
+	defer handshakes.Wait()
+	for {
+		raw, err := l.Accept()
+		if err != nil {
+			if strings.Contains(err.Error(), "use of closed network connection") {
+				// yikes way of handling this
+				// but means this needs to be restarted anyway
+				return
+			}
+			level.Warn(evtLog).Log("msg", "failed to accept connection", "err", err, "listener", lis)
+			continue
+		}
+
+		handshakes.Add(1)
+		go func(raw net.Conn) {
+			defer handshakes.Done()
+			conn, err := n.acceptHandshake(raw, raw.RemoteAddr(), wrappers)
+			if err != nil {
+				raw.Close()
+				cause := errors.Cause(err)
+				switch cause.(type) {
 				case secrethandshake.ErrProcessing:
 					// ignore
 				case secrethandshake.ErrProtocol:
 					// ignore
+				case net.Error:
+					// like the handshake timeout
+					level.Debug(evtLog).Log("msg", "handshake failed", "err", err, "listener", lis)
 				default:
-					if cause != io.EOF { // handshake ended early
-						level.Warn(evtLog).Log("msg", "failed to accept connection", "err", err,
+					if cause != io.EOF && cause != errThrottled { // handshake ended early or already logged
+						level.Warn(evtLog).Log("msg", "failed to accept connection", "err", err, "listener", lis,
 							"cause", cause, "causeT", fmt.Sprintf("%T", cause))
 					}
 				}
-				continue
+				return
 			}
 
-			newConn <- conn
-		}
-	}()
-
-	defer level.Debug(n.log).Log("event", "network listen loop exited")
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case conn := <-newConn:
-			if conn == nil {
-				return nil
+			select {
+			case newConn <- acceptedConn{conn: conn, lis: lis}:
+			case <-ctx.Done():
+				conn.Close()
 			}
-			go n.handleConnection(ctx, conn, wrappers...)
+		}(raw)
+	}
+}
+
+// closeListeners closes all listeners and returns the first error
+func (n *node) closeListeners() error {
+	var firstErr error
+	for _, l := range n.ls {
+		if err := l.Close(); err != nil && firstErr == nil {
+			firstErr = err
 		}
 	}
+	return firstErr
 }
 
 func (n *node) Connect(ctx context.Context, addr net.Addr) error {
@@ -404,26 +649,60 @@ func (n *node) Connect(ctx context.Context, addr net.Addr) error {
 		return errors.New("node/connect: expected shs-bs address to be of type secretstream.Addr")
 	}
 
-	conn, err := n.dialer(netwrap.GetAddr(addr, "tcp"), append(n.beforeCryptoConnWrappers,
-		n.secretClient.ConnWrapper(pubKey))...)
+	if err := n.backoffs.check(addr); err != nil {
+		return errors.Wrap(err, "node/connect")
+	}
+
+	wrappers := make([]netwrap.ConnWrapper, 0, len(n.beforeCryptoConnWrappers)+1)
+	wrappers = append(wrappers, n.beforeCryptoConnWrappers...)
+	wrappers = append(wrappers, n.secretClient.ConnWrapper(pubKey))
+	conn, err := n.dial(ctx, addr, wrappers...)
 	if err != nil {
-		if conn != nil {
-			conn.Close()
+		if de, ok := err.(*DialError); ok {
+			n.dialFailed(addr, de)
 		}
 		return errors.Wrap(err, "node/connect: error dialing")
 	}
+	n.backoffs.succeeded(addr)
 
 	go func(c net.Conn) {
-		n.handleConnection(ctx, c)
+		n.handleConnection(ctx, c, ssb.ConnOutgoing, nil)
 	}(conn)
 	return nil
 }
 
+// GetListenAddrs returns the addresses of all listeners, ListenAddr first. Like GetListenAddr it waits for Serve.
+func (n *node) GetListenAddrs() []net.Addr {
+	if n.GetListenAddr() == nil {
+		return nil
+	}
+	addrs := make([]net.Addr, len(n.ls))
+	for i, l := range n.ls {
+		addrs[i] = l.Addr()
+	}
+	return addrs
+}
+
+// BoundAddrs returns the addresses of all listeners like GetListenAddrs, with the ports they really got.
+// It doesn't wait for Serve but returns nil while the node isn't listening.
+func (n *node) BoundAddrs() []net.Addr {
+	select {
+	case <-n.listening:
+	default:
+		return nil
+	}
+	addrs := make([]net.Addr, len(n.ls))
+	for i, l := range n.ls {
+		addrs[i] = l.Addr()
+	}
+	return addrs
+}
+
 // GetListenAddr waits for Serve() to be called!
 func (n *node) GetListenAddr() net.Addr {
 	_, ok := <-n.listening
 	if !ok {
-		return n.l.Addr()
+		return n.ls[0].Addr()
 	}
 	level.Error(n.log).Log("msg", "listener not ready")
 	return nil
@@ -440,20 +719,32 @@ func (n *node) applyConnWrappers(conn net.Conn) (net.Conn, error) {
 	return conn, nil
 }
 
-func (n *node) Close() error {
+// StopAccepting closes the listeners and stops advertising the node but leaves the open connections alone
+func (n *node) StopAccepting() error {
 	if n.localDiscovTx != nil {
 		n.localDiscovTx.Stop()
 	}
 
-	if n.l != nil {
+	if n.wsSrv != nil {
+		n.wsSrv.Close()
+	}
+
+	if len(n.ls) > 0 {
 		var closeErr error
 		n.lisClose.Do(func() {
-			closeErr = n.l.Close()
+			closeErr = n.closeListeners()
 		})
 		if closeErr != nil && !strings.Contains(errors.Cause(closeErr).Error(), "use of closed network connection") {
 			return errors.Wrap(closeErr, "ssb: network node failed to close it's listener")
 		}
 	}
+	return nil
+}
+
+func (n *node) Close() error {
+	if err := n.StopAccepting(); err != nil {
+		return err
+	}
 
 	n.remotesLock.Lock()
 	defer n.remotesLock.Unlock()
@@ -0,0 +1,166 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/netwrap"
+)
+
+// SOCKS5Dialer dials addresses through the SOCKS5 proxy at proxyAddr, like the local tor daemon (usually localhost:9050).
+// Host names are sent to the proxy as they are, it resolves them. The connections have addr as their remote address.
+func SOCKS5Dialer(proxyAddr string) netwrap.Dialer {
+	return func(addr net.Addr, wrappers ...netwrap.ConnWrapper) (net.Conn, error) {
+		conn, err := net.Dial("tcp", proxyAddr)
+		if err != nil {
+			return nil, errors.Wrap(err, "socks5: failed to connect to proxy")
+		}
+		if err := socksConnect(conn, addr.String()); err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		var c net.Conn = proxiedConn{Conn: conn, remote: addr}
+		for i, cw := range wrappers {
+			wrapped, err := cw(c)
+			if err != nil {
+				c.Close()
+				return nil, errors.Wrapf(err, "error applying connection wrapper #%d", i)
+			}
+			c = wrapped
+		}
+		return c, nil
+	}
+}
+
+// proxiedConn is a connection through the proxy, to the peer at remote
+type proxiedConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (pc proxiedConn) RemoteAddr() net.Addr { return pc.remote }
+
+const (
+	socksVersion      = 5
+	socksNoAuth       = 0
+	socksCmdConnect   = 1
+	socksAtypIPv4     = 1
+	socksAtypDomain   = 3
+	socksAtypIPv6     = 4
+	socksReplySuccess = 0
+)
+
+// SOCKSError is a failure reported by the proxy
+type SOCKSError struct {
+	Code byte
+}
+
+var socksReplies = map[byte]string{
+	1: "general failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+func (se *SOCKSError) Error() string {
+	if msg, ok := socksReplies[se.Code]; ok {
+		return "socks5: " + msg
+	}
+	return fmt.Sprintf("socks5: unknown failure %d", se.Code)
+}
+
+// Timeout is true if the proxy gave up waiting for the peer, which is how tor reports onion services that don't answer
+func (se *SOCKSError) Timeout() bool { return se.Code == 6 }
+
+func (se *SOCKSError) Temporary() bool { return se.Code == 6 }
+
+// Is makes refused connections look like the ones we dial directly, for errors.Is
+func (se *SOCKSError) Is(target error) bool {
+	return se.Code == 5 && target == syscall.ECONNREFUSED
+}
+
+// socksConnect asks the proxy on conn to connect to hostPort (RFC 1928, without authentication)
+func socksConnect(conn net.Conn, hostPort string) error {
+	host, portStr, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return errors.Wrap(err, "socks5: invalid address")
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return errors.Errorf("socks5: invalid port %q", portStr)
+	}
+
+	if _, err := conn.Write([]byte{socksVersion, 1, socksNoAuth}); err != nil {
+		return errors.Wrap(err, "socks5: failed to send greeting")
+	}
+	var choice [2]byte
+	if _, err := io.ReadFull(conn, choice[:]); err != nil {
+		return errors.Wrap(err, "socks5: failed to read greeting")
+	}
+	if choice[0] != socksVersion || choice[1] != socksNoAuth {
+		return errors.Errorf("socks5: proxy wants authentication (method %d)", choice[1])
+	}
+
+	req := []byte{socksVersion, socksCmdConnect, 0}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(append(req, socksAtypIPv4), ip4...)
+		} else {
+			req = append(append(req, socksAtypIPv6), ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return errors.Errorf("socks5: host name too long")
+		}
+		req = append(append(req, socksAtypDomain, byte(len(host))), host...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return errors.Wrap(err, "socks5: failed to send connect request")
+	}
+
+	// version, reply, reserved, address type
+	var reply [4]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return errors.Wrap(err, "socks5: failed to read reply")
+	}
+	if reply[0] != socksVersion {
+		return errors.Errorf("socks5: unexpected version %d in reply", reply[0])
+	}
+	if reply[1] != socksReplySuccess {
+		return &SOCKSError{Code: reply[1]}
+	}
+
+	// skip the address the proxy bound to
+	var skip int
+	switch reply[3] {
+	case socksAtypIPv4:
+		skip = net.IPv4len
+	case socksAtypIPv6:
+		skip = net.IPv6len
+	case socksAtypDomain:
+		var l [1]byte
+		if _, err := io.ReadFull(conn, l[:]); err != nil {
+			return errors.Wrap(err, "socks5: failed to read reply")
+		}
+		skip = int(l[0])
+	default:
+		return errors.Errorf("socks5: unknown address type %d in reply", reply[3])
+	}
+	if _, err := io.CopyN(ioutil.Discard, conn, int64(skip+2)); err != nil {
+		return errors.Wrap(err, "socks5: failed to read reply")
+	}
+	return nil
+}
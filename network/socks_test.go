@@ -0,0 +1,203 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/muxrpc"
+	"go.cryptoscope.co/netwrap"
+	"go.cryptoscope.co/secretstream"
+)
+
+func TestParseAddress(t *testing.T) {
+	r := require.New(t)
+
+	kp := makeRandPubkey(t)
+	key := base64.StdEncoding.EncodeToString(kp.Id.PubKey())
+
+	addr, err := ParseAddress("onion:abcdefghijklmnop.onion:8008~shs:" + key)
+	r.NoError(err)
+	onion, ok := netwrap.GetAddr(addr, "onion").(*HostAddr)
+	r.True(ok, "not an onion address: %s", addr)
+	r.Equal("abcdefghijklmnop.onion:8008", onion.String())
+	shs, ok := netwrap.GetAddr(addr, "shs-bs").(secretstream.Addr)
+	r.True(ok)
+	r.Equal([]byte(kp.Id.PubKey()), shs.PubKey)
+
+	addr, err = ParseAddress("net:pub.example.org:8008~shs:" + key)
+	r.NoError(err)
+	named, ok := netwrap.GetAddr(addr, "tcp").(*HostAddr)
+	r.True(ok, "the name should be kept: %s", addr)
+	r.Equal("pub.example.org:8008", named.String())
+
+	addr, err = ParseAddress("net:10.0.0.1:8008~shs:" + key)
+	r.NoError(err)
+	_, ok = netwrap.GetAddr(addr, "tcp").(*net.TCPAddr)
+	r.True(ok, "IPs stay TCP addresses: %s", addr)
+
+	addr, err = ParseAddress("net:[2001:db8::1]:8008~shs:" + key)
+	r.NoError(err)
+	v6, ok := netwrap.GetAddr(addr, "tcp").(*net.TCPAddr)
+	r.True(ok, "IPv6 literals are TCP addresses: %s", addr)
+	r.Equal("[2001:db8::1]:8008", v6.String())
+
+	addr, err = ParseAddress("net:[fe80::1%eth0]:8008~shs:" + key)
+	r.NoError(err)
+	v6, ok = netwrap.GetAddr(addr, "tcp").(*net.TCPAddr)
+	r.True(ok, "IPv6 with zone: %s", addr)
+	r.Equal("eth0", v6.Zone)
+	r.Equal(8008, v6.Port)
+
+	// the first part we understand is used
+	addr, err = ParseAddress("foo:bar;onion:abcdefghijklmnop.onion:8008~shs:" + key)
+	r.NoError(err)
+	r.NotNil(netwrap.GetAddr(addr, "onion"))
+
+	for _, bad := range []string{
+		"onion:abcdefghijklmnop.onion:8008",
+		"onion:example.org:8008~shs:" + key,
+		"onion:abcdefghijklmnop.onion:0~shs:" + key,
+		"onion:abcdefghijklmnop.onion:8008~shs:nope",
+	} {
+		_, err := ParseAddress(bad)
+		r.Error(err, "parsed %s", bad)
+	}
+}
+
+// socksServer is a SOCKS5 proxy that connects every host to target, except refused.onion
+func socksServer(t *testing.T, target net.Addr, requested chan<- string) net.Listener {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		for {
+			c, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				var greeting [3]byte
+				if _, err := io.ReadFull(c, greeting[:]); err != nil {
+					return
+				}
+				c.Write([]byte{5, 0})
+
+				var req [5]byte
+				if _, err := io.ReadFull(c, req[:]); err != nil || req[3] != 3 {
+					return
+				}
+				host := make([]byte, req[4]+2)
+				if _, err := io.ReadFull(c, host); err != nil {
+					return
+				}
+				name := string(host[:req[4]])
+				requested <- name
+				if name == "refused.onion" {
+					c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				if binary.BigEndian.Uint16(host[req[4]:]) != 8008 {
+					return
+				}
+
+				up, err := net.Dial("tcp", target.String())
+				if err != nil {
+					return
+				}
+				defer up.Close()
+				c.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 1})
+				go io.Copy(up, c)
+				io.Copy(c, up)
+			}()
+		}
+	}()
+	return lis
+}
+
+func TestDialOnionThroughSOCKS5(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	appKey := make([]byte, 32)
+	self, pub := makeRandPubkey(t), makeRandPubkey(t)
+
+	// the onion service, a peer that only does the handshake
+	srv, err := secretstream.NewServer(pub.Pair, appKey)
+	r.NoError(err)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	r.NoError(err)
+	defer lis.Close()
+	go func() {
+		for {
+			c, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				sc, err := srv.ConnWrapper()(c)
+				if err != nil {
+					c.Close()
+					return
+				}
+				io.Copy(ioutil.Discard, sc)
+				sc.Close()
+			}()
+		}
+	}()
+
+	requested := make(chan string, 10)
+	proxy := socksServer(t, lis.Addr(), requested)
+	defer proxy.Close()
+
+	connected := make(chan net.Addr, 1)
+	nw, err := New(Options{
+		Logger:      log.NewNopLogger(),
+		KeyPair:     self,
+		AppKey:      appKey,
+		SOCKS5Proxy: proxy.Addr().String(),
+		MakeHandler: func(c net.Conn) (muxrpc.Handler, error) {
+			connected <- c.RemoteAddr()
+			return nil, errors.New("only testing the dial")
+		},
+	})
+	r.NoError(err)
+
+	key := base64.StdEncoding.EncodeToString(pub.Id.PubKey())
+	addr, err := ParseAddress("onion:abcdefghijklmnop.onion:8008~shs:" + key)
+	r.NoError(err)
+	r.NoError(nw.Connect(ctx, addr))
+	r.Equal("abcdefghijklmnop.onion", <-requested, "the proxy should resolve the name")
+
+	remote := <-connected
+	onion, ok := netwrap.GetAddr(remote, "onion").(*HostAddr)
+	r.True(ok, "remote address should be the onion address: %s", remote)
+	r.Equal("abcdefghijklmnop.onion:8008", onion.String())
+	formatted, ok := FormatAddress(remote, pub.Id)
+	r.True(ok)
+	r.Equal("onion:abcdefghijklmnop.onion:8008~shs:"+key, formatted)
+
+	refused, err := ParseAddress("onion:refused.onion:8008~shs:" + key)
+	r.NoError(err)
+	r.Equal(DialRefused, DialFailureOf(nw.Connect(ctx, refused)))
+
+	// without a proxy onion addresses can't be dialed
+	direct, err := New(Options{
+		Logger:  log.NewNopLogger(),
+		KeyPair: self,
+		AppKey:  appKey,
+	})
+	r.NoError(err)
+	err = direct.Connect(ctx, addr)
+	r.Error(err)
+	r.Equal(DialOther, DialFailureOf(err))
+}
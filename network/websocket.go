@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/muxrpc"
+	"go.cryptoscope.co/netwrap"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/websock"
+)
+
+// serveWebsocket accepts secret-handshake connections over websockets, for browsers and rooms.
+// Without the TLS files it serves plain ws, which is also what to use behind a reverse proxy that terminates TLS.
+// The connections end up in the same handler as the ones from the TCP listener.
+func (n *node) serveWebsocket(ctx context.Context, hws ...muxrpc.HandlerWrapper) (*http.Server, error) {
+	var tlsConf *tls.Config
+	if n.opts.WebsocketTLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(n.opts.WebsocketTLSCert, n.opts.WebsocketTLSKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "error loading websocket TLS certificate")
+		}
+		tlsConf = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
+	lis, err := net.Listen("tcp", n.opts.WebsocketAddr.String())
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating websocket listener")
+	}
+
+	wrappers := make([]netwrap.ConnWrapper, 0, len(n.beforeCryptoConnWrappers)+1)
+	wrappers = append(wrappers, n.beforeCryptoConnWrappers...)
+	wrappers = append(wrappers, n.secretServer.ConnWrapper())
+
+	srv := &http.Server{
+		TLSConfig: tlsConf,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			wsConn, err := websock.Upgrade(w, req)
+			if err != nil {
+				level.Debug(n.log).Log("event", "websocket upgrade failed", "err", err, "remote", req.RemoteAddr)
+				return
+			}
+
+			remote := wsConn.RemoteAddr()
+			if fwd := n.acceptLimiter.forwardedFor(req); fwd != nil {
+				remote = fwd
+			}
+			conn, err := n.acceptHandshake(wsConn, remote, wrappers)
+			if err != nil {
+				wsConn.Close()
+				level.Debug(n.log).Log("event", "websocket handshake failed", "err", err, "remote", req.RemoteAddr)
+				return
+			}
+			n.handleConnection(ctx, conn, ssb.ConnIncoming, n.opts.WebsocketAddr, hws...)
+		}),
+
+		// upgrades don't work over http/2
+		TLSNextProto: make(map[string]func(*http.Server, *tls.Conn, http.Handler)),
+	}
+
+	go func() {
+		var err error
+		if tlsConf != nil {
+			err = srv.ServeTLS(lis, "", "")
+		} else {
+			err = srv.Serve(lis)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			level.Warn(n.log).Log("event", "websocket server exited", "err", err)
+		}
+	}()
+	return srv, nil
+}
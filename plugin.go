@@ -30,6 +30,11 @@ type PluginManager interface {
 	MakeHandler(conn net.Conn) (muxrpc.Handler, error)
 }
 
+// PluginLister is implemented by plugin managers that can tell which methods their plugins serve
+type PluginLister interface {
+	Methods() []muxrpc.Method
+}
+
 type pluginManager struct {
 	regLock sync.Mutex // protects the map
 	plugins map[string]Plugin
@@ -48,6 +53,16 @@ func (pmgr *pluginManager) Register(p Plugin) {
 	pmgr.plugins[p.Method().String()] = p
 }
 
+func (pmgr *pluginManager) Methods() []muxrpc.Method {
+	pmgr.regLock.Lock()
+	defer pmgr.regLock.Unlock()
+	methods := make([]muxrpc.Method, 0, len(pmgr.plugins))
+	for _, p := range pmgr.plugins {
+		methods = append(methods, p.Method())
+	}
+	return methods
+}
+
 func (pmgr *pluginManager) MakeHandler(conn net.Conn) (muxrpc.Handler, error) {
 	// TODO: add authorization requirements check to plugin so we can call it here
 	// e.g. only allow some peers to make certain requests
@@ -4,6 +4,7 @@ package blobs
 
 import (
 	"context"
+	"encoding/json"
 
 	"github.com/cryptix/go/logging"
 	"github.com/pkg/errors"
@@ -11,11 +12,14 @@ import (
 	"go.cryptoscope.co/muxrpc"
 
 	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/blobstore"
 )
 
 type addHandler struct {
 	bs  ssb.BlobStore
 	log logging.Interface
+
+	limit int64 // 0 for blobstore.DefaultMaxSize
 }
 
 func (addHandler) HandleConnect(context.Context, muxrpc.Endpoint) {}
@@ -26,9 +30,31 @@ func (h addHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp mux
 		req.Type = "sink"
 	}
 
-	r := muxrpc.NewSourceReader(req.Stream)
+	// blobs bigger than the limit are refused, a call can only ask for a smaller max
+	maxSize := h.limit
+	if maxSize <= 0 {
+		maxSize = blobstore.DefaultMaxSize
+	}
+	var opts []addOpts
+	if err := json.Unmarshal(req.RawArgs, &opts); err == nil && len(opts) == 1 && opts[0].Max > 0 && opts[0].Max < maxSize {
+		maxSize = opts[0].Max
+	}
+
+	r := blobstore.LimitReader(muxrpc.NewSourceReader(req.Stream), maxSize)
 	ref, err := h.bs.Put(r)
-	checkAndLog(h.log, errors.Wrap(err, "error putting blob"))
+	if errors.Cause(err) == blobstore.ErrBlobTooBig {
+		req.Stream.CloseWithError(errors.Errorf("blob is bigger than %d bytes", maxSize))
+		return
+	} else if err != nil {
+		checkAndLog(h.log, errors.Wrap(err, "error putting blob"))
+		req.Stream.CloseWithError(errors.New("failed to store blob"))
+		return
+	}
 
 	req.Return(ctx, ref)
 }
+
+// addOpts are the optional arguments of blobs.add
+type addOpts struct {
+	Max int64 `json:"max"`
+}
@@ -4,6 +4,7 @@ package blobs
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/cryptix/go/logging"
 	"github.com/go-kit/kit/log/level"
@@ -18,16 +19,16 @@ import (
 /*
 blobs manifest.json except:
 "get": "source",
+"getSlice": "source",
 "add": "sink",
 "rm": "async",
 "ls": "source",
 "has": "async",
 "want": "async",
-"createWants": "source"
-
+"createWants": "source",
 "size": "async",
-"getSlice": "source",
-"meta": "async",
+"meta": "async"
+
 "push": "async",
 "changes": "source",
 */
@@ -43,18 +44,40 @@ func checkAndLog(log logging.Interface, err error) {
 	}
 }
 
+// SizeLimit is an option of NewMaster. It's the size in bytes of the biggest blob blobs.add stores,
+// the max of a call can only lower it. 0 means blobstore.DefaultMaxSize.
+type SizeLimit uint
+
+// New returns the blobs plugin for peers
 func New(log logging.Interface, self ssb.FeedRef, bs ssb.BlobStore, wm ssb.WantManager) ssb.Plugin {
+	return newPlugin(log, self, bs, wm, false, 0, nil)
+}
+
+// NewMaster returns the blobs plugin for the master connection.
+// It can also add blobs, list all our blobs with blobs.ls, learn who served a blob with blobs.meta and want blobs again.
+// With the Referrers option it also answers which messages link to a blob (blobs.refs) and which blobs nothing links to (blobs.orphans).
+func NewMaster(log logging.Interface, self ssb.FeedRef, bs ssb.BlobStore, wm ssb.WantManager, opts ...interface{}) ssb.Plugin {
+	var (
+		limit SizeLimit
+		refs  Referrers
+	)
+	for i, o := range opts {
+		switch v := o.(type) {
+		case SizeLimit:
+			limit = v
+		case Referrers:
+			refs = v
+		default:
+			level.Warn(log).Log("event", "unhandled blobs option", "i", i, "type", fmt.Sprintf("%T", o))
+		}
+	}
+	return newPlugin(log, self, bs, wm, true, limit, refs)
+}
+
+func newPlugin(log logging.Interface, self ssb.FeedRef, bs ssb.BlobStore, wm ssb.WantManager, master bool, limit SizeLimit, refs Referrers) ssb.Plugin {
 	rootHdlr := muxrpc.HandlerMux{}
 
 	// TODO: needs priv checks
-	// rootHdlr.Register(muxrpc.Method{"blobs", "add"}, addHandler{
-	// 	log: log,
-	// 	bs:  bs,
-	// })
-	// rootHdlr.Register(muxrpc.Method{"blobs", "list"}, listHandler{
-	// 	log: log,
-	// 	bs:  bs,
-	// })
 	// rootHdlr.Register(muxrpc.Method{"blobs", "rm"}, rmHandler{
 	// 	log: log,
 	// 	bs:  bs,
@@ -65,13 +88,24 @@ func New(log logging.Interface, self ssb.FeedRef, bs ssb.BlobStore, wm ssb.WantM
 			log: log,
 			bs:  bs,
 		}},
+		{muxrpc.Method{"blobs", "getSlice"}, getSliceHandler{
+			log: log,
+			bs:  bs,
+		}},
 		{muxrpc.Method{"blobs", "has"}, hasHandler{
 			log: log,
 			bs:  bs,
 		}},
+		{muxrpc.Method{"blobs", "size"}, sizeHandler{
+			log:    log,
+			bs:     bs,
+			wm:     wm,
+			master: master,
+		}},
 		{muxrpc.Method{"blobs", "want"}, wantHandler{
-			log: log,
-			wm:  wm,
+			log:    log,
+			wm:     wm,
+			master: master,
 		}},
 		{muxrpc.Method{"blobs", "createWants"}, &createWantsHandler{
 			log:     log,
@@ -81,6 +115,37 @@ func New(log logging.Interface, self ssb.FeedRef, bs ssb.BlobStore, wm ssb.WantM
 			sources: make(map[string]luigi.Source),
 		}},
 	}
+	if master {
+		hs = append(hs,
+			muxrpc.NamedHandler{muxrpc.Method{"blobs", "add"}, addHandler{
+				log:   log,
+				bs:    bs,
+				limit: int64(limit),
+			}},
+			muxrpc.NamedHandler{muxrpc.Method{"blobs", "ls"}, listHandler{
+				log: log,
+				bs:  bs,
+			}},
+			muxrpc.NamedHandler{muxrpc.Method{"blobs", "meta"}, sizeHandler{
+				log:    log,
+				bs:     bs,
+				wm:     wm,
+				master: true,
+			}},
+		)
+		if refs != nil {
+			hs = append(hs,
+				muxrpc.NamedHandler{muxrpc.Method{"blobs", "refs"}, refsHandler{
+					log:  log,
+					refs: refs,
+				}},
+				muxrpc.NamedHandler{muxrpc.Method{"blobs", "orphans"}, orphansHandler{
+					log:  log,
+					refs: refs,
+				}},
+			)
+		}
+	}
 	rootHdlr.RegisterAll(hs...)
 
 	return plugin{
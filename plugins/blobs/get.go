@@ -6,6 +6,7 @@ import (
 	"context"
 	"encoding/json"
 	"io"
+	"io/ioutil"
 	"time"
 
 	"github.com/go-kit/kit/log"
@@ -38,6 +39,7 @@ func (h getHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp mux
 
 	var wantedRef *ssb.BlobRef
 	var maxSize uint = blobstore.DefaultMaxSize
+	var offset int64
 
 	var justTheRef []ssb.BlobRef
 	if err := json.Unmarshal(req.RawArgs, &justTheRef); err != nil {
@@ -51,7 +53,17 @@ func (h getHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp mux
 			return
 		}
 		wantedRef = withSize[0].Key
-		maxSize = withSize[0].Max
+		if wantedRef == nil {
+			wantedRef = withSize[0].Hash
+		}
+		if wantedRef == nil {
+			req.Stream.CloseWithError(errors.New("bad request - missing key"))
+			return
+		}
+		if withSize[0].Max > 0 {
+			maxSize = withSize[0].Max
+		}
+		offset = withSize[0].Offset
 	} else {
 		if len(justTheRef) != 1 {
 			req.Stream.CloseWithError(errors.New("bad request"))
@@ -72,6 +84,11 @@ func (h getHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp mux
 		return
 	}
 
+	if offset < 0 || offset > sz {
+		req.Stream.CloseWithError(errors.New("bad request - offset outside of the blob"))
+		return
+	}
+
 	logger = log.With(logger, "blob", wantedRef.ShortRef())
 	info := level.Info(logger)
 	errLog = level.Error(logger)
@@ -82,6 +99,22 @@ func (h getHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp mux
 		checkAndLog(errLog, errors.Wrap(err, "error closing stream with error"))
 		return
 	}
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	// the rest of an interrupted fetch
+	if offset > 0 {
+		if seeker, ok := r.(io.Seeker); ok {
+			_, err = seeker.Seek(offset, io.SeekStart)
+		} else {
+			_, err = io.CopyN(ioutil.Discard, r, offset)
+		}
+		if err != nil {
+			req.Stream.CloseWithError(errors.Wrap(err, "failed to skip to offset"))
+			return
+		}
+	}
 	start := time.Now()
 
 	w := muxrpc.NewSinkWriter(req.Stream)
@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: MIT
+
+package blobs
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+
+	"github.com/cryptix/go/logging"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+
+	"go.cryptoscope.co/muxrpc"
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/blobstore"
+)
+
+type getSliceHandler struct {
+	bs  ssb.BlobStore
+	log logging.Interface
+}
+
+func (getSliceHandler) HandleConnect(context.Context, muxrpc.Endpoint) {}
+
+func (h getSliceHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
+	logger := log.With(h.log, "handler", "getSlice")
+	errLog := level.Error(logger)
+
+	if req.Type == "" {
+		req.Type = "source"
+	}
+
+	var args []blobstore.GetSlice
+	if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+		req.Stream.CloseWithError(errors.Wrap(err, "bad request - invalid json"))
+		return
+	}
+	if len(args) != 1 || args[0].Key == nil {
+		req.Stream.CloseWithError(errors.New("bad request"))
+		return
+	}
+	slice := args[0]
+	if slice.Start < 0 || (slice.End != 0 && slice.End < slice.Start) {
+		req.Stream.CloseWithError(errors.New("bad request - invalid range"))
+		return
+	}
+
+	r, err := h.bs.Get(slice.Key)
+	if err != nil {
+		err = req.Stream.CloseWithError(errors.New("do not have blob"))
+		checkAndLog(errLog, errors.Wrap(err, "error closing stream with error"))
+		return
+	}
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	if seeker, ok := r.(io.Seeker); ok {
+		_, err = seeker.Seek(slice.Start, io.SeekStart)
+	} else {
+		_, err = io.CopyN(ioutil.Discard, r, slice.Start)
+	}
+	if err != nil && err != io.EOF {
+		req.Stream.CloseWithError(errors.Wrap(err, "failed to skip to start"))
+		return
+	}
+	if slice.End > 0 {
+		r = io.LimitReader(r, slice.End-slice.Start)
+	}
+
+	w := muxrpc.NewSinkWriter(req.Stream)
+	_, err = io.Copy(w, r)
+	checkAndLog(errLog, errors.Wrap(err, "error sending blob slice"))
+
+	err = w.Close()
+	checkAndLog(errLog, errors.Wrap(err, "error closing blob output"))
+}
@@ -4,6 +4,7 @@ package blobs
 
 import (
 	"context"
+	"encoding/json"
 	"io/ioutil"
 	"os"
 	"strings"
@@ -13,6 +14,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"go.cryptoscope.co/luigi"
 	"go.cryptoscope.co/muxrpc"
+	"go.cryptoscope.co/muxrpc/codec"
 
 	"go.cryptoscope.co/ssb"
 	"go.cryptoscope.co/ssb/blobstore"
@@ -136,3 +138,183 @@ func TestReplicate(t *testing.T) {
 		os.RemoveAll(srcPath)
 	}
 }
+
+func TestGetSlice(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	srcRepo, srcPath := test.MakeEmptyPeer(t)
+	dstRepo, dstPath := test.MakeEmptyPeer(t)
+
+	srcKP, err := repo.DefaultKeyPair(srcRepo)
+	r.NoError(err)
+
+	srcBS, err := repo.OpenBlobStore(srcRepo)
+	r.NoError(err, "error src opening blob store")
+
+	srcLog := kitlog.With(kitlog.NewSyncLogger(kitlog.NewLogfmtLogger(os.Stderr)), "node", "src/alice")
+	srcWM := blobstore.NewWantManager(srcLog, srcBS)
+
+	pkr1, pkr2, _, serve := test.PrepareConnectAndServe(t, srcRepo, dstRepo)
+
+	// rpc2 is the master of the bot, blobs.ls is only for it
+	pi1 := NewMaster(srcLog, *srcKP.Id, srcBS, srcWM)
+
+	ref, err := srcBS.Put(strings.NewReader("0123456789"))
+	r.NoError(err, "error putting blob at src")
+
+	rpc1 := muxrpc.Handle(pkr1, pi1.Handler())
+	rpc2 := muxrpc.Handle(pkr2, &muxrpc.HandlerMux{})
+	finish := serve(rpc1, rpc2)
+
+	getSlice := func(start, end int64) (string, error) {
+		src, err := rpc2.Source(ctx, codec.Body{}, muxrpc.Method{"blobs", "getSlice"}, blobstore.GetSlice{Key: ref, Start: start, End: end})
+		if err != nil {
+			return "", err
+		}
+		data, err := ioutil.ReadAll(muxrpc.NewSourceReader(src))
+		return string(data), err
+	}
+
+	got, err := getSlice(2, 5)
+	r.NoError(err)
+	r.Equal("234", got)
+
+	got, err = getSlice(7, 0)
+	r.NoError(err)
+	r.Equal("789", got)
+
+	got, err = getSlice(20, 30)
+	r.NoError(err)
+	r.Equal("", got)
+
+	_, err = getSlice(5, 2)
+	r.Error(err)
+
+	finish()
+
+	if !t.Failed() {
+		os.RemoveAll(dstPath)
+		os.RemoveAll(srcPath)
+	}
+}
+
+func TestSizeAndHas(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	srcRepo, srcPath := test.MakeEmptyPeer(t)
+	dstRepo, dstPath := test.MakeEmptyPeer(t)
+
+	srcKP, err := repo.DefaultKeyPair(srcRepo)
+	r.NoError(err)
+
+	srcBS, err := repo.OpenBlobStore(srcRepo)
+	r.NoError(err, "error src opening blob store")
+
+	srcLog := kitlog.With(kitlog.NewSyncLogger(kitlog.NewLogfmtLogger(os.Stderr)), "node", "src/alice")
+	srcWM := blobstore.NewWantManager(srcLog, srcBS)
+
+	pkr1, pkr2, _, serve := test.PrepareConnectAndServe(t, srcRepo, dstRepo)
+
+	pi1 := New(srcLog, *srcKP.Id, srcBS, srcWM)
+
+	ref, err := srcBS.Put(strings.NewReader("0123456789"))
+	r.NoError(err, "error putting blob at src")
+	missing := &ssb.BlobRef{Hash: make([]byte, 32), Algo: ssb.RefAlgoBlobSSB1}
+
+	rpc1 := muxrpc.Handle(pkr1, pi1.Handler())
+	rpc2 := muxrpc.Handle(pkr2, &muxrpc.HandlerMux{})
+	finish := serve(rpc1, rpc2)
+
+	for _, m := range []string{"size", "meta"} {
+		v, err := rpc2.Async(ctx, BlobMeta{}, muxrpc.Method{"blobs", m}, ref.Ref())
+		r.NoError(err, m)
+		r.Equal(BlobMeta{Size: 10}, v, m)
+	}
+
+	_, err = rpc2.Async(ctx, BlobMeta{}, muxrpc.Method{"blobs", "size"}, missing.Ref())
+	r.Error(err)
+
+	v, err := rpc2.Async(ctx, true, muxrpc.Method{"blobs", "has"}, ref.Ref())
+	r.NoError(err)
+	r.Equal(true, v)
+
+	v, err = rpc2.Async(ctx, []bool{}, muxrpc.Method{"blobs", "has"}, []string{missing.Ref(), ref.Ref()})
+	r.NoError(err)
+	r.Equal([]bool{false, true}, v)
+
+	_, err = rpc2.Async(ctx, []bool{}, muxrpc.Method{"blobs", "has"}, []string{ref.Ref(), "nope"})
+	r.Error(err)
+
+	src, err := rpc2.Source(ctx, json.RawMessage{}, muxrpc.Method{"blobs", "ls"})
+	r.NoError(err)
+	var listed []string
+	for {
+		v, err := src.Next(ctx)
+		if luigi.IsEOS(err) {
+			break
+		}
+		r.NoError(err)
+		var listedRef string
+		r.NoError(json.Unmarshal(v.(json.RawMessage), &listedRef))
+		listed = append(listed, listedRef)
+	}
+	r.Equal([]string{ref.Ref()}, listed)
+
+	finish()
+
+	if !t.Failed() {
+		os.RemoveAll(dstPath)
+		os.RemoveAll(srcPath)
+	}
+}
+
+// peers can't list our blobs or see who served them, only the master can
+func TestListMasterOnly(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	srcRepo, srcPath := test.MakeEmptyPeer(t)
+	dstRepo, dstPath := test.MakeEmptyPeer(t)
+
+	srcKP, err := repo.DefaultKeyPair(srcRepo)
+	r.NoError(err)
+
+	srcBS, err := repo.OpenBlobStore(srcRepo)
+	r.NoError(err, "error src opening blob store")
+
+	srcLog := kitlog.With(kitlog.NewSyncLogger(kitlog.NewLogfmtLogger(os.Stderr)), "node", "src/alice")
+	srcWM := blobstore.NewWantManager(srcLog, srcBS)
+
+	pkr1, pkr2, _, serve := test.PrepareConnectAndServe(t, srcRepo, dstRepo)
+
+	pi1 := New(srcLog, *srcKP.Id, srcBS, srcWM)
+
+	ref, err := srcBS.Put(strings.NewReader("0123456789"))
+	r.NoError(err, "error putting blob at src")
+
+	rpc1 := muxrpc.Handle(pkr1, pi1.Handler())
+	rpc2 := muxrpc.Handle(pkr2, &muxrpc.HandlerMux{})
+	finish := serve(rpc1, rpc2)
+
+	v, err := rpc2.Async(ctx, BlobMeta{}, muxrpc.Method{"blobs", "size"}, ref.Ref())
+	r.NoError(err)
+	r.Equal(BlobMeta{Size: 10}, v)
+	_, err = rpc2.Async(ctx, BlobMeta{}, muxrpc.Method{"blobs", "meta"}, ref.Ref())
+	r.Error(err)
+
+	src, err := rpc2.Source(ctx, json.RawMessage{}, muxrpc.Method{"blobs", "ls"})
+	if err == nil {
+		_, err = src.Next(ctx)
+	}
+	r.Error(err)
+	r.False(luigi.IsEOS(err), "listed nothing instead of refusing")
+
+	finish()
+
+	if !t.Failed() {
+		os.RemoveAll(dstPath)
+		os.RemoveAll(srcPath)
+	}
+}
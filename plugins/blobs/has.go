@@ -43,7 +43,7 @@ func (h hasHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp mux
 			return
 		}
 
-		_, err = h.bs.Get(ref)
+		_, err = h.bs.Size(ref)
 
 		has := true
 
@@ -70,12 +70,12 @@ func (h hasHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp mux
 				return
 			}
 			ref, err := ssb.ParseBlobRef(blobStr)
-			checkAndLog(h.log, errors.Wrap(err, "error parsing blob reference"))
 			if err != nil {
+				req.Stream.CloseWithError(errors.Wrapf(err, "error parsing blob reference %d", k))
 				return
 			}
 
-			_, err = h.bs.Get(ref)
+			_, err = h.bs.Size(ref)
 
 			has[k] = true
 
@@ -4,6 +4,7 @@ package blobs
 
 import (
 	"context"
+	"time"
 
 	"github.com/cryptix/go/logging"
 	"github.com/pkg/errors"
@@ -26,6 +27,45 @@ func (h listHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp mu
 		req.Type = "source"
 	}
 
-	err := luigi.Pump(ctx, req.Stream, h.bs.List())
-	checkAndLog(h.log, errors.Wrap(err, "error listing blobs"))
+	var meta bool
+	if args := req.Args(); len(args) > 0 {
+		if opts, ok := args[0].(map[string]interface{}); ok {
+			meta, _ = opts["meta"].(bool)
+		}
+	}
+
+	if !meta {
+		err := luigi.Pump(ctx, req.Stream, h.bs.List())
+		checkAndLog(h.log, errors.Wrap(err, "error listing blobs"))
+		return
+	}
+
+	// like the js implementation, with the time in milliseconds
+	src := h.bs.ListMeta()
+	for {
+		v, err := src.Next(ctx)
+		if luigi.IsEOS(err) {
+			break
+		} else if err != nil {
+			checkAndLog(h.log, errors.Wrap(req.Stream.CloseWithError(err), "error listing blobs"))
+			return
+		}
+		m := v.(ssb.BlobMeta)
+		err = req.Stream.Pour(ctx, listMeta{
+			ID:   m.Ref.Ref(),
+			Size: m.Size,
+			TS:   m.Added.UnixNano() / int64(time.Millisecond),
+		})
+		if err != nil {
+			checkAndLog(h.log, errors.Wrap(err, "error sending blob meta"))
+			return
+		}
+	}
+	checkAndLog(h.log, errors.Wrap(req.Stream.Close(), "error closing list stream"))
+}
+
+type listMeta struct {
+	ID   string `json:"id"`
+	Size int64  `json:"size"`
+	TS   int64  `json:"ts"`
 }
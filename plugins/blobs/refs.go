@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: MIT
+
+package blobs
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cryptix/go/logging"
+	"github.com/pkg/errors"
+
+	"go.cryptoscope.co/muxrpc"
+	"go.cryptoscope.co/ssb"
+)
+
+// Referrers is an option of NewMaster. It finds the messages that link to our blobs from the blobRefs index,
+// for blobs.refs and blobs.orphans.
+type Referrers interface {
+	BlobReferrers(*ssb.BlobRef) ([]*ssb.MessageRef, error)
+	BlobOrphans() ([]*ssb.BlobRef, error)
+}
+
+type refsHandler struct {
+	refs Referrers
+	log  logging.Interface
+}
+
+func (refsHandler) HandleConnect(context.Context, muxrpc.Endpoint) {}
+
+// HandleCall returns the keys of the messages that link to the blob
+func (h refsHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
+	if req.Type == "" {
+		req.Type = "async"
+	}
+
+	args := req.Args()
+	if len(args) != 1 {
+		req.Stream.CloseWithError(fmt.Errorf("bad request - wrong args"))
+		return
+	}
+	refStr, ok := args[0].(string)
+	if !ok {
+		req.Stream.CloseWithError(fmt.Errorf("bad request - unhandled type"))
+		return
+	}
+	ref, err := ssb.ParseBlobRef(refStr)
+	if err != nil {
+		req.Stream.CloseWithError(errors.Wrap(err, "error parsing blob reference"))
+		return
+	}
+
+	referrers, err := h.refs.BlobReferrers(ref)
+	if err != nil {
+		checkAndLog(h.log, req.Stream.CloseWithError(errors.Wrap(err, "error looking up referrers")))
+		return
+	}
+	keys := make([]string, len(referrers))
+	for i, mr := range referrers {
+		keys[i] = mr.Ref()
+	}
+	err = req.Return(ctx, keys)
+	checkAndLog(h.log, errors.Wrap(err, "error returning value"))
+}
+
+type orphansHandler struct {
+	refs Referrers
+	log  logging.Interface
+}
+
+func (orphansHandler) HandleConnect(context.Context, muxrpc.Endpoint) {}
+
+// HandleCall sends the stored blobs that no message links to
+func (h orphansHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
+	if req.Type == "" {
+		req.Type = "source"
+	}
+
+	orphans, err := h.refs.BlobOrphans()
+	if err != nil {
+		checkAndLog(h.log, req.Stream.CloseWithError(errors.Wrap(err, "error looking up orphans")))
+		return
+	}
+	for _, ref := range orphans {
+		if err := req.Stream.Pour(ctx, ref.Ref()); err != nil {
+			checkAndLog(h.log, errors.Wrap(err, "error sending orphan"))
+			return
+		}
+	}
+	checkAndLog(h.log, errors.Wrap(req.Stream.Close(), "error closing orphans stream"))
+}
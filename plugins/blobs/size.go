@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: MIT
+
+package blobs
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cryptix/go/logging"
+	"github.com/pkg/errors"
+
+	"go.cryptoscope.co/muxrpc"
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/blobstore"
+)
+
+// BlobMeta is what blobs.size and blobs.meta return for a blob we have.
+// From is the peer we recently fetched it from, if we know it. Only the master connection gets it.
+type BlobMeta struct {
+	Size int64  `json:"size"`
+	From string `json:"from,omitempty"`
+}
+
+type sizeHandler struct {
+	bs     ssb.BlobStore
+	wm     ssb.WantManager
+	log    logging.Interface
+	master bool // who served the blob is nobody else's business
+}
+
+func (sizeHandler) HandleConnect(context.Context, muxrpc.Endpoint) {}
+
+func (h sizeHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
+	if req.Type == "" {
+		req.Type = "async"
+	}
+
+	if len(req.Args()) != 1 {
+		req.Stream.CloseWithError(fmt.Errorf("bad request - wrong args"))
+		return
+	}
+
+	refStr, ok := req.Args()[0].(string)
+	if !ok {
+		req.Stream.CloseWithError(fmt.Errorf("bad request - unhandled type"))
+		return
+	}
+
+	ref, err := ssb.ParseBlobRef(refStr)
+	if err != nil {
+		req.Stream.CloseWithError(errors.Wrap(err, "error parsing blob reference"))
+		return
+	}
+
+	sz, err := h.bs.Size(ref)
+	if err != nil {
+		if err == blobstore.ErrNoSuchBlob {
+			err = errors.Errorf("do not have blob %s", ref.Ref())
+		} else {
+			err = errors.Wrap(err, "error looking up blob")
+		}
+		checkAndLog(h.log, req.Stream.CloseWithError(err))
+		return
+	}
+
+	meta := BlobMeta{Size: sz}
+	if from, ok := blobstore.ServedBy(h.wm, ref); ok && h.master {
+		meta.From = from.Ref()
+	}
+	err = req.Return(ctx, meta)
+	checkAndLog(h.log, errors.Wrap(err, "error returning value"))
+}
@@ -11,11 +11,20 @@ import (
 	"go.cryptoscope.co/muxrpc"
 
 	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/blobstore"
 )
 
+// WantArgs are the optional second argument of blobs.want.
+// Again sends the want to all connected peers again, also to the ones that were already asked.
+// Only the master connection can do that, for peers it is the same as a plain want.
+type WantArgs struct {
+	Again bool `json:"again"`
+}
+
 type wantHandler struct {
-	wm  ssb.WantManager
-	log logging.Interface
+	wm     ssb.WantManager
+	log    logging.Interface
+	master bool
 }
 
 func (wantHandler) HandleConnect(context.Context, muxrpc.Endpoint) {}
@@ -28,7 +37,7 @@ func (h wantHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp mu
 	}
 
 	args := req.Args()
-	if len(args) != 1 {
+	if len(args) != 1 && len(args) != 2 {
 		// TODO: change from generic handlers to typed once (source, sink, async..)
 		// async then would have to return a value or an error and not fall into this trap of not closing a stream
 		req.Stream.CloseWithError(fmt.Errorf("bad request - wrong args (%d)", len(args)))
@@ -42,7 +51,21 @@ func (h wantHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp mu
 		return
 	}
 
-	err = h.wm.Want(br)
+	var again bool
+	if len(args) == 2 {
+		opts, ok := args[1].(map[string]interface{})
+		if !ok {
+			checkAndLog(h.log, errors.Wrap(req.CloseWithError(errors.Errorf("bad request - unhandled want options: %T", args[1])), "error returning error"))
+			return
+		}
+		again, _ = opts["again"].(bool)
+	}
+
+	if again && h.master {
+		err = blobstore.Rewant(h.wm, br)
+	} else {
+		err = h.wm.Want(br)
+	}
 	err = errors.Wrap(err, "error wanting blob reference")
 	checkAndLog(h.log, errors.Wrap(req.Return(ctx, err), "error returning error"))
 }
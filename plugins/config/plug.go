@@ -0,0 +1,39 @@
+// SPDX-License-Identifier: MIT
+
+// Package config serves the config method, which returns the configuration a bot runs with
+package config
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/muxrpc"
+
+	"go.cryptoscope.co/ssb"
+)
+
+type Plugin struct {
+	cfg ssb.Configurer
+}
+
+// New returns the config plugin. It should only be registered for the master, the config tells a lot about the bot.
+func New(cfg ssb.Configurer) *Plugin {
+	return &Plugin{cfg: cfg}
+}
+
+func (lt Plugin) Name() string            { return "config" }
+func (Plugin) Method() muxrpc.Method      { return muxrpc.Method{"config"} }
+func (lt Plugin) Handler() muxrpc.Handler { return lt }
+
+func (g Plugin) HandleConnect(ctx context.Context, e muxrpc.Endpoint) {}
+
+func (g Plugin) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
+	cfg, err := g.cfg.Config()
+	if err != nil {
+		req.CloseWithError(errors.Wrap(err, "config: failed to get configuration"))
+		return
+	}
+	if err := req.Return(ctx, cfg); err != nil {
+		req.CloseWithError(errors.Wrap(err, "config: failed to return configuration"))
+	}
+}
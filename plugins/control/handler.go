@@ -6,31 +6,33 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 
 	"github.com/cryptix/go/logging"
 	"github.com/go-kit/kit/log/level"
 	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
 	"go.cryptoscope.co/muxrpc"
-	"go.cryptoscope.co/netwrap"
-	"go.cryptoscope.co/secretstream"
 	"go.cryptoscope.co/ssb/internal/muxmux"
-	multiserver "go.mindeco.de/ssb-multiserver"
 
 	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/network"
 )
 
 type handler struct {
-	node ssb.Network
-	repl ssb.Replicator
+	node  ssb.Network
+	repl  ssb.Replicator
+	sched ssb.ConnScheduler
 
 	info logging.Interface
 }
 
-func New(i logging.Interface, n ssb.Network, r ssb.Replicator) muxrpc.Handler {
+func New(i logging.Interface, n ssb.Network, r ssb.Replicator, cs ssb.ConnScheduler) muxrpc.Handler {
 	h := &handler{
-		info: i,
-		node: n,
-		repl: r,
+		info:  i,
+		node:  n,
+		repl:  r,
+		sched: cs,
 	}
 
 	mux := muxmux.New(i)
@@ -40,6 +42,13 @@ func New(i logging.Interface, n ssb.Network, r ssb.Replicator) muxrpc.Handler {
 
 	mux.RegisterAsync(muxrpc.Method{"ctrl", "replicate"}, unmarshalActionMap(h.replicate))
 	mux.RegisterAsync(muxrpc.Method{"ctrl", "block"}, unmarshalActionMap(h.block))
+	mux.RegisterAsync(muxrpc.Method{"ctrl", "sticky"}, unmarshalActionMap(h.sticky))
+
+	mux.RegisterAsync(muxrpc.Method{"ctrl", "backoffs"}, muxmux.AsyncFunc(h.backoffs))
+	mux.RegisterAsync(muxrpc.Method{"ctrl", "resetBackoff"}, muxmux.AsyncFunc(h.resetBackoff))
+	mux.RegisterAsync(muxrpc.Method{"ctrl", "announce"}, muxmux.AsyncFunc(h.announce))
+
+	mux.RegisterSource(muxrpc.Method{"ctrl", "gossipLog"}, muxmux.SourceFunc(h.gossipLog))
 	return &mux
 }
 
@@ -107,6 +116,16 @@ func (h *handler) block(ctx context.Context, m actionMap) error {
 	return nil
 }
 
+func (h *handler) sticky(ctx context.Context, m actionMap) error {
+	if h.sched == nil {
+		return errors.New("ctrl.sticky: the bot doesn't manage its connections")
+	}
+	for ref, do := range m {
+		h.sched.SetSticky(ref, do)
+	}
+	return nil
+}
+
 func (h *handler) disconnect(ctx context.Context, r *muxrpc.Request) (interface{}, error) {
 	h.node.GetConnTracker().CloseAll()
 	return "disconencted", nil
@@ -121,14 +140,131 @@ func (h *handler) connect(ctx context.Context, req *muxrpc.Request) (interface{}
 	if !ok {
 		return nil, errors.Errorf("ctrl.connect call: expected argument to be string, got %T", req.Args()[0])
 	}
-	msaddr, err := multiserver.ParseNetAddress([]byte(dest))
+	addr, err := network.ParseAddress(dest)
 	if err != nil {
 		return nil, errors.Wrapf(err, "ctrl.connect call: failed to parse input: %s", dest)
 	}
+	remote, err := ssb.GetFeedRefFromAddr(addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "ctrl.connect call: no key in %s", dest)
+	}
 
-	wrappedAddr := netwrap.WrapAddr(&msaddr.Addr, secretstream.Addr{PubKey: msaddr.Ref.PubKey()})
-	level.Info(h.info).Log("event", "doing gossip.connect", "remote", msaddr.Ref.ShortRef())
+	if h.sched != nil {
+		// also redial it later
+		if err := h.sched.AddAddress(addr, "manual"); err != nil {
+			return nil, errors.Wrapf(err, "ctrl.connect call: failed to schedule %q", dest)
+		}
+	}
+	level.Info(h.info).Log("event", "doing gossip.connect", "remote", remote.ShortRef())
 	// TODO: add context to tracker to cancel connections
-	err = h.node.Connect(context.Background(), wrappedAddr)
-	return nil, errors.Wrapf(err, "ctrl.connect call: error connecting to %q", msaddr.Addr)
+	err = h.node.Connect(context.Background(), addr)
+	return nil, errors.Wrapf(err, "ctrl.connect call: error connecting to %q", dest)
+}
+
+func (h *handler) dialBackoffs() (ssb.DialBackoffs, error) {
+	dbs, ok := h.node.(ssb.DialBackoffs)
+	if !ok {
+		return nil, errors.New("ctrl: the network doesn't back off from failed dials")
+	}
+	return dbs, nil
+}
+
+// backoffs returns the addresses that failed to dial, how and when they are tried again
+func (h *handler) backoffs(ctx context.Context, req *muxrpc.Request) (interface{}, error) {
+	dbs, err := h.dialBackoffs()
+	if err != nil {
+		return nil, err
+	}
+	return dbs.DialBackoffs(), nil
+}
+
+// resetBackoff lets the address (host:port:key) be dialed right away, without one all of them
+func (h *handler) resetBackoff(ctx context.Context, req *muxrpc.Request) (interface{}, error) {
+	dbs, err := h.dialBackoffs()
+	if err != nil {
+		return nil, err
+	}
+	var addr net.Addr
+	switch len(req.Args()) {
+	case 0:
+	case 1:
+		dest, ok := req.Args()[0].(string)
+		if !ok {
+			return nil, errors.Errorf("ctrl.resetBackoff: expected argument to be string, got %T", req.Args()[0])
+		}
+		var err error
+		addr, err = network.ParseAddress(dest)
+		if err != nil {
+			return nil, errors.Wrapf(err, "ctrl.resetBackoff: failed to parse input: %s", dest)
+		}
+	default:
+		return nil, errors.New("usage: ctrl.resetBackoff [host:port:key]")
+	}
+	return fmt.Sprintf("reset %d addresses", dbs.ResetBackoff(addr)), nil
+}
+
+// announce publishes a pub message with the address (host:port), the configured one without an argument.
+// Nothing is published if it is the address we announced last.
+func (h *handler) announce(ctx context.Context, req *muxrpc.Request) (interface{}, error) {
+	pa, ok := h.repl.(ssb.PubAnnouncer)
+	if !ok {
+		return nil, errors.New("ctrl.announce: the bot can't announce its address")
+	}
+	var addr string
+	switch len(req.Args()) {
+	case 0:
+	case 1:
+		addr, ok = req.Args()[0].(string)
+		if !ok {
+			return nil, errors.Errorf("ctrl.announce: expected argument to be string, got %T", req.Args()[0])
+		}
+	default:
+		return nil, errors.New("usage: ctrl.announce [host:port]")
+	}
+	ref, err := pa.AnnouncePub(addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "ctrl.announce")
+	}
+	if ref == nil {
+		return "unchanged", nil
+	}
+	return ref.Ref(), nil
+}
+
+// gossipLog streams the recent connection and replication events, and the ones after them with {live: true}
+func (h *handler) gossipLog(ctx context.Context, req *muxrpc.Request, snk luigi.Sink) error {
+	gs, ok := h.node.(ssb.GossipEventStreamer)
+	if !ok {
+		return errors.New("ctrl.gossipLog: the network doesn't log gossip events")
+	}
+	var args []struct {
+		Live bool `json:"live"`
+	}
+	if len(req.RawArgs) > 0 {
+		if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+			return errors.Wrap(err, "ctrl.gossipLog: bad arguments")
+		}
+	}
+	live := len(args) > 0 && args[0].Live
+
+	recent, evts, cancel := gs.GossipEvents()
+	defer cancel()
+	for _, evt := range recent {
+		if err := snk.Pour(ctx, evt); err != nil {
+			return errors.Wrap(err, "ctrl.gossipLog: failed to send event")
+		}
+	}
+	if !live {
+		return snk.Close()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return snk.Close()
+		case evt := <-evts:
+			if err := snk.Pour(ctx, evt); err != nil {
+				return errors.Wrap(err, "ctrl.gossipLog: failed to send event")
+			}
+		}
+	}
 }
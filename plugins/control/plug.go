@@ -12,8 +12,9 @@ type connectPlug struct {
 	h muxrpc.Handler
 }
 
-func NewPlug(i logging.Interface, n ssb.Network, r ssb.Replicator) ssb.Plugin {
-	return &connectPlug{h: New(i, n, r)}
+// NewPlug returns the ctrl plugin. cs can be nil if the bot doesn't manage its connections.
+func NewPlug(i logging.Interface, n ssb.Network, r ssb.Replicator, cs ssb.ConnScheduler) ssb.Plugin {
+	return &connectPlug{h: New(i, n, r, cs)}
 }
 
 func (p connectPlug) Name() string {
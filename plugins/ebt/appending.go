@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: MIT
+
+package ebt
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/margaret"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/mutil"
+)
+
+// feedAppends serializes the appends of the messages of one feed
+type feedAppends struct {
+	mu sync.Mutex
+
+	// the latest message appended, as long as the sublog of the feed doesn't have it yet
+	latest ssb.Message
+}
+
+// Appending locks feed for appending its messages, so that two connections that get the same message don't both store it.
+// The ebt sessions use it and so do the createHistoryStream fetches the Replicator is passed to.
+// It returns the latest message of feed that is stored or about to be, nil if there is none.
+// Once the caller is done it has to call done with the message it appended, or nil if it didn't.
+func (r *Replicator) Appending(feed *ssb.FeedRef) (latest ssb.Message, done func(appended ssb.Message), err error) {
+	r.appendsMu.Lock()
+	fa, ok := r.appends[feed.Ref()]
+	if !ok {
+		fa = &feedAppends{}
+		r.appends[feed.Ref()] = fa
+	}
+	r.appendsMu.Unlock()
+
+	fa.mu.Lock()
+	stored, err := r.latestMessage(feed)
+	if err != nil {
+		fa.mu.Unlock()
+		return nil, nil, err
+	}
+	// the indexes update after the append, the sublog can lag behind it for a moment
+	if fa.latest != nil && (stored == nil || fa.latest.Seq() > stored.Seq()) {
+		stored = fa.latest
+	} else {
+		fa.latest = nil
+	}
+	done = func(appended ssb.Message) {
+		if appended != nil && (fa.latest == nil || appended.Seq() > fa.latest.Seq()) {
+			fa.latest = appended
+		}
+		fa.mu.Unlock()
+	}
+	return stored, done, nil
+}
+
+// latestMessage returns the latest message of feed in the sublog, nil if there is none
+func (r *Replicator) latestMessage(feed *ssb.FeedRef) (ssb.Message, error) {
+	userLog, err := r.userFeeds.Get(feed.StoredAddr())
+	if err != nil {
+		return nil, errors.Wrap(err, "ebt: failed to open sublog of feed")
+	}
+	latest, err := latestOf(userLog)
+	if err != nil || latest == 0 {
+		return nil, err
+	}
+	v, err := mutil.Indirect(r.rootLog, userLog).Get(margaret.BaseSeq(latest - 1))
+	if err != nil {
+		return nil, errors.Wrap(err, "ebt: failed to get latest message")
+	}
+	msg, ok := v.(ssb.Message)
+	if !ok {
+		return nil, errors.Errorf("ebt: wrong message type %T", v)
+	}
+	return msg, nil
+}
@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: MIT
+
+package ebt
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// Note is what a peer says about one feed in an ebt.replicate stream.
+//
+// On the wire it is a single number, like the JS implementation encodes it:
+// -1 means the peer doesn't replicate the feed at all.
+// Otherwise the sequence it has is shifted left by one and the lowest bit is set if it does NOT want to receive the feed over this connection.
+// So 0 is "replicate, send me everything" and 1 is "replicate, but not from you", both for a feed the peer has nothing of.
+type Note struct {
+	// Seq is the latest message of the feed the peer has, 0 for none
+	Seq int64
+
+	// Replicate is false if the peer isn't interested in the feed
+	Replicate bool
+
+	// Receive is true if the peer wants the messages of the feed from us
+	Receive bool
+}
+
+func (n Note) MarshalJSON() ([]byte, error) {
+	if !n.Replicate {
+		return []byte("-1"), nil
+	}
+	if n.Seq < 0 {
+		return nil, errors.Errorf("ebt: invalid note sequence %d", n.Seq)
+	}
+	v := n.Seq << 1
+	if !n.Receive {
+		v |= 1
+	}
+	return []byte(strconv.FormatInt(v, 10)), nil
+}
+
+func (n *Note) UnmarshalJSON(data []byte) error {
+	// the js side has no integers, be lenient about 4.0 and friends
+	var f float64
+	if err := json.Unmarshal(data, &f); err != nil {
+		return errors.Wrap(err, "ebt: note is not a number")
+	}
+	v := int64(f)
+	if float64(v) != f {
+		return errors.Errorf("ebt: note is not an integer: %s", data)
+	}
+	if v < 0 {
+		*n = Note{}
+		return nil
+	}
+	n.Replicate = true
+	n.Receive = v&1 == 0
+	n.Seq = v >> 1
+	return nil
+}
+
+// NetworkFrontier is a set of notes by feed reference, the non-message values of an ebt.replicate stream
+type NetworkFrontier map[string]Note
+
+// UnmarshalJSON skips entries that aren't feed references, other implementations might send formats we don't know
+func (nf *NetworkFrontier) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return errors.Wrap(err, "ebt: frontier is not an object")
+	}
+	fr := make(NetworkFrontier, len(raw))
+	for feed, v := range raw {
+		if _, err := ssb.ParseFeedRef(feed); err != nil {
+			continue
+		}
+		var n Note
+		if err := n.UnmarshalJSON(v); err != nil {
+			return errors.Wrapf(err, "ebt: bad note for %s", feed)
+		}
+		fr[feed] = n
+	}
+	*nf = fr
+	return nil
+}
+
+// isMessage tells signed messages apart from notes, which both are objects on the stream
+func isMessage(raw map[string]json.RawMessage) bool {
+	_, hasAuthor := raw["author"]
+	_, hasSig := raw["signature"]
+	return hasAuthor && hasSig
+}
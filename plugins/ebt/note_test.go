@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: MIT
+
+package ebt
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNoteEncoding(t *testing.T) {
+	r := require.New(t)
+
+	for _, tc := range []struct {
+		note Note
+		wire string
+	}{
+		{Note{}, "-1"},
+		{Note{Replicate: true, Receive: true}, "0"},
+		{Note{Replicate: true}, "1"},
+		{Note{Seq: 1, Replicate: true, Receive: true}, "2"},
+		{Note{Seq: 23, Replicate: true}, "47"},
+		{Note{Seq: 1 << 40, Replicate: true, Receive: true}, "2199023255552"},
+	} {
+		got, err := json.Marshal(tc.note)
+		r.NoError(err)
+		r.Equal(tc.wire, string(got), "encoding %+v", tc.note)
+
+		var back Note
+		r.NoError(json.Unmarshal(got, &back))
+		r.Equal(tc.note, back, "decoding %s", tc.wire)
+	}
+
+	// any negative number means don't replicate, whatever else it says
+	var n Note
+	r.NoError(json.Unmarshal([]byte("-3"), &n))
+	r.False(n.Replicate)
+	r.NoError(json.Unmarshal([]byte("6.0"), &n))
+	r.Equal(Note{Seq: 3, Replicate: true, Receive: true}, n)
+
+	r.Error(json.Unmarshal([]byte("2.5"), &n))
+	r.Error(json.Unmarshal([]byte(`"2"`), &n))
+}
+
+func TestFrontierOrMessage(t *testing.T) {
+	r := require.New(t)
+
+	const alice = "@Z9VZfAWEFjNyo2SfuPu6dkbarqalYELwARGE0+ZdA9w=.ed25519"
+
+	var fr NetworkFrontier
+	r.NoError(json.Unmarshal([]byte(`{"`+alice+`": 10, "not a feed": 3, "@other.format": 1}`), &fr))
+	r.Len(fr, 1)
+	r.Equal(Note{Seq: 5, Replicate: true, Receive: true}, fr[alice])
+
+	r.Error(json.Unmarshal([]byte(`{"`+alice+`": "ten"}`), &fr))
+
+	out, err := json.Marshal(NetworkFrontier{alice: {Seq: 2, Replicate: true}})
+	r.NoError(err)
+	r.JSONEq(`{"`+alice+`": 5}`, string(out))
+
+	var obj map[string]json.RawMessage
+	r.NoError(json.Unmarshal([]byte(`{"previous":null,"author":"`+alice+`","sequence":1,"timestamp":1,"hash":"sha256","content":{"type":"test"},"signature":"x.sig.ed25519"}`), &obj))
+	r.True(isMessage(obj))
+	r.NoError(json.Unmarshal([]byte(`{"`+alice+`": 10}`), &obj))
+	r.False(isMessage(obj))
+}
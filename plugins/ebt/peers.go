@@ -0,0 +1,157 @@
+// SPDX-License-Identifier: MIT
+
+package ebt
+
+import (
+	"context"
+	"time"
+
+	"go.cryptoscope.co/muxrpc"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// DefaultRememberFor is how long a replicator remembers whether a peer speaks ebt, if RememberFor isn't set
+const DefaultRememberFor = time.Hour
+
+// RememberFor is how long a replicator remembers whether a peer speaks ebt.
+// Within it, peers that didn't are not asked again when they reconnect and legacy replication starts right away.
+type RememberFor time.Duration
+
+// support is what we found out about a peer
+type support struct {
+	ebt bool
+	at  time.Time
+}
+
+// Supports returns whether the peer spoke ebt the last time, known is false if we don't know or it was too long ago
+func (r *Replicator) Supports(peer *ssb.FeedRef) (supported, known bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sup, ok := r.supports[peer.Ref()]
+	if !ok || r.now().Sub(sup.at) > r.rememberFor {
+		return false, false
+	}
+	return sup.ebt, true
+}
+
+func (r *Replicator) remember(peer *ssb.FeedRef, ebt bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.supports[peer.Ref()] = support{ebt: ebt, at: r.now()}
+}
+
+// Active returns true while a session runs on the connection edp, in either direction.
+// Feeds shouldn't be fetched with createHistoryStream over it then, the session gets them.
+// Other connections with the same peer are not affected.
+func (r *Replicator) Active(edp muxrpc.Endpoint) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.sessions[edp]) > 0
+}
+
+// NotesFrom returns how many notes the sessions with peer received since the start.
+// They are only understood in the encoding of version 3, so it stays zero for peers that speak another one.
+func (r *Replicator) NotesFrom(peer *ssb.FeedRef) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.notes[peer.Ref()]
+}
+
+func (r *Replicator) countNotes(peer *ssb.FeedRef, n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.notes[peer.Ref()] += n
+}
+
+// track adds s to the running sessions until the returned func is called
+func (r *Replicator) track(s *session) func() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ss, ok := r.sessions[s.edp]
+	if !ok {
+		ss = make(map[*session]struct{})
+		r.sessions[s.edp] = ss
+	}
+	ss[s] = struct{}{}
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(ss, s)
+		if len(ss) == 0 {
+			delete(r.sessions, s.edp)
+		}
+	}
+}
+
+// sessionsOn returns the running sessions on the connection edp
+func (r *Replicator) sessionsOn(edp muxrpc.Endpoint) []*session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var list []*session
+	for s := range r.sessions[edp] {
+		list = append(list, s)
+	}
+	return list
+}
+
+// DefaultFetchWait is how long Fetch waits for the peer to note a feed, if FetchWait isn't set
+const DefaultFetchWait = 10 * time.Second
+
+// FetchWait is how long Fetch waits for the peer to note a feed before it gives up on the session
+type FetchWait time.Duration
+
+// Fetch has the sessions on edp note feed right away, instead of with the next look at the want list,
+// and waits until we have what the peer has of it or ctx is done. The feed has to be replicated already.
+//
+// It returns ErrUnsupported if no session runs on edp, if it ends while waiting or if the peer doesn't note the feed
+// within FetchWait. The caller can fetch the feed with createHistoryStream then.
+func (r *Replicator) Fetch(ctx context.Context, edp muxrpc.Endpoint, feed *ssb.FeedRef) error {
+	sessions := r.sessionsOn(edp)
+	if len(sessions) == 0 {
+		return ErrUnsupported
+	}
+	for _, s := range sessions {
+		if err := s.queueNotes(); err != nil {
+			return err
+		}
+	}
+
+	var seen bool // whether a session noted feed yet
+	noted := time.NewTimer(r.fetchWait)
+	defer noted.Stop()
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		for _, s := range sessions {
+			n, ok := s.noteOf(feed)
+			if !ok {
+				continue
+			}
+			seen = true
+			if !n.Replicate {
+				// the peer doesn't have it
+				return nil
+			}
+			latest, err := r.latestSeq(feed)
+			if err != nil {
+				return err
+			}
+			if latest >= n.Seq {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-noted.C:
+			if !seen {
+				return ErrUnsupported
+			}
+		case <-tick.C:
+		}
+		if !r.Active(edp) {
+			return ErrUnsupported
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// SPDX-License-Identifier: MIT
+
+package ebt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/muxrpc"
+
+	"go.cryptoscope.co/ssb"
+)
+
+func TestPeerSupport(t *testing.T) {
+	r := require.New(t)
+
+	feed := func(b byte) *ssb.FeedRef {
+		return &ssb.FeedRef{ID: bytes.Repeat([]byte{b}, 32), Algo: ssb.RefAlgoFeedSSB1}
+	}
+	self, legacy, modern := feed(1), feed(2), feed(3)
+
+	rep := New(kitlog.NewNopLogger(), self, nil, nil, emptyLister{}, RememberFor(time.Hour))
+	now := time.Unix(1000, 0)
+	rep.now = func() time.Time { return now }
+
+	_, known := rep.Supports(legacy)
+	r.False(known)
+
+	rep.remember(legacy, false)
+	rep.remember(modern, true)
+	sup, known := rep.Supports(legacy)
+	r.True(known)
+	r.False(sup)
+	sup, known = rep.Supports(modern)
+	r.True(known)
+	r.True(sup)
+
+	// they are asked again after a while
+	now = now.Add(time.Hour + time.Second)
+	_, known = rep.Supports(legacy)
+	r.False(known)
+
+	// sessions are tracked per connection, no matter who started them
+	conn, other := &testEndpoint{}, &testEndpoint{}
+	r.False(rep.Active(conn))
+	s1, s2 := rep.newSession(modern, conn, nil), rep.newSession(modern, conn, nil)
+	untrack1 := rep.track(s1)
+	untrack2 := rep.track(s2)
+	r.True(rep.Active(conn))
+	r.False(rep.Active(other), "another connection of the same peer has no session")
+	untrack1()
+	r.True(rep.Active(conn))
+	untrack2()
+	r.False(rep.Active(conn))
+
+	// without a session the caller has to fetch it the old way
+	r.True(IsUnsupported(rep.Fetch(context.Background(), conn, legacy)))
+
+	// the peer said it doesn't have the feed
+	untrack := rep.track(s1)
+	s1.noted[self.Ref()] = true
+	s1.wants[legacy.Ref()] = Note{}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	r.NoError(rep.Fetch(ctx, conn, legacy))
+
+	// the peer doesn't note the feed in time
+	rep.fetchWait = 200 * time.Millisecond
+	r.True(IsUnsupported(rep.Fetch(ctx, conn, self)))
+	untrack()
+}
+
+func TestNotesFrom(t *testing.T) {
+	r := require.New(t)
+
+	self := &ssb.FeedRef{ID: bytes.Repeat([]byte{1}, 32), Algo: ssb.RefAlgoFeedSSB1}
+	peer := &ssb.FeedRef{ID: bytes.Repeat([]byte{2}, 32), Algo: ssb.RefAlgoFeedSSB1}
+	rep := New(kitlog.NewNopLogger(), self, nil, nil, emptyLister{})
+	s := rep.newSession(peer, &testEndpoint{}, nil)
+	ctx := context.Background()
+
+	r.NoError(s.handle(ctx, json.RawMessage(`{"`+self.Ref()+`": 4, "`+peer.Ref()+`": -1}`)))
+	r.Equal(2, rep.NotesFrom(peer))
+
+	// the objects of version 2 aren't notes
+	r.Error(s.handle(ctx, json.RawMessage(`{"`+self.Ref()+`": {"seq": 2, "replicate": true, "receive": true}}`)))
+	r.Equal(2, rep.NotesFrom(peer))
+	r.Zero(rep.NotesFrom(self))
+}
+
+// testEndpoint only stands for a connection, none of its methods are called
+type testEndpoint struct {
+	muxrpc.Endpoint
+}
+
+type emptyLister struct{}
+
+func (emptyLister) Authorize(*ssb.FeedRef) error     { return nil }
+func (emptyLister) ReplicationList() *ssb.StrFeedSet { return ssb.NewFeedSet(0) }
+func (emptyLister) BlockList() *ssb.StrFeedSet       { return ssb.NewFeedSet(0) }
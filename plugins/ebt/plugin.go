@@ -0,0 +1,308 @@
+// SPDX-License-Identifier: MIT
+
+// Package ebt implements the ebt.replicate duplex of the JS ssb-ebt plugin, epidemic broadcast trees.
+//
+// Instead of one createHistoryStream per feed and peer, both sides send a note per feed with the sequence they have
+// and then stream each other the messages the other side is missing, including new ones as they arrive.
+// Every connection asks to receive all the feeds it replicates, so no actual tree is built yet and
+// messages that arrive twice over different connections, or over ebt and createHistoryStream, are dropped.
+package ebt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cryptix/go/logging"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/margaret/multilog"
+	"go.cryptoscope.co/muxrpc"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/partial"
+)
+
+// ErrUnsupported is returned by Replicate when the peer doesn't speak ebt.replicate, legacy replication has to be used then
+var ErrUnsupported = errors.New("ebt: peer doesn't support ebt.replicate")
+
+// IsUnsupported returns true if err says the peer doesn't support ebt
+func IsUnsupported(err error) bool {
+	return errors.Cause(err) == ErrUnsupported
+}
+
+// HMACSecret is the key messages are signed with on a test network
+type HMACSecret *[32]byte
+
+// WaitIncoming is how long the side that was dialed waits for the ebt.replicate call of the other side, default 10 seconds
+type WaitIncoming time.Duration
+
+// receiveRecorder is told about the messages that arrived over a session, gossip.PeerStates for example
+type receiveRecorder interface {
+	Received(peer, feed *ssb.FeedRef, seq int64)
+}
+
+// Replicator runs an ebt.replicate session for every connection it is asked to
+type Replicator struct {
+	self      *ssb.FeedRef
+	rootLog   margaret.Log
+	userFeeds multilog.MultiLog
+	wantList  ssb.ReplicationLister
+	info      logging.Interface
+
+	hmacSec      HMACSecret
+	waitIncoming time.Duration
+	rememberFor  time.Duration
+	fetchWait    time.Duration
+	received     receiveRecorder // optional
+	partial      *partial.Store  // optional, the feeds in it are left to createHistoryStream
+	now          func() time.Time
+
+	mu       sync.Mutex
+	incoming map[muxrpc.Endpoint]*incoming             // sessions the remotes opened, by their connection
+	sessions map[muxrpc.Endpoint]map[*session]struct{} // the running sessions in both directions, by their connection
+	supports map[string]support                        // whether peers spoke ebt, by their feed
+	notes    map[string]int                            // how many notes the sessions received, by the feed of the peer
+
+	appendsMu sync.Mutex
+	appends   map[string]*feedAppends // by feed
+}
+
+// incoming is an ebt.replicate call of a peer
+type incoming struct {
+	started chan struct{}
+	done    chan struct{}
+	err     error
+}
+
+// New returns a replicator that stores the messages it receives in rootLog and serves the feeds of userFeeds.
+// opts can be HMACSecret, WaitIncoming, RememberFor, FetchWait, a *gossip.PeerStates and a *partial.Store.
+func New(
+	log logging.Interface,
+	self *ssb.FeedRef,
+	rootLog margaret.Log,
+	userFeeds multilog.MultiLog,
+	wantList ssb.ReplicationLister,
+	opts ...interface{},
+) *Replicator {
+	r := &Replicator{
+		self:         self,
+		rootLog:      rootLog,
+		userFeeds:    userFeeds,
+		wantList:     wantList,
+		info:         log,
+		waitIncoming: 10 * time.Second,
+		rememberFor:  DefaultRememberFor,
+		fetchWait:    DefaultFetchWait,
+		now:          time.Now,
+		incoming:     make(map[muxrpc.Endpoint]*incoming),
+		sessions:     make(map[muxrpc.Endpoint]map[*session]struct{}),
+		supports:     make(map[string]support),
+		notes:        make(map[string]int),
+		appends:      make(map[string]*feedAppends),
+	}
+	for i, o := range opts {
+		switch v := o.(type) {
+		case HMACSecret:
+			r.hmacSec = v
+		case WaitIncoming:
+			r.waitIncoming = time.Duration(v)
+		case RememberFor:
+			r.rememberFor = time.Duration(v)
+		case FetchWait:
+			r.fetchWait = time.Duration(v)
+		case receiveRecorder:
+			r.received = v
+		case *partial.Store:
+			r.partial = v
+		default:
+			log.Log("warning", "unhandled ebt option", "i", i, "type", fmt.Sprintf("%T", o))
+		}
+	}
+	return r
+}
+
+func (r *Replicator) Name() string { return "ebt" }
+
+func (*Replicator) Method() muxrpc.Method {
+	return muxrpc.Method{"ebt"}
+}
+
+func (r *Replicator) Handler() muxrpc.Handler {
+	return handler{r}
+}
+
+// Replicate runs a session with the peer of edp until the connection is closed.
+//
+// On connections we dialed it calls ebt.replicate, on the others it waits for the peer to do so, like the JS side does.
+// Either way it returns ErrUnsupported if that doesn't happen, the caller can fall back to createHistoryStream then.
+// Peers that didn't speak ebt are not asked again for RememberFor, it returns ErrUnsupported right away for them.
+func (r *Replicator) Replicate(ctx context.Context, edp muxrpc.Endpoint) error {
+	remote, err := ssb.GetFeedRefFromAddr(edp.Remote())
+	if err != nil {
+		return errors.Wrap(err, "ebt: failed to get remote feed")
+	}
+	if sup, known := r.Supports(remote); known && !sup {
+		return ErrUnsupported
+	}
+
+	if dir, ok := ssb.ConnDirectionFrom(ctx); ok && dir == ssb.ConnIncoming {
+		err := r.waitFor(ctx, edp)
+		if IsUnsupported(err) {
+			r.remember(remote, false)
+		}
+		return err
+	}
+
+	src, snk, err := edp.Duplex(ctx, json.RawMessage{}, muxrpc.Method{"ebt", "replicate"}, map[string]interface{}{"version": 3})
+	if err != nil {
+		return errors.Wrap(err, "ebt: failed to open replicate stream")
+	}
+	s := r.newSession(remote, edp, snk)
+	untrack := r.track(s)
+	err = s.run(ctx, src)
+	untrack()
+	snk.Close()
+	if !s.heard() && ctx.Err() == nil {
+		// nothing ever came back, an error or a closed stream means the peer doesn't know the method
+		level.Debug(r.info).Log("event", "no ebt", "remote", remote.ShortRef(), "err", err)
+		r.remember(remote, false)
+		return ErrUnsupported
+	}
+	if s.heard() {
+		r.remember(remote, true)
+	}
+	return err
+}
+
+// waitFor waits for the ebt.replicate call on edp and until its session is done
+func (r *Replicator) waitFor(ctx context.Context, edp muxrpc.Endpoint) error {
+	in := r.incomingFor(edp)
+	t := time.NewTimer(r.waitIncoming)
+	defer t.Stop()
+	select {
+	case <-in.started:
+	case <-t.C:
+		r.mu.Lock()
+		select {
+		case <-in.started:
+		default:
+			delete(r.incoming, edp)
+			r.mu.Unlock()
+			return ErrUnsupported
+		}
+		r.mu.Unlock()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-in.done:
+		return in.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// incomingFor returns the incoming session on edp, which might not be started yet
+func (r *Replicator) incomingFor(edp muxrpc.Endpoint) *incoming {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	in, ok := r.incoming[edp]
+	if !ok {
+		in = &incoming{
+			started: make(chan struct{}),
+			done:    make(chan struct{}),
+		}
+		r.incoming[edp] = in
+	}
+	return in
+}
+
+type handler struct {
+	r *Replicator
+}
+
+// HandleConnect does nothing, the gossip handler calls Replicate so that it can fall back to legacy replication
+func (handler) HandleConnect(context.Context, muxrpc.Endpoint) {}
+
+func (h handler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
+	if req.Method.String() != "ebt.replicate" {
+		req.CloseWithError(errors.Errorf("ebt: unknown method %s", req.Method))
+		return
+	}
+	if req.Type != "duplex" {
+		req.CloseWithError(errors.Errorf("ebt: replicate is a duplex call, not %s", req.Type))
+		return
+	}
+	if err := checkVersion(req.Args()); err != nil {
+		req.CloseWithError(err)
+		return
+	}
+
+	remote, err := ssb.GetFeedRefFromAddr(edp.Remote())
+	if err != nil {
+		req.CloseWithError(errors.Wrap(err, "ebt: failed to get remote feed"))
+		return
+	}
+	if h.r.wantList.BlockList().Has(remote) {
+		req.CloseWithError(errors.New("ebt: blocked"))
+		return
+	}
+
+	r := h.r
+	in := r.incomingFor(edp)
+	r.mu.Lock()
+	select {
+	case <-in.started:
+		// a second call on the same connection, nobody waits for this one
+		in = &incoming{started: make(chan struct{}), done: make(chan struct{})}
+	default:
+	}
+	close(in.started)
+	r.mu.Unlock()
+	// also if we thought it didn't, it might have been updated
+	r.remember(remote, true)
+
+	s := r.newSession(remote, edp, req.Stream)
+	untrack := r.track(s)
+	in.err = s.run(ctx, req.Stream)
+	untrack()
+	close(in.done)
+
+	r.mu.Lock()
+	if r.incoming[edp] == in {
+		delete(r.incoming, edp)
+	}
+	r.mu.Unlock()
+
+	if in.err != nil && errors.Cause(in.err) != context.Canceled {
+		level.Debug(log.With(r.info, "remote", remote.ShortRef())).Log("event", "ebt session ended", "err", in.err)
+		req.CloseWithError(in.err)
+		return
+	}
+	req.Stream.Close()
+}
+
+// checkVersion makes sure the caller speaks version 3 of the protocol, which is the one that has the note encoding above
+func checkVersion(args []interface{}) error {
+	if len(args) == 0 {
+		return nil
+	}
+	opts, ok := args[0].(map[string]interface{})
+	if !ok {
+		return errors.Errorf("ebt: expected options object, got %T", args[0])
+	}
+	v, ok := opts["version"]
+	if !ok {
+		return nil
+	}
+	if f, ok := v.(float64); !ok || f != 3 {
+		return errors.Errorf("ebt: unsupported version %v", v)
+	}
+	return nil
+}
@@ -0,0 +1,497 @@
+// SPDX-License-Identifier: MIT
+
+package ebt
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/librarian"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/muxrpc"
+	"go.cryptoscope.co/muxrpc/codec"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/mutil"
+	"go.cryptoscope.co/ssb/message"
+)
+
+// how often the want list is checked for feeds the peer wasn't told about yet
+const renoteInterval = time.Minute
+
+// session is the state of one ebt.replicate stream
+type session struct {
+	r      *Replicator
+	remote *ssb.FeedRef
+	edp    muxrpc.Endpoint // the connection it runs on
+	info   log.Logger
+	snk    luigi.Sink
+
+	kick chan struct{} // wakes up the sender
+
+	mu      sync.Mutex
+	gotAny  bool
+	wants   map[string]Note  // what the peer said it wants, by feed
+	sent    map[string]int64 // the latest message the peer has of a feed, as far as we know
+	noted   map[string]bool  // the feeds we sent a note for
+	notes   NetworkFrontier  // notes the sender still has to send
+	pending map[string]bool  // feeds the sender has to look at
+
+	// only used by the receiving side
+	drains map[string]*drain
+}
+
+// drain verifies the messages of one feed before they are stored
+type drain struct {
+	snk    luigi.Sink
+	latest int64
+	stored ssb.Message // the latest message it appended
+}
+
+func (r *Replicator) newSession(remote *ssb.FeedRef, edp muxrpc.Endpoint, snk luigi.Sink) *session {
+	return &session{
+		r:       r,
+		remote:  remote,
+		edp:     edp,
+		info:    log.With(r.info, "event", "ebt", "remote", remote.ShortRef()),
+		snk:     snk,
+		kick:    make(chan struct{}, 1),
+		wants:   make(map[string]Note),
+		sent:    make(map[string]int64),
+		noted:   make(map[string]bool),
+		notes:   make(NetworkFrontier),
+		pending: make(map[string]bool),
+		drains:  make(map[string]*drain),
+	}
+}
+
+// heard returns true if the peer sent anything yet
+func (s *session) heard() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.gotAny
+}
+
+// run sends our notes, serves the peer and stores what it sends until src ends
+func (s *session) run(ctx context.Context, src luigi.Source) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if err := s.queueNotes(); err != nil {
+		return err
+	}
+
+	// the first error of the helpers ends the session
+	failed := make(chan error, 1)
+	fail := func(err error) {
+		if err == nil || errors.Cause(err) == context.Canceled {
+			return
+		}
+		select {
+		case failed <- err:
+			cancel()
+		default:
+		}
+	}
+	go func() { fail(s.sender(ctx)) }()
+	go func() { fail(s.live(ctx)) }()
+	go func() {
+		tick := time.NewTicker(renoteInterval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick.C:
+			}
+			if err := s.queueNotes(); err != nil {
+				fail(err)
+				return
+			}
+		}
+	}()
+
+	for {
+		v, err := src.Next(ctx)
+		if luigi.IsEOS(err) {
+			return nil
+		} else if err != nil {
+			select {
+			case err := <-failed:
+				return err
+			default:
+			}
+			return errors.Wrap(err, "ebt: failed to read from peer")
+		}
+		s.mu.Lock()
+		s.gotAny = true
+		s.mu.Unlock()
+
+		if err := s.handle(ctx, v); err != nil {
+			return err
+		}
+	}
+}
+
+// handle takes one value from the stream, a note or a message
+func (s *session) handle(ctx context.Context, v interface{}) error {
+	var data json.RawMessage
+	switch tv := v.(type) {
+	case json.RawMessage:
+		data = tv
+	case codec.Body:
+		data = json.RawMessage(tv)
+	case []byte:
+		data = tv
+	default:
+		var err error
+		if data, err = json.Marshal(v); err != nil {
+			return errors.Wrapf(err, "ebt: unexpected value %T", v)
+		}
+	}
+
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return errors.Wrap(err, "ebt: expected an object")
+	}
+	if isMessage(obj) {
+		s.receive(ctx, data)
+		return nil
+	}
+
+	var fr NetworkFrontier
+	if err := json.Unmarshal(data, &fr); err != nil {
+		return err
+	}
+	s.r.countNotes(s.remote, len(fr))
+	s.takeNotes(fr)
+	return nil
+}
+
+// queueNotes has the sender tell the peer about the feeds we replicate and didn't note yet
+func (s *session) queueNotes() error {
+	feeds := []*ssb.FeedRef{s.r.self}
+	if lst := s.r.wantList.ReplicationList(); lst != nil {
+		more, err := lst.List()
+		if err != nil {
+			return errors.Wrap(err, "ebt: failed to get want list")
+		}
+		feeds = append(feeds, more...)
+	}
+	blocked := s.r.wantList.BlockList()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, feed := range feeds {
+		if feed.Algo != ssb.RefAlgoFeedSSB1 || s.noted[feed.Ref()] || blocked.Has(feed) || s.r.isPartial(feed) {
+			continue
+		}
+		seq, err := s.r.latestSeq(feed)
+		if err != nil {
+			return err
+		}
+		s.noted[feed.Ref()] = true
+		s.notes[feed.Ref()] = Note{Seq: seq, Replicate: true, Receive: true}
+	}
+	if len(s.notes) > 0 {
+		s.wake()
+	}
+	return nil
+}
+
+// noteOf returns the last note of the peer about feed, false if it didn't send one yet
+func (s *session) noteOf(feed *ssb.FeedRef) (Note, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.wants[feed.Ref()]
+	return n, ok
+}
+
+// takeNotes remembers what the peer wants and has the sender look at those feeds
+func (s *session) takeNotes(fr NetworkFrontier) {
+	blocked := s.r.wantList.BlockList()
+	sch, _ := s.r.wantList.(ssb.ReplicationScheduler)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for feed, n := range fr {
+		ref, err := ssb.ParseFeedRef(feed)
+		if err != nil || ref.Algo != ssb.RefAlgoFeedSSB1 || blocked.Has(ref) || s.r.isPartial(ref) {
+			continue
+		}
+		if sch != nil && n.Replicate && n.Seq > 0 {
+			sch.RemoteHas(ref, n.Seq)
+		}
+		s.wants[feed] = n
+		if !n.Replicate || !n.Receive {
+			continue
+		}
+		// the peer might also have gone back, after losing its data for example
+		s.sent[feed] = n.Seq
+		s.pending[feed] = true
+	}
+	if len(s.pending) > 0 {
+		s.wake()
+	}
+}
+
+func (s *session) wake() {
+	select {
+	case s.kick <- struct{}{}:
+	default:
+	}
+}
+
+// sender is the only one pouring into the stream, notes first and then the messages of the feeds that need a look
+func (s *session) sender(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.kick:
+		}
+
+		s.mu.Lock()
+		notes, pending := s.notes, s.pending
+		s.notes, s.pending = make(NetworkFrontier), make(map[string]bool)
+		s.mu.Unlock()
+
+		if len(notes) > 0 {
+			if err := s.snk.Pour(ctx, notes); err != nil {
+				return errors.Wrap(err, "ebt: failed to send notes")
+			}
+		}
+		for feed := range pending {
+			if err := s.sendFeed(ctx, feed); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// sendFeed sends the messages of feed that the peer asked for and doesn't have yet
+func (s *session) sendFeed(ctx context.Context, feed string) error {
+	ref, err := ssb.ParseFeedRef(feed)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	n, from := s.wants[feed], s.sent[feed]
+	s.mu.Unlock()
+	if !n.Replicate || !n.Receive {
+		return nil
+	}
+
+	userLog, err := s.r.userFeeds.Get(ref.StoredAddr())
+	if err != nil {
+		return errors.Wrap(err, "ebt: failed to open sublog of feed")
+	}
+	has, err := latestOf(userLog)
+	if err != nil {
+		return err
+	}
+	if has <= from {
+		return nil
+	}
+
+	// the sublog starts at 0 and feeds at 1, so from is the index of the first message the peer is missing
+	src, err := mutil.Indirect(s.r.rootLog, userLog).Query(
+		margaret.Gte(margaret.BaseSeq(from)),
+		margaret.Limit(int(has-from)),
+	)
+	if err != nil {
+		return errors.Wrap(err, "ebt: failed to query feed")
+	}
+	for {
+		v, err := src.Next(ctx)
+		if luigi.IsEOS(err) {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "ebt: failed to read feed")
+		}
+		msg, ok := v.(ssb.Message)
+		if !ok {
+			return errors.Errorf("ebt: wrong message type %T", v)
+		}
+		if err := s.snk.Pour(ctx, msg.ValueContentJSON()); err != nil {
+			return errors.Wrap(err, "ebt: failed to send message")
+		}
+		s.mu.Lock()
+		if msg.Seq() > s.sent[feed] {
+			s.sent[feed] = msg.Seq()
+		}
+		s.mu.Unlock()
+	}
+}
+
+// live has the sender look at the feeds the peer wants whenever we get a new message of them
+func (s *session) live(ctx context.Context) error {
+	sv, err := s.r.rootLog.Seq().Value()
+	if err != nil {
+		return errors.Wrap(err, "ebt: failed to get root log sequence")
+	}
+	src, err := s.r.rootLog.Query(
+		margaret.Gt(sv.(margaret.Seq)),
+		margaret.Live(true),
+	)
+	if err != nil {
+		return errors.Wrap(err, "ebt: failed to query new messages")
+	}
+	for {
+		v, err := src.Next(ctx)
+		if luigi.IsEOS(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		msg, ok := v.(ssb.Message)
+		if !ok {
+			continue
+		}
+		feed := msg.Author().Ref()
+		s.mu.Lock()
+		if n := s.wants[feed]; n.Replicate && n.Receive && msg.Seq() > s.sent[feed] {
+			s.pending[feed] = true
+			s.wake()
+		}
+		s.mu.Unlock()
+	}
+}
+
+// receive verifies and stores a message of the peer.
+// Problems with a message only cost us that feed for now, its drain is set up again with the next message.
+func (s *session) receive(ctx context.Context, raw json.RawMessage) {
+	var hdr struct {
+		Author   string
+		Sequence int64
+	}
+	if err := json.Unmarshal(raw, &hdr); err != nil {
+		level.Warn(s.info).Log("msg", "broken message", "err", err)
+		return
+	}
+
+	s.mu.Lock()
+	noted := s.noted[hdr.Author]
+	s.mu.Unlock()
+	if !noted {
+		// we didn't ask for it
+		return
+	}
+
+	ref, err := ssb.ParseFeedRef(hdr.Author)
+	if err != nil {
+		level.Warn(s.info).Log("msg", "broken author", "fr", hdr.Author, "err", err)
+		return
+	}
+	latest, done, err := s.r.Appending(ref)
+	if err != nil {
+		level.Warn(s.info).Log("msg", "failed to look up feed", "fr", hdr.Author, "err", err)
+		return
+	}
+	var appended ssb.Message
+	defer func() { done(appended) }()
+
+	var latestSeq int64
+	if latest != nil {
+		latestSeq = latest.Seq()
+	}
+	if hdr.Sequence <= latestSeq {
+		// got it over another connection already
+		return
+	}
+
+	d, ok := s.drains[hdr.Author]
+	if !ok || d.latest != latestSeq {
+		// new, or others stored messages since and the drain is behind
+		d = s.newDrain(ref, latest)
+		s.drains[hdr.Author] = d
+	}
+
+	if err := d.snk.Pour(ctx, raw); err != nil {
+		delete(s.drains, hdr.Author)
+		if fork, ok := ssb.IsForkDetected(err); ok {
+			level.Error(s.info).Log("msg", "fork detected", "fr", hdr.Author, "seq", fork.Seq, "local", fork.LocalKey.Ref(), "remote", fork.RemoteKey.Ref())
+			return
+		}
+		level.Warn(s.info).Log("msg", "skipped message", "fr", hdr.Author, "seq", hdr.Sequence, "err", err)
+		return
+	}
+	d.latest = hdr.Sequence
+	appended = d.stored
+
+	s.mu.Lock()
+	if hdr.Sequence > s.sent[hdr.Author] {
+		// no need to send it back
+		s.sent[hdr.Author] = hdr.Sequence
+	}
+	s.mu.Unlock()
+
+	if s.r.received != nil {
+		s.r.received.Received(s.remote, ref, hdr.Sequence)
+	}
+}
+
+// newDrain sets up the verification of feed, starting after latestMsg, the latest message we have of it
+func (s *session) newDrain(feed *ssb.FeedRef, latestMsg ssb.Message) *drain {
+	d := &drain{}
+	if latestMsg != nil {
+		d.latest = latestMsg.Seq()
+	}
+	store := luigi.FuncSink(func(ctx context.Context, val interface{}, err error) error {
+		if err != nil {
+			if luigi.IsEOS(err) {
+				return nil
+			}
+			return err
+		}
+		msg, ok := val.(ssb.Message)
+		if !ok {
+			return errors.Errorf("ebt: wrong message type %T", val)
+		}
+		if _, err = s.r.rootLog.Append(msg); err != nil {
+			return errors.Wrap(err, "failed to append verified message to rootLog")
+		}
+		d.stored = msg
+		return nil
+	})
+	d.snk = message.NewVerifySink(feed, margaret.BaseSeq(d.latest), latestMsg, store, s.r.hmacSec)
+	return d
+}
+
+// isPartial returns true for feeds of which we only have some messages.
+// The peer would send us all of them and couldn't continue from the gaps in ours, so they aren't part of the session.
+func (r *Replicator) isPartial(feed *ssb.FeedRef) bool {
+	return r.partial.IsPartial(feed)
+}
+
+// latestSeq returns the sequence of the latest message of feed we have, 0 if we have none
+func (r *Replicator) latestSeq(feed *ssb.FeedRef) (int64, error) {
+	userLog, err := r.userFeeds.Get(feed.StoredAddr())
+	if err != nil {
+		return 0, errors.Wrap(err, "ebt: failed to open sublog of feed")
+	}
+	return latestOf(userLog)
+}
+
+// latestOf returns the number of messages in the sublog of a feed, which is the sequence of its latest one
+func latestOf(userLog margaret.Log) (int64, error) {
+	v, err := userLog.Seq().Value()
+	if err != nil {
+		return 0, errors.Wrap(err, "ebt: failed to observe latest")
+	}
+	switch sv := v.(type) {
+	case librarian.UnsetValue:
+		return 0, nil
+	case margaret.BaseSeq:
+		return sv.Seq() + 1, nil
+	default:
+		return 0, errors.Errorf("ebt: wrong type in index %T", v)
+	}
+}
@@ -37,7 +37,7 @@ func (h isFollowingH) HandleAsync(ctx context.Context, req *muxrpc.Request) (int
 	}
 	a := args[0]
 
-	g, err := h.builder.Build()
+	g, err := h.builder.Snapshot()
 	if err != nil {
 		return nil, err
 	}
@@ -63,7 +63,7 @@ func (h isBlockingH) HandleAsync(ctx context.Context, req *muxrpc.Request) (inte
 	}
 	a := args[0]
 
-	g, err := h.builder.Build()
+	g, err := h.builder.Snapshot()
 	if err != nil {
 		return nil, err
 	}
@@ -87,7 +87,13 @@ func (h hopsSrc) HandleSource(ctx context.Context, req *muxrpc.Request, snk luig
 		start = &h.self
 	}
 
-	set := h.builder.Hops(start, int(dist))
+	g, err := h.builder.Snapshot()
+	if err != nil {
+		return err
+	}
+
+	// blocks by start sever all paths through the blocked feed
+	set := g.Hops(start, int(dist))
 
 	lst, err := set.List()
 	if err != nil {
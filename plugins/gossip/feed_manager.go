@@ -6,8 +6,6 @@ package gossip
 
 import (
 	"context"
-	"math"
-	"sync"
 
 	"github.com/cryptix/go/logging"
 	"github.com/go-kit/kit/log/level"
@@ -19,6 +17,7 @@ import (
 	"go.cryptoscope.co/margaret/multilog"
 	"go.cryptoscope.co/muxrpc"
 	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/livelog"
 	"go.cryptoscope.co/ssb/internal/mutil"
 	"go.cryptoscope.co/ssb/internal/transform"
 	"go.cryptoscope.co/ssb/message"
@@ -32,9 +31,6 @@ type FeedManager struct {
 	UserFeeds multilog.MultiLog
 	logger    logging.Interface
 
-	liveFeeds    map[string]*multiSink
-	liveFeedsMut sync.Mutex
-
 	// metrics
 	sysGauge metrics.Gauge
 	sysCtr   metrics.Counter
@@ -57,91 +53,10 @@ func NewFeedManager(
 		rootCtx:   ctx,
 		sysCtr:    sysCtr,
 		sysGauge:  sysGauge,
-		liveFeeds: make(map[string]*multiSink),
 	}
-	// QUESTION: How should the error case be handled?
-	go fm.serveLiveFeeds()
 	return fm
 }
 
-func (m *FeedManager) pour(ctx context.Context, val interface{}, err error) error {
-	m.liveFeedsMut.Lock()
-	defer m.liveFeedsMut.Unlock()
-
-	if err != nil {
-		if luigi.IsEOS(err) {
-			return nil
-		}
-		level.Error(m.logger).Log("event", "pour failed", "err", err)
-		return err
-	}
-
-	author := val.(margaret.SeqWrapper).Value().(ssb.Message).Author()
-	sink, ok := m.liveFeeds[author.Ref()]
-	if !ok {
-		return nil
-	}
-	return sink.Pour(ctx, val)
-}
-
-func (m *FeedManager) serveLiveFeeds() {
-	seqv, err := m.RootLog.Seq().Value()
-	if err != nil {
-		err = errors.Wrap(err, "failed to get root log sequence")
-		panic(err)
-	}
-
-	src, err := m.RootLog.Query(
-		margaret.Gt(seqv.(margaret.BaseSeq)),
-		margaret.Live(true),
-		margaret.SeqWrap(true),
-	)
-	if err != nil {
-		panic(err)
-	}
-
-	err = luigi.Pump(m.rootCtx, luigi.FuncSink(m.pour), src)
-	if err != nil && err != ssb.ErrShuttingDown && err != context.Canceled {
-		err = errors.Wrap(err, "error while serving live feed")
-		panic(err)
-	}
-}
-
-func (m *FeedManager) addLiveFeed(
-	ctx context.Context,
-	sink luigi.Sink,
-	ssbID string,
-	seq, limit int64,
-) error {
-	// TODO: ensure all messages make it to the live query
-	//  Messages could be lost when written after the non-live portion and
-	//  registering to live feed.
-	m.liveFeedsMut.Lock()
-	defer m.liveFeedsMut.Unlock()
-
-	liveFeed, ok := m.liveFeeds[ssbID]
-	if !ok {
-		m.liveFeeds[ssbID] = newMultiSink(seq)
-		liveFeed = m.liveFeeds[ssbID]
-	}
-
-	if m.sysGauge != nil {
-		m.sysGauge.With("part", "gossip-livefeeds").Set(float64(len(m.liveFeeds)))
-	}
-
-	until := seq + limit
-	if limit == -1 {
-		until = math.MaxInt64
-	}
-	err := liveFeed.Register(ctx, sink, until)
-	if err != nil {
-		return errors.Wrapf(err, "could not create live stream for client %s", ssbID)
-	}
-	m.liveFeeds[ssbID] = liveFeed
-	// TODO: Remove multiSink from map when complete
-	return nil
-}
-
 // nonliveLimit returns the upper limit for a CreateStreamHistory request given
 // the current User Feeds latest sequence.
 func nonliveLimit(
@@ -250,7 +165,11 @@ func (m *FeedManager) CreateStreamHistory(
 	}
 
 	sent := 0
-	err = luigi.Pump(ctx, newSinkCounter(&sent, sink), src)
+	counted := newSinkCounter(&sent, sink)
+	err = luigi.Pump(ctx, counted, src)
+	if err == nil && arg.Live && !arg.Reverse {
+		err = m.pumpLive(ctx, counted, userLog, arg.Seq+int64(sent), liveLimit(arg, latest))
+	}
 
 	// track number of messages sent
 	if m.sysCtr != nil {
@@ -267,16 +186,44 @@ func (m *FeedManager) CreateStreamHistory(
 	} else if err != nil {
 		return errors.Wrap(err, "failed to pump messages to peer")
 	}
-
-	// cryptix: this seems to produce some hangs
-	// TODO: make tests with leaving and joining peers while messages are published
-	//if arg.Live {
-	//	return m.addLiveFeed(
-	//		ctx, sink,
-	//		arg.ID,
-	//		latest,
-	//		liveLimit(arg, latest),
-	//	)
-	//}
 	return sink.Close()
 }
+
+// pumpLive sends the messages of userLog from the entry next on, including the ones that are appended later,
+// until limit of them are sent or ctx or the FeedManager is done.
+// Every stream has its own bounded queue, a slow peer only has to read from the log itself once it fell behind.
+func (m *FeedManager) pumpLive(ctx context.Context, sink luigi.Sink, userLog margaret.Log, next, limit int64) error {
+	if limit == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-m.rootCtx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	live := mutil.Indirect(m.RootLog, liveSublog{ctx: ctx, Log: userLog})
+	src, err := live.Query(
+		margaret.Gte(margaret.BaseSeq(next)),
+		margaret.Limit(int(limit)),
+		margaret.Live(true),
+	)
+	if err != nil {
+		return errors.Wrap(err, "invalid live query")
+	}
+	return luigi.Pump(ctx, sink, src)
+}
+
+// liveSublog serves the live queries of a sublog from a queue of its own, until ctx is done
+type liveSublog struct {
+	ctx context.Context
+	margaret.Log
+}
+
+func (l liveSublog) Query(specs ...margaret.QuerySpec) (luigi.Source, error) {
+	return livelog.Query(l.ctx, l.Log, livelog.DefaultQueueSize, specs...)
+}
@@ -12,11 +12,13 @@ import (
 
 	"github.com/go-kit/kit/log"
 	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/luigi"
 
 	"go.cryptoscope.co/margaret"
 	"go.cryptoscope.co/margaret/multilog"
 	"go.cryptoscope.co/ssb"
 	"go.cryptoscope.co/ssb/internal/ctxutils"
+	"go.cryptoscope.co/ssb/internal/livelog"
 	"go.cryptoscope.co/ssb/internal/testutils"
 	"go.cryptoscope.co/ssb/message"
 	"go.cryptoscope.co/ssb/multilogs"
@@ -188,3 +190,85 @@ func (cs *countSink) Close() error {
 		"countSink", "closed")
 	return nil
 }
+
+// a live stream to a peer that doesn't read doesn't hold up the others
+func TestCreateHistoryStreamSlowPeer(t *testing.T) {
+	r := require.New(t)
+	info := testutils.NewRelativeTimeLogger(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	repoPath := filepath.Join("testrun", t.Name())
+	create, rootLog, userFeeds, keyPair := loadTestRepo(t, repoPath)
+	defer userFeeds.Close()
+	create(t, 3, "prefill")
+
+	fm := NewFeedManager(ctx, rootLog, userFeeds, info, nil, nil)
+
+	var (
+		release = make(chan struct{})
+		slowGot = make(chan struct{}, 1000)
+		fastGot = make(chan struct{}, 1000)
+		slowCnt int
+	)
+	// the slow peer gets stuck after the messages that were there
+	slow := luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error {
+		if err != nil {
+			return nil
+		}
+		if slowCnt >= 3 {
+			select {
+			case <-release:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		slowCnt++
+		slowGot <- struct{}{}
+		return nil
+	})
+	fast := luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error {
+		if err != nil {
+			return nil
+		}
+		fastGot <- struct{}{}
+		return nil
+	})
+
+	errc := make(chan error, 2)
+	for _, snk := range []luigi.Sink{slow, fast} {
+		go func(snk luigi.Sink) {
+			errc <- fm.CreateStreamHistory(ctx, snk, &message.CreateHistArgs{
+				ID:         keyPair.Id,
+				CommonArgs: message.CommonArgs{Live: true},
+				StreamArgs: message.StreamArgs{Limit: -1},
+			})
+		}(snk)
+	}
+
+	waitFor := func(got chan struct{}, n int) {
+		for i := 0; i < n; i++ {
+			select {
+			case <-got:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("got only %d of %d messages", i, n)
+			}
+		}
+	}
+	waitFor(fastGot, 3)
+	waitFor(slowGot, 3)
+
+	// more than fit in a queue while the slow one still waits for the first new message
+	create(t, livelog.DefaultQueueSize+10, "post/live")
+	waitFor(fastGot, livelog.DefaultQueueSize+10)
+	r.Len(slowGot, 0)
+
+	// it catches up from the log
+	close(release)
+	waitFor(slowGot, livelog.DefaultQueueSize+10)
+
+	cancel()
+	r.NoError(<-errc)
+	r.NoError(<-errc)
+}
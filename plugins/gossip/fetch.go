@@ -22,6 +22,7 @@ import (
 
 	"go.cryptoscope.co/ssb"
 	"go.cryptoscope.co/ssb/internal/neterr"
+	"go.cryptoscope.co/ssb/internal/partial"
 	"go.cryptoscope.co/ssb/message"
 )
 
@@ -34,6 +35,9 @@ func (h *handler) fetchAll(
 	if err != nil {
 		return err
 	}
+	if sch, ok := h.WantList.(ssb.ReplicationScheduler); ok {
+		sch.Prioritize(lst)
+	}
 	// we don't just want them all parallel right nw
 	// this kind of concurrency is way to harsh on the runtime
 	// we need some kind of FeedManager, similar to Blobs
@@ -58,6 +62,10 @@ func (h *handler) fetchAll(
 	}
 
 	for _, r := range lst {
+		if h.ebtActive(e) && !h.partial.IsPartial(r) {
+			// the ebt session that started in the meantime has it
+			continue
+		}
 		select {
 		case <-ctx.Done():
 			close(work)
@@ -81,6 +89,9 @@ func (h *handler) makeWorker(work <-chan *ssb.FeedRef, ctx context.Context, edp
 			causeErr := errors.Cause(err)
 			if muxrpc.IsSinkClosed(err) || causeErr == context.Canceled || causeErr == muxrpc.ErrSessionTerminated || neterr.IsConnBrokenErr(causeErr) {
 				return err
+			} else if fork, ok := ssb.IsForkDetected(err); ok {
+				level.Error(h.Info).Log("event", "fork detected", "fr", ref.ShortRef(),
+					"seq", fork.Seq, "local", fork.LocalKey.Ref(), "remote", fork.RemoteKey.Ref(), "remote-peer", edp.Remote().String())
 			} else if err != nil {
 				// just logging the error assuming forked feed for instance
 				level.Warn(h.Info).Log("event", "skipped updating of stored feed", "err", err, "fr", ref.ShortRef())
@@ -90,6 +101,32 @@ func (h *handler) makeWorker(work <-chan *ssb.FeedRef, ctx context.Context, edp
 	}
 }
 
+// errStoredElsewhere ends a fetch that got a message an ebt session stored already
+var errStoredElsewhere = errors.New("fetch: message stored by another connection")
+
+// appendFetched stores msg, unless an ebt session stored it already
+func (g *handler) appendFetched(fr *ssb.FeedRef, msg ssb.Message) error {
+	if g.ebt == nil {
+		// the active fetches are the only ones that append
+		_, err := g.RootLog.Append(msg)
+		return errors.Wrap(err, "failed to append verified message to rootLog")
+	}
+	latest, done, err := g.ebt.Appending(fr)
+	if err != nil {
+		return err
+	}
+	if latest != nil && msg.Seq() <= latest.Seq() {
+		done(nil)
+		return errStoredElsewhere
+	}
+	if _, err := g.RootLog.Append(msg); err != nil {
+		done(nil)
+		return errors.Wrap(err, "failed to append verified message to rootLog")
+	}
+	done(msg)
+	return nil
+}
+
 func isIn(list []librarian.Addr, a *ssb.FeedRef) bool {
 	for _, el := range list {
 		if bytes.Equal([]byte(a.StoredAddr()), []byte(el)) {
@@ -135,6 +172,21 @@ func (g *handler) fetchFeed(
 			g.sysGauge.With("part", "fetches").Add(-1)
 		}
 	}()
+
+	// the peer the messages come from, to keep track of what we got from it
+	var remote *ssb.FeedRef
+	if g.peers != nil {
+		var err error
+		remote, err = ssb.GetFeedRefFromAddr(edp.Remote())
+		if err != nil {
+			return errors.Wrap(err, "fetchFeed: failed to get remote feed ref")
+		}
+		if g.peers.KnownMissing(remote, fr) {
+			// it didn't have it a moment ago
+			return nil
+		}
+	}
+
 	userLog, err := g.UserFeeds.Get(frAddr)
 	if err != nil {
 		return errors.Wrapf(err, "failed to open sublog for user")
@@ -147,11 +199,19 @@ func (g *handler) fetchFeed(
 		latestSeq margaret.BaseSeq
 		latestMsg ssb.Message
 	)
+	var (
+		marker    partial.Marker
+		isPartial bool
+		storedSeq int64 // of the latest message of a partial feed that is in the receive log
+	)
+	if g.partial != nil && fr.Algo == ssb.RefAlgoFeedSSB1 {
+		marker, isPartial = g.partial.Get(fr)
+	}
 	switch v := latest.(type) {
 	case librarian.UnsetValue:
 		// nothing stored, fetch from zero
 	case margaret.BaseSeq:
-		latestSeq = v + 1 // sublog is 0-init while ssb chains start at 1
+		var storedMsg ssb.Message
 		if v >= 0 {
 			rootLogValue, err := userLog.Get(v)
 			if err != nil {
@@ -163,10 +223,22 @@ func (g *handler) fetchFeed(
 			}
 
 			var ok bool
-			latestMsg, ok = msgV.(ssb.Message)
+			storedMsg, ok = msgV.(ssb.Message)
 			if !ok {
-				return errors.Errorf("fetch: wrong message type. expected %T - got %T", latestMsg, msgV)
+				return errors.Errorf("fetch: wrong message type. expected %T - got %T", storedMsg, msgV)
+			}
+		}
+		if isPartial {
+			// the sublog has gaps, the marker knows where the chain is.
+			// It is only saved after the fetch, so after a crash the kept messages from before it can come again.
+			if storedMsg != nil {
+				storedSeq = storedMsg.Seq()
 			}
+			break
+		}
+		latestSeq = v + 1 // sublog is 0-init while ssb chains start at 1
+		if v >= 0 {
+			latestMsg = storedMsg
 
 			// make sure our house is in order
 			if hasSeq := latestMsg.Seq(); hasSeq != latestSeq.Seq() {
@@ -175,11 +247,39 @@ func (g *handler) fetchFeed(
 		}
 	}
 
+	if isPartial {
+		latestSeq = margaret.BaseSeq(marker.Seq)
+		latestMsg, err = marker.Latest(g.hmacSec)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := g.partial.Flush(); err != nil {
+				level.Warn(g.Info).Log("event", "failed to save partial marker", "err", err, "fr", fr.ShortRef())
+			}
+		}()
+	}
+
 	startSeq := latestSeq
 	info := log.With(g.Info, "event", "gossiprx",
 		"fr", fr.ShortRef(),
 		"latest", startSeq) // , "me", g.Id.ShortRef())
 
+	// the sequence of the last progress event and who the messages come from
+	var (
+		reported = startSeq
+		peer     string
+	)
+	if g.prog != nil {
+		if ref, err := ssb.GetFeedRefFromAddr(edp.Remote()); err == nil {
+			peer = ref.Ref()
+		}
+		g.prog.start(fr, peer, startSeq.Seq())
+		defer func() {
+			g.prog.finish(fr, peer, latestSeq.Seq(), (latestSeq - startSeq).Seq(), (latestSeq - reported).Seq(), err)
+		}()
+	}
+
 	var q = message.CreateHistArgs{
 		ID:         fr,
 		Seq:        int64(latestSeq + 1),
@@ -209,8 +309,22 @@ func (g *handler) fetchFeed(
 			}
 			return err
 		}
-		_, err = g.RootLog.Append(val)
-		return errors.Wrap(err, "failed to append verified message to rootLog")
+		msg, ok := val.(ssb.Message)
+		if !ok {
+			return errors.Errorf("fetch: wrong message type. expected ssb.Message - got %T", val)
+		}
+		if !isPartial {
+			return g.appendFetched(fr, msg)
+		}
+
+		if marker.Keeps(partial.ContentType(msg)) && msg.Seq() > storedSeq {
+			if _, err := g.RootLog.Append(val); err != nil {
+				return errors.Wrap(err, "failed to append verified message to rootLog")
+			}
+		}
+		// the marker moves on for every verified message, stored or not
+		g.partial.Advance(msg)
+		return nil
 	})
 
 	var (
@@ -231,10 +345,25 @@ func (g *handler) fetchFeed(
 	// count the received messages
 	snk = mfr.SinkMap(snk, func(_ context.Context, val interface{}) (interface{}, error) {
 		latestSeq++
+		if remote != nil {
+			g.peers.Received(remote, fr, latestSeq.Seq())
+		}
+		if g.prog != nil && latestSeq-reported >= ProgressBatch {
+			g.prog.progress(fr, peer, latestSeq.Seq(), (latestSeq - startSeq).Seq(), (latestSeq - reported).Seq())
+			reported = latestSeq
+		}
 		return val, nil
 	})
 
 	// info.Log("starting", "fetch")
 	err = luigi.Pump(toLong, snk, src)
+	if errors.Cause(err) == errStoredElsewhere {
+		// an ebt session got ahead of us, it fetches the rest
+		err = nil
+	}
+	// an empty answer only shows that the peer lacks the feed if we had none of it either, otherwise we are just caught up
+	if err == nil && remote != nil && startSeq == 0 && latestSeq == 0 {
+		g.peers.NotHave(remote, fr)
+	}
 	return errors.Wrap(err, "gossip pump failed")
 }
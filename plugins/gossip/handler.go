@@ -17,7 +17,9 @@ import (
 	"go.cryptoscope.co/margaret/multilog"
 	"go.cryptoscope.co/muxrpc"
 	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/partial"
 	"go.cryptoscope.co/ssb/message"
+	"go.cryptoscope.co/ssb/plugins/ebt"
 )
 
 type handler struct {
@@ -27,9 +29,10 @@ type handler struct {
 	WantList  ssb.ReplicationLister
 	Info      logging.Interface
 
-	hmacSec  HMACSecret
-	hopCount int
-	promisc  bool // ask for remote feed even if it's not on owns fetch list
+	hmacSec   HMACSecret
+	hopCount  int
+	promisc   bool // ask for remote feed even if it's not on owns fetch list
+	strangers bool // like promisc for fetching, but the remote feed is updated on every connection
 
 	activeLock  *sync.Mutex
 	activeFetch map[string]struct{}
@@ -39,6 +42,12 @@ type handler struct {
 
 	feedManager *FeedManager
 
+	peers   *PeerStates             // optional
+	events  ssb.GossipEventRecorder // optional
+	ebt     *ebt.Replicator         // optional, tried before createHistoryStream
+	partial *partial.Store          // optional, the feeds of which only some types are stored
+	prog    *Progress               // optional
+
 	rootCtx context.Context
 }
 
@@ -55,6 +64,12 @@ func (g *handler) HandleConnect(ctx context.Context, e muxrpc.Endpoint) {
 
 	info := log.With(g.Info, "remote", remoteRef.ShortRef(), "event", "gossiprx")
 	start := time.Now()
+	if g.peers != nil {
+		g.peers.Connected(remoteRef, start)
+	}
+	if g.events != nil {
+		g.events.RecordGossipEvent(ssb.GossipEvent{Time: start, Event: ssb.GossipReplicationStart, Peer: remoteRef.Ref()})
+	}
 
 	// re-sync _our_ feed if we don't have it yet (re-onboarding of an existing feed)
 	hasSelf, err := multilog.Has(g.UserFeeds, g.Id.StoredAddr())
@@ -67,31 +82,54 @@ func (g *handler) HandleConnect(ctx context.Context, e muxrpc.Endpoint) {
 		info.Log("handleConnect", "oops - dont have my own feed. requesting...")
 		if err := g.fetchFeed(ctx, g.Id, e, time.Now()); err != nil {
 			info.Log("handleConnect", "fetchFeed self failed", "err", err)
+			g.exchangeDone(remoteRef, err)
 			return
 		}
 		info.Log("msg", "done fetching self")
 	}
 
-	if g.promisc {
+	if g.promisc || g.strangers {
 		hasCallee, err := multilog.Has(g.UserFeeds, remoteRef.StoredAddr())
 		if err != nil {
 			info.Log("handleConnect", "multilog.Has(callee)", "err", err)
 			return
 		}
 
-		if !hasCallee {
+		stranger := g.strangers && !g.WantList.ReplicationList().Has(remoteRef)
+		if !hasCallee || stranger {
 			info.Log("handleConnect", "oops - dont have feed of remote peer. requesting...")
 			if err := g.fetchFeed(ctx, remoteRef, e, time.Now()); err != nil {
 				info.Log("handleConnect", "fetchFeed callee failed", "err", err)
+				g.exchangeDone(remoteRef, err)
 				return
 			}
 			info.Log("msg", "done fetching callee")
 		}
 	}
 
+	if g.ebt != nil {
+		// ebt can't skip messages, the partial feeds are fetched the old way first
+		if err := g.fetchPartial(ctx, e); err != nil {
+			level.Warn(info).Log("msg", "partial feeds failed", "err", err)
+		}
+
+		// runs as long as the connection does, unless the peer doesn't know ebt
+		err := g.ebt.Replicate(ctx, e)
+		if !ebt.IsUnsupported(err) {
+			g.exchangeDone(remoteRef, err)
+			if err != nil && errors.Cause(err) != context.Canceled {
+				level.Warn(info).Log("msg", "ebt session failed", "err", err)
+			}
+			return
+		}
+		level.Debug(info).Log("msg", "peer doesn't do ebt, falling back to createHistoryStream")
+	}
+
 	feeds := g.WantList.ReplicationList()
-	if feeds != nil {
+	if feeds != nil && !g.ebtActive(e) {
+		g.learnRemote(ctx, e, remoteRef)
 		err := g.fetchAll(ctx, e, feeds)
+		g.exchangeDone(remoteRef, err)
 		if err != nil {
 			level.Error(info).Log("msg", "hops failed", "err", err)
 			return
@@ -108,10 +146,17 @@ func (g *handler) HandleConnect(ctx context.Context, e muxrpc.Endpoint) {
 			return
 		case <-tick.C:
 		}
+		if g.ebtActive(e) {
+			// the peer started an ebt session after all, that one gets the feeds while it runs
+			level.Debug(info).Log("msg", "ebt session runs, skipping createHistoryStream")
+			continue
+		}
 		start := time.Now()
 		feeds := g.WantList.ReplicationList()
 		if feeds != nil {
+			g.learnRemote(ctx, e, remoteRef)
 			err := g.fetchAll(ctx, e, feeds)
+			g.exchangeDone(remoteRef, err)
 			if err != nil {
 				level.Error(info).Log("msg", "hops failed", "err", err)
 				return
@@ -121,6 +166,100 @@ func (g *handler) HandleConnect(ctx context.Context, e muxrpc.Endpoint) {
 	}
 }
 
+// ebtActive returns true if an ebt session runs on e, which also fetches the feeds createHistoryStream would
+func (g *handler) ebtActive(e muxrpc.Endpoint) bool {
+	return g.ebt != nil && g.ebt.Active(e)
+}
+
+// uptoTimeout bounds how long we wait for the replicate.upto of a peer
+const uptoTimeout = 30 * time.Second
+
+// learnRemote tells the scheduler of the want list how far the peer is with the feeds we replicate,
+// so that fetchAll gets the ones we are the furthest behind with first.
+// Without ebt notes that comes from what the peer sent us before and, if it serves it, its replicate.upto.
+func (g *handler) learnRemote(ctx context.Context, e muxrpc.Endpoint, remote *ssb.FeedRef) {
+	sch, ok := g.WantList.(ssb.ReplicationScheduler)
+	if !ok {
+		return
+	}
+
+	if g.peers != nil {
+		if st, has := g.peers.ReplicationState(remote); has {
+			for feed, seq := range st.Received {
+				ref, err := ssb.ParseFeedRef(feed)
+				if err != nil {
+					continue
+				}
+				sch.RemoteHas(ref, seq)
+			}
+		}
+	}
+
+	uctx, cancel := context.WithTimeout(ctx, uptoTimeout)
+	defer cancel()
+	src, err := e.Source(uctx, ssb.ReplicateUpToResponse{}, muxrpc.Method{"replicate", "upto"})
+	if err != nil {
+		return
+	}
+	// fails if the peer doesn't serve it to us, then we go by what it sent us before
+	if err := learnUpto(uctx, sch, src); err != nil {
+		level.Debug(g.Info).Log("event", "replicate.upto failed", "peer", remote.ShortRef(), "err", err)
+	}
+}
+
+// learnUpto passes the sequences of a replicate.upto stream to sch, the lister only keeps the feeds we replicate
+func learnUpto(ctx context.Context, sch ssb.ReplicationScheduler, src luigi.Source) error {
+	for {
+		v, err := src.Next(ctx)
+		if err != nil {
+			if luigi.IsEOS(err) {
+				return nil
+			}
+			return err
+		}
+		up, ok := v.(ssb.ReplicateUpToResponse)
+		if !ok {
+			return errors.Errorf("unexpected replicate.upto value: %T", v)
+		}
+		sch.RemoteHas(&up.ID, up.Sequence)
+	}
+}
+
+// fetchPartial fetches the partially replicated feeds we want
+func (g *handler) fetchPartial(ctx context.Context, e muxrpc.Endpoint) error {
+	if g.partial == nil {
+		return nil
+	}
+	wants := g.WantList.ReplicationList()
+	feeds := ssb.NewFeedSet(0)
+	for _, ref := range g.partial.List() {
+		if wants.Has(ref) {
+			feeds.AddRef(ref)
+		}
+	}
+	if feeds.Count() == 0 {
+		return nil
+	}
+	return g.fetchAll(ctx, e, feeds)
+}
+
+// exchangeDone records the result of fetching from remote, if the handler keeps track of that
+func (g *handler) exchangeDone(remote *ssb.FeedRef, err error) {
+	if g.peers != nil {
+		g.peers.ExchangeDone(remote, err)
+		if err := g.peers.Save(); err != nil {
+			level.Warn(g.Info).Log("event", "failed to save peer states", "err", err)
+		}
+	}
+	if g.events != nil {
+		evt := ssb.GossipEvent{Event: ssb.GossipReplicationDone, Peer: remote.Ref()}
+		if err != nil {
+			evt.Err = err.Error()
+		}
+		g.events.RecordGossipEvent(evt)
+	}
+}
+
 func (g *handler) HandleCall(
 	ctx context.Context,
 	req *muxrpc.Request,
@@ -178,10 +317,24 @@ func (g *handler) HandleCall(
 		// hlog = log.With(hlog, "fr", query.ID.ShortRef(), "remote", remote.ShortRef())
 		// dbgLog = level.Warn(hlog)
 
+		blocks := g.WantList.BlockList()
+
+		// nothing for the peers we block, not even in promisc mode
+		if !g.Id.Equal(remote) && blocks.Has(remote) {
+			dbgLog.Log("msg", "remote blocked")
+			req.Stream.Close()
+			return
+		}
+
+		// we can't serve the messages we skipped
+		if g.partial.IsPartial(query.ID) {
+			dbgLog.Log("msg", "feed only partially replicated", "fr", query.ID.ShortRef())
+			req.Stream.Close()
+			return
+		}
+
 		// skip this check for self/master or in promisc mode (talk to everyone)
 		if !(g.Id.Equal(remote) || g.promisc) {
-			blocks := g.WantList.BlockList()
-
 			if blocks.Has(query.ID) {
 				dbgLog.Log("msg", "feed blocked")
 				req.Stream.Close()
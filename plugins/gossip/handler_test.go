@@ -0,0 +1,50 @@
+// SPDX-License-Identifier: MIT
+
+package gossip
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/luigi"
+
+	"go.cryptoscope.co/ssb"
+)
+
+type testScheduler struct {
+	testLister
+	remote map[string]int64
+}
+
+func (s testScheduler) Schedule() []ssb.FeedPriority    { return nil }
+func (s testScheduler) Prioritize(feeds []*ssb.FeedRef) {}
+func (s testScheduler) RemoteHas(feed *ssb.FeedRef, seq int64) {
+	s.remote[feed.Ref()] = seq
+}
+
+func TestLearnUpto(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	feed := func(b byte) *ssb.FeedRef {
+		return &ssb.FeedRef{ID: bytes.Repeat([]byte{b}, 32), Algo: ssb.RefAlgoFeedSSB1}
+	}
+	alice, bob := feed(1), feed(2)
+
+	sch := testScheduler{testLister: newTestLister(), remote: make(map[string]int64)}
+	src, snk := luigi.NewPipe(luigi.WithBuffer(2))
+	r.NoError(snk.Pour(ctx, ssb.ReplicateUpToResponse{ID: *alice, Sequence: 12}))
+	r.NoError(snk.Pour(ctx, ssb.ReplicateUpToResponse{ID: *bob, Sequence: 3}))
+	r.NoError(snk.Close())
+
+	r.NoError(learnUpto(ctx, sch, src))
+	r.Equal(map[string]int64{alice.Ref(): 12, bob.Ref(): 3}, sch.remote)
+
+	// anything else isn't an answer of replicate.upto
+	src, snk = luigi.NewPipe(luigi.WithBuffer(1))
+	r.NoError(snk.Pour(ctx, "nope"))
+	r.NoError(snk.Close())
+	r.Error(learnUpto(ctx, sch, src))
+}
@@ -0,0 +1,302 @@
+// SPDX-License-Identifier: MIT
+
+package gossip
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"modernc.org/kv"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+// DefaultMissingFor is how long a peer isn't asked again for a feed it didn't have
+const DefaultMissingFor = time.Hour
+
+// PeerStates keeps track of the replication with each peer, see ssb.ReplicationState.
+// Pass it to New as an option to have the gossip handler fill it in.
+//
+// It is updated for every received message, so that is kept to a map write under a lock per peer.
+// States that are opened from a directory are kept in a key-value store there, one entry per peer.
+// Save writes the peers that changed since the last time, the handler does that after every exchange.
+type PeerStates struct {
+	mu    sync.RWMutex
+	peers map[string]*peerState
+
+	db         *kv.DB // optional, where Save writes to
+	saveMu     sync.Mutex
+	missingFor time.Duration
+	now        func() time.Time
+}
+
+type peerState struct {
+	mu    sync.Mutex
+	state ssb.ReplicationState
+	dirty bool // changed since it was saved
+}
+
+var _ ssb.ReplicationStater = (*PeerStates)(nil)
+
+// NewPeerStates returns an empty PeerStates
+func NewPeerStates() *PeerStates {
+	return &PeerStates{
+		peers:      make(map[string]*peerState),
+		missingFor: DefaultMissingFor,
+		now:        time.Now,
+	}
+}
+
+// OpenPeerStates loads the states that were saved in the directory dir, which doesn't have to exist yet.
+// Peers aren't asked for a feed they didn't have for missingFor, DefaultMissingFor if it is zero.
+func OpenPeerStates(dir string, missingFor time.Duration) (*PeerStates, error) {
+	ps := NewPeerStates()
+	if missingFor > 0 {
+		ps.missingFor = missingFor
+	}
+
+	db, err := repo.OpenMKV(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "gossip: failed to open peer states")
+	}
+	enum, err := db.SeekFirst()
+	if err == io.EOF {
+		ps.db = db
+		return ps, nil
+	} else if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "gossip: failed to read peer states")
+	}
+	for {
+		_, data, err := enum.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			db.Close()
+			return nil, errors.Wrap(err, "gossip: failed to read peer states")
+		}
+		var st ssb.ReplicationState
+		if err := json.Unmarshal(data, &st); err != nil {
+			db.Close()
+			return nil, errors.Wrap(err, "gossip: failed to decode peer state")
+		}
+		if st.Received == nil {
+			st.Received = make(map[string]int64)
+		}
+		ps.peers[st.Peer.Ref()] = &peerState{state: st}
+	}
+	ps.db = db
+	return ps, nil
+}
+
+// Save writes the states of the peers that changed since the last call to the store they were opened from.
+// It does nothing for the ones from NewPeerStates.
+func (ps *PeerStates) Save() error {
+	if ps.db == nil {
+		return nil
+	}
+	ps.saveMu.Lock()
+	defer ps.saveMu.Unlock()
+
+	ps.mu.RLock()
+	var changed []*peerState
+	for _, st := range ps.peers {
+		st.mu.Lock()
+		if st.dirty {
+			changed = append(changed, st)
+		}
+		st.mu.Unlock()
+	}
+	ps.mu.RUnlock()
+	if len(changed) == 0 {
+		return nil
+	}
+
+	if err := ps.db.BeginTransaction(); err != nil {
+		return errors.Wrap(err, "gossip: failed to save peer states")
+	}
+	saved := make([][]byte, len(changed))
+	for i, st := range changed {
+		cpy := st.copy()
+		data, err := json.Marshal(cpy)
+		if err != nil {
+			ps.db.Rollback()
+			return errors.Wrap(err, "gossip: failed to encode peer state")
+		}
+		if err := ps.db.Set([]byte(cpy.Peer.Ref()), data); err != nil {
+			ps.db.Rollback()
+			return errors.Wrap(err, "gossip: failed to write peer state")
+		}
+		saved[i] = data
+	}
+	if err := ps.db.Commit(); err != nil {
+		return errors.Wrap(err, "gossip: failed to save peer states")
+	}
+
+	// only what was written is clean, a state that changed in the meantime is saved the next time
+	for i, st := range changed {
+		st.mu.Lock()
+		if cur, err := json.Marshal(st.state); err == nil && bytes.Equal(cur, saved[i]) {
+			st.dirty = false
+		}
+		st.mu.Unlock()
+	}
+	return nil
+}
+
+// Close saves the last changes and closes the store, if the states were opened from one
+func (ps *PeerStates) Close() error {
+	if ps.db == nil {
+		return nil
+	}
+	err := ps.Save()
+	if cerr := ps.db.Close(); err == nil && cerr != nil {
+		err = errors.Wrap(cerr, "gossip: failed to close peer states")
+	}
+	return err
+}
+
+func (ps *PeerStates) get(peer *ssb.FeedRef) *peerState {
+	key := peer.Ref()
+
+	ps.mu.RLock()
+	st, has := ps.peers[key]
+	ps.mu.RUnlock()
+	if has {
+		return st
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if st, has := ps.peers[key]; has {
+		return st
+	}
+	st = &peerState{state: ssb.ReplicationState{
+		Peer:     *peer,
+		Received: make(map[string]int64),
+	}}
+	ps.peers[key] = st
+	return st
+}
+
+// Connected records that an exchange with peer started at time at
+func (ps *PeerStates) Connected(peer *ssb.FeedRef, at time.Time) {
+	st := ps.get(peer)
+	st.mu.Lock()
+	st.state.LastConnected = at
+	st.state.LastExchange = time.Time{}
+	st.state.LastResult = ""
+	st.dirty = true
+	st.mu.Unlock()
+}
+
+// Received records that we got the message with sequence seq of feed from peer
+func (ps *PeerStates) Received(peer, feed *ssb.FeedRef, seq int64) {
+	st := ps.get(peer)
+	st.mu.Lock()
+	st.state.Received[feed.Ref()] = seq
+	if st.state.Missing != nil {
+		delete(st.state.Missing, feed.Ref())
+	}
+	st.dirty = true
+	st.mu.Unlock()
+}
+
+// NotHave records that peer had nothing of feed when we asked for it.
+// It is ignored if the peer sent us messages of the feed before, then it just had nothing new.
+func (ps *PeerStates) NotHave(peer, feed *ssb.FeedRef) {
+	st := ps.get(peer)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if _, got := st.state.Received[feed.Ref()]; got {
+		return
+	}
+	if st.state.Missing == nil {
+		st.state.Missing = make(map[string]time.Time)
+	}
+	st.state.Missing[feed.Ref()] = ps.now()
+	st.dirty = true
+}
+
+// KnownMissing returns true if peer didn't have feed the last time we asked, less than the missing duration ago
+func (ps *PeerStates) KnownMissing(peer, feed *ssb.FeedRef) bool {
+	ps.mu.RLock()
+	st, has := ps.peers[peer.Ref()]
+	ps.mu.RUnlock()
+	if !has {
+		return false
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	at, has := st.state.Missing[feed.Ref()]
+	if !has {
+		return false
+	}
+	if ps.now().Sub(at) >= ps.missingFor {
+		// time to ask again
+		delete(st.state.Missing, feed.Ref())
+		st.dirty = true
+		return false
+	}
+	return true
+}
+
+// ExchangeDone records that the exchange with peer ended with err, which may be nil
+func (ps *PeerStates) ExchangeDone(peer *ssb.FeedRef, err error) {
+	st := ps.get(peer)
+	st.mu.Lock()
+	st.state.LastExchange = ps.now()
+	st.state.LastResult = "ok"
+	if err != nil {
+		st.state.LastResult = err.Error()
+	}
+	st.dirty = true
+	st.mu.Unlock()
+}
+
+func (ps *PeerStates) ReplicationState(peer *ssb.FeedRef) (ssb.ReplicationState, bool) {
+	ps.mu.RLock()
+	st, has := ps.peers[peer.Ref()]
+	ps.mu.RUnlock()
+	if !has {
+		return ssb.ReplicationState{}, false
+	}
+	return st.copy(), true
+}
+
+func (ps *PeerStates) ReplicationStates() []ssb.ReplicationState {
+	ps.mu.RLock()
+	states := make([]ssb.ReplicationState, 0, len(ps.peers))
+	for _, st := range ps.peers {
+		states = append(states, st.copy())
+	}
+	ps.mu.RUnlock()
+
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Peer.Ref() < states[j].Peer.Ref()
+	})
+	return states
+}
+
+func (st *peerState) copy() ssb.ReplicationState {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	cpy := st.state
+	cpy.Received = make(map[string]int64, len(st.state.Received))
+	for feed, seq := range st.state.Received {
+		cpy.Received[feed] = seq
+	}
+	if len(st.state.Missing) > 0 {
+		cpy.Missing = make(map[string]time.Time, len(st.state.Missing))
+		for feed, at := range st.state.Missing {
+			cpy.Missing[feed] = at
+		}
+	}
+	return cpy
+}
@@ -0,0 +1,162 @@
+// SPDX-License-Identifier: MIT
+
+package gossip
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/ssb"
+)
+
+func TestPeerStates(t *testing.T) {
+	r := require.New(t)
+
+	mkRef := func(b byte) *ssb.FeedRef {
+		return &ssb.FeedRef{ID: bytes.Repeat([]byte{b}, 32), Algo: ssb.RefAlgoFeedSSB1}
+	}
+	alice, bob, feed := mkRef(1), mkRef(2), mkRef(3)
+
+	ps := NewPeerStates()
+	_, has := ps.ReplicationState(alice)
+	r.False(has)
+	r.Len(ps.ReplicationStates(), 0)
+
+	connected := time.Now()
+	ps.Connected(alice, connected)
+	ps.Received(alice, feed, 1)
+	ps.Received(alice, feed, 2)
+	ps.Received(alice, alice, 7)
+
+	st, has := ps.ReplicationState(alice)
+	r.True(has)
+	r.True(st.Peer.Equal(alice))
+	r.Equal(connected, st.LastConnected)
+	r.Equal("", st.LastResult, "still running")
+	r.Equal(map[string]int64{feed.Ref(): 2, alice.Ref(): 7}, st.Received)
+
+	// the returned state is a copy
+	st.Received[feed.Ref()] = 100
+	st, _ = ps.ReplicationState(alice)
+	r.EqualValues(2, st.Received[feed.Ref()])
+
+	ps.ExchangeDone(alice, nil)
+	ps.Connected(bob, time.Now())
+	ps.ExchangeDone(bob, errors.New("stream reset"))
+
+	states := ps.ReplicationStates()
+	r.Len(states, 2)
+	r.True(states[0].Peer.Equal(alice))
+	r.Equal("ok", states[0].LastResult)
+	r.False(states[0].LastExchange.IsZero())
+	r.True(states[1].Peer.Equal(bob))
+	r.Equal("stream reset", states[1].LastResult)
+
+	// a new connection resets the result but keeps what we received
+	ps.Connected(alice, time.Now())
+	st, _ = ps.ReplicationState(alice)
+	r.Equal("", st.LastResult)
+	r.True(st.LastExchange.IsZero())
+	r.EqualValues(2, st.Received[feed.Ref()])
+}
+
+func TestPeerStatesConcurrent(t *testing.T) {
+	ps := NewPeerStates()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			peer := &ssb.FeedRef{ID: bytes.Repeat([]byte{byte(i % 2)}, 32), Algo: ssb.RefAlgoFeedSSB1}
+			feed := &ssb.FeedRef{ID: bytes.Repeat([]byte{byte(i)}, 32), Algo: ssb.RefAlgoFeedSSB1}
+			for seq := int64(1); seq <= 100; seq++ {
+				ps.Received(peer, feed, seq)
+				ps.ReplicationStates()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	states := ps.ReplicationStates()
+	require.Len(t, states, 2)
+	for _, st := range states {
+		require.Len(t, st.Received, 4)
+	}
+}
+
+func TestPeerStatesMissing(t *testing.T) {
+	r := require.New(t)
+
+	mkRef := func(b byte) *ssb.FeedRef {
+		return &ssb.FeedRef{ID: bytes.Repeat([]byte{b}, 32), Algo: ssb.RefAlgoFeedSSB1}
+	}
+	alice, bob, feed, other := mkRef(1), mkRef(2), mkRef(3), mkRef(4)
+
+	dir, err := ioutil.TempDir("", t.Name())
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "peers")
+
+	ps, err := OpenPeerStates(path, time.Hour)
+	r.NoError(err)
+	now := time.Now()
+	ps.now = func() time.Time { return now }
+
+	r.False(ps.KnownMissing(alice, feed))
+	ps.NotHave(alice, feed)
+	r.True(ps.KnownMissing(alice, feed))
+	r.False(ps.KnownMissing(bob, feed), "only for that peer")
+
+	// a peer that gave us some of a feed just had nothing new
+	ps.Received(alice, other, 3)
+	ps.NotHave(alice, other)
+	r.False(ps.KnownMissing(alice, other))
+
+	// it survives a restart, only the peers that changed are written
+	ps.ExchangeDone(alice, nil)
+	r.NoError(ps.Save())
+	r.False(ps.peers[alice.Ref()].dirty)
+	ps.Connected(bob, now)
+	r.True(ps.peers[bob.Ref()].dirty)
+	r.False(ps.peers[alice.Ref()].dirty)
+	r.NoError(ps.Close())
+	ps, err = OpenPeerStates(path, time.Hour)
+	r.NoError(err)
+	ps.now = func() time.Time { return now }
+	r.True(ps.KnownMissing(alice, feed))
+	st, has := ps.ReplicationState(alice)
+	r.True(has)
+	r.EqualValues(3, st.Received[other.Ref()])
+	r.Len(st.Missing, 1)
+	r.True(st.LastExchange.Equal(now), "the exchange is stamped with the clock of the states")
+
+	// after a while it is asked again, so it can catch up once it has it
+	now = now.Add(59 * time.Minute)
+	r.True(ps.KnownMissing(alice, feed))
+	now = now.Add(time.Minute)
+	r.False(ps.KnownMissing(alice, feed))
+	st, _ = ps.ReplicationState(alice)
+	r.Len(st.Missing, 0)
+
+	// getting a message of it ends the marker right away
+	ps.NotHave(alice, feed)
+	r.True(ps.KnownMissing(alice, feed))
+	ps.Received(alice, feed, 1)
+	r.False(ps.KnownMissing(alice, feed))
+
+	_, has = ps.ReplicationState(bob)
+	r.True(has)
+	r.NoError(ps.Close())
+
+	// in-memory states are not written anywhere
+	r.NoError(NewPeerStates().Save())
+}
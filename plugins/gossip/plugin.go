@@ -6,6 +6,7 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/cryptix/go/logging"
 	"github.com/go-kit/kit/metrics"
@@ -13,6 +14,8 @@ import (
 	"go.cryptoscope.co/margaret/multilog"
 	"go.cryptoscope.co/muxrpc"
 	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/partial"
+	"go.cryptoscope.co/ssb/plugins/ebt"
 )
 
 type HMACSecret *[32]byte
@@ -21,6 +24,9 @@ type HopCount int
 
 type Promisc bool
 
+// FetchStrangers makes the handler fetch the feed of every peer it talks to, also the ones that aren't in the want list
+type FetchStrangers bool
+
 func New(
 	ctx context.Context,
 	log logging.Interface,
@@ -54,6 +60,18 @@ func New(
 			h.hmacSec = v
 		case Promisc:
 			h.promisc = bool(v)
+		case FetchStrangers:
+			h.strangers = bool(v)
+		case *PeerStates:
+			h.peers = v
+		case ssb.GossipEventRecorder:
+			h.events = v
+		case *ebt.Replicator:
+			h.ebt = v
+		case *partial.Store:
+			h.partial = v
+		case *Progress:
+			h.prog = v
 		default:
 			log.Log("warning", "unhandled option", "i", i, "type", fmt.Sprintf("%T", o))
 		}
@@ -104,10 +122,16 @@ func NewHist(
 			h.sysCtr = v
 		case Promisc:
 			h.promisc = bool(v)
+		case *PeerStates:
+			h.peers = v
+		case ssb.GossipEventRecorder:
+			h.events = v
 		case HopCount:
 			h.hopCount = int(v)
 		case HMACSecret:
 			h.hmacSec = v
+		case *partial.Store:
+			h.partial = v
 		default:
 			log.Log("warning", "unhandled hist option", "i", i, "type", fmt.Sprintf("%T", o))
 		}
@@ -135,6 +159,20 @@ type plugin struct {
 
 func (plugin) Name() string { return "gossip" }
 
+// Fetch gets the messages of feed that edp has and we don't, with createHistoryStream.
+// It returns right away if the feed is already being fetched.
+// If an ebt session runs on edp, that one fetches it instead and Fetch waits for it,
+// unless the session ends or the peer doesn't note the feed in time.
+func (p plugin) Fetch(ctx context.Context, edp muxrpc.Endpoint, feed *ssb.FeedRef) error {
+	if p.h.ebtActive(edp) && !p.h.partial.IsPartial(feed) {
+		err := p.h.ebt.Fetch(ctx, edp, feed)
+		if !ebt.IsUnsupported(err) {
+			return err
+		}
+	}
+	return p.h.fetchFeed(ctx, feed, edp, time.Now())
+}
+
 func (plugin) Method() muxrpc.Method {
 	return muxrpc.Method{"gossip"}
 }
@@ -0,0 +1,136 @@
+// SPDX-License-Identifier: MIT
+
+package gossip
+
+import (
+	"sync"
+	"time"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// ProgressBatch is after how many messages of a feed a fetch sends a progress event
+const ProgressBatch = 100
+
+// the seconds the rate of a Progress is measured over
+const rateWindow = 5
+
+// Progress follows the fetches of the handler and passes them on as ssb.ReplicationEvents.
+// Like the GossipLog it drops the events for followers that don't keep up instead of holding up the fetches.
+type Progress struct {
+	wants ssb.ReplicationLister // optional, for the pending feeds
+	now   func() time.Time
+
+	mu     sync.Mutex
+	active map[string]struct{}
+	done   map[string]struct{}
+
+	// the messages of the last seconds, by unix second modulo rateWindow
+	counts  [rateWindow]int64
+	seconds [rateWindow]int64
+
+	followers map[chan ssb.ReplicationEvent]struct{}
+}
+
+var _ ssb.ReplicationEventStreamer = (*Progress)(nil)
+
+// NewProgress returns an empty progress, the feeds in the replication list of wants that weren't fetched yet are pending
+func NewProgress(wants ssb.ReplicationLister) *Progress {
+	return &Progress{
+		wants:     wants,
+		now:       time.Now,
+		active:    make(map[string]struct{}),
+		done:      make(map[string]struct{}),
+		followers: make(map[chan ssb.ReplicationEvent]struct{}),
+	}
+}
+
+// ReplicationEvents returns the events from now on until cancel is called
+func (p *Progress) ReplicationEvents() (<-chan ssb.ReplicationEvent, func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ch := make(chan ssb.ReplicationEvent, 64)
+	p.followers[ch] = struct{}{}
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			p.mu.Lock()
+			delete(p.followers, ch)
+			p.mu.Unlock()
+			close(ch)
+		})
+	}
+	return ch, cancel
+}
+
+// start is called before a feed is fetched from peer, seq is the latest message we have
+func (p *Progress) start(feed *ssb.FeedRef, peer string, seq int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active[feed.Ref()] = struct{}{}
+	delete(p.done, feed.Ref())
+	p.send(ssb.ReplicationEvent{Event: ssb.ReplicationFeedStart, Feed: feed.Ref(), Peer: peer, Seq: seq})
+}
+
+// progress is called every ProgressBatch messages, n came since the last call
+func (p *Progress) progress(feed *ssb.FeedRef, peer string, seq, received, n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.count(n)
+	p.send(ssb.ReplicationEvent{Event: ssb.ReplicationFeedProgress, Feed: feed.Ref(), Peer: peer, Seq: seq, Received: received})
+}
+
+// finish is called once the fetch is over, n messages came since the last call to progress
+func (p *Progress) finish(feed *ssb.FeedRef, peer string, seq, received, n int64, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.count(n)
+	delete(p.active, feed.Ref())
+	p.done[feed.Ref()] = struct{}{}
+	evt := ssb.ReplicationEvent{Event: ssb.ReplicationFeedDone, Feed: feed.Ref(), Peer: peer, Seq: seq, Received: received}
+	if err != nil {
+		evt.Err = err.Error()
+	}
+	p.send(evt)
+}
+
+// count adds n messages to the current second
+func (p *Progress) count(n int64) {
+	sec := p.now().Unix()
+	i := sec % rateWindow
+	if p.seconds[i] != sec {
+		p.seconds[i], p.counts[i] = sec, 0
+	}
+	p.counts[i] += n
+}
+
+// rate returns the messages per second of the window up to now
+func (p *Progress) rate() float64 {
+	sec := p.now().Unix()
+	var n int64
+	for i := range p.counts {
+		if sec-p.seconds[i] < rateWindow {
+			n += p.counts[i]
+		}
+	}
+	return float64(n) / rateWindow
+}
+
+// send adds the summary to evt and passes it on, p.mu has to be held
+func (p *Progress) send(evt ssb.ReplicationEvent) {
+	evt.Time = p.now()
+	evt.Active = len(p.active)
+	evt.Done = len(p.done)
+	if p.wants != nil {
+		if pending := p.wants.ReplicationList().Count() - evt.Active - evt.Done; pending > 0 {
+			evt.Pending = pending
+		}
+	}
+	evt.Rate = p.rate()
+	for ch := range p.followers {
+		select {
+		case ch <- evt:
+		default:
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: MIT
+
+package gossip
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/ssb"
+)
+
+func TestProgressEvents(t *testing.T) {
+	r := require.New(t)
+
+	feed := func(b byte) *ssb.FeedRef {
+		return &ssb.FeedRef{ID: bytes.Repeat([]byte{b}, 32), Algo: ssb.RefAlgoFeedSSB1}
+	}
+	alice, bob, claire := feed(1), feed(2), feed(3)
+
+	wants := newTestLister()
+	for _, f := range []*ssb.FeedRef{alice, bob, claire} {
+		wants.feeds.AddRef(f)
+	}
+
+	now := time.Unix(1000, 0)
+	p := NewProgress(wants)
+	p.now = func() time.Time { return now }
+
+	evts, cancel := p.ReplicationEvents()
+	next := func() ssb.ReplicationEvent {
+		select {
+		case evt := <-evts:
+			return evt
+		default:
+			r.FailNow("no event")
+		}
+		return ssb.ReplicationEvent{}
+	}
+
+	p.start(alice, "peer", 3)
+	evt := next()
+	r.Equal(ssb.ReplicationFeedStart, evt.Event)
+	r.Equal(alice.Ref(), evt.Feed)
+	r.EqualValues(3, evt.Seq)
+	r.Equal(1, evt.Active)
+	r.Equal(2, evt.Pending)
+
+	p.progress(alice, "peer", 3+ProgressBatch, ProgressBatch, ProgressBatch)
+	evt = next()
+	r.Equal(ssb.ReplicationFeedProgress, evt.Event)
+	r.EqualValues(3+ProgressBatch, evt.Seq)
+	r.EqualValues(ProgressBatch, evt.Received)
+	r.Equal(float64(ProgressBatch)/rateWindow, evt.Rate)
+
+	now = now.Add(time.Second)
+	p.finish(alice, "peer", 3+ProgressBatch+10, ProgressBatch+10, 10, nil)
+	evt = next()
+	r.Equal(ssb.ReplicationFeedDone, evt.Event)
+	r.EqualValues(ProgressBatch+10, evt.Received)
+	r.Equal(0, evt.Active)
+	r.Equal(1, evt.Done)
+	r.Equal(2, evt.Pending)
+	r.Equal(float64(ProgressBatch+10)/rateWindow, evt.Rate)
+	r.Empty(evt.Err)
+
+	p.start(bob, "peer", 0)
+	next()
+	p.finish(bob, "peer", 0, 0, 0, errors.New("nope"))
+	evt = next()
+	r.Equal("nope", evt.Err)
+	r.Equal(2, evt.Done)
+	r.Equal(1, evt.Pending)
+
+	// the messages are out of the window after a while
+	now = now.Add(rateWindow * time.Second)
+	p.start(claire, "peer", 0)
+	evt = next()
+	r.Equal(0.0, evt.Rate)
+	r.Equal(0, evt.Pending)
+
+	cancel()
+	_, open := <-evts
+	r.False(open)
+}
+
+type testLister struct {
+	feeds *ssb.StrFeedSet
+}
+
+func newTestLister() testLister { return testLister{feeds: ssb.NewFeedSet(0)} }
+
+func (l testLister) Authorize(*ssb.FeedRef) error     { return nil }
+func (l testLister) ReplicationList() *ssb.StrFeedSet { return l.feeds }
+func (l testLister) BlockList() *ssb.StrFeedSet       { return ssb.NewFeedSet(0) }
@@ -7,9 +7,12 @@ import (
 	"encoding/json"
 
 	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/luigi/mfr"
 	"go.cryptoscope.co/ssb/internal/transform"
 	"go.cryptoscope.co/ssb/message"
 	"go.cryptoscope.co/ssb/private"
+	"go.cryptoscope.co/ssb/private/box2"
+	"go.cryptoscope.co/ssb/private/keys"
 
 	"go.cryptoscope.co/ssb"
 
@@ -24,6 +27,8 @@ type handler struct {
 
 	publish ssb.Publisher
 	read    margaret.Log
+	keys    *keys.Manager
+	replay  Replayer
 }
 
 func (h handler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
@@ -74,21 +79,35 @@ func (h handler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc
 			return
 		}
 
-		rcpsRefs := make([]*ssb.FeedRef, len(rcps))
+		var (
+			rcpsStrs = make([]string, len(rcps))
+			rcpsRefs = make([]*ssb.FeedRef, len(rcps))
+			useBox2  bool
+		)
 		for i, rv := range rcps {
 			rstr, ok := rv.(string)
 			if !ok {
 				req.CloseWithError(errors.Errorf("private/publish: wrong argument type. expected strings but got %T", rv))
 				return
 			}
+			rcpsStrs[i] = rstr
 			rcpsRefs[i], err = ssb.ParseFeedRef(rstr)
 			if err != nil {
-				req.CloseWithError(errors.Wrapf(err, "private/publish: failed to parse recp %d", i))
-				return
+				if h.keys == nil {
+					req.CloseWithError(errors.Wrapf(err, "private/publish: failed to parse recp %d", i))
+					return
+				}
+				// not a feed, box2 knows the keys of groups
+				useBox2 = true
 			}
 		}
 
-		ref, err := h.privatePublish(msg, rcpsRefs)
+		var ref *ssb.MessageRef
+		if useBox2 {
+			ref, err = h.privatePublishBox2(msg, rcpsStrs)
+		} else {
+			ref, err = h.privatePublish(msg, rcpsRefs)
+		}
 		if err != nil {
 			req.CloseWithError(err)
 			return
@@ -110,6 +129,20 @@ func (h handler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc
 		}
 		h.privateRead(ctx, req)
 
+	case "private.reindex":
+		if req.Type == "" {
+			req.Type = "async"
+		}
+		name, err := h.reindex(req)
+		if err != nil {
+			req.CloseWithError(err)
+			return
+		}
+		err = req.Return(ctx, map[string]string{"job": name})
+		if err != nil {
+			h.info.Log("event", "error", "msg", "cound't return replay job")
+		}
+
 	default:
 		checkAndClose(errors.Errorf("private: unknown command: %s", req.Method))
 	}
@@ -117,8 +150,12 @@ func (h handler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc
 
 func (h handler) HandleConnect(ctx context.Context, edp muxrpc.Endpoint) {}
 
+// privateRead streams the decrypted messages, in the short private.Decrypted form with their recipients for {recipients: true}
 func (h handler) privateRead(ctx context.Context, req *muxrpc.Request) {
-	var qry message.CreateHistArgs
+	var (
+		qry        message.CreateHistArgs
+		recipients bool
+	)
 
 	args := req.Args()
 	if len(args) > 0 {
@@ -131,6 +168,7 @@ func (h handler) privateRead(ctx context.Context, req *muxrpc.Request) {
 				return
 			}
 			qry = *q
+			recipients, _ = v["recipients"].(bool)
 		default:
 			req.CloseWithError(errors.Errorf("privateRead: invalid argument type %T", args[0]))
 			return
@@ -146,7 +184,18 @@ func (h handler) privateRead(ctx context.Context, req *muxrpc.Request) {
 	// well, sorry - the client lib needs better handling of receiving types
 	qry.Keys = true
 
-	src, err := h.read.Query(
+	// id picks the author, before the messages are decrypted
+	read := h.read
+	if qry.ID != nil {
+		ff, ok := read.(private.FromFilterer)
+		if !ok {
+			req.CloseWithError(errors.Errorf("private/read: can't filter by author"))
+			return
+		}
+		read = ff.From(qry.ID)
+	}
+
+	src, err := read.Query(
 		margaret.Gte(margaret.BaseSeq(qry.Seq)),
 		margaret.Limit(int(qry.Limit)),
 		margaret.Live(qry.Live))
@@ -155,7 +204,11 @@ func (h handler) privateRead(ctx context.Context, req *muxrpc.Request) {
 		return
 	}
 
-	err = luigi.Pump(ctx, transform.NewKeyValueWrapper(req.Stream, qry.Keys), src)
+	snk := transform.NewKeyValueWrapper(req.Stream, qry.Keys)
+	if recipients {
+		snk = decryptedSink(req.Stream)
+	}
+	err = luigi.Pump(ctx, snk, src)
 	if err != nil {
 		req.CloseWithError(errors.Wrap(err, "private/read: message pump failed"))
 		return
@@ -163,6 +216,58 @@ func (h handler) privateRead(ctx context.Context, req *muxrpc.Request) {
 	req.Close()
 }
 
+// decryptedSink pours the messages as private.Decrypted into snk
+func decryptedSink(snk luigi.Sink) luigi.Sink {
+	return mfr.SinkMap(snk, func(_ context.Context, v interface{}) (interface{}, error) {
+		if sw, ok := v.(margaret.SeqWrapper); ok {
+			v = sw.Value()
+		}
+		msg, ok := v.(ssb.Message)
+		if !ok {
+			return nil, errors.Errorf("private/read: unexpected message type %T", v)
+		}
+		data, err := json.Marshal(private.NewDecrypted(msg))
+		if err != nil {
+			return nil, errors.Wrap(err, "private/read: failed to encode message")
+		}
+		return json.RawMessage(data), nil
+	})
+}
+
+// reindex starts a replay of the encrypted messages, with the key of the group of the optional {group} argument or the keypair of the bot
+func (h handler) reindex(req *muxrpc.Request) (string, error) {
+	if h.replay == nil || h.keys == nil {
+		return "", errors.New("private/reindex: not supported by this bot")
+	}
+	var args []struct {
+		Group string `json:"group"`
+	}
+	if len(req.RawArgs) > 0 {
+		if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+			return "", errors.Wrap(err, "private/reindex: bad request")
+		}
+	}
+	var group string
+	if len(args) > 0 {
+		group = args[0].Group
+	}
+	return h.replay.ReplayPrivates(h.keys.KeyPair().Id, group)
+}
+
+// privatePublishBox2 publishes msg with box2, to the direct message keys of feeds and the keys of groups
+func (h handler) privatePublishBox2(msg []byte, recps []string) (*ssb.MessageRef, error) {
+	rs, err := h.keys.Recipients(recps)
+	if err != nil {
+		return nil, errors.Wrap(err, "private/publish")
+	}
+
+	ref, err := h.publish.Publish(box2.Content{Plain: msg, Recipients: rs})
+	if err != nil {
+		return nil, errors.Wrap(err, "private/publish: pour failed")
+	}
+	return ref, nil
+}
+
 func (h handler) privatePublish(msg []byte, recps []*ssb.FeedRef) (*ssb.MessageRef, error) {
 	boxedMsg, err := private.Box(msg, recps...)
 	if err != nil {
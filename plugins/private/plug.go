@@ -7,14 +7,24 @@ import (
 	"go.cryptoscope.co/margaret"
 	"go.cryptoscope.co/muxrpc"
 	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/private/keys"
 )
 
 type privatePlug struct {
 	h muxrpc.Handler
 }
 
-func NewPlug(i logging.Interface, publish ssb.Publisher, readIdx margaret.Log) ssb.Plugin {
-	return &privatePlug{h: handler{publish: publish, read: readIdx, info: i}}
+// Replayer reads the encrypted messages again with the key of group, or the keypair of feed if group is empty.
+// It returns the name of the job that does it.
+type Replayer interface {
+	ReplayPrivates(feed *ssb.FeedRef, group string) (string, error)
+}
+
+// NewPlug serves private.publish, private.read and private.reindex.
+// Messages to recipients that aren't feeds are published with box2, to the keys of km. Without km only box1 is published.
+// Without replay there is no private.reindex.
+func NewPlug(i logging.Interface, publish ssb.Publisher, readIdx margaret.Log, km *keys.Manager, replay Replayer) ssb.Plugin {
+	return &privatePlug{h: handler{publish: publish, read: readIdx, keys: km, replay: replay, info: i}}
 }
 
 func (p privatePlug) Name() string {
@@ -78,13 +78,19 @@ func (g rxLogHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp m
 		margaret.Limit(int(qry.Limit)),
 		margaret.Live(qry.Live),
 		margaret.Reverse(qry.Reverse),
+		margaret.SeqWrap(qry.RXSeq),
 	)
 	if err != nil {
 		req.CloseWithError(errors.Wrap(err, "logStream: failed to qry tipe"))
 		return
 	}
 
-	err = luigi.Pump(ctx, transform.NewKeyValueWrapper(req.Stream, qry.Keys), src)
+	snk := transform.NewKeyValueWrapper(req.Stream, qry.Keys)
+	if qry.RXSeq {
+		snk = transform.NewKeyValueRXSeqWrapper(req.Stream)
+	}
+
+	err = luigi.Pump(ctx, snk, src)
 	if err != nil {
 		req.CloseWithError(errors.Wrap(err, "logStream: failed to pump msgs"))
 		return
@@ -4,9 +4,11 @@ package replicate
 
 import (
 	"context"
+	"time"
 
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
 	"go.cryptoscope.co/margaret/multilog"
 	"go.cryptoscope.co/muxrpc"
 
@@ -18,10 +20,28 @@ type replicatePlug struct {
 }
 
 // TODO: add replicate, block, changes
-func NewPlug(users multilog.MultiLog) ssb.Plugin {
+// states serves replicate.peers, which returns what we know about the replication with other peers.
+// If it is also an ssb.ReplicationScheduler, replicate.schedule returns the replicated feeds in the order they are fetched.
+// If it is an ssb.FeedFetcher, replicate.fetch(feed, {timeout, dial}) fetches one feed right away. The timeout is in milliseconds.
+// If it is an ssb.ReplicationEventStreamer, the source replicate.changes streams the progress of the replication until it is closed.
+func NewPlug(users multilog.MultiLog, states ssb.ReplicationStater) ssb.Plugin {
 	plug := &replicatePlug{}
 	plug.h = replicateHandler{
-		users: users,
+		users:  users,
+		states: states,
+	}
+	return plug
+}
+
+// NewUptoPlug only serves replicate.upto, for the peers that don't get the other methods.
+// They learn how far we are with the feeds we replicate, which createHistoryStream would tell them anyhow.
+// Other feeds that happen to be in users are left out.
+func NewUptoPlug(users multilog.MultiLog, wants ssb.ReplicationLister) ssb.Plugin {
+	plug := &replicatePlug{}
+	plug.h = replicateHandler{
+		users:    users,
+		wants:    wants,
+		uptoOnly: true,
 	}
 	return plug
 }
@@ -36,18 +56,47 @@ func (lt replicatePlug) Handler() muxrpc.Handler {
 }
 
 type replicateHandler struct {
-	users multilog.MultiLog
+	users    multilog.MultiLog
+	states   ssb.ReplicationStater
+	wants    ssb.ReplicationLister // if set, upto only lists these feeds
+	uptoOnly bool
 }
 
 func (g replicateHandler) HandleConnect(ctx context.Context, e muxrpc.Endpoint) {}
 
 func (g replicateHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
-	if len(req.Method) < 2 && req.Method[1] != "upto" {
+	if g.uptoOnly && req.Method.String() != "replicate.upto" {
+		req.CloseWithError(errors.Errorf("invalid method"))
+		return
+	}
+	switch req.Method.String() {
+	case "replicate.upto":
+	case "replicate.peers":
+		g.peers(ctx, req)
+		return
+	case "replicate.schedule":
+		g.schedule(ctx, req)
+		return
+	case "replicate.fetch":
+		g.fetch(ctx, req)
+		return
+	case "replicate.changes":
+		g.changes(ctx, req)
+		return
+	default:
 		req.CloseWithError(errors.Errorf("invalid method"))
 		return
 	}
 
-	src, err := ssb.FeedsWithSequnce(g.users)
+	var (
+		src luigi.Source
+		err error
+	)
+	if g.wants != nil {
+		src, err = g.wantedUpto()
+	} else {
+		src, err = ssb.FeedsWithSequnce(g.users)
+	}
 	if err != nil {
 		req.CloseWithError(errors.Wrap(err, "replicate: did not get feed source"))
 		return
@@ -62,3 +111,147 @@ func (g replicateHandler) HandleCall(ctx context.Context, req *muxrpc.Request, e
 
 	req.Stream.Close()
 }
+
+// wantedUpto is FeedsWithSequnce for the replicated feeds only, without going through all the sublogs
+func (g replicateHandler) wantedUpto() (luigi.Source, error) {
+	feeds, err := g.wants.ReplicationList().List()
+	if err != nil {
+		return nil, errors.Wrap(err, "replicate: failed to list replicated feeds")
+	}
+	var upto []interface{}
+	for _, feed := range feeds {
+		subLog, err := g.users.Get(feed.StoredAddr())
+		if err != nil {
+			return nil, errors.Wrapf(err, "replicate: did not load sublog of %s", feed.Ref())
+		}
+		currSeq, err := subLog.Seq().Value()
+		if err != nil {
+			return nil, errors.Wrapf(err, "replicate: failed to get current seq of %s", feed.Ref())
+		}
+		seq, ok := currSeq.(margaret.Seq)
+		if !ok || seq.Seq() < 0 {
+			// nothing of it yet
+			continue
+		}
+		upto = append(upto, ssb.ReplicateUpToResponse{
+			ID:       *feed,
+			Sequence: seq.Seq() + 1,
+		})
+	}
+	src := luigi.SliceSource(upto)
+	return &src, nil
+}
+
+// schedule returns the feeds that are replicated with their priority, the most urgent first
+func (g replicateHandler) schedule(ctx context.Context, req *muxrpc.Request) {
+	sch, ok := g.states.(ssb.ReplicationScheduler)
+	if !ok {
+		req.CloseWithError(errors.New("replicate.schedule: no scheduler"))
+		return
+	}
+	prios := sch.Schedule()
+	if prios == nil {
+		prios = []ssb.FeedPriority{}
+	}
+	if err := req.Return(ctx, prios); err != nil {
+		req.CloseWithError(errors.Wrap(err, "replicate.schedule: failed to return schedule"))
+	}
+}
+
+// fetch fetches the feed of the first argument and returns how far it got
+func (g replicateHandler) fetch(ctx context.Context, req *muxrpc.Request) {
+	fetcher, ok := g.states.(ssb.FeedFetcher)
+	if !ok {
+		req.CloseWithError(errors.New("replicate.fetch: no fetcher"))
+		return
+	}
+	args := req.Args()
+	if len(args) < 1 {
+		req.CloseWithError(errors.New("replicate.fetch: expected a feed ref as argument"))
+		return
+	}
+	ref, ok := args[0].(string)
+	if !ok {
+		req.CloseWithError(errors.Errorf("replicate.fetch: expected a feed ref as argument, got %T", args[0]))
+		return
+	}
+	feed, err := ssb.ParseFeedRef(ref)
+	if err != nil {
+		req.CloseWithError(errors.Wrap(err, "replicate.fetch: invalid feed ref"))
+		return
+	}
+
+	var opts ssb.FetchOptions
+	if len(args) > 1 {
+		m, ok := args[1].(map[string]interface{})
+		if !ok {
+			req.CloseWithError(errors.Errorf("replicate.fetch: expected options as second argument, got %T", args[1]))
+			return
+		}
+		if ms, ok := m["timeout"].(float64); ok {
+			opts.Timeout = time.Duration(ms) * time.Millisecond
+		}
+		opts.Dial, _ = m["dial"].(bool)
+	}
+
+	res, err := fetcher.FetchFeed(ctx, feed, opts)
+	if err != nil {
+		req.CloseWithError(errors.Wrap(err, "replicate.fetch: failed"))
+		return
+	}
+	if err := req.Return(ctx, res); err != nil {
+		req.CloseWithError(errors.Wrap(err, "replicate.fetch: failed to return result"))
+	}
+}
+
+// changes streams the replication events from now on
+func (g replicateHandler) changes(ctx context.Context, req *muxrpc.Request) {
+	es, ok := g.states.(ssb.ReplicationEventStreamer)
+	if !ok {
+		req.CloseWithError(errors.New("replicate.changes: no replication events"))
+		return
+	}
+	evts, cancel := es.ReplicationEvents()
+	defer cancel()
+	for {
+		select {
+		case <-ctx.Done():
+			req.Stream.Close()
+			return
+		case evt := <-evts:
+			if err := req.Stream.Pour(ctx, evt); err != nil {
+				req.CloseWithError(errors.Wrap(err, "replicate.changes: failed to send event"))
+				return
+			}
+		}
+	}
+}
+
+// peers returns the replication state of all peers or, if a feed ref is passed, only the one of that peer
+func (g replicateHandler) peers(ctx context.Context, req *muxrpc.Request) {
+	var states []ssb.ReplicationState
+	if args := req.Args(); len(args) > 0 {
+		ref, ok := args[0].(string)
+		if !ok {
+			req.CloseWithError(errors.Errorf("replicate.peers: expected a feed ref as argument, got %T", args[0]))
+			return
+		}
+		peer, err := ssb.ParseFeedRef(ref)
+		if err != nil {
+			req.CloseWithError(errors.Wrap(err, "replicate.peers: invalid feed ref"))
+			return
+		}
+		if st, has := g.states.ReplicationState(peer); has {
+			states = append(states, st)
+		}
+	} else {
+		states = g.states.ReplicationStates()
+	}
+
+	if states == nil {
+		states = []ssb.ReplicationState{}
+	}
+	if err := req.Return(ctx, states); err != nil {
+		req.CloseWithError(errors.Wrap(err, "replicate.peers: failed to return states"))
+	}
+}
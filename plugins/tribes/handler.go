@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: MIT
+
+package tribes
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"go.cryptoscope.co/muxrpc"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/private/groups"
+)
+
+// CreateReply is what tribes.create returns: the id and key of the new group and its init message
+type CreateReply struct {
+	GroupID string          `json:"groupId"`
+	Key     string          `json:"groupKey"`
+	Root    *ssb.MessageRef `json:"groupInitMsg"`
+}
+
+type createH struct {
+	grps *groups.Groups
+}
+
+func (h createH) HandleAsync(ctx context.Context, req *muxrpc.Request) (interface{}, error) {
+	grp, err := h.grps.Create()
+	if err != nil {
+		return nil, err
+	}
+	return CreateReply{
+		GroupID: grp.ID,
+		Key:     base64.StdEncoding.EncodeToString(grp.Key),
+		Root:    grp.Root,
+	}, nil
+}
+
+type inviteH struct {
+	grps *groups.Groups
+}
+
+// HandleAsync takes [groupId, [feeds...], {text}], the options are optional
+func (h inviteH) HandleAsync(ctx context.Context, req *muxrpc.Request) (interface{}, error) {
+	var args []json.RawMessage
+	if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+		return nil, fmt.Errorf("invalid arguments on tribes.invite call: %w", err)
+	}
+	if n := len(args); n < 2 || n > 3 {
+		return nil, fmt.Errorf("tribes.invite: expected [groupId, [feeds...], {text}]")
+	}
+
+	var id string
+	if err := json.Unmarshal(args[0], &id); err != nil {
+		return nil, fmt.Errorf("tribes.invite: invalid group id: %w", err)
+	}
+	var members []*ssb.FeedRef
+	if err := json.Unmarshal(args[1], &members); err != nil {
+		return nil, fmt.Errorf("tribes.invite: invalid feeds: %w", err)
+	}
+	var opts struct {
+		Text string `json:"text"`
+	}
+	if len(args) == 3 {
+		if err := json.Unmarshal(args[2], &opts); err != nil {
+			return nil, fmt.Errorf("tribes.invite: invalid options: %w", err)
+		}
+	}
+
+	return h.grps.AddMember(id, members, opts.Text)
+}
+
+type listH struct {
+	grps *groups.Groups
+}
+
+func (h listH) HandleAsync(ctx context.Context, req *muxrpc.Request) (interface{}, error) {
+	ids := []string{}
+	for _, grp := range h.grps.List() {
+		ids = append(ids, grp.ID)
+	}
+	return ids, nil
+}
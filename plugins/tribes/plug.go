@@ -0,0 +1,49 @@
+// SPDX-License-Identifier: MIT
+
+// Package tribes serves the private groups of the bot over muxrpc.
+package tribes
+
+import (
+	"github.com/cryptix/go/logging"
+	"go.cryptoscope.co/muxrpc"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/muxmux"
+	"go.cryptoscope.co/ssb/private/groups"
+)
+
+/*
+
+  create: 'async',
+  invite: 'async',
+  list: 'async',
+
+*/
+
+var (
+	_      ssb.Plugin = plugin{} // compile-time type check
+	method            = muxrpc.Method{"tribes"}
+)
+
+// New serves tribes.create, tribes.invite and tribes.list with grps
+func New(log logging.Interface, grps *groups.Groups) ssb.Plugin {
+	rootHdlr := muxmux.New(log)
+
+	rootHdlr.RegisterAsync(muxrpc.Method{"tribes", "create"}, createH{grps: grps})
+	rootHdlr.RegisterAsync(muxrpc.Method{"tribes", "invite"}, inviteH{grps: grps})
+	rootHdlr.RegisterAsync(muxrpc.Method{"tribes", "list"}, listH{grps: grps})
+
+	return plugin{
+		h: &rootHdlr,
+	}
+}
+
+type plugin struct {
+	h muxrpc.Handler
+}
+
+func (plugin) Name() string { return "tribes" }
+
+func (plugin) Method() muxrpc.Method { return method }
+
+func (p plugin) Handler() muxrpc.Handler { return p.h }
@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: MIT
+
+package backlinks
+
+import (
+	"encoding/json"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// these fields of a message content are considered links to other messages, blobs or feeds
+var linkFields = []string{"root", "branch", "fork", "about", "mentions", "vote"}
+
+// how deep to walk into nested objects and arrays before giving up
+const maxDepth = 8
+
+// ExtractRefs returns all the references the content of a message links to.
+// Each reference is only returned once, invalid or unparsable content just results in no refs.
+func ExtractRefs(content []byte) []ssb.Ref {
+	var obj map[string]interface{}
+	if err := json.Unmarshal(content, &obj); err != nil {
+		// private messages or broken content
+		return nil
+	}
+
+	var ex extractor
+	ex.seen = make(map[string]struct{})
+	for _, field := range linkFields {
+		v, has := obj[field]
+		if !has {
+			continue
+		}
+		switch field {
+		case "vote":
+			// only vote.link is a reference
+			if vote, ok := v.(map[string]interface{}); ok {
+				ex.walk(vote["link"], 0)
+			}
+		default:
+			ex.walk(v, 0)
+		}
+	}
+	return ex.refs
+}
+
+type extractor struct {
+	seen map[string]struct{}
+	refs []ssb.Ref
+}
+
+func (ex *extractor) walk(v interface{}, depth int) {
+	if depth > maxDepth {
+		return
+	}
+	switch tv := v.(type) {
+	case string:
+		ex.add(tv)
+	case []interface{}:
+		for _, elem := range tv {
+			ex.walk(elem, depth+1)
+		}
+	case map[string]interface{}:
+		// mentions are objects like {link: ref, name: ...}
+		ex.walk(tv["link"], depth+1)
+		for k, elem := range tv {
+			if k == "link" {
+				continue
+			}
+			switch elem.(type) {
+			case []interface{}, map[string]interface{}:
+				ex.walk(elem, depth+1)
+			}
+		}
+	}
+}
+
+func (ex *extractor) add(s string) {
+	if len(s) < 2 {
+		return
+	}
+	switch s[0] {
+	case '%', '&', '@':
+	default:
+		return
+	}
+	if _, done := ex.seen[s]; done {
+		return
+	}
+	r, err := ssb.ParseRef(s)
+	if err != nil {
+		return
+	}
+	ex.seen[s] = struct{}{}
+	ex.refs = append(ex.refs, r)
+}
@@ -0,0 +1,47 @@
+// SPDX-License-Identifier: MIT
+
+package backlinks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractRefs(t *testing.T) {
+	const (
+		msgA = "%EMr6LTquV6Y8qkSaQ96ncL6oymbx4IddLdQKVGqYgGI=.sha256"
+		msgB = "%rkJMoEspdU75c1RpGbwjEH7eZxM/PJPFubpZTtynhsg=.sha256"
+		feed = "@iL6NzQoOLFP18pCpprkbY80DMtiG4JFFtVSVUaoGsOQ=.ed25519"
+		blob = "&I3yWHMF2kqC7fLZrC8FB+Kuu/6MQZIKzJGIjR3fVv9g=.sha256"
+	)
+
+	tcs := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{"post reply", `{"type":"post","text":"hi","root":"` + msgA + `","branch":"` + msgB + `"}`, []string{msgA, msgB}},
+		{"branch array", `{"type":"post","root":"` + msgA + `","branch":["` + msgA + `","` + msgB + `"]}`, []string{msgA, msgB}},
+		{"vote", `{"type":"vote","vote":{"link":"` + msgA + `","value":1}}`, []string{msgA}},
+		{"about", `{"type":"about","about":"` + feed + `","image":"` + blob + `"}`, []string{feed}},
+		{"fork", `{"type":"post","fork":"` + msgB + `"}`, []string{msgB}},
+		{"mentions", `{"type":"post","mentions":[{"link":"` + feed + `","name":"dust"},{"link":"` + blob + `"},"` + msgA + `"]}`, []string{feed, blob, msgA}},
+		{"nested mentions", `{"type":"post","mentions":[[{"link":"` + msgA + `","extra":{"link":"` + msgB + `"}}]]}`, []string{msgA, msgB}},
+		{"mention object", `{"type":"post","mentions":{"link":"` + feed + `"}}`, []string{feed}},
+
+		{"private", `"ASDASDASD.box"`, nil},
+		{"broken json", `{"type":"post","root":`, nil},
+		{"invalid refs", `{"type":"post","root":"%nope","branch":[1,2,null],"vote":"` + msgA + `","mentions":[{"link":42},"@"]}`, nil},
+		{"wrong types", `{"type":"post","root":{"foo":"bar"},"mentions":true,"vote":{"link":["` + msgA + `"]}}`, []string{msgA}},
+	}
+
+	for _, tc := range tcs {
+		refs := ExtractRefs([]byte(tc.content))
+		var got []string
+		for _, r := range refs {
+			got = append(got, r.Ref())
+		}
+		assert.ElementsMatch(t, tc.want, got, "case: %s", tc.name)
+	}
+}
@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: MIT
+
+package backlinks
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/librarian"
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/margaret/multilog"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+// MakeMultiLog opens the dest->source index.
+// Each sublog is addressed by the compact storage ref of the link target and holds the receive log sequences of the messages that link to it.
+func (plug *Plugin) MakeMultiLog(r repo.Interface) (multilog.MultiLog, librarian.SinkIndex, error) {
+	mlog, serve, err := repo.OpenMultiLog(r, plug.Name(), IndexUpdate)
+	plug.h.links = mlog
+	return mlog, serve, err
+}
+
+func IndexUpdate(ctx context.Context, seq margaret.Seq, msgv interface{}, mlog multilog.MultiLog) error {
+	if nulled, ok := msgv.(error); ok {
+		if margaret.IsErrNulled(nulled) {
+			return nil
+		}
+		return nulled
+	}
+	msg, ok := msgv.(ssb.Message)
+	if !ok {
+		err := errors.Errorf("error casting message. got type %T", msgv)
+		return err
+	}
+
+	for _, ref := range ExtractRefs(msg.ContentBytes()) {
+		addr, ok := storedAddr(ref)
+		if !ok {
+			continue
+		}
+
+		linkLog, err := mlog.Get(addr)
+		if err != nil {
+			return errors.Wrap(err, "error opening sublog")
+		}
+
+		_, err = linkLog.Append(seq)
+		if err != nil {
+			return errors.Wrapf(err, "error appending link to %s", ref.Ref())
+		}
+	}
+	return nil
+}
+
+// storedAddr returns the compact binary key for a reference. Refs that have no binary representation are skipped.
+func storedAddr(ref ssb.Ref) (librarian.Addr, bool) {
+	sr, err := ssb.NewStorageRef(ref)
+	if err != nil {
+		return "", false
+	}
+	b, err := sr.Marshal()
+	if err != nil {
+		return "", false
+	}
+	return librarian.Addr(b), true
+}
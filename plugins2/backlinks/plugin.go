@@ -0,0 +1,140 @@
+// SPDX-License-Identifier: MIT
+
+package backlinks
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/margaret/multilog"
+	"go.cryptoscope.co/muxrpc"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/livelog"
+	"go.cryptoscope.co/ssb/internal/mutil"
+	"go.cryptoscope.co/ssb/internal/transform"
+	"go.cryptoscope.co/ssb/message"
+	"go.cryptoscope.co/ssb/plugins2"
+)
+
+type Plugin struct {
+	h handler
+}
+
+var (
+	_ plugins2.NeedsRootLog = (*Plugin)(nil)
+)
+
+func (plug *Plugin) WantRootLog(rl margaret.Log) error {
+	plug.h.root = rl
+	return nil
+}
+
+func (Plugin) Name() string                 { return "backlinks" }
+func (Plugin) Method() muxrpc.Method        { return muxrpc.Method{"backlinks"} }
+func (plug Plugin) Handler() muxrpc.Handler { return plug.h }
+
+type handler struct {
+	root  margaret.Log
+	links multilog.MultiLog
+}
+
+func (h handler) HandleConnect(ctx context.Context, e muxrpc.Endpoint) {}
+
+func (h handler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
+	if len(req.Method) != 2 || req.Method[1] != "read" {
+		req.CloseWithError(errors.Errorf("backlinks: unsupported call %s", req.Method))
+		return
+	}
+
+	var args []message.BacklinksArgs
+	if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+		req.CloseWithError(errors.Wrap(err, "backlinks: bad request"))
+		return
+	}
+	if len(args) != 1 {
+		req.CloseWithError(errors.Errorf("backlinks: expected one argument object"))
+		return
+	}
+	qry := args[0]
+
+	if qry.Dest == "" {
+		req.CloseWithError(errors.Errorf("backlinks: bad request - missing dest"))
+		return
+	}
+	dest, err := ssb.ParseRef(qry.Dest)
+	if err != nil {
+		req.CloseWithError(errors.Wrap(err, "backlinks: bad request - invalid dest"))
+		return
+	}
+	addr, ok := storedAddr(dest)
+	if !ok {
+		req.CloseWithError(errors.Errorf("backlinks: unsupported dest reference %s", qry.Dest))
+		return
+	}
+
+	if qry.Limit == 0 {
+		qry.Limit = -1
+	}
+
+	linkLog, err := h.links.Get(addr)
+	if err != nil {
+		req.CloseWithError(errors.Wrap(err, "backlinks: failed to open sublog"))
+		return
+	}
+
+	src, err := livelog.Query(ctx, mutil.Indirect(h.root, linkLog), livelog.DefaultQueueSize,
+		margaret.Live(qry.Live), margaret.Reverse(qry.Reverse))
+	if err != nil {
+		req.CloseWithError(errors.Wrap(err, "backlinks: failed to query links"))
+		return
+	}
+
+	snk := transform.NewKeyValueWrapper(req.Stream, qry.Keys)
+	err = pumpFiltered(ctx, snk, src, qry.Type, qry.Limit)
+	if err != nil {
+		req.CloseWithError(errors.Wrap(err, "backlinks: failed to pump msgs"))
+		return
+	}
+
+	req.Stream.Close()
+}
+
+// pumpFiltered is like luigi.Pump but skips messages that don't have the wanted type.
+// The limit is applied to the filtered messages.
+func pumpFiltered(ctx context.Context, snk luigi.Sink, src luigi.Source, tipe string, limit int64) error {
+	for limit != 0 {
+		v, err := src.Next(ctx)
+		if err != nil {
+			if luigi.IsEOS(err) {
+				return nil
+			}
+			return err
+		}
+
+		if tipe != "" {
+			msg, ok := v.(ssb.Message)
+			if !ok {
+				// nulled messages and the like
+				continue
+			}
+			var typed struct {
+				Type string `json:"type"`
+			}
+			if err := json.Unmarshal(msg.ContentBytes(), &typed); err != nil || typed.Type != tipe {
+				continue
+			}
+		}
+
+		if err := snk.Pour(ctx, v); err != nil {
+			return err
+		}
+		if limit > 0 {
+			limit--
+		}
+	}
+	return nil
+}
@@ -11,6 +11,7 @@ import (
 	"go.cryptoscope.co/margaret"
 	"go.cryptoscope.co/margaret/multilog"
 	"go.cryptoscope.co/muxrpc"
+	"go.cryptoscope.co/ssb/internal/livelog"
 	"go.cryptoscope.co/ssb/internal/mutil"
 	"go.cryptoscope.co/ssb/internal/transform"
 	"go.cryptoscope.co/ssb/message"
@@ -86,7 +87,8 @@ func (g handler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc
 		return
 	}
 
-	src, err := mutil.Indirect(g.root, threadLog).Query(margaret.Limit(int(qry.Limit)), margaret.Live(qry.Live), margaret.Reverse(qry.Reverse))
+	src, err := livelog.Query(ctx, mutil.Indirect(g.root, threadLog), livelog.DefaultQueueSize,
+		margaret.Limit(int(qry.Limit)), margaret.Live(qry.Live), margaret.Reverse(qry.Reverse))
 	if err != nil {
 		req.CloseWithError(errors.Wrap(err, "logT: failed to qry tipe"))
 		return
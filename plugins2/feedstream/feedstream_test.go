@@ -0,0 +1,183 @@
+// SPDX-License-Identifier: MIT
+
+package feedstream
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/message"
+	"go.cryptoscope.co/ssb/message/legacy"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+func TestSortTimestamp(t *testing.T) {
+	r := require.New(t)
+
+	rx := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	r.Equal(rx.Add(-time.Hour), SortTimestamp(rx.Add(-time.Hour), rx), "past claims are kept")
+	r.Equal(rx.Add(MaxClockSkew), SortTimestamp(rx.Add(MaxClockSkew), rx), "claims up to the skew are kept")
+	r.Equal(rx, SortTimestamp(rx.Add(MaxClockSkew+time.Millisecond), rx), "claims past the skew are clamped")
+
+	year3000 := time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC)
+	r.Equal(rx, SortTimestamp(year3000, rx), "the year 3000 is clamped")
+
+	// no overflow for far away times
+	r.True(millis(year3000) > millis(rx))
+	r.EqualValues(32503680000000, millis(year3000))
+	r.EqualValues(-1000, millis(time.Unix(-1, 0)))
+}
+
+func TestFeedStream(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	testPath, err := ioutil.TempDir("", t.Name())
+	r.NoError(err)
+	defer os.RemoveAll(testPath)
+
+	tRepo := repo.New(testPath)
+	rl, err := repo.OpenLog(tRepo)
+	r.NoError(err)
+	defer rl.Close()
+
+	var plug Plugin
+	r.NoError(plug.WantRootLog(rl))
+	_, snk, err := plug.MakeSimpleIndex(tRepo)
+	r.NoError(err)
+	defer snk.Close()
+
+	kp, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+
+	base := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	var prev *ssb.MessageRef
+	appendMsg := func(i int, claimed time.Time) {
+		lm := legacy.LegacyMessage{
+			Previous:  prev,
+			Author:    kp.Id.Ref(),
+			Sequence:  margaret.BaseSeq(i + 1),
+			Timestamp: millis(claimed),
+			Hash:      "sha256",
+			Content:   map[string]interface{}{"type": "test", "i": i},
+		}
+		ref, raw, err := lm.Sign(kp.Pair.Secret[:], nil)
+		r.NoError(err)
+
+		// received one minute after the other
+		_, err = rl.Append(&legacy.StoredMessage{
+			Author_:    kp.Id,
+			Previous_:  prev,
+			Key_:       ref,
+			Sequence_:  margaret.BaseSeq(i + 1),
+			Timestamp_: base.Add(time.Duration(i) * time.Minute),
+			Raw_:       raw,
+		})
+		r.NoError(err)
+		prev = ref
+	}
+
+	claims := []time.Time{
+		base.Add(-10 * time.Minute),
+		base.Add(-30 * time.Minute),
+		base.Add(-20 * time.Minute),
+		time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC), // clamped to base+3min
+		base.Add(-30 * time.Minute),                 // same as 1, received later
+		base.Add(-5 * time.Minute),
+		base.Add(10 * time.Minute), // in the future but within the skew
+	}
+	for i, c := range claims {
+		appendMsg(i, c)
+	}
+
+	updateIndex := func() {
+		src, err := rl.Query(margaret.SeqWrap(true), snk.QuerySpec())
+		r.NoError(err)
+		r.NoError(luigi.Pump(ctx, snk, src))
+	}
+	updateIndex()
+
+	stream := func(qry message.CreateFeedArgs) ([]int, error) {
+		var got []int
+		err := plug.h.stream(ctx, luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error {
+			if err != nil {
+				return err
+			}
+			var c struct{ I int }
+			if err := json.Unmarshal(v.(ssb.Message).ContentBytes(), &c); err != nil {
+				return err
+			}
+			got = append(got, c.I)
+			return nil
+		}), qry)
+		return got, err
+	}
+	collect := func(qry message.CreateFeedArgs) []int {
+		got, err := stream(qry)
+		r.NoError(err)
+		return got
+	}
+	ms := func(t time.Time) float64 { return float64(millis(t)) }
+
+	var qry message.CreateFeedArgs
+	qry.Limit = -1
+	r.Equal([]int{1, 4, 2, 0, 5, 3, 6}, collect(qry))
+
+	qry.Reverse = true
+	r.Equal([]int{6, 3, 5, 0, 2, 4, 1}, collect(qry))
+
+	qry.Limit = 2
+	r.Equal([]int{6, 3}, collect(qry))
+
+	qry = message.CreateFeedArgs{}
+	qry.Limit = -1
+	qry.Gt = ms(base.Add(-30 * time.Minute))
+	qry.Lt = ms(base.Add(3 * time.Minute))
+	r.Equal([]int{2, 0, 5}, collect(qry))
+
+	qry.Reverse = true
+	r.Equal([]int{5, 0, 2}, collect(qry))
+
+	// messages the index didn't see yet are not in the backlog
+	appendMsg(len(claims), base.Add(-time.Hour))
+	qry = message.CreateFeedArgs{}
+	qry.Limit = -1
+	r.Equal([]int{1, 4, 2, 0, 5, 3, 6}, collect(qry))
+	updateIndex()
+	r.Equal([]int{7, 1, 4, 2, 0, 5, 3, 6}, collect(qry))
+
+	// nulled messages are skipped
+	r.NoError(rl.Null(margaret.BaseSeq(4)))
+	r.Equal([]int{7, 1, 2, 0, 5, 3, 6}, collect(qry))
+
+	// live streams the backlog and then new messages in the order they arrive
+	qry.Live = true
+	qry.Limit = 5
+	qry.Gt = ms(base.Add(-20 * time.Minute))
+	done := make(chan []int)
+	go func() {
+		got, err := stream(qry)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- got
+	}()
+	appendMsg(len(claims)+1, base.Add(-time.Hour)) // out of range
+	appendMsg(len(claims)+2, base.Add(time.Minute))
+	select {
+	case got := <-done:
+		r.Equal([]int{0, 5, 3, 6, 9}, got)
+	case <-time.After(5 * time.Second):
+		t.Fatal("live stream didn't return")
+	}
+}
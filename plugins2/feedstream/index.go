@@ -0,0 +1,198 @@
+// SPDX-License-Identifier: MIT
+
+package feedstream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/librarian"
+	libbadger "go.cryptoscope.co/librarian/badger"
+	"go.cryptoscope.co/margaret"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+// MaxClockSkew is how far the claimed timestamp of a message may be ahead of the time it was received.
+// A message that claims a later time is sorted by the time it was received instead.
+// Otherwise a message from a broken clock (or one claiming to be from the year 3000) would stay on top of every feed forever.
+const MaxClockSkew = time.Hour
+
+// SortTimestamp returns the time a message is sorted by in createFeedStream.
+// That is the claimed time, unless it is more than MaxClockSkew after received, then it's received.
+func SortTimestamp(claimed, received time.Time) time.Time {
+	if claimed.After(received.Add(MaxClockSkew)) {
+		return received
+	}
+	return claimed
+}
+
+// millis is like UnixNano()/1e6 but doesn't overflow for times after 2262
+func millis(t time.Time) int64 {
+	return t.Unix()*1000 + int64(t.Nanosecond())/int64(time.Millisecond)
+}
+
+var (
+	prefixClaimed = []byte("claimed:")
+
+	// the receive log sequence of the last message the index has seen
+	keyIndexedSeq = []byte("claimed-seq")
+)
+
+// an entry of the index is only a key:
+// prefix | sort timestamp | received timestamp | receive log sequence
+// all as big-endian uint64 with the sign bit flipped, so that they sort bytewise.
+const entryLen = 8 * 3
+
+func entryKey(sortTs, rxTs, seq int64) []byte {
+	k := make([]byte, len(prefixClaimed)+entryLen)
+	n := copy(k, prefixClaimed)
+	for _, v := range []int64{sortTs, rxTs, seq} {
+		binary.BigEndian.PutUint64(k[n:], uint64(v)^(1<<63))
+		n += 8
+	}
+	return k
+}
+
+// boundKey is the key right before all entries with the sort timestamp ts
+func boundKey(ts int64) []byte {
+	k := entryKey(ts, 0, 0)
+	return k[:len(prefixClaimed)+8]
+}
+
+func decodeEntry(k []byte) (sortTs, seq int64, err error) {
+	if len(k) != len(prefixClaimed)+entryLen || !bytes.HasPrefix(k, prefixClaimed) {
+		return 0, 0, errors.Errorf("feedstream: invalid index key %x", k)
+	}
+	k = k[len(prefixClaimed):]
+	sortTs = int64(binary.BigEndian.Uint64(k[0:8]) ^ (1 << 63))
+	seq = int64(binary.BigEndian.Uint64(k[16:24]) ^ (1 << 63))
+	return sortTs, seq, nil
+}
+
+type claimStore struct {
+	kv *badger.DB
+}
+
+// MakeSimpleIndex opens the claimed timestamp -> receive log sequence index
+func (plug *Plugin) MakeSimpleIndex(r repo.Interface) (librarian.Index, librarian.SinkIndex, error) {
+	f := func(db *badger.DB) (librarian.SeqSetterIndex, librarian.SinkIndex) {
+		idx := libbadger.NewIndex(db, 0)
+		cs := claimStore{kv: db}
+		return idx, repo.NewBadgerBatchSink(db, keyIndexedSeq, idx, cs.update)
+	}
+
+	db, idx, update, err := repo.OpenBadgerIndex(r, plug.Name(), f)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error getting claimed timestamp index")
+	}
+	plug.h.claims = claimStore{kv: db}
+
+	return idx, update, nil
+}
+
+func (cs claimStore) update(txn *badger.Txn, seq margaret.Seq, msgv interface{}) error {
+	if nulled, ok := msgv.(error); ok {
+		if margaret.IsErrNulled(nulled) {
+			return nil
+		}
+		return nulled
+	}
+	msg, ok := msgv.(ssb.Message)
+	if !ok {
+		return errors.Errorf("feedstream(%d): wrong msgT: %T", seq.Seq(), msgv)
+	}
+
+	rx := msg.Received()
+	k := entryKey(millis(SortTimestamp(msg.Claimed(), rx)), millis(rx), seq.Seq())
+	return errors.Wrap(txn.Set(k, nil), "db/idx feedstream: failed to update index")
+}
+
+// indexedSeq returns the receive log sequence of the last message in the index
+func (cs claimStore) indexedSeq() (margaret.Seq, error) {
+	seq, err := repo.BadgerIndexedSeq(cs.kv, keyIndexedSeq)
+	return seq, errors.Wrap(err, "feedstream: failed to get indexed sequence")
+}
+
+// claimRange are the bounds of a query on the index and where it left off.
+// gt and lt are sort timestamps in milliseconds, entries for messages after maxSeq are skipped.
+type claimRange struct {
+	gt, lt  *int64
+	reverse bool
+	maxSeq  int64
+
+	lastKey []byte
+	done    bool
+}
+
+func (cr claimRange) contains(ts int64) bool {
+	if cr.gt != nil && ts <= *cr.gt {
+		return false
+	}
+	if cr.lt != nil && ts >= *cr.lt {
+		return false
+	}
+	return true
+}
+
+// next returns up to n receive log sequences that follow the ones of the last call, in the order of the index.
+// Entries past maxSeq are skipped. It returns nothing once the range is exhausted.
+func (cs claimStore) next(cr *claimRange, n int) ([]margaret.Seq, error) {
+	if cr.done {
+		return nil, nil
+	}
+	var seqs []margaret.Seq
+	err := cs.kv.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Reverse = cr.reverse
+		iter := txn.NewIterator(opts)
+		defer iter.Close()
+
+		start := cr.lastKey
+		if start == nil {
+			switch {
+			case !cr.reverse && cr.gt != nil:
+				start = boundKey(*cr.gt + 1)
+			case !cr.reverse:
+				start = prefixClaimed
+			case cr.lt != nil:
+				// the largest key before all the entries at lt
+				start = boundKey(*cr.lt)
+			default:
+				start = append(append([]byte{}, prefixClaimed...), bytes.Repeat([]byte{0xff}, entryLen+1)...)
+			}
+		}
+
+		for iter.Seek(start); iter.ValidForPrefix(prefixClaimed); iter.Next() {
+			k := iter.Item().KeyCopy(nil)
+			if bytes.Equal(k, cr.lastKey) {
+				continue
+			}
+			ts, seq, err := decodeEntry(k)
+			if err != nil {
+				return err
+			}
+			if !cr.contains(ts) {
+				// sorted by ts, so everything that follows is out of range as well
+				cr.done = true
+				return nil
+			}
+			cr.lastKey = k
+			if seq > cr.maxSeq {
+				continue
+			}
+			seqs = append(seqs, margaret.BaseSeq(seq))
+			if len(seqs) >= n {
+				return nil
+			}
+		}
+		cr.done = true
+		return nil
+	})
+	return seqs, errors.Wrap(err, "feedstream: failed to iterate index")
+}
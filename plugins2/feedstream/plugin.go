@@ -0,0 +1,169 @@
+// SPDX-License-Identifier: MIT
+
+// Package feedstream serves createFeedStream, all messages ordered by the timestamps their authors claim.
+// Claims too far in the future are sorted by the time the message was received, see SortTimestamp.
+package feedstream
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/muxrpc"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/transform"
+	"go.cryptoscope.co/ssb/message"
+	"go.cryptoscope.co/ssb/plugins2"
+)
+
+// how many sequences are read from the index at once
+const batchSize = 256
+
+type Plugin struct {
+	h handler
+}
+
+var (
+	_ plugins2.NeedsRootLog = (*Plugin)(nil)
+)
+
+func (plug *Plugin) WantRootLog(rl margaret.Log) error {
+	plug.h.root = rl
+	return nil
+}
+
+func (Plugin) Name() string                 { return "feedstream" }
+func (Plugin) Method() muxrpc.Method        { return muxrpc.Method{"createFeedStream"} }
+func (plug Plugin) Handler() muxrpc.Handler { return plug.h }
+
+type handler struct {
+	root   margaret.Log
+	claims claimStore
+}
+
+func (h handler) HandleConnect(ctx context.Context, e muxrpc.Endpoint) {}
+
+func (h handler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
+	var qry message.CreateFeedArgs
+	qry.Limit = -1
+	if len(req.RawArgs) > 0 {
+		var args []message.CreateFeedArgs
+		if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+			req.CloseWithError(errors.Wrap(err, "feedstream: bad request"))
+			return
+		}
+		if len(args) > 1 {
+			req.CloseWithError(errors.Errorf("feedstream: expected one argument object"))
+			return
+		}
+		if len(args) == 1 {
+			qry = args[0]
+		}
+	}
+	if qry.Limit == 0 {
+		qry.Limit = -1
+	}
+	if qry.Live && qry.Reverse {
+		req.CloseWithError(errors.Errorf("feedstream: live and reverse can't be combined"))
+		return
+	}
+
+	snk := transform.NewKeyValueWrapper(req.Stream, qry.Keys)
+	err := h.stream(ctx, snk, qry)
+	if err != nil {
+		req.CloseWithError(errors.Wrap(err, "feedstream: failed to pump msgs"))
+		return
+	}
+
+	req.Stream.Close()
+}
+
+// stream pours the messages in the index into snk and, if qry.Live is set, the new messages as they arrive.
+// New messages are streamed in the order they are received.
+func (h handler) stream(ctx context.Context, snk luigi.Sink, qry message.CreateFeedArgs) error {
+	indexed, err := h.claims.indexedSeq()
+	if err != nil {
+		return err
+	}
+
+	cr := claimRange{
+		reverse: qry.Reverse,
+		maxSeq:  indexed.Seq(),
+	}
+	if qry.Gt != 0 {
+		gt := int64(math.Floor(qry.Gt))
+		cr.gt = &gt
+	}
+	if qry.Lt != 0 {
+		lt := int64(math.Ceil(qry.Lt))
+		cr.lt = &lt
+	}
+
+	limit := qry.Limit
+	for limit != 0 {
+		seqs, err := h.claims.next(&cr, batchSize)
+		if err != nil {
+			return err
+		}
+		if len(seqs) == 0 {
+			break
+		}
+
+		for _, seq := range seqs {
+			v, err := h.root.Get(seq)
+			if margaret.IsErrNulled(err) {
+				continue
+			} else if err != nil {
+				return errors.Wrapf(err, "failed to get message %d", seq.Seq())
+			}
+			if err := snk.Pour(ctx, v); err != nil {
+				return err
+			}
+			if limit > 0 {
+				limit--
+				if limit == 0 {
+					break
+				}
+			}
+		}
+	}
+
+	if !qry.Live || limit == 0 {
+		return nil
+	}
+
+	src, err := h.root.Query(margaret.Gt(indexed), margaret.Live(true))
+	if err != nil {
+		return errors.Wrap(err, "failed to query live messages")
+	}
+	for limit != 0 {
+		v, err := src.Next(ctx)
+		if err != nil {
+			if luigi.IsEOS(err) {
+				return nil
+			}
+			return err
+		}
+
+		msg, ok := v.(ssb.Message)
+		if !ok {
+			// nulled messages and the like
+			continue
+		}
+		if !cr.contains(millis(SortTimestamp(msg.Claimed(), msg.Received()))) {
+			continue
+		}
+
+		if err := snk.Pour(ctx, v); err != nil {
+			return err
+		}
+		if limit > 0 {
+			limit--
+		}
+	}
+	return nil
+}
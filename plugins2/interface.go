@@ -3,6 +3,8 @@
 package plugins2
 
 import (
+	"context"
+
 	"go.cryptoscope.co/margaret"
 	"go.cryptoscope.co/ssb"
 )
@@ -25,3 +27,10 @@ type NeedsRootLog interface {
 type NeedsMultiLog interface {
 	WantMultiLog(ssb.MultiLogGetter) error
 }
+
+// Stopper is implemented by plugins that have to finish work before the bot closes its logs.
+// Stop is called by sbot.GracefulShutdown after the open calls were drained.
+type Stopper interface {
+	ssb.Plugin
+	Stop(context.Context) error
+}
@@ -4,7 +4,6 @@ package names
 
 import (
 	"bytes"
-	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -197,14 +196,18 @@ func (ab aboutStore) CollectedFor(ref *ssb.FeedRef) (*AboutInfo, error) {
 
 const FolderNameAbout = "about"
 
+// keyAboutSeq is where the about index stores up to which message it is updated
+var keyAboutSeq = []byte("about-seq")
+
 func (plug *Plugin) MakeSimpleIndex(r repo.Interface) (librarian.Index, librarian.SinkIndex, error) {
+	var readIdx librarian.Index
 	f := func(db *badger.DB) (librarian.SeqSetterIndex, librarian.SinkIndex) {
 		aboutIdx := libbadger.NewIndex(db, 0)
-
-		return aboutIdx, librarian.NewSinkIndex(updateAboutMessage, aboutIdx)
+		readIdx = repo.NewBadgerReadIndex(db, "")
+		return aboutIdx, repo.NewBadgerBatchSink(db, keyAboutSeq, aboutIdx, updateAboutMessage)
 	}
 
-	db, idx, update, err := repo.OpenBadgerIndex(r, FolderNameAbout, f)
+	db, _, update, err := repo.OpenBadgerIndex(r, FolderNameAbout, f)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "error getting about index")
 	}
@@ -213,13 +216,13 @@ func (plug *Plugin) MakeSimpleIndex(r repo.Interface) (librarian.Index, libraria
 
 	plug.about = aboutStore{db}
 
-	return idx, update, err
+	return readIdx, update, err
 }
 
-func updateAboutMessage(ctx context.Context, seq margaret.Seq, msgv interface{}, idx librarian.SetterIndex) error {
+func updateAboutMessage(txn *badger.Txn, seq margaret.Seq, msgv interface{}) error {
 	msg, ok := msgv.(ssb.Message)
 	if !ok {
-		if margaret.IsErrNulled(msgv.(error)) {
+		if err, ok := msgv.(error); ok && margaret.IsErrNulled(err) {
 			return nil
 		}
 		return fmt.Errorf("about(%d): wrong msgT: %T", seq, msgv)
@@ -230,10 +233,6 @@ func updateAboutMessage(ctx context.Context, seq margaret.Seq, msgv interface{},
 	if err != nil {
 		// fmt.Println("msg", "skipped contact message", "reason", err)
 		return nil
-		// debugging
-		if ssb.IsMessageUnusable(err) {
-			return nil
-		}
 	}
 
 	// about:from:field
@@ -242,22 +241,20 @@ func updateAboutMessage(ctx context.Context, seq margaret.Seq, msgv interface{},
 	addr += msg.Author().Ref()
 	addr += ":"
 
-	var val string
-	if aboutMSG.Name != "" {
-		val = aboutMSG.Name
-		if err := idx.Set(ctx, librarian.Addr(addr+"name"), val); err != nil {
-			return errors.Wrap(err, "db/idx about: failed to update field")
-		}
-	}
-	if aboutMSG.Description != "" {
-		val = aboutMSG.Description
-		if err := idx.Set(ctx, librarian.Addr(addr+"description"), val); err != nil {
-			return errors.Wrap(err, "db/idx about: failed to update field")
-		}
+	fields := []struct {
+		name, val string
+	}{
+		{"name", aboutMSG.Name},
+		{"description", aboutMSG.Description},
 	}
 	if aboutMSG.Image != nil {
-		val = aboutMSG.Image.Ref()
-		if err := idx.Set(ctx, librarian.Addr(addr+"image"), val); err != nil {
+		fields = append(fields, struct{ name, val string }{"image", aboutMSG.Image.Ref()})
+	}
+	for _, f := range fields {
+		if f.val == "" {
+			continue
+		}
+		if err := repo.BadgerSetJSON(txn, []byte(addr+f.name), f.val); err != nil {
 			return errors.Wrap(err, "db/idx about: failed to update field")
 		}
 	}
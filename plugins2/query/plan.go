@@ -0,0 +1,220 @@
+// SPDX-License-Identifier: MIT
+
+package query
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/librarian"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/mutil"
+	"go.cryptoscope.co/ssb/multilogs"
+)
+
+// IndexNameRootLog is used by plans that scan the whole receive log
+const IndexNameRootLog = "rootLog"
+
+// Plan describes how a query is executed.
+// Messages from the selected index are always checked against all the filters of the query,
+// the index only narrows down how many of them need to be looked at.
+type Plan struct {
+	Index string
+	Addr  librarian.Addr
+
+	// Key is the readable form of Addr
+	Key string
+}
+
+func (p Plan) String() string {
+	if p.Index == IndexNameRootLog {
+		return "scan " + IndexNameRootLog
+	}
+	return fmt.Sprintf("%s[%s]", p.Index, p.Key)
+}
+
+// Plan picks the index to read from. has reports which multilogs are available.
+// An author is more selective than a type, so userFeeds is preferred over msgTypes.
+func (q Query) Plan(has func(name string) bool) Plan {
+	if q.Author != nil && has(multilogs.IndexNameFeeds) {
+		return Plan{Index: multilogs.IndexNameFeeds, Addr: q.Author.StoredAddr(), Key: q.Author.Ref()}
+	}
+	if q.Type != "" && has("msgTypes") {
+		return Plan{Index: "msgTypes", Addr: librarian.Addr(q.Type), Key: q.Type}
+	}
+	return Plan{Index: IndexNameRootLog}
+}
+
+// Explain describes the plan and the filters that are applied to the messages it yields
+func (q Query) Explain(p Plan) map[string]interface{} {
+	var filters []string
+	if q.Author != nil {
+		filters = append(filters, "value.author")
+	}
+	if q.Type != "" {
+		filters = append(filters, "value.content.type")
+	}
+	if !q.Claimed.IsZero() {
+		filters = append(filters, "value.timestamp")
+	}
+	if !q.Received.IsZero() {
+		filters = append(filters, "timestamp")
+	}
+	sort.Strings(filters)
+	return map[string]interface{}{
+		"plan":    p.String(),
+		"filters": filters,
+	}
+}
+
+// execute opens a source for the plan
+func (h handler) execute(q *Query, p Plan) (luigi.Source, error) {
+	var (
+		qryLog       = h.root
+		lower  int64 = -1 // margaret.SeqEmpty
+	)
+
+	if p.Index != IndexNameRootLog {
+		mlog, has := h.mlogs.GetMultiLog(p.Index)
+		if !has {
+			return nil, errors.Errorf("query: planned index %s is not available", p.Index)
+		}
+		sublog, err := mlog.Get(p.Addr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "query: failed to open sublog of %s", p.Index)
+		}
+		if !q.Old {
+			lower, err = currentSeq(sublog)
+			if err != nil {
+				return nil, err
+			}
+		}
+		qryLog = mutil.Indirect(h.root, sublog)
+	} else {
+		if !q.Old {
+			cur, err := currentSeq(h.root)
+			if err != nil {
+				return nil, err
+			}
+			lower = cur
+		} else if q.Received.HasFrom {
+			// the receive log is sorted by receive time, skip everything that is older
+			first, err := firstReceivedAfter(h.root, q.Received.From)
+			if err != nil {
+				return nil, err
+			}
+			lower = first - 1
+		}
+	}
+
+	opts := []margaret.QuerySpec{
+		margaret.Live(q.Live),
+		margaret.Reverse(q.Reverse),
+	}
+	if lower >= 0 {
+		opts = append(opts, margaret.Gt(margaret.BaseSeq(lower)))
+	}
+
+	src, err := qryLog.Query(opts...)
+	return src, errors.Wrap(err, "query: failed to query log")
+}
+
+func currentSeq(l margaret.Log) (int64, error) {
+	sv, err := l.Seq().Value()
+	if err != nil {
+		return 0, errors.Wrap(err, "query: failed to get current sequence")
+	}
+	seq, ok := sv.(margaret.Seq)
+	if !ok {
+		return 0, errors.Errorf("query: unexpected sequence type %T", sv)
+	}
+	return seq.Seq(), nil
+}
+
+// firstReceivedAfter does a binary search over the receive log
+// and returns the first sequence that was received at or after ts (in milliseconds).
+func firstReceivedAfter(rl margaret.Log, ts float64) (int64, error) {
+	cur, err := currentSeq(rl)
+	if err != nil {
+		return 0, err
+	}
+
+	lo, hi := int64(0), cur+1
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+
+		rcvd, found, err := receivedAtOrAfter(rl, mid, hi)
+		if err != nil {
+			return 0, err
+		}
+		if !found {
+			// only nulled messages between mid and hi
+			hi = mid
+			continue
+		}
+		if rcvd < ts {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo, nil
+}
+
+// receivedAtOrAfter returns the receive time of the first message in [seq, end) that wasn't nulled
+func receivedAtOrAfter(rl margaret.Log, seq, end int64) (float64, bool, error) {
+	for ; seq < end; seq++ {
+		v, err := rl.Get(margaret.BaseSeq(seq))
+		if err != nil {
+			return 0, false, errors.Wrapf(err, "query: failed to get message %d", seq)
+		}
+		msg, ok := v.(ssb.Message)
+		if !ok {
+			if verr, ok := v.(error); ok && !margaret.IsErrNulled(verr) {
+				return 0, false, verr
+			}
+			continue
+		}
+		return float64(msg.Received().UnixNano()) / 1e6, true, nil
+	}
+	return 0, false, nil
+}
+
+// pumpFiltered is like luigi.Pump but only passes on messages that match the query.
+// The limit is applied to the filtered messages.
+func pumpFiltered(ctx context.Context, snk luigi.Sink, src luigi.Source, q *Query) error {
+	limit := q.Limit
+	for limit != 0 {
+		v, err := src.Next(ctx)
+		if err != nil {
+			if luigi.IsEOS(err) {
+				return nil
+			}
+			return err
+		}
+
+		msg, ok := v.(ssb.Message)
+		if !ok {
+			if verr, ok := v.(error); ok && !margaret.IsErrNulled(verr) {
+				return verr
+			}
+			continue
+		}
+		if !q.Match(msg) {
+			continue
+		}
+
+		if err := snk.Pour(ctx, msg); err != nil {
+			return err
+		}
+		if limit > 0 {
+			limit--
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+// SPDX-License-Identifier: MIT
+
+package query
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/muxrpc"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/transform"
+	"go.cryptoscope.co/ssb/plugins2"
+)
+
+// Plugin serves query.read and query.explain
+type Plugin struct {
+	h handler
+}
+
+var (
+	_ plugins2.NeedsRootLog  = (*Plugin)(nil)
+	_ plugins2.NeedsMultiLog = (*Plugin)(nil)
+)
+
+func (plug *Plugin) WantRootLog(rl margaret.Log) error {
+	plug.h.root = rl
+	return nil
+}
+
+func (plug *Plugin) WantMultiLog(mlg ssb.MultiLogGetter) error {
+	plug.h.mlogs = mlg
+	return nil
+}
+
+func (Plugin) Name() string                 { return "query" }
+func (Plugin) Method() muxrpc.Method        { return muxrpc.Method{"query"} }
+func (plug Plugin) Handler() muxrpc.Handler { return plug.h }
+
+type handler struct {
+	root  margaret.Log
+	mlogs ssb.MultiLogGetter
+}
+
+func (h handler) HandleConnect(ctx context.Context, e muxrpc.Endpoint) {}
+
+func (h handler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
+	if len(req.Method) != 2 || (req.Method[1] != "read" && req.Method[1] != "explain") {
+		req.CloseWithError(errors.Errorf("query: unsupported call %s", req.Method))
+		return
+	}
+
+	var args []map[string]interface{}
+	if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+		req.CloseWithError(errors.Wrap(err, "query: bad request"))
+		return
+	}
+	if len(args) > 1 {
+		req.CloseWithError(errors.Errorf("query: expected one argument object"))
+		return
+	}
+	if len(args) == 0 {
+		args = append(args, map[string]interface{}{})
+	}
+
+	qry, err := Parse(args[0])
+	if err != nil {
+		req.CloseWithError(err)
+		return
+	}
+
+	plan := qry.Plan(func(name string) bool {
+		_, has := h.mlogs.GetMultiLog(name)
+		return has
+	})
+
+	if req.Method[1] == "explain" {
+		if err := req.Return(ctx, qry.Explain(plan)); err != nil {
+			req.CloseWithError(errors.Wrap(err, "query: failed to return plan"))
+		}
+		return
+	}
+
+	src, err := h.execute(qry, plan)
+	if err != nil {
+		req.CloseWithError(err)
+		return
+	}
+
+	snk := transform.NewKeyValueWrapper(req.Stream, qry.Keys)
+	err = pumpFiltered(ctx, snk, src, qry)
+	if err != nil {
+		req.CloseWithError(errors.Wrap(err, "query: failed to pump msgs"))
+		return
+	}
+
+	req.Stream.Close()
+}
@@ -0,0 +1,317 @@
+// SPDX-License-Identifier: MIT
+
+// Package query implements the subset of ssb-query (query.read) that clients like patchfoo use in practice.
+//
+// Only $filter stages are supported and inside of them only these fields:
+//
+//	value.author        exact match on a feed reference
+//	value.content.type  exact match on the message type
+//	value.timestamp     claimed time, number or range with $gt, $gte, $lt and $lte
+//	timestamp           receive time, same as above
+//
+// Everything else ($map, $sort, other fields or operators) is rejected with an ErrUnsupported error.
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// ErrUnsupported lists all the parts of a query that can't be executed
+type ErrUnsupported struct {
+	Rejected []string
+}
+
+func (e ErrUnsupported) Error() string {
+	return fmt.Sprintf("query: unsupported operators or fields: %s", strings.Join(e.Rejected, ", "))
+}
+
+// Range is a (half) open interval over timestamps in milliseconds since the epoch.
+// The zero value matches everything.
+type Range struct {
+	From, To       float64
+	HasFrom, HasTo bool
+
+	FromInclusive, ToInclusive bool
+}
+
+// Contains returns true if ts is inside the range
+func (r Range) Contains(ts float64) bool {
+	if r.HasFrom {
+		if ts < r.From || (ts == r.From && !r.FromInclusive) {
+			return false
+		}
+	}
+	if r.HasTo {
+		if ts > r.To || (ts == r.To && !r.ToInclusive) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsZero returns true if the range is unbounded
+func (r Range) IsZero() bool { return !r.HasFrom && !r.HasTo }
+
+// Query is a parsed query.read request
+type Query struct {
+	Author *ssb.FeedRef
+	Type   string
+
+	Claimed  Range // value.timestamp
+	Received Range // timestamp
+
+	Limit   int64
+	Reverse bool
+	Live    bool
+	Old     bool
+	Keys    bool
+}
+
+// Parse turns the arguments of a query.read call into a Query.
+func Parse(args map[string]interface{}) (*Query, error) {
+	var (
+		q = Query{
+			Limit: -1,
+			Old:   true,
+			Keys:  true,
+		}
+		rejected []string
+	)
+
+	for k, v := range args {
+		switch k {
+		case "query":
+			rejected = append(rejected, q.parseStages(v)...)
+
+		case "limit":
+			n, ok := v.(float64)
+			if !ok {
+				return nil, errors.Errorf("query: limit is not a number (%T)", v)
+			}
+			if n > 0 {
+				q.Limit = int64(n)
+			}
+
+		case "reverse", "live", "old", "keys":
+			b, ok := v.(bool)
+			if !ok {
+				return nil, errors.Errorf("query: %s is not a bool (%T)", k, v)
+			}
+			switch k {
+			case "reverse":
+				q.Reverse = b
+			case "live":
+				q.Live = b
+			case "old":
+				q.Old = b
+			case "keys":
+				q.Keys = b
+			}
+		}
+	}
+
+	if len(rejected) > 0 {
+		sort.Strings(rejected)
+		return nil, ErrUnsupported{Rejected: rejected}
+	}
+
+	if !q.Old && !q.Live {
+		return nil, errors.Errorf("query: old:false only makes sense with live:true")
+	}
+
+	return &q, nil
+}
+
+// ParseJSON is Parse for raw JSON arguments
+func ParseJSON(data []byte) (*Query, error) {
+	var args map[string]interface{}
+	if err := json.Unmarshal(data, &args); err != nil {
+		return nil, errors.Wrap(err, "query: failed to decode arguments")
+	}
+	return Parse(args)
+}
+
+func (q *Query) parseStages(v interface{}) []string {
+	var stages []interface{}
+	switch tv := v.(type) {
+	case []interface{}:
+		stages = tv
+	case map[string]interface{}:
+		stages = []interface{}{tv}
+	default:
+		return []string{fmt.Sprintf("query of type %T", v)}
+	}
+
+	var rejected []string
+	for _, s := range stages {
+		stage, ok := s.(map[string]interface{})
+		if !ok {
+			rejected = append(rejected, fmt.Sprintf("stage of type %T", s))
+			continue
+		}
+		for op, body := range stage {
+			if op != "$filter" {
+				rejected = append(rejected, op)
+				continue
+			}
+			rejected = append(rejected, q.parseFilter("", body)...)
+		}
+	}
+	return rejected
+}
+
+func (q *Query) parseFilter(path string, v interface{}) []string {
+	switch path {
+	case "value.author":
+		ref, ok := v.(string)
+		if !ok {
+			return []string{"$filter." + path + describeOps(v)}
+		}
+		author, err := ssb.ParseFeedRef(ref)
+		if err != nil {
+			return []string{"$filter." + path + " (invalid feed reference)"}
+		}
+		if q.Author != nil && !q.Author.Equal(author) {
+			return []string{"$filter." + path + " (conflicting values)"}
+		}
+		q.Author = author
+		return nil
+
+	case "value.content.type":
+		tipe, ok := v.(string)
+		if !ok {
+			return []string{"$filter." + path + describeOps(v)}
+		}
+		if q.Type != "" && q.Type != tipe {
+			return []string{"$filter." + path + " (conflicting values)"}
+		}
+		q.Type = tipe
+		return nil
+
+	case "value.timestamp":
+		return parseRange(path, v, &q.Claimed)
+
+	case "timestamp":
+		return parseRange(path, v, &q.Received)
+	}
+
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		if path == "" {
+			return []string{fmt.Sprintf("$filter of type %T", v)}
+		}
+		return []string{"$filter." + path}
+	}
+
+	var rejected []string
+	for k, sub := range obj {
+		p := k
+		if path != "" {
+			p = path + "." + k
+		}
+		if strings.HasPrefix(k, "$") || !isKnownPrefix(p) {
+			rejected = append(rejected, "$filter."+p)
+			continue
+		}
+		rejected = append(rejected, q.parseFilter(p, sub)...)
+	}
+	return rejected
+}
+
+func isKnownPrefix(p string) bool {
+	switch p {
+	case "value", "value.content", "value.author", "value.content.type", "value.timestamp", "timestamp":
+		return true
+	}
+	return false
+}
+
+func parseRange(path string, v interface{}, r *Range) []string {
+	switch tv := v.(type) {
+	case float64:
+		r.From, r.HasFrom, r.FromInclusive = tv, true, true
+		r.To, r.HasTo, r.ToInclusive = tv, true, true
+		return nil
+
+	case map[string]interface{}:
+		var rejected []string
+		for op, bound := range tv {
+			n, ok := bound.(float64)
+			if !ok {
+				rejected = append(rejected, fmt.Sprintf("$filter.%s.%s (not a number)", path, op))
+				continue
+			}
+			switch op {
+			case "$gt", "$gte":
+				r.From, r.HasFrom, r.FromInclusive = n, true, op == "$gte"
+			case "$lt", "$lte":
+				r.To, r.HasTo, r.ToInclusive = n, true, op == "$lte"
+			default:
+				rejected = append(rejected, fmt.Sprintf("$filter.%s.%s", path, op))
+			}
+		}
+		return rejected
+	}
+	return []string{fmt.Sprintf("$filter.%s (%T)", path, v)}
+}
+
+// describeOps names the operators used on a field that only supports exact matches
+func describeOps(v interface{}) string {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return fmt.Sprintf(" (%T)", v)
+	}
+	var ops []string
+	for k := range obj {
+		ops = append(ops, k)
+	}
+	sort.Strings(ops)
+	return "." + strings.Join(ops, ",")
+}
+
+// fields are the parts of a message a filter looks at
+type fields struct {
+	author   *ssb.FeedRef
+	tipe     string
+	claimed  float64
+	received float64
+}
+
+func (q Query) match(f fields) bool {
+	if q.Author != nil && (f.author == nil || !q.Author.Equal(f.author)) {
+		return false
+	}
+	if q.Type != "" && q.Type != f.tipe {
+		return false
+	}
+	if !q.Claimed.Contains(f.claimed) {
+		return false
+	}
+	return q.Received.Contains(f.received)
+}
+
+// Match returns true if the message passes all the filters of the query
+func (q Query) Match(msg ssb.Message) bool {
+	f := fields{
+		author:   msg.Author(),
+		claimed:  float64(msg.Claimed().UnixNano()) / 1e6,
+		received: float64(msg.Received().UnixNano()) / 1e6,
+	}
+	if q.Type != "" {
+		var typed struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(msg.ContentBytes(), &typed); err != nil {
+			return false
+		}
+		f.tipe = typed.Type
+	}
+	return q.match(f)
+}
@@ -0,0 +1,173 @@
+// SPDX-License-Identifier: MIT
+
+package query
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/multilogs"
+)
+
+const (
+	alice = "@iL6NzQoOLFP18pCpprkbY80DMtiG4JFFtVSVUaoGsOQ=.ed25519"
+	bob   = "@AiBJDta+4boyh2USNGwIagH/wKjeruTcDX2Aj1r/haM=.ed25519"
+)
+
+// these were captured from patchfoo, patchwork and ssb-query based scripts
+var jsQueries = []struct {
+	name string
+	args string
+
+	author   string
+	tipe     string
+	plan     string
+	rejected []string
+}{
+	{
+		name: "patchfoo user posts",
+		args: `{"query":[{"$filter":{"value":{"author":"` + alice + `","content":{"type":"post"}}}}],"reverse":true,"limit":20}`,
+
+		author: alice,
+		tipe:   "post",
+		plan:   multilogs.IndexNameFeeds + "[" + alice + "]",
+	},
+	{
+		name: "patchwork recent votes",
+		args: `{"query":[{"$filter":{"value":{"timestamp":{"$gt":1577836800000},"content":{"type":"vote"}}}}],"live":true,"old":false}`,
+
+		tipe: "vote",
+		plan: "msgTypes[vote]",
+	},
+	{
+		name: "receive time window",
+		args: `{"query":[{"$filter":{"timestamp":{"$gte":1577836800000,"$lt":1580515200000}}}]}`,
+
+		plan: "scan " + IndexNameRootLog,
+	},
+	{
+		name: "single stage object",
+		args: `{"query":{"$filter":{"value":{"author":"` + bob + `"}}}}`,
+
+		author: bob,
+		plan:   multilogs.IndexNameFeeds + "[" + bob + "]",
+	},
+	{
+		name: "about with map",
+		args: `{"query":[{"$filter":{"value":{"content":{"type":"about","about":"` + alice + `"}}}},{"$map":{"name":["value","content","name"]}}]}`,
+
+		rejected: []string{"$filter.value.content.about", "$map"},
+	},
+	{
+		name: "channel with sort",
+		args: `{"query":[{"$filter":{"value":{"content":{"channel":"scuttlebutt"}}}},{"$sort":[["timestamp"]]}],"limit":10}`,
+
+		rejected: []string{"$filter.value.content.channel", "$sort"},
+	},
+	{
+		name: "prefix on type",
+		args: `{"query":[{"$filter":{"value":{"content":{"type":{"$prefix":"git-"}}}}}]}`,
+
+		rejected: []string{"$filter.value.content.type.$prefix"},
+	},
+	{
+		name: "in on timestamp",
+		args: `{"query":[{"$filter":{"value":{"timestamp":{"$in":[1,2]}}}}]}`,
+
+		rejected: []string{"$filter.value.timestamp.$in (not a number)"},
+	},
+}
+
+func TestParseJSQueries(t *testing.T) {
+	has := func(string) bool { return true }
+
+	for _, tc := range jsQueries {
+		q, err := ParseJSON([]byte(tc.args))
+		if tc.rejected != nil {
+			require.Error(t, err, tc.name)
+			unsupported, ok := err.(ErrUnsupported)
+			require.True(t, ok, "%s: wrong error type %T", tc.name, err)
+			assert.Equal(t, tc.rejected, unsupported.Rejected, tc.name)
+			continue
+		}
+		require.NoError(t, err, tc.name)
+
+		if tc.author != "" {
+			require.NotNil(t, q.Author, tc.name)
+			assert.Equal(t, tc.author, q.Author.Ref(), tc.name)
+		} else {
+			assert.Nil(t, q.Author, tc.name)
+		}
+		assert.Equal(t, tc.tipe, q.Type, tc.name)
+		assert.Equal(t, tc.plan, q.Plan(has).String(), tc.name)
+	}
+}
+
+func TestParseOptions(t *testing.T) {
+	r := require.New(t)
+
+	q, err := ParseJSON([]byte(`{}`))
+	r.NoError(err)
+	r.EqualValues(-1, q.Limit)
+	r.True(q.Old)
+	r.True(q.Keys)
+	r.False(q.Live)
+	r.False(q.Reverse)
+
+	q, err = ParseJSON([]byte(`{"limit":5,"reverse":true,"live":true,"old":false,"keys":false}`))
+	r.NoError(err)
+	r.EqualValues(5, q.Limit)
+	r.True(q.Reverse)
+	r.True(q.Live)
+	r.False(q.Old)
+	r.False(q.Keys)
+
+	_, err = ParseJSON([]byte(`{"old":false}`))
+	r.Error(err, "old:false without live")
+
+	_, err = ParseJSON([]byte(`{"limit":"ten"}`))
+	r.Error(err)
+
+	_, err = ParseJSON([]byte(`{"query":[{"$filter":{"value":{"author":"@nope"}}}]}`))
+	r.Error(err)
+}
+
+func TestPlanFallback(t *testing.T) {
+	q, err := ParseJSON([]byte(`{"query":[{"$filter":{"value":{"author":"` + alice + `","content":{"type":"post"}}}}]}`))
+	require.NoError(t, err)
+
+	onlyTypes := func(name string) bool { return name == "msgTypes" }
+	assert.Equal(t, "msgTypes[post]", q.Plan(onlyTypes).String())
+
+	none := func(string) bool { return false }
+	assert.Equal(t, "scan "+IndexNameRootLog, q.Plan(none).String())
+}
+
+func TestMatch(t *testing.T) {
+	a, err := ssb.ParseFeedRef(alice)
+	require.NoError(t, err)
+	b, err := ssb.ParseFeedRef(bob)
+	require.NoError(t, err)
+
+	q, err := ParseJSON([]byte(`{"query":[{"$filter":{"value":{"author":"` + alice + `","content":{"type":"post"},"timestamp":{"$gte":100,"$lt":200}},"timestamp":{"$gt":1000}}}]}`))
+	require.NoError(t, err)
+
+	tcs := []struct {
+		name string
+		f    fields
+		want bool
+	}{
+		{"all good", fields{author: a, tipe: "post", claimed: 100, received: 1001}, true},
+		{"other author", fields{author: b, tipe: "post", claimed: 100, received: 1001}, false},
+		{"other type", fields{author: a, tipe: "vote", claimed: 150, received: 1001}, false},
+		{"claimed too late", fields{author: a, tipe: "post", claimed: 200, received: 1001}, false},
+		{"claimed too early", fields{author: a, tipe: "post", claimed: 99, received: 1001}, false},
+		{"received too early", fields{author: a, tipe: "post", claimed: 150, received: 1000}, false},
+	}
+	for _, tc := range tcs {
+		assert.Equal(t, tc.want, q.match(tc.f), tc.name)
+	}
+}
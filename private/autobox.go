@@ -0,0 +1,125 @@
+// SPDX-License-Identifier: MIT
+
+package private
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/margaret"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/private/box2"
+	"go.cryptoscope.co/ssb/private/keys"
+)
+
+// ErrMixedRecipients is returned for recps with groups when box2 can't be used, box1 only knows feeds
+var ErrMixedRecipients = errors.New("private: recps mixes groups and feeds but box2 is not available")
+
+// contentBoxer is message.ContentBoxer, box2.Content for example
+type contentBoxer interface {
+	BoxContent(author *ssb.FeedRef, prev *ssb.MessageRef) ([]byte, error)
+}
+
+type autoBoxer struct {
+	ssb.Publisher
+
+	keys    *keys.Manager
+	useBox2 bool
+}
+
+// NewAutoBoxPublisher encrypts content that has a recps array to those recipients before it is published, like the JS sbot does.
+// Content without recps and content that is encrypted already, []byte or a message.ContentBoxer, is passed on as it is.
+//
+// Messages to feeds only are box1, unless useBox2 is set. Group ids need box2, the group has to be the first recipient
+// and the feeds after it get their direct message keys. Without km there is no box2.
+func NewAutoBoxPublisher(pub ssb.Publisher, km *keys.Manager, useBox2 bool) ssb.Publisher {
+	return autoBoxer{
+		Publisher: pub,
+		keys:      km,
+		useBox2:   useBox2 && km != nil,
+	}
+}
+
+func (ab autoBoxer) Append(val interface{}) (margaret.Seq, error) {
+	boxed, err := ab.box(val)
+	if err != nil {
+		return nil, err
+	}
+	return ab.Publisher.Append(boxed)
+}
+
+func (ab autoBoxer) Publish(content interface{}) (*ssb.MessageRef, error) {
+	boxed, err := ab.box(content)
+	if err != nil {
+		return nil, err
+	}
+	return ab.Publisher.Publish(boxed)
+}
+
+// box returns the ciphertext of val if it has recps, and val if it doesn't
+func (ab autoBoxer) box(val interface{}) (interface{}, error) {
+	switch val.(type) {
+	case []byte, contentBoxer:
+		return val, nil
+	}
+
+	plain, err := json.Marshal(val)
+	if err != nil {
+		return nil, errors.Wrap(err, "private/autobox: failed to encode content")
+	}
+	var withRecps struct {
+		Recps json.RawMessage `json:"recps"`
+	}
+	if err := json.Unmarshal(plain, &withRecps); err != nil {
+		// not an object
+		return val, nil
+	}
+	if len(withRecps.Recps) == 0 || string(withRecps.Recps) == "null" {
+		return val, nil
+	}
+
+	var recps []string
+	if err := json.Unmarshal(withRecps.Recps, &recps); err != nil {
+		return nil, errors.Wrap(err, "private/autobox: recps needs to be a list of feeds and group ids")
+	}
+	if len(recps) == 0 {
+		return nil, errors.New("private/autobox: recps is empty")
+	}
+
+	var (
+		feeds  []*ssb.FeedRef
+		groups int
+	)
+	for i, recp := range recps {
+		if ref, err := ssb.ParseFeedRef(recp); err == nil {
+			feeds = append(feeds, ref)
+			continue
+		}
+		if !strings.HasPrefix(recp, "%") || !strings.HasSuffix(recp, ".cloaked") {
+			return nil, errors.Errorf("private/autobox: recipient %d is neither a feed nor a group id: %q", i, recp)
+		}
+		if i != 0 {
+			return nil, errors.Errorf("private/autobox: group %s needs to be the first recipient", recp)
+		}
+		groups++
+	}
+
+	if groups == 0 && !ab.useBox2 {
+		boxed, err := Box(plain, feeds...)
+		if err != nil {
+			return nil, errors.Wrap(err, "private/autobox: failed to box1 content")
+		}
+		return boxed, nil
+	}
+
+	if ab.keys == nil {
+		return nil, ErrMixedRecipients
+	}
+	rs, err := ab.keys.Recipients(recps)
+	if err != nil {
+		return nil, errors.Wrap(err, "private/autobox")
+	}
+	return box2.Content{Plain: plain, Recipients: rs}, nil
+}
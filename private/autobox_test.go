@@ -0,0 +1,112 @@
+// SPDX-License-Identifier: MIT
+
+package private_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/margaret"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/private"
+	"go.cryptoscope.co/ssb/private/keys"
+	"go.cryptoscope.co/ssb/sbot"
+)
+
+func TestAutoBox(t *testing.T) {
+	r := require.New(t)
+
+	srvRepo := filepath.Join("testrun", t.Name())
+	os.RemoveAll(srvRepo)
+
+	alice, err := ssb.NewKeyPair(bytes.NewReader(bytes.Repeat([]byte("alice"), 8)))
+	r.NoError(err)
+	bob, err := ssb.NewKeyPair(bytes.NewReader(bytes.Repeat([]byte("bobby"), 8)))
+	r.NoError(err)
+
+	km := keys.NewManager(alice)
+	r.NoError(km.AddGroupKey("%group.cloaked", bytes.Repeat([]byte{1}, 32)))
+
+	start := func(opts ...sbot.Option) *sbot.Sbot {
+		srv, err := sbot.New(append([]sbot.Option{
+			sbot.WithKeyPair(alice),
+			sbot.WithInfo(kitlog.NewNopLogger()),
+			sbot.WithRepoPath(srvRepo),
+			sbot.DisableNetworkNode(),
+			sbot.WithKeyManager(km),
+		}, opts...)...)
+		r.NoError(err, "sbot srv init failed")
+		return srv
+	}
+
+	last := func(srv *sbot.Sbot) ssb.Message {
+		sv, err := srv.RootLog.Seq().Value()
+		r.NoError(err)
+		v, err := srv.RootLog.Get(sv.(margaret.Seq))
+		r.NoError(err)
+		msg, ok := v.(ssb.Message)
+		r.True(ok, "wrong type: %T", v)
+		return msg
+	}
+
+	srv := start()
+
+	// without recps nothing changes
+	_, err = srv.PublishLog.Publish(map[string]interface{}{"type": "test", "text": "clear"})
+	r.NoError(err)
+	r.Contains(string(last(srv).ContentBytes()), `"clear"`)
+
+	for i, tc := range []struct {
+		recps  []string
+		suffix string
+	}{
+		{[]string{alice.Id.Ref(), bob.Id.Ref()}, `.box"`},
+		{[]string{"%group.cloaked", bob.Id.Ref()}, `.box2"`},
+	} {
+		_, err = srv.PublishLog.Publish(map[string]interface{}{"type": "test", "text": "secret", "recps": tc.recps})
+		r.NoError(err, "publish %d failed", i)
+
+		msg := last(srv)
+		content := string(msg.ContentBytes())
+		r.True(strings.HasSuffix(content, tc.suffix), "%d: not encrypted: %s", i, content)
+		plain, err := private.Open(km, msg)
+		r.NoError(err, "%d: failed to open", i)
+		r.Contains(string(plain), `"secret"`)
+	}
+
+	for i, recps := range []interface{}{
+		[]string{},
+		"not a list",
+		[]string{"@nope"},
+		[]string{bob.Id.Ref(), "%group.cloaked"},
+		[]string{"%unknown.cloaked"},
+	} {
+		_, err = srv.PublishLog.Publish(map[string]interface{}{"type": "test", "recps": recps})
+		r.Error(err, "publish %d should fail", i)
+	}
+
+	srv.Shutdown()
+	r.NoError(srv.Close())
+
+	// box2 for feeds, too
+	srv = start(sbot.WithAutoBox2(true))
+	_, err = srv.PublishLog.Publish(map[string]interface{}{"type": "test", "recps": []string{bob.Id.Ref()}})
+	r.NoError(err)
+	r.True(strings.HasSuffix(string(last(srv).ContentBytes()), `.box2"`))
+	srv.Shutdown()
+	r.NoError(srv.Close())
+
+	// and off
+	srv = start(sbot.EnableAutoBox(false))
+	_, err = srv.PublishLog.Publish(map[string]interface{}{"type": "test", "recps": []string{bob.Id.Ref()}})
+	r.NoError(err)
+	r.Contains(string(last(srv).ContentBytes()), bob.Id.Ref())
+	srv.Shutdown()
+	r.NoError(srv.Close())
+}
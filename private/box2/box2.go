@@ -0,0 +1,295 @@
+// SPDX-License-Identifier: MIT
+
+// Package box2 implements the envelope encryption of private messages, see https://github.com/ssbc/envelope-spec
+//
+// A box2 message starts with a header box that tells where the body starts, followed by one key slot per recipient and the body box.
+// Every slot holds the key of the message, xored with a secret derived from the key of one recipient.
+// Readers try the keys they have on each slot until the header box opens.
+package box2
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/nacl/secretbox"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// the schemes of recipient keys
+const (
+	SchemeDirectMessage       = "envelope-id-based-dm-converted-ed25519"
+	SchemeLargeSymmetricGroup = "envelope-large-symmetric-group"
+)
+
+// MaxRecipients is the most key slots a message can have, and how many of them are tried when reading
+const MaxRecipients = 16
+
+// Prefix marks box2 ciphertext in the content of a message, before it is base64 encoded with the .box2 suffix for legacy feeds
+const Prefix = "box2:"
+
+const (
+	keySize       = 32
+	headerSize    = 16
+	headerBoxSize = headerSize + secretbox.Overhead
+	slotSize      = keySize
+)
+
+// the labels of the derived secrets
+const (
+	labelReadKey   = "read_key"
+	labelHeaderKey = "header_key"
+	labelBodyKey   = "body_key"
+	labelSlotKey   = "slot_key"
+)
+
+// ErrDecryptFailed means none of the candidate keys opened the message
+var ErrDecryptFailed = errors.New("box2: decryption failed")
+
+// Recipient is a key a message is encrypted to, like the direct message key shared with a feed or the key of a group
+type Recipient struct {
+	Key    []byte
+	Scheme string
+}
+
+// the nonce of all boxes, each of their keys is only used once
+var zeroNonce [24]byte
+
+// Encrypt boxes plain for recps. author and prev are the feed and the previous message of the message that will hold the ciphertext,
+// prev is nil for the first message of a feed.
+func Encrypt(plain []byte, author *ssb.FeedRef, prev *ssb.MessageRef, recps []Recipient) ([]byte, error) {
+	ct, _, err := EncryptReadKey(plain, author, prev, recps)
+	return ct, err
+}
+
+// EncryptReadKey is Encrypt that also returns the read key of the new message, see DecryptReadKey
+func EncryptReadKey(plain []byte, author *ssb.FeedRef, prev *ssb.MessageRef, recps []Recipient) ([]byte, []byte, error) {
+	var msgKey [keySize]byte
+	if _, err := io.ReadFull(rand.Reader, msgKey[:]); err != nil {
+		return nil, nil, errors.Wrap(err, "box2: failed to make message key")
+	}
+	return encrypt(plain, author, prev, msgKey, recps)
+}
+
+func encrypt(plain []byte, author *ssb.FeedRef, prev *ssb.MessageRef, msgKey [keySize]byte, recps []Recipient) ([]byte, []byte, error) {
+	if n := len(recps); n == 0 || n > MaxRecipients {
+		return nil, nil, errors.Errorf("box2: wrong number of recipients: %d", n)
+	}
+	d, err := newDeriver(author, prev)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	readKey := d.derive(msgKey[:], labelReadKey)
+	headerKey := d.derive(readKey[:], labelHeaderKey)
+	bodyKey := d.derive(readKey[:], labelBodyKey)
+
+	// no header extensions and flags yet, only the offset of the body
+	var header [headerSize]byte
+	binary.LittleEndian.PutUint16(header[:2], uint16(headerBoxSize+slotSize*len(recps)))
+
+	var out bytes.Buffer
+	out.Write(secretbox.Seal(nil, header[:], &zeroNonce, &headerKey))
+	for i, r := range recps {
+		if len(r.Key) != keySize {
+			return nil, nil, errors.Errorf("box2: key of recipient %d has %d bytes", i, len(r.Key))
+		}
+		slotKey := d.derive(r.Key, labelSlotKey, r.Scheme)
+		var slot [slotSize]byte
+		for j := range slot {
+			slot[j] = msgKey[j] ^ slotKey[j]
+		}
+		out.Write(slot[:])
+	}
+	out.Write(secretbox.Seal(nil, plain, &zeroNonce, &bodyKey))
+	return out.Bytes(), readKey[:], nil
+}
+
+// Decrypt opens ciphertext with the first of the candidate keys that was used for one of its slots
+func Decrypt(ciphertext []byte, author *ssb.FeedRef, prev *ssb.MessageRef, candidates []Recipient) ([]byte, error) {
+	plain, _, err := DecryptReadKey(ciphertext, author, prev, candidates)
+	return plain, err
+}
+
+// DecryptReadKey is Decrypt that also returns the read key of the message, which opens it without any of the recipient keys
+func DecryptReadKey(ciphertext []byte, author *ssb.FeedRef, prev *ssb.MessageRef, candidates []Recipient) ([]byte, []byte, error) {
+	if len(ciphertext) < headerBoxSize+slotSize+secretbox.Overhead {
+		return nil, nil, errors.Errorf("box2: message is too short")
+	}
+	d, err := newDeriver(author, prev)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	headerBox := ciphertext[:headerBoxSize]
+	slots := ciphertext[headerBoxSize:]
+
+	var slotKeys [][keySize]byte
+	for _, c := range candidates {
+		if len(c.Key) != keySize {
+			continue
+		}
+		slotKeys = append(slotKeys, d.derive(c.Key, labelSlotKey, c.Scheme))
+	}
+
+	for i := 0; i < MaxRecipients && len(slots) >= (i+1)*slotSize; i++ {
+		slot := slots[i*slotSize : (i+1)*slotSize]
+		for _, slotKey := range slotKeys {
+			var msgKey [keySize]byte
+			for j := range msgKey {
+				msgKey[j] = slot[j] ^ slotKey[j]
+			}
+			readKey := d.derive(msgKey[:], labelReadKey)
+			headerKey := d.derive(readKey[:], labelHeaderKey)
+			header, ok := secretbox.Open(nil, headerBox, &zeroNonce, &headerKey)
+			if !ok {
+				continue
+			}
+
+			offset := int(binary.LittleEndian.Uint16(header[:2]))
+			if offset < headerBoxSize+slotSize || offset > len(ciphertext) {
+				return nil, nil, errors.Errorf("box2: invalid body offset %d", offset)
+			}
+			bodyKey := d.derive(readKey[:], labelBodyKey)
+			plain, ok := secretbox.Open(nil, ciphertext[offset:], &zeroNonce, &bodyKey)
+			if !ok {
+				return nil, nil, errors.New("box2: body doesn't match the header")
+			}
+			return plain, readKey[:], nil
+		}
+	}
+	return nil, nil, ErrDecryptFailed
+}
+
+// CloakedMessageID hides the id of a message behind one derived from its read key, only those who can read the message know which one it is.
+// Groups are known by the cloaked id of their init message.
+func CloakedMessageID(msg *ssb.MessageRef, readKey []byte) (string, error) {
+	if len(readKey) != keySize {
+		return "", errors.Errorf("box2: read key has %d bytes", len(readKey))
+	}
+	msgTFK, err := MessageTFK(msg)
+	if err != nil {
+		return "", err
+	}
+	var id [keySize]byte
+	r := hkdf.Expand(sha256.New, readKey, SLPEncode([]byte("cloaked_msg_id"), msgTFK))
+	if _, err := io.ReadFull(r, id[:]); err != nil {
+		return "", errors.Wrap(err, "box2: failed to derive cloaked id")
+	}
+	return "%" + base64.StdEncoding.EncodeToString(id[:]) + ".cloaked", nil
+}
+
+// deriver derives the secrets of one message, they are bound to its author and previous message
+type deriver struct {
+	feed, prev []byte
+}
+
+func newDeriver(author *ssb.FeedRef, prev *ssb.MessageRef) (deriver, error) {
+	feed, err := FeedTFK(author)
+	if err != nil {
+		return deriver{}, err
+	}
+	prevTFK, err := MessageTFK(prev)
+	if err != nil {
+		return deriver{}, err
+	}
+	return deriver{feed: feed, prev: prevTFK}, nil
+}
+
+// derive is DeriveSecret of the spec, HKDF-Expand of key with ["envelope", feed, prev, labels...] as the info
+func (d deriver) derive(key []byte, labels ...string) [keySize]byte {
+	info := [][]byte{[]byte("envelope"), d.feed, d.prev}
+	for _, l := range labels {
+		info = append(info, []byte(l))
+	}
+	var out [keySize]byte
+	r := hkdf.Expand(sha256.New, key, SLPEncode(info...))
+	if _, err := io.ReadFull(r, out[:]); err != nil {
+		panic(err) // only fails if more than 255 hashes worth are read
+	}
+	return out
+}
+
+// SLPEncode is the shallow length-prefixed encoding of the spec, each part with its length as two little endian bytes in front
+func SLPEncode(parts ...[]byte) []byte {
+	var buf bytes.Buffer
+	for _, p := range parts {
+		var l [2]byte
+		binary.LittleEndian.PutUint16(l[:], uint16(len(p)))
+		buf.Write(l[:])
+		buf.Write(p)
+	}
+	return buf.Bytes()
+}
+
+// the types and formats of the type-format-key encoding
+const (
+	tfkTypeFeed    = 0
+	tfkTypeMessage = 1
+	tfkTypeDHKey   = 3
+
+	tfkFormatClassic = 0
+	tfkFormatGabby   = 1
+)
+
+// FeedTFK is the type-format-key encoding of ref
+func FeedTFK(ref *ssb.FeedRef) ([]byte, error) {
+	if ref == nil {
+		return nil, errors.New("box2: no feed")
+	}
+	var format byte
+	switch ref.Algo {
+	case ssb.RefAlgoFeedSSB1:
+		format = tfkFormatClassic
+	case ssb.RefAlgoFeedGabby:
+		format = tfkFormatGabby
+	default:
+		return nil, errors.Errorf("box2: unsupported feed format %s", ref.Algo)
+	}
+	return append([]byte{tfkTypeFeed, format}, ref.ID...), nil
+}
+
+// MessageTFK is the type-format-key encoding of ref.
+// A nil ref, before the first message of a feed, is a classic message with a key of all zeros, like the spec has it.
+func MessageTFK(ref *ssb.MessageRef) ([]byte, error) {
+	if ref == nil {
+		return append([]byte{tfkTypeMessage, tfkFormatClassic}, make([]byte, 32)...), nil
+	}
+	var format byte
+	switch ref.Algo {
+	case ssb.RefAlgoMessageSSB1:
+		format = tfkFormatClassic
+	case ssb.RefAlgoMessageGabby:
+		format = tfkFormatGabby
+	default:
+		return nil, errors.Errorf("box2: unsupported message format %s", ref.Algo)
+	}
+	return append([]byte{tfkTypeMessage, format}, ref.Hash...), nil
+}
+
+// DHKeyTFK is the type-format-key encoding of a curve25519 public key
+func DHKeyTFK(pub []byte) []byte {
+	return append([]byte{tfkTypeDHKey, tfkFormatClassic}, pub...)
+}
+
+// Content is private content that is encrypted when it is published, once its author and previous message are known.
+// It implements message.ContentBoxer.
+type Content struct {
+	Plain      []byte
+	Recipients []Recipient
+}
+
+// BoxContent returns the ciphertext with Prefix in front
+func (c Content) BoxContent(author *ssb.FeedRef, prev *ssb.MessageRef) ([]byte, error) {
+	ct, err := Encrypt(c.Plain, author, prev, c.Recipients)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(Prefix), ct...), nil
+}
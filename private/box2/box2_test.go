@@ -0,0 +1,136 @@
+// SPDX-License-Identifier: MIT
+
+package box2
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/ssb"
+)
+
+func TestBox2(t *testing.T) {
+	r := require.New(t)
+
+	author := &ssb.FeedRef{ID: bytes.Repeat([]byte{1}, 32), Algo: ssb.RefAlgoFeedSSB1}
+	prev := &ssb.MessageRef{Hash: bytes.Repeat([]byte{2}, 32), Algo: ssb.RefAlgoMessageSSB1}
+
+	var recps []Recipient
+	for i := byte(0); i < 4; i++ {
+		recps = append(recps, Recipient{Key: bytes.Repeat([]byte{10 + i}, 32), Scheme: SchemeLargeSymmetricGroup})
+	}
+	plain := []byte(`{"type":"post","text":"hello"}`)
+
+	ct, err := Encrypt(plain, author, prev, recps)
+	r.NoError(err)
+	r.Len(ct, headerBoxSize+4*slotSize+len(plain)+16)
+
+	// every recipient can read it, whatever slot is theirs
+	for i, rcp := range recps {
+		out, err := Decrypt(ct, author, prev, []Recipient{{Key: bytes.Repeat([]byte{99}, 32), Scheme: SchemeLargeSymmetricGroup}, rcp})
+		r.NoError(err, "recipient %d", i)
+		r.Equal(plain, out)
+	}
+
+	// others can't
+	_, err = Decrypt(ct, author, prev, []Recipient{{Key: bytes.Repeat([]byte{99}, 32), Scheme: SchemeLargeSymmetricGroup}})
+	r.Equal(ErrDecryptFailed, err)
+
+	// the scheme is part of the slot key
+	_, err = Decrypt(ct, author, prev, []Recipient{{Key: recps[0].Key, Scheme: SchemeDirectMessage}})
+	r.Equal(ErrDecryptFailed, err)
+
+	// the ciphertext is bound to its place in the feed
+	otherPrev := &ssb.MessageRef{Hash: bytes.Repeat([]byte{3}, 32), Algo: ssb.RefAlgoMessageSSB1}
+	_, err = Decrypt(ct, author, otherPrev, recps)
+	r.Equal(ErrDecryptFailed, err)
+
+	// and can't be changed
+	ct[len(ct)-1] ^= 1
+	_, err = Decrypt(ct, author, prev, recps[:1])
+	r.Error(err)
+}
+
+func TestBox2FirstMessage(t *testing.T) {
+	r := require.New(t)
+
+	author := &ssb.FeedRef{ID: bytes.Repeat([]byte{1}, 32), Algo: ssb.RefAlgoFeedGabby}
+	rcp := Recipient{Key: bytes.Repeat([]byte{7}, 32), Scheme: SchemeDirectMessage}
+
+	ct, err := Encrypt(nil, author, nil, []Recipient{rcp})
+	r.NoError(err)
+	out, err := Decrypt(ct, author, nil, []Recipient{rcp})
+	r.NoError(err)
+	r.Len(out, 0)
+}
+
+func TestBox2Recipients(t *testing.T) {
+	r := require.New(t)
+
+	author := &ssb.FeedRef{ID: bytes.Repeat([]byte{1}, 32), Algo: ssb.RefAlgoFeedSSB1}
+	rcp := Recipient{Key: bytes.Repeat([]byte{7}, 32), Scheme: SchemeDirectMessage}
+
+	_, err := Encrypt([]byte("x"), author, nil, nil)
+	r.Error(err)
+
+	tooMany := make([]Recipient, MaxRecipients+1)
+	for i := range tooMany {
+		tooMany[i] = rcp
+	}
+	_, err = Encrypt([]byte("x"), author, nil, tooMany)
+	r.Error(err)
+
+	_, err = Encrypt([]byte("x"), author, nil, []Recipient{{Key: []byte("short"), Scheme: SchemeDirectMessage}})
+	r.Error(err)
+}
+
+func TestSLPEncode(t *testing.T) {
+	r := require.New(t)
+
+	r.Equal([]byte{3, 0, 'a', 'b', 'c', 0, 0, 1, 0, 'x'}, SLPEncode([]byte("abc"), nil, []byte("x")))
+}
+
+func TestTFK(t *testing.T) {
+	r := require.New(t)
+
+	feed, err := FeedTFK(&ssb.FeedRef{ID: bytes.Repeat([]byte{1}, 32), Algo: ssb.RefAlgoFeedSSB1})
+	r.NoError(err)
+	r.Equal(append([]byte{0, 0}, bytes.Repeat([]byte{1}, 32)...), feed)
+
+	msg, err := MessageTFK(&ssb.MessageRef{Hash: bytes.Repeat([]byte{2}, 32), Algo: ssb.RefAlgoMessageGabby})
+	r.NoError(err)
+	r.Equal(append([]byte{1, 1}, bytes.Repeat([]byte{2}, 32)...), msg)
+
+	none, err := MessageTFK(nil)
+	r.NoError(err)
+	r.Equal(append([]byte{1, 0}, make([]byte, 32)...), none)
+}
+
+func TestBox2ReadKey(t *testing.T) {
+	r := require.New(t)
+
+	author := &ssb.FeedRef{ID: bytes.Repeat([]byte{1}, 32), Algo: ssb.RefAlgoFeedSSB1}
+	rcp := Recipient{Key: bytes.Repeat([]byte{7}, 32), Scheme: SchemeLargeSymmetricGroup}
+
+	ct, readKey, err := EncryptReadKey([]byte("init"), author, nil, []Recipient{rcp})
+	r.NoError(err)
+	out, readKey2, err := DecryptReadKey(ct, author, nil, []Recipient{rcp})
+	r.NoError(err)
+	r.Equal("init", string(out))
+	r.Equal(readKey, readKey2)
+
+	msg := &ssb.MessageRef{Hash: bytes.Repeat([]byte{2}, 32), Algo: ssb.RefAlgoMessageSSB1}
+	id, err := CloakedMessageID(msg, readKey)
+	r.NoError(err)
+	r.True(strings.HasPrefix(id, "%") && strings.HasSuffix(id, ".cloaked"), id)
+
+	id2, err := CloakedMessageID(msg, bytes.Repeat([]byte{3}, 32))
+	r.NoError(err)
+	r.NotEqual(id, id2)
+
+	_, err = CloakedMessageID(msg, []byte("short"))
+	r.Error(err)
+}
@@ -0,0 +1,179 @@
+// SPDX-License-Identifier: MIT
+
+package box2
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// the vectors of https://github.com/ssbc/envelope-spec, vendored into testdata by private/vendor-spec-vectors.sh.
+// Another checkout of it can be pointed to with ENVELOPE_SPEC.
+func specVectors(t *testing.T) []string {
+	dir := filepath.Join("testdata", "envelope-spec")
+	if env := os.Getenv("ENVELOPE_SPEC"); env != "" {
+		dir = env
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "vectors", "*.json"))
+	require.NoError(t, err)
+	if len(files) == 0 {
+		t.Fatalf("no envelope-spec vectors in %s, run private/vendor-spec-vectors.sh or set ENVELOPE_SPEC", dir)
+	}
+	return files
+}
+
+type specVector struct {
+	Type        string          `json:"type"`
+	Description string          `json:"description"`
+	Input       json.RawMessage `json:"input"`
+	Output      json.RawMessage `json:"output"`
+	ErrorCode   string          `json:"error_code"`
+}
+
+type specKey struct {
+	Key    string `json:"key"`
+	Scheme string `json:"scheme"`
+}
+
+func TestSpecVectors(t *testing.T) {
+	for _, file := range specVectors(t) {
+		data, err := ioutil.ReadFile(file)
+		require.NoError(t, err)
+		var v specVector
+		require.NoError(t, json.Unmarshal(data, &v), file)
+
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			r := require.New(t)
+			switch v.Type {
+			case "box":
+				var in struct {
+					PlainText string    `json:"plain_text"`
+					FeedID    string    `json:"feed_id"`
+					PrevMsgID *string   `json:"prev_msg_id"`
+					MsgKey    string    `json:"msg_key"`
+					RecpKeys  []specKey `json:"recp_keys"`
+				}
+				var out struct {
+					Ciphertext string `json:"ciphertext"`
+				}
+				r.NoError(json.Unmarshal(v.Input, &in))
+				r.NoError(json.Unmarshal(v.Output, &out))
+
+				var msgKey [keySize]byte
+				copy(msgKey[:], specBytes(t, in.MsgKey))
+				ct, _, err := encrypt(specBytes(t, in.PlainText), specFeed(t, in.FeedID), specPrev(t, in.PrevMsgID), msgKey, specRecipients(t, in.RecpKeys))
+				r.NoError(err, v.Description)
+				r.Equal(specBytes(t, out.Ciphertext), ct, v.Description)
+
+			case "unbox":
+				var in struct {
+					Ciphertext string    `json:"ciphertext"`
+					FeedID     string    `json:"feed_id"`
+					PrevMsgID  *string   `json:"prev_msg_id"`
+					TrialKeys  []specKey `json:"trial_keys"`
+				}
+				var out struct {
+					PlainText string `json:"plain_text"`
+				}
+				r.NoError(json.Unmarshal(v.Input, &in))
+				plain, err := Decrypt(specBytes(t, in.Ciphertext), specFeed(t, in.FeedID), specPrev(t, in.PrevMsgID), specRecipients(t, in.TrialKeys))
+				if v.ErrorCode != "" {
+					r.Error(err, v.Description)
+					return
+				}
+				r.NoError(err, v.Description)
+				r.NoError(json.Unmarshal(v.Output, &out))
+				r.Equal(specBytes(t, out.PlainText), plain, v.Description)
+
+			case "derive_secret":
+				var in struct {
+					FeedID    string   `json:"feed_id"`
+					PrevMsgID *string  `json:"prev_msg_id"`
+					Key       string   `json:"key"`
+					Labels    []string `json:"labels"`
+				}
+				var out struct {
+					DerivedKey string `json:"derived_key"`
+				}
+				r.NoError(json.Unmarshal(v.Input, &in))
+				r.NoError(json.Unmarshal(v.Output, &out))
+				d, err := newDeriver(specFeed(t, in.FeedID), specPrev(t, in.PrevMsgID))
+				r.NoError(err)
+				secret := d.derive(specBytes(t, in.Key), in.Labels...)
+				r.Equal(specBytes(t, out.DerivedKey), secret[:], v.Description)
+
+			case "slp_encode":
+				var in []string
+				var out string
+				r.NoError(json.Unmarshal(v.Input, &in))
+				r.NoError(json.Unmarshal(v.Output, &out))
+				parts := make([][]byte, len(in))
+				for i, p := range in {
+					parts[i] = specBytes(t, p)
+				}
+				r.Equal(specBytes(t, out), SLPEncode(parts...), v.Description)
+
+			case "cloaked_msg_id":
+				var in struct {
+					PublicMsgID string `json:"public_msg_id"`
+					ReadKey     string `json:"read_key"`
+				}
+				var out struct {
+					CloakedMsgID string `json:"cloaked_msg_id"`
+				}
+				r.NoError(json.Unmarshal(v.Input, &in))
+				r.NoError(json.Unmarshal(v.Output, &out))
+				msg, err := ssb.ParseMessageRef(in.PublicMsgID)
+				r.NoError(err)
+				id, err := CloakedMessageID(msg, specBytes(t, in.ReadKey))
+				r.NoError(err)
+				r.Equal(out.CloakedMsgID, id, v.Description)
+
+			default:
+				t.Skipf("vector type %q is not covered here", v.Type)
+			}
+		})
+	}
+}
+
+// specBytes decodes the base64 of the vectors, some of them have an encoding suffix
+func specBytes(t *testing.T, s string) []byte {
+	if i := strings.LastIndex(s, "."); i > 0 {
+		s = s[:i]
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	require.NoError(t, err, "invalid base64 in vector: %q", s)
+	return b
+}
+
+func specFeed(t *testing.T, id string) *ssb.FeedRef {
+	ref, err := ssb.ParseFeedRef(id)
+	require.NoError(t, err)
+	return ref
+}
+
+func specPrev(t *testing.T, id *string) *ssb.MessageRef {
+	if id == nil {
+		return nil
+	}
+	ref, err := ssb.ParseMessageRef(*id)
+	require.NoError(t, err)
+	return ref
+}
+
+func specRecipients(t *testing.T, keys []specKey) []Recipient {
+	recps := make([]Recipient, len(keys))
+	for i, k := range keys {
+		recps[i] = Recipient{Key: specBytes(t, k.Key), Scheme: k.Scheme}
+	}
+	return recps
+}
@@ -79,6 +79,13 @@ const (
 )
 
 func Unbox(recpt *ssb.KeyPair, rawMsg []byte) ([]byte, error) {
+	var cvSec [32]byte
+	extra25519.PrivateKeyToCurve25519(&cvSec, recpt.Pair.Secret)
+	return unbox(&cvSec, rawMsg)
+}
+
+// unbox opens rawMsg with the curve25519 secret of a recipient
+func unbox(cvSec *[32]byte, rawMsg []byte) ([]byte, error) {
 	if len(rawMsg) < 122 {
 		return nil, errors.Errorf("decode pm: sorry message seems short?")
 	}
@@ -92,9 +99,8 @@ func Unbox(recpt *ssb.KeyPair, rawMsg []byte) ([]byte, error) {
 	copy(hdrPub[:], rawMsg[24:start])
 
 	// construct the key that should/can open the header sbox _for us_
-	var messageShared, cvSec [32]byte
-	extra25519.PrivateKeyToCurve25519(&cvSec, recpt.Pair.Secret)
-	curve25519.ScalarMult(&messageShared, &cvSec, &hdrPub)
+	var messageShared [32]byte
+	curve25519.ScalarMult(&messageShared, cvSec, &hdrPub)
 
 	var (
 		cnt  int              // number of recipients
@@ -102,20 +108,20 @@ func Unbox(recpt *ssb.KeyPair, rawMsg []byte) ([]byte, error) {
 		curr = rawMsg[start:] // pointer into the message for finding recpt' sbox
 	)
 
+	// all the slots are tried, so that the time doesn't tell which one is ours
 	for i := 0; i < maxRecps; i++ {
 		if len(curr) < rcptSboxSize { // prevent seeking off the msgs end
 			break
 		}
 
 		decrypted, ok := secretbox.Open(nil, curr[:rcptSboxSize], &nonce, &messageShared)
-		if !ok {
-			curr = curr[rcptSboxSize:]
+		curr = curr[rcptSboxSize:]
+		if !ok || cnt != 0 {
 			continue
 		}
 
 		cnt = int(decrypted[0])
 		copy(skey[:], decrypted[1:])
-		break
 	}
 
 	content, ok := secretbox.Open(nil, rawMsg[start+cnt*rcptSboxSize:], &nonce, &skey)
@@ -127,3 +133,53 @@ func Unbox(recpt *ssb.KeyPair, rawMsg []byte) ([]byte, error) {
 }
 
 var ErrPrivateMessageDecryptFailed = errors.New("decode pm: decryption failed")
+
+// Unboxer opens box1 messages with several keypairs, like all the identities of a repo.
+// The curve25519 secrets of the keypairs are converted once and not for every message.
+// Every keypair costs one scalar multiplication and a try of each key slot, whether it can read the message or not.
+type Unboxer struct {
+	kps     []*ssb.KeyPair
+	secrets [][32]byte
+}
+
+// NewUnboxer returns an unboxer for kps, they are tried in that order
+func NewUnboxer(kps ...*ssb.KeyPair) *Unboxer {
+	u := &Unboxer{
+		kps:     kps,
+		secrets: make([][32]byte, len(kps)),
+	}
+	for i, kp := range kps {
+		extra25519.PrivateKeyToCurve25519(&u.secrets[i], kp.Pair.Secret)
+	}
+	return u
+}
+
+// Open returns the content of rawMsg and the first keypair that can read it
+func (u *Unboxer) Open(rawMsg []byte) (*ssb.KeyPair, []byte, error) {
+	for i := range u.secrets {
+		content, err := unbox(&u.secrets[i], rawMsg)
+		if err == nil {
+			return u.kps[i], content, nil
+		}
+	}
+	return nil, nil, ErrPrivateMessageDecryptFailed
+}
+
+// Readers returns all the keypairs that can read rawMsg and its content, for messages to more than one of them
+func (u *Unboxer) Readers(rawMsg []byte) ([]*ssb.KeyPair, []byte, error) {
+	var (
+		readers []*ssb.KeyPair
+		content []byte
+	)
+	for i := range u.secrets {
+		c, err := unbox(&u.secrets[i], rawMsg)
+		if err == nil {
+			readers = append(readers, u.kps[i])
+			content = c
+		}
+	}
+	if len(readers) == 0 {
+		return nil, nil, ErrPrivateMessageDecryptFailed
+	}
+	return readers, content, nil
+}
@@ -0,0 +1,249 @@
+// SPDX-License-Identifier: MIT
+
+// Package groups creates private groups, adds members to them and learns the groups others add us to.
+//
+// A group is a key that is shared with its members. It is created with a group/init message encrypted to that key,
+// the id of the group is the cloaked id of that message. Members are added with a group/add-member message that holds the key,
+// it is encrypted to the group and the direct message keys of the new members.
+package groups
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/private"
+	"go.cryptoscope.co/ssb/private/box2"
+	"go.cryptoscope.co/ssb/private/keys"
+)
+
+// the types of the group messages
+const (
+	TypeInit      = "group/init"
+	TypeAddMember = "group/add-member"
+)
+
+// MaxAddMembers is the most members one add-member message can add, the other slots are the group itself
+const MaxAddMembers = box2.MaxRecipients - 1
+
+// Tangle places a message in a thread of messages: the first one and the latest ones it knows of
+type Tangle struct {
+	Root     *ssb.MessageRef   `json:"root"`
+	Previous []*ssb.MessageRef `json:"previous"`
+}
+
+// InitContent starts a group
+type InitContent struct {
+	Type    string            `json:"type"`
+	Tangles map[string]Tangle `json:"tangles"`
+}
+
+// AddMemberContent gives the key of a group to new members
+type AddMemberContent struct {
+	Type     string            `json:"type"`
+	Version  string            `json:"version"`
+	GroupKey string            `json:"groupKey"`
+	Root     *ssb.MessageRef   `json:"root"`
+	Text     string            `json:"text,omitempty"`
+	Recps    []string          `json:"recps"`
+	Tangles  map[string]Tangle `json:"tangles"`
+}
+
+// Groups publishes the group messages of a feed and keeps the groups it is in with the keys manager
+type Groups struct {
+	keys    *keys.Manager
+	publish ssb.Publisher
+}
+
+// New returns Groups that publishes with publish, which has to be the feed of km
+func New(km *keys.Manager, publish ssb.Publisher) *Groups {
+	return &Groups{
+		keys:    km,
+		publish: publish,
+	}
+}
+
+// Create makes a new group with a random key and publishes its init message.
+// The group is added to the keys manager, so that it can be written to right away.
+func (g *Groups) Create() (keys.Group, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return keys.Group{}, errors.Wrap(err, "groups: failed to make group key")
+	}
+
+	plain, err := json.Marshal(InitContent{
+		Type:    TypeInit,
+		Tangles: map[string]Tangle{"group": {}},
+	})
+	if err != nil {
+		return keys.Group{}, errors.Wrap(err, "groups: failed to encode init message")
+	}
+
+	boxer := &readKeyBoxer{
+		plain: plain,
+		recps: []box2.Recipient{{Key: key, Scheme: box2.SchemeLargeSymmetricGroup}},
+	}
+	root, err := g.publish.Publish(boxer)
+	if err != nil {
+		return keys.Group{}, errors.Wrap(err, "groups: failed to publish init message")
+	}
+
+	id, err := box2.CloakedMessageID(root, boxer.readKey)
+	if err != nil {
+		return keys.Group{}, err
+	}
+	grp := keys.Group{ID: id, Key: key, Root: root}
+	if err := g.keys.AddGroup(grp); err != nil {
+		return keys.Group{}, errors.Wrapf(err, "groups: failed to keep group %s", id)
+	}
+	return grp, nil
+}
+
+// AddMember publishes an add-member message that gives the key of the group id to members, text is an optional greeting
+func (g *Groups) AddMember(id string, members []*ssb.FeedRef, text string) (*ssb.MessageRef, error) {
+	if n := len(members); n == 0 || n > MaxAddMembers {
+		return nil, errors.Errorf("groups: can add 1 to %d members at once, not %d", MaxAddMembers, n)
+	}
+	grp, ok := g.keys.Group(id)
+	if !ok {
+		return nil, errors.Errorf("groups: unknown group %s", id)
+	}
+	if grp.Root == nil {
+		return nil, errors.Errorf("groups: init message of group %s is unknown", id)
+	}
+
+	content := AddMemberContent{
+		Type:     TypeAddMember,
+		Version:  "v1",
+		GroupKey: base64.StdEncoding.EncodeToString(grp.Key),
+		Root:     grp.Root,
+		Text:     text,
+		Recps:    []string{id},
+		Tangles: map[string]Tangle{
+			"group":   {Root: grp.Root, Previous: []*ssb.MessageRef{grp.Root}},
+			"members": {Root: grp.Root, Previous: []*ssb.MessageRef{grp.Root}},
+		},
+	}
+	recps := []box2.Recipient{{Key: grp.Key, Scheme: box2.SchemeLargeSymmetricGroup}}
+	for _, m := range members {
+		dm, err := g.keys.DMKey(m)
+		if err != nil {
+			return nil, errors.Wrapf(err, "groups: no direct message key for %s", m.Ref())
+		}
+		recps = append(recps, dm)
+		content.Recps = append(content.Recps, m.Ref())
+	}
+
+	plain, err := json.Marshal(content)
+	if err != nil {
+		return nil, errors.Wrap(err, "groups: failed to encode add-member message")
+	}
+	ref, err := g.publish.Publish(box2.Content{Plain: plain, Recipients: recps})
+	if err != nil {
+		return nil, errors.Wrap(err, "groups: failed to publish add-member message")
+	}
+	return ref, nil
+}
+
+// List returns the groups the feed is in
+func (g *Groups) List() []keys.Group {
+	return g.keys.Groups()
+}
+
+// Learn adds the group of an add-member message to km if it is addressed to the feed of km.
+// plain is the decrypted content of the message, isNew is false for everything else and groups that were already known.
+//
+// Anyone can send us an add-member message, so a new group is only taken once get finds its init message,
+// the key opens it and its cloaked id is the id of the group. The key of a group that is known already is never replaced.
+func Learn(km *keys.Manager, get ssb.Getter, plain []byte) (grp keys.Group, isNew bool, err error) {
+	var content AddMemberContent
+	if err := json.Unmarshal(plain, &content); err != nil || content.Type != TypeAddMember {
+		// not json or some other message, that's fine
+		return keys.Group{}, false, nil
+	}
+
+	if len(content.Recps) < 2 || !strings.HasSuffix(content.Recps[0], ".cloaked") {
+		return keys.Group{}, false, errors.Errorf("groups: add-member message without group id")
+	}
+	self := km.KeyPair().Id.Ref()
+	var toSelf bool
+	for _, r := range content.Recps[1:] {
+		toSelf = toSelf || r == self
+	}
+	if !toSelf {
+		return keys.Group{}, false, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(content.GroupKey)
+	if err != nil {
+		return keys.Group{}, false, errors.Wrap(err, "groups: invalid group key")
+	}
+	id := content.Recps[0]
+	if known, ok := km.Group(id); ok {
+		if !bytes.Equal(known.Key, key) {
+			return keys.Group{}, false, errors.Errorf("groups: add-member message has another key for group %s", id)
+		}
+		return known, false, nil
+	}
+
+	if content.Root == nil {
+		return keys.Group{}, false, errors.Errorf("groups: add-member message for %s without init message", id)
+	}
+	if err := verifyInit(get, id, key, content.Root); err != nil {
+		return keys.Group{}, false, err
+	}
+	grp = keys.Group{ID: id, Key: key, Root: content.Root}
+	if err := km.AddGroup(grp); err != nil {
+		return keys.Group{}, false, err
+	}
+	return grp, true, nil
+}
+
+// verifyInit checks that root is a group/init message that key opens and that id is its cloaked id
+func verifyInit(get ssb.Getter, id string, key []byte, root *ssb.MessageRef) error {
+	if get == nil {
+		return errors.Errorf("groups: can't look up the init message of group %s", id)
+	}
+	msg, err := get.Get(*root)
+	if err != nil {
+		return errors.Wrapf(err, "groups: init message %s of group %s is unknown", root.Ref(), id)
+	}
+	plain, readKey, err := private.OpenWithKeys(msg, []box2.Recipient{{Key: key, Scheme: box2.SchemeLargeSymmetricGroup}})
+	if err != nil {
+		return errors.Wrapf(err, "groups: the key of group %s doesn't open init message %s", id, root.Ref())
+	}
+	var init InitContent
+	if err := json.Unmarshal(plain, &init); err != nil || init.Type != TypeInit {
+		return errors.Errorf("groups: %s is not the init message of a group", root.Ref())
+	}
+	cloaked, err := box2.CloakedMessageID(root, readKey)
+	if err != nil {
+		return err
+	}
+	if cloaked != id {
+		return errors.Errorf("groups: init message %s is of group %s, not %s", root.Ref(), cloaked, id)
+	}
+	return nil
+}
+
+// readKeyBoxer is box2.Content that keeps the read key of the message it was boxed for
+type readKeyBoxer struct {
+	plain   []byte
+	recps   []box2.Recipient
+	readKey []byte
+}
+
+func (b *readKeyBoxer) BoxContent(author *ssb.FeedRef, prev *ssb.MessageRef) ([]byte, error) {
+	ct, readKey, err := box2.EncryptReadKey(b.plain, author, prev, b.recps)
+	if err != nil {
+		return nil, err
+	}
+	b.readKey = readKey
+	return append([]byte(box2.Prefix), ct...), nil
+}
@@ -0,0 +1,242 @@
+// SPDX-License-Identifier: MIT
+
+package groups_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/luigi"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/indexes"
+	"go.cryptoscope.co/ssb/multilogs"
+	"go.cryptoscope.co/ssb/private"
+	"go.cryptoscope.co/ssb/private/box2"
+	"go.cryptoscope.co/ssb/private/groups"
+	"go.cryptoscope.co/ssb/private/keys"
+	"go.cryptoscope.co/ssb/sbot"
+)
+
+func TestGroups(t *testing.T) {
+	r := require.New(t)
+
+	srvRepo := filepath.Join("testrun", t.Name())
+	os.RemoveAll(srvRepo)
+
+	alice, err := ssb.NewKeyPair(bytes.NewReader(bytes.Repeat([]byte("alice"), 8)))
+	r.NoError(err)
+	bob, err := ssb.NewKeyPair(bytes.NewReader(bytes.Repeat([]byte("bobby"), 8)))
+	r.NoError(err)
+
+	km := keys.NewManager(alice)
+	srvLog := kitlog.NewNopLogger()
+	mlogPriv := multilogs.NewPrivateReadWithKeys(kitlog.With(srvLog, "module", "privLogs"), km)
+
+	srv, err := sbot.New(
+		sbot.WithKeyPair(alice),
+		sbot.WithInfo(srvLog),
+		sbot.WithRepoPath(srvRepo),
+		sbot.WithListenAddr(":0"),
+		sbot.WithKeyManager(km),
+		sbot.LateOption(sbot.MountSimpleIndex("get", indexes.OpenGet)),
+		sbot.LateOption(func(s *sbot.Sbot) error {
+			mlogPriv.SetGetter(s)
+			return sbot.MountMultiLog("privLogs", mlogPriv.OpenRoaring)(s)
+		}),
+	)
+	r.NoError(err, "sbot srv init failed")
+
+	grps := groups.New(km, srv.PublishLog)
+	grp, err := grps.Create()
+	r.NoError(err)
+	r.True(strings.HasSuffix(grp.ID, ".cloaked"), "not a cloaked id: %s", grp.ID)
+	r.NotNil(grp.Root)
+	r.Len(grps.List(), 1)
+
+	_, err = grps.AddMember(grp.ID, []*ssb.FeedRef{bob.Id}, "welcome")
+	r.NoError(err)
+	_, err = grps.AddMember("%unknown.cloaked", []*ssb.FeedRef{bob.Id}, "")
+	r.Error(err)
+
+	// a group we don't know yet and a message to it
+	otherKey := bytes.Repeat([]byte{5}, 32)
+	otherRecps := []box2.Recipient{{Key: otherKey, Scheme: box2.SchemeLargeSymmetricGroup}}
+	initPlain, err := json.Marshal(groups.InitContent{Type: groups.TypeInit, Tangles: map[string]groups.Tangle{"group": {}}})
+	r.NoError(err)
+	otherRoot, err := srv.PublishLog.Publish(box2.Content{Plain: initPlain, Recipients: otherRecps})
+	r.NoError(err)
+	srv.WaitUntilIndexesAreSynced()
+	initMsg, err := srv.Get(*otherRoot)
+	r.NoError(err)
+	_, readKey, err := private.OpenWithKeys(initMsg, otherRecps)
+	r.NoError(err)
+	otherID, err := box2.CloakedMessageID(otherRoot, readKey)
+	r.NoError(err)
+
+	_, err = srv.PublishLog.Publish(box2.Content{
+		Plain:      []byte(`{"type":"test","text":"before"}`),
+		Recipients: []box2.Recipient{{Key: otherKey, Scheme: box2.SchemeLargeSymmetricGroup}},
+	})
+	r.NoError(err)
+
+	self, err := km.DMKey(alice.Id)
+	r.NoError(err)
+	addTo := func(id string, key []byte, root *ssb.MessageRef) {
+		addMember, err := json.Marshal(groups.AddMemberContent{
+			Type:     groups.TypeAddMember,
+			Version:  "v1",
+			GroupKey: base64.StdEncoding.EncodeToString(key),
+			Root:     root,
+			Recps:    []string{id, alice.Id.Ref()},
+		})
+		r.NoError(err)
+		_, err = srv.PublishLog.Publish(box2.Content{Plain: addMember, Recipients: []box2.Recipient{self}})
+		r.NoError(err)
+	}
+
+	// neither another key for a group we are in nor a key that doesn't open the init message are taken
+	addTo(grp.ID, otherKey, grp.Root)
+	addTo("%forged.cloaked", otherKey, otherRoot)
+
+	// being added to it makes the init and the one before readable
+	addTo(otherID, otherKey, otherRoot)
+
+	srv.WaitUntilIndexesAreSynced()
+
+	pl, ok := srv.GetMultiLog("privLogs")
+	r.True(ok)
+	userPrivs, err := pl.Get(srv.KeyPair.Id.StoredAddr())
+	r.NoError(err)
+
+	// init, add bob, the other init, the one before and the three add-members to us
+	var n int
+	for try := 0; try < 50; try++ {
+		src, err := private.NewUnboxerLogWithKeys(srv.RootLog, userPrivs, km).Query()
+		r.NoError(err)
+		n = 0
+		for {
+			_, err := src.Next(context.TODO())
+			if luigi.IsEOS(err) {
+				break
+			}
+			r.NoError(err)
+			n++
+		}
+		if n == 7 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	r.Equal(7, n)
+
+	other, ok := km.Group(otherID)
+	r.True(ok, "didn't learn the other group")
+	r.Equal(otherKey, other.Key)
+	own, ok := km.Group(grp.ID)
+	r.True(ok)
+	r.Equal(grp.Key, own.Key, "key of the group was replaced")
+	_, ok = km.Group("%forged.cloaked")
+	r.False(ok, "learned a group without its init message")
+	r.Len(grps.List(), 2)
+
+	srv.Shutdown()
+	r.NoError(srv.Close())
+}
+
+func TestLearn(t *testing.T) {
+	r := require.New(t)
+
+	alice, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+	bob, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+	km := keys.NewManager(alice)
+
+	// the init message of the group, by bob
+	key := bytes.Repeat([]byte{1}, 32)
+	initPlain, err := json.Marshal(groups.InitContent{Type: groups.TypeInit, Tangles: map[string]groups.Tangle{"group": {}}})
+	r.NoError(err)
+	ct, readKey, err := box2.EncryptReadKey(initPlain, bob.Id, nil, []box2.Recipient{{Key: key, Scheme: box2.SchemeLargeSymmetricGroup}})
+	r.NoError(err)
+	root := &ssb.MessageRef{Hash: bytes.Repeat([]byte{7}, 32), Algo: ssb.RefAlgoMessageSSB1}
+	var initMsg ssb.KeyValueRaw
+	initMsg.Key_ = root
+	initMsg.Value.Author = *bob.Id
+	initMsg.Value.Sequence = 1
+	initMsg.Value.Content, err = json.Marshal(base64.StdEncoding.EncodeToString(ct) + ".box2")
+	r.NoError(err)
+	id, err := box2.CloakedMessageID(root, readKey)
+	r.NoError(err)
+	get := testGetter{root.Ref(): initMsg}
+
+	mk := func(key []byte, root *ssb.MessageRef, recps ...string) []byte {
+		plain, err := json.Marshal(groups.AddMemberContent{
+			Type:     groups.TypeAddMember,
+			Version:  "v1",
+			GroupKey: base64.StdEncoding.EncodeToString(key),
+			Root:     root,
+			Recps:    recps,
+		})
+		r.NoError(err)
+		return plain
+	}
+
+	_, isNew, err := groups.Learn(km, get, []byte(`{"type":"post","text":"hi"}`))
+	r.NoError(err)
+	r.False(isNew)
+
+	_, isNew, err = groups.Learn(km, get, mk(key, root, id, bob.Id.Ref()))
+	r.NoError(err)
+	r.False(isNew, "not for us")
+
+	_, _, err = groups.Learn(km, get, mk(key, root, alice.Id.Ref()))
+	r.Error(err, "no group id")
+
+	// the init message has to be there, open with the key and have the id of the group
+	_, _, err = groups.Learn(km, get, mk(key, nil, id, alice.Id.Ref()))
+	r.Error(err, "no init message")
+	_, _, err = groups.Learn(km, testGetter{}, mk(key, root, id, alice.Id.Ref()))
+	r.Error(err, "unknown init message")
+	_, _, err = groups.Learn(km, get, mk(bytes.Repeat([]byte{2}, 32), root, id, alice.Id.Ref()))
+	r.Error(err, "key doesn't open the init message")
+	_, _, err = groups.Learn(km, get, mk(key, root, "%other.cloaked", alice.Id.Ref()))
+	r.Error(err, "not the id of the init message")
+	r.Len(km.Groups(), 0)
+
+	grp, isNew, err := groups.Learn(km, get, mk(key, root, id, bob.Id.Ref(), alice.Id.Ref()))
+	r.NoError(err)
+	r.True(isNew)
+	r.Equal(id, grp.ID)
+
+	_, isNew, err = groups.Learn(km, get, mk(key, root, id, alice.Id.Ref()))
+	r.NoError(err)
+	r.False(isNew, "known already")
+
+	// the key of a known group is never replaced
+	_, _, err = groups.Learn(km, get, mk(bytes.Repeat([]byte{2}, 32), root, id, alice.Id.Ref()))
+	r.Error(err)
+	known, ok := km.Group(id)
+	r.True(ok)
+	r.Equal(key, known.Key)
+}
+
+type testGetter map[string]ssb.KeyValueRaw
+
+func (tg testGetter) Get(ref ssb.MessageRef) (ssb.Message, error) {
+	msg, ok := tg[ref.Ref()]
+	if !ok {
+		return nil, errors.Errorf("no message %s", ref.Ref())
+	}
+	return msg, nil
+}
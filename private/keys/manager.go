@@ -0,0 +1,297 @@
+// SPDX-License-Identifier: MIT
+
+// Package keys holds the keys a bot can encrypt private messages to and read them with, for box2.
+package keys
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/extra25519"
+	"go.cryptoscope.co/ssb/private/box2"
+)
+
+// Manager knows the keys of the groups a feed is in and derives the direct message keys it shares with other feeds.
+// The groups of a manager from NewManager are only held in memory, OpenManager keeps them in a file.
+type Manager struct {
+	kp   *ssb.KeyPair
+	path string
+
+	mu      sync.Mutex
+	groups  map[string]Group
+	dms     map[string][]byte // derived ones, by feed
+	newHook []func(Group)
+}
+
+// Group is a group a feed is in: the cloaked id of its init message, the key of the group and the init message itself, if it is known
+type Group struct {
+	ID   string          `json:"id"`
+	Key  []byte          `json:"key"`
+	Root *ssb.MessageRef `json:"root,omitempty"`
+}
+
+// NewManager returns a manager for the keys of kp, without any groups
+func NewManager(kp *ssb.KeyPair) *Manager {
+	return &Manager{
+		kp:     kp,
+		groups: make(map[string]Group),
+		dms:    make(map[string][]byte),
+	}
+}
+
+// OpenManager returns a manager for the keys of kp with the groups stored at path, the file is created when the first group is added
+func OpenManager(kp *ssb.KeyPair, path string) (*Manager, error) {
+	m := NewManager(kp)
+	m.path = path
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "keys: failed to open groups")
+	}
+	defer f.Close()
+
+	var groups []Group
+	if err := json.NewDecoder(f).Decode(&groups); err != nil {
+		return nil, errors.Wrapf(err, "keys: failed to decode groups of %s", path)
+	}
+	for _, g := range groups {
+		if len(g.Key) != 32 {
+			return nil, errors.Errorf("keys: group %s has a key of %d bytes", g.ID, len(g.Key))
+		}
+		m.groups[g.ID] = g
+	}
+	return m, nil
+}
+
+// KeyPair returns the keypair the manager derives direct message keys with
+func (m *Manager) KeyPair() *ssb.KeyPair { return m.kp }
+
+// AddGroupKey makes messages to the group id readable and lets Recipients encrypt to it
+func (m *Manager) AddGroupKey(id string, key []byte) error {
+	return m.AddGroup(Group{ID: id, Key: key})
+}
+
+// AddGroup is AddGroupKey with the init message of the group.
+// The hooks of OnNewGroup are called if the group wasn't known before, after it is stored.
+// A known group keeps its key, adding it with another one is an error.
+func (m *Manager) AddGroup(g Group) error {
+	if g.ID == "" {
+		return errors.New("keys: group id can't be empty")
+	}
+	if len(g.Key) != 32 {
+		return errors.Errorf("keys: group key has %d bytes, not 32", len(g.Key))
+	}
+	g.Key = append([]byte(nil), g.Key...)
+
+	m.mu.Lock()
+	old, known := m.groups[g.ID]
+	if known && !bytes.Equal(old.Key, g.Key) {
+		m.mu.Unlock()
+		return errors.Errorf("keys: group %s is known with another key", g.ID)
+	}
+	if known && (g.Root == nil || old.Root != nil) {
+		m.mu.Unlock()
+		return nil
+	}
+	if g.Root == nil {
+		g.Root = old.Root
+	}
+	m.groups[g.ID] = g
+	err := m.save()
+	hooks := m.newHook
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if !known {
+		for _, fn := range hooks {
+			fn(g)
+		}
+	}
+	return nil
+}
+
+// OnNewGroup calls fn with every group that is added from now on and wasn't known before, for instance to read older messages to it
+func (m *Manager) OnNewGroup(fn func(Group)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.newHook = append(m.newHook, fn)
+}
+
+// RemoveGroupKey forgets the key of the group id
+func (m *Manager) RemoveGroupKey(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.groups, id)
+	return m.save()
+}
+
+// GroupKey returns the key of the group id, ok is false if it isn't known
+func (m *Manager) GroupKey(id string) (box2.Recipient, bool) {
+	g, ok := m.Group(id)
+	if !ok {
+		return box2.Recipient{}, false
+	}
+	return box2.Recipient{Key: g.Key, Scheme: box2.SchemeLargeSymmetricGroup}, true
+}
+
+// Group returns the group id, ok is false if it isn't known
+func (m *Manager) Group(id string) (Group, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	g, ok := m.groups[id]
+	return g, ok
+}
+
+// Groups returns all known groups, sorted by id
+func (m *Manager) Groups() []Group {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	gs := make([]Group, 0, len(m.groups))
+	for _, g := range m.groups {
+		gs = append(gs, g)
+	}
+	sort.Slice(gs, func(i, j int) bool { return gs[i].ID < gs[j].ID })
+	return gs
+}
+
+// save writes the groups to the file of the manager, if it has one. m.mu has to be held.
+func (m *Manager) save() error {
+	if m.path == "" {
+		return nil
+	}
+	gs := make([]Group, 0, len(m.groups))
+	for _, g := range m.groups {
+		gs = append(gs, g)
+	}
+	sort.Slice(gs, func(i, j int) bool { return gs[i].ID < gs[j].ID })
+	data, err := json.Marshal(gs)
+	if err != nil {
+		return errors.Wrap(err, "keys: failed to encode groups")
+	}
+
+	tmp := m.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return errors.Wrap(err, "keys: failed to write groups")
+	}
+	return errors.Wrap(os.Rename(tmp, m.path), "keys: failed to replace groups")
+}
+
+// DMKey returns the direct message key we share with other. Both sides derive the same one.
+func (m *Manager) DMKey(other *ssb.FeedRef) (box2.Recipient, error) {
+	m.mu.Lock()
+	key, ok := m.dms[other.Ref()]
+	m.mu.Unlock()
+	if !ok {
+		var err error
+		key, err = DeriveDMKey(m.kp, other)
+		if err != nil {
+			return box2.Recipient{}, err
+		}
+		m.mu.Lock()
+		m.dms[other.Ref()] = key
+		m.mu.Unlock()
+	}
+	return box2.Recipient{Key: key, Scheme: box2.SchemeDirectMessage}, nil
+}
+
+// Recipients turns the recps of a private message into keys. Feeds get the direct message key, everything else is looked up as a group id.
+func (m *Manager) Recipients(recps []string) ([]box2.Recipient, error) {
+	rs := make([]box2.Recipient, len(recps))
+	for i, recp := range recps {
+		if ref, err := ssb.ParseFeedRef(recp); err == nil {
+			rs[i], err = m.DMKey(ref)
+			if err != nil {
+				return nil, errors.Wrapf(err, "keys: recipient %d", i)
+			}
+			continue
+		}
+		r, ok := m.GroupKey(recp)
+		if !ok {
+			return nil, errors.Errorf("keys: recipient %d: no key for group %s", i, recp)
+		}
+		rs[i] = r
+	}
+	return rs, nil
+}
+
+// CandidateKeys are the keys to try on a message by author: the keys of all groups and the direct message key shared with author.
+// Our own messages to ourself use the key we share with ourself.
+func (m *Manager) CandidateKeys(author *ssb.FeedRef) []box2.Recipient {
+	var cs []box2.Recipient
+	if dm, err := m.DMKey(author); err == nil {
+		cs = append(cs, dm)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, g := range m.groups {
+		cs = append(cs, box2.Recipient{Key: g.Key, Scheme: box2.SchemeLargeSymmetricGroup})
+	}
+	return cs
+}
+
+// the constants of the direct message key derivation
+var (
+	dmInfoContext = []byte("envelope-ssb-dm-v1/key")
+	dmSalt        = sha256.Sum256([]byte("envelope-dm-v1-extract-salt"))
+)
+
+// DeriveDMKey derives the direct message key kp shares with other, from the Diffie-Hellman secret of their keys converted to curve25519.
+// The info binds the key to both feeds, sorted so that both sides get the same one.
+func DeriveDMKey(kp *ssb.KeyPair, other *ssb.FeedRef) ([]byte, error) {
+	if len(other.ID) != ed25519.PublicKeySize {
+		return nil, errors.Errorf("keys: can't derive a direct message key with %s", other.Ref())
+	}
+
+	var mySecret, myPublic, otherPublic [32]byte
+	extra25519.PrivateKeyToCurve25519(&mySecret, kp.Pair.Secret)
+	if !extra25519.PublicKeyToCurve25519(&myPublic, kp.Pair.Public) {
+		return nil, errors.New("keys: invalid public key of our own")
+	}
+	if !extra25519.PublicKeyToCurve25519(&otherPublic, other.PubKey()) {
+		return nil, errors.Errorf("keys: invalid public key of %s", other.Ref())
+	}
+	return deriveDMKey(mySecret, myPublic, kp.Id, otherPublic, other)
+}
+
+// deriveDMKey is DeriveDMKey with the curve25519 keys of both sides
+func deriveDMKey(mySecret, myPublic [32]byte, me *ssb.FeedRef, otherPublic [32]byte, other *ssb.FeedRef) ([]byte, error) {
+	var shared [32]byte
+	curve25519.ScalarMult(&shared, &mySecret, &otherPublic)
+
+	myFeed, err := box2.FeedTFK(me)
+	if err != nil {
+		return nil, err
+	}
+	otherFeed, err := box2.FeedTFK(other)
+	if err != nil {
+		return nil, err
+	}
+	infoKeys := [][]byte{
+		append(box2.DHKeyTFK(myPublic[:]), myFeed...),
+		append(box2.DHKeyTFK(otherPublic[:]), otherFeed...),
+	}
+	sort.Slice(infoKeys, func(i, j int) bool { return bytes.Compare(infoKeys[i], infoKeys[j]) < 0 })
+
+	key := make([]byte, 32)
+	r := hkdf.New(sha256.New, shared[:], dmSalt[:], box2.SLPEncode(dmInfoContext, infoKeys[0], infoKeys[1]))
+	if _, err := io.ReadFull(r, key); err != nil {
+		return nil, errors.Wrap(err, "keys: failed to derive direct message key")
+	}
+	return key, nil
+}
@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: MIT
+
+package keys
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/private/box2"
+)
+
+func TestDMKey(t *testing.T) {
+	r := require.New(t)
+
+	alice, err := ssb.NewKeyPair(bytes.NewReader(bytes.Repeat([]byte("alice"), 8)))
+	r.NoError(err)
+	bob, err := ssb.NewKeyPair(bytes.NewReader(bytes.Repeat([]byte("bobby"), 8)))
+	r.NoError(err)
+	carl, err := ssb.NewKeyPair(bytes.NewReader(bytes.Repeat([]byte("carla"), 8)))
+	r.NoError(err)
+
+	ab, err := NewManager(alice).DMKey(bob.Id)
+	r.NoError(err)
+	ba, err := NewManager(bob).DMKey(alice.Id)
+	r.NoError(err)
+	r.Equal(ab, ba, "both sides should derive the same key")
+	r.Equal(box2.SchemeDirectMessage, ab.Scheme)
+	r.Len(ab.Key, 32)
+
+	ac, err := NewManager(alice).DMKey(carl.Id)
+	r.NoError(err)
+	r.NotEqual(ab.Key, ac.Key)
+
+	// bob can read what alice sent him
+	ct, err := box2.Encrypt([]byte("hi bob"), alice.Id, nil, []box2.Recipient{ab})
+	r.NoError(err)
+	out, err := box2.Decrypt(ct, alice.Id, nil, NewManager(bob).CandidateKeys(alice.Id))
+	r.NoError(err)
+	r.Equal("hi bob", string(out))
+
+	_, err = box2.Decrypt(ct, alice.Id, nil, NewManager(carl).CandidateKeys(alice.Id))
+	r.Equal(box2.ErrDecryptFailed, err)
+}
+
+func TestGroupKeys(t *testing.T) {
+	r := require.New(t)
+
+	alice, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+	bob, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+
+	km := NewManager(alice)
+	r.Error(km.AddGroupKey("%group.cloaked", []byte("short")))
+	r.NoError(km.AddGroupKey("%group.cloaked", bytes.Repeat([]byte{1}, 32)))
+
+	rs, err := km.Recipients([]string{"%group.cloaked", bob.Id.Ref()})
+	r.NoError(err)
+	r.Len(rs, 2)
+	r.Equal(box2.SchemeLargeSymmetricGroup, rs[0].Scheme)
+	r.Equal(box2.SchemeDirectMessage, rs[1].Scheme)
+
+	_, err = km.Recipients([]string{"%other.cloaked"})
+	r.Error(err)
+
+	// the group key and the key shared with the author
+	r.Len(km.CandidateKeys(bob.Id), 2)
+
+	r.NoError(km.RemoveGroupKey("%group.cloaked"))
+	_, ok := km.GroupKey("%group.cloaked")
+	r.False(ok)
+	r.Len(km.CandidateKeys(bob.Id), 1)
+}
+
+func TestManagerStore(t *testing.T) {
+	r := require.New(t)
+
+	dir, err := ioutil.TempDir("", "keys-store")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "groups.json")
+
+	kp, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+
+	km, err := OpenManager(kp, path)
+	r.NoError(err)
+	r.Len(km.Groups(), 0)
+
+	var added []string
+	km.OnNewGroup(func(g Group) { added = append(added, g.ID) })
+
+	root := &ssb.MessageRef{Hash: bytes.Repeat([]byte{3}, 32), Algo: ssb.RefAlgoMessageSSB1}
+	r.NoError(km.AddGroup(Group{ID: "%one.cloaked", Key: bytes.Repeat([]byte{1}, 32), Root: root}))
+	r.NoError(km.AddGroupKey("%two.cloaked", bytes.Repeat([]byte{2}, 32)))
+	r.NoError(km.AddGroupKey("%one.cloaked", bytes.Repeat([]byte{1}, 32)))
+	r.Equal([]string{"%one.cloaked", "%two.cloaked"}, added, "known groups are only new once")
+	r.Error(km.AddGroupKey("%one.cloaked", bytes.Repeat([]byte{9}, 32)), "replaced the key of a known group")
+	one, ok := km.Group("%one.cloaked")
+	r.True(ok)
+	r.Equal(bytes.Repeat([]byte{1}, 32), one.Key)
+
+	// the groups are there again after a restart, with their roots
+	km2, err := OpenManager(kp, path)
+	r.NoError(err)
+	gs := km2.Groups()
+	r.Len(gs, 2)
+	r.Equal("%one.cloaked", gs[0].ID)
+	r.True(root.Equal(*gs[0].Root))
+	r.Nil(gs[1].Root)
+
+	r.NoError(km2.RemoveGroupKey("%two.cloaked"))
+	km3, err := OpenManager(kp, path)
+	r.NoError(err)
+	r.Len(km3.Groups(), 1)
+}
@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: MIT
+
+package keys
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// TestSpecDMKey runs the direct message key vectors of https://github.com/ssbc/private-group-spec,
+// vendored into testdata by private/vendor-spec-vectors.sh. Another checkout of it can be pointed to with PRIVATE_GROUP_SPEC.
+func TestSpecDMKey(t *testing.T) {
+	dir := filepath.Join("testdata", "private-group-spec")
+	if env := os.Getenv("PRIVATE_GROUP_SPEC"); env != "" {
+		dir = env
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "direct-messages", "vectors", "*.json"))
+	require.NoError(t, err)
+	if len(files) == 0 {
+		t.Fatalf("no private-group-spec vectors in %s, run private/vendor-spec-vectors.sh or set PRIVATE_GROUP_SPEC", dir)
+	}
+
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			r := require.New(t)
+			data, err := ioutil.ReadFile(file)
+			r.NoError(err)
+			var v struct {
+				Description string `json:"description"`
+				Input       struct {
+					MyDHSecret   string `json:"my_dh_secret"`
+					MyDHPublic   string `json:"my_dh_public"`
+					MyFeedID     string `json:"my_feed_id"`
+					YourDHPublic string `json:"your_dh_public"`
+					YourFeedID   string `json:"your_feed_id"`
+				} `json:"input"`
+				Output struct {
+					SharedKey string `json:"shared_key"`
+				} `json:"output"`
+			}
+			r.NoError(json.Unmarshal(data, &v))
+			if v.Input.MyDHSecret == "" {
+				t.Skip("not a direct message key vector")
+			}
+
+			var mySecret, myPublic, yourPublic [32]byte
+			copy(mySecret[:], specBytes(t, v.Input.MyDHSecret))
+			copy(myPublic[:], specBytes(t, v.Input.MyDHPublic))
+			copy(yourPublic[:], specBytes(t, v.Input.YourDHPublic))
+			me, err := ssb.ParseFeedRef(v.Input.MyFeedID)
+			r.NoError(err)
+			you, err := ssb.ParseFeedRef(v.Input.YourFeedID)
+			r.NoError(err)
+
+			key, err := deriveDMKey(mySecret, myPublic, me, yourPublic, you)
+			r.NoError(err)
+			r.Equal(specBytes(t, v.Output.SharedKey), key, v.Description)
+		})
+	}
+}
+
+// specBytes decodes the base64 of the vectors, some of them have an encoding suffix
+func specBytes(t *testing.T, s string) []byte {
+	if i := strings.LastIndex(s, "."); i > 0 {
+		s = s[:i]
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	require.NoError(t, err, "invalid base64 in vector: %q", s)
+	return b
+}
@@ -0,0 +1,105 @@
+// SPDX-License-Identifier: MIT
+
+package private
+
+import (
+	"bytes"
+	"encoding/base64"
+
+	"github.com/pkg/errors"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/private/box2"
+	"go.cryptoscope.co/ssb/private/keys"
+)
+
+// ErrNotBoxed is returned by Open for content that isn't a private message
+var ErrNotBoxed = errors.New("private: content is not boxed")
+
+// Open decrypts the content of msg, box1 and box2 alike.
+// box1 is opened with the keypair of km, box2 with all of its candidate keys for the author of msg.
+func Open(km *keys.Manager, msg ssb.Message) ([]byte, error) {
+	boxed, isBox2, err := boxedContent(msg)
+	if err != nil {
+		return nil, err
+	}
+	if !isBox2 {
+		return Unbox(km.KeyPair(), boxed)
+	}
+	clear, _, err := openBox2(msg, boxed, km.CandidateKeys(msg.Author()))
+	return clear, err
+}
+
+// OpenWithKeys decrypts msg if it is box2 and one of candidates opens it, it also returns the read key of msg.
+// Other content, box1 included, is ErrNotBoxed.
+func OpenWithKeys(msg ssb.Message, candidates []box2.Recipient) ([]byte, []byte, error) {
+	boxed, isBox2, err := boxedContent(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !isBox2 {
+		return nil, nil, ErrNotBoxed
+	}
+	return openBox2(msg, boxed, candidates)
+}
+
+// ReadersOf decrypts msg if it is box1 and returns the keypairs of u that can read it.
+// Other content, box2 included, is ErrNotBoxed. If none of the keypairs can read it, it's ErrPrivateMessageDecryptFailed.
+func (u *Unboxer) ReadersOf(msg ssb.Message) ([]*ssb.KeyPair, []byte, error) {
+	boxed, isBox2, err := boxedContent(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if isBox2 {
+		return nil, nil, ErrNotBoxed
+	}
+	return u.Readers(boxed)
+}
+
+func openBox2(msg ssb.Message, boxed []byte, candidates []box2.Recipient) ([]byte, []byte, error) {
+	clear, readKey, err := box2.DecryptReadKey(boxed, msg.Author(), msg.Previous(), candidates)
+	if err != nil {
+		if errors.Cause(err) == box2.ErrDecryptFailed {
+			return nil, nil, ErrPrivateMessageDecryptFailed
+		}
+		return nil, nil, err
+	}
+	return clear, readKey, nil
+}
+
+// boxedContent returns the ciphertext of msg and if it is box2.
+// Legacy messages have it as a base64 string with the .box or .box2 suffix, gabby ones with a box1: or box2: prefix.
+func boxedContent(msg ssb.Message) ([]byte, bool, error) {
+	input := msg.ContentBytes()
+	switch msg.Author().Algo {
+	case ssb.RefAlgoFeedSSB1:
+		if len(input) < 2 || !(input[0] == '"' && input[len(input)-1] == '"') {
+			return nil, false, ErrNotBoxed
+		}
+		str := input[1 : len(input)-1]
+		var isBox2 bool
+		switch {
+		case bytes.HasSuffix(str, []byte(".box2")):
+			str, isBox2 = bytes.TrimSuffix(str, []byte(".box2")), true
+		case bytes.HasSuffix(str, []byte(".box")):
+			str = bytes.TrimSuffix(str, []byte(".box"))
+		default:
+			return nil, false, ErrNotBoxed
+		}
+		boxed := make([]byte, base64.StdEncoding.DecodedLen(len(str)))
+		n, err := base64.StdEncoding.Decode(boxed, str)
+		if err != nil {
+			return nil, false, errors.Wrap(err, "decode pm: invalid b64 encoding")
+		}
+		return boxed[:n], isBox2, nil
+
+	case ssb.RefAlgoFeedGabby:
+		if bytes.HasPrefix(input, []byte(box2.Prefix)) {
+			return bytes.TrimPrefix(input, []byte(box2.Prefix)), true, nil
+		}
+		return bytes.TrimPrefix(input, []byte("box1:")), false, nil
+
+	default:
+		return nil, false, errors.Errorf("decode pm: unknown feed type: %s", msg.Author().Algo)
+	}
+}
@@ -20,6 +20,8 @@ import (
 	"go.cryptoscope.co/ssb/client"
 	"go.cryptoscope.co/ssb/multilogs"
 	"go.cryptoscope.co/ssb/private"
+	"go.cryptoscope.co/ssb/private/box2"
+	"go.cryptoscope.co/ssb/private/keys"
 	"go.cryptoscope.co/ssb/sbot"
 )
 
@@ -123,3 +125,81 @@ func testPublishPerAlgo(algo string) func(t *testing.T) {
 		r.NoError(srv.Close())
 	}
 }
+
+func TestPrivatePublishBox2(t *testing.T) {
+	t.Run("classic", testPublishBox2PerAlgo(ssb.RefAlgoFeedSSB1))
+	t.Run("gabby", testPublishBox2PerAlgo(ssb.RefAlgoFeedGabby))
+}
+
+func testPublishBox2PerAlgo(algo string) func(t *testing.T) {
+	return func(t *testing.T) {
+		r := require.New(t)
+
+		srvRepo := filepath.Join("testrun", t.Name(), "serv")
+		os.RemoveAll(srvRepo)
+
+		alice, err := ssb.NewKeyPair(bytes.NewReader(bytes.Repeat([]byte("alice"), 8)))
+		r.NoError(err)
+		alice.Id.Algo = algo
+
+		bob, err := ssb.NewKeyPair(bytes.NewReader(bytes.Repeat([]byte("bobby"), 8)))
+		r.NoError(err)
+
+		km := keys.NewManager(alice)
+		r.NoError(km.AddGroupKey("%group.cloaked", bytes.Repeat([]byte{1}, 32)))
+
+		srvLog := kitlog.NewNopLogger()
+		mlogPriv := multilogs.NewPrivateReadWithKeys(kitlog.With(srvLog, "module", "privLogs"), km)
+
+		srv, err := sbot.New(
+			sbot.WithKeyPair(alice),
+			sbot.WithInfo(srvLog),
+			sbot.WithRepoPath(srvRepo),
+			sbot.WithListenAddr(":0"),
+			sbot.WithKeyManager(km),
+			sbot.LateOption(sbot.MountMultiLog("privLogs", mlogPriv.OpenRoaring)),
+		)
+		r.NoError(err, "sbot srv init failed")
+
+		// one before, so that prev isn't nil
+		_, err = srv.PublishLog.Publish(map[string]string{"type": "test", "text": "clear text!"})
+		r.NoError(err)
+
+		var refs []*ssb.MessageRef
+		for _, recps := range [][]string{
+			{"%group.cloaked"},
+			{alice.Id.Ref(), bob.Id.Ref()},
+			{bob.Id.Ref()}, // not readable by us
+		} {
+			rs, err := km.Recipients(recps)
+			r.NoError(err)
+			ref, err := srv.PublishLog.Publish(box2.Content{Plain: []byte(`{"type":"test","text":"secret"}`), Recipients: rs})
+			r.NoError(err)
+			refs = append(refs, ref)
+		}
+
+		srv.WaitUntilIndexesAreSynced()
+
+		pl, ok := srv.GetMultiLog("privLogs")
+		r.True(ok)
+		userPrivs, err := pl.Get(srv.KeyPair.Id.StoredAddr())
+		r.NoError(err)
+
+		src, err := private.NewUnboxerLogWithKeys(srv.RootLog, userPrivs, km).Query()
+		r.NoError(err)
+		for i, ref := range refs[:2] {
+			v, err := src.Next(context.TODO())
+			r.NoError(err, "failed to get msg %d", i)
+			msg, ok := v.(ssb.Message)
+			r.True(ok, "wrong type: %T", v)
+			r.Equal(ref.Ref(), msg.Key().Ref())
+			r.JSONEq(`{"type":"test","text":"secret"}`, string(msg.ContentBytes()))
+		}
+		_, err = src.Next(context.TODO())
+		r.Error(err)
+		r.EqualError(luigi.EOS{}, errors.Cause(err).Error())
+
+		srv.Shutdown()
+		r.NoError(srv.Close())
+	}
+}
@@ -0,0 +1,57 @@
+// SPDX-License-Identifier: MIT
+
+package private
+
+import (
+	"encoding/json"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// Decrypted is the short form of a decrypted message, with who it was for.
+// private.read returns them when it is called with {recipients: true}.
+type Decrypted struct {
+	Key        *ssb.MessageRef `json:"key"`
+	Author     *ssb.FeedRef    `json:"author"`
+	Sequence   int64           `json:"sequence"`
+	Content    json.RawMessage `json:"content"`
+	Recipients []string        `json:"recipients"`
+}
+
+// NewDecrypted returns the short form of msg, which has to be decrypted already
+func NewDecrypted(msg ssb.Message) Decrypted {
+	return Decrypted{
+		Key:        msg.Key(),
+		Author:     msg.Author(),
+		Sequence:   msg.Seq(),
+		Content:    msg.ContentBytes(),
+		Recipients: Recipients(msg.ContentBytes()),
+	}
+}
+
+// Recipients returns the recps of decrypted content, feeds and group ids.
+// A box1 ciphertext doesn't tell who it is for, so this is the only list there is. Entries can be refs or {link} objects.
+// It is empty if content has no recps.
+func Recipients(content []byte) []string {
+	var withRecps struct {
+		Recps []json.RawMessage `json:"recps"`
+	}
+	if err := json.Unmarshal(content, &withRecps); err != nil {
+		return []string{}
+	}
+	recps := make([]string, 0, len(withRecps.Recps))
+	for _, raw := range withRecps.Recps {
+		var ref string
+		if err := json.Unmarshal(raw, &ref); err == nil {
+			recps = append(recps, ref)
+			continue
+		}
+		var link struct {
+			Link string `json:"link"`
+		}
+		if err := json.Unmarshal(raw, &link); err == nil && link.Link != "" {
+			recps = append(recps, link.Link)
+		}
+	}
+	return recps
+}
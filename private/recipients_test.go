@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: MIT
+
+package private
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/margaret"
+
+	"go.cryptoscope.co/ssb"
+)
+
+func TestDecryptedRecipients(t *testing.T) {
+	r := require.New(t)
+
+	alice, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+	bob, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+
+	content, err := json.Marshal(map[string]interface{}{
+		"type": "post",
+		"text": "hi",
+		"recps": []interface{}{
+			alice.Id.Ref(),
+			map[string]string{"link": bob.Id.Ref(), "name": "bob"},
+		},
+	})
+	r.NoError(err)
+
+	boxed, err := Box(content, alice.Id, bob.Id)
+	r.NoError(err)
+	clear, err := Unbox(bob, boxed)
+	r.NoError(err)
+
+	var msg ssb.KeyValueRaw
+	msg.Value.Author = *alice.Id
+	msg.Value.Sequence = margaret.BaseSeq(3)
+	msg.Value.Content = clear
+
+	dec := NewDecrypted(msg)
+	r.True(dec.Author.Equal(alice.Id))
+	r.EqualValues(3, dec.Sequence)
+	r.Equal([]string{alice.Id.Ref(), bob.Id.Ref()}, dec.Recipients)
+	r.JSONEq(string(content), string(dec.Content))
+
+	r.Equal([]string{}, Recipients([]byte(`{"type":"post"}`)))
+	r.Equal([]string{}, Recipients([]byte(`"still.box"`)))
+}
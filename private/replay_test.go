@@ -0,0 +1,139 @@
+// SPDX-License-Identifier: MIT
+
+package private_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/luigi"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/multilogs"
+	"go.cryptoscope.co/ssb/private"
+	"go.cryptoscope.co/ssb/private/box2"
+	"go.cryptoscope.co/ssb/private/keys"
+	"go.cryptoscope.co/ssb/sbot"
+)
+
+func TestReplayNewKeys(t *testing.T) {
+	r := require.New(t)
+
+	srvRepo := filepath.Join("testrun", t.Name())
+	os.RemoveAll(srvRepo)
+
+	alice, err := ssb.NewKeyPair(bytes.NewReader(bytes.Repeat([]byte("alice"), 8)))
+	r.NoError(err)
+
+	start := func(km *keys.Manager) *sbot.Sbot {
+		srvLog := kitlog.NewNopLogger()
+		mlogPriv := multilogs.NewPrivateReadWithKeys(kitlog.With(srvLog, "module", "privLogs"), km)
+		srv, err := sbot.New(
+			sbot.WithKeyPair(alice),
+			sbot.WithInfo(srvLog),
+			sbot.WithRepoPath(srvRepo),
+			sbot.WithListenAddr(":0"),
+			sbot.WithKeyManager(km),
+			sbot.LateOption(sbot.MountMultiLog("privLogs", mlogPriv.OpenRoaring)),
+		)
+		r.NoError(err, "sbot srv init failed")
+		return srv
+	}
+
+	// waits until n messages are readable
+	waitReadable := func(srv *sbot.Sbot, km *keys.Manager, n int) {
+		pl, ok := srv.GetMultiLog("privLogs")
+		r.True(ok)
+		userPrivs, err := pl.Get(alice.Id.StoredAddr())
+		r.NoError(err)
+
+		var got int
+		for try := 0; try < 50; try++ {
+			src, err := private.NewUnboxerLogWithKeys(srv.RootLog, userPrivs, km).Query()
+			r.NoError(err)
+			got = 0
+			for {
+				_, err := src.Next(context.TODO())
+				if luigi.IsEOS(err) {
+					break
+				}
+				r.NoError(err)
+				got++
+			}
+			if got == n {
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		r.Equal(n, got)
+	}
+
+	publishTo := func(srv *sbot.Sbot, key []byte) {
+		_, err := srv.PublishLog.Publish(box2.Content{
+			Plain:      []byte(`{"type":"test","text":"secret"}`),
+			Recipients: []box2.Recipient{{Key: key, Scheme: box2.SchemeLargeSymmetricGroup}},
+		})
+		r.NoError(err)
+	}
+
+	keyOne := bytes.Repeat([]byte{1}, 32)
+	keyTwo := bytes.Repeat([]byte{2}, 32)
+
+	km := keys.NewManager(alice)
+	srv := start(km)
+	publishTo(srv, keyOne)
+	publishTo(srv, keyTwo)
+	srv.WaitUntilIndexesAreSynced()
+	waitReadable(srv, km, 0)
+
+	// adding the key replays the log
+	r.NoError(km.AddGroupKey("%one.cloaked", keyOne))
+	waitReadable(srv, km, 1)
+
+	var state string
+	for try := 0; try < 50 && state != "done"; try++ {
+		st, err := srv.Status()
+		r.NoError(err)
+		for _, idx := range st.Indicies {
+			if strings.HasPrefix(idx.Name, "privates replay ") && strings.HasSuffix(idx.Name, "%one.cloaked") {
+				state = idx.State
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	r.Equal("done", state, "replay not done in the status")
+
+	srv.Shutdown()
+	r.NoError(srv.Close())
+
+	// a job that didn't finish before the shutdown continues on the next start
+	job := []multilogs.ReplayJob{{Feed: alice.Id.Ref(), Group: "%two.cloaked", Next: 0, Until: 1}}
+	data, err := json.Marshal(job)
+	r.NoError(err)
+	r.NoError(ioutil.WriteFile(filepath.Join(srvRepo, "privates-replays.json"), data, 0600))
+
+	km = keys.NewManager(alice)
+	r.NoError(km.AddGroupKey("%one.cloaked", keyOne))
+	r.NoError(km.AddGroupKey("%two.cloaked", keyTwo))
+	srv = start(km)
+	srv.WaitUntilIndexesAreSynced()
+	waitReadable(srv, km, 2)
+
+	// and can be started by hand
+	_, err = srv.ReplayPrivates(alice.Id, "%two.cloaked")
+	r.NoError(err)
+	_, err = srv.ReplayPrivates(alice.Id, "%unknown.cloaked")
+	r.Error(err)
+
+	srv.Shutdown()
+	r.NoError(srv.Close())
+}
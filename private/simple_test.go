@@ -60,3 +60,80 @@ func TestNotForMe(t *testing.T) {
 		r.Nil(out)
 	}
 }
+
+func TestUnboxer(t *testing.T) {
+	r := require.New(t)
+
+	var kps []*ssb.KeyPair
+	for i := 0; i < 3; i++ {
+		kp, err := ssb.NewKeyPair(nil)
+		r.NoError(err)
+		kps = append(kps, kp)
+	}
+	other, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+
+	u := NewUnboxer(kps...)
+	msg := []byte(`{"hello": true}`)
+
+	sbox, err := Box(msg, other.Id, kps[1].Id, kps[2].Id)
+	r.NoError(err)
+	sbox = sbox[5:]
+
+	kp, out, err := u.Open(sbox)
+	r.NoError(err)
+	r.True(kp.Id.Equal(kps[1].Id), "not the first reader")
+	r.Equal(msg, out)
+
+	readers, out, err := u.Readers(sbox)
+	r.NoError(err)
+	r.Len(readers, 2)
+	r.True(readers[0].Id.Equal(kps[1].Id))
+	r.True(readers[1].Id.Equal(kps[2].Id))
+	r.Equal(msg, out)
+
+	sbox, err = Box(msg, other.Id)
+	r.NoError(err)
+	_, _, err = u.Open(sbox[5:])
+	r.Equal(ErrPrivateMessageDecryptFailed, err)
+	_, _, err = u.Readers(sbox[5:])
+	r.Equal(ErrPrivateMessageDecryptFailed, err)
+}
+
+// benchmarkUnbox opens a message that is for none of n keypairs, the common case while indexing
+func benchmarkUnbox(b *testing.B, n int, cached bool) {
+	var kps []*ssb.KeyPair
+	for i := 0; i < n; i++ {
+		kp, err := ssb.NewKeyPair(nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		kps = append(kps, kp)
+	}
+	other, err := ssb.NewKeyPair(nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	sbox, err := Box([]byte(`{"hello": true}`), other.Id)
+	if err != nil {
+		b.Fatal(err)
+	}
+	sbox = sbox[5:]
+
+	u := NewUnboxer(kps...)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if cached {
+			u.Readers(sbox)
+			continue
+		}
+		for _, kp := range kps {
+			Unbox(kp, sbox)
+		}
+	}
+}
+
+func BenchmarkUnbox1(b *testing.B)   { benchmarkUnbox(b, 1, false) }
+func BenchmarkUnbox5(b *testing.B)   { benchmarkUnbox(b, 5, false) }
+func BenchmarkUnboxer1(b *testing.B) { benchmarkUnbox(b, 1, true) }
+func BenchmarkUnboxer5(b *testing.B) { benchmarkUnbox(b, 5, true) }
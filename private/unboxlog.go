@@ -3,9 +3,7 @@
 package private
 
 import (
-	"bytes"
 	"context"
-	"encoding/base64"
 
 	"github.com/cryptix/go/encodedTime"
 
@@ -14,23 +12,46 @@ import (
 	"go.cryptoscope.co/luigi/mfr"
 	"go.cryptoscope.co/margaret"
 	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/private/keys"
 )
 
 type unboxedLog struct {
 	root, seqlog margaret.Log
-	kp           *ssb.KeyPair
+	keys         *keys.Manager
+
+	from *ssb.FeedRef
+}
+
+// FromFilterer is implemented by the unboxer logs.
+// From returns a log of only the messages by author, the others are skipped before they are decrypted.
+type FromFilterer interface {
+	From(author *ssb.FeedRef) margaret.Log
 }
 
+var _ FromFilterer = unboxedLog{}
+
 // NewUnboxerLog expects the sequence numbers, that are returned from seqlog, to be decryptable by kp.
+// Only box1 messages and box2 direct messages can be read with it, see NewUnboxerLogWithKeys for groups.
 func NewUnboxerLog(root, seqlog margaret.Log, kp *ssb.KeyPair) margaret.Log {
+	return NewUnboxerLogWithKeys(root, seqlog, keys.NewManager(kp))
+}
+
+// NewUnboxerLogWithKeys is NewUnboxerLog for the keypair of km, with its group keys for box2 messages.
+func NewUnboxerLogWithKeys(root, seqlog margaret.Log, km *keys.Manager) margaret.Log {
 	il := unboxedLog{
 		root:   root,
 		seqlog: seqlog,
-		kp:     kp,
+		keys:   km,
 	}
 	return il
 }
 
+// From filters the log by author, which is outside of the encrypted content.
+func (il unboxedLog) From(author *ssb.FeedRef) margaret.Log {
+	il.from = author
+	return il
+}
+
 func (il unboxedLog) Seq() luigi.Observable {
 	return il.seqlog.Seq()
 }
@@ -56,7 +77,7 @@ func (il unboxedLog) Query(args ...margaret.QuerySpec) (luigi.Source, error) {
 		return nil, errors.Wrap(err, "unboxLog: error querying seqlog")
 	}
 
-	return mfr.SourceMap(src, func(ctx context.Context, iv interface{}) (interface{}, error) {
+	unboxed := mfr.SourceMap(src, func(ctx context.Context, iv interface{}) (interface{}, error) {
 		var rootSeq margaret.Seq
 		var wrappedSeq margaret.Seq
 		switch tv := iv.(type) {
@@ -86,31 +107,11 @@ func (il unboxedLog) Query(args ...margaret.QuerySpec) (luigi.Source, error) {
 		}
 
 		author := amsg.Author()
-
-		var boxedContent []byte
-		switch author.Algo {
-		case ssb.RefAlgoFeedSSB1:
-			input := amsg.ContentBytes()
-			if !(input[0] == '"' && input[len(input)-1] == '"') {
-				return nil, errors.Errorf("expected json string with quotes")
-			}
-			b64data := bytes.TrimSuffix(input[1:], []byte(".box\""))
-			boxedData := make([]byte, len(b64data))
-
-			n, err := base64.StdEncoding.Decode(boxedData, b64data)
-			if err != nil {
-				return nil, errors.Wrap(err, "decode pm: invalid b64 encoding")
-			}
-			boxedContent = boxedData[:n]
-
-		case ssb.RefAlgoFeedGabby:
-			boxedContent = bytes.TrimPrefix(amsg.ContentBytes(), []byte("box1:"))
-
-		default:
-			return nil, errors.Errorf("decode pm: unknown feed type: %s", author.Algo)
+		if il.from != nil && !author.Equal(il.from) {
+			return skipped{}, nil
 		}
 
-		clearContent, err := Unbox(il.kp, boxedContent)
+		clearContent, err := Open(il.keys, amsg)
 		if err != nil {
 			return nil, errors.Wrap(err, "unboxLog: unbox failed")
 		}
@@ -131,9 +132,19 @@ func (il unboxedLog) Query(args ...margaret.QuerySpec) (luigi.Source, error) {
 		}
 
 		return msg, nil
-	}), nil
+	})
+	if il.from != nil {
+		return mfr.SourceFilter(unboxed, func(_ context.Context, v interface{}) (bool, error) {
+			_, skip := v.(skipped)
+			return !skip, nil
+		}), nil
+	}
+	return unboxed, nil
 }
 
+// skipped stands in for messages that don't pass the filters of the log, before they are dropped
+type skipped struct{}
+
 // Append doesn't work on this log. They need to go through the proper channels.
 func (il unboxedLog) Append(interface{}) (margaret.Seq, error) {
 	return nil, errors.New("can't append to seqloged log, sorry")
@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: MIT
+
+package private_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/luigi"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/multilogs"
+	"go.cryptoscope.co/ssb/private"
+	"go.cryptoscope.co/ssb/repo"
+	"go.cryptoscope.co/ssb/sbot"
+)
+
+func TestUnboxerLogFrom(t *testing.T) {
+	r := require.New(t)
+
+	srvRepo := filepath.Join("testrun", t.Name())
+	os.RemoveAll(srvRepo)
+	tRepo := repo.New(srvRepo)
+
+	alice, err := repo.DefaultKeyPair(tRepo)
+	r.NoError(err)
+	arny, err := repo.NewKeyPair(tRepo, "arny", ssb.RefAlgoFeedSSB1)
+	r.NoError(err)
+	bert, err := repo.NewKeyPair(tRepo, "bert", ssb.RefAlgoFeedSSB1)
+	r.NoError(err)
+
+	srvLog := kitlog.NewNopLogger()
+	mlogPriv := multilogs.NewPrivateRead(kitlog.With(srvLog, "module", "privLogs"), alice)
+
+	srv, err := sbot.New(
+		sbot.WithKeyPair(alice),
+		sbot.WithInfo(srvLog),
+		sbot.WithRepoPath(srvRepo),
+		sbot.DisableNetworkNode(),
+		sbot.LateOption(sbot.MountMultiLog("privLogs", mlogPriv.OpenRoaring)),
+	)
+	r.NoError(err, "sbot srv init failed")
+
+	for i, as := range []string{"arny", "bert", "arny", "bert", "bert"} {
+		boxed, err := private.Box([]byte(`"from `+as+`"`), alice.Id)
+		r.NoError(err)
+		_, err = srv.PublishAs(as, boxed)
+		r.NoError(err, "publish %d failed", i)
+	}
+
+	srv.WaitUntilIndexesAreSynced()
+
+	pl, ok := srv.GetMultiLog("privLogs")
+	r.True(ok)
+	userPrivs, err := pl.Get(alice.Id.StoredAddr())
+	r.NoError(err)
+	unboxed := private.NewUnboxerLog(srv.RootLog, userPrivs, alice)
+
+	count := func(from *ssb.FeedRef) int {
+		ff, ok := unboxed.(private.FromFilterer)
+		r.True(ok, "no author filter on the unboxer log")
+		src, err := ff.From(from).Query()
+		r.NoError(err)
+		var n int
+		for {
+			v, err := src.Next(context.TODO())
+			if luigi.IsEOS(err) {
+				return n
+			}
+			r.NoError(err)
+			msg, ok := v.(ssb.Message)
+			r.True(ok, "wrong type: %T", v)
+			r.True(msg.Author().Equal(from))
+			r.Equal(`"from `+map[string]string{arny.Id.Ref(): "arny", bert.Id.Ref(): "bert"}[from.Ref()]+`"`, string(msg.ContentBytes()))
+			n++
+		}
+	}
+	r.Equal(2, count(arny.Id))
+	r.Equal(3, count(bert.Id))
+	r.Equal(0, count(alice.Id))
+
+	srv.Shutdown()
+	r.NoError(srv.Close())
+}
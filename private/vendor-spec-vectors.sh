@@ -0,0 +1,21 @@
+#! /usr/bin/env bash
+# copies the test vectors of the envelope and private group specs into the testdata of box2 and keys,
+# the vector tests fail without them
+
+set -e
+
+cd "$(dirname "$0")"
+tmp=$(mktemp -d)
+trap 'rm -rf "$tmp"' EXIT
+
+git clone --depth 1 https://github.com/ssbc/envelope-spec.git "$tmp/envelope-spec"
+rm -rf box2/testdata/envelope-spec
+mkdir -p box2/testdata/envelope-spec
+cp -r "$tmp/envelope-spec/vectors" box2/testdata/envelope-spec/
+git -C "$tmp/envelope-spec" rev-parse HEAD > box2/testdata/envelope-spec/COMMIT
+
+git clone --depth 1 https://github.com/ssbc/private-group-spec.git "$tmp/private-group-spec"
+rm -rf keys/testdata/private-group-spec
+mkdir -p keys/testdata/private-group-spec/direct-messages
+cp -r "$tmp/private-group-spec/direct-messages/vectors" keys/testdata/private-group-spec/direct-messages/
+git -C "$tmp/private-group-spec" rev-parse HEAD > keys/testdata/private-group-spec/COMMIT
@@ -291,6 +291,15 @@ func (ref FeedRef) Ref() string {
 	return fmt.Sprintf("@%s.%s", base64.StdEncoding.EncodeToString(ref.ID), ref.Algo)
 }
 
+// URI returns the ref in the ssb: URI form, like ssb:feed/ed25519/<url-safe base64 of the key>
+func (ref FeedRef) URI() string {
+	format := ref.Algo
+	if format == RefAlgoFeedGabby {
+		format = "gabbygrove-v1"
+	}
+	return fmt.Sprintf("ssb:feed/%s/%s", format, base64.URLEncoding.EncodeToString(ref.ID))
+}
+
 func (ref FeedRef) ShortRef() string {
 	return fmt.Sprintf("<@%s.%s>", base64.StdEncoding.EncodeToString(ref.ID[:3]), ref.Algo)
 }
@@ -139,6 +139,17 @@ func TestStorageRef(t *testing.T) {
 	}
 }
 
+func TestFeedRefURI(t *testing.T) {
+	r := require.New(t)
+
+	fr, err := ParseFeedRef("@ye+QM09iPcDJD6YvQYjoQc7sLF/IFhmNbEqgdzQo3lQ=.ed25519")
+	r.NoError(err)
+	r.Equal("ssb:feed/ed25519/ye-QM09iPcDJD6YvQYjoQc7sLF_IFhmNbEqgdzQo3lQ=", fr.URI())
+
+	fr.Algo = RefAlgoFeedGabby
+	r.Equal("ssb:feed/gabbygrove-v1/ye-QM09iPcDJD6YvQYjoQc7sLF_IFhmNbEqgdzQo3lQ=", fr.URI())
+}
+
 func TestParseBranches(t *testing.T) {
 	r := require.New(t)
 
@@ -0,0 +1,143 @@
+// SPDX-License-Identifier: MIT
+
+package repo
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/margaret/offset2"
+
+	"go.cryptoscope.co/ssb/message/multimsg"
+)
+
+// LogBackend opens the storage behind the logs of a repo (see OpenLog).
+type LogBackend interface {
+	// Name is recorded next to the log on disk.
+	// Opening it again with a backend of another name fails with ErrWrongLogBackend.
+	Name() string
+
+	// Open opens or creates the log at pth
+	Open(pth string) (multimsg.AlterableLog, error)
+}
+
+// ErrWrongLogBackend is returned by OpenLog if the log was created with a different backend
+type ErrWrongLogBackend struct {
+	Path     string
+	Recorded string
+	Wanted   string
+}
+
+func (e ErrWrongLogBackend) Error() string {
+	return "repo: log at " + e.Path + " was created with the " + e.Recorded + " backend, not " + e.Wanted
+}
+
+// Option changes how a repo is opened
+type Option func(*repo)
+
+// WithLogBackend sets the backend OpenLog uses. The default is OffsetLog.
+func WithLogBackend(b LogBackend) Option {
+	return func(r *repo) {
+		r.logBackend = b
+	}
+}
+
+// OffsetLog stores logs in offset2 files. It's the default.
+var OffsetLog LogBackend = offsetBackend{}
+
+type offsetBackend struct{}
+
+func (offsetBackend) Name() string { return "offset2" }
+
+func (offsetBackend) Open(pth string) (multimsg.AlterableLog, error) {
+	log, err := offset2.Open(pth, multimsg.MargaretCodec{})
+	if err != nil {
+		return nil, err
+	}
+	return log, nil
+}
+
+// NewMemoryLogBackend returns a backend that keeps logs in memory, mostly useful for tests.
+// Logs survive closing and reopening a repo as long as the same backend is passed to it, but not the process.
+// Only logs are kept in memory, indexes and blobs still go to disk.
+func NewMemoryLogBackend() LogBackend {
+	return &memoryBackend{logs: make(map[string]*memLog)}
+}
+
+type memoryBackend struct {
+	mu   sync.Mutex
+	logs map[string]*memLog
+}
+
+func (*memoryBackend) Name() string { return "memory" }
+
+func (mb *memoryBackend) Open(pth string) (multimsg.AlterableLog, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	l, ok := mb.logs[pth]
+	if !ok {
+		l = newMemLog()
+		mb.logs[pth] = l
+	}
+	return l, nil
+}
+
+// logBackendOf returns the backend r was opened with
+func logBackendOf(r Interface) LogBackend {
+	lb, ok := r.(interface{ LogBackend() LogBackend })
+	if !ok || lb.LogBackend() == nil {
+		return OffsetLog
+	}
+	return lb.LogBackend()
+}
+
+func (r repo) LogBackend() LogBackend { return r.logBackend }
+
+const logBackendFile = "backend"
+
+// checkLogBackend compares the backend with the one recorded in the log directory and records it for new logs.
+// Logs from before backends were recorded are offset2 logs.
+// The memory backend records nothing but refuses to hide a log that is on disk.
+func checkLogBackend(r Interface, pth string, b LogBackend) error {
+	_, inMemory := b.(*memoryBackend)
+
+	marker := filepath.Join(pth, logBackendFile)
+	recorded, err := ioutil.ReadFile(marker)
+	if err == nil {
+		if name := string(bytes.TrimSpace(recorded)); name != b.Name() {
+			return ErrWrongLogBackend{Path: pth, Recorded: name, Wanted: b.Name()}
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to read log backend")
+	}
+
+	if inMemory {
+		if _, err := os.Stat(pth); err == nil {
+			return ErrWrongLogBackend{Path: pth, Recorded: OffsetLog.Name(), Wanted: b.Name()}
+		}
+		return nil
+	}
+
+	if _, err := os.Stat(pth); err == nil {
+		if b.Name() != OffsetLog.Name() {
+			return ErrWrongLogBackend{Path: pth, Recorded: OffsetLog.Name(), Wanted: b.Name()}
+		}
+		if IsReadOnly(r) {
+			return nil
+		}
+	} else if IsReadOnly(r) {
+		return mustExist(pth)
+	}
+
+	if err := os.MkdirAll(pth, 0700); err != nil {
+		return errors.Wrap(err, "failed to create log directory")
+	}
+	err = ioutil.WriteFile(marker, []byte(b.Name()+"\n"), 0600)
+	return errors.Wrap(err, "failed to record log backend")
+}
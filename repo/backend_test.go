@@ -0,0 +1,189 @@
+// SPDX-License-Identifier: MIT
+
+package repo
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/message/legacy"
+	"go.cryptoscope.co/ssb/message/multimsg"
+)
+
+func appendTestMessages(t *testing.T, rl multimsg.AlterableLog, n int) {
+	r := require.New(t)
+
+	kp, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+
+	var prev *ssb.MessageRef
+	for i := 1; i <= n; i++ {
+		lm := legacy.LegacyMessage{
+			Previous:  prev,
+			Author:    kp.Id.Ref(),
+			Sequence:  margaret.BaseSeq(i),
+			Timestamp: int64(i) * 1000,
+			Hash:      "sha256",
+			Content:   map[string]interface{}{"type": "test", "i": i},
+		}
+		ref, raw, err := lm.Sign(kp.Pair.Secret[:], nil)
+		r.NoError(err)
+
+		_, err = rl.Append(&legacy.StoredMessage{
+			Author_:   kp.Id,
+			Previous_: prev,
+			Key_:      ref,
+			Sequence_: margaret.BaseSeq(i),
+			Raw_:      raw,
+		})
+		r.NoError(err)
+		prev = ref
+	}
+}
+
+func TestMemoryLogBackend(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	testPath, err := ioutil.TempDir("", t.Name())
+	r.NoError(err)
+	defer os.RemoveAll(testPath)
+
+	mem := NewMemoryLogBackend()
+	rl, err := OpenLog(New(testPath, WithLogBackend(mem)))
+	r.NoError(err)
+
+	appendTestMessages(t, rl, 5)
+	r.NoError(rl.Null(margaret.BaseSeq(1)))
+	r.NoError(rl.Close())
+
+	_, err = os.Stat(New(testPath).GetPath("log"))
+	r.True(os.IsNotExist(err), "memory backend wrote to disk")
+
+	// reopening with the same backend keeps the messages
+	rl, err = OpenLog(New(testPath, WithLogBackend(mem)))
+	r.NoError(err)
+
+	sv, err := rl.Seq().Value()
+	r.NoError(err)
+	r.EqualValues(4, sv.(margaret.Seq).Seq())
+
+	_, err = rl.Get(margaret.BaseSeq(1))
+	r.True(margaret.IsErrNulled(err))
+
+	v, err := rl.Get(margaret.BaseSeq(2))
+	r.NoError(err)
+	r.EqualValues(3, v.(ssb.Message).Seq())
+
+	src, err := rl.Query(margaret.Gt(margaret.BaseSeq(1)), margaret.Limit(2), margaret.SeqWrap(true))
+	r.NoError(err)
+	var got []int64
+	for {
+		v, err := src.Next(ctx)
+		if luigi.IsEOS(err) {
+			break
+		}
+		r.NoError(err)
+		sw := v.(margaret.SeqWrapper)
+		r.EqualValues(sw.Seq().Seq()+1, sw.Value().(ssb.Message).Seq())
+		got = append(got, sw.Seq().Seq())
+	}
+	r.Equal([]int64{2, 3}, got)
+
+	src, err = rl.Query(margaret.Reverse(true))
+	r.NoError(err)
+	v, err = src.Next(ctx)
+	r.NoError(err)
+	r.EqualValues(5, v.(ssb.Message).Seq())
+
+	// live queries wait for new messages
+	src, err = rl.Query(margaret.Gt(margaret.BaseSeq(4)), margaret.Live(true))
+	r.NoError(err)
+	done := make(chan error, 1)
+	go func() {
+		v, err := src.Next(ctx)
+		if err == nil {
+			if msg, ok := v.(ssb.Message); !ok || msg.Seq() != 1 {
+				err = errors.Errorf("unexpected live value: %v", v)
+			}
+		}
+		done <- err
+	}()
+	appendTestMessages(t, rl, 1)
+	r.NoError(<-done)
+
+	// a fresh backend has nothing
+	rl, err = OpenLog(New(testPath, WithLogBackend(NewMemoryLogBackend())))
+	r.NoError(err)
+	sv, err = rl.Seq().Value()
+	r.NoError(err)
+	r.Equal(margaret.SeqEmpty, sv)
+	r.NoError(rl.Close())
+}
+
+type renamedBackend struct {
+	LogBackend
+	name string
+}
+
+func (rb renamedBackend) Name() string { return rb.name }
+
+func TestWrongLogBackend(t *testing.T) {
+	r := require.New(t)
+
+	testPath, err := ioutil.TempDir("", t.Name())
+	r.NoError(err)
+	defer os.RemoveAll(testPath)
+
+	// logs from before the backend was recorded are offset2
+	rl, err := OffsetLog.Open(New(testPath).GetPath("log"))
+	r.NoError(err)
+	r.NoError(rl.Close())
+
+	other := renamedBackend{LogBackend: OffsetLog, name: "other"}
+	_, err = OpenLog(New(testPath, WithLogBackend(other)))
+	r.Error(err)
+	_, ok := errors.Cause(err).(ErrWrongLogBackend)
+	r.True(ok, "wrong error type: %T", errors.Cause(err))
+
+	rl, err = OpenLog(New(testPath))
+	r.NoError(err)
+	r.NoError(rl.Close())
+
+	// a new log records its backend
+	otherPath, err := ioutil.TempDir("", t.Name())
+	r.NoError(err)
+	defer os.RemoveAll(otherPath)
+
+	rl, err = OpenLog(New(otherPath, WithLogBackend(other)))
+	r.NoError(err)
+	r.NoError(rl.Close())
+
+	_, err = OpenLog(New(otherPath))
+	r.Error(err)
+	wrong, ok := errors.Cause(err).(ErrWrongLogBackend)
+	r.True(ok, "wrong error type: %T", errors.Cause(err))
+	r.Equal("other", wrong.Recorded)
+	r.Equal("offset2", wrong.Wanted)
+
+	// the memory backend doesn't hide logs that are on disk
+	_, err = OpenLog(New(otherPath, WithLogBackend(NewMemoryLogBackend())))
+	r.Error(err)
+	wrong, ok = errors.Cause(err).(ErrWrongLogBackend)
+	r.True(ok, "wrong error type: %T", errors.Cause(err))
+	r.Equal("other", wrong.Recorded)
+
+	_, err = OpenLog(New(testPath, WithLogBackend(NewMemoryLogBackend())))
+	r.Error(err)
+	wrong, ok = errors.Cause(err).(ErrWrongLogBackend)
+	r.True(ok, "wrong error type: %T", errors.Cause(err))
+	r.Equal("offset2", wrong.Recorded)
+}
@@ -0,0 +1,195 @@
+// SPDX-License-Identifier: MIT
+
+package repo
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"reflect"
+
+	"github.com/dgraph-io/badger"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/librarian"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+)
+
+// BatchSinkIndex is a SinkIndex that can apply many messages at once.
+// Either all of the batch is applied, including the sequence the index processed up to, or nothing.
+type BatchSinkIndex interface {
+	librarian.SinkIndex
+
+	PourBatch(ctx context.Context, batch []margaret.SeqWrapper) error
+}
+
+// BadgerUpdateFunc applies the message val with the receive log sequence seq to an index.
+// All writes need to go through txn. Nulled messages are passed as their error value.
+type BadgerUpdateFunc func(txn *badger.Txn, seq margaret.Seq, val interface{}) error
+
+// NewBadgerBatchSink returns a BatchSinkIndex that applies each batch with f inside one transaction on db.
+// The sequence of the last message of the batch is stored under seqKey in the same transaction,
+// so the index never has data for messages that it doesn't know it processed, or the other way around.
+//
+// If seqIdx is not nil, its sequence is set after each batch as well. That one is not atomic with the data
+// and only meant for the users of the librarian index that belongs to the same db.
+// Indexes that were fed message by message before have their sequence only there, it's where they continue from.
+func NewBadgerBatchSink(db *badger.DB, seqKey []byte, seqIdx librarian.SeqSetterIndex, f BadgerUpdateFunc, opts ...BatchSinkOption) BatchSinkIndex {
+	bs := &badgerBatchSink{
+		db:     db,
+		seqKey: seqKey,
+		seqIdx: seqIdx,
+		update: f,
+	}
+	for _, o := range opts {
+		o(bs)
+	}
+	return bs
+}
+
+// BatchSinkOption changes a sink made by NewBadgerBatchSink
+type BatchSinkOption func(*badgerBatchSink)
+
+// AfterBatch sets a function that is called with the messages of each transaction once it was committed,
+// for notifications and caches that must not see data that might still be rolled back.
+func AfterBatch(f func([]margaret.SeqWrapper)) BatchSinkOption {
+	return func(bs *badgerBatchSink) {
+		bs.after = f
+	}
+}
+
+type badgerBatchSink struct {
+	db     *badger.DB
+	seqKey []byte
+	seqIdx librarian.SeqSetterIndex
+	update BadgerUpdateFunc
+	after  func([]margaret.SeqWrapper)
+}
+
+func (bs *badgerBatchSink) Pour(ctx context.Context, v interface{}) error {
+	sw, ok := v.(margaret.SeqWrapper)
+	if !ok {
+		return errors.Errorf("batch index: expected sequence wrapped value, got %T", v)
+	}
+	return bs.PourBatch(ctx, []margaret.SeqWrapper{sw})
+}
+
+func (bs *badgerBatchSink) PourBatch(ctx context.Context, batch []margaret.SeqWrapper) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	err := bs.db.Update(func(txn *badger.Txn) error {
+		for _, sw := range batch {
+			if err := bs.update(txn, sw.Seq(), sw.Value()); err != nil {
+				return err
+			}
+		}
+		var seqBytes [8]byte
+		binary.BigEndian.PutUint64(seqBytes[:], uint64(batch[len(batch)-1].Seq().Seq()))
+		return txn.Set(bs.seqKey, seqBytes[:])
+	})
+	if errors.Cause(err) == badger.ErrTxnTooBig && len(batch) > 1 {
+		// split it up. the halves are still applied in order and each one is atomic.
+		half := len(batch) / 2
+		if err := bs.PourBatch(ctx, batch[:half]); err != nil {
+			return err
+		}
+		return bs.PourBatch(ctx, batch[half:])
+	}
+	if err != nil {
+		return errors.Wrapf(err, "batch index: failed to apply %d messages (up to seq %d)", len(batch), batch[len(batch)-1].Seq().Seq())
+	}
+
+	if bs.after != nil {
+		bs.after(batch)
+	}
+
+	if bs.seqIdx != nil {
+		err = bs.seqIdx.SetSeq(batch[len(batch)-1].Seq())
+		return errors.Wrap(err, "batch index: failed to update the sequence of the index")
+	}
+	return nil
+}
+
+func (bs *badgerBatchSink) QuerySpec() margaret.QuerySpec {
+	seq, err := BadgerIndexedSeq(bs.db, bs.seqKey)
+	if err == nil && seq.Seq() == margaret.SeqEmpty.Seq() && bs.seqIdx != nil {
+		seq, err = bs.seqIdx.GetSeq()
+	}
+	if err != nil {
+		return func(margaret.Query) error { return err }
+	}
+	return margaret.Gt(seq)
+}
+
+func (bs *badgerBatchSink) Close() error { return nil }
+
+// BadgerSetJSON stores v under key the way the badger index of librarian does, so that it can still read it.
+func BadgerSetJSON(txn *badger.Txn, key []byte, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "batch index: failed to encode value")
+	}
+	return txn.Set(key, data)
+}
+
+// NewBadgerReadIndex returns an index of the values that BadgerSetJSON (or librarian's badger index) stored in db.
+// They are decoded into the type of tipe. Unknown addresses have a librarian.UnsetValue.
+//
+// Librarian's index keeps the observables it handed out and only updates them when a value is set through it.
+// This one reads the value on every Get, so it sees what a sink from NewBadgerBatchSink wrote.
+func NewBadgerReadIndex(db *badger.DB, tipe interface{}) librarian.Index {
+	return badgerReadIndex{db: db, tipe: reflect.TypeOf(tipe)}
+}
+
+type badgerReadIndex struct {
+	db   *badger.DB
+	tipe reflect.Type
+}
+
+func (idx badgerReadIndex) Get(_ context.Context, addr librarian.Addr) (luigi.Observable, error) {
+	var v interface{} = librarian.UnsetValue{Addr: addr}
+	err := idx.db.View(func(txn *badger.Txn) error {
+		it, err := txn.Get([]byte(addr))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return it.Value(func(data []byte) error {
+			val := reflect.New(idx.tipe)
+			if err := json.Unmarshal(data, val.Interface()); err != nil {
+				return err
+			}
+			v = val.Elem().Interface()
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "badger index: failed to get %x", addr)
+	}
+	return luigi.NewObservable(v), nil
+}
+
+// BadgerIndexedSeq returns the sequence that a sink from NewBadgerBatchSink stored under seqKey.
+// It's margaret.SeqEmpty if nothing was processed yet.
+func BadgerIndexedSeq(db *badger.DB, seqKey []byte) (margaret.Seq, error) {
+	seq := margaret.SeqEmpty
+	err := db.View(func(txn *badger.Txn) error {
+		it, err := txn.Get(seqKey)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return it.Value(func(v []byte) error {
+			if len(v) != 8 {
+				return errors.Errorf("invalid sequence value %x", v)
+			}
+			seq = margaret.BaseSeq(binary.BigEndian.Uint64(v))
+			return nil
+		})
+	})
+	return seq, errors.Wrap(err, "batch index: failed to get indexed sequence")
+}
@@ -0,0 +1,217 @@
+// SPDX-License-Identifier: MIT
+
+package repo
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/librarian"
+	libbadger "go.cryptoscope.co/librarian/badger"
+	"go.cryptoscope.co/margaret"
+)
+
+var testSeqKey = []byte("test-seq")
+
+func openTestBadger(t testing.TB) (*badger.DB, func()) {
+	dir, err := ioutil.TempDir("", "batchindex")
+	require.NoError(t, err)
+	db, err := badger.Open(badgerOpts(dir))
+	require.NoError(t, err)
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// setSeq stores every value under its sequence
+func setSeq(txn *badger.Txn, seq margaret.Seq, val interface{}) error {
+	if err, ok := val.(error); ok {
+		return err
+	}
+	var k [8]byte
+	binary.BigEndian.PutUint64(k[:], uint64(seq.Seq()))
+	return txn.Set(k[:], []byte(val.(string)))
+}
+
+func makeBatch(from, n int) []margaret.SeqWrapper {
+	batch := make([]margaret.SeqWrapper, n)
+	for i := range batch {
+		seq := from + i
+		batch[i] = margaret.WrapWithSeq(fmt.Sprint("msg", seq), margaret.BaseSeq(seq))
+	}
+	return batch
+}
+
+func countKeys(t testing.TB, db *badger.DB) int {
+	var n int
+	err := db.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			n++
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	return n
+}
+
+type gtRecorder struct {
+	margaret.Query
+	gt margaret.Seq
+}
+
+func (qr *gtRecorder) Gt(s margaret.Seq) error {
+	qr.gt = s
+	return nil
+}
+
+func TestBadgerBatchSink(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	db, cleanup := openTestBadger(t)
+	defer cleanup()
+
+	snk := NewBadgerBatchSink(db, testSeqKey, nil, setSeq)
+	indexedSeq := func() int64 {
+		var qr gtRecorder
+		r.NoError(snk.QuerySpec()(&qr))
+		return qr.gt.Seq()
+	}
+	r.EqualValues(-1, indexedSeq())
+
+	r.NoError(snk.PourBatch(ctx, makeBatch(0, 10)))
+	r.EqualValues(9, indexedSeq())
+	r.Equal(11, countKeys(t, db))
+
+	r.NoError(snk.Pour(ctx, margaret.WrapWithSeq("msg10", margaret.BaseSeq(10))))
+	r.EqualValues(10, indexedSeq())
+
+	// a batch that fails halfway leaves neither data nor a new sequence
+	batch := makeBatch(11, 10)
+	batch[5] = margaret.WrapWithSeq(errors.New("broken"), margaret.BaseSeq(16))
+	r.Error(snk.PourBatch(ctx, batch))
+	r.EqualValues(10, indexedSeq())
+	r.Equal(12, countKeys(t, db))
+}
+
+func TestBadgerBatchSinkSplit(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	db, cleanup := openTestBadger(t)
+	defer cleanup()
+
+	// transactions with more than four messages are too big, wrapped like the update funcs of the indexes do it
+	var (
+		inTxn    = make(map[*badger.Txn]int)
+		attempts int
+	)
+	snk := NewBadgerBatchSink(db, testSeqKey, nil, func(txn *badger.Txn, seq margaret.Seq, val interface{}) error {
+		if inTxn[txn] == 0 {
+			attempts++
+		}
+		inTxn[txn]++
+		if inTxn[txn] > 4 {
+			return errors.Wrapf(badger.ErrTxnTooBig, "test: failed to add %d", seq.Seq())
+		}
+		return setSeq(txn, seq, val)
+	})
+
+	r.NoError(snk.PourBatch(ctx, makeBatch(0, 10)))
+	r.Equal(11, countKeys(t, db), "all messages and the sequence")
+	r.Equal(7, attempts, "10 fails, split into two 5 that fail and are split into 2 and 3 each")
+
+	var qr gtRecorder
+	r.NoError(snk.QuerySpec()(&qr))
+	r.EqualValues(9, qr.gt.Seq())
+}
+
+func TestBadgerReadIndex(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	db, cleanup := openTestBadger(t)
+	defer cleanup()
+
+	// an index that was fed message by message continues where it left off
+	libIdx := libbadger.NewIndex(db, margaret.BaseSeq(0))
+	r.NoError(libIdx.Set(ctx, librarian.Addr("old"), margaret.BaseSeq(1)))
+	r.NoError(libIdx.SetSeq(margaret.BaseSeq(1)))
+
+	snk := NewBadgerBatchSink(db, testSeqKey, libIdx, func(txn *badger.Txn, seq margaret.Seq, val interface{}) error {
+		return BadgerSetJSON(txn, []byte(val.(string)), seq.Seq())
+	})
+	var qr gtRecorder
+	r.NoError(snk.QuerySpec()(&qr))
+	r.EqualValues(1, qr.gt.Seq())
+
+	idx := NewBadgerReadIndex(db, margaret.BaseSeq(0))
+	obv, err := idx.Get(ctx, librarian.Addr("new"))
+	r.NoError(err)
+	v, err := obv.Value()
+	r.NoError(err)
+	r.IsType(librarian.UnsetValue{}, v)
+
+	r.NoError(snk.PourBatch(ctx, []margaret.SeqWrapper{margaret.WrapWithSeq("new", margaret.BaseSeq(2))}))
+	for addr, want := range map[string]int64{"old": 1, "new": 2} {
+		obv, err := idx.Get(ctx, librarian.Addr(addr))
+		r.NoError(err)
+		v, err := obv.Value()
+		r.NoError(err)
+		r.Equal(margaret.BaseSeq(want), v, addr)
+	}
+}
+
+// BenchmarkIndexSync compares how indexes were updated before, with a librarian sink index that sets each value
+// through librarian's badger index, with batch sinks of a few sizes. batch1 is one transaction per message.
+func BenchmarkIndexSync(b *testing.B) {
+	const msgCount = 2048
+	ctx := context.Background()
+
+	b.Run("librarian", func(b *testing.B) {
+		db, cleanup := openTestBadger(b)
+		defer cleanup()
+		idx := libbadger.NewIndex(db, "")
+		snk := librarian.NewSinkIndex(func(ctx context.Context, seq margaret.Seq, val interface{}, idx librarian.SetterIndex) error {
+			var k [8]byte
+			binary.BigEndian.PutUint64(k[:], uint64(seq.Seq()))
+			return idx.Set(ctx, librarian.Addr(k[:]), val)
+		}, idx)
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for _, sw := range makeBatch(i*msgCount, msgCount) {
+				if err := snk.Pour(ctx, sw); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+
+	for _, size := range []int{1, 32, 512} {
+		b.Run(fmt.Sprintf("batch%d", size), func(b *testing.B) {
+			db, cleanup := openTestBadger(b)
+			defer cleanup()
+			snk := NewBadgerBatchSink(db, testSeqKey, nil, setSeq)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for from := 0; from < msgCount; from += size {
+					if err := snk.PourBatch(ctx, makeBatch(i*msgCount+from, size)); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
@@ -4,10 +4,10 @@ package repo
 
 import (
 	"github.com/pkg/errors"
-	"go.cryptoscope.co/margaret/offset2"
 	"go.cryptoscope.co/ssb/message/multimsg"
 )
 
+// OpenLog opens the log at path with the backend the repo was opened with (offset2 by default).
 func OpenLog(r Interface, path ...string) (multimsg.AlterableLog, error) {
 	// prefix path with "logs" if path is not empty, otherwise use "log"
 	path = append([]string{"log"}, path...)
@@ -15,7 +15,21 @@ func OpenLog(r Interface, path ...string) (multimsg.AlterableLog, error) {
 		path[0] = "logs"
 	}
 
+	pth := r.GetPath(path...)
+	backend := logBackendOf(r)
+	if err := checkLogBackend(r, pth, backend); err != nil {
+		return nil, errors.Wrap(err, "failed to open log")
+	}
+
 	// TODO use proper log message type here
-	log, err := offset2.Open(r.GetPath(path...), multimsg.MargaretCodec{})
-	return multimsg.NewWrappedLog(log), errors.Wrap(err, "failed to open log")
+	log, err := backend.Open(pth)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open log")
+	}
+
+	if IsReadOnly(r) {
+		// offset2 doesn't take file locks, opening it next to a running bot is fine as long as nobody else writes to it
+		return readOnlyLog{multimsg.NewWrappedLog(log)}, nil
+	}
+	return multimsg.NewWrappedLog(log), nil
 }
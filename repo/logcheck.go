@@ -0,0 +1,295 @@
+// SPDX-License-Identifier: MIT
+
+package repo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"go.cryptoscope.co/ssb/message/multimsg"
+)
+
+// maxTornEntries is how many entries RepairLogTail drops at most.
+// More than that is not a torn write but a broken log, which needs a human.
+const maxTornEntries = 16
+
+// LogRepair describes what RepairLogTail changed
+type LogRepair struct {
+	// Entries is the number of entries in the log after the repair
+	Entries int64
+
+	DroppedEntries int64
+	DroppedBytes   int64
+
+	// Backup is the file that holds the dropped bytes of the data file
+	Backup string
+
+	Reasons []string
+}
+
+// Repaired is true if the log was changed
+func (lr LogRepair) Repaired() bool {
+	return lr.DroppedEntries > 0 || lr.DroppedBytes > 0 || len(lr.Reasons) > 0
+}
+
+// RepairLogTail checks the end of the offset2 root log of r and cuts off a record that was only partially written,
+// for instance because the process was killed in the middle of an append.
+//
+// The offset2 files are:
+//
+//	data: frames of an int64 (big endian) length followed by the encoded entry
+//	ofst: one int64 per entry, the position of its frame in data
+//	jrnl: the int64 sequence of the last entry
+//
+// The last entry needs a complete frame that decodes and, for legacy messages, hashes to its key.
+// Nulled entries (all zeros) are fine. Bytes after the last frame are dropped as well.
+// Everything that is dropped from the data file is copied to a file in log-repairs/ first.
+//
+// It does nothing for read-only repos, other log backends and logs that don't exist yet.
+func RepairLogTail(r Interface) (*LogRepair, error) {
+	var rep LogRepair
+	if IsReadOnly(r) || logBackendOf(r).Name() != OffsetLog.Name() {
+		return &rep, nil
+	}
+	logPath := r.GetPath("log")
+	if _, err := os.Stat(logPath); os.IsNotExist(err) {
+		return &rep, nil
+	}
+
+	open := func(name string) (*os.File, error) {
+		f, err := os.OpenFile(filepath.Join(logPath, name), os.O_RDWR|os.O_CREATE, 0600)
+		return f, errors.Wrapf(err, "logcheck: failed to open %s", name)
+	}
+	data, err := open("data")
+	if err != nil {
+		return nil, err
+	}
+	defer data.Close()
+	ofst, err := open("ofst")
+	if err != nil {
+		return nil, err
+	}
+	defer ofst.Close()
+	jrnl, err := open("jrnl")
+	if err != nil {
+		return nil, err
+	}
+	defer jrnl.Close()
+
+	ofstInfo, err := ofst.Stat()
+	if err != nil {
+		return nil, errors.Wrap(err, "logcheck: failed to stat offsets")
+	}
+	dataInfo, err := data.Stat()
+	if err != nil {
+		return nil, errors.Wrap(err, "logcheck: failed to stat data")
+	}
+	dataSize := dataInfo.Size()
+
+	entries := ofstInfo.Size() / 8
+	if ofstInfo.Size()%8 != 0 {
+		rep.Reasons = append(rep.Reasons, fmt.Sprintf("offset file has a partial entry (%d bytes)", ofstInfo.Size()%8))
+	}
+
+	// walk back from the end until an entry checks out
+	var validEnd int64
+	for entries > 0 {
+		if rep.DroppedEntries >= maxTornEntries {
+			return nil, errors.Errorf("logcheck: the last %d entries are broken, not repairing that automatically (%s)", maxTornEntries, strings.Join(rep.Reasons, "; "))
+		}
+
+		frameStart, err := readInt64(ofst, (entries-1)*8)
+		if err != nil {
+			return nil, errors.Wrapf(err, "logcheck: failed to read offset of entry %d", entries-1)
+		}
+
+		end, reason := checkFrame(data, dataSize, frameStart)
+		if reason == "" {
+			validEnd = end
+			break
+		}
+		rep.Reasons = append(rep.Reasons, fmt.Sprintf("entry %d: %s", entries-1, reason))
+		rep.DroppedEntries++
+		entries--
+		if frameStart >= 0 && frameStart <= dataSize {
+			validEnd = frameStart
+		}
+	}
+	if entries == 0 {
+		validEnd = 0
+	}
+	rep.Entries = entries
+
+	if dataSize > validEnd {
+		rep.DroppedBytes = dataSize - validEnd
+		rep.Backup, err = backupTail(r, data, validEnd, dataSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !rep.Repaired() {
+		return &rep, nil
+	}
+
+	if err := data.Truncate(validEnd); err != nil {
+		return nil, errors.Wrap(err, "logcheck: failed to truncate data")
+	}
+	if err := ofst.Truncate(entries * 8); err != nil {
+		return nil, errors.Wrap(err, "logcheck: failed to truncate offsets")
+	}
+	if err := writeInt64(jrnl, entries-1); err != nil {
+		return nil, errors.Wrap(err, "logcheck: failed to update journal")
+	}
+	for _, f := range []*os.File{data, ofst, jrnl} {
+		if err := f.Sync(); err != nil {
+			return nil, errors.Wrapf(err, "logcheck: failed to sync %s", f.Name())
+		}
+	}
+	return &rep, nil
+}
+
+// checkFrame returns the end of the frame at start or why it is broken
+func checkFrame(data io.ReaderAt, dataSize, start int64) (int64, string) {
+	if start < 0 || start+8 > dataSize {
+		return 0, fmt.Sprintf("frame at %d is past the end of the data (%d bytes)", start, dataSize)
+	}
+	size, err := readInt64(data, start)
+	if err != nil {
+		return 0, err.Error()
+	}
+	end := start + 8 + size
+	if size < 0 || end > dataSize {
+		return 0, fmt.Sprintf("frame at %d claims %d bytes but only %d are there", start, size, dataSize-start-8)
+	}
+
+	frame := make([]byte, size)
+	if _, err := data.ReadAt(frame, start+8); err != nil {
+		return 0, fmt.Sprintf("failed to read frame at %d: %s", start, err)
+	}
+	if bytes.Count(frame, []byte{0}) == len(frame) {
+		// nulled
+		return end, ""
+	}
+
+	v, err := multimsg.MargaretCodec{}.Unmarshal(frame)
+	if err != nil {
+		return 0, fmt.Sprintf("frame at %d doesn't decode: %s", start, err)
+	}
+	mm, ok := v.(*multimsg.MultiMessage)
+	if !ok {
+		return 0, fmt.Sprintf("frame at %d decoded to %T", start, v)
+	}
+	if err := checkKey(mm); err != nil {
+		return 0, fmt.Sprintf("frame at %d: %s", start, err)
+	}
+	return end, ""
+}
+
+func backupTail(r Interface, data io.ReaderAt, from, to int64) (string, error) {
+	dir := r.GetPath("log-repairs")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", errors.Wrap(err, "logcheck: failed to create backup directory")
+	}
+	name := filepath.Join(dir, fmt.Sprintf("data-%d-%d.bin", time.Now().Unix(), from))
+	f, err := os.Create(name)
+	if err != nil {
+		return "", errors.Wrap(err, "logcheck: failed to create backup")
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, io.NewSectionReader(data, from, to-from)); err != nil {
+		return "", errors.Wrap(err, "logcheck: failed to write backup")
+	}
+	return name, errors.Wrap(f.Sync(), "logcheck: failed to sync backup")
+}
+
+func readInt64(r io.ReaderAt, at int64) (int64, error) {
+	var buf [8]byte
+	if _, err := r.ReadAt(buf[:], at); err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(buf[:])), nil
+}
+
+func writeInt64(w io.WriterAt, v int64) error {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(v))
+	_, err := w.WriteAt(buf[:], 0)
+	return err
+}
+
+const rebuildIndexesFile = "rebuild-indexes"
+
+// ScheduleIndexRebuild marks the index name to be dropped by DropScheduledIndexes on the next start,
+// for instance because it is ahead of the log.
+func ScheduleIndexRebuild(r Interface, name string) error {
+	f, err := os.OpenFile(r.GetPath(rebuildIndexesFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to schedule index rebuild")
+	}
+	defer f.Close()
+	_, err = fmt.Fprintln(f, name)
+	return errors.Wrap(err, "failed to schedule index rebuild")
+}
+
+// DropScheduledIndexes removes the indexes marked by ScheduleIndexRebuild.
+// It has to be called before they are opened, they are rebuilt from the log afterwards.
+func DropScheduledIndexes(r Interface) ([]string, error) {
+	marker := r.GetPath(rebuildIndexesFile)
+	content, err := ioutil.ReadFile(marker)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to read scheduled index rebuilds")
+	}
+
+	var dropped []string
+	seen := make(map[string]struct{})
+	s := bufio.NewScanner(bytes.NewReader(content))
+	for s.Scan() {
+		name := strings.TrimSpace(s.Text())
+		if _, done := seen[name]; done || name == "" || strings.ContainsAny(name, `/\`) || name == ".." {
+			continue
+		}
+		seen[name] = struct{}{}
+
+		if err := removeIndex(r, name); err != nil {
+			return dropped, err
+		}
+		dropped = append(dropped, name)
+	}
+	return dropped, errors.Wrap(os.Remove(marker), "failed to remove scheduled index rebuilds")
+}
+
+// DropIndex removes the index name while the repo is in use, so that it can be opened again empty and rebuilt from the log.
+// The badger databases of it that r opened are closed first, the sink and multilog of the index have to be closed by the caller.
+func DropIndex(r Interface, name string) error {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == ".." {
+		return errors.Errorf("invalid index name %q", name)
+	}
+	if h := handlesOf(r); h != nil {
+		if err := h.close(PrefixMultiLog+"/"+name+"/", PrefixIndex+"/"+name+"/"); err != nil {
+			return errors.Wrapf(err, "failed to close index %s", name)
+		}
+	}
+	return removeIndex(r, name)
+}
+
+func removeIndex(r Interface, name string) error {
+	for _, pth := range []string{r.GetPath(PrefixMultiLog, name), r.GetPath(PrefixIndex, name)} {
+		if err := os.RemoveAll(pth); err != nil {
+			return errors.Wrapf(err, "failed to drop index %s", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+// SPDX-License-Identifier: MIT
+
+package repo
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/librarian"
+	"go.cryptoscope.co/margaret"
+)
+
+func TestRepairLogTail(t *testing.T) {
+	r := require.New(t)
+
+	testPath, err := ioutil.TempDir("", t.Name())
+	r.NoError(err)
+	defer os.RemoveAll(testPath)
+	tr := New(testPath)
+
+	rep, err := RepairLogTail(tr)
+	r.NoError(err)
+	r.False(rep.Repaired(), "no log yet")
+
+	rl, err := OpenLog(tr)
+	r.NoError(err)
+	appendTestMessages(t, rl, 3)
+	r.NoError(rl.Close())
+
+	rep, err = RepairLogTail(tr)
+	r.NoError(err)
+	r.False(rep.Repaired(), "clean log: %v", rep.Reasons)
+	r.EqualValues(3, rep.Entries)
+
+	dataPath := tr.GetPath("log", "data")
+	ofstPath := tr.GetPath("log", "ofst")
+	dataInfo, err := os.Stat(dataPath)
+	r.NoError(err)
+
+	// a frame that was only partially written and already has its offset
+	appendFile := func(name string, data []byte) {
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0600)
+		r.NoError(err)
+		_, err = f.Write(data)
+		r.NoError(err)
+		r.NoError(f.Close())
+	}
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], 100)
+	appendFile(dataPath, append(buf[:], []byte(`{"torn`)...))
+	binary.BigEndian.PutUint64(buf[:], uint64(dataInfo.Size()))
+	appendFile(ofstPath, buf[:])
+	// and half of the next offset
+	appendFile(ofstPath, buf[:3])
+
+	rep, err = RepairLogTail(tr)
+	r.NoError(err)
+	r.True(rep.Repaired())
+	r.EqualValues(3, rep.Entries)
+	r.EqualValues(1, rep.DroppedEntries)
+	r.EqualValues(8+6, rep.DroppedBytes)
+	r.Len(rep.Reasons, 2)
+
+	backup, err := ioutil.ReadFile(rep.Backup)
+	r.NoError(err)
+	r.Len(backup, 8+6)
+	r.Equal(filepath.Join(testPath, "log-repairs"), filepath.Dir(rep.Backup))
+
+	rl, err = OpenLog(tr)
+	r.NoError(err)
+	sv, err := rl.Seq().Value()
+	r.NoError(err)
+	r.EqualValues(2, sv.(margaret.Seq).Seq())
+	appendTestMessages(t, rl, 1)
+	r.NoError(rl.Close())
+
+	rep, err = RepairLogTail(tr)
+	r.NoError(err)
+	r.False(rep.Repaired(), "after repair: %v", rep.Reasons)
+	r.EqualValues(4, rep.Entries)
+}
+
+func TestScheduleIndexRebuild(t *testing.T) {
+	r := require.New(t)
+
+	testPath, err := ioutil.TempDir("", t.Name())
+	r.NoError(err)
+	defer os.RemoveAll(testPath)
+	tr := New(testPath)
+
+	for _, pth := range []string{tr.GetPath(PrefixMultiLog, "userFeeds", "roaring"), tr.GetPath(PrefixIndex, "contacts", "db"), tr.GetPath(PrefixIndex, "other")} {
+		r.NoError(os.MkdirAll(pth, 0700))
+	}
+
+	r.NoError(ScheduleIndexRebuild(tr, "userFeeds"))
+	r.NoError(ScheduleIndexRebuild(tr, "contacts"))
+	r.NoError(ScheduleIndexRebuild(tr, "contacts"))
+
+	dropped, err := DropScheduledIndexes(tr)
+	r.NoError(err)
+	r.Equal([]string{"userFeeds", "contacts"}, dropped)
+
+	_, err = os.Stat(tr.GetPath(PrefixMultiLog, "userFeeds"))
+	r.True(os.IsNotExist(err))
+	_, err = os.Stat(tr.GetPath(PrefixIndex, "contacts"))
+	r.True(os.IsNotExist(err))
+	_, err = os.Stat(tr.GetPath(PrefixIndex, "other"))
+	r.NoError(err)
+
+	dropped, err = DropScheduledIndexes(tr)
+	r.NoError(err)
+	r.Empty(dropped)
+}
+
+func TestDropIndex(t *testing.T) {
+	r := require.New(t)
+
+	testPath, err := ioutil.TempDir("", t.Name())
+	r.NoError(err)
+	defer os.RemoveAll(testPath)
+	h := NewHandles()
+	tr := New(testPath, WithHandles(h))
+
+	noIdx := func(*badger.DB) (librarian.SeqSetterIndex, librarian.SinkIndex) { return nil, nil }
+	db, _, _, err := OpenBadgerIndex(tr, "contacts", noIdx)
+	r.NoError(err)
+	r.NoError(db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte("k"), []byte("v"))
+	}))
+
+	r.NoError(DropIndex(tr, "contacts"))
+	r.Empty(h.list())
+
+	// opened again while the old one would still hold the lock
+	db, _, _, err = OpenBadgerIndex(tr, "contacts", noIdx)
+	r.NoError(err)
+	defer db.Close()
+	r.NoError(db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get([]byte("k"))
+		r.Equal(badger.ErrKeyNotFound, err)
+		return nil
+	}))
+
+	r.Error(DropIndex(tr, "../contacts"))
+}
@@ -0,0 +1,242 @@
+// SPDX-License-Identifier: MIT
+
+package repo
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+
+	"go.cryptoscope.co/ssb/message/multimsg"
+)
+
+// memLog is an in-memory multimsg.AlterableLog.
+// Entries are kept encoded with the same codec offset2 uses, so values come out of it exactly like they would from disk.
+type memLog struct {
+	codec multimsg.MargaretCodec
+
+	mu      sync.Mutex
+	entries [][]byte // nil entries are nulled
+	appends chan struct{}
+
+	// serializes appends up to setting seq, so that observers see the sequences in order.
+	// Separate from mu so that they can read the log while they are called.
+	appendMu sync.Mutex
+
+	seq luigi.Observable
+}
+
+func newMemLog() *memLog {
+	return &memLog{
+		appends: make(chan struct{}),
+		seq:     luigi.NewObservable(margaret.SeqEmpty),
+	}
+}
+
+func (ml *memLog) Seq() luigi.Observable { return ml.seq }
+
+func (ml *memLog) Get(s margaret.Seq) (interface{}, error) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	return ml.get(s.Seq())
+}
+
+func (ml *memLog) get(seq int64) (interface{}, error) {
+	if seq < 0 || seq >= int64(len(ml.entries)) {
+		return nil, luigi.EOS{}
+	}
+	data := ml.entries[seq]
+	if data == nil {
+		return nil, margaret.ErrNulled
+	}
+	return ml.codec.Unmarshal(data)
+}
+
+func (ml *memLog) Append(v interface{}) (margaret.Seq, error) {
+	data, err := ml.codec.Marshal(v)
+	if err != nil {
+		return margaret.SeqEmpty, errors.Wrap(err, "memlog: failed to encode entry")
+	}
+
+	ml.appendMu.Lock()
+	defer ml.appendMu.Unlock()
+
+	ml.mu.Lock()
+	ml.entries = append(ml.entries, data)
+	seq := margaret.BaseSeq(len(ml.entries) - 1)
+	close(ml.appends)
+	ml.appends = make(chan struct{})
+	ml.mu.Unlock()
+
+	return seq, ml.seq.Set(seq)
+}
+
+func (ml *memLog) Null(s margaret.Seq) error {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	seq := s.Seq()
+	if seq < 0 || seq >= int64(len(ml.entries)) {
+		return errors.Errorf("memlog: can't null %d, out of bounds", seq)
+	}
+	ml.entries[seq] = nil
+	return nil
+}
+
+func (ml *memLog) Replace(s margaret.Seq, data []byte) error {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	seq := s.Seq()
+	if seq < 0 || seq >= int64(len(ml.entries)) {
+		return errors.Errorf("memlog: can't replace %d, out of bounds", seq)
+	}
+	if ml.entries[seq] == nil {
+		return margaret.ErrNulled
+	}
+	ml.entries[seq] = append([]byte(nil), data...)
+	return nil
+}
+
+// Close doesn't drop the entries, the backend hands the same log out again when the repo is reopened
+func (ml *memLog) Close() error { return nil }
+
+func (ml *memLog) Query(specs ...margaret.QuerySpec) (luigi.Source, error) {
+	qry := &memQuery{
+		log:   ml,
+		lt:    -1,
+		limit: -1,
+	}
+	for _, spec := range specs {
+		if err := spec(qry); err != nil {
+			return nil, err
+		}
+	}
+	if qry.reverse && qry.live {
+		return nil, errors.Errorf("memlog: reverse and live queries are not supported together")
+	}
+	if qry.reverse {
+		ml.mu.Lock()
+		qry.nextSeq = int64(len(ml.entries)) - 1
+		ml.mu.Unlock()
+		if qry.lt >= 0 && qry.lt-1 < qry.nextSeq {
+			qry.nextSeq = qry.lt - 1
+		}
+	}
+	return qry, nil
+}
+
+// memQuery implements margaret.Query and luigi.Source
+type memQuery struct {
+	log *memLog
+
+	nextSeq int64
+	gte     int64
+	lt      int64 // -1 means unbounded
+	limit   int   // -1 means unbounded
+
+	live    bool
+	seqWrap bool
+	reverse bool
+}
+
+func (qry *memQuery) Gt(s margaret.Seq) error {
+	return qry.Gte(margaret.BaseSeq(s.Seq() + 1))
+}
+
+func (qry *memQuery) Gte(s margaret.Seq) error {
+	if qry.gte > 0 {
+		return errors.Errorf("memlog: lower bound already set")
+	}
+	qry.gte = s.Seq()
+	qry.nextSeq = s.Seq()
+	return nil
+}
+
+func (qry *memQuery) Lt(s margaret.Seq) error {
+	if qry.lt >= 0 {
+		return errors.Errorf("memlog: upper bound already set")
+	}
+	qry.lt = s.Seq()
+	return nil
+}
+
+func (qry *memQuery) Lte(s margaret.Seq) error {
+	return qry.Lt(margaret.BaseSeq(s.Seq() + 1))
+}
+
+func (qry *memQuery) Limit(n int) error {
+	qry.limit = n
+	return nil
+}
+
+func (qry *memQuery) Live(live bool) error {
+	qry.live = live
+	return nil
+}
+
+func (qry *memQuery) SeqWrap(wrap bool) error {
+	qry.seqWrap = wrap
+	return nil
+}
+
+func (qry *memQuery) Reverse(yes bool) error {
+	qry.reverse = yes
+	return nil
+}
+
+func (qry *memQuery) Next(ctx context.Context) (interface{}, error) {
+	if qry.limit == 0 {
+		return nil, luigi.EOS{}
+	}
+
+	if qry.reverse {
+		if qry.nextSeq < qry.gte {
+			return nil, luigi.EOS{}
+		}
+	} else if qry.lt >= 0 && qry.nextSeq >= qry.lt {
+		return nil, luigi.EOS{}
+	}
+
+	qry.log.mu.Lock()
+	for qry.nextSeq >= int64(len(qry.log.entries)) {
+		if !qry.live {
+			qry.log.mu.Unlock()
+			return nil, luigi.EOS{}
+		}
+		appended := qry.log.appends
+		qry.log.mu.Unlock()
+
+		select {
+		case <-appended:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		qry.log.mu.Lock()
+	}
+	seq := qry.nextSeq
+	v, err := qry.log.get(seq)
+	qry.log.mu.Unlock()
+
+	if qry.reverse {
+		qry.nextSeq--
+	} else {
+		qry.nextSeq++
+	}
+	if qry.limit > 0 {
+		qry.limit--
+	}
+
+	if err != nil {
+		if !margaret.IsErrNulled(err) {
+			return nil, err
+		}
+		// like offset2, nulled entries are passed on as values
+		v = err
+	}
+	if qry.seqWrap {
+		return margaret.WrapWithSeq(v, margaret.BaseSeq(seq)), nil
+	}
+	return v, nil
+}
@@ -0,0 +1,181 @@
+// SPDX-License-Identifier: MIT
+
+package repo
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/librarian"
+	libmkv "go.cryptoscope.co/librarian/mkv"
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/margaret/multilog"
+	"modernc.org/kv"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/message/multimsg"
+)
+
+// ErrReadOnly is returned by operations that would change a repo which was opened with NewReadOnly
+var ErrReadOnly = errors.New("repo: opened read-only")
+
+// NewReadOnly is like New but the stores that are opened through it don't change anything on disk.
+// Logs and blob stores refuse writes with ErrReadOnly, so it can be used next to a running bot (backups, analytics and such).
+// Multilogs and mkv indexes are read from a temporary copy of their store which is removed again when they (or the sink of the index) are closed.
+//
+// The offset2 log doesn't use file locks so it can always be opened this way.
+// Badger holds an exclusive lock on its directory while it's opened for writing, reading a badger index of a running bot fails.
+func NewReadOnly(basePath string, opts ...Option) Interface {
+	r := repo{basePath: basePath, readOnly: true}
+	for _, o := range opts {
+		o(&r)
+	}
+	return r
+}
+
+func (r repo) ReadOnly() bool { return r.readOnly }
+
+// IsReadOnly returns true if r was created with NewReadOnly
+func IsReadOnly(r Interface) bool {
+	ro, ok := r.(interface{ ReadOnly() bool })
+	return ok && ro.ReadOnly()
+}
+
+// mustExist is used in read-only mode instead of creating missing directories
+func mustExist(pth string) error {
+	_, err := os.Stat(pth)
+	if os.IsNotExist(err) {
+		return errors.Wrapf(ErrReadOnly, "%s doesn't exist", pth)
+	}
+	return err
+}
+
+type readOnlyLog struct {
+	multimsg.AlterableLog
+}
+
+func (readOnlyLog) Append(interface{}) (margaret.Seq, error) {
+	return margaret.SeqEmpty, ErrReadOnly
+}
+
+func (readOnlyLog) Null(margaret.Seq) error { return ErrReadOnly }
+
+func (readOnlyLog) Replace(margaret.Seq, []byte) error { return ErrReadOnly }
+
+type readOnlyBlobStore struct {
+	ssb.BlobStore
+}
+
+func (readOnlyBlobStore) Put(io.Reader) (*ssb.BlobRef, error) { return nil, ErrReadOnly }
+
+func (readOnlyBlobStore) PutExpected(io.Reader, *ssb.BlobRef) error { return ErrReadOnly }
+
+func (readOnlyBlobStore) Delete(*ssb.BlobRef) error { return ErrReadOnly }
+
+// copyUnlocked copies the files of the mkv directory src to a new temporary directory, leaving out the lock files.
+// margaret's roaring multilog opens its mkv store with the default locker, a read-only repo opens such a copy instead.
+func copyUnlocked(src string) (string, error) {
+	dst, err := ioutil.TempDir("", "ssb-readonly-mkv")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create copy directory")
+	}
+	err = filepath.Walk(src, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, pth)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0700)
+		}
+		if isLockFile(info) {
+			return nil
+		}
+		return copyFile(target, pth)
+	})
+	if err != nil {
+		os.RemoveAll(dst)
+		return "", errors.Wrapf(err, "failed to copy %s", src)
+	}
+	return dst, nil
+}
+
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// openIndexCopy opens a copy of the mkv index at pth, see copyUnlocked.
+// Opening the index of a running bot itself could recover its WAL or write to it behind the back of that process.
+// The copy is removed when the returned sink is closed.
+func openIndexCopy(pth string, f func(librarian.SeqSetterIndex) librarian.SinkIndex) (librarian.Index, librarian.SinkIndex, error) {
+	if err := mustExist(filepath.Join(pth, "idx.mkv")); err != nil {
+		return nil, nil, err
+	}
+	dir, err := copyUnlocked(pth)
+	if err != nil {
+		return nil, nil, err
+	}
+	db, err := kv.Open(filepath.Join(dir, "idx.mkv"), &kv.Options{})
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, errors.Wrap(err, "openIndex: failed to open MKV database read-only")
+	}
+	idx := libmkv.NewIndex(db, margaret.BaseSeq(0))
+	return idx, copiedSinkIndex{SinkIndex: f(idx), db: db, dir: dir}, nil
+}
+
+// copiedSinkIndex closes and removes the copy made by openIndexCopy when it's closed
+type copiedSinkIndex struct {
+	librarian.SinkIndex
+
+	db  *kv.DB
+	dir string
+}
+
+func (snk copiedSinkIndex) Close() error {
+	var err error
+	if snk.SinkIndex != nil {
+		err = snk.SinkIndex.Close()
+	}
+	if cErr := snk.db.Close(); cErr != nil && err == nil {
+		err = cErr
+	}
+	if rmErr := os.RemoveAll(snk.dir); rmErr != nil && err == nil {
+		err = rmErr
+	}
+	return err
+}
+
+// copiedMultiLog removes the copy made by copyUnlocked when it's closed
+type copiedMultiLog struct {
+	multilog.MultiLog
+
+	dir string
+}
+
+func (ml copiedMultiLog) Close() error {
+	err := ml.MultiLog.Close()
+	if rmErr := os.RemoveAll(ml.dir); rmErr != nil && err == nil {
+		err = rmErr
+	}
+	return err
+}
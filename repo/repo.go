@@ -26,12 +26,19 @@ import (
 var _ Interface = repo{}
 
 // New creates a new repository value, it opens the keypair and database from basePath if it is already existing
-func New(basePath string) Interface {
-	return repo{basePath: basePath}
+func New(basePath string, opts ...Option) Interface {
+	r := repo{basePath: basePath}
+	for _, o := range opts {
+		o(&r)
+	}
+	return r
 }
 
 type repo struct {
-	basePath string
+	basePath   string
+	readOnly   bool
+	logBackend LogBackend
+	handles    *Handles // optional, for Snapshot
 }
 
 func (r repo) GetPath(rel ...string) string {
@@ -47,26 +54,25 @@ const PrefixMultiLog = "sublogs"
 func OpenBadgerMultiLog(r Interface, name string, f multilog.Func) (multilog.MultiLog, librarian.SinkIndex, error) {
 
 	dbPath := r.GetPath(PrefixMultiLog, name, "db")
-	err := os.MkdirAll(dbPath, 0700)
+	err := makeDir(r, dbPath)
 	if err != nil {
-		return nil, nil, errors.Wrapf(err, "mkdir error for %q", dbPath)
+		return nil, nil, err
 	}
 
-	db, err := badger.Open(badgerOpts(dbPath))
+	opts := badgerOpts(dbPath)
+	opts.ReadOnly = IsReadOnly(r)
+	db, err := badger.Open(opts)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "db/idx: badger failed to open")
 	}
+	trackBadger(r, dbPath, db)
 
 	mlog := multibadger.New(db, msgpack.New(margaret.BaseSeq(0)))
 
 	statePath := r.GetPath(PrefixMultiLog, name, "state.json")
-	mode := os.O_RDWR | os.O_EXCL
-	if _, err := os.Stat(statePath); os.IsNotExist(err) {
-		mode |= os.O_CREATE
-	}
-	idxStateFile, err := os.OpenFile(statePath, mode, 0700)
+	idxStateFile, err := openStateFile(r, statePath)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "error opening state file")
+		return nil, nil, err
 	}
 
 	mlogSink := multilog.NewSink(idxStateFile, mlog, f)
@@ -77,12 +83,16 @@ func OpenBadgerMultiLog(r Interface, name string, f multilog.Func) (multilog.Mul
 func OpenMultiLog(r Interface, name string, f multilog.Func) (multilog.MultiLog, librarian.SinkIndex, error) {
 
 	dbPath := r.GetPath(PrefixMultiLog, name, "roaring")
-	err := os.MkdirAll(dbPath, 0700)
+	err := makeDir(r, dbPath)
 	if err != nil {
-		return nil, nil, errors.Wrapf(err, "mkdir error for %q", dbPath)
+		return nil, nil, err
 	}
 
 	mkvPath := filepath.Join(dbPath, "mkv")
+	if IsReadOnly(r) {
+		return openMultiLogCopy(r, name, mkvPath, f)
+	}
+
 	mlog, err := multimkv.NewMultiLog(mkvPath)
 	if err != nil {
 		// yuk..
@@ -107,13 +117,9 @@ func OpenMultiLog(r Interface, name string, f multilog.Func) (multilog.MultiLog,
 
 	// todo: save the current state in the multilog
 	statePath := r.GetPath(PrefixMultiLog, name, "state_mkv.json")
-	mode := os.O_RDWR | os.O_EXCL
-	if _, err := os.Stat(statePath); os.IsNotExist(err) {
-		mode |= os.O_CREATE
-	}
-	idxStateFile, err := os.OpenFile(statePath, mode, 0700)
+	idxStateFile, err := openStateFile(r, statePath)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "error opening state file")
+		return nil, nil, err
 	}
 
 	mlogSink := multilog.NewSink(idxStateFile, mlog, f)
@@ -121,27 +127,85 @@ func OpenMultiLog(r Interface, name string, f multilog.Func) (multilog.MultiLog,
 	return mlog, mlogSink, nil
 }
 
+// openMultiLogCopy opens a copy of the roaring store at mkvPath, see copyUnlocked.
+func openMultiLogCopy(r Interface, name, mkvPath string, f multilog.Func) (multilog.MultiLog, librarian.SinkIndex, error) {
+	if err := mustExist(mkvPath); err != nil {
+		return nil, nil, err
+	}
+	dir, err := copyUnlocked(mkvPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	mlog, err := multimkv.NewMultiLog(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, errors.Wrapf(err, "failed to open roaring db read-only")
+	}
+	ml := copiedMultiLog{MultiLog: mlog, dir: dir}
+
+	statePath := r.GetPath(PrefixMultiLog, name, "state_mkv.json")
+	idxStateFile, err := openStateFile(r, statePath)
+	if err != nil {
+		ml.Close()
+		return nil, nil, err
+	}
+
+	return ml, multilog.NewSink(idxStateFile, ml, f), nil
+}
+
+// makeDir creates pth or, if r is read-only, checks that it exists
+func makeDir(r Interface, pth string) error {
+	if IsReadOnly(r) {
+		return mustExist(pth)
+	}
+	err := os.MkdirAll(pth, 0700)
+	return errors.Wrapf(err, "mkdir error for %q", pth)
+}
+
+// openStateFile opens the file where a multilog sink keeps the sequence it processed up to.
+// Read-only repos don't process messages, the file is only opened for reading then.
+func openStateFile(r Interface, statePath string) (*os.File, error) {
+	if IsReadOnly(r) {
+		f, err := os.Open(statePath)
+		return f, errors.Wrap(err, "error opening state file read-only")
+	}
+	mode := os.O_RDWR | os.O_EXCL
+	if _, err := os.Stat(statePath); os.IsNotExist(err) {
+		mode |= os.O_CREATE
+	}
+	f, err := os.OpenFile(statePath, mode, 0700)
+	return f, errors.Wrap(err, "error opening state file")
+}
+
 func cleanupLockFiles(root string) error {
 	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		name := filepath.Base(path)
-		if info.Size() == 0 && len(name) == 41 && name[0] == '.' {
+		if isLockFile(info) {
 			log.Println("dropping empty lockflile", path)
 			if err := os.Remove(path); err != nil {
-				return errors.Wrapf(err, "failed to remove %s", name)
+				return errors.Wrapf(err, "failed to remove %s", info.Name())
 			}
 		}
 		return nil
 	})
 }
 
+// isLockFile matches the empty .<sha1> files modernc.org/kv uses as locks
+func isLockFile(info os.FileInfo) bool {
+	name := info.Name()
+	return !info.IsDir() && info.Size() == 0 && len(name) == 41 && name[0] == '.'
+}
+
 const PrefixIndex = "indexes"
 
 func OpenIndex(r Interface, name string, f func(librarian.SeqSetterIndex) librarian.SinkIndex) (librarian.Index, librarian.SinkIndex, error) {
 	pth := r.GetPath(PrefixIndex, name, "mkv")
-	err := os.MkdirAll(pth, 0700)
+	if IsReadOnly(r) {
+		return openIndexCopy(pth, f)
+	}
+	err := makeDir(r, pth)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "openIndex: error making index directory")
 	}
@@ -191,24 +255,59 @@ type LibrarianIndexCreater func(*badger.DB) (librarian.SeqSetterIndex, librarian
 
 func OpenBadgerIndex(r Interface, name string, f LibrarianIndexCreater) (*badger.DB, librarian.SeqSetterIndex, librarian.SinkIndex, error) {
 	pth := r.GetPath(PrefixIndex, name, "db")
-	err := os.MkdirAll(pth, 0700)
+	err := makeDir(r, pth)
 	if err != nil {
 		return nil, nil, nil, errors.Wrap(err, "error making index directory")
 	}
 
-	db, err := badger.Open(badgerOpts(pth))
+	opts := badgerOpts(pth)
+	opts.ReadOnly = IsReadOnly(r)
+	db, err := badger.Open(opts)
 	if err != nil {
 		return nil, nil, nil, errors.Wrap(err, "db/idx: badger failed to open")
 	}
+	trackBadger(r, pth, db)
 
 	idx, sinkidx := f(db)
 
 	return db, idx, sinkidx, nil
 }
 
-func OpenBlobStore(r Interface) (ssb.BlobStore, error) {
-	bs, err := blobstore.New(r.GetPath("blobs"))
-	return bs, errors.Wrap(err, "error opening blob store")
+// OpenBlobStore opens the local blob store of the repo with opts
+func OpenBlobStore(r Interface, opts ...blobstore.Option) (ssb.BlobStore, error) {
+	if IsReadOnly(r) {
+		if err := mustExist(r.GetPath("blobs", "sha256")); err != nil {
+			return nil, errors.Wrap(err, "error opening blob store")
+		}
+	}
+	bs, err := blobstore.New(r.GetPath("blobs"), opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening blob store")
+	}
+	if IsReadOnly(r) {
+		return readOnlyBlobStore{bs}, nil
+	}
+	return bs, nil
+}
+
+// OpenBlobBackend opens the blob store registered as name in the blobstore package with opts.
+// The default backend uses the blobs directory of the repo, like OpenBlobStore, others keep their local files in a directory below it.
+func OpenBlobBackend(r Interface, name string, opts map[string]string) (ssb.BlobStore, error) {
+	dir := r.GetPath("blobs", name)
+	if name == "" || name == blobstore.DefaultBackend {
+		if len(opts) == 0 {
+			return OpenBlobStore(r)
+		}
+		dir = r.GetPath("blobs")
+	}
+	bs, err := blobstore.Open(name, dir, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening blob store")
+	}
+	if IsReadOnly(r) {
+		return readOnlyBlobStore{bs}, nil
+	}
+	return bs, nil
 }
 
 var lockFileExistsRe = regexp.MustCompile(`cannot access DB \"(.*)\": lock file \"(.*)\" exists`)
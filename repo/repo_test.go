@@ -3,13 +3,22 @@
 package repo
 
 import (
+	"bytes"
+	"context"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 
+	"go.cryptoscope.co/librarian"
 	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/margaret/multilog"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/message/legacy"
 )
 
 func TestNew(t *testing.T) {
@@ -33,3 +42,108 @@ func TestNew(t *testing.T) {
 		os.RemoveAll(rpath)
 	}
 }
+
+func TestReadOnly(t *testing.T) {
+	r := require.New(t)
+
+	rpath, err := ioutil.TempDir("", t.Name())
+	r.NoError(err)
+
+	ro := NewReadOnly(rpath)
+	r.True(IsReadOnly(ro))
+	r.False(IsReadOnly(New(rpath)))
+
+	_, err = OpenLog(ro)
+	r.Error(err, "should not create a missing log")
+	_, err = DefaultKeyPair(ro)
+	r.Error(err, "should not create a missing key pair")
+
+	// create it and write to it with a normal repo
+	rw := New(rpath)
+	rwLog, err := OpenLog(rw)
+	r.NoError(err)
+
+	kp, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+	msg := &legacy.StoredMessage{
+		Author_:   kp.Id,
+		Key_:      &ssb.MessageRef{Hash: bytes.Repeat([]byte{1}, 32), Algo: ssb.RefAlgoMessageSSB1},
+		Sequence_: 1,
+		Raw_:      []byte(`{"content":{"type":"test"}}`),
+	}
+	_, err = rwLog.Append(msg)
+	r.NoError(err)
+
+	_, err = OpenBlobStore(rw)
+	r.NoError(err)
+
+	// open it a second time while it's still open for writing
+	roLog, err := OpenLog(ro)
+	r.NoError(err, "failed to open log read-only")
+	seq, err := roLog.Seq().Value()
+	r.NoError(err)
+	r.Equal(margaret.BaseSeq(0), seq)
+
+	_, err = roLog.Append(msg)
+	r.Equal(ErrReadOnly, err)
+	r.Equal(ErrReadOnly, roLog.Null(margaret.BaseSeq(0)))
+
+	bs, err := OpenBlobStore(ro)
+	r.NoError(err)
+	_, err = bs.Put(strings.NewReader("nope"))
+	r.Equal(ErrReadOnly, err)
+
+	r.NoError(roLog.Close())
+	r.NoError(rwLog.Close())
+
+	// indexes and multilogs can be read while they are open for writing
+	noop := func(context.Context, margaret.Seq, interface{}, multilog.MultiLog) error { return nil }
+	rwML, _, err := OpenMultiLog(rw, "test", noop)
+	r.NoError(err)
+	sublog, err := rwML.Get(librarian.Addr("sub"))
+	r.NoError(err)
+	_, err = sublog.Append(margaret.BaseSeq(23))
+	r.NoError(err)
+
+	roML, _, err := OpenMultiLog(ro, "test", noop)
+	r.NoError(err, "failed to open multilog read-only")
+	roSublog, err := roML.Get(librarian.Addr("sub"))
+	r.NoError(err)
+	seq, err = roSublog.Seq().Value()
+	r.NoError(err)
+	r.Equal(margaret.BaseSeq(0), seq)
+	r.NoError(roML.Close())
+
+	noSink := func(librarian.SeqSetterIndex) librarian.SinkIndex { return nil }
+	rwIdx, _, err := OpenIndex(rw, "test", noSink)
+	r.NoError(err)
+	ctx := context.Background()
+	r.NoError(rwIdx.(librarian.SeqSetterIndex).Set(ctx, librarian.Addr("key"), 42))
+
+	roIdx, roSnk, err := OpenIndex(ro, "test", noSink)
+	r.NoError(err, "failed to open index read-only")
+	obv, err := roIdx.Get(ctx, librarian.Addr("key"))
+	r.NoError(err)
+	v, err := obv.Value()
+	r.NoError(err)
+	r.EqualValues(42, v)
+
+	// it's a copy, the index of the writer stays its own
+	r.NoError(rwIdx.(librarian.SeqSetterIndex).Set(ctx, librarian.Addr("key"), 43))
+	obv, err = roIdx.Get(ctx, librarian.Addr("key"))
+	r.NoError(err)
+	v, err = obv.Value()
+	r.NoError(err)
+	r.EqualValues(42, v)
+	r.NoError(roSnk.Close())
+
+	_, _, err = OpenIndex(ro, "missing", noSink)
+	r.Error(err, "should not create a missing index")
+	_, err = os.Stat(filepath.Join(rpath, PrefixIndex, "missing"))
+	r.True(os.IsNotExist(err))
+	r.NoError(rwML.Close())
+
+	if !t.Failed() {
+		os.RemoveAll(rpath)
+	}
+}
@@ -19,6 +19,9 @@ func DefaultKeyPair(r Interface) (*ssb.KeyPair, error) {
 		if !os.IsNotExist(errors.Cause(err)) {
 			return nil, errors.Wrap(err, "repo: error opening key pair")
 		}
+		if IsReadOnly(r) {
+			return nil, errors.Wrap(ErrReadOnly, "repo: no keypair to load")
+		}
 		keyPair, err = ssb.NewKeyPair(nil)
 		if err != nil {
 			return nil, errors.Wrap(err, "repo: no keypair but couldn't create one either")
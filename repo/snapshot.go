@@ -0,0 +1,576 @@
+// SPDX-License-Identifier: MIT
+
+package repo
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/margaret"
+
+	"go.cryptoscope.co/ssb/message/legacy"
+	"go.cryptoscope.co/ssb/message/multimsg"
+)
+
+// SnapshotVersion is the format version written by Snapshot
+const SnapshotVersion = 1
+
+const (
+	snapshotManifestName = "manifest.json"
+	snapshotLogName      = "log.frames"
+	snapshotBadgerPrefix = "badger/"
+	snapshotStatePrefix  = "state/"
+)
+
+// SnapshotManifest describes the content of a snapshot.
+type SnapshotManifest struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+
+	// Sequence is the last sequence of the root log that is part of the snapshot.
+	// The badger indexes were saved before the log was fenced, their state is at or before it.
+	// Indexes that are not part of the snapshot are rebuilt from the start.
+	Sequence int64 `json:"sequence"`
+
+	// LogHash is the sha256 of the log.frames entry
+	LogHash string `json:"logHash"`
+
+	// Badger lists the repo paths of the badger databases in the snapshot
+	Badger []string `json:"badger"`
+
+	// States lists the repo paths of the state files of the badger multilogs, how far they processed the log
+	States []string `json:"states,omitempty"`
+}
+
+// Handles are the open databases of a repo that Snapshot needs,
+// badger databases don't have a lock free way to copy them and can only be saved with the backup api of their open handle.
+// Pass the same Handles to all the repo values of a bot with WithHandles.
+type Handles struct {
+	mu      sync.Mutex
+	badgers map[string]*badger.DB // by their path relative to the repo
+}
+
+// NewHandles returns an empty set of handles for WithHandles
+func NewHandles() *Handles {
+	return &Handles{badgers: make(map[string]*badger.DB)}
+}
+
+// WithHandles has the repo keep the badger databases it opens in h, for Snapshot
+func WithHandles(h *Handles) Option {
+	return func(r *repo) {
+		r.handles = h
+	}
+}
+
+// Forget drops the databases, call it after they were closed
+func (h *Handles) Forget() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.badgers = make(map[string]*badger.DB)
+}
+
+// close closes and drops the databases below the prefixes
+func (h *Handles) close(prefixes ...string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var firstErr error
+	for rel, db := range h.badgers {
+		for _, p := range prefixes {
+			if strings.HasPrefix(rel, p) {
+				if err := db.Close(); err != nil && firstErr == nil {
+					firstErr = errors.Wrapf(err, "failed to close %s", rel)
+				}
+				delete(h.badgers, rel)
+				break
+			}
+		}
+	}
+	return firstErr
+}
+
+func (h *Handles) list() map[string]*badger.DB {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	dbs := make(map[string]*badger.DB, len(h.badgers))
+	for rel, db := range h.badgers {
+		dbs[rel] = db
+	}
+	return dbs
+}
+
+// handlesOf returns the handles of r, nil if it doesn't keep any
+func handlesOf(r Interface) *Handles {
+	rr, ok := r.(repo)
+	if !ok {
+		return nil
+	}
+	return rr.handles
+}
+
+func trackBadger(r Interface, dbPath string, db *badger.DB) {
+	h := handlesOf(r)
+	if h == nil {
+		return
+	}
+	rel, err := filepath.Rel(r.GetPath(), dbPath)
+	if err != nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.badgers[filepath.ToSlash(rel)] = db
+}
+
+// multilogState is the state file next to the database of a badger multilog, see OpenBadgerMultiLog
+func multilogState(rel string) (string, bool) {
+	parts := strings.Split(rel, "/")
+	if len(parts) != 3 || parts[0] != PrefixMultiLog || parts[2] != "db" {
+		return "", false
+	}
+	return PrefixMultiLog + "/" + parts[1] + "/state.json", true
+}
+
+// Snapshot writes a consistent backup of the repo as a tar stream to w.
+//
+// First the badger indexes that r opened are saved using badger's backup api, r needs to be made WithHandles for that.
+// The state files of the badger multilogs are read before, so that the restored ones at most process some messages again.
+// Then the root log is fenced at its current sequence and copied up to there.
+// Messages that are appended while the snapshot is made are not part of it.
+// Other indexes (like the roaring multilogs) are left out and rebuilt after Restore.
+func Snapshot(w io.Writer, r Interface, rl margaret.Log) (*SnapshotManifest, error) {
+	tmpDir, err := ioutil.TempDir("", "ssb-snapshot")
+	if err != nil {
+		return nil, errors.Wrap(err, "snapshot: failed to create temporary directory")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var manifest = SnapshotManifest{
+		Version: SnapshotVersion,
+		Created: time.Now(),
+	}
+
+	// 1) indexes
+	var dbs map[string]*badger.DB
+	if h := handlesOf(r); h != nil {
+		dbs = h.list()
+	}
+
+	for rel := range dbs {
+		state, ok := multilogState(rel)
+		if !ok {
+			continue
+		}
+		data, err := ioutil.ReadFile(r.GetPath(filepath.FromSlash(state)))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "snapshot: failed to read %s", state)
+		}
+		if err := ioutil.WriteFile(filepath.Join(tmpDir, strings.Replace(state, "/", "_", -1)), data, 0600); err != nil {
+			return nil, errors.Wrapf(err, "snapshot: failed to copy %s", state)
+		}
+		manifest.States = append(manifest.States, state)
+	}
+	sort.Strings(manifest.States)
+
+	for rel, db := range dbs {
+		f, err := os.Create(filepath.Join(tmpDir, strings.Replace(rel, "/", "_", -1)))
+		if err != nil {
+			return nil, errors.Wrap(err, "snapshot: failed to create badger backup file")
+		}
+		_, err = db.Backup(f, 0)
+		f.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "snapshot: badger backup of %s failed", rel)
+		}
+		manifest.Badger = append(manifest.Badger, rel)
+	}
+	sort.Strings(manifest.Badger)
+
+	// 2) fence the log and copy it
+	sv, err := rl.Seq().Value()
+	if err != nil {
+		return nil, errors.Wrap(err, "snapshot: failed to get current sequence")
+	}
+	manifest.Sequence = margaret.SeqEmpty.Seq()
+	if seq, ok := sv.(margaret.Seq); ok {
+		manifest.Sequence = seq.Seq()
+	}
+
+	logFile, err := os.Create(filepath.Join(tmpDir, snapshotLogName))
+	if err != nil {
+		return nil, errors.Wrap(err, "snapshot: failed to create log file")
+	}
+	defer logFile.Close()
+
+	h := sha256.New()
+	buf := bufio.NewWriter(io.MultiWriter(logFile, h))
+	for seq := int64(0); seq <= manifest.Sequence; seq++ {
+		v, err := rl.Get(margaret.BaseSeq(seq))
+		if err != nil {
+			if !margaret.IsErrNulled(err) {
+				return nil, errors.Wrapf(err, "snapshot: failed to get message %d", seq)
+			}
+			v = err
+		}
+
+		var frame []byte // empty frames are nulled messages
+		switch tv := v.(type) {
+		case *multimsg.MultiMessage:
+			frame, err = tv.MarshalBinary()
+			if err != nil {
+				return nil, errors.Wrapf(err, "snapshot: failed to encode message %d", seq)
+			}
+		case error:
+			if !margaret.IsErrNulled(tv) {
+				return nil, errors.Wrapf(tv, "snapshot: failed to get message %d", seq)
+			}
+		default:
+			return nil, errors.Errorf("snapshot: unexpected log entry %T at %d", v, seq)
+		}
+
+		if err := writeFrame(buf, frame); err != nil {
+			return nil, errors.Wrap(err, "snapshot: failed to write log")
+		}
+	}
+	if err := buf.Flush(); err != nil {
+		return nil, errors.Wrap(err, "snapshot: failed to write log")
+	}
+	manifest.LogHash = hex.EncodeToString(h.Sum(nil))
+
+	// 3) the archive, manifest first so that a restore can check it before unpacking
+	tw := tar.NewWriter(w)
+
+	mb, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "snapshot: failed to encode manifest")
+	}
+	if err := tarBytes(tw, snapshotManifestName, mb); err != nil {
+		return nil, err
+	}
+	if err := tarFile(tw, snapshotLogName, logFile.Name()); err != nil {
+		return nil, err
+	}
+	for _, rel := range manifest.Badger {
+		if err := tarFile(tw, snapshotBadgerPrefix+rel, filepath.Join(tmpDir, strings.Replace(rel, "/", "_", -1))); err != nil {
+			return nil, err
+		}
+	}
+	for _, state := range manifest.States {
+		if err := tarFile(tw, snapshotStatePrefix+state, filepath.Join(tmpDir, strings.Replace(state, "/", "_", -1))); err != nil {
+			return nil, err
+		}
+	}
+	return &manifest, errors.Wrap(tw.Close(), "snapshot: failed to finish archive")
+}
+
+// Restore unpacks a snapshot into r, which must not have a log yet.
+// The hashes of the log and of every legacy message are checked while unpacking.
+// If it fails, the content of r is undefined and should be removed.
+func Restore(rd io.Reader, r Interface) (*SnapshotManifest, error) {
+	if _, err := os.Stat(r.GetPath("log")); !os.IsNotExist(err) {
+		return nil, errors.Errorf("restore: %s already has a log", r.GetPath())
+	}
+
+	tr := tar.NewReader(rd)
+
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, errors.Wrap(err, "restore: failed to read manifest")
+	}
+	if hdr.Name != snapshotManifestName {
+		return nil, errors.Errorf("restore: expected manifest first, got %s", hdr.Name)
+	}
+	var manifest SnapshotManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, errors.Wrap(err, "restore: invalid manifest")
+	}
+	if manifest.Version != SnapshotVersion {
+		return nil, errors.Errorf("restore: unsupported snapshot version %d", manifest.Version)
+	}
+	if manifest.Sequence < margaret.SeqEmpty.Seq() {
+		return nil, errors.Errorf("restore: invalid sequence %d in manifest", manifest.Sequence)
+	}
+	if manifest.Sequence == margaret.SeqEmpty.Seq() && (len(manifest.Badger) > 0 || len(manifest.States) > 0) {
+		return nil, errors.Errorf("restore: manifest has indexes but no messages")
+	}
+
+	var (
+		sawLog  bool
+		badgers = make(map[string]bool, len(manifest.Badger))
+		states  = make(map[string]bool, len(manifest.States))
+	)
+	for _, rel := range manifest.Badger {
+		badgers[rel] = false
+	}
+	for _, state := range manifest.States {
+		if _, ok := multilogState(strings.TrimSuffix(state, "state.json") + "db"); !ok {
+			return nil, errors.Errorf("restore: %s is not the state file of a multilog", state)
+		}
+		states[state] = false
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "restore: failed to read archive")
+		}
+
+		switch {
+		case hdr.Name == snapshotLogName:
+			if err := restoreLog(tr, r, manifest); err != nil {
+				return nil, err
+			}
+			sawLog = true
+
+		case strings.HasPrefix(hdr.Name, snapshotBadgerPrefix):
+			rel := strings.TrimPrefix(hdr.Name, snapshotBadgerPrefix)
+			if _, listed := badgers[rel]; !listed || strings.Contains(rel, "..") {
+				return nil, errors.Errorf("restore: badger database %s is not in the manifest", rel)
+			}
+			if err := restoreBadger(tr, r.GetPath(filepath.FromSlash(rel))); err != nil {
+				return nil, errors.Wrapf(err, "restore: failed to load %s", rel)
+			}
+			badgers[rel] = true
+
+		case strings.HasPrefix(hdr.Name, snapshotStatePrefix):
+			state := strings.TrimPrefix(hdr.Name, snapshotStatePrefix)
+			if _, listed := states[state]; !listed || strings.Contains(state, "..") {
+				return nil, errors.Errorf("restore: state file %s is not in the manifest", state)
+			}
+			if err := restoreFile(tr, r.GetPath(filepath.FromSlash(state))); err != nil {
+				return nil, errors.Wrapf(err, "restore: failed to write %s", state)
+			}
+			states[state] = true
+
+		default:
+			return nil, errors.Errorf("restore: unexpected archive entry %s", hdr.Name)
+		}
+	}
+
+	if !sawLog {
+		return nil, errors.Errorf("restore: snapshot has no log")
+	}
+	for rel, done := range badgers {
+		if !done {
+			return nil, errors.Errorf("restore: badger database %s is missing", rel)
+		}
+	}
+	for state, done := range states {
+		if !done {
+			return nil, errors.Errorf("restore: state file %s is missing", state)
+		}
+	}
+	return &manifest, nil
+}
+
+func restoreLog(rd io.Reader, r Interface, manifest SnapshotManifest) error {
+	rl, err := OpenLog(r)
+	if err != nil {
+		return errors.Wrap(err, "restore: failed to create log")
+	}
+	defer rl.Close()
+
+	h := sha256.New()
+	frames := bufio.NewReader(io.TeeReader(rd, h))
+
+	var (
+		seq     int64
+		nulled  int64 // nulled entries that wait for a message to use as a placeholder
+		lastMsg *multimsg.MultiMessage
+	)
+	for ; ; seq++ {
+		frame, err := readFrame(frames)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrapf(err, "restore: failed to read log entry %d", seq)
+		}
+		if seq > manifest.Sequence {
+			return errors.Errorf("restore: log goes on after sequence %d of the manifest", manifest.Sequence)
+		}
+
+		if len(frame) == 0 {
+			if lastMsg == nil {
+				nulled++
+				continue
+			}
+			if err := appendNulled(rl, lastMsg); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var mm multimsg.MultiMessage
+		if err := mm.UnmarshalBinary(frame); err != nil {
+			return errors.Wrapf(err, "restore: failed to decode log entry %d", seq)
+		}
+		if err := checkKey(&mm); err != nil {
+			return errors.Wrapf(err, "restore: log entry %d", seq)
+		}
+
+		for ; nulled > 0; nulled-- {
+			if err := appendNulled(rl, &mm); err != nil {
+				return err
+			}
+		}
+
+		if _, err := rl.Append(&mm); err != nil {
+			return errors.Wrapf(err, "restore: failed to append log entry %d", seq)
+		}
+		lastMsg = &mm
+	}
+
+	if nulled > 0 {
+		return errors.Errorf("restore: log only has nulled entries")
+	}
+	if seq-1 != manifest.Sequence {
+		return errors.Errorf("restore: log has %d entries but the manifest says %d", seq, manifest.Sequence+1)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != manifest.LogHash {
+		return errors.Errorf("restore: log hash mismatch (%s != %s)", got, manifest.LogHash)
+	}
+	return nil
+}
+
+// appendNulled keeps the sequence numbers intact by appending a placeholder and nulling it right away
+func appendNulled(rl multimsg.AlterableLog, placeholder *multimsg.MultiMessage) error {
+	seq, err := rl.Append(placeholder)
+	if err != nil {
+		return errors.Wrap(err, "restore: failed to append placeholder")
+	}
+	return errors.Wrap(rl.Null(seq), "restore: failed to null placeholder")
+}
+
+// checkKey makes sure the key of a legacy message is the hash of its content
+func checkKey(mm *multimsg.MultiMessage) error {
+	lm, ok := mm.AsLegacy()
+	if !ok {
+		// gabby grove keys are computed when decoding
+		return nil
+	}
+	enc, err := legacy.EncodePreserveOrder(lm.Raw_)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode message")
+	}
+	v8, err := legacy.InternalV8Binary(enc)
+	if err != nil {
+		return errors.Wrap(err, "failed to convert message for hashing")
+	}
+	if lm.Key_ == nil {
+		return errors.Errorf("message without key")
+	}
+	sum := sha256.Sum256(v8)
+	if !bytes.Equal(sum[:], lm.Key_.Hash) {
+		return errors.Errorf("message hash doesn't match its key %s", lm.Key_.Ref())
+	}
+	return nil
+}
+
+func restoreBadger(rd io.Reader, dbPath string) error {
+	if err := os.MkdirAll(dbPath, 0700); err != nil {
+		return err
+	}
+	db, err := badger.Open(badgerOpts(dbPath))
+	if err != nil {
+		return err
+	}
+	if err := db.Load(rd, 256); err != nil {
+		db.Close()
+		return err
+	}
+	return db.Close()
+}
+
+func restoreFile(rd io.Reader, pth string) error {
+	if err := os.MkdirAll(filepath.Dir(pth), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(pth, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0700)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, rd); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeFrame(w io.Writer, frame []byte) error {
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(frame)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(frame)
+	return err
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	frame := make([]byte, binary.BigEndian.Uint32(hdr[:]))
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, errors.Wrap(err, "short frame")
+	}
+	return frame, nil
+}
+
+func tarBytes(tw *tar.Writer, name string, data []byte) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "snapshot: failed to write header for %s", name)
+	}
+	_, err = tw.Write(data)
+	return errors.Wrapf(err, "snapshot: failed to write %s", name)
+}
+
+func tarFile(tw *tar.Writer, name, pth string) error {
+	f, err := os.Open(pth)
+	if err != nil {
+		return errors.Wrapf(err, "snapshot: failed to open %s", name)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return errors.Wrapf(err, "snapshot: failed to stat %s", name)
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "snapshot: failed to write header for %s", name)
+	}
+	_, err = io.Copy(tw, f)
+	return errors.Wrapf(err, "snapshot: failed to write %s", name)
+}
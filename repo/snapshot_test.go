@@ -0,0 +1,177 @@
+// SPDX-License-Identifier: MIT
+
+package repo
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/margaret/multilog"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/message/legacy"
+	"go.cryptoscope.co/ssb/message/multimsg"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	r := require.New(t)
+
+	srcPath, err := ioutil.TempDir("", t.Name())
+	r.NoError(err)
+	defer os.RemoveAll(srcPath)
+
+	src := New(srcPath, WithHandles(NewHandles()))
+	rl, err := OpenLog(src)
+	r.NoError(err)
+
+	// a badger multilog, its database and state file are part of the snapshot
+	mlog, mlogSink, err := OpenBadgerMultiLog(src, "test", func(context.Context, margaret.Seq, interface{}, multilog.MultiLog) error {
+		return nil
+	})
+	r.NoError(err)
+
+	kp, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+
+	var prev *ssb.MessageRef
+	for i := 1; i <= 5; i++ {
+		lm := legacy.LegacyMessage{
+			Previous:  prev,
+			Author:    kp.Id.Ref(),
+			Sequence:  margaret.BaseSeq(i),
+			Timestamp: int64(i) * 1000,
+			Hash:      "sha256",
+			Content:   map[string]interface{}{"type": "test", "i": i},
+		}
+		ref, raw, err := lm.Sign(kp.Pair.Secret[:], nil)
+		r.NoError(err)
+
+		_, err = rl.Append(&legacy.StoredMessage{
+			Author_:   kp.Id,
+			Previous_: prev,
+			Key_:      ref,
+			Sequence_: margaret.BaseSeq(i),
+			Raw_:      raw,
+		})
+		r.NoError(err)
+		prev = ref
+	}
+	r.NoError(rl.Null(margaret.BaseSeq(0)))
+	r.NoError(rl.Null(margaret.BaseSeq(2)))
+
+	var snap bytes.Buffer
+	manifest, err := Snapshot(&snap, src, rl)
+	r.NoError(err)
+	r.EqualValues(4, manifest.Sequence)
+	r.NotEmpty(manifest.LogHash)
+	r.Equal([]string{"sublogs/test/db"}, manifest.Badger)
+	r.Equal([]string{"sublogs/test/state.json"}, manifest.States)
+
+	// messages after the fence are not part of it
+	r.NoError(rl.Close())
+	r.NoError(mlogSink.Close())
+	r.NoError(mlog.Close())
+
+	dstPath, err := ioutil.TempDir("", t.Name())
+	r.NoError(err)
+	defer os.RemoveAll(dstPath)
+	dst := New(dstPath)
+
+	restored, err := Restore(bytes.NewReader(snap.Bytes()), dst)
+	r.NoError(err)
+	r.Equal(manifest.LogHash, restored.LogHash)
+
+	_, err = Restore(bytes.NewReader(snap.Bytes()), dst)
+	r.Error(err, "should not restore into an existing log")
+	_, err = os.Stat(dst.GetPath("sublogs", "test", "state.json"))
+	r.NoError(err, "state file of the multilog wasn't restored")
+
+	dl, err := OpenLog(dst)
+	r.NoError(err)
+	defer dl.Close()
+
+	sv, err := dl.Seq().Value()
+	r.NoError(err)
+	r.EqualValues(4, sv.(margaret.Seq).Seq())
+
+	for seq := int64(0); seq <= 4; seq++ {
+		v, err := dl.Get(margaret.BaseSeq(seq))
+		if seq == 0 || seq == 2 {
+			if err == nil {
+				verr, ok := v.(error)
+				r.True(ok, "expected nulled entry at %d: %T", seq, v)
+				err = verr
+			}
+			r.True(margaret.IsErrNulled(err), "expected nulled entry at %d", seq)
+			continue
+		}
+		r.NoError(err)
+		mm, ok := v.(*multimsg.MultiMessage)
+		r.True(ok, "wrong type %T", v)
+		r.EqualValues(seq+1, mm.Seq())
+	}
+}
+
+func TestRestoreRejectsTampering(t *testing.T) {
+	r := require.New(t)
+
+	srcPath, err := ioutil.TempDir("", t.Name())
+	r.NoError(err)
+	defer os.RemoveAll(srcPath)
+
+	src := New(srcPath)
+	rl, err := OpenLog(src)
+	r.NoError(err)
+	defer rl.Close()
+
+	kp, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+
+	lm := legacy.LegacyMessage{
+		Author:    kp.Id.Ref(),
+		Sequence:  1,
+		Timestamp: 1000,
+		Hash:      "sha256",
+		Content:   map[string]interface{}{"type": "test", "text": "original"},
+	}
+	ref, raw, err := lm.Sign(kp.Pair.Secret[:], nil)
+	r.NoError(err)
+
+	_, err = rl.Append(&legacy.StoredMessage{
+		Author_:   kp.Id,
+		Key_:      ref,
+		Sequence_: 1,
+		Raw_:      bytes.Replace(raw, []byte("original"), []byte("tampered"), 1),
+	})
+	r.NoError(err)
+
+	var snap bytes.Buffer
+	_, err = Snapshot(&snap, src, rl)
+	r.NoError(err)
+
+	dstPath, err := ioutil.TempDir("", t.Name())
+	r.NoError(err)
+	defer os.RemoveAll(dstPath)
+
+	_, err = Restore(&snap, New(dstPath))
+	r.Error(err)
+	r.Contains(err.Error(), "hash")
+
+	// a manifest with a sequence that can't be
+	var bad bytes.Buffer
+	tw := tar.NewWriter(&bad)
+	r.NoError(tarBytes(tw, snapshotManifestName, []byte(`{"version":1,"sequence":-5}`)))
+	r.NoError(tw.Close())
+	badPath, err := ioutil.TempDir("", t.Name())
+	r.NoError(err)
+	defer os.RemoveAll(badPath)
+	_, err = Restore(&bad, New(badPath))
+	r.Error(err)
+	r.Contains(err.Error(), "sequence")
+}
@@ -3,6 +3,9 @@
 package ssb
 
 import (
+	"context"
+	"time"
+
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/librarian"
 	"go.cryptoscope.co/luigi"
@@ -54,21 +57,114 @@ type ReplicationLister interface {
 	BlockList() *StrFeedSet
 }
 
+// PubAnnouncer publishes the address a bot can be reached on as a pub message
+type PubAnnouncer interface {
+	// AnnouncePub publishes addr (host:port) unless it is the address that was announced last, then it returns nil.
+	// The empty string announces the configured address.
+	AnnouncePub(addr string) (*MessageRef, error)
+}
+
 // Statuser returns status information about the bot, like how many open connections it has (see type Status for more)
 type Statuser interface {
 	Status() (Status, error)
 }
 
+// Configurer returns the configuration a bot runs with
+type Configurer interface {
+	Config() (Config, error)
+}
+
+// Config is the effective configuration of a bot, for the config method.
+// It has no secret material: of the private keys and the HMAC key it only says whether they are set.
+type Config struct {
+	Feed     string `json:"feed"`
+	Repo     string `json:"repo"`
+	ReadOnly bool   `json:"readOnly"`
+
+	// NetworkKey is the base64 encoded app key of the secret handshake, clients need the same one as --shscap
+	NetworkKey  string `json:"networkKey"`
+	HMACSigning bool   `json:"hmacSigning"`
+
+	// Listen are the configured addresses, the ports might be chosen when the bot starts to listen
+	Listen      []string `json:"listen"`
+	WebSocket   string   `json:"webSocket,omitempty"`
+	SOCKS5Proxy string   `json:"socks5Proxy,omitempty"` // without user and password
+	Discovery   bool     `json:"discovery"`
+	Adverts     bool     `json:"adverts"`
+	MaxConns    int      `json:"maxConns,omitempty"` // 0 if the connections aren't managed
+
+	Replication ReplicationConfig `json:"replication"`
+}
+
+// ReplicationConfig is what a bot replicates and in which order
+type ReplicationConfig struct {
+	Hops          uint `json:"hops"`
+	Promisc       bool `json:"promisc"`
+	Strangers     bool `json:"strangers"`
+	EBT           bool `json:"ebt"`
+	DeleteBlocked bool `json:"deleteBlocked"`
+
+	// HopPolicies are the policies by the distance they start at
+	HopPolicies []HopPolicyConfig `json:"hopPolicies,omitempty"`
+
+	ScheduleHops      float64 `json:"scheduleHops"`
+	ScheduleLag       float64 `json:"scheduleLag"`
+	ScheduleFairEvery int     `json:"scheduleFairEvery"`
+}
+
+// HopPolicyConfig is what is replicated of the feeds from a distance on
+type HopPolicyConfig struct {
+	From  uint     `json:"from"`
+	Skip  bool     `json:"skip"`
+	Types []string `json:"types,omitempty"`
+}
+
 type PeerStatus struct {
-	Addr  string
-	Since string
+	Addr     string
+	Since    string
+	Listener string `json:",omitempty"` // the listener the peer connected to, empty if we dialed it
 }
 type Status struct {
 	PID      int // process id of the bot
 	Peers    []PeerStatus
 	Blobs    []BlobWant
+	Stored   BlobStoreStats // number and size of the blobs we have
 	Root     margaret.BaseSeq
 	Indicies IndexStates
+
+	// Connections is set if the bot manages its connections
+	Connections *ConnectionsStatus `json:",omitempty"`
+
+	// Dials are the addresses that failed to dial the last time
+	Dials []DialBackoff `json:",omitempty"`
+
+	// Traffic is how much data was exchanged with each peer, the busiest first
+	Traffic []PeerTraffic `json:",omitempty"`
+}
+
+// ConnectionsStatus is the state of a connection manager
+type ConnectionsStatus struct {
+	Max   int
+	Open  []ManagedPeerStatus
+	Known []KnownPeerStatus
+}
+
+// ManagedPeerStatus is an open connection of a connection manager
+type ManagedPeerStatus struct {
+	Feed   string
+	Since  string
+	Idle   string
+	Sticky bool
+}
+
+// KnownPeerStatus is an address a connection manager dials
+type KnownPeerStatus struct {
+	Addr     string
+	Source   string
+	Failures int
+	NextTry  string
+	Failure  string `json:",omitempty"` // how the last dial failed, see DialBackoff
+	LastErr  string `json:",omitempty"`
 }
 
 type IndexStates []IndexState
@@ -78,6 +174,130 @@ type IndexState struct {
 	State string
 }
 
+// ReplicationStater returns what we know about the replication with other peers
+type ReplicationStater interface {
+	// ReplicationState returns the state of peer, false if we didn't replicate with it yet
+	ReplicationState(peer *FeedRef) (ReplicationState, bool)
+
+	// ReplicationStates returns the states of all the peers we replicated with
+	ReplicationStates() []ReplicationState
+}
+
+// ReplicationScheduler decides which of the replicated feeds are fetched first
+type ReplicationScheduler interface {
+	// Schedule returns all the feeds that are replicated, the most urgent first
+	Schedule() []FeedPriority
+
+	// Prioritize sorts feeds like Schedule, in place
+	Prioritize(feeds []*FeedRef)
+
+	// RemoteHas records that a peer said it has feed up to seq
+	RemoteHas(feed *FeedRef, seq int64)
+}
+
+// FeedPriority is why a feed is replicated and how far behind we think we are with it
+type FeedPriority struct {
+	Feed FeedRef `json:"feed"`
+
+	// Hops is 0 for our own feed, 1 for the ones we follow and so on. -1 for feeds that were added by hand
+	Hops int `json:"hops"`
+
+	// Local is the latest message we have, Remote the latest one a peer told us about (0 if none did)
+	Local  int64 `json:"local"`
+	Remote int64 `json:"remote"`
+
+	// Behind is how many messages we are missing, as far as we know
+	Behind int64 `json:"behind"`
+}
+
+// FeedFetcher fetches one feed right away, instead of waiting for the next round of replication
+type FeedFetcher interface {
+	// FetchFeed asks the connected peers for feed until we have the latest message we know of or the timeout is over.
+	// Calls for a feed that is already being fetched wait for that one.
+	FetchFeed(ctx context.Context, feed *FeedRef, opts FetchOptions) (FetchResult, error)
+}
+
+// FetchOptions are the arguments of replicate.fetch besides the feed
+type FetchOptions struct {
+	// how long to try at most, a default of the fetcher if it is 0
+	Timeout time.Duration `json:"-"`
+
+	// also dial the pubs we know of
+	Dial bool `json:"dial"`
+}
+
+// FetchResult is what a FetchFeed call got
+type FetchResult struct {
+	Feed FeedRef `json:"feed"`
+
+	// how many messages arrived and the latest one we have now
+	Received int64 `json:"received"`
+	Latest   int64 `json:"latest"`
+
+	// the latest message a peer told us about, 0 if none did.
+	// Complete is true if we have it or, without one, if all the peers we asked sent what they have.
+	Remote   int64 `json:"remote"`
+	Complete bool  `json:"complete"`
+
+	// the peers that were asked
+	Asked int `json:"asked"`
+}
+
+// ReplicationEvent is a step of the replication of one feed, with a summary of all of them for a progress display
+type ReplicationEvent struct {
+	Time  time.Time `json:"time"`
+	Event string    `json:"event"` // one of the Replication* constants
+
+	Feed string `json:"feed"`
+	Peer string `json:"peer"`
+
+	// the latest message of Feed we have and how many of them came with this fetch so far
+	Seq      int64 `json:"seq"`
+	Received int64 `json:"received"`
+
+	// the replicated feeds that weren't fetched yet, are being fetched and were fetched since the bot started
+	Pending int `json:"pending"`
+	Active  int `json:"active"`
+	Done    int `json:"done"`
+
+	// messages per second over all feeds, in the last seconds
+	Rate float64 `json:"rate"`
+
+	Err string `json:"err,omitempty"` // why a fetch ended early
+}
+
+// the events of ReplicationEvent
+const (
+	ReplicationFeedStart    = "feed-start"
+	ReplicationFeedProgress = "feed-progress"
+	ReplicationFeedDone     = "feed-done"
+)
+
+// ReplicationEventStreamer passes on the progress of the replication.
+// ReplicationEvents returns a channel with the events from now on, cancel has to be called once done with it.
+// Followers that don't keep up miss events.
+type ReplicationEventStreamer interface {
+	ReplicationEvents() (live <-chan ReplicationEvent, cancel func())
+}
+
+// ReplicationState is what we know about the replication with one peer
+type ReplicationState struct {
+	Peer FeedRef `json:"peer"`
+
+	// when we connected to the peer the last time
+	LastConnected time.Time `json:"lastConnected"`
+
+	// when the last exchange ended and how ("ok" or the error), empty while it is still running
+	LastExchange time.Time `json:"lastExchange"`
+	LastResult   string    `json:"lastResult"`
+
+	// the sequence of the last message we received of each feed from this peer, by feed ref
+	Received map[string]int64 `json:"received"`
+
+	// the feeds the peer had nothing of when we asked, and when that was. They are not asked for again for a while.
+	Missing map[string]time.Time `json:"missing,omitempty"`
+}
+
 type ContentNuller interface {
 	NullContent(feed *FeedRef, seq uint) error
 }
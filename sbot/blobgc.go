@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret/multilog"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/blobstore"
+	"go.cryptoscope.co/ssb/internal/mutil"
+	"go.cryptoscope.co/ssb/multilogs"
+)
+
+// WithBlobGC deletes blobs in the background, once the store is bigger than the max size or blobs weren't accessed for the max age (see blobstore.NewGC).
+// Blobs mentioned by messages of feeds within the configured hops are kept.
+// Blobs of stores other than the local one of the repo are as old as when they were added.
+func WithBlobGC(opts ...blobstore.GCOption) Option {
+	return func(s *Sbot) error {
+		s.enableBlobGC = true
+		s.blobGCOpts = opts
+		return nil
+	}
+}
+
+func (s *Sbot) startBlobGC() error {
+	refs, has := s.mlogIndicies[multilogs.IndexNameBlobRefs]
+	if !has {
+		return errors.Errorf("sbot: blob GC needs the %s index", multilogs.IndexNameBlobRefs)
+	}
+
+	opts := append([]blobstore.GCOption{
+		blobstore.GCWithLogger(kitlog.With(s.info, "module", "blobGC")),
+		blobstore.GCWithProtection(s.blobProtection(refs)),
+	}, s.blobGCOpts...)
+	gc, err := blobstore.NewGC(s.BlobStore, opts...)
+	if err != nil {
+		return errors.Wrap(err, "sbot: failed to make blob GC")
+	}
+	go gc.Serve(s.rootCtx)
+	return nil
+}
+
+// blobProtection keeps blobs that messages of ourself and the feeds we replicate link to.
+// When in doubt, blobs are kept.
+func (s *Sbot) blobProtection(refs multilog.MultiLog) blobstore.Protection {
+	return func() (func(*ssb.BlobRef) bool, error) {
+		wanted := s.GraphBuilder.Hops(s.KeyPair.Id, int(s.hopCount))
+		if wanted == nil {
+			return nil, errors.Errorf("sbot: failed to walk the follow graph")
+		}
+		if err := wanted.AddRef(s.KeyPair.Id); err != nil {
+			return nil, err
+		}
+
+		return func(ref *ssb.BlobRef) bool {
+			addr, err := multilogs.BlobRefAddr(ref)
+			if err != nil {
+				return true
+			}
+			sub, err := refs.Get(addr)
+			if err != nil {
+				return true
+			}
+			src, err := mutil.Indirect(s.RootLog, sub).Query()
+			if err != nil {
+				return true
+			}
+			for {
+				v, err := src.Next(s.rootCtx)
+				if luigi.IsEOS(err) {
+					return false
+				} else if err != nil {
+					return true
+				}
+				msg, ok := v.(ssb.Message)
+				if !ok {
+					// nulled messages don't protect anything
+					continue
+				}
+				if wanted.Has(msg.Author()) && linksTo(msg, ref) {
+					return true
+				}
+			}
+		}, nil
+	}
+}
@@ -0,0 +1,244 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/librarian"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/mutil"
+	"go.cryptoscope.co/ssb/multilogs"
+	"go.cryptoscope.co/ssb/plugins2/backlinks"
+)
+
+const (
+	// DefaultBlobPushInterval is how long the bot waits between announcing two blobs
+	DefaultBlobPushInterval = 2 * time.Second
+
+	// blobPushRecent is how many of our latest messages count as recent
+	blobPushRecent = 50
+)
+
+// WithBlobPush announces blobs that our recent messages link to, to the connected peers within hops, once we have them.
+// That way peers get the images of a post before anyone views it. At most one blob is announced per interval,
+// so that adding a whole album doesn't make every peer fetch everything at once.
+// It also makes the bot fetch the blobs that peers within hops announce to it. It is off by default.
+func WithBlobPush(hops uint, interval time.Duration) Option {
+	return func(s *Sbot) error {
+		if interval <= 0 {
+			interval = DefaultBlobPushInterval
+		}
+		s.blobPush = &blobPusher{
+			s:        s,
+			hops:     int(hops),
+			interval: interval,
+			queued:   make(map[string]struct{}),
+			recent:   make(map[string]int64),
+		}
+		return nil
+	}
+}
+
+type blobPusher struct {
+	s        *Sbot
+	hops     int
+	interval time.Duration
+
+	mu sync.Mutex
+	// blobs waiting to be announced, in order
+	queue  []*ssb.BlobRef
+	queued map[string]struct{}
+	// blobs our recent messages link to and the sequence of the latest one of those messages
+	recent map[string]int64
+	latest int64
+
+	// cached result of walking the follow graph
+	peers   *ssb.StrFeedSet
+	peersAt time.Time
+}
+
+// accepts returns true if fr is within hops of us. The graph is walked at most once a minute.
+func (bp *blobPusher) accepts(fr *ssb.FeedRef) bool {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	if bp.peers == nil || time.Since(bp.peersAt) > time.Minute {
+		if bp.s.GraphBuilder == nil {
+			return false
+		}
+		peers := bp.s.GraphBuilder.Hops(bp.s.KeyPair.Id, bp.hops)
+		if peers == nil {
+			return false
+		}
+		bp.peers, bp.peersAt = peers, time.Now()
+	}
+	return bp.peers.Has(fr)
+}
+
+func (s *Sbot) startBlobPush() error {
+	bp := s.blobPush
+	log := kitlog.With(s.info, "module", "blobPush")
+
+	uf, ok := s.GetMultiLog(multilogs.IndexNameFeeds)
+	if !ok {
+		return errors.Errorf("sbot: no userFeeds index for blob push")
+	}
+	ownLog, err := uf.Get(s.KeyPair.Id.StoredAddr())
+	if err != nil {
+		return errors.Wrap(err, "sbot: failed to open own sublog for blob push")
+	}
+
+	s.BlobStore.Changes().Register(luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error {
+		if err != nil {
+			return nil
+		}
+		n, ok := v.(ssb.BlobStoreNotification)
+		if !ok || n.Op != ssb.BlobStoreOpPut {
+			return nil
+		}
+		bp.mu.Lock()
+		_, mentioned := bp.recent[n.Ref.Ref()]
+		bp.mu.Unlock()
+		if mentioned {
+			bp.enqueue(n.Ref)
+		}
+		return nil
+	}))
+
+	go func() {
+		if err := bp.watchOwnMessages(s.rootCtx, ownLog); err != nil && s.rootCtx.Err() == nil {
+			level.Warn(log).Log("event", "stopped watching own messages", "err", err)
+		}
+	}()
+	go bp.serve(s.rootCtx, log)
+	return nil
+}
+
+// watchOwnMessages reads the links of our existing messages and then waits for new ones.
+// New messages that link to blobs we already have queue them right away.
+func (bp *blobPusher) watchOwnMessages(ctx context.Context, ownLog margaret.Log) error {
+	own := mutil.Indirect(bp.s.RootLog, ownLog)
+
+	current, err := ownLog.Seq().Value()
+	if err != nil {
+		return errors.Wrap(err, "failed to get own sequence")
+	}
+	var last margaret.Seq = margaret.SeqEmpty
+	if cs, ok := current.(margaret.Seq); ok && cs.Seq() >= 0 {
+		from := cs.Seq() - blobPushRecent
+		if from < -1 {
+			from = -1
+		}
+		src, err := own.Query(margaret.Gt(margaret.BaseSeq(from)), margaret.Limit(blobPushRecent))
+		if err != nil {
+			return errors.Wrap(err, "failed to query own messages")
+		}
+		if err := bp.drain(ctx, src, false); err != nil {
+			return err
+		}
+		last = cs
+	} else if _, unset := current.(librarian.UnsetValue); !unset {
+		return errors.Errorf("unexpected own sequence %T", current)
+	}
+
+	src, err := own.Query(margaret.Gt(last), margaret.Live(true))
+	if err != nil {
+		return errors.Wrap(err, "failed to query new own messages")
+	}
+	return bp.drain(ctx, src, true)
+}
+
+func (bp *blobPusher) drain(ctx context.Context, src luigi.Source, live bool) error {
+	for {
+		v, err := src.Next(ctx)
+		if luigi.IsEOS(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		msg, ok := v.(ssb.Message)
+		if !ok {
+			continue
+		}
+		for _, r := range backlinks.ExtractRefs(msg.ContentBytes()) {
+			br, ok := r.(*ssb.BlobRef)
+			if !ok {
+				continue
+			}
+			bp.mention(br, msg.Seq())
+			if _, err := bp.s.BlobStore.Size(br); live && err == nil {
+				bp.enqueue(br)
+			}
+		}
+	}
+}
+
+// mention remembers that our message seq links to ref and forgets the links of messages that aren't recent anymore
+func (bp *blobPusher) mention(ref *ssb.BlobRef, seq int64) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	bp.recent[ref.Ref()] = seq
+	if seq <= bp.latest {
+		return
+	}
+	bp.latest = seq
+	for r, s := range bp.recent {
+		if s <= bp.latest-blobPushRecent {
+			delete(bp.recent, r)
+		}
+	}
+}
+
+func (bp *blobPusher) enqueue(ref *ssb.BlobRef) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	if _, has := bp.queued[ref.Ref()]; has {
+		return
+	}
+	bp.queued[ref.Ref()] = struct{}{}
+	bp.queue = append(bp.queue, ref)
+}
+
+func (bp *blobPusher) next() *ssb.BlobRef {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	if len(bp.queue) == 0 {
+		return nil
+	}
+	ref := bp.queue[0]
+	bp.queue = bp.queue[1:]
+	delete(bp.queued, ref.Ref())
+	return ref
+}
+
+// serve announces one queued blob per interval until ctx is done
+func (bp *blobPusher) serve(ctx context.Context, log kitlog.Logger) {
+	tick := time.NewTicker(bp.interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+
+		ref := bp.next()
+		if ref == nil {
+			continue
+		}
+		n, err := bp.s.WantManager.Announce(ref, bp.accepts)
+		if err != nil {
+			level.Warn(log).Log("event", "announce failed", "ref", ref.ShortRef(), "err", err)
+			continue
+		}
+		level.Debug(log).Log("event", "announced", "ref", ref.ShortRef(), "peers", n)
+	}
+}
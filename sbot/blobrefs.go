@@ -0,0 +1,44 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"github.com/pkg/errors"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/multilogs"
+	"go.cryptoscope.co/ssb/plugins/blobs"
+	"go.cryptoscope.co/ssb/plugins2/backlinks"
+)
+
+var _ blobs.Referrers = (*Sbot)(nil)
+
+// BlobReferrers returns the keys of the messages that link to ref, in mentions, about images or any other content field.
+// Messages that were deleted or had their content nulled don't count.
+func (s *Sbot) BlobReferrers(ref *ssb.BlobRef) ([]*ssb.MessageRef, error) {
+	refs, has := s.mlogIndicies[multilogs.IndexNameBlobRefs]
+	if !has {
+		return nil, errors.Errorf("sbot: no %s index", multilogs.IndexNameBlobRefs)
+	}
+	return multilogs.BlobReferrers(s.rootCtx, s.RootLog, refs, ref)
+}
+
+// BlobOrphans returns the stored blobs that no message links to, for cleaning them up by hand
+func (s *Sbot) BlobOrphans() ([]*ssb.BlobRef, error) {
+	refs, has := s.mlogIndicies[multilogs.IndexNameBlobRefs]
+	if !has {
+		return nil, errors.Errorf("sbot: no %s index", multilogs.IndexNameBlobRefs)
+	}
+	orphans, err := multilogs.BlobOrphans(s.rootCtx, s.RootLog, refs, s.BlobStore)
+	return orphans, errors.Wrap(err, "sbot: failed to find orphaned blobs")
+}
+
+// linksTo returns true if the content of msg still links to ref
+func linksTo(msg ssb.Message, ref *ssb.BlobRef) bool {
+	for _, r := range backlinks.ExtractRefs(msg.ContentBytes()) {
+		if br, ok := r.(*ssb.BlobRef); ok && br.Equal(ref) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/testutils"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+func TestBlobReferrers(t *testing.T) {
+	r := require.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+
+	// nulling content only works for gabby grove feeds
+	kpBert, err := repo.NewKeyPair(repo.New(tRepoPath), "bert", ssb.RefAlgoFeedGabby)
+	r.NoError(err)
+
+	bot, err := New(
+		WithInfo(testutils.NewRelativeTimeLogger(nil)),
+		WithRepoPath(tRepoPath),
+		DisableNetworkNode(),
+	)
+	r.NoError(err)
+	defer func() {
+		bot.Shutdown()
+		r.NoError(bot.Close())
+	}()
+
+	img, err := bot.BlobStore.Put(strings.NewReader("a picture"))
+	r.NoError(err)
+	unused, err := bot.BlobStore.Put(strings.NewReader("nobody links to this"))
+	r.NoError(err)
+
+	post, err := bot.PublishAs("bert", map[string]interface{}{
+		"type":     "post",
+		"text":     "look",
+		"mentions": []interface{}{map[string]interface{}{"link": img.Ref()}},
+	})
+	r.NoError(err)
+	about, err := bot.PublishAs("bert", map[string]interface{}{
+		"type":  "about",
+		"about": kpBert.Id.Ref(),
+		"image": img.Ref(),
+	})
+	r.NoError(err)
+
+	referrers := func() []string {
+		refs, err := bot.BlobReferrers(img)
+		r.NoError(err)
+		var keys []string
+		for _, ref := range refs {
+			keys = append(keys, ref.Ref())
+		}
+		return keys
+	}
+	r.Eventually(func() bool { return len(referrers()) == 2 }, 5*time.Second, 10*time.Millisecond)
+	r.Equal([]string{post.Ref(), about.Ref()}, referrers())
+
+	orphans, err := bot.BlobOrphans()
+	r.NoError(err)
+	r.Len(orphans, 1)
+	r.True(orphans[0].Equal(unused))
+
+	// the post doesn't count anymore once its content is gone
+	r.NoError(bot.NullContent(kpBert.Id, 1))
+	r.Equal([]string{about.Ref()}, referrers())
+
+	r.NoError(bot.NullContent(kpBert.Id, 2))
+	r.Empty(referrers())
+	orphans, err = bot.BlobOrphans()
+	r.NoError(err)
+	r.Len(orphans, 2)
+}
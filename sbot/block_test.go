@@ -0,0 +1,127 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/testutils"
+)
+
+// ali blocks bob, who can't get ali's new messages from her any more while carl still can
+func TestBlockedPeerGetsNothing(t *testing.T) {
+	r := require.New(t)
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	os.RemoveAll(filepath.Join("testrun", t.Name()))
+
+	appKey := make([]byte, 32)
+	rand.Read(appKey)
+
+	botgroup, ctx := errgroup.WithContext(ctx)
+	mainLog := testutils.NewRelativeTimeLogger(nil)
+
+	start := func(name string) *Sbot {
+		bot, err := New(
+			WithAppKey(appKey),
+			WithContext(ctx),
+			WithInfo(log.With(mainLog, "unit", name)),
+			WithRepoPath(filepath.Join("testrun", t.Name(), name)),
+			WithListenAddr(":0"),
+		)
+		r.NoError(err)
+		botgroup.Go(func() error {
+			err := bot.Network.Serve(ctx)
+			if err == context.Canceled {
+				return nil
+			}
+			return err
+		})
+		return bot
+	}
+
+	ali := start("ali")
+	bob := start("bob")
+	carl := start("carl")
+
+	contact := func(bot *Sbot, who *ssb.FeedRef, following, blocking bool) {
+		_, err := bot.PublishLog.Publish(map[string]interface{}{
+			"type":      "contact",
+			"contact":   who.Ref(),
+			"following": following,
+			"blocking":  blocking,
+		})
+		r.NoError(err)
+	}
+	contact(ali, bob.KeyPair.Id, true, false)
+	contact(ali, carl.KeyPair.Id, true, false)
+	contact(bob, ali.KeyPair.Id, true, false)
+	contact(carl, ali.KeyPair.Id, true, false)
+	bob.Replicate(ali.KeyPair.Id)
+	carl.Replicate(ali.KeyPair.Id)
+
+	// the sequence of ali's feed on bot, -1 if it has none of it
+	alisSeq := func(bot *Sbot) int64 {
+		uf, ok := bot.GetMultiLog("userFeeds")
+		r.True(ok)
+		alisLog, err := uf.Get(ali.KeyPair.Id.StoredAddr())
+		r.NoError(err)
+		sv, err := alisLog.Seq().Value()
+		r.NoError(err)
+		return sv.(margaret.Seq).Seq()
+	}
+
+	// connects bot to ali until it has ali's feed up to want, at most tries times
+	fetchAli := func(bot *Sbot, want int64, tries int) int64 {
+		var got int64
+		for i := 0; i < tries; i++ {
+			bot.Network.Connect(ctx, ali.Network.GetListenAddr())
+			time.Sleep(500 * time.Millisecond)
+			bot.Network.GetConnTracker().CloseAll()
+			if got = alisSeq(bot); got >= want {
+				break
+			}
+		}
+		return got
+	}
+
+	publish := func(text string) int64 {
+		_, err := ali.PublishLog.Publish(map[string]interface{}{"type": "test", "text": text})
+		r.NoError(err)
+		ali.WaitUntilIndexesAreSynced()
+		return alisSeq(ali)
+	}
+
+	seq := publish("before")
+	r.Equal(seq, fetchAli(bob, seq, 20), "bob didn't get ali's feed before the block")
+
+	contact(ali, bob.KeyPair.Id, false, true)
+	seq = publish("after the block")
+
+	r.True(fetchAli(bob, seq, 5) < seq, "bob got ali's messages after the block")
+	r.Equal(seq, fetchAli(carl, seq, 20), "carl didn't get ali's messages")
+
+	// unblocking needs no restart
+	contact(ali, bob.KeyPair.Id, true, false)
+	seq = publish("after the unblock")
+	r.Equal(seq, fetchAli(bob, seq, 20), "bob didn't get ali's messages after the unblock")
+
+	cancel()
+	for _, bot := range []*Sbot{ali, bob, carl} {
+		bot.Shutdown()
+		r.NoError(bot.Close())
+	}
+	r.NoError(botgroup.Wait())
+}
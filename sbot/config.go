@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"encoding/base64"
+	"net"
+	"sort"
+
+	"go.cryptoscope.co/ssb"
+)
+
+var _ ssb.Configurer = (*Sbot)(nil)
+
+// Config returns the options the bot runs with, see ssb.Config
+func (s *Sbot) Config() (ssb.Config, error) {
+	cfg := ssb.Config{
+		Feed:        s.KeyPair.Id.Ref(),
+		Repo:        s.repoPath,
+		ReadOnly:    s.readOnly,
+		NetworkKey:  base64.StdEncoding.EncodeToString(s.appKey),
+		HMACSigning: s.signHMACsecret != nil,
+		SOCKS5Proxy: s.socks5Proxy,
+		Discovery:   s.enableDiscovery,
+		Adverts:     s.enableAdverts,
+		Listen:      []string{},
+		Replication: ssb.ReplicationConfig{
+			Hops:              s.hopCount,
+			Promisc:           s.promisc,
+			Strangers:         s.strangers,
+			EBT:               s.enableEBT,
+			DeleteBlocked:     s.deleteBlocked,
+			ScheduleHops:      s.scheduleWeights.Hops,
+			ScheduleLag:       s.scheduleWeights.Lag,
+			ScheduleFairEvery: s.scheduleWeights.FairEvery,
+		},
+	}
+	if !s.disableNetwork {
+		// the addresses the listeners got once they run, a port of 0 is chosen then
+		var bound []net.Addr
+		if ba, ok := s.Network.(interface{ BoundAddrs() []net.Addr }); ok {
+			bound = ba.BoundAddrs()
+		}
+		if len(bound) == 0 {
+			if s.listenAddr != nil {
+				bound = append(bound, s.listenAddr)
+			}
+			bound = append(bound, s.extraListenAddrs...)
+		}
+		for _, a := range bound {
+			cfg.Listen = append(cfg.Listen, a.String())
+		}
+		if s.wsAddr != nil {
+			cfg.WebSocket = s.wsAddr.String()
+		}
+	}
+	if s.connManager != nil {
+		cfg.MaxConns = s.connManager.State().Max
+	}
+
+	for from, p := range s.hopPolicies {
+		cfg.Replication.HopPolicies = append(cfg.Replication.HopPolicies, ssb.HopPolicyConfig{
+			From:  from,
+			Skip:  p.Skip,
+			Types: p.Types,
+		})
+	}
+	sort.Slice(cfg.Replication.HopPolicies, func(i, j int) bool {
+		return cfg.Replication.HopPolicies[i].From < cfg.Replication.HopPolicies[j].From
+	})
+	return cfg, nil
+}
@@ -0,0 +1,69 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/ssb/internal/testutils"
+)
+
+func TestConfigHasNoSecrets(t *testing.T) {
+	r := require.New(t)
+
+	os.RemoveAll(filepath.Join("testrun", t.Name()))
+
+	appKey := bytes.Repeat([]byte{1}, 32)
+	hmacKey := bytes.Repeat([]byte{2}, 32)
+	bot, err := New(
+		WithAppKey(appKey),
+		WithHMACSigning(hmacKey),
+		WithInfo(testutils.NewRelativeTimeLogger(nil)),
+		WithRepoPath(filepath.Join("testrun", t.Name())),
+		WithListenAddr(":0"),
+		WithSOCKS5Proxy("localhost:9050", false),
+		WithHopPolicy(2, HopPolicy{Types: []string{"contact"}}),
+		WithHopPolicy(3, HopPolicy{Skip: true}),
+	)
+	r.NoError(err)
+
+	cfg, err := bot.Config()
+	r.NoError(err)
+	r.Equal(bot.KeyPair.Id.Ref(), cfg.Feed)
+	r.Equal(base64.StdEncoding.EncodeToString(appKey), cfg.NetworkKey)
+	r.True(cfg.HMACSigning)
+	r.Equal([]string{":0"}, cfg.Listen)
+	r.Len(cfg.Replication.HopPolicies, 2)
+	r.EqualValues(2, cfg.Replication.HopPolicies[0].From)
+	r.True(cfg.Replication.HopPolicies[1].Skip)
+	r.Equal(DefaultScheduleWeights.Hops, cfg.Replication.ScheduleHops)
+
+	js, err := json.Marshal(cfg)
+	r.NoError(err)
+	r.NotContains(string(js), base64.StdEncoding.EncodeToString(hmacKey))
+	r.NotContains(string(js), base64.StdEncoding.EncodeToString(bot.KeyPair.Pair.Secret[:]))
+
+	// once it listens, the port it got
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- bot.Network.Serve(ctx) }()
+	lisAddr := bot.Network.GetListenAddr()
+	r.NotNil(lisAddr)
+	cfg, err = bot.Config()
+	r.NoError(err)
+	r.Equal([]string{lisAddr.String()}, cfg.Listen)
+	r.NotEqual(":0", cfg.Listen[0])
+
+	cancel()
+	<-served
+	bot.Shutdown()
+	r.NoError(bot.Close())
+}
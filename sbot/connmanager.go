@@ -0,0 +1,307 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/librarian"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/netwrap"
+	"go.cryptoscope.co/secretstream"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/graph"
+	"go.cryptoscope.co/ssb/internal/mutil"
+	"go.cryptoscope.co/ssb/multilogs"
+	"go.cryptoscope.co/ssb/network"
+)
+
+// WithConnManager lets the bot limit its connections and dial known peers on its own.
+// The addresses come from pub messages (if the msgTypes index is mounted), local discovery and ctrl.connect.
+// Sticky peers are never disconnected to make room for others, ctrl.sticky changes that at runtime.
+func WithConnManager(sticky []*ssb.FeedRef, opts ...network.ConnManagerOption) Option {
+	return func(s *Sbot) error {
+		s.connManagerOpts = opts
+		s.connManagerSticky = sticky
+		s.enableConnManager = true
+		return nil
+	}
+}
+
+// newConnManager sets the manager up as the connection tracker of the network
+func (s *Sbot) newConnManager() error {
+	if s.networkConnTracker != nil {
+		return errors.New("sbot: WithConnManager can't be used with a custom ConnTracker")
+	}
+	opts := append([]network.ConnManagerOption{
+		network.WithConnLogger(kitlog.With(s.info, "module", "connmanager")),
+		network.WithLag(s.feedLag),
+	}, s.connManagerOpts...)
+	cm, err := network.NewConnManager(opts...)
+	if err != nil {
+		return errors.Wrap(err, "sbot: failed to create connection manager")
+	}
+	for _, ref := range s.connManagerSticky {
+		cm.SetSticky(ref, true)
+	}
+	s.connManager = cm
+	s.networkConnTracker = cm
+	s.postSecureWrappers = append(s.postSecureWrappers, cm.WrapConn)
+	return nil
+}
+
+// startConnManager dials with the network and feeds the addresses of pub messages to the manager
+func (s *Sbot) startConnManager() {
+	log := kitlog.With(s.info, "module", "connmanager")
+	go func() {
+		err := s.connManager.Serve(s.rootCtx, s.Network.Connect)
+		if err != nil && s.rootCtx.Err() == nil {
+			level.Warn(log).Log("event", "dialing stopped", "err", err)
+		}
+	}()
+
+	mt, ok := s.mlogIndicies["msgTypes"]
+	if !ok {
+		level.Debug(log).Log("msg", "no msgTypes index, not dialing announced pubs")
+		return
+	}
+	hops := newHopsCache(s.GraphBuilder, s.KeyPair.Id, int(s.hopCount))
+	for tipe, parse := range map[string]func(ssb.Message) (*announcedPub, error){
+		"pub":     pubMessageAddress,
+		"address": addressMessageAddress,
+	} {
+		msgs, err := mt.Get(librarian.Addr(tipe))
+		if err != nil {
+			level.Warn(log).Log("event", "failed to open announcements", "type", tipe, "err", err)
+			return
+		}
+		go func(tipe string, msgs margaret.Log, parse func(ssb.Message) (*announcedPub, error)) {
+			err := s.watchPubs(s.rootCtx, mutil.Indirect(s.RootLog, msgs), parse, hops)
+			if err != nil && s.rootCtx.Err() == nil {
+				level.Warn(log).Log("event", "stopped watching announcements", "type", tipe, "err", err)
+			}
+		}(tipe, msgs, parse)
+	}
+}
+
+// watchPubs gives the addresses of pub announcements by feeds within our hops to the connection manager.
+// Addresses on private networks are left out unless WithPrivatePubAddresses allows them.
+func (s *Sbot) watchPubs(ctx context.Context, pubs margaret.Log, parse func(ssb.Message) (*announcedPub, error), hops *hopsCache) error {
+	src, err := pubs.Query(margaret.Live(true))
+	if err != nil {
+		return errors.Wrap(err, "failed to query pub messages")
+	}
+	for {
+		v, err := src.Next(ctx)
+		if luigi.IsEOS(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		msg, ok := v.(ssb.Message)
+		if !ok {
+			continue
+		}
+		addr, err := parse(msg)
+		if err != nil {
+			continue
+		}
+		if addr.feed.Equal(s.KeyPair.Id) {
+			continue
+		}
+		if err := checkPubAddress(addr, s.allowPrivatePubAddrs); err != nil {
+			continue
+		}
+		if !hops.Has(msg.Author()) {
+			continue
+		}
+		s.connManager.AddAddress(addr.wrapped, "pub")
+	}
+}
+
+type announcedPub struct {
+	feed    *ssb.FeedRef
+	addr    net.Addr // without the key
+	wrapped net.Addr
+}
+
+// pubMessageAddress reads the address of a type:pub message, which anyone can publish about a pub, like after using an invite
+func pubMessageAddress(msg ssb.Message) (*announcedPub, error) {
+	return pubAddress(msg.ContentBytes())
+}
+
+// addressMessageAddress reads {type: address, address: net:host:port~shs:key}, which a peer publishes about itself
+func addressMessageAddress(msg ssb.Message) (*announcedPub, error) {
+	var content struct {
+		Address string `json:"address"`
+	}
+	if err := json.Unmarshal(msg.ContentBytes(), &content); err != nil {
+		return nil, err
+	}
+	wrapped, err := network.ParseAddress(content.Address)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ssb.GetFeedRefFromAddr(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	if !key.Equal(msg.Author()) {
+		return nil, errors.Errorf("address of %s announced by %s", key.ShortRef(), msg.Author().ShortRef())
+	}
+	addr := netwrap.GetAddr(wrapped, "tcp")
+	if addr == nil {
+		addr = netwrap.GetAddr(wrapped, "onion")
+	}
+	if addr == nil {
+		return nil, errors.Errorf("unsupported address %q", content.Address)
+	}
+	return &announcedPub{feed: key, addr: addr, wrapped: wrapped}, nil
+}
+
+// pubAddress reads {type: pub, address: {host, port, key}}
+func pubAddress(content []byte) (*announcedPub, error) {
+	var pub struct {
+		Address struct {
+			Host string       `json:"host"`
+			Port int          `json:"port"`
+			Key  *ssb.FeedRef `json:"key"`
+		} `json:"address"`
+	}
+	if err := json.Unmarshal(content, &pub); err != nil {
+		return nil, err
+	}
+	a := pub.Address
+	if a.Host == "" || a.Port <= 0 || a.Key == nil {
+		return nil, errors.New("incomplete pub address")
+	}
+
+	// names are resolved when they are dialed, by the SOCKS5 proxy if there is one
+	var addr net.Addr
+	if ip := net.ParseIP(a.Host); ip != nil {
+		addr = &net.TCPAddr{IP: ip, Port: a.Port}
+	} else if strings.HasSuffix(a.Host, ".onion") {
+		addr = &network.HostAddr{Net: "onion", Host: a.Host, Port: a.Port}
+	} else {
+		addr = &network.HostAddr{Net: "tcp", Host: a.Host, Port: a.Port}
+	}
+	return &announcedPub{
+		feed:    a.Key,
+		addr:    addr,
+		wrapped: netwrap.WrapAddr(addr, secretstream.Addr{PubKey: a.Key.PubKey()}),
+	}, nil
+}
+
+// checkPubAddress rejects ports out of range and, unless allowPrivate, addresses that can't be reached from the internet
+func checkPubAddress(ap *announcedPub, allowPrivate bool) error {
+	var (
+		host string
+		port int
+	)
+	switch a := ap.addr.(type) {
+	case *net.TCPAddr:
+		if !allowPrivate && !isPublicIP(a.IP) {
+			return errors.Errorf("pub address %s is not public", a)
+		}
+		host, port = a.IP.String(), a.Port
+	case *network.HostAddr:
+		if !allowPrivate && !a.IsOnion() && (a.Host == "localhost" || strings.HasSuffix(a.Host, ".local") || !strings.Contains(a.Host, ".")) {
+			return errors.Errorf("pub address %s is not public", a)
+		}
+		host, port = a.Host, a.Port
+	default:
+		return errors.Errorf("unsupported pub address %s", ap.addr)
+	}
+	if host == "" || port <= 0 || port > 65535 {
+		return errors.Errorf("invalid pub address %s", ap.addr)
+	}
+	return nil
+}
+
+var privateNets = []*net.IPNet{
+	{IP: net.IPv4(10, 0, 0, 0), Mask: net.CIDRMask(8, 32)},
+	{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}, // carrier-grade NAT
+	{IP: net.IPv4(172, 16, 0, 0), Mask: net.CIDRMask(12, 32)},
+	{IP: net.IPv4(192, 168, 0, 0), Mask: net.CIDRMask(16, 32)},
+	{IP: net.ParseIP("fc00::"), Mask: net.CIDRMask(7, 128)}, // unique local
+}
+
+// isPublicIP tells if ip is a unicast address that isn't on a private network
+func isPublicIP(ip net.IP) bool {
+	if !ip.IsGlobalUnicast() {
+		return false // loopback, link local, multicast and unspecified
+	}
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// hopsCache is the set of feeds within hops, built again once it is a minute old
+type hopsCache struct {
+	b    graph.Builder
+	self *ssb.FeedRef
+	hops int
+
+	mu    sync.Mutex
+	set   *ssb.StrFeedSet
+	built time.Time
+}
+
+func newHopsCache(b graph.Builder, self *ssb.FeedRef, hops int) *hopsCache {
+	return &hopsCache{b: b, self: self, hops: hops}
+}
+
+func (hc *hopsCache) Has(ref *ssb.FeedRef) bool {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	if hc.set == nil || time.Since(hc.built) > time.Minute {
+		hc.set = hc.b.Hops(hc.self, hc.hops)
+		hc.built = time.Now()
+	}
+	return hc.set != nil && hc.set.Has(ref)
+}
+
+// feedLag is how long ago the newest message we have of ref was received.
+// Feeds we have nothing of are the furthest behind.
+func (s *Sbot) feedLag(ref *ssb.FeedRef) time.Duration {
+	const nothingYet = time.Duration(1<<63 - 1)
+
+	uf, ok := s.mlogIndicies[multilogs.IndexNameFeeds]
+	if !ok {
+		return 0
+	}
+	sub, err := uf.Get(ref.StoredAddr())
+	if err != nil {
+		return 0
+	}
+	v, err := sub.Seq().Value()
+	if err != nil {
+		return 0
+	}
+	seq, ok := v.(margaret.Seq)
+	if !ok || seq.Seq() < 0 {
+		return nothingYet
+	}
+	mv, err := mutil.Indirect(s.RootLog, sub).Get(seq)
+	if err != nil {
+		return 0
+	}
+	msg, ok := mv.(ssb.Message)
+	if !ok {
+		return 0
+	}
+	return time.Since(msg.Received())
+}
@@ -0,0 +1,26 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import "go.cryptoscope.co/ssb"
+
+// discoveryFilter only lets the network dial peers from the local network that we would also accept connections from.
+// Promiscuous bots dial everyone.
+func (s *Sbot) discoveryFilter() func(*ssb.FeedRef) bool {
+	if s.promisc {
+		return nil
+	}
+	return func(remote *ssb.FeedRef) bool {
+		auth := s.authorizer
+		if auth == nil {
+			auth = s.Replicator.Lister()
+		}
+		if auth.Authorize(remote) == nil {
+			return true
+		}
+		// like in the handler, shs1 doesn't tell us the feed format
+		gg := *remote
+		gg.Algo = ssb.RefAlgoFeedGabby
+		return auth.Authorize(&gg) == nil
+	}
+}
@@ -0,0 +1,278 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/testutils"
+)
+
+// bob and ali replicate over ebt, which also brings new messages while they stay connected.
+// carl doesn't know ebt, ali falls back to createHistoryStream with him in both directions.
+func TestEBTReplication(t *testing.T) {
+	r := require.New(t)
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	os.RemoveAll(filepath.Join("testrun", t.Name()))
+
+	appKey := make([]byte, 32)
+	rand.Read(appKey)
+
+	botgroup, ctx := errgroup.WithContext(ctx)
+	mainLog := testutils.NewRelativeTimeLogger(nil)
+
+	start := func(name string, withEBT bool) *Sbot {
+		bot, err := New(
+			WithAppKey(appKey),
+			WithContext(ctx),
+			WithInfo(log.With(mainLog, "unit", name)),
+			WithRepoPath(filepath.Join("testrun", t.Name(), name)),
+			WithListenAddr(":0"),
+			EnableEBT(withEBT),
+		)
+		r.NoError(err)
+		botgroup.Go(func() error {
+			err := bot.Network.Serve(ctx)
+			if err == context.Canceled {
+				return nil
+			}
+			return err
+		})
+		return bot
+	}
+
+	ali := start("ali", true)
+	bob := start("bob", true)
+	carl := start("carl", false)
+
+	follow := func(bot *Sbot, who *ssb.FeedRef) {
+		_, err := bot.PublishLog.Publish(map[string]interface{}{
+			"type":      "contact",
+			"contact":   who.Ref(),
+			"following": true,
+		})
+		r.NoError(err)
+		bot.Replicate(who)
+	}
+	follow(ali, bob.KeyPair.Id)
+	follow(ali, carl.KeyPair.Id)
+	follow(bob, ali.KeyPair.Id)
+	follow(carl, ali.KeyPair.Id)
+
+	// the sequence of who's feed on bot, 0 if it has none of it
+	seqOf := func(bot *Sbot, who *ssb.FeedRef) int64 {
+		uf, ok := bot.GetMultiLog("userFeeds")
+		r.True(ok)
+		l, err := uf.Get(who.StoredAddr())
+		r.NoError(err)
+		sv, err := l.Seq().Value()
+		r.NoError(err)
+		return sv.(margaret.Seq).Seq() + 1
+	}
+
+	// long enough for the dialed side to give up waiting for ebt.replicate
+	waitFor := func(bot *Sbot, who *ssb.FeedRef, want int64) bool {
+		for i := 0; i < 200; i++ {
+			if seqOf(bot, who) >= want {
+				return true
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		return false
+	}
+
+	publish := func(bot *Sbot, n int) int64 {
+		for i := 0; i < n; i++ {
+			_, err := bot.PublishLog.Publish(map[string]interface{}{"type": "test", "i": i})
+			r.NoError(err)
+		}
+		return seqOf(bot, bot.KeyPair.Id)
+	}
+
+	aliSeq := publish(ali, 10)
+	bobSeq := publish(bob, 3)
+
+	err := bob.Network.Connect(ctx, ali.Network.GetListenAddr())
+	r.NoError(err)
+	r.True(waitFor(bob, ali.KeyPair.Id, aliSeq), "bob didn't get ali's feed")
+	r.True(waitFor(ali, bob.KeyPair.Id, bobSeq), "ali didn't get bob's feed")
+
+	// no new connection needed
+	aliSeq = publish(ali, 5)
+	r.True(waitFor(bob, ali.KeyPair.Id, aliSeq), "bob didn't get ali's new messages")
+	bobSeq = publish(bob, 2)
+	r.True(waitFor(ali, bob.KeyPair.Id, bobSeq), "ali didn't get bob's new messages")
+	bob.Network.GetConnTracker().CloseAll()
+
+	carlSeq := publish(carl, 4)
+	err = carl.Network.Connect(ctx, ali.Network.GetListenAddr())
+	r.NoError(err)
+	r.True(waitFor(carl, ali.KeyPair.Id, aliSeq), "carl didn't get ali's feed")
+	r.True(waitFor(ali, carl.KeyPair.Id, carlSeq), "ali didn't get carl's feed")
+
+	cancel()
+	for _, bot := range []*Sbot{ali, bob, carl} {
+		bot.Shutdown()
+		r.NoError(bot.Close())
+	}
+	r.NoError(botgroup.Wait())
+}
+
+// ali talks ebt with bob and createHistoryStream with carl at the same time.
+// dan's feed comes from both, but nothing is fetched with createHistoryStream over the connection with bob.
+func TestEBTMixedMode(t *testing.T) {
+	r := require.New(t)
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	os.RemoveAll(filepath.Join("testrun", t.Name()))
+
+	appKey := make([]byte, 32)
+	rand.Read(appKey)
+
+	botgroup, ctx := errgroup.WithContext(ctx)
+	mainLog := testutils.NewRelativeTimeLogger(nil)
+
+	start := func(name string, withEBT bool) *Sbot {
+		bot, err := New(
+			WithAppKey(appKey),
+			WithContext(ctx),
+			WithInfo(log.With(mainLog, "unit", name)),
+			WithRepoPath(filepath.Join("testrun", t.Name(), name)),
+			WithListenAddr(":0"),
+			EnableEBT(withEBT),
+		)
+		r.NoError(err)
+		botgroup.Go(func() error {
+			err := bot.Network.Serve(ctx)
+			if err == context.Canceled {
+				return nil
+			}
+			return err
+		})
+		return bot
+	}
+
+	ali := start("ali", true)
+	bob := start("bob", true)
+	carl := start("carl", false)
+	dan := start("dan", true)
+
+	follow := func(bot *Sbot, who *ssb.FeedRef) {
+		_, err := bot.PublishLog.Publish(map[string]interface{}{
+			"type":      "contact",
+			"contact":   who.Ref(),
+			"following": true,
+		})
+		r.NoError(err)
+		bot.Replicate(who)
+	}
+	follow(ali, bob.KeyPair.Id)
+	follow(ali, carl.KeyPair.Id)
+	follow(ali, dan.KeyPair.Id)
+	follow(bob, ali.KeyPair.Id)
+	follow(bob, dan.KeyPair.Id)
+	follow(carl, ali.KeyPair.Id)
+	follow(carl, dan.KeyPair.Id)
+	follow(dan, bob.KeyPair.Id)
+	follow(dan, carl.KeyPair.Id)
+
+	seqOf := func(bot *Sbot, who *ssb.FeedRef) int64 {
+		uf, ok := bot.GetMultiLog("userFeeds")
+		r.True(ok)
+		l, err := uf.Get(who.StoredAddr())
+		r.NoError(err)
+		sv, err := l.Seq().Value()
+		r.NoError(err)
+		return sv.(margaret.Seq).Seq() + 1
+	}
+	waitFor := func(bot *Sbot, who *ssb.FeedRef, want int64) bool {
+		for i := 0; i < 200; i++ {
+			if seqOf(bot, who) >= want {
+				return true
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		return false
+	}
+
+	for i := 0; i < 20; i++ {
+		_, err := dan.PublishLog.Publish(map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+	}
+	danSeq := seqOf(dan, dan.KeyPair.Id)
+
+	// bob and carl get dan's feed first
+	r.NoError(bob.Network.Connect(ctx, dan.Network.GetListenAddr()))
+	r.NoError(carl.Network.Connect(ctx, dan.Network.GetListenAddr()))
+	r.True(waitFor(bob, dan.KeyPair.Id, danSeq), "bob didn't get dan's feed")
+	r.True(waitFor(carl, dan.KeyPair.Id, danSeq), "carl didn't get dan's feed")
+	bob.Network.GetConnTracker().CloseAll()
+	carl.Network.GetConnTracker().CloseAll()
+
+	// the createHistoryStream fetches of ali, by the peer they went to
+	evts, stop := ali.ReplicationEvents()
+	var legacyFrom = make(map[string]int)
+	gotEvents := make(chan struct{})
+	go func() {
+		for evt := range evts {
+			if evt.Event == ssb.ReplicationFeedStart {
+				legacyFrom[evt.Peer]++
+			}
+		}
+		close(gotEvents)
+	}()
+
+	r.NoError(ali.Network.Connect(ctx, bob.Network.GetListenAddr()))
+	r.NoError(ali.Network.Connect(ctx, carl.Network.GetListenAddr()))
+	r.True(waitFor(ali, dan.KeyPair.Id, danSeq), "ali didn't get dan's feed")
+	r.True(waitFor(ali, bob.KeyPair.Id, seqOf(bob, bob.KeyPair.Id)), "ali didn't get bob's feed")
+	r.True(waitFor(ali, carl.KeyPair.Id, seqOf(carl, carl.KeyPair.Id)), "ali didn't get carl's feed")
+
+	stop()
+	<-gotEvents
+	r.Zero(legacyFrom[bob.KeyPair.Id.Ref()], "fetched with createHistoryStream over the ebt connection")
+	r.NotZero(legacyFrom[carl.KeyPair.Id.Ref()], "carl doesn't know ebt")
+
+	cancel()
+	for _, bot := range []*Sbot{ali, bob, carl, dan} {
+		bot.Shutdown()
+	}
+
+	// bob and carl both sent dan's feed, ali has to have stored each message once
+	src, err := ali.RootLog.Query()
+	r.NoError(err)
+	var fromDan int64
+	for {
+		v, err := src.Next(context.TODO())
+		if luigi.IsEOS(err) {
+			break
+		}
+		r.NoError(err)
+		if msg, ok := v.(ssb.Message); ok && msg.Author().Equal(dan.KeyPair.Id) {
+			fromDan++
+			r.Equal(fromDan, msg.Seq(), "duplicate or missing message in the receive log")
+		}
+	}
+	r.Equal(danSeq, fromDan)
+	r.Equal(danSeq, seqOf(ali, dan.KeyPair.Id))
+
+	for _, bot := range []*Sbot{ali, bob, carl, dan} {
+		r.NoError(bot.Close())
+	}
+	r.NoError(botgroup.Wait())
+}
@@ -0,0 +1,195 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"context"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/muxrpc"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/network"
+)
+
+// DefaultFetchTimeout is how long FetchFeed tries if the call doesn't say
+const DefaultFetchTimeout = 30 * time.Second
+
+// how many of the known pubs FetchFeed dials with FetchOptions.Dial
+const fetchDials = 3
+
+// feedFetcher is the gossip plugin, it fetches a feed from one peer
+type feedFetcher interface {
+	Fetch(ctx context.Context, edp muxrpc.Endpoint, feed *ssb.FeedRef) error
+}
+
+// pendingFetch is a running FetchFeed, the calls for the same feed wait for it
+type pendingFetch struct {
+	done chan struct{}
+	res  ssb.FetchResult
+	err  error
+}
+
+var _ ssb.FeedFetcher = (*Sbot)(nil)
+
+// FetchFeed fetches feed from the connected peers right away, see ssb.FeedFetcher.
+// The feed is replicated while it runs, the peers that connect in that time fetch it too.
+func (s *Sbot) FetchFeed(ctx context.Context, feed *ssb.FeedRef, opts ssb.FetchOptions) (ssb.FetchResult, error) {
+	if s.fetcher == nil || s.Network == nil || s.Replicator == nil {
+		return ssb.FetchResult{}, errors.New("sbot: fetching feeds needs the network")
+	}
+	if s.Replicator.Lister().BlockList().Has(feed) {
+		return ssb.FetchResult{}, errors.Errorf("sbot: %s is blocked", feed.Ref())
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultFetchTimeout
+	}
+
+	s.fetchMu.Lock()
+	pf, running := s.fetches[feed.Ref()]
+	if !running {
+		pf = &pendingFetch{done: make(chan struct{})}
+		s.fetches[feed.Ref()] = pf
+		go func() {
+			pf.res, pf.err = s.fetchFeed(feed, opts)
+			s.fetchMu.Lock()
+			delete(s.fetches, feed.Ref())
+			s.fetchMu.Unlock()
+			close(pf.done)
+		}()
+	}
+	s.fetchMu.Unlock()
+
+	select {
+	case <-pf.done:
+		return pf.res, pf.err
+	case <-ctx.Done():
+		return ssb.FetchResult{}, ctx.Err()
+	}
+}
+
+// fetchFeed asks one connected peer after the other, until we have what they told us about or the timeout is over
+func (s *Sbot) fetchFeed(feed *ssb.FeedRef, opts ssb.FetchOptions) (ssb.FetchResult, error) {
+	log := kitlog.With(s.info, "event", "fetch", "fr", feed.ShortRef())
+	ctx, cancel := context.WithTimeout(s.rootCtx, opts.Timeout)
+	defer cancel()
+
+	res := ssb.FetchResult{Feed: *feed}
+	start, err := s.fetchedSeq(feed)
+	if err != nil {
+		return res, err
+	}
+
+	if !s.Replicator.Lister().ReplicationList().Has(feed) {
+		s.Replicator.Replicate(feed)
+		defer s.Replicator.DontReplicate(feed)
+	}
+
+	if opts.Dial {
+		s.dialPubs(ctx, log)
+	}
+
+	reached := func() bool {
+		remote := s.remoteSeq(feed)
+		latest, err := s.fetchedSeq(feed)
+		return err == nil && remote > 0 && latest >= remote
+	}
+
+	var (
+		asked  = make(map[string]bool)
+		failed int
+		tick   = time.NewTicker(time.Second)
+	)
+	defer tick.Stop()
+wait:
+	for !reached() {
+		for _, es := range s.Network.GetAllEndpoints() {
+			if asked[es.ID.Ref()] || ctx.Err() != nil {
+				continue
+			}
+			asked[es.ID.Ref()] = true
+			if err := s.fetcher.Fetch(ctx, es.Endpoint, feed); err != nil {
+				failed++
+				level.Debug(log).Log("msg", "peer failed", "peer", es.ID.ShortRef(), "err", err)
+			}
+			if reached() {
+				break wait
+			}
+		}
+
+		// without new peers to come, only a fetch that was already running can still get us there
+		if !opts.Dial && s.remoteSeq(feed) == 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			break wait
+		case <-tick.C:
+		}
+	}
+
+	res.Asked = len(asked)
+	res.Remote = s.remoteSeq(feed)
+	res.Latest, err = s.fetchedSeq(feed)
+	if err != nil {
+		return res, err
+	}
+	res.Received = res.Latest - start
+	if res.Remote > 0 {
+		res.Complete = res.Latest >= res.Remote
+	} else {
+		res.Complete = res.Asked > 0 && failed == 0
+	}
+	level.Debug(log).Log("received", res.Received, "latest", res.Latest, "remote", res.Remote, "asked", res.Asked)
+	return res, nil
+}
+
+// dialPubs connects to a few of the pubs the connection manager knows and we aren't connected to
+func (s *Sbot) dialPubs(ctx context.Context, log kitlog.Logger) {
+	if s.connManager == nil {
+		return
+	}
+	n := 0
+	for _, ka := range s.connManager.State().Known {
+		if n >= fetchDials {
+			return
+		}
+		if ka.Source != "pub" && ka.Source != "address" {
+			continue
+		}
+		if _, connected := s.Network.GetEndpointFor(ka.Feed); connected {
+			continue
+		}
+		n++
+		go func(ka network.KnownAddrState) {
+			if err := s.Network.Connect(ctx, ka.Addr); err != nil {
+				level.Debug(log).Log("msg", "failed to dial pub", "peer", ka.Feed.ShortRef(), "err", err)
+			}
+		}(ka)
+	}
+}
+
+// fetchedSeq is the latest message of feed we verified, stored or not
+func (s *Sbot) fetchedSeq(feed *ssb.FeedRef) (int64, error) {
+	if s.partial.IsPartial(feed) {
+		m, _ := s.partial.Get(feed)
+		return m.Seq, nil
+	}
+	_, msg, err := s.latestOf(feed)
+	if err != nil || msg == nil {
+		return 0, err
+	}
+	return msg.Seq(), nil
+}
+
+// remoteSeq is the latest message of feed a peer told us about, 0 if none did
+func (s *Sbot) remoteSeq(feed *ssb.FeedRef) int64 {
+	l, ok := s.Replicator.Lister().(*lister)
+	if !ok || l.sched == nil {
+		return 0
+	}
+	return l.sched.remoteSeq(feed)
+}
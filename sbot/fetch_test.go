@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/testutils"
+)
+
+// bob doesn't replicate ali but fetches her feed once, twice at the same time
+func TestFetchFeed(t *testing.T) {
+	r := require.New(t)
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	os.RemoveAll(filepath.Join("testrun", t.Name()))
+
+	appKey := make([]byte, 32)
+	rand.Read(appKey)
+
+	botgroup, ctx := errgroup.WithContext(ctx)
+	mainLog := testutils.NewRelativeTimeLogger(nil)
+
+	start := func(name string) *Sbot {
+		bot, err := New(
+			WithAppKey(appKey),
+			WithContext(ctx),
+			WithInfo(log.With(mainLog, "unit", name)),
+			WithRepoPath(filepath.Join("testrun", t.Name(), name)),
+			WithListenAddr(":0"),
+		)
+		r.NoError(err)
+		botgroup.Go(func() error {
+			err := bot.Network.Serve(ctx)
+			if err == context.Canceled {
+				return nil
+			}
+			return err
+		})
+		return bot
+	}
+
+	ali := start("ali")
+	bob := start("bob")
+
+	_, err := ali.PublishLog.Publish(map[string]interface{}{
+		"type":      "contact",
+		"contact":   bob.KeyPair.Id.Ref(),
+		"following": true,
+	})
+	r.NoError(err)
+	for i := 0; i < 4; i++ {
+		_, err := ali.PublishLog.Publish(map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+	}
+	ali.WaitUntilIndexesAreSynced()
+
+	r.NoError(bob.Network.Connect(ctx, ali.Network.GetListenAddr()))
+	for i := 0; i < 20 && len(bob.Network.GetAllEndpoints()) == 0; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	r.Len(bob.Network.GetAllEndpoints(), 1)
+
+	evts, stopEvts := bob.ReplicationEvents()
+	defer stopEvts()
+
+	var (
+		wg      sync.WaitGroup
+		results [2]ssb.FetchResult
+		errs    [2]error
+	)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = bob.FetchFeed(ctx, ali.KeyPair.Id, ssb.FetchOptions{Timeout: 10 * time.Second})
+		}(i)
+	}
+	wg.Wait()
+
+	// the second call usually waits for the first one, if it came too late it found nothing new
+	for i, res := range results {
+		r.NoError(errs[i])
+		r.True(res.Feed.Equal(ali.KeyPair.Id))
+		r.EqualValues(5, res.Latest)
+		r.True(res.Complete)
+	}
+	r.Contains([]int64{results[0].Received, results[1].Received}, int64(5))
+	r.False(bob.Replicator.Lister().ReplicationList().Has(ali.KeyPair.Id), "ali's feed is still replicated")
+
+	// the fetch shows up in the progress
+	var seen []string
+	for len(evts) > 0 {
+		evt := <-evts
+		if evt.Feed == ali.KeyPair.Id.Ref() {
+			seen = append(seen, evt.Event)
+		}
+	}
+	r.Contains(seen, ssb.ReplicationFeedStart)
+	r.Contains(seen, ssb.ReplicationFeedDone)
+
+	// a second fetch finds nothing new
+	res, err := bob.FetchFeed(ctx, ali.KeyPair.Id, ssb.FetchOptions{Timeout: 10 * time.Second})
+	r.NoError(err)
+	r.EqualValues(5, res.Latest)
+	r.EqualValues(0, res.Received)
+
+	cancel()
+	for _, bot := range []*Sbot{ali, bob} {
+		bot.Shutdown()
+		r.NoError(bot.Close())
+	}
+	r.NoError(botgroup.Wait())
+}
@@ -16,6 +16,7 @@ import (
 	"go.cryptoscope.co/margaret/multilog"
 
 	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/partial"
 	"go.cryptoscope.co/ssb/multilogs"
 )
 
@@ -31,8 +32,8 @@ const (
 	// FSCKModeSequences makes sure the sequence field of each message on a feed are increasing correctly
 	FSCKModeSequences
 
-	// FSCKModeVerify does a full signature and hash verification
-	// FSCKModeVerify
+	// FSCKModeVerify does a full signature and hash verification of each feed
+	FSCKModeVerify
 )
 
 type ErrConsistencyProblems struct {
@@ -56,6 +57,7 @@ type fsckOpt struct {
 	feedsIdx   multilog.MultiLog
 	mode       FSCKMode
 	progressFn FSCKUpdateFunc
+	parallel   int
 }
 
 type FSCKOption func(*fsckOpt) error
@@ -69,7 +71,7 @@ func FSCKWithFeedIndex(idx multilog.MultiLog) FSCKOption {
 
 func FSCKWithMode(m FSCKMode) FSCKOption {
 	return func(o *fsckOpt) error {
-		if m != FSCKModeLength && m != FSCKModeSequences {
+		if m != FSCKModeLength && m != FSCKModeSequences && m != FSCKModeVerify {
 			return fmt.Errorf("invalid fsck mode: %d", m)
 		}
 		o.mode = m
@@ -77,6 +79,17 @@ func FSCKWithMode(m FSCKMode) FSCKOption {
 	}
 }
 
+// FSCKWithParallel sets how many feeds are verified at the same time by FSCKModeVerify
+func FSCKWithParallel(n int) FSCKOption {
+	return func(o *fsckOpt) error {
+		if n < 1 {
+			return fmt.Errorf("invalid fsck parallelism: %d", n)
+		}
+		o.parallel = n
+		return nil
+	}
+}
+
 func FSCKWithProgress(fn FSCKUpdateFunc) FSCKOption {
 	return func(o *fsckOpt) error {
 		if fn == nil {
@@ -123,10 +136,13 @@ func (s *Sbot) FSCK(opts ...FSCKOption) error {
 
 	switch opt.mode {
 	case FSCKModeLength:
-		return lengthFSCK(opt.feedsIdx, s.RootLog)
+		return lengthFSCK(opt.feedsIdx, s.RootLog, s.partial)
 
 	case FSCKModeSequences:
-		return sequenceFSCK(s.RootLog, opt.progressFn)
+		return sequenceFSCK(s.RootLog, s.partial, opt.progressFn)
+
+	case FSCKModeVerify:
+		return verifyFSCK(opt.feedsIdx, s.RootLog, s.hmacKey(), s.partial, opt.parallel, opt.progressFn)
 
 	default:
 		return errors.New("sbot: unknown fsck mode")
@@ -135,8 +151,9 @@ func (s *Sbot) FSCK(opts ...FSCKOption) error {
 
 // lengthFSCK just checks the length of each stored feed.
 // It expects a multilog as first parameter where each sublog is one feed
-// and each entry maps to another entry in the receiveLog
-func lengthFSCK(authorMlog multilog.MultiLog, receiveLog margaret.Log) error {
+// and each entry maps to another entry in the receiveLog.
+// Partially replicated feeds have gaps, their latest message only can't be before the length of the sublog.
+func lengthFSCK(authorMlog multilog.MultiLog, receiveLog margaret.Log, partials *partial.Store) error {
 	feeds, err := authorMlog.List()
 	if err != nil {
 		return err
@@ -181,7 +198,8 @@ func lengthFSCK(authorMlog multilog.MultiLog, receiveLog margaret.Log) error {
 		msg := rv.(ssb.Message)
 
 		// margaret indexes are 0-based, therefore +1
-		if msg.Seq() != currentSeqFromIndex.Seq()+1 {
+		want := currentSeqFromIndex.Seq() + 1
+		if msg.Seq() != want && !(partials.IsPartial(authorRef) && msg.Seq() > want) {
 			return ssb.ErrWrongSequence{
 				Ref:     authorRef,
 				Stored:  currentSeqFromIndex,
@@ -214,8 +232,9 @@ func (p *processedCounter) N() int64 {
 func (p *processedCounter) Err() error { return nil }
 
 // sequenceFSCK goes through every message in the receiveLog
-// and checks tha the sequence of a feed is correctly increasing by one each message
-func sequenceFSCK(receiveLog margaret.Log, progressFn FSCKUpdateFunc) error {
+// and checks tha the sequence of a feed is correctly increasing by one each message.
+// For partially replicated feeds it only has to increase.
+func sequenceFSCK(receiveLog margaret.Log, partials *partial.Store, progressFn FSCKUpdateFunc) error {
 	ctx := context.Background()
 
 	// the last sequence number we saw of that author
@@ -288,9 +307,10 @@ func sequenceFSCK(receiveLog margaret.Log, progressFn FSCKUpdateFunc) error {
 		seqMap.Add(uint32(rxLogSeq))
 
 		currSeq, has := lastSequence[authorRef]
+		gaps := partials.IsPartial(msg.Author())
 
 		if !has {
-			if msgSeq != 1 { // not seen yet, so has to be the first
+			if msgSeq != 1 && !gaps { // not seen yet, so has to be the first
 				seqErr := ssb.ErrWrongSequence{
 					Ref:     msg.Author(),
 					Stored:  sw.Seq(),
@@ -300,7 +320,7 @@ func sequenceFSCK(receiveLog margaret.Log, progressFn FSCKUpdateFunc) error {
 				lastSequence[authorRef] = -1
 				continue
 			}
-			lastSequence[authorRef] = 1
+			lastSequence[authorRef] = msgSeq
 			continue
 		}
 
@@ -308,7 +328,7 @@ func sequenceFSCK(receiveLog margaret.Log, progressFn FSCKUpdateFunc) error {
 			continue
 		}
 
-		if currSeq+1 != msgSeq { // correct next value?
+		if currSeq+1 != msgSeq && !(gaps && msgSeq > currSeq) { // correct next value?
 			seqErr := ssb.ErrWrongSequence{
 				Ref:     msg.Author(),
 				Stored:  margaret.BaseSeq(currSeq + 1),
@@ -318,7 +338,7 @@ func sequenceFSCK(receiveLog margaret.Log, progressFn FSCKUpdateFunc) error {
 			lastSequence[authorRef] = -1
 			continue
 		}
-		lastSequence[authorRef] = currSeq + 1
+		lastSequence[authorRef] = msgSeq
 
 		// bench stats
 		pc.Incr()
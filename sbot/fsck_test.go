@@ -7,6 +7,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/stretchr/testify/require"
@@ -56,6 +57,8 @@ func TestFSCK(t *testing.T) {
 	t.Run("correct", testFSCKcorrect)
 	t.Run("double", testFSCKdouble)
 	t.Run("multipleFeeds", testFSCKmultipleFeeds)
+	t.Run("verify", testFSCKverify)
+	t.Run("verifyGaps", testFSCKverifyGaps)
 	// t.Run("rerpo", testFSCKrerpo)
 }
 
@@ -75,6 +78,71 @@ func testFSCKcorrect(t *testing.T) {
 	err = theBot.FSCK(FSCKWithMode(FSCKModeSequences))
 	r.NoError(err)
 
+	err = theBot.FSCK(FSCKWithMode(FSCKModeVerify))
+	r.NoError(err)
+
+	// cleanup
+	theBot.Shutdown()
+	r.NoError(theBot.Close())
+}
+
+func testFSCKverify(t *testing.T) {
+	r := require.New(t)
+	theBot, _ := makeTestBot(t)
+
+	const n = 16
+	for i := n; i > 0; i-- {
+		_, err := theBot.PublishLog.Publish(i)
+		r.NoError(err)
+	}
+
+	// drop a message in the middle of the feed
+	r.NoError(theBot.RootLog.Null(margaret.BaseSeq(9)))
+
+	err := theBot.FSCK(FSCKWithMode(FSCKModeVerify), FSCKWithParallel(2))
+	r.Error(err)
+	broken, ok := err.(ErrBrokenFeeds)
+	r.True(ok, "wrong error type. got %T", err)
+	r.Len(broken.Feeds, 1)
+	r.True(broken.Feeds[0].Feed.Equal(theBot.KeyPair.Id))
+	r.EqualValues(10, broken.Feeds[0].FirstBad)
+
+	r.NoError(theBot.TruncateFeed(broken.Feeds[0].Feed, broken.Feeds[0].FirstBad-1))
+
+	err = theBot.FSCK(FSCKWithMode(FSCKModeVerify))
+	r.NoError(err, "after truncate")
+
+	// cleanup
+	theBot.Shutdown()
+	r.NoError(theBot.Close())
+}
+
+func testFSCKverifyGaps(t *testing.T) {
+	r := require.New(t)
+	theBot, _ := makeTestBot(t)
+
+	const n = 16
+	for i := n; i > 0; i-- {
+		_, err := theBot.PublishLog.Publish(i)
+		r.NoError(err)
+	}
+	r.NoError(theBot.partial.Mark(theBot.KeyPair.Id, nil, 1, nil))
+
+	// message 9 again, after the end of the feed
+	v, err := theBot.RootLog.Get(margaret.BaseSeq(8))
+	r.NoError(err)
+	_, err = theBot.RootLog.Append(v)
+	r.NoError(err)
+
+	var broken ErrBrokenFeeds
+	r.Eventually(func() bool {
+		var ok bool
+		broken, ok = theBot.FSCK(FSCKWithMode(FSCKModeVerify)).(ErrBrokenFeeds)
+		return ok
+	}, 5*time.Second, 10*time.Millisecond, "the repeated message wasn't found")
+	r.Len(broken.Feeds, 1)
+	r.EqualValues(9, broken.Feeds[0].FirstBad, "the sequence of the message that failed")
+
 	// cleanup
 	theBot.Shutdown()
 	r.NoError(theBot.Close())
@@ -0,0 +1,316 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/machinebox/progress"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/librarian"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/margaret/multilog"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/partial"
+	"go.cryptoscope.co/ssb/message"
+	"go.cryptoscope.co/ssb/message/legacy"
+	"go.cryptoscope.co/ssb/message/multimsg"
+	"go.cryptoscope.co/ssb/multilogs"
+)
+
+// FeedProblem describes the first broken message of a feed
+type FeedProblem struct {
+	Feed *ssb.FeedRef
+
+	// FirstBad is the feed sequence of the first message that failed verification.
+	// Everything before it is fine.
+	FirstBad int64
+
+	Reason error
+}
+
+func (fp FeedProblem) Error() string {
+	return fmt.Sprintf("fsck(%s): broken at sequence %d: %s", fp.Feed.Ref(), fp.FirstBad, fp.Reason)
+}
+
+// ErrBrokenFeeds is returned by FSCKModeVerify if any of the feeds failed verification
+type ErrBrokenFeeds struct {
+	Checked int
+	Feeds   []FeedProblem
+}
+
+func (e ErrBrokenFeeds) Error() string {
+	errStr := fmt.Sprintf("ssb: %d of %d feeds are broken", len(e.Feeds), e.Checked)
+	for i, fp := range e.Feeds {
+		errStr += fmt.Sprintf("\n%02d: %s", i, fp.Error())
+	}
+	return errStr + "\n"
+}
+
+func (s *Sbot) hmacKey() *[32]byte {
+	if len(s.signHMACsecret) != 32 {
+		return nil
+	}
+	var k [32]byte
+	copy(k[:], s.signHMACsecret)
+	return &k
+}
+
+// verifyFSCK checks signatures, hashes and the previous links of every feed in the feeds index.
+func verifyFSCK(feedsIdx multilog.MultiLog, receiveLog margaret.Log, hmacKey *[32]byte, partials *partial.Store, parallel int, progressFn FSCKUpdateFunc) error {
+	feeds, err := feedsIdx.List()
+	if err != nil {
+		return errors.Wrap(err, "fsck/verify: failed to list feeds")
+	}
+
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	var pc processedCounter
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		p := progress.NewTicker(ctx, &pc, int64(len(feeds)), 3*time.Second)
+		for remaining := range p {
+			timeLeft := remaining.Estimated().Sub(time.Now()).Round(time.Second)
+			progressFn(remaining.Percent(), timeLeft)
+		}
+	}()
+
+	var (
+		mu       sync.Mutex
+		problems []FeedProblem
+		firstErr error
+
+		wg    sync.WaitGroup
+		addrs = make(chan librarian.Addr)
+	)
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for addr := range addrs {
+				fp, err := verifyFeed(ctx, feedsIdx, receiveLog, hmacKey, partials, addr)
+				pc.Incr()
+
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				if fp != nil {
+					problems = append(problems, *fp)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, addr := range feeds {
+		select {
+		case addrs <- addr:
+		case <-ctx.Done():
+		}
+	}
+	close(addrs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+
+	sort.Slice(problems, func(i, j int) bool {
+		return problems[i].Feed.Ref() < problems[j].Feed.Ref()
+	})
+	return ErrBrokenFeeds{
+		Checked: len(feeds),
+		Feeds:   problems,
+	}
+}
+
+// verifyFeed returns a problem for the first message of the feed that fails verification.
+// The error is only set if reading the logs failed.
+// The messages of partially replicated feeds don't link to each other, they are only checked one by one.
+func verifyFeed(ctx context.Context, feedsIdx multilog.MultiLog, receiveLog margaret.Log, hmacKey *[32]byte, partials *partial.Store, addr librarian.Addr) (*FeedProblem, error) {
+	var sr ssb.StorageRef
+	if err := sr.Unmarshal([]byte(addr)); err != nil {
+		return nil, errors.Wrap(err, "fsck/verify: invalid feed address")
+	}
+	feed, err := sr.FeedRef()
+	if err != nil {
+		return nil, errors.Wrap(err, "fsck/verify: invalid feed address")
+	}
+
+	subLog, err := feedsIdx.Get(addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fsck/verify: failed to open sublog of %s", feed.Ref())
+	}
+
+	src, err := subLog.Query()
+	if err != nil {
+		return nil, errors.Wrapf(err, "fsck/verify: failed to query sublog of %s", feed.Ref())
+	}
+	gaps := partials.IsPartial(feed)
+
+	var (
+		prev     ssb.Message
+		expected int64 = 1
+	)
+	for ; ; expected++ {
+		v, err := src.Next(ctx)
+		if err != nil {
+			if luigi.IsEOS(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+
+		broken := func(reason error) (*FeedProblem, error) {
+			return &FeedProblem{Feed: feed, FirstBad: expected, Reason: reason}, nil
+		}
+
+		rxSeq, ok := v.(margaret.Seq)
+		if !ok {
+			return nil, errors.Errorf("fsck/verify: unexpected sublog entry %T", v)
+		}
+
+		mv, err := receiveLog.Get(rxSeq)
+		if err != nil {
+			if margaret.IsErrNulled(err) {
+				return broken(err)
+			}
+			return nil, errors.Wrapf(err, "fsck/verify: failed to get message %d", rxSeq.Seq())
+		}
+		msg, ok := mv.(ssb.Message)
+		if !ok {
+			if verr, ok := mv.(error); ok && margaret.IsErrNulled(verr) {
+				return broken(verr)
+			}
+			return nil, errors.Errorf("fsck/verify: unexpected message type %T", mv)
+		}
+		if gaps {
+			// the count of the sublog isn't the sequence, problems are reported at the message that has them
+			expected = msg.Seq()
+		}
+
+		if prev == nil && !msg.Author().Equal(feed) {
+			return broken(errors.Errorf("message by %s in the sublog", msg.Author().Ref()))
+		}
+		if err := verifyMessage(msg, hmacKey); err != nil {
+			return broken(err)
+		}
+		if gaps {
+			if !msg.Author().Equal(feed) {
+				return broken(errors.Errorf("message by %s in the sublog", msg.Author().Ref()))
+			}
+			if prev != nil && msg.Seq() <= prev.Seq() {
+				return broken(errors.Errorf("sequence %d after %d", msg.Seq(), prev.Seq()))
+			}
+		} else if err := message.ValidateNext(prev, msg); err != nil {
+			return broken(err)
+		}
+		prev = msg
+	}
+}
+
+// verifyMessage checks the signature and that the stored key is the hash of the message
+func verifyMessage(msg ssb.Message, hmacKey *[32]byte) error {
+	mm, ok := msg.(*multimsg.MultiMessage)
+	if !ok {
+		return errors.Errorf("unexpected message type %T", msg)
+	}
+
+	if lm, ok := mm.AsLegacy(); ok {
+		ref, _, err := legacy.Verify(lm.Raw_, hmacKey)
+		if err != nil {
+			return err
+		}
+		if lm.Key_ == nil || !bytes.Equal(ref.Hash, lm.Key_.Hash) {
+			return errors.Errorf("stored key doesn't match the message hash %s", ref.Ref())
+		}
+		return nil
+	}
+
+	if tr, ok := mm.AsGabby(); ok {
+		if !tr.Verify(hmacKey) {
+			return errors.Errorf("gabby grove transfer failed verification")
+		}
+		return nil
+	}
+
+	return errors.Errorf("unsupported message format")
+}
+
+// TruncateFeed removes all messages of ref that come after lastValid.
+// The removed messages are nulled in the receive log and the feed's entry in the userFeeds index is rewritten.
+// Other indexes still know about the removed messages and should be rebuilt.
+func (s *Sbot) TruncateFeed(ref *ssb.FeedRef, lastValid int64) error {
+	uf, ok := s.GetMultiLog(multilogs.IndexNameFeeds)
+	if !ok {
+		return errors.Errorf("TruncateFeed: failed to open multilog")
+	}
+
+	feedAddr := ref.StoredAddr()
+	userSeqs, err := uf.Get(feedAddr)
+	if err != nil {
+		return errors.Wrap(err, "TruncateFeed: failed to open log for feed argument")
+	}
+
+	src, err := userSeqs.Query()
+	if err != nil {
+		return errors.Wrap(err, "TruncateFeed: failed create user seqs query")
+	}
+
+	var keep []margaret.Seq
+	for i := int64(0); ; i++ {
+		v, err := src.Next(context.Background())
+		if err != nil {
+			if luigi.IsEOS(err) {
+				break
+			}
+			return errors.Wrap(err, "TruncateFeed: failed to read user seqs")
+		}
+		seq, ok := v.(margaret.Seq)
+		if !ok {
+			return errors.Errorf("TruncateFeed: not a sequence from userlog query")
+		}
+
+		// the sublog is 0-based, feed sequences start at 1
+		if i < lastValid {
+			keep = append(keep, seq)
+			continue
+		}
+		if err := s.RootLog.Null(seq); err != nil && !margaret.IsErrNulled(err) {
+			return errors.Wrapf(err, "TruncateFeed: failed to null message %d", seq.Seq())
+		}
+	}
+
+	if err := uf.Delete(feedAddr); err != nil {
+		return errors.Wrap(err, "TruncateFeed: error while deleting feed from userFeeds index")
+	}
+	if len(keep) == 0 {
+		return nil
+	}
+
+	userSeqs, err = uf.Get(feedAddr)
+	if err != nil {
+		return errors.Wrap(err, "TruncateFeed: failed to re-open log for feed argument")
+	}
+	for _, seq := range keep {
+		if _, err := userSeqs.Append(seq); err != nil {
+			return errors.Wrap(err, "TruncateFeed: failed to re-add message to userFeeds index")
+		}
+	}
+	return nil
+}
@@ -4,15 +4,21 @@ package sbot
 
 import (
 	"github.com/pkg/errors"
+	"go.cryptoscope.co/margaret/multilog"
 
 	"go.cryptoscope.co/ssb"
 	"go.cryptoscope.co/ssb/message"
 	"go.cryptoscope.co/ssb/multilogs"
+	"go.cryptoscope.co/ssb/private"
+	"go.cryptoscope.co/ssb/private/keys"
 	"go.cryptoscope.co/ssb/repo"
 )
 
 func (sbot *Sbot) PublishAs(nick string, val interface{}) (*ssb.MessageRef, error) {
-	r := repo.New(sbot.repoPath)
+	if sbot.readOnly {
+		return nil, repo.ErrReadOnly
+	}
+	r := sbot.repository()
 
 	uf, ok := sbot.GetMultiLog(multilogs.IndexNameFeeds)
 	if !ok {
@@ -24,6 +30,40 @@ func (sbot *Sbot) PublishAs(nick string, val interface{}) (*ssb.MessageRef, erro
 		return nil, err
 	}
 
+	pl, err := sbot.publisherFor(uf, kp)
+	if err != nil {
+		return nil, errors.Wrap(err, "publishAs: failed to create publish log")
+	}
+	if !sbot.disableAutoBox {
+		pl = private.NewAutoBoxPublisher(pl, sbot.keysFor(kp), sbot.autoBoxBox2)
+	}
+
+	return pl.Publish(val)
+}
+
+// keysFor returns the key manager of kp, with the groups it was given via WithPrivateIndexKeys.
+// Identities without one only get a fresh manager, which can box direct messages but knows no groups.
+func (sbot *Sbot) keysFor(kp *ssb.KeyPair) *keys.Manager {
+	if sbot.keysManager != nil && sbot.keysManager.KeyPair().Id.Equal(kp.Id) {
+		return sbot.keysManager
+	}
+	for _, km := range sbot.privKeys {
+		if km.KeyPair().Id.Equal(kp.Id) {
+			return km
+		}
+	}
+	return keys.NewManager(kp)
+}
+
+// publisherFor returns the publish log of the feed of kp, it is opened on first use.
+func (sbot *Sbot) publisherFor(uf multilog.MultiLog, kp *ssb.KeyPair) (ssb.Publisher, error) {
+	sbot.publishersMu.Lock()
+	defer sbot.publishersMu.Unlock()
+
+	if pl, ok := sbot.publishers[kp.Id.Ref()]; ok {
+		return pl, nil
+	}
+
 	var pubopts = []message.PublishOption{
 		message.UseNowTimestamps(true),
 	}
@@ -33,8 +73,11 @@ func (sbot *Sbot) PublishAs(nick string, val interface{}) (*ssb.MessageRef, erro
 
 	pl, err := message.OpenPublishLog(sbot.RootLog, uf, kp, pubopts...)
 	if err != nil {
-		return nil, errors.Wrap(err, "publishAs: failed to create publish log")
+		return nil, err
 	}
-
-	return pl.Publish(val)
+	if sbot.publishers == nil {
+		sbot.publishers = make(map[string]ssb.Publisher)
+	}
+	sbot.publishers[kp.Id.Ref()] = pl
+	return pl, nil
 }
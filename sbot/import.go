@@ -0,0 +1,216 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/librarian"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/message"
+	"go.cryptoscope.co/ssb/multilogs"
+)
+
+// ImportedFeed is what ImportFeed did with the messages of one feed
+type ImportedFeed struct {
+	Feed *ssb.FeedRef
+
+	// Skipped messages were already stored, Imported ones are new
+	Skipped, Imported int64
+}
+
+// ImportFeed reads the signed messages of a single feed from r, verifies them and appends the new ones to the receive log.
+// r holds the messages as JSON values one after the other, either plain or wrapped in key and value like createLogStream returns them.
+// Messages up to the latest one the bot has are skipped, the others have to continue the feed.
+// progress is called with the sequence of each stored message and can be nil.
+//
+// Different feeds can be imported at the same time, imports of the same feed wait for each other.
+func (s *Sbot) ImportFeed(ctx context.Context, r io.Reader, progress func(seq int64)) (ImportedFeed, error) {
+	var res ImportedFeed
+
+	dec := json.NewDecoder(r)
+	next := func() (json.RawMessage, *importHeader, error) {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, nil, err
+		}
+		var kv struct {
+			Value json.RawMessage `json:"value"`
+		}
+		if err := json.Unmarshal(raw, &kv); err == nil && len(kv.Value) > 0 {
+			raw = kv.Value
+		}
+		var hdr importHeader
+		if err := json.Unmarshal(raw, &hdr); err != nil {
+			return nil, nil, errors.Wrap(err, "import: not a message")
+		}
+		if hdr.Author == nil {
+			return nil, nil, errors.New("import: message without author")
+		}
+		return raw, &hdr, nil
+	}
+
+	raw, hdr, err := next()
+	if err == io.EOF {
+		return res, errors.New("import: no messages")
+	} else if err != nil {
+		return res, err
+	}
+	res.Feed = hdr.Author
+	if res.Feed.Algo != ssb.RefAlgoFeedSSB1 {
+		return res, errors.Errorf("import: unsupported feed format %s", res.Feed.Algo)
+	}
+
+	unlock := s.imports.lock(res.Feed.Ref())
+	defer unlock()
+
+	latestSeq, latestMsg, err := s.latestOf(res.Feed)
+	if err != nil {
+		return res, err
+	}
+	// the index might not have caught up with an earlier import yet
+	if last := s.imports.last(res.Feed.Ref()); last != nil && last.Seq() > latestSeq.Seq() {
+		latestSeq, latestMsg = margaret.BaseSeq(last.Seq()), last
+	}
+	defer func() {
+		if latestMsg != nil {
+			s.imports.setLast(res.Feed.Ref(), latestMsg)
+		}
+	}()
+
+	store := luigi.FuncSink(func(ctx context.Context, val interface{}, err error) error {
+		if err != nil {
+			if luigi.IsEOS(err) {
+				return nil
+			}
+			return err
+		}
+		if _, err := s.RootLog.Append(val); err != nil {
+			return errors.Wrap(err, "import: failed to append verified message to rootLog")
+		}
+		latestMsg = val.(ssb.Message)
+		res.Imported++
+		if progress != nil {
+			progress(latestMsg.Seq())
+		}
+		return nil
+	})
+	snk := message.NewVerifySink(res.Feed, latestSeq, latestMsg, store, s.hmacKey())
+
+	for {
+		if !hdr.Author.Equal(res.Feed) {
+			return res, errors.Errorf("import: message %d is by %s, not %s", hdr.Sequence, hdr.Author.Ref(), res.Feed.Ref())
+		}
+
+		if hdr.Sequence <= latestSeq.Seq() {
+			// the one at latestSeq still goes through the sink, to make sure it's the same message we have
+			res.Skipped++
+		}
+		if hdr.Sequence >= latestSeq.Seq() {
+			if err := snk.Pour(ctx, raw); err != nil {
+				return res, errors.Wrapf(err, "import: message %d of %s", hdr.Sequence, res.Feed.Ref())
+			}
+		}
+
+		raw, hdr, err = next()
+		if err == io.EOF {
+			return res, nil
+		} else if err != nil {
+			return res, err
+		}
+	}
+}
+
+type importHeader struct {
+	Author   *ssb.FeedRef `json:"author"`
+	Sequence int64        `json:"sequence"`
+}
+
+// latestOf returns the sequence and the message of the newest message of fr, or 0 and nil if we don't have any
+func (s *Sbot) latestOf(fr *ssb.FeedRef) (margaret.BaseSeq, ssb.Message, error) {
+	uf, ok := s.GetMultiLog(multilogs.IndexNameFeeds)
+	if !ok {
+		return 0, nil, errors.Errorf("sbot: no userFeeds index")
+	}
+	userLog, err := uf.Get(fr.StoredAddr())
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "sbot: failed to open sublog for user")
+	}
+	latest, err := userLog.Seq().Value()
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "sbot: failed to observe latest")
+	}
+
+	switch v := latest.(type) {
+	case librarian.UnsetValue:
+		return 0, nil, nil
+	case margaret.BaseSeq:
+		if v < 0 {
+			return 0, nil, nil
+		}
+		rootSeq, err := userLog.Get(v)
+		if err != nil {
+			return 0, nil, errors.Wrap(err, "sbot: failed to look up root seq for latest user sublog")
+		}
+		msgV, err := s.RootLog.Get(rootSeq.(margaret.Seq))
+		if err != nil {
+			return 0, nil, errors.Wrap(err, "sbot: failed retreive stored message")
+		}
+		msg, ok := msgV.(ssb.Message)
+		if !ok {
+			return 0, nil, errors.Errorf("sbot: wrong message type. expected %T - got %T", msg, msgV)
+		}
+		// sublog is 0-init while ssb chains start at 1
+		if msg.Seq() != int64(v+1) {
+			return 0, nil, ssb.ErrWrongSequence{Ref: fr, Stored: msg, Logical: v + 1}
+		}
+		return v + 1, msg, nil
+	default:
+		return 0, nil, errors.Errorf("sbot: unexpected latest value %T", latest)
+	}
+}
+
+// importTracker hands out one lock per feed and remembers the last imported message of each feed
+type importTracker struct {
+	mu       sync.Mutex
+	locks    map[string]*sync.Mutex
+	lastMsgs map[string]ssb.Message
+}
+
+func (it *importTracker) lock(feed string) func() {
+	it.mu.Lock()
+	if it.locks == nil {
+		it.locks = make(map[string]*sync.Mutex)
+	}
+	l, has := it.locks[feed]
+	if !has {
+		l = new(sync.Mutex)
+		it.locks[feed] = l
+	}
+	it.mu.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+func (it *importTracker) last(feed string) ssb.Message {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	return it.lastMsgs[feed]
+}
+
+func (it *importTracker) setLast(feed string, msg ssb.Message) {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	if it.lastMsgs == nil {
+		it.lastMsgs = make(map[string]ssb.Message)
+	}
+	it.lastMsgs[feed] = msg
+}
@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/testutils"
+)
+
+func TestImportFeed(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	testPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(testPath)
+
+	hmacKey := make([]byte, 32)
+	rand.Read(hmacKey)
+	mainLog := testutils.NewRelativeTimeLogger(nil)
+	mkBot := func(name string) *Sbot {
+		bot, err := New(
+			WithHMACSigning(hmacKey),
+			WithInfo(log.With(mainLog, "bot", name)),
+			WithRepoPath(filepath.Join(testPath, name)),
+			DisableNetworkNode(),
+		)
+		r.NoError(err)
+		return bot
+	}
+
+	src := mkBot("src")
+	defer src.Close()
+	const n = 10
+	for i := 0; i < n; i++ {
+		_, err := src.PublishLog.Publish(map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+	}
+
+	// export the first half plain and the rest like createLogStream with keys
+	var first, all bytes.Buffer
+	msgs, err := src.RootLog.Query()
+	r.NoError(err)
+	for {
+		v, err := msgs.Next(ctx)
+		if luigi.IsEOS(err) {
+			break
+		}
+		r.NoError(err)
+		msg := v.(ssb.Message)
+		if msg.Seq() <= n/2 {
+			first.Write(msg.ValueContentJSON())
+			first.WriteString("\n")
+			all.Write(msg.ValueContentJSON())
+		} else {
+			fmt.Fprintf(&all, `{"key": %q, "value": %s}`, msg.Key().Ref(), msg.ValueContentJSON())
+		}
+		all.WriteString("\n")
+	}
+
+	dst := mkBot("dst")
+	defer dst.Close()
+	dst.WaitUntilIndexesAreSynced()
+
+	var seqs []int64
+	res, err := dst.ImportFeed(ctx, &first, func(seq int64) { seqs = append(seqs, seq) })
+	r.NoError(err)
+	r.True(res.Feed.Equal(src.KeyPair.Id))
+	r.EqualValues(n/2, res.Imported)
+	r.EqualValues(0, res.Skipped)
+	r.Equal([]int64{1, 2, 3, 4, 5}, seqs)
+
+	// what is already there is skipped, even if the index didn't catch up yet
+	res, err = dst.ImportFeed(ctx, &all, nil)
+	r.NoError(err)
+	r.EqualValues(n/2, res.Imported)
+	r.EqualValues(n/2, res.Skipped)
+
+	rxSeq, err := dst.RootLog.Seq().Value()
+	r.NoError(err)
+	r.EqualValues(n-1, rxSeq.(margaret.Seq).Seq())
+
+	// a message that doesn't fit is refused
+	var other bytes.Buffer
+	other.WriteString(`{"previous":null,"author":"`)
+	other.WriteString(src.KeyPair.Id.Ref())
+	other.WriteString(`","sequence":11,"timestamp":1,"hash":"sha256","content":{"type":"test"},"signature":"AAAA.sig.ed25519"}`)
+	_, err = dst.ImportFeed(ctx, &other, nil)
+	r.Error(err)
+}
@@ -0,0 +1,105 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+)
+
+const (
+	// DefaultIndexBatchSize is how many messages an index that supports it gets in one transaction at most
+	DefaultIndexBatchSize = 512
+
+	// DefaultIndexBatchDelay is how long messages are collected for one batch at most
+	DefaultIndexBatchDelay = 250 * time.Millisecond
+)
+
+// WithIndexBatching sets the bounds for the batches of messages that are applied to indexes in one transaction
+// (see repo.BatchSinkIndex). A batch is written once it has size messages, once it's older than delay
+// or once no more messages are available right away, whatever comes first.
+func WithIndexBatching(size int, delay time.Duration) Option {
+	return func(s *Sbot) error {
+		if size < 1 {
+			return errors.Errorf("sbot: index batch size needs to be positive")
+		}
+		if delay < 0 {
+			return errors.Errorf("sbot: negative index batch delay")
+		}
+		s.indexBatchSize = size
+		s.indexBatchDelay = delay
+		return nil
+	}
+}
+
+type batchPourer interface {
+	PourBatch(context.Context, []margaret.SeqWrapper) error
+}
+
+// pumpBatched is like luigi.Pump but hands the values of src to snk in batches.
+//
+// Values are read ahead into a buffer of size. Once the first value of a batch is there,
+// everything else that is in the buffer is added, until the batch is full or older than maxDelay.
+// A single new message, like one that was just published, is thus applied right away
+// while the backlog or a burst of replicated messages goes in large batches.
+func pumpBatched(ctx context.Context, snk batchPourer, src luigi.Source, size int, maxDelay time.Duration) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	vals := make(chan margaret.SeqWrapper, size)
+	srcErr := make(chan error, 1)
+	go func() {
+		defer close(vals)
+		for {
+			v, err := src.Next(ctx)
+			if err != nil {
+				srcErr <- err
+				return
+			}
+			sw, ok := v.(margaret.SeqWrapper)
+			if !ok {
+				srcErr <- errors.Errorf("index batch: expected sequence wrapped value, got %T", v)
+				return
+			}
+			select {
+			case vals <- sw:
+			case <-ctx.Done():
+				srcErr <- ctx.Err()
+				return
+			}
+		}
+	}()
+
+	batch := make([]margaret.SeqWrapper, 0, size)
+	for first := range vals {
+		batch = append(batch[:0], first)
+		started := time.Now()
+
+	fill:
+		for len(batch) < size && time.Since(started) < maxDelay {
+			select {
+			case sw, ok := <-vals:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, sw)
+			default:
+				break fill
+			}
+		}
+
+		if err := snk.PourBatch(ctx, batch); err != nil {
+			return err
+		}
+	}
+
+	err := <-srcErr
+	if luigi.IsEOS(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+)
+
+type batchRecorder struct {
+	mu      sync.Mutex
+	batches [][]int64
+	poured  chan struct{}
+}
+
+func (br *batchRecorder) PourBatch(ctx context.Context, batch []margaret.SeqWrapper) error {
+	var seqs []int64
+	for _, sw := range batch {
+		seqs = append(seqs, sw.Seq().Seq())
+	}
+	br.mu.Lock()
+	br.batches = append(br.batches, seqs)
+	br.mu.Unlock()
+	br.poured <- struct{}{}
+	return nil
+}
+
+func TestPumpBatched(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	src, snk := luigi.NewPipe(luigi.WithBuffer(100))
+	for i := 0; i < 10; i++ {
+		r.NoError(snk.Pour(ctx, margaret.WrapWithSeq(i, margaret.BaseSeq(i))))
+	}
+
+	br := &batchRecorder{poured: make(chan struct{}, 100)}
+	done := make(chan error)
+	go func() {
+		done <- pumpBatched(ctx, br, src, 4, time.Minute)
+	}()
+
+	// the backlog goes in order and in batches of up to 4
+	var got []int64
+	for i := 0; len(got) < 10; i++ {
+		select {
+		case <-br.poured:
+		case <-time.After(5 * time.Second):
+			t.Fatal("backlog was not applied")
+		}
+		br.mu.Lock()
+		batch := br.batches[i]
+		br.mu.Unlock()
+		r.True(len(batch) <= 4, "batch too big: %v", batch)
+		got = append(got, batch...)
+	}
+	r.Equal([]int64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, got)
+
+	// a single new one doesn't wait for the delay
+	r.NoError(snk.Pour(ctx, margaret.WrapWithSeq(10, margaret.BaseSeq(10))))
+	select {
+	case <-br.poured:
+	case <-time.After(5 * time.Second):
+		t.Fatal("single message was not applied")
+	}
+	br.mu.Lock()
+	r.Equal([]int64{10}, br.batches[len(br.batches)-1])
+	br.mu.Unlock()
+
+	r.NoError(snk.Close())
+	r.NoError(<-done)
+}
@@ -5,6 +5,7 @@ package sbot
 import (
 	"context"
 	"fmt"
+	"io"
 	"sync"
 	"time"
 
@@ -48,6 +49,10 @@ func MountPlugin(plug ssb.Plugin, mode plugins2.AuthMode) Option {
 			}
 		}
 
+		if st, ok := plug.(plugins2.Stopper); ok {
+			s.stoppers = append(s.stoppers, st)
+		}
+
 		switch mode {
 		case plugins2.AuthPublic:
 			s.public.Register(plug)
@@ -63,7 +68,15 @@ func MountPlugin(plug ssb.Plugin, mode plugins2.AuthMode) Option {
 
 func MountMultiLog(name string, fn repo.MakeMultiLog) Option {
 	return func(s *Sbot) error {
-		mlog, updateSink, err := fn(repo.New(s.repoPath))
+		var mlog multilog.MultiLog
+		updateSink, err := s.openIndex(name, func() (io.Closer, librarian.SinkIndex, error) {
+			var (
+				snk librarian.SinkIndex
+				err error
+			)
+			mlog, snk, err = fn(s.repository())
+			return mlog, snk, err
+		})
 		if err != nil {
 			return errors.Wrapf(err, "sbot/index: failed to open idx %s", name)
 		}
@@ -76,7 +89,15 @@ func MountMultiLog(name string, fn repo.MakeMultiLog) Option {
 
 func MountSimpleIndex(name string, fn repo.MakeSimpleIndex) Option {
 	return func(s *Sbot) error {
-		idx, updateSink, err := fn(repo.New(s.repoPath))
+		var idx librarian.Index
+		updateSink, err := s.openIndex(name, func() (io.Closer, librarian.SinkIndex, error) {
+			var (
+				snk librarian.SinkIndex
+				err error
+			)
+			idx, snk, err = fn(s.repository())
+			return nil, snk, err
+		})
 		if err != nil {
 			return errors.Wrapf(err, "sbot/index: failed to open idx %s", name)
 		}
@@ -121,10 +142,19 @@ func (s *Sbot) WaitUntilIndexesAreSynced() {
 }
 
 func (s *Sbot) serveIndex(name string, snk librarian.SinkIndex) {
+	if s.readOnly {
+		// read-only repos are used as they are on disk, no catch-up
+		s.indexStateMu.Lock()
+		s.indexStates[name] = "read-only"
+		s.indexStateMu.Unlock()
+		return
+	}
+
 	s.idxInSync.Add(1)
 
 	s.indexStateMu.Lock()
 	s.indexStates[name] = "pending"
+	s.indexSinks[name] = snk
 	s.indexStateMu.Unlock()
 
 	s.idxDone.Go(func() error {
@@ -161,7 +191,11 @@ func (s *Sbot) serveIndex(name string, snk librarian.SinkIndex) {
 			}
 		}()
 
-		err = luigi.Pump(s.rootCtx, &ps, src)
+		if _, ok := snk.(repo.BatchSinkIndex); ok {
+			err = pumpBatched(s.rootCtx, &ps, src, s.indexBatchSize, s.indexBatchDelay)
+		} else {
+			err = luigi.Pump(s.rootCtx, &ps, src)
+		}
 		cancel()
 		if err == ssb.ErrShuttingDown || err == context.Canceled {
 			return nil
@@ -184,7 +218,11 @@ func (s *Sbot) serveIndex(name string, snk librarian.SinkIndex) {
 		s.indexStates[name] = "live"
 		s.indexStateMu.Unlock()
 
-		err = luigi.Pump(s.rootCtx, snk, src)
+		if bsnk, ok := snk.(repo.BatchSinkIndex); ok {
+			err = pumpBatched(s.rootCtx, bsnk, src, s.indexBatchSize, s.indexBatchDelay)
+		} else {
+			err = luigi.Pump(s.rootCtx, snk, src)
+		}
 		if err == ssb.ErrShuttingDown || err == context.Canceled {
 			return nil
 		}
@@ -238,4 +276,27 @@ func (ps *progressSink) Pour(ctx context.Context, v interface{}) error {
 	return nil
 }
 
+// PourBatch passes batch on, the backing sink needs to be a repo.BatchSinkIndex
+func (ps *progressSink) PourBatch(ctx context.Context, batch []margaret.SeqWrapper) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.erred != nil {
+		return ps.erred
+	}
+
+	bsnk, ok := ps.backing.(repo.BatchSinkIndex)
+	if !ok {
+		ps.erred = errors.Errorf("progress sink: %T doesn't take batches", ps.backing)
+		return ps.erred
+	}
+	err := bsnk.PourBatch(ctx, batch)
+	if err != nil {
+		ps.erred = err
+		return err
+	}
+
+	ps.n += uint(len(batch))
+	return nil
+}
+
 func (ps progressSink) Close() error { return nil }
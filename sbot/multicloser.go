@@ -28,8 +28,9 @@ func (mc *multiCloser) Close() error {
 	mc.l.Lock()
 	defer mc.l.Unlock()
 
-	for i, c := range mc.cs {
-		err = multierror.Append(err, errors.Wrapf(c.Close(), "multiCloser: c%d failed", i))
+	// like defer: the log is added first and closed last, after the indexes that read from it
+	for i := len(mc.cs) - 1; i >= 0; i-- {
+		err = multierror.Append(err, errors.Wrapf(mc.cs[i].Close(), "multiCloser: c%d failed", i))
 	}
 
 	me := err.(*multierror.Error)
@@ -9,21 +9,26 @@ import (
 
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/librarian"
 	"go.cryptoscope.co/muxrpc"
 
 	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/authz"
 	"go.cryptoscope.co/ssb/blobstore"
 	"go.cryptoscope.co/ssb/graph"
 	"go.cryptoscope.co/ssb/indexes"
 	"go.cryptoscope.co/ssb/internal/ctxutils"
+	"go.cryptoscope.co/ssb/internal/livelog"
 	"go.cryptoscope.co/ssb/internal/mutil"
-	"go.cryptoscope.co/ssb/message"
+	"go.cryptoscope.co/ssb/internal/partial"
 	"go.cryptoscope.co/ssb/multilogs"
 	"go.cryptoscope.co/ssb/network"
 	"go.cryptoscope.co/ssb/plugins/blobs"
+	"go.cryptoscope.co/ssb/plugins/config"
 	"go.cryptoscope.co/ssb/plugins/control"
+	"go.cryptoscope.co/ssb/plugins/ebt"
 	"go.cryptoscope.co/ssb/plugins/friends"
 	"go.cryptoscope.co/ssb/plugins/get"
 	"go.cryptoscope.co/ssb/plugins/gossip"
@@ -33,11 +38,17 @@ import (
 	"go.cryptoscope.co/ssb/plugins/rawread"
 	"go.cryptoscope.co/ssb/plugins/replicate"
 	"go.cryptoscope.co/ssb/plugins/status"
+	"go.cryptoscope.co/ssb/plugins/tribes"
 	"go.cryptoscope.co/ssb/plugins/whoami"
 	"go.cryptoscope.co/ssb/private"
+	"go.cryptoscope.co/ssb/private/groups"
+	"go.cryptoscope.co/ssb/private/keys"
 	"go.cryptoscope.co/ssb/repo"
 )
 
+// Close closes the network node, waits for the indexes and closes the stores.
+// It keeps going if a step fails and returns all the errors together.
+// See GracefulShutdown for a version that lets open calls finish first.
 func (s *Sbot) Close() error {
 	s.closedMu.Lock()
 	defer s.closedMu.Unlock()
@@ -49,26 +60,29 @@ func (s *Sbot) Close() error {
 	closeEvt := kitlog.With(s.info, "event", "sbot closing")
 	s.closed = true
 
+	var errs error
 	if s.Network != nil {
 		if err := s.Network.Close(); err != nil {
-			s.closeErr = errors.Wrap(err, "sbot: failed to close own network node")
-			return s.closeErr
+			errs = multierror.Append(errs, errors.Wrap(err, "sbot: failed to close own network node"))
 		}
 		s.Network.GetConnTracker().CloseAll()
 		level.Debug(closeEvt).Log("msg", "connections closed")
 	}
 
 	if err := s.idxDone.Wait(); err != nil {
-		s.closeErr = errors.Wrap(err, "sbot: index group shutdown failed")
-		return s.closeErr
+		errs = multierror.Append(errs, errors.Wrap(err, "sbot: index group shutdown failed"))
 	}
 	level.Debug(closeEvt).Log("msg", "waited for indexes to close")
 
 	if err := s.closers.Close(); err != nil {
-		s.closeErr = err
-		return s.closeErr
+		errs = multierror.Append(errs, err)
 	}
+	s.handles.Forget()
 
+	s.closeErr = errs
+	if errs != nil {
+		return errs
+	}
 	level.Info(closeEvt).Log("msg", "closers closed")
 	return nil
 }
@@ -80,7 +94,11 @@ func initSbot(s *Sbot) (*Sbot, error) {
 	s.rootCtx, s.Shutdown = ctxutils.WithError(s.rootCtx, ssb.ErrShuttingDown)
 	ctx := s.rootCtx
 
-	r := repo.New(s.repoPath)
+	r := s.repository()
+
+	if err := s.startupCheck(r); err != nil {
+		return nil, err
+	}
 
 	// optionize?!
 	s.RootLog, err = repo.OpenLog(r)
@@ -89,6 +107,22 @@ func initSbot(s *Sbot) (*Sbot, error) {
 	}
 	s.closers.addCloser(s.RootLog.(io.Closer))
 
+	s.partial, err = partial.Open(r.GetPath("partial.json"))
+	if err != nil {
+		return nil, errors.Wrap(err, "sbot: failed to open partial replication markers")
+	}
+
+	// what each peer gave us and didn't have survives restarts, so reconnects don't ask for the same things again
+	s.peerStates, err = gossip.OpenPeerStates(r.GetPath("gossip", "peers"), 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "sbot: failed to open replication states")
+	}
+	s.closers.addCloser(s.peerStates)
+
+	// live queries of the network facing plugins go through bounded queues
+	s.liveLog = livelog.New(s.RootLog, s.liveQueueSize)
+	s.closers.addCloser(s.liveLog)
+
 	// TODO: rewirte about as consumer of msgs by type, like contacts
 	// ab, serveAbouts, err := indexes.OpenAbout(kitlog.With(log, "index", "abouts"), r)
 	// if err != nil {
@@ -99,18 +133,25 @@ func initSbot(s *Sbot) (*Sbot, error) {
 	// s.AboutStore = ab
 
 	if s.BlobStore == nil { // load default, local file blob store
-		s.BlobStore, err = repo.OpenBlobStore(r)
+		s.BlobStore, err = repo.OpenBlobStore(r, blobstore.WithLogger(kitlog.With(s.info, "module", "blobstore")))
 		if err != nil {
 			return nil, errors.Wrap(err, "sbot: failed to open blob store")
 		}
 	}
 
 	wantsLog := kitlog.With(log, "module", "WantManager")
-	wm := blobstore.NewWantManager(s.BlobStore,
+	wantOpts := []blobstore.WantManagerOption{
 		blobstore.WantWithLogger(wantsLog),
 		blobstore.WantWithContext(s.rootCtx),
 		blobstore.WantWithMetrics(s.systemGauge, s.eventCounter),
-	)
+	}
+	if s.blobSizeLimit > 0 {
+		wantOpts = append(wantOpts, blobstore.WantWithMaxSize(s.blobSizeLimit))
+	}
+	if s.blobPush != nil {
+		wantOpts = append(wantOpts, blobstore.WantWithPushesFrom(s.blobPush.accepts))
+	}
+	wm := blobstore.NewWantManager(s.BlobStore, wantOpts...)
 	s.WantManager = wm
 	s.closers.addCloser(wm)
 
@@ -134,6 +175,13 @@ func initSbot(s *Sbot) (*Sbot, error) {
 		}
 	}
 
+	if _, ok := s.mlogIndicies[multilogs.IndexNameBlobRefs]; !ok {
+		err = MountMultiLog(multilogs.IndexNameBlobRefs, multilogs.OpenBlobRefs)(s)
+		if err != nil {
+			return nil, errors.Wrap(err, "sbot: failed to open blobRefs index")
+		}
+	}
+
 	if _, ok := s.simpleIndex["content-delete-requests"]; !ok {
 		var dcrTrigger dropContentTrigger
 		dcrTrigger.logger = kitlog.With(log, "module", "dcrTrigger")
@@ -146,16 +194,19 @@ func initSbot(s *Sbot) (*Sbot, error) {
 		}
 	}
 
-	var pubopts = []message.PublishOption{
-		message.UseNowTimestamps(true),
-	}
-	if s.signHMACsecret != nil {
-		pubopts = append(pubopts, message.SetHMACKey(s.signHMACsecret))
-	}
-	s.PublishLog, err = message.OpenPublishLog(s.RootLog, uf, s.KeyPair, pubopts...)
+	s.PublishLog, err = s.publisherFor(uf, s.KeyPair)
 	if err != nil {
 		return nil, errors.Wrap(err, "sbot: failed to create publish log")
 	}
+	if s.keysManager == nil {
+		s.keysManager = keys.NewManager(s.KeyPair)
+	}
+	if !s.disableAutoBox {
+		s.PublishLog = private.NewAutoBoxPublisher(s.PublishLog, s.keysManager, s.autoBoxBox2)
+	}
+	if s.readOnly {
+		s.PublishLog = readOnlyPublisher{s.PublishLog}
+	}
 
 	// LogBuilder doesn't fully work yet
 	if mt, ok := s.mlogIndicies["msgTypes"]; ok {
@@ -169,7 +220,18 @@ func initSbot(s *Sbot) (*Sbot, error) {
 			return nil, errors.Wrap(err, "sbot: NewLogBuilder failed")
 		}
 	} else {
-		gb, seqSetter, updateIdx, err := indexes.OpenContacts(kitlog.With(log, "module", "graph"), r)
+		var (
+			gb        graph.Builder
+			seqSetter librarian.SeqSetterIndex
+		)
+		updateIdx, err := s.openIndex(indexes.FolderNameContacts, func() (io.Closer, librarian.SinkIndex, error) {
+			var (
+				snk librarian.SinkIndex
+				err error
+			)
+			gb, seqSetter, snk, err = indexes.OpenContacts(kitlog.With(log, "module", "graph"), r)
+			return nil, snk, err
+		})
 		if err != nil {
 			return nil, errors.Wrap(err, "sbot: OpenContacts failed")
 		}
@@ -178,6 +240,24 @@ func initSbot(s *Sbot) (*Sbot, error) {
 		s.GraphBuilder = gb
 	}
 
+	if s.enableBlobGC {
+		if err := s.startBlobGC(); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.blobPush != nil {
+		if err := s.startBlobPush(); err != nil {
+			return nil, err
+		}
+	}
+
+	if pl, ok := s.mlogIndicies["privLogs"]; ok {
+		if err := s.openPrivateReplays(r, pl); err != nil {
+			return nil, err
+		}
+	}
+
 	if s.disableNetwork {
 		return s, nil
 	}
@@ -272,7 +352,9 @@ func initSbot(s *Sbot) (*Sbot, error) {
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to open user private index")
 		}
-		s.master.Register(privplug.NewPlug(kitlog.With(log, "plugin", "private"), s.PublishLog, private.NewUnboxerLog(s.RootLog, userPrivs, s.KeyPair)))
+		km := s.keysManager
+		s.master.Register(privplug.NewPlug(kitlog.With(log, "plugin", "private"), s.PublishLog, private.NewUnboxerLogWithKeys(s.RootLog, userPrivs, km), km, s))
+		s.master.Register(tribes.New(kitlog.With(log, "plugin", "tribes"), groups.New(km, s.PublishLog)))
 	}
 
 	// whoami
@@ -281,9 +363,13 @@ func initSbot(s *Sbot) (*Sbot, error) {
 	s.master.Register(whoami)
 
 	// blobs
-	blobs := blobs.New(kitlog.With(log, "plugin", "blobs"), *s.KeyPair.Id, s.BlobStore, wm)
-	s.public.Register(blobs)
-	s.master.Register(blobs) // TODO: does not need to open a createWants on this one?!
+	blobsLog := kitlog.With(log, "plugin", "blobs")
+	s.public.Register(blobs.New(blobsLog, *s.KeyPair.Id, s.BlobStore, wm))
+	blobOpts := []interface{}{blobs.SizeLimit(s.blobSizeLimit)}
+	if _, has := s.mlogIndicies[multilogs.IndexNameBlobRefs]; has {
+		blobOpts = append(blobOpts, blobs.Referrers(s))
+	}
+	s.master.Register(blobs.NewMaster(blobsLog, *s.KeyPair.Id, s.BlobStore, wm, blobOpts...)) // TODO: does not need to open a createWants on this one?!
 
 	// names
 
@@ -291,6 +377,9 @@ func initSbot(s *Sbot) (*Sbot, error) {
 	var histOpts = []interface{}{
 		gossip.HopCount(s.hopCount),
 		gossip.Promisc(s.promisc),
+		s.peerStates,
+		s.gossipLog,
+		s.partial,
 	}
 
 	if s.systemGauge != nil {
@@ -301,43 +390,86 @@ func initSbot(s *Sbot) (*Sbot, error) {
 		histOpts = append(histOpts, s.eventCounter)
 	}
 
-	if s.signHMACsecret != nil {
-		var k [32]byte
-		copy(k[:], s.signHMACsecret)
-		histOpts = append(histOpts, gossip.HMACSecret(&k))
+	if k := s.hmacKey(); k != nil {
+		histOpts = append(histOpts, gossip.HMACSecret(k))
+	}
+	s.progress = gossip.NewProgress(s.Replicator.Lister())
+	gossipOpts := append(histOpts, gossip.FetchStrangers(s.strangers), s.progress)
+	if s.enableEBT {
+		ebtOpts := []interface{}{s.peerStates, s.partial}
+		if k := s.hmacKey(); k != nil {
+			ebtOpts = append(ebtOpts, ebt.HMACSecret(k))
+		}
+		rep := ebt.New(kitlog.With(log, "plugin", "ebt"),
+			s.KeyPair.Id, s.liveLog, uf, s.Replicator.Lister(),
+			ebtOpts...)
+		s.ebt = rep
+		s.public.Register(rep)
+		gossipOpts = append(gossipOpts, rep)
 	}
-	s.public.Register(gossip.New(ctx,
+	gp := gossip.New(ctx,
 		kitlog.With(log, "plugin", "gossip"),
-		s.KeyPair.Id, s.RootLog, uf, s.Replicator.Lister(),
-		histOpts...))
+		s.KeyPair.Id, s.liveLog, uf, s.Replicator.Lister(),
+		gossipOpts...)
+	s.fetcher = gp
+	s.public.Register(gp)
 
 	// incoming createHistoryStream handler
 	hist := gossip.NewHist(ctx,
 		kitlog.With(log, "plugin", "gossip/hist"),
-		s.KeyPair.Id, s.RootLog, uf, s.Replicator.Lister(),
+		s.KeyPair.Id, s.liveLog, uf, s.Replicator.Lister(),
 		histOpts...)
 	s.public.Register(hist)
 
 	s.master.Register(get.New(s))
 
 	// raw log plugins
-	s.master.Register(rawread.NewRXLog(s.RootLog)) // createLogStream
+	s.master.Register(rawread.NewRXLog(s.liveLog)) // createLogStream
 	s.master.Register(hist)                        // createHistoryStream
 
-	s.master.Register(replicate.NewPlug(uf))
+	s.master.Register(replicate.NewPlug(uf, s))
+	s.public.Register(replicate.NewUptoPlug(uf, s.Replicator.Lister())) // for the schedule of legacy peers
 
 	s.master.Register(friends.New(log, *s.KeyPair.Id, s.GraphBuilder))
 
+	if s.enableConnManager {
+		if err := s.newConnManager(); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.authzHops > 0 {
+		s.connAuthorizers = append(s.connAuthorizers, authz.NewGraph(s.GraphBuilder, s.KeyPair.Id, s.authzHops))
+	} else {
+		// the graph policy denies them, too
+		s.connAuthorizers = append(s.connAuthorizers, authz.NewBlocks(s.GraphBuilder, s.KeyPair.Id))
+	}
+
 	// tcp+shs
 	opts := network.Options{
 		Logger:              s.info,
 		Dialer:              s.dialer,
+		SOCKS5Proxy:         s.socks5Proxy,
+		SOCKS5ProxyAll:      s.socks5ProxyAll,
 		ListenAddr:          s.listenAddr,
+		ExtraListenAddrs:    s.extraListenAddrs,
+		WebsocketAddr:       s.wsAddr,
+		WebsocketTLSCert:    s.wsTLSCert,
+		WebsocketTLSKey:     s.wsTLSKey,
 		AdvertsSend:         s.enableAdverts,
 		AdvertsConnectTo:    s.enableDiscovery,
+		DiscoveryFilter:     s.discoveryFilter(),
+		DialTimeout:         s.dialTimeout,
+		HandshakeTimeout:    s.handshakeTimeout,
+		Bandwidth:           s.bandwidthLimits(),
+		TrafficCounter:      s.trafficCounter,
+		GossipLog:           s.gossipLog,
+		AcceptLimits:        s.acceptLimits,
+		ConnAuthorizer:      connAuthorizer(s.connAuthorizers),
+		CallAuthorizer:      callAuthorizer(s.callAuthorizers),
 		KeyPair:             s.KeyPair,
 		AppKey:              s.appKey[:],
-		MakeHandler:         mkHandler,
+		MakeHandler:         s.trackCalls(mkHandler),
 		ConnTracker:         s.networkConnTracker,
 		BefreCryptoWrappers: s.preSecureWrappers,
 		AfterSecureWrappers: s.postSecureWrappers,
@@ -367,8 +499,57 @@ func initSbot(s *Sbot) (*Sbot, error) {
 	s.master.Register(inviteService.MasterPlugin())
 
 	// TODO: should be gossip.connect but conflicts with our namespace assumption
-	s.master.Register(control.NewPlug(kitlog.With(log, "plugin", "ctrl"), s.Network, s))
+	var sched ssb.ConnScheduler
+	if s.connManager != nil {
+		sched = s.connManager
+		s.startConnManager()
+	}
+	if s.pubAnnounceAddr != "" {
+		if _, err := s.pubAnnouncement(s.pubAnnounceAddr); err != nil {
+			return nil, err
+		}
+		s.startPubAnnouncement()
+	}
+
+	s.master.Register(control.NewPlug(kitlog.With(log, "plugin", "ctrl"), s.Network, s, sched))
 	s.master.Register(status.New(s))
+	s.master.Register(config.New(s))
 
 	return s, nil
 }
+
+// bandwidthLimits are the limits of WithBandwidthLimits, with the follow graph to tell followed peers from strangers
+func (s *Sbot) bandwidthLimits() network.BandwidthLimits {
+	bw := s.bandwidth
+	bw.IsFollowed = func(ref *ssb.FeedRef) bool {
+		g, err := s.GraphBuilder.Build()
+		if err != nil {
+			level.Warn(s.info).Log("event", "bandwidth class", "err", err)
+			return false
+		}
+		return g.Follows(s.KeyPair.Id, ref)
+	}
+	return bw
+}
+
+// connAuthorizer combines the authorizers, nil means the network doesn't need to ask
+func connAuthorizer(auths []ssb.ConnAuthorizer) ssb.ConnAuthorizer {
+	switch len(auths) {
+	case 0:
+		return nil
+	case 1:
+		return auths[0]
+	}
+	return authz.AllConns(auths...)
+}
+
+// callAuthorizer combines the authorizers, nil means the network doesn't need to ask
+func callAuthorizer(auths []ssb.CallAuthorizer) ssb.CallAuthorizer {
+	switch len(auths) {
+	case 0:
+		return nil
+	case 1:
+		return auths[0]
+	}
+	return authz.AllCalls(auths...)
+}
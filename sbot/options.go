@@ -12,6 +12,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/cryptix/go/logging"
 	kitlog "github.com/go-kit/kit/log"
@@ -24,10 +25,18 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/blobstore"
 	"go.cryptoscope.co/ssb/graph"
+	"go.cryptoscope.co/ssb/internal/livelog"
 	"go.cryptoscope.co/ssb/internal/netwraputil"
+	"go.cryptoscope.co/ssb/internal/partial"
 	"go.cryptoscope.co/ssb/message/multimsg"
+	"go.cryptoscope.co/ssb/multilogs"
 	"go.cryptoscope.co/ssb/network"
+	"go.cryptoscope.co/ssb/plugins/ebt"
+	"go.cryptoscope.co/ssb/plugins/gossip"
+	"go.cryptoscope.co/ssb/plugins2"
+	"go.cryptoscope.co/ssb/private/keys"
 	"go.cryptoscope.co/ssb/repo"
 )
 
@@ -51,17 +60,53 @@ type Sbot struct {
 	closedMu sync.Mutex
 	closeErr error
 
+	calls        callCounter
+	drainTimeout time.Duration
+	stoppers     []plugins2.Stopper
+
 	promisc  bool
 	hopCount uint
 
+	// replicate with ebt.replicate where the peer supports it
+	enableEBT bool
+	ebt       *ebt.Replicator
+
+	// the order of the feeds to fetch
+	scheduleWeights ScheduleWeights
+
+	// fetch the feeds of peers that aren't in the hops
+	strangers bool
+
+	// what is replicated of the feeds from a distance on, and the feeds of which only some types are stored
+	hopPolicies map[uint]HopPolicy
+	partial     *partial.Store
+
+	// null the stored messages of feeds once they are blocked
+	deleteBlocked bool
+
 	// TODO: these should all be options that are applied on the network construction...
 	Network            ssb.Network
 	disableNetwork     bool
 	appKey             []byte
 	listenAddr         net.Addr
+	extraListenAddrs   []net.Addr
+	wsAddr             net.Addr
+	wsTLSCert          string
+	wsTLSKey           string
+	dialTimeout        time.Duration
+	handshakeTimeout   time.Duration
 	dialer             netwrap.Dialer
+	socks5Proxy        string
+	socks5ProxyAll     bool
+	bandwidth          network.BandwidthLimits
+	trafficCounter     metrics.Counter
+	acceptLimits       network.AcceptLimits
 	edpWrapper         MuxrpcEndpointWrapper
 	networkConnTracker ssb.ConnTracker
+	connManager        *network.ConnManager
+	connManagerOpts    []network.ConnManagerOption
+	connManagerSticky  []*ssb.FeedRef
+	enableConnManager  bool
 	preSecureWrappers  []netwrap.ConnWrapper
 	postSecureWrappers []netwrap.ConnWrapper
 
@@ -70,29 +115,88 @@ type Sbot struct {
 
 	authorizer ssb.Authorizer
 
+	connAuthorizers []ssb.ConnAuthorizer
+	callAuthorizers []ssb.CallAuthorizer
+	authzHops       int
+
+	pubAnnounceAddr      string
+	pubAnnounceMu        sync.Mutex
+	allowPrivatePubAddrs bool
+
 	enableAdverts   bool
 	enableDiscovery bool
 
-	repoPath string
-	KeyPair  *ssb.KeyPair
+	repoPath   string
+	readOnly   bool
+	logBackend repo.LogBackend
+	handles    *repo.Handles // the badger databases the repo opened, for Snapshot
+	KeyPair    *ssb.KeyPair
+
+	skipStartupCheck bool
 
 	RootLog multimsg.AlterableLog
 
 	PublishLog     ssb.Publisher
 	signHMACsecret []byte
 
+	// one publish log per feed, so that PublishAs calls for the same identity are serialized
+	publishersMu sync.Mutex
+	publishers   map[string]ssb.Publisher
+
+	// the keys of box2, for the private plugin
+	keysManager *keys.Manager
+	privKeys    []*keys.Manager
+	privReplays *multilogs.PrivateReplays
+
+	// content with recps is encrypted when it is published, see private.NewAutoBoxPublisher
+	disableAutoBox bool
+	autoBoxBox2    bool
+
 	mlogIndicies map[string]multilog.MultiLog
 	simpleIndex  map[string]librarian.Index
 
+	// serves the live queries of createLogStream, createHistoryStream queries the sublogs with queues of their own
+	liveLog       *livelog.Log
+	liveQueueSize int
+
 	liveIndexUpdates bool
+	indexBatchSize   int
+	indexBatchDelay  time.Duration
 	indexStateMu     sync.Mutex
 	indexStates      map[string]string
+	indexSinks       map[string]librarian.SinkIndex
 
 	GraphBuilder graph.Builder
 
+	// what we know about the replication with each peer
+	peerStates *gossip.PeerStates
+
+	// connects, disconnects and replications for ctrl.gossipLog
+	gossipLog *network.GossipLog
+
+	// the progress of the fetches, for replicate.changes
+	progress *gossip.Progress
+
+	// keeps imports of the same feed apart
+	imports importTracker
+
+	// fetches single feeds for FetchFeed, the ones that are running by feed
+	fetcher feedFetcher
+	fetchMu sync.Mutex
+	fetches map[string]*pendingFetch
+
 	BlobStore   ssb.BlobStore
 	WantManager ssb.WantManager
 
+	enableBlobGC bool
+	blobGCOpts   []blobstore.GCOption
+
+	// the size of the biggest blob we fetch or add, 0 for blobstore.DefaultMaxSize
+	blobSizeLimit uint
+
+	// announces blobs of our recent messages, nil if disabled
+	blobPush *blobPusher
+
 	// TODO: wrap better
 	eventCounter metrics.Counter
 	systemGauge  metrics.Gauge
@@ -110,6 +214,15 @@ func WithBlobStore(bs ssb.BlobStore) Option {
 	}
 }
 
+// WithBlobSizeLimit sets the size in bytes of the biggest blob that is fetched from peers or stored with blobs.add.
+// The default is blobstore.DefaultMaxSize, which is what the other implementations use.
+func WithBlobSizeLimit(sz uint) Option {
+	return func(s *Sbot) error {
+		s.blobSizeLimit = sz
+		return nil
+	}
+}
+
 // DisableLiveIndexMode makes the update processing halt once it reaches the end of the rootLog
 // makes it easier to rebuild indicies.
 func DisableLiveIndexMode() Option {
@@ -119,6 +232,18 @@ func DisableLiveIndexMode() Option {
 	}
 }
 
+// WithLiveQueueSize sets how many messages a live stream can fall behind before it has to catch up from the log
+// instead of getting new messages passed directly. The default is livelog.DefaultQueueSize.
+func WithLiveQueueSize(n int) Option {
+	return func(s *Sbot) error {
+		if n < 1 {
+			return errors.Errorf("sbot: live queue size needs to be positive")
+		}
+		s.liveQueueSize = n
+		return nil
+	}
+}
+
 func WithRepoPath(path string) Option {
 	return func(s *Sbot) error {
 		s.repoPath = path
@@ -126,6 +251,18 @@ func WithRepoPath(path string) Option {
 	}
 }
 
+// WithLogBackend sets the storage of the root log, see repo.LogBackend.
+// The repo remembers the backend it was created with, opening it with another one fails.
+func WithLogBackend(b repo.LogBackend) Option {
+	return func(s *Sbot) error {
+		if b == nil {
+			return errors.Errorf("sbot: nil log backend")
+		}
+		s.logBackend = b
+		return nil
+	}
+}
+
 func DisableNetworkNode() Option {
 	return func(s *Sbot) error {
 		s.disableNetwork = true
@@ -141,6 +278,134 @@ func WithListenAddr(addr string) Option {
 	}
 }
 
+// WithListenAddrs listens on all of the addresses, like 0.0.0.0:8008 and [::]:8008 for IPv4 and IPv6.
+// The first one is the main one, like with WithListenAddr.
+func WithListenAddrs(addrs ...string) Option {
+	return func(s *Sbot) error {
+		if len(addrs) == 0 {
+			return errors.New("sbot: need at least one listen address")
+		}
+		s.extraListenAddrs = nil
+		for i, addr := range addrs {
+			tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+			if err != nil {
+				return errors.Wrapf(err, "failed to parse tcp listen addr %q", addr)
+			}
+			if i == 0 {
+				s.listenAddr = tcpAddr
+			} else {
+				s.extraListenAddrs = append(s.extraListenAddrs, tcpAddr)
+			}
+		}
+		return nil
+	}
+}
+
+// WithWebsocketAddr also accepts connections over websockets on addr, for browser clients and rooms.
+func WithWebsocketAddr(addr string) Option {
+	return func(s *Sbot) error {
+		var err error
+		s.wsAddr, err = net.ResolveTCPAddr("tcp", addr)
+		return errors.Wrap(err, "failed to parse websocket listen addr")
+	}
+}
+
+// WithWebsocketTLS serves wss with the certificate and key in the passed files instead of plain ws.
+func WithWebsocketTLS(certFile, keyFile string) Option {
+	return func(s *Sbot) error {
+		if certFile == "" || keyFile == "" {
+			return errors.New("WithWebsocketTLS: need both the certificate and the key file")
+		}
+		s.wsTLSCert = certFile
+		s.wsTLSKey = keyFile
+		return nil
+	}
+}
+
+// WithDialTimeouts limits how long dialing a peer can take, connect for TCP and handshake for the secret-handshake after it.
+// Zero keeps the defaults of the network package, negative values disable the limit.
+func WithDialTimeouts(connect, handshake time.Duration) Option {
+	return func(s *Sbot) error {
+		s.dialTimeout = connect
+		s.handshakeTimeout = handshake
+		return nil
+	}
+}
+
+// WithConnAuthorizer adds a policy that is asked after the handshake if a peer may stay connected.
+// It can be used more than once, all of them need to allow the connection.
+func WithConnAuthorizer(a ssb.ConnAuthorizer) Option {
+	return func(s *Sbot) error {
+		s.connAuthorizers = append(s.connAuthorizers, a)
+		return nil
+	}
+}
+
+// WithCallAuthorizer adds a policy that is asked for every muxrpc call a peer makes.
+// It can be used more than once, all of them need to allow the call.
+func WithCallAuthorizer(a ssb.CallAuthorizer) Option {
+	return func(s *Sbot) error {
+		s.callAuthorizers = append(s.callAuthorizers, a)
+		return nil
+	}
+}
+
+// WithAuthzHops only lets peers connect to us that are at most hops away in our follow graph, and none we block.
+// See authz.Graph.
+func WithAuthzHops(hops int) Option {
+	return func(s *Sbot) error {
+		if hops < 0 {
+			return errors.Errorf("sbot: invalid authz hops: %d", hops)
+		}
+		s.authzHops = hops
+		return nil
+	}
+}
+
+// WithSOCKS5Proxy dials onion addresses through the SOCKS5 proxy at addr, usually a local tor daemon on localhost:9050.
+// If all is true, every other peer is dialed through it, too.
+func WithSOCKS5Proxy(addr string, all bool) Option {
+	return func(s *Sbot) error {
+		s.socks5Proxy = addr
+		s.socks5ProxyAll = all
+		return nil
+	}
+}
+
+// WithBandwidthLimits limits how fast all peers together (global) and each connection may send and receive.
+// Connections with peers we follow get the followed limit, all others the stranger one. Zero limits don't limit.
+func WithBandwidthLimits(global, followed, stranger network.RateLimit) Option {
+	return func(s *Sbot) error {
+		for _, rl := range []network.RateLimit{global, followed, stranger} {
+			if rl.In < 0 || rl.Out < 0 {
+				return errors.Errorf("sbot: invalid rate limit: %+v", rl)
+			}
+		}
+		s.bandwidth.Global = global
+		s.bandwidth.Followed = followed
+		s.bandwidth.Stranger = stranger
+		return nil
+	}
+}
+
+// WithTrafficMetrics adds the bytes exchanged with peers to ctr, with direction (in or out) and class (followed or stranger) labels
+func WithTrafficMetrics(ctr metrics.Counter) Option {
+	return func(s *Sbot) error {
+		s.trafficCounter = ctr
+		return nil
+	}
+}
+
+// WithAcceptLimits sets how many incoming handshakes may run at once, how often one IP address may connect
+// and how long it is banned after failed handshakes. They only apply with limits.Enabled set.
+// Zero values keep the defaults of network.AcceptLimits, negative ones turn the limit off.
+func WithAcceptLimits(limits network.AcceptLimits) Option {
+	return func(s *Sbot) error {
+		s.acceptLimits = limits
+		return nil
+	}
+}
+
 func WithDialer(dial netwrap.Dialer) Option {
 	return func(s *Sbot) error {
 		s.dialer = dial
@@ -245,7 +510,7 @@ func WithAppKey(k []byte) Option {
 
 func WithNamedKeyPair(name string) Option {
 	return func(s *Sbot) error {
-		r := repo.New(s.repoPath)
+		r := s.repository()
 		var err error
 		s.KeyPair, err = repo.LoadKeyPair(r, name)
 		return errors.Wrapf(err, "loading named key-pair %q failed", name)
@@ -329,6 +594,41 @@ func EnableAdvertismentDialing(do bool) Option {
 	}
 }
 
+// WithKeyManager sets the manager with the group keys the private plugin publishes and reads box2 messages with.
+// Without it the bot only knows the direct message keys of its own keypair.
+func WithKeyManager(km *keys.Manager) Option {
+	return func(s *Sbot) error {
+		s.keysManager = km
+		return nil
+	}
+}
+
+// WithPrivateIndexKeys sets the managers the privates index reads with.
+// The groups they learn start replays of the older messages, like the ones of WithKeyManager.
+func WithPrivateIndexKeys(kms ...*keys.Manager) Option {
+	return func(s *Sbot) error {
+		s.privKeys = kms
+		return nil
+	}
+}
+
+// EnableAutoBox controls if published content with a recps array is encrypted to those recipients, like the JS sbot does.
+// It is on by default, callers that box their messages themselves can turn it off.
+func EnableAutoBox(do bool) Option {
+	return func(s *Sbot) error {
+		s.disableAutoBox = !do
+		return nil
+	}
+}
+
+// WithAutoBox2 makes auto-boxed messages to feeds use box2 instead of box1. Messages to groups are always box2.
+func WithAutoBox2(yes bool) Option {
+	return func(s *Sbot) error {
+		s.autoBoxBox2 = yes
+		return nil
+	}
+}
+
 func WithHMACSigning(key []byte) Option {
 	return func(s *Sbot) error {
 		if n := len(key); n != 32 {
@@ -344,7 +644,8 @@ func WithHMACSigning(key []byte) Option {
 // 0: only my own follows
 // 1: my friends follows
 // 2: also their friends follows
-// and how many hops a peer can be from self to for a connection to be accepted
+// and how many hops a peer can be from self to for a connection to be accepted.
+// Feeds that move further away than this are not fetched any more, unless they were added with Replicate.
 func WithHops(h uint) Option {
 	return func(s *Sbot) error {
 		s.hopCount = h
@@ -352,6 +653,74 @@ func WithHops(h uint) Option {
 	}
 }
 
+// DeleteBlockedFeeds makes the bot delete the stored messages of the feeds it blocks, like go-sbot -cleanup does.
+// Blocked feeds are never fetched, with or without it.
+func DeleteBlockedFeeds(yes bool) Option {
+	return func(s *Sbot) error {
+		s.deleteBlocked = yes
+		return nil
+	}
+}
+
+// EnableEBT makes the bot replicate with epidemic broadcast trees (ebt.replicate) with peers that support it.
+// Peers that don't are still asked with createHistoryStream.
+func EnableEBT(yes bool) Option {
+	return func(s *Sbot) error {
+		s.enableEBT = yes
+		return nil
+	}
+}
+
+// EBT returns the replicator of EnableEBT, nil without it
+func (s *Sbot) EBT() *ebt.Replicator { return s.ebt }
+
+// WithScheduleWeights sets the order in which the feeds are fetched, DefaultScheduleWeights without it
+func WithScheduleWeights(w ScheduleWeights) Option {
+	return func(s *Sbot) error {
+		if w.Hops < 0 || w.Lag < 0 {
+			return errors.New("sbot: schedule weights can't be negative")
+		}
+		s.scheduleWeights = w
+		return nil
+	}
+}
+
+// HopPolicy is what is replicated of the feeds at some distance in the follow graph, see WithHopPolicy.
+// The zero value replicates them fully.
+type HopPolicy struct {
+	// Skip doesn't fetch the feeds at all
+	Skip bool
+
+	// Types, if there are any, are the only content types whose messages are stored.
+	// All messages are still fetched to verify the feed. Encrypted ones have the type "private".
+	Types []string
+}
+
+// WithHopPolicy sets what is replicated of the feeds that are hops or more away, until the next hop level that has a policy.
+// Our own feed and the ones added with Replicate are always replicated fully.
+// Peers don't get the partially replicated feeds from us and a feed that becomes full again is fetched anew.
+func WithHopPolicy(hops uint, p HopPolicy) Option {
+	return func(s *Sbot) error {
+		if hops == 0 {
+			return errors.New("sbot: our own feed is always replicated fully")
+		}
+		if s.hopPolicies == nil {
+			s.hopPolicies = make(map[uint]HopPolicy)
+		}
+		s.hopPolicies[hops] = p
+		return nil
+	}
+}
+
+// WithReplicateStrangers makes the bot fetch the feed of every peer it talks to, also of those that are further away than the hops.
+// Which peers may connect at all is still up to the authorizer, see WithPublicAuthorizer.
+func WithReplicateStrangers(yes bool) Option {
+	return func(s *Sbot) error {
+		s.strangers = yes
+		return nil
+	}
+}
+
 // WithPromisc when enabled bypasses graph-distance lookups on connections and makes the gossip handler fetch the remotes feed
 func WithPromisc(yes bool) Option {
 	return func(s *Sbot) error {
@@ -392,13 +761,23 @@ func LateOption(o Option) Option {
 func New(fopts ...Option) (*Sbot, error) {
 	var s Sbot
 	s.liveIndexUpdates = true
+	s.liveQueueSize = livelog.DefaultQueueSize
+	s.indexBatchSize = DefaultIndexBatchSize
+	s.indexBatchDelay = DefaultIndexBatchDelay
+	s.drainTimeout = DefaultDrainTimeout
 
 	s.public = ssb.NewPluginManager()
 	s.master = ssb.NewPluginManager()
 
 	s.mlogIndicies = make(map[string]multilog.MultiLog)
 	s.simpleIndex = make(map[string]librarian.Index)
+	s.handles = repo.NewHandles()
 	s.indexStates = make(map[string]string)
+	s.indexSinks = make(map[string]librarian.SinkIndex)
+	s.fetches = make(map[string]*pendingFetch)
+	s.scheduleWeights = DefaultScheduleWeights
+	s.peerStates = gossip.NewPeerStates()
+	s.gossipLog = network.NewGossipLog(0)
 
 	for i, opt := range fopts {
 		err := opt(&s)
@@ -442,7 +821,7 @@ func New(fopts ...Option) (*Sbot, error) {
 		s.rootCtx = context.TODO()
 	}
 
-	r := repo.New(s.repoPath)
+	r := s.repository()
 
 	if s.KeyPair == nil {
 		var err error
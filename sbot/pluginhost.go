@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"net"
+	"os"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+
+	"go.cryptoscope.co/ssb/internal/netwraputil"
+	"go.cryptoscope.co/ssb/internal/pluginhost"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+// WithPluginHost lets other processes serve muxrpc methods of the bot (see package internal/pluginhost).
+// They connect to the socket plugins.sock in the repo and authenticate with the token in plugins.token,
+// which is rewritten on every start. Both are only accessible by the user of the bot.
+//
+// Like WithUNIXSocket it needs the keypair and has to be used with LateOption.
+func WithPluginHost() Option {
+	return func(s *Sbot) error {
+		if s.KeyPair == nil {
+			return errors.Errorf("sbot/pluginhost: keypair is nil. please use WithPluginHost with LateOption")
+		}
+
+		r := repo.New(s.repoPath)
+		sockPath := r.GetPath("plugins.sock")
+
+		c, err := net.Dial("unix", sockPath)
+		if err == nil {
+			c.Close()
+			return errors.Errorf("sbot/pluginhost: repo already in use, socket accepted connection")
+		}
+		os.Remove(sockPath)
+
+		host, err := pluginhost.New(pluginhost.Options{
+			Logger:    kitlog.With(s.info, "plugin", "pluginhost"),
+			Master:    s.master,
+			Public:    s.public,
+			WrapConn:  netwraputil.SpoofRemoteAddress(s.KeyPair.Id.ID),
+			TokenPath: r.GetPath("plugins.token"),
+		})
+		if err != nil {
+			return err
+		}
+
+		lis, err := netwraputil.ListenUnixPrivate(sockPath)
+		if err != nil {
+			return errors.Wrap(err, "sbot/pluginhost")
+		}
+		s.closers.addCloser(lis)
+
+		go func() {
+			if err := host.Serve(s.rootCtx, lis); err != nil {
+				level.Warn(s.info).Log("event", "pluginhost stopped", "err", err)
+			}
+		}()
+		return nil
+	}
+}
@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"context"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/margaret/multilog"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/multilogs"
+	"go.cryptoscope.co/ssb/private/keys"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+// openPrivateReplays continues the replays of the privates index that didn't finish before the last shutdown
+// and starts one for every group the managers of the index learn from now on.
+func (s *Sbot) openPrivateReplays(r repo.Interface, privs multilog.MultiLog) error {
+	kms := []*keys.Manager{s.keysManager}
+	for _, km := range s.privKeys {
+		if km.KeyPair().Id.Equal(s.keysManager.KeyPair().Id) {
+			continue // the one of the private plugin has the groups
+		}
+		kms = append(kms, km)
+	}
+
+	replays, err := multilogs.OpenPrivateReplays(kitlog.With(s.info, "module", "privReplays"), r.GetPath("privates-replays.json"), s.RootLog, privs, s, kms...)
+	if err != nil {
+		return errors.Wrap(err, "sbot: failed to open private replays")
+	}
+	s.privReplays = replays
+
+	for _, km := range kms {
+		feed := km.KeyPair().Id
+		km.OnNewGroup(func(grp keys.Group) {
+			if _, err := s.ReplayPrivates(feed, grp.ID); err != nil {
+				level.Warn(s.info).Log("event", "private replay", "feed", feed.Ref(), "group", grp.ID, "err", err)
+			}
+		})
+	}
+
+	if s.readOnly {
+		return nil
+	}
+	for _, name := range replays.Pending() {
+		s.runPrivateReplay(name)
+	}
+	return nil
+}
+
+// ReplayPrivates reads the receive log again with the key of group, or the keypair of feed if group is empty,
+// for the private messages that were indexed before it was known. It returns the name of the job, its progress is in the status.
+func (s *Sbot) ReplayPrivates(feed *ssb.FeedRef, group string) (string, error) {
+	if s.privReplays == nil {
+		return "", errors.New("sbot: private messages are not indexed")
+	}
+	if s.readOnly {
+		return "", repo.ErrReadOnly
+	}
+	name, err := s.privReplays.Add(feed, group)
+	if err != nil {
+		return "", err
+	}
+	s.runPrivateReplay(name)
+	return name, nil
+}
+
+func (s *Sbot) runPrivateReplay(name string) {
+	s.idxDone.Go(func() error {
+		err := s.privReplays.Run(s.rootCtx, name)
+		if cause := errors.Cause(err); cause == ssb.ErrShuttingDown || cause == context.Canceled {
+			return nil
+		}
+		if err != nil {
+			// not fatal for the indexes, the job continues on the next start
+			level.Warn(s.info).Log("event", "private replay", "job", name, "err", err)
+		}
+		return nil
+	})
+}
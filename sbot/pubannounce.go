@@ -0,0 +1,182 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/librarian"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/netwrap"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/mutil"
+	"go.cryptoscope.co/ssb/network"
+)
+
+// WithPubAnnouncement publishes a pub message with addr, the address others can reach the bot on from the internet,
+// whenever it differs from the one the bot announced last. addr is host:port or net:host:port~shs:key with our key.
+// ctrl.announce publishes a new one at runtime.
+func WithPubAnnouncement(addr string) Option {
+	return func(s *Sbot) error {
+		s.pubAnnounceAddr = addr
+		return nil
+	}
+}
+
+// WithPrivatePubAddresses lets addresses on private networks and loopback through,
+// both in our own announcements and the ones we take from the messages of others. Only useful for test networks.
+func WithPrivatePubAddresses(allow bool) Option {
+	return func(s *Sbot) error {
+		s.allowPrivatePubAddrs = allow
+		return nil
+	}
+}
+
+var _ ssb.PubAnnouncer = (*Sbot)(nil)
+
+// AnnouncePub publishes addr as our pub address unless it is the one we announced last.
+// An empty addr announces the one set with WithPubAnnouncement. It returns nil if nothing had to be published.
+func (s *Sbot) AnnouncePub(addr string) (*ssb.MessageRef, error) {
+	if addr == "" {
+		addr = s.pubAnnounceAddr
+	}
+	if addr == "" {
+		return nil, errors.New("sbot: no pub address to announce")
+	}
+	msg, err := s.pubAnnouncement(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s.pubAnnounceMu.Lock()
+	defer s.pubAnnounceMu.Unlock()
+	last, err := s.lastPubAnnouncement()
+	if err != nil {
+		return nil, err
+	}
+	if last != nil && last.Host == msg.Address.Host && last.Port == msg.Address.Port {
+		return nil, nil
+	}
+	ref, err := s.PublishLog.Publish(msg)
+	if err != nil {
+		return nil, errors.Wrap(err, "sbot: failed to publish pub announcement")
+	}
+	return ref, nil
+}
+
+// pubAnnouncement checks addr and makes the pub message for it
+func (s *Sbot) pubAnnouncement(addr string) (*ssb.OldPubMessage, error) {
+	var ap announcedPub
+	if strings.Contains(addr, "~shs:") {
+		wrapped, err := network.ParseAddress(addr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "sbot: invalid pub address %q", addr)
+		}
+		key, err := ssb.GetFeedRefFromAddr(wrapped)
+		if err != nil {
+			return nil, err
+		}
+		if !key.Equal(s.KeyPair.Id) {
+			return nil, errors.Errorf("sbot: pub address %q has the key %s, not ours", addr, key.ShortRef())
+		}
+		ap.addr = netwrap.GetAddr(wrapped, "tcp")
+		if ap.addr == nil {
+			ap.addr = netwrap.GetAddr(wrapped, "onion")
+		}
+	} else {
+		host, portStr, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "sbot: invalid pub address %q", addr)
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return nil, errors.Errorf("sbot: invalid port in pub address %q", addr)
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			ap.addr = &net.TCPAddr{IP: ip, Port: port}
+		} else if strings.HasSuffix(host, ".onion") {
+			ap.addr = &network.HostAddr{Net: "onion", Host: host, Port: port}
+		} else {
+			ap.addr = &network.HostAddr{Net: "tcp", Host: host, Port: port}
+		}
+	}
+	if err := checkPubAddress(&ap, s.allowPrivatePubAddrs); err != nil {
+		return nil, errors.Wrap(err, "sbot: refusing to announce")
+	}
+
+	msg := &ssb.OldPubMessage{Type: "pub"}
+	msg.Address.Key = *s.KeyPair.Id
+	switch a := ap.addr.(type) {
+	case *net.TCPAddr:
+		msg.Address.Host, msg.Address.Port = a.IP.String(), a.Port
+	case *network.HostAddr:
+		msg.Address.Host, msg.Address.Port = a.Host, a.Port
+	}
+	return msg, nil
+}
+
+// lastPubAnnouncement returns the address of the newest pub message we published about ourself, nil if there is none
+func (s *Sbot) lastPubAnnouncement() (*ssb.OldAddress, error) {
+	mt, ok := s.mlogIndicies["msgTypes"]
+	if !ok {
+		return nil, errors.New("sbot: pub announcements need the msgTypes index")
+	}
+	pubs, err := mt.Get(librarian.Addr("pub"))
+	if err != nil {
+		return nil, errors.Wrap(err, "sbot: failed to open pub messages")
+	}
+	src, err := mutil.Indirect(s.RootLog, pubs).Query()
+	if err != nil {
+		return nil, errors.Wrap(err, "sbot: failed to query pub messages")
+	}
+	var last *ssb.OldAddress
+	for {
+		v, err := src.Next(s.rootCtx)
+		if luigi.IsEOS(err) {
+			return last, nil
+		} else if err != nil {
+			return nil, errors.Wrap(err, "sbot: failed to read pub messages")
+		}
+		msg, ok := v.(ssb.Message)
+		if !ok || !msg.Author().Equal(s.KeyPair.Id) {
+			continue
+		}
+		ap, err := pubAddress(msg.ContentBytes())
+		if err != nil || !ap.feed.Equal(s.KeyPair.Id) {
+			continue
+		}
+		last = &ssb.OldAddress{Key: *ap.feed}
+		switch a := ap.addr.(type) {
+		case *net.TCPAddr:
+			last.Host, last.Port = a.IP.String(), a.Port
+		case *network.HostAddr:
+			last.Host, last.Port = a.Host, a.Port
+		}
+	}
+}
+
+// startPubAnnouncement publishes the address of WithPubAnnouncement once the indexes caught up,
+// so that the pub messages we already published are known
+func (s *Sbot) startPubAnnouncement() {
+	log := kitlog.With(s.info, "module", "pubannounce")
+	go func() {
+		s.WaitUntilIndexesAreSynced()
+		if s.rootCtx.Err() != nil {
+			return
+		}
+		ref, err := s.AnnouncePub("")
+		if err != nil {
+			level.Warn(log).Log("event", "pub announcement failed", "err", err)
+			return
+		}
+		if ref != nil {
+			level.Info(log).Log("event", "announced pub address", "addr", s.pubAnnounceAddr, "msg", ref.Ref())
+		}
+	}()
+}
@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/ssb"
+)
+
+func TestPubAnnouncement(t *testing.T) {
+	r := require.New(t)
+
+	kp, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+	other, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+	key := func(kp *ssb.KeyPair) string {
+		return base64.StdEncoding.EncodeToString(kp.Id.PubKey())
+	}
+
+	s := &Sbot{KeyPair: kp}
+
+	msg, err := s.pubAnnouncement("pub.example.org:8008")
+	r.NoError(err)
+	r.Equal("pub", msg.Type)
+	r.Equal("pub.example.org", msg.Address.Host)
+	r.Equal(8008, msg.Address.Port)
+	r.True(msg.Address.Key.Equal(kp.Id))
+
+	msg, err = s.pubAnnouncement("net:203.0.113.7:8008~shs:" + key(kp))
+	r.NoError(err)
+	r.Equal("203.0.113.7", msg.Address.Host)
+
+	for _, bad := range []string{
+		"192.168.1.2:8008",
+		"127.0.0.1:8008",
+		"[fd00::1]:8008",
+		"localhost:8008",
+		"pub.example.org:0",
+		"pub.example.org",
+		"net:203.0.113.7:8008~shs:" + key(other),
+	} {
+		_, err := s.pubAnnouncement(bad)
+		r.Error(err, "announced %s", bad)
+	}
+
+	s.allowPrivatePubAddrs = true
+	msg, err = s.pubAnnouncement("192.168.1.2:8008")
+	r.NoError(err)
+	r.Equal("192.168.1.2", msg.Address.Host)
+}
+
+func TestCheckPubAddress(t *testing.T) {
+	r := require.New(t)
+
+	kp, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+
+	for host, public := range map[string]bool{
+		"203.0.113.7":      true,
+		"2001:db8::1":      true,
+		"pub.example.org":  true,
+		"abcdefghij.onion": true,
+		"10.1.2.3":         false,
+		"172.20.0.1":       false,
+		"100.64.1.1":       false,
+		"169.254.1.1":      false,
+		"::1":              false,
+		"0.0.0.0":          false,
+		"localhost":        false,
+		"printer.local":    false,
+	} {
+		content := fmt.Sprintf(`{"type":"pub","address":{"host":%q,"port":8008,"key":%q}}`, host, kp.Id.Ref())
+		ap, err := pubAddress([]byte(content))
+		r.NoError(err, host)
+		err = checkPubAddress(ap, false)
+		if public {
+			r.NoError(err, host)
+		} else {
+			r.Error(err, host)
+			r.NoError(checkPubAddress(ap, true), host)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"go.cryptoscope.co/margaret"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+// ReadOnly opens the repo with repo.NewReadOnly, for backups and analytics next to a running bot.
+// Publishing and blob uploads fail with repo.ErrReadOnly, indexes are not updated and the network node is disabled.
+func ReadOnly() Option {
+	return func(s *Sbot) error {
+		s.readOnly = true
+		s.disableNetwork = true
+		return nil
+	}
+}
+
+func (s *Sbot) repository() repo.Interface {
+	opts := []repo.Option{repo.WithHandles(s.handles)}
+	if s.logBackend != nil {
+		opts = append(opts, repo.WithLogBackend(s.logBackend))
+	}
+	if s.readOnly {
+		return repo.NewReadOnly(s.repoPath, opts...)
+	}
+	return repo.New(s.repoPath, opts...)
+}
+
+type readOnlyPublisher struct {
+	ssb.Publisher
+}
+
+func (readOnlyPublisher) Append(interface{}) (margaret.Seq, error) {
+	return margaret.SeqEmpty, repo.ErrReadOnly
+}
+
+func (readOnlyPublisher) Publish(interface{}) (*ssb.MessageRef, error) {
+	return nil, repo.ErrReadOnly
+}
@@ -3,6 +3,7 @@ package sbot
 import (
 	"context"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -11,21 +12,114 @@ import (
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/luigi"
 	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/margaret/multilog"
 	"go.cryptoscope.co/ssb"
 	"go.cryptoscope.co/ssb/graph"
+	"go.cryptoscope.co/ssb/internal/partial"
+	"go.cryptoscope.co/ssb/multilogs"
 )
 
 var _ ssb.Replicator = (*Sbot)(nil)
 
+var _ ssb.ReplicationStater = (*Sbot)(nil)
+
+// ReplicationState returns what we know about the replication with peer
+func (s *Sbot) ReplicationState(peer *ssb.FeedRef) (ssb.ReplicationState, bool) {
+	return s.peerStates.ReplicationState(peer)
+}
+
+// ReplicationStates returns what we know about the replication with all the peers we fetched from, sorted by their ref
+func (s *Sbot) ReplicationStates() []ssb.ReplicationState {
+	return s.peerStates.ReplicationStates()
+}
+
+var _ ssb.ReplicationScheduler = (*Sbot)(nil)
+
+// Schedule returns the feeds the bot replicates, the ones it fetches first first
+func (s *Sbot) Schedule() []ssb.FeedPriority {
+	if sch, ok := s.scheduler(); ok {
+		return sch.Schedule()
+	}
+	return nil
+}
+
+// Prioritize sorts feeds like Schedule
+func (s *Sbot) Prioritize(feeds []*ssb.FeedRef) {
+	if sch, ok := s.scheduler(); ok {
+		sch.Prioritize(feeds)
+	}
+}
+
+// RemoteHas records that a peer has feed up to seq, which moves it up in the schedule if we are behind
+func (s *Sbot) RemoteHas(feed *ssb.FeedRef, seq int64) {
+	if sch, ok := s.scheduler(); ok {
+		sch.RemoteHas(feed, seq)
+	}
+}
+
+var _ ssb.ReplicationEventStreamer = (*Sbot)(nil)
+
+// ReplicationEvents returns the progress of the feeds that are fetched from now on, until cancel is called.
+// Only fetches with createHistoryStream are followed, not the ones with ebt.
+func (s *Sbot) ReplicationEvents() (<-chan ssb.ReplicationEvent, func()) {
+	if s.progress == nil {
+		return make(chan ssb.ReplicationEvent), func() {}
+	}
+	return s.progress.ReplicationEvents()
+}
+
+func (s *Sbot) scheduler() (ssb.ReplicationScheduler, bool) {
+	if s.Replicator == nil {
+		return nil, false
+	}
+	sch, ok := s.Replicator.Lister().(ssb.ReplicationScheduler)
+	return sch, ok
+}
+
 type graphReplicator struct {
 	builder graph.Builder
 	current *lister
+	sched   *schedule
+
+	// the feeds that are wanted because of the graph and the ones that were added with Replicate,
+	// only the first ones are dropped again once they leave the hops
+	graphWants *ssb.StrFeedSet
+	manual     *ssb.StrFeedSet
+
+	// the feeds that are blocked in the graph, the others in current.blocked were blocked by hand
+	graphBlocked *ssb.StrFeedSet
+
+	onBlock func(*ssb.FeedRef) // optional, called for feeds that became blocked
+
+	// what to replicate of the feeds by their distance, and how to switch them between partial and full
+	policy  func(hops int) HopPolicy
+	partial *partial.Store
+	mark    func(*ssb.FeedRef, []string) error
+	unmark  func(*ssb.FeedRef) error
 }
 
 func (s *Sbot) newGraphReplicator() (*graphReplicator, error) {
 	var r graphReplicator
 	r.builder = s.GraphBuilder
 	r.current = newLister()
+	r.graphBlocked = ssb.NewFeedSet(0)
+	r.graphWants = ssb.NewFeedSet(0)
+	r.manual = ssb.NewFeedSet(0)
+
+	uf, _ := s.GetMultiLog(multilogs.IndexNameFeeds)
+	r.sched = newSchedule(uf, s.scheduleWeights)
+	r.sched.partial = s.partial
+	r.current.sched = r.sched
+	r.current.feedWants.AddRef(s.KeyPair.Id)
+	if s.deleteBlocked {
+		r.onBlock = s.nullBlockedFeed
+	}
+	if s.partial != nil {
+		r.policy = s.hopPolicy
+		r.partial = s.partial
+		r.mark = s.markPartial
+		r.unmark = s.unmarkPartial
+	}
 
 	replicateEvt := log.With(s.info, "event", "update-replicate")
 	update := r.makeUpdater(replicateEvt, s.KeyPair.Id, int(s.hopCount))
@@ -33,6 +127,9 @@ func (s *Sbot) newGraphReplicator() (*graphReplicator, error) {
 	// update for new messages but only every 15seconds
 	go debounce(s.rootCtx, 15*time.Second, s.RootLog.Seq(), update)
 
+	// react faster when the contact graph changed, so that feeds which just came into range are fetched
+	go debounce(s.rootCtx, 2*time.Second, s.GraphBuilder.Changes(), update)
+
 	return &r, nil
 }
 
@@ -40,17 +137,65 @@ func (s *Sbot) newGraphReplicator() (*graphReplicator, error) {
 func (r *graphReplicator) makeUpdater(log log.Logger, self *ssb.FeedRef, hopCount int) func() {
 	return func() {
 		start := time.Now()
-		newWants := r.builder.Hops(self, hopCount)
-		level.Debug(log).Log("feed-want-count", newWants.Count(), "hops", hopCount, "took", time.Since(start))
 
-		refs, err := newWants.List()
-		if err != nil {
-			level.Error(log).Log("msg", "want list failed", "err", err, "wants", newWants.Count())
-			return
+		// walk once per depth for the distances, the last walk is the want list
+		hops := map[string]int{self.Ref(): 0}
+		var refs []*ssb.FeedRef
+		for depth := 0; depth <= hopCount; depth++ {
+			walked := r.builder.Hops(self, depth)
+			var err error
+			refs, err = walked.List()
+			if err != nil {
+				level.Error(log).Log("msg", "want list failed", "err", err, "wants", walked.Count())
+				return
+			}
+			for _, ref := range refs {
+				if _, has := hops[ref.Ref()]; !has {
+					hops[ref.Ref()] = depth + 1
+				}
+			}
 		}
+		refs = append(refs, self)
+		level.Debug(log).Log("feed-want-count", len(refs), "hops", hopCount, "took", time.Since(start))
+
+		newWants := ssb.NewFeedSet(len(refs))
 		for _, ref := range refs {
+			newWants.AddRef(ref)
+			r.graphWants.AddRef(ref)
+			if !r.applyPolicy(log, ref, hops[ref.Ref()]) {
+				r.current.feedWants.Delete(ref)
+				continue
+			}
+			if !r.current.feedWants.Has(ref) {
+				level.Debug(log).Log("msg", "feed entered range", "feed", ref.Ref())
+			}
 			r.current.feedWants.AddRef(ref)
 		}
+		if r.partial != nil {
+			// the ones added by hand are always full
+			for _, ref := range r.partial.List() {
+				if r.manual.Has(ref) {
+					r.applyPolicy(log, ref, -1)
+				}
+			}
+		}
+
+		// unfollowed feeds aren't fetched any more, unless they were added by hand
+		prevWants, err := r.graphWants.List()
+		if err != nil {
+			level.Error(log).Log("msg", "previous want list failed", "err", err)
+			return
+		}
+		for _, ref := range prevWants {
+			if newWants.Has(ref) {
+				continue
+			}
+			r.graphWants.Delete(ref)
+			if !r.manual.Has(ref) {
+				level.Debug(log).Log("msg", "feed left range", "feed", ref.Ref())
+				r.current.feedWants.Delete(ref)
+			}
+		}
 
 		// make sure we dont fetch and allow blocked feeds
 		g, err := r.builder.Build()
@@ -61,32 +206,185 @@ func (r *graphReplicator) makeUpdater(log log.Logger, self *ssb.FeedRef, hopCoun
 
 		newBlocked := g.BlockedList(self)
 		lst, err := newBlocked.List()
-		if err == nil {
-			for _, bf := range lst {
-				r.current.blocked.AddRef(bf)
-				r.current.feedWants.Delete(bf)
+		if err != nil {
+			level.Error(log).Log("msg", "blocked list failed", "err", err)
+			return
+		}
+		for _, bf := range lst {
+			if !r.graphBlocked.Has(bf) {
+				level.Debug(log).Log("msg", "feed blocked", "feed", bf.Ref())
+				if r.onBlock != nil {
+					r.onBlock(bf)
+				}
+			}
+			r.graphBlocked.AddRef(bf)
+			r.current.blocked.AddRef(bf)
+			r.current.feedWants.Delete(bf)
+			delete(hops, bf.Ref())
+		}
+		r.sched.setHops(hops)
+		r.sched.keepRemote(r.current.feedWants)
+
+		// unblocked feeds are fetched again if the hops above have them
+		prev, err := r.graphBlocked.List()
+		if err != nil {
+			level.Error(log).Log("msg", "previous blocked list failed", "err", err)
+			return
+		}
+		for _, bf := range prev {
+			if !newBlocked.Has(bf) {
+				level.Debug(log).Log("msg", "feed unblocked", "feed", bf.Ref())
+				r.graphBlocked.Delete(bf)
+				r.current.blocked.Delete(bf)
+			}
+		}
+	}
+}
+
+// applyPolicy marks ref as partially replicated or full again, as its distance calls for.
+// It returns false if the feed isn't fetched at all.
+func (r *graphReplicator) applyPolicy(log log.Logger, ref *ssb.FeedRef, hops int) bool {
+	if r.policy == nil {
+		return true
+	}
+	var p HopPolicy
+	if hops > 0 && !r.manual.Has(ref) {
+		p = r.policy(hops)
+	}
+	if p.Skip {
+		return false
+	}
+
+	m, marked := r.partial.Get(ref)
+	if len(p.Types) == 0 {
+		if marked {
+			level.Debug(log).Log("msg", "feed replicated fully again", "feed", ref.Ref())
+			if err := r.unmark(ref); err != nil {
+				level.Warn(log).Log("msg", "failed to make feed full", "feed", ref.Ref(), "err", err)
 			}
 		}
+		return true
+	}
+	if !marked || !sameTypes(m.Types, p.Types) {
+		level.Debug(log).Log("msg", "feed replicated partially", "feed", ref.Ref(), "types", strings.Join(p.Types, ","))
+		if err := r.mark(ref, p.Types); err != nil {
+			level.Warn(log).Log("msg", "failed to make feed partial", "feed", ref.Ref(), "err", err)
+		}
+	}
+	return true
+}
+
+func sameTypes(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// hopPolicy returns the policy of the closest hop level at or before hops, see WithHopPolicy
+func (s *Sbot) hopPolicy(hops int) HopPolicy {
+	var (
+		p    HopPolicy
+		from = -1
+	)
+	for h, hp := range s.hopPolicies {
+		if int(h) <= hops && int(h) > from {
+			p, from = hp, int(h)
+		}
+	}
+	return p
+}
+
+// markPartial makes feed partial, after the messages of it that are already stored
+func (s *Sbot) markPartial(feed *ssb.FeedRef, types []string) error {
+	if feed.Algo != ssb.RefAlgoFeedSSB1 {
+		return errors.Errorf("partial replication is only supported for %s feeds", ssb.RefAlgoFeedSSB1)
+	}
+	var (
+		seq int64
+		raw []byte
+	)
+	uf, ok := s.GetMultiLog(multilogs.IndexNameFeeds)
+	if !ok {
+		return errors.New("sbot: no users multilog")
+	}
+	userLog, err := uf.Get(feed.StoredAddr())
+	if err != nil {
+		return errors.Wrap(err, "failed to open sublog of feed")
+	}
+	v, err := userLog.Seq().Value()
+	if err != nil {
+		return errors.Wrap(err, "failed to get latest of feed")
+	}
+	if latest, ok := v.(margaret.Seq); ok && latest.Seq() >= 0 {
+		rxSeq, err := userLog.Get(latest)
+		if err != nil {
+			return errors.Wrap(err, "failed to get latest of feed")
+		}
+		mv, err := s.RootLog.Get(rxSeq.(margaret.Seq))
+		if err != nil {
+			return errors.Wrap(err, "failed to get latest of feed")
+		}
+		msg, ok := mv.(ssb.Message)
+		if !ok {
+			return errors.Errorf("wrong message type. expected %T - got %T", msg, mv)
+		}
+		seq, raw = msg.Seq(), msg.ValueContentJSON()
+	}
+	return s.partial.Mark(feed, types, seq, raw)
+}
+
+// unmarkPartial deletes the messages of a partial feed so that it is fetched fully again
+func (s *Sbot) unmarkPartial(feed *ssb.FeedRef) error {
+	uf, ok := s.GetMultiLog(multilogs.IndexNameFeeds)
+	if !ok {
+		return errors.New("sbot: no users multilog")
+	}
+	if has, err := multilog.Has(uf, feed.StoredAddr()); err != nil {
+		return err
+	} else if has {
+		if err := s.NullFeed(feed); err != nil {
+			return err
+		}
 	}
+	return s.partial.Unmark(feed)
 }
 
-func debounce(ctx context.Context, interval time.Duration, obs luigi.Observable, work func()) {
-	var seqMu sync.Mutex
-	var seq = margaret.SeqEmpty
+// nullBlockedFeed deletes the stored messages of a feed that we blocked, for DeleteBlockedFeeds
+func (s *Sbot) nullBlockedFeed(ref *ssb.FeedRef) {
+	uf, ok := s.GetMultiLog(multilogs.IndexNameFeeds)
+	if !ok {
+		return
+	}
+	if has, err := multilog.Has(uf, ref.StoredAddr()); err != nil || !has {
+		return
+	}
+	if err := s.NullFeed(ref); err != nil {
+		level.Warn(s.info).Log("event", "failed to delete blocked feed", "feed", ref.Ref(), "err", err)
+		return
+	}
+	level.Info(s.info).Log("event", "deleted blocked feed", "feed", ref.Ref())
+}
+
+// debounce calls work once no new value was emitted by obs for the duration of interval
+func debounce(ctx context.Context, interval time.Duration, obs luigi.Broadcast, work func()) {
+	var dirtyMu sync.Mutex
+	var dirty bool
 	timer := time.NewTimer(interval)
 
 	handle := luigi.FuncSink(func(ctx context.Context, val interface{}, err error) error {
 		if err != nil {
 			return err
 		}
-		newSeq, ok := val.(margaret.BaseSeq)
-		if !ok {
-			return fmt.Errorf("graph rebuild debounce: wrong type: %T", val)
-		}
-		seqMu.Lock()
-		seq = newSeq
+		dirtyMu.Lock()
+		dirty = true
 		timer.Reset(interval)
-		seqMu.Unlock()
+		dirtyMu.Unlock()
 		return nil
 	})
 	done := obs.Register(handle)
@@ -98,12 +396,12 @@ func debounce(ctx context.Context, interval time.Duration, obs luigi.Observable,
 			return
 
 		case <-timer.C:
-			seqMu.Lock()
-			if seq != margaret.SeqEmpty {
+			dirtyMu.Lock()
+			if dirty {
 				work()
-				seq = margaret.SeqEmpty
+				dirty = false
 			}
-			seqMu.Unlock()
+			dirtyMu.Unlock()
 		}
 	}
 }
@@ -111,14 +409,23 @@ func debounce(ctx context.Context, interval time.Duration, obs luigi.Observable,
 func (r *graphReplicator) Block(ref *ssb.FeedRef)   { r.current.blocked.AddRef(ref) }
 func (r *graphReplicator) Unblock(ref *ssb.FeedRef) { r.current.blocked.Delete(ref) }
 
-func (r *graphReplicator) Replicate(ref *ssb.FeedRef)     { r.current.feedWants.AddRef(ref) }
-func (r *graphReplicator) DontReplicate(ref *ssb.FeedRef) { r.current.feedWants.Delete(ref) }
+func (r *graphReplicator) Replicate(ref *ssb.FeedRef) {
+	r.manual.AddRef(ref)
+	r.current.feedWants.AddRef(ref)
+}
+
+func (r *graphReplicator) DontReplicate(ref *ssb.FeedRef) {
+	r.manual.Delete(ref)
+	r.current.feedWants.Delete(ref)
+}
 
 func (r *graphReplicator) Lister() ssb.ReplicationLister { return r.current }
 
 type lister struct {
 	feedWants *ssb.StrFeedSet
 	blocked   *ssb.StrFeedSet
+
+	sched *schedule // optional, without it there is no order
 }
 
 func newLister() *lister {
@@ -141,3 +448,30 @@ func (l lister) Authorize(remote *ssb.FeedRef) error {
 
 func (l lister) ReplicationList() *ssb.StrFeedSet { return l.feedWants }
 func (l lister) BlockList() *ssb.StrFeedSet       { return l.blocked }
+
+var _ ssb.ReplicationScheduler = lister{}
+
+func (l lister) Schedule() []ssb.FeedPriority {
+	feeds, err := l.feedWants.List()
+	if err != nil || l.sched == nil {
+		prios := make([]ssb.FeedPriority, len(feeds))
+		for i, f := range feeds {
+			prios[i] = ssb.FeedPriority{Feed: *f, Hops: -1}
+		}
+		return prios
+	}
+	return l.sched.priorities(feeds)
+}
+
+func (l lister) Prioritize(feeds []*ssb.FeedRef) {
+	if l.sched != nil {
+		l.sched.Prioritize(feeds)
+	}
+}
+
+// RemoteHas only keeps the sequences of the feeds we replicate, peers tell us about plenty of others
+func (l lister) RemoteHas(feed *ssb.FeedRef, seq int64) {
+	if l.sched != nil && l.feedWants.Has(feed) {
+		l.sched.RemoteHas(feed, seq)
+	}
+}
@@ -0,0 +1,245 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"math"
+	"sort"
+	"sync"
+
+	"go.cryptoscope.co/librarian"
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/margaret/multilog"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/partial"
+)
+
+// ScheduleWeights sets the order in which the replicator fetches the feeds, see WithScheduleWeights.
+// Our own feed always comes first. The others go by hops*Hops - log2(1+behind)*Lag, the lowest first,
+// where behind is how many messages we are missing as far as peers tell: the notes of ebt sessions,
+// the replicate.upto of legacy peers and what they sent us before. Claims only count up to 4096 messages beyond the ones we have.
+// Feeds added with Replicate count as one hop away.
+type ScheduleWeights struct {
+	Hops float64
+	Lag  float64
+
+	// FairEvery puts one of the feeds from the less urgent half at every FairEvery-th place, taking turns,
+	// so that they move on even while there is always something more urgent. Below 2 turns it off.
+	FairEvery int
+}
+
+// DefaultScheduleWeights fetches the closer feeds first, unless a further one is a lot more behind:
+// a feed one hop further away needs to miss 2^8 times as many messages to come before.
+var DefaultScheduleWeights = ScheduleWeights{Hops: 8, Lag: 1, FairEvery: 10}
+
+// schedule orders the feeds of the replicator by the ScheduleWeights
+type schedule struct {
+	users   multilog.MultiLog // optional, for the local sequences
+	partial *partial.Store    // optional, the sublogs of partial feeds have gaps
+
+	mu      sync.Mutex
+	weights ScheduleWeights
+	hops    map[string]int   // by feed, from the last walk of the graph
+	remote  map[string]int64 // the latest sequence peers told us about, by feed
+	turn    int              // of the fair places, moves on with every Prioritize
+}
+
+func newSchedule(users multilog.MultiLog, w ScheduleWeights) *schedule {
+	return &schedule{
+		users:   users,
+		weights: w,
+		hops:    make(map[string]int),
+		remote:  make(map[string]int64),
+	}
+}
+
+// setHops replaces the distances with the ones of a new walk
+func (s *schedule) setHops(hops map[string]int) {
+	s.mu.Lock()
+	s.hops = hops
+	s.mu.Unlock()
+}
+
+// keepRemote forgets the remote sequences of the feeds that are not in wants anymore, so that they don't pile up
+func (s *schedule) keepRemote(wants *ssb.StrFeedSet) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for feed := range s.remote {
+		ref, err := ssb.ParseFeedRef(feed)
+		if err != nil || !wants.Has(ref) {
+			delete(s.remote, feed)
+		}
+	}
+}
+
+// maxRemoteLead is how far beyond what we have a claim of a peer counts.
+// Anyone can claim any sequence, without a cap one claim would move a far away feed before all the close ones for good.
+// With it the lag can make up for about one and a half hops of the default weights, and it grows as the messages arrive.
+const maxRemoteLead = 1 << 12
+
+// RemoteHas records that a peer has feed up to seq, at most maxRemoteLead messages beyond the local ones
+func (s *schedule) RemoteHas(feed *ssb.FeedRef, seq int64) {
+	if lim := s.localSeq(feed) + maxRemoteLead; seq > lim {
+		seq = lim
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if seq > s.remote[feed.Ref()] {
+		s.remote[feed.Ref()] = seq
+	}
+}
+
+// remoteSeq returns the latest sequence of feed peers told us about, 0 if none did
+func (s *schedule) remoteSeq(feed *ssb.FeedRef) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.remote[feed.Ref()]
+}
+
+// priorities returns the priorities of feeds, most urgent first
+func (s *schedule) priorities(feeds []*ssb.FeedRef) []ssb.FeedPriority {
+	prios := make([]ssb.FeedPriority, len(feeds))
+	s.mu.Lock()
+	for i, feed := range feeds {
+		hops, ok := s.hops[feed.Ref()]
+		if !ok {
+			hops = -1
+		}
+		prios[i] = ssb.FeedPriority{
+			Feed:   *feed,
+			Hops:   hops,
+			Remote: s.remote[feed.Ref()],
+		}
+	}
+	s.mu.Unlock()
+
+	for i := range prios {
+		prios[i].Local = s.localSeq(&prios[i].Feed)
+		if prios[i].Remote > prios[i].Local {
+			prios[i].Behind = prios[i].Remote - prios[i].Local
+		}
+	}
+
+	scores := make(map[string]float64, len(prios))
+	for _, p := range prios {
+		scores[p.Feed.Ref()] = s.weights.score(p)
+	}
+	sort.SliceStable(prios, func(i, j int) bool {
+		a, b := prios[i], prios[j]
+		if (a.Hops == 0) != (b.Hops == 0) {
+			return a.Hops == 0
+		}
+		if sa, sb := scores[a.Feed.Ref()], scores[b.Feed.Ref()]; sa != sb {
+			return sa < sb
+		}
+		if ah, bh := closeness(a.Hops), closeness(b.Hops); ah != bh {
+			return ah < bh
+		}
+		if a.Behind != b.Behind {
+			return a.Behind > b.Behind
+		}
+		return a.Feed.Ref() < b.Feed.Ref()
+	})
+	return prios
+}
+
+// closeness is hops, with the ones added by hand as close as they get
+func closeness(hops int) int {
+	if hops < 0 {
+		return 1
+	}
+	return hops
+}
+
+func (w ScheduleWeights) score(p ssb.FeedPriority) float64 {
+	return float64(closeness(p.Hops))*w.Hops - math.Log2(1+float64(p.Behind))*w.Lag
+}
+
+// Prioritize sorts feeds by their priority, with the fair places of this turn
+func (s *schedule) Prioritize(feeds []*ssb.FeedRef) {
+	s.mu.Lock()
+	turn := s.turn
+	s.turn++
+	s.mu.Unlock()
+
+	prios := fairOrder(s.priorities(feeds), s.weights.FairEvery, turn)
+	for i, p := range prios {
+		feed := p.Feed
+		feeds[i] = &feed
+	}
+}
+
+// fairOrder moves one feed of the less urgent half of prios to every every-th place.
+// Which ones get there goes round with turn.
+func fairOrder(prios []ssb.FeedPriority, every, turn int) []ssb.FeedPriority {
+	if every < 2 || len(prios) < every {
+		return prios
+	}
+	slots := len(prios) / every
+	half := len(prios) / 2
+	pool := prios[half:]
+
+	var (
+		picked = make(map[int]bool, slots)
+		fair   = make([]ssb.FeedPriority, 0, slots)
+		rest   = make([]ssb.FeedPriority, 0, len(prios)-slots)
+	)
+	for j := 0; j < slots; j++ {
+		i := half + (turn*slots+j)%len(pool)
+		picked[i] = true
+		fair = append(fair, prios[i])
+	}
+	for i, p := range prios {
+		if !picked[i] {
+			rest = append(rest, p)
+		}
+	}
+
+	out := make([]ssb.FeedPriority, 0, len(prios))
+	for len(rest) > 0 || len(fair) > 0 {
+		if len(out)%every == every-1 && len(fair) > 0 {
+			out, fair = append(out, fair[0]), fair[1:]
+			continue
+		}
+		if len(rest) == 0 {
+			out, fair = append(out, fair[0]), fair[1:]
+			continue
+		}
+		out, rest = append(out, rest[0]), rest[1:]
+	}
+	return out
+}
+
+// localSeq returns the sequence of the latest message of feed we have, 0 if we have none
+func (s *schedule) localSeq(feed *ssb.FeedRef) int64 {
+	if m, ok := s.partialMarker(feed); ok {
+		return m.Seq
+	}
+	if s.users == nil {
+		return 0
+	}
+	l, err := s.users.Get(feed.StoredAddr())
+	if err != nil {
+		return 0
+	}
+	v, err := l.Seq().Value()
+	if err != nil {
+		return 0
+	}
+	switch sv := v.(type) {
+	case librarian.UnsetValue:
+		return 0
+	case margaret.Seq:
+		return sv.Seq() + 1
+	}
+	return 0
+}
+
+// partialMarker returns the marker of feed if it is replicated partially, its sublog only counts the stored messages
+func (s *schedule) partialMarker(feed *ssb.FeedRef) (partial.Marker, bool) {
+	if s.partial == nil {
+		return partial.Marker{}, false
+	}
+	return s.partial.Get(feed)
+}
@@ -0,0 +1,320 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/partial"
+)
+
+func TestSchedulePriorities(t *testing.T) {
+	r := require.New(t)
+
+	feed := func(b byte) *ssb.FeedRef {
+		return &ssb.FeedRef{ID: bytes.Repeat([]byte{b}, 32), Algo: ssb.RefAlgoFeedSSB1}
+	}
+	var (
+		self    = feed(1)
+		friend  = feed(2)
+		foaf    = feed(3)
+		manual  = feed(4)
+		distant = feed(5)
+	)
+
+	// only the lag, closer feeds only come first among the ones as far behind
+	sch := newSchedule(nil, ScheduleWeights{Lag: 1})
+	sch.setHops(map[string]int{
+		self.Ref():    0,
+		friend.Ref():  1,
+		foaf.Ref():    2,
+		distant.Ref(): 3,
+	})
+
+	// nothing known about the remotes, closest first
+	prios := sch.priorities([]*ssb.FeedRef{distant, manual, foaf, friend, self})
+	var order []string
+	for _, p := range prios {
+		order = append(order, p.Feed.Ref())
+	}
+	r.Equal([]string{self.Ref(), friend.Ref(), manual.Ref(), foaf.Ref(), distant.Ref()}, order)
+	r.Equal(-1, prios[2].Hops)
+
+	// the ones we are the furthest behind with come right after our own
+	sch.RemoteHas(distant, 50)
+	sch.RemoteHas(foaf, 10)
+	sch.RemoteHas(foaf, 5) // doesn't go back
+	sch.RemoteHas(self, 100)
+
+	feeds := []*ssb.FeedRef{friend, foaf, self, distant, manual}
+	sch.Prioritize(feeds)
+	order = order[:0]
+	for _, f := range feeds {
+		order = append(order, f.Ref())
+	}
+	r.Equal([]string{self.Ref(), distant.Ref(), foaf.Ref(), friend.Ref(), manual.Ref()}, order)
+
+	prios = sch.priorities([]*ssb.FeedRef{foaf})
+	r.Equal(int64(10), prios[0].Remote)
+	r.Equal(int64(10), prios[0].Behind)
+
+	// a partial feed is as far as its marker, not as many messages as are stored of it
+	dir, err := ioutil.TempDir("", t.Name())
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+	sch.partial, err = partial.Open(filepath.Join(dir, "partial.json"))
+	r.NoError(err)
+	r.NoError(sch.partial.Mark(foaf, []string{"contact"}, 4, nil))
+	prios = sch.priorities([]*ssb.FeedRef{foaf})
+	r.Equal(int64(4), prios[0].Local)
+	r.Equal(int64(6), prios[0].Behind)
+
+	// feeds we stopped replicating are forgotten, the lister doesn't take the ones we don't replicate
+	wants := ssb.NewFeedSet(0)
+	r.NoError(wants.AddRef(foaf))
+	sch.keepRemote(wants)
+	r.Equal(int64(0), sch.remoteSeq(distant))
+	r.Equal(int64(10), sch.remoteSeq(foaf))
+
+	l := lister{feedWants: wants, blocked: ssb.NewFeedSet(0), sched: sch}
+	l.RemoteHas(distant, 70)
+	l.RemoteHas(foaf, 20)
+	r.Equal(int64(0), sch.remoteSeq(distant))
+	r.Equal(int64(20), sch.remoteSeq(foaf))
+}
+
+func TestScheduleWeights(t *testing.T) {
+	r := require.New(t)
+
+	feed := func(b byte) *ssb.FeedRef {
+		return &ssb.FeedRef{ID: bytes.Repeat([]byte{b}, 32), Algo: ssb.RefAlgoFeedSSB1}
+	}
+	var (
+		self    = feed(1)
+		friend  = feed(2)
+		foaf    = feed(3)
+		far     = feed(4)
+		distant = feed(5)
+	)
+
+	sch := newSchedule(nil, ScheduleWeights{Hops: 8, Lag: 1})
+	sch.setHops(map[string]int{
+		self.Ref():    0,
+		friend.Ref():  1,
+		foaf.Ref():    2,
+		far.Ref():     2,
+		distant.Ref(): 3,
+	})
+	sch.RemoteHas(friend, 3)
+	sch.RemoteHas(foaf, 100)
+	sch.RemoteHas(far, 1000)
+
+	order := func() []string {
+		var refs []string
+		for _, p := range sch.priorities([]*ssb.FeedRef{far, foaf, friend, self}) {
+			refs = append(refs, p.Feed.Ref())
+		}
+		return refs
+	}
+	// the follows first, then the one we miss the most of
+	r.Equal([]string{self.Ref(), friend.Ref(), far.Ref(), foaf.Ref()}, order())
+
+	// a lot more behind beats a hop
+	sch.RemoteHas(far, 1<<20)
+	r.Equal([]string{self.Ref(), far.Ref(), friend.Ref(), foaf.Ref()}, order())
+	r.Equal(int64(maxRemoteLead), sch.remoteSeq(far), "claims are capped")
+
+	// but no claim moves a feed three hops away before the follows
+	sch.RemoteHas(distant, 1<<62)
+	prios := sch.priorities([]*ssb.FeedRef{distant, far, foaf, friend, self})
+	var refs []string
+	for _, p := range prios {
+		refs = append(refs, p.Feed.Ref())
+	}
+	r.Equal([]string{self.Ref(), far.Ref(), friend.Ref(), foaf.Ref(), distant.Ref()}, refs)
+}
+
+func TestScheduleFairness(t *testing.T) {
+	r := require.New(t)
+
+	// self, then 9 follows and 10 feeds two hops away
+	var feeds []*ssb.FeedRef
+	hops := make(map[string]int)
+	for i := 0; i < 20; i++ {
+		f := &ssb.FeedRef{ID: bytes.Repeat([]byte{byte(i + 1)}, 32), Algo: ssb.RefAlgoFeedSSB1}
+		feeds = append(feeds, f)
+		switch {
+		case i == 0:
+			hops[f.Ref()] = 0
+		case i < 10:
+			hops[f.Ref()] = 1
+		default:
+			hops[f.Ref()] = 2
+		}
+	}
+
+	sch := newSchedule(nil, ScheduleWeights{Hops: 1, FairEvery: 5})
+	sch.setHops(hops)
+
+	seen := make(map[string]bool)
+	for turn := 0; turn < 5; turn++ {
+		ordered := append([]*ssb.FeedRef(nil), feeds...)
+		sch.Prioritize(ordered)
+		r.Len(ordered, len(feeds))
+		r.True(ordered[0].Equal(feeds[0]), "our own feed isn't first")
+		for _, i := range []int{4, 9, 14, 19} {
+			r.Equal(2, hops[ordered[i].Ref()], "place %d of turn %d isn't a fair one", i, turn)
+		}
+		for _, f := range ordered[:10] {
+			if hops[f.Ref()] == 2 {
+				seen[f.Ref()] = true
+			}
+		}
+	}
+	// all the far ones got to the front at some point
+	r.Len(seen, 10)
+}
+
+// coldSync is a replication from scratch of self, 25 follows and 500 feeds two hops away, with a few big ones among them
+func coldSync() ([]*ssb.FeedRef, map[string]int, map[string]int64) {
+	rnd := rand.New(rand.NewSource(1))
+	var (
+		feeds  []*ssb.FeedRef
+		hops   = make(map[string]int)
+		remote = make(map[string]int64)
+	)
+	for i := 0; i < 526; i++ {
+		id := make([]byte, 32)
+		binary.BigEndian.PutUint32(id[28:], rnd.Uint32())
+		binary.BigEndian.PutUint32(id, uint32(i))
+		f := &ssb.FeedRef{ID: id, Algo: ssb.RefAlgoFeedSSB1}
+		feeds = append(feeds, f)
+		switch {
+		case i == 0:
+			hops[f.Ref()] = 0
+		case i <= 25:
+			hops[f.Ref()] = 1
+		default:
+			hops[f.Ref()] = 2
+		}
+		remote[f.Ref()] = 10 + rnd.Int63n(500)
+		if rnd.Intn(20) == 0 {
+			remote[f.Ref()] *= 20
+		}
+	}
+	rnd.Shuffle(len(feeds), func(i, j int) { feeds[i], feeds[j] = feeds[j], feeds[i] })
+	return feeds, hops, remote
+}
+
+// untilFollowsAreCurrent is how many messages are fetched in order until all the feeds one hop away are
+func untilFollowsAreCurrent(order []*ssb.FeedRef, hops map[string]int, remote map[string]int64) int64 {
+	left := 0
+	for _, h := range hops {
+		if h == 1 {
+			left++
+		}
+	}
+	var n int64
+	for _, f := range order {
+		n += remote[f.Ref()]
+		if hops[f.Ref()] == 1 {
+			if left--; left == 0 {
+				break
+			}
+		}
+	}
+	return n
+}
+
+func scheduledColdSync(w ScheduleWeights) int64 {
+	feeds, hops, remote := coldSync()
+	sch := newSchedule(nil, w)
+	sch.setHops(hops)
+	for _, f := range feeds {
+		sch.RemoteHas(f, remote[f.Ref()])
+	}
+	sch.Prioritize(feeds)
+	return untilFollowsAreCurrent(feeds, hops, remote)
+}
+
+// legacyColdSync is scheduledColdSync without ebt: the lag comes through the lister from the replicate.upto of
+// three peers that each have two thirds of the feeds, and of plenty of feeds we don't replicate
+func legacyColdSync(w ScheduleWeights) int64 {
+	feeds, hops, remote := coldSync()
+	rnd := rand.New(rand.NewSource(2))
+
+	l := newLister()
+	l.sched = newSchedule(nil, w)
+	l.sched.setHops(hops)
+	for _, f := range feeds {
+		l.feedWants.AddRef(f)
+	}
+	for peer := 0; peer < 3; peer++ {
+		var upto []ssb.ReplicateUpToResponse
+		for _, f := range feeds {
+			if rnd.Intn(3) > 0 {
+				upto = append(upto, ssb.ReplicateUpToResponse{ID: *f, Sequence: remote[f.Ref()]})
+			}
+		}
+		for i := 0; i < 1000; i++ {
+			id := make([]byte, 32)
+			rnd.Read(id)
+			upto = append(upto, ssb.ReplicateUpToResponse{ID: ssb.FeedRef{ID: id, Algo: ssb.RefAlgoFeedSSB1}, Sequence: 1 << 20})
+		}
+		for _, up := range upto {
+			up := up
+			l.RemoteHas(&up.ID, up.Sequence)
+		}
+	}
+	l.Prioritize(feeds)
+	return untilFollowsAreCurrent(feeds, hops, remote)
+}
+
+func TestScheduleColdSync(t *testing.T) {
+	feeds, hops, remote := coldSync()
+	unordered := untilFollowsAreCurrent(feeds, hops, remote)
+	scheduled := scheduledColdSync(DefaultScheduleWeights)
+	legacy := legacyColdSync(DefaultScheduleWeights)
+	t.Logf("messages until the follows are current: %d in the order of the list, %d scheduled, %d scheduled without ebt", unordered, scheduled, legacy)
+	require.True(t, scheduled*5 < unordered, "scheduling didn't help: %d vs %d", scheduled, unordered)
+	require.True(t, legacy*5 < unordered, "scheduling without ebt didn't help: %d vs %d", legacy, unordered)
+}
+
+func BenchmarkScheduleColdSync(b *testing.B) {
+	for _, bc := range []struct {
+		name   string
+		w      ScheduleWeights
+		legacy bool
+	}{
+		{"unordered", ScheduleWeights{}, false},
+		{"lag", ScheduleWeights{Lag: 1}, false},
+		{"default", DefaultScheduleWeights, false},
+		{"legacy-lag", ScheduleWeights{Lag: 1}, true},
+		{"legacy-default", DefaultScheduleWeights, true},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			var n int64
+			for i := 0; i < b.N; i++ {
+				switch {
+				case bc.w == (ScheduleWeights{}):
+					feeds, hops, remote := coldSync()
+					n = untilFollowsAreCurrent(feeds, hops, remote)
+				case bc.legacy:
+					n = legacyColdSync(bc.w)
+				default:
+					n = scheduledColdSync(bc.w)
+				}
+			}
+			b.ReportMetric(float64(n), "msgs-until-follows")
+		})
+	}
+}
@@ -0,0 +1,217 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/muxrpc"
+)
+
+// DefaultDrainTimeout is how long GracefulShutdown waits for the indexes and for open calls by default
+const DefaultDrainTimeout = 5 * time.Second
+
+// WithDrainTimeout sets how long GracefulShutdown waits for the indexes to catch up and for open calls to end before the connections are closed
+func WithDrainTimeout(d time.Duration) Option {
+	return func(s *Sbot) error {
+		if d < 0 {
+			return errors.Errorf("sbot: negative drain timeout")
+		}
+		s.drainTimeout = d
+		return nil
+	}
+}
+
+// GracefulShutdown stops the bot in order:
+// it stops accepting connections, lets the indexes process the messages that are in the log by then,
+// ends the streams of open calls and waits for them to finish (both up to the drain timeout, see WithDrainTimeout),
+// stops plugins that implement plugins2.Stopper and then closes everything else like Close, the log last.
+//
+// ctx bounds the whole process, if it is done the remaining steps are rushed through.
+// All errors are collected and returned together.
+func (s *Sbot) GracefulShutdown(ctx context.Context) error {
+	shutLog := kitlog.With(s.info, "event", "graceful shutdown")
+	var errs error
+
+	// 1) no new connections
+	if s.Network != nil {
+		if sa, ok := s.Network.(interface{ StopAccepting() error }); ok {
+			if err := sa.StopAccepting(); err != nil {
+				errs = multierror.Append(errs, errors.Wrap(err, "sbot: failed to stop accepting connections"))
+			}
+		}
+	}
+
+	// 2) the indexes catch up while their pumps still run, Shutdown stops them
+	if err := s.waitIndexes(ctx); err != nil {
+		level.Warn(shutLog).Log("msg", "indexes did not catch up before the drain timeout", "err", err)
+	} else {
+		level.Debug(shutLog).Log("msg", "indexes caught up")
+	}
+
+	// 3) live streams see the shutdown and send their end packets
+	s.Shutdown()
+
+	drainCtx, cancel := context.WithTimeout(ctx, s.drainTimeout)
+	err := s.calls.wait(drainCtx)
+	cancel()
+	if err != nil {
+		level.Warn(shutLog).Log("msg", "calls still open after drain timeout", "open", s.calls.count(), "err", err)
+	} else {
+		level.Debug(shutLog).Log("msg", "open calls drained")
+	}
+
+	// 4) plugins
+	for _, st := range s.stoppers {
+		if err := st.Stop(ctx); err != nil {
+			errs = multierror.Append(errs, errors.Wrapf(err, "sbot: failed to stop plugin %s", st.Name()))
+		}
+	}
+
+	// 5) indexes, network and the stores
+	closed := make(chan error, 1)
+	go func() { closed <- s.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	case <-ctx.Done():
+		// Close keeps going in the background, the caller shouldn't wait for it any longer
+		level.Warn(shutLog).Log("msg", "closing did not finish in time", "err", ctx.Err())
+		errs = multierror.Append(errs, errors.Wrap(ctx.Err(), "sbot: failed to close in time"))
+	}
+	return errs
+}
+
+// waitIndexes waits until the served indexes processed the log up to its current end, at most for the drain timeout.
+// Without live index updates only the backlog is processed, that is waited for.
+func (s *Sbot) waitIndexes(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.drainTimeout)
+	defer cancel()
+
+	synced := make(chan struct{})
+	go func() {
+		s.idxInSync.Wait()
+		close(synced)
+	}()
+	select {
+	case <-synced:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if !s.liveIndexUpdates {
+		return nil
+	}
+
+	seqV, err := s.RootLog.Seq().Value()
+	if err != nil {
+		return errors.Wrap(err, "sbot: failed to get the end of the log")
+	}
+	seq := seqV.(margaret.Seq).Seq()
+
+	tick := time.NewTicker(20 * time.Millisecond)
+	defer tick.Stop()
+	for !s.indexesAt(seq) {
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// indexesAt returns true if all served indexes processed the log up to seq
+func (s *Sbot) indexesAt(seq int64) bool {
+	s.indexStateMu.Lock()
+	defer s.indexStateMu.Unlock()
+	for _, snk := range s.indexSinks {
+		var qry seqRecorder
+		if err := snk.QuerySpec()(&qry); err != nil || qry.gt == nil || qry.gt.Seq() < seq {
+			return false
+		}
+	}
+	return true
+}
+
+// trackCalls wraps the handlers of new connections to count the calls that are still being handled
+func (s *Sbot) trackCalls(mk func(net.Conn) (muxrpc.Handler, error)) func(net.Conn) (muxrpc.Handler, error) {
+	return func(conn net.Conn) (muxrpc.Handler, error) {
+		h, err := mk(conn)
+		if err != nil || h == nil {
+			return h, err
+		}
+		return trackedHandler{Handler: h, calls: &s.calls}, nil
+	}
+}
+
+type trackedHandler struct {
+	muxrpc.Handler
+	calls *callCounter
+}
+
+// HandleCall only counts for as long as the wrapped handler blocks.
+// Handlers that hand the stream off to a goroutine are not waited for.
+func (th trackedHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
+	th.calls.add()
+	defer th.calls.done()
+	th.Handler.HandleCall(ctx, req, edp)
+}
+
+// callCounter is like a sync.WaitGroup that can be waited on with a context and that allows adds while waiting
+type callCounter struct {
+	mu      sync.Mutex
+	n       int
+	waiters []chan struct{}
+}
+
+func (cc *callCounter) add() {
+	cc.mu.Lock()
+	cc.n++
+	cc.mu.Unlock()
+}
+
+func (cc *callCounter) done() {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.n--
+	if cc.n == 0 {
+		for _, w := range cc.waiters {
+			close(w)
+		}
+		cc.waiters = nil
+	}
+}
+
+func (cc *callCounter) count() int {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.n
+}
+
+func (cc *callCounter) wait(ctx context.Context) error {
+	cc.mu.Lock()
+	if cc.n == 0 {
+		cc.mu.Unlock()
+		return nil
+	}
+	w := make(chan struct{})
+	cc.waiters = append(cc.waiters, w)
+	cc.mu.Unlock()
+
+	select {
+	case <-w:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/margaret"
+)
+
+func TestCallCounter(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	var cc callCounter
+	r.NoError(cc.wait(ctx), "nothing to wait for")
+
+	cc.add()
+	cc.add()
+
+	shortCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	r.Equal(context.DeadlineExceeded, cc.wait(shortCtx))
+	cancel()
+
+	waited := make(chan error)
+	go func() { waited <- cc.wait(ctx) }()
+
+	cc.done()
+	select {
+	case <-waited:
+		t.Fatal("returned with one call left")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	cc.done()
+	r.NoError(<-waited)
+	r.Equal(0, cc.count())
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	r := require.New(t)
+	testPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(testPath)
+
+	theBot, _ := makeTestBot(t)
+
+	for i := 0; i < 20; i++ {
+		_, err := theBot.PublishLog.Publish(map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the indexes get to the end of the log before they are stopped
+	r.NoError(theBot.waitIndexes(ctx))
+	seqV, err := theBot.RootLog.Seq().Value()
+	r.NoError(err)
+	r.True(theBot.indexesAt(seqV.(margaret.Seq).Seq()))
+	r.NotEmpty(theBot.indexSinks)
+
+	r.NoError(theBot.GracefulShutdown(ctx))
+
+	// closing again returns the same result
+	r.NoError(theBot.Close())
+}
@@ -0,0 +1,15 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"io"
+
+	"go.cryptoscope.co/ssb/repo"
+)
+
+// Snapshot writes a consistent backup of the running bot to w, see repo.Snapshot for the details.
+// Use repo.Restore to unpack it into a new repo.
+func (s *Sbot) Snapshot(w io.Writer) (*repo.SnapshotManifest, error) {
+	return repo.Snapshot(w, s.repository(), s.RootLog)
+}
@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"io"
+	"strings"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/librarian"
+	"go.cryptoscope.co/margaret"
+
+	"go.cryptoscope.co/ssb/repo"
+)
+
+// DisableStartupCheck skips the repair of a torn log tail and of indexes that are ahead of the log.
+// Only useful to debug a broken repo as it is.
+func DisableStartupCheck() Option {
+	return func(s *Sbot) error {
+		s.skipStartupCheck = true
+		return nil
+	}
+}
+
+// startupCheck runs before the log and the indexes are opened.
+// It drops indexes that were found to be ahead of the log the last time and repairs a torn write at the end of the log.
+func (s *Sbot) startupCheck(r repo.Interface) error {
+	if s.readOnly || s.skipStartupCheck {
+		return nil
+	}
+	evt := level.Warn(s.info)
+
+	dropped, err := repo.DropScheduledIndexes(r)
+	if err != nil {
+		return errors.Wrap(err, "sbot: failed to drop indexes for rebuild")
+	}
+	if len(dropped) > 0 {
+		evt.Log("event", "startup check", "msg", "dropped indexes, they will be rebuilt", "indexes", strings.Join(dropped, ","))
+	}
+
+	rep, err := repo.RepairLogTail(r)
+	if err != nil {
+		return errors.Wrap(err, "sbot: failed to check the end of the log")
+	}
+	if rep.Repaired() {
+		evt.Log("event", "startup check", "msg", "repaired the end of the log",
+			"entries", rep.Entries,
+			"dropped-entries", rep.DroppedEntries,
+			"dropped-bytes", rep.DroppedBytes,
+			"backup", rep.Backup,
+			"reasons", strings.Join(rep.Reasons, "; "))
+	}
+	return nil
+}
+
+// openIndex opens the index name with open and checks if it already processed messages the log doesn't have (anymore).
+// If it has, it is closed, dropped and opened again empty, serveIndex then rebuilds it from the log.
+// mlog is the multilog of the index, if it has one, it is closed with the sink.
+// If the index can't be dropped now, it is scheduled to be rebuilt on the next start.
+func (s *Sbot) openIndex(name string, open func() (mlog io.Closer, snk librarian.SinkIndex, err error)) (librarian.SinkIndex, error) {
+	mlog, snk, err := open()
+	if err != nil {
+		return nil, err
+	}
+	if !s.indexAhead(name, snk) {
+		return snk, nil
+	}
+
+	evt := level.Error(s.info)
+	r := s.repository()
+	closeErr := snk.Close()
+	if mlog != nil {
+		if err := mlog.Close(); err != nil && closeErr == nil {
+			closeErr = err
+		}
+	}
+	if closeErr == nil {
+		closeErr = repo.DropIndex(r, name)
+	}
+	if closeErr != nil {
+		evt.Log("event", "startup check", "index", name, "msg", "failed to drop index, scheduling rebuild", "err", closeErr)
+		if err := repo.ScheduleIndexRebuild(r, name); err != nil {
+			evt.Log("event", "startup check", "index", name, "err", err)
+		}
+		return nil, errors.Wrapf(closeErr, "sbot: index %s is ahead of the log, restart to rebuild it", name)
+	}
+
+	evt.Log("event", "startup check", "msg", "dropped index, it will be rebuilt", "index", name)
+	_, snk, err = open()
+	return snk, err
+}
+
+// indexAhead checks if the index already processed messages the log doesn't have (anymore).
+func (s *Sbot) indexAhead(name string, snk librarian.SinkIndex) bool {
+	if s.readOnly || s.skipStartupCheck {
+		return false
+	}
+
+	var qry seqRecorder
+	if err := snk.QuerySpec()(&qry); err != nil {
+		level.Warn(s.info).Log("event", "startup check", "index", name, "err", err)
+		return false
+	}
+	if qry.gt == nil {
+		return false
+	}
+
+	logSeqV, err := s.RootLog.Seq().Value()
+	if err != nil {
+		level.Warn(s.info).Log("event", "startup check", "index", name, "err", err)
+		return false
+	}
+	logSeq := logSeqV.(margaret.Seq).Seq()
+	if qry.gt.Seq() <= logSeq {
+		return false
+	}
+
+	level.Error(s.info).Log("event", "startup check", "msg", "index is ahead of the log",
+		"index", name, "index-seq", qry.gt.Seq(), "log-seq", logSeq)
+	return true
+}
+
+// seqRecorder implements margaret.Query to find out where an index wants to continue from
+type seqRecorder struct {
+	gt margaret.Seq
+}
+
+func (sr *seqRecorder) Gt(s margaret.Seq) error {
+	sr.gt = s
+	return nil
+}
+
+func (sr *seqRecorder) Gte(s margaret.Seq) error {
+	sr.gt = margaret.BaseSeq(s.Seq() - 1)
+	return nil
+}
+
+func (sr *seqRecorder) Lt(margaret.Seq) error  { return nil }
+func (sr *seqRecorder) Lte(margaret.Seq) error { return nil }
+func (sr *seqRecorder) Limit(int) error        { return nil }
+func (sr *seqRecorder) Live(bool) error        { return nil }
+func (sr *seqRecorder) SeqWrap(bool) error     { return nil }
+func (sr *seqRecorder) Reverse(bool) error     { return nil }
@@ -13,6 +13,7 @@ import (
 	"go.cryptoscope.co/margaret"
 	"go.cryptoscope.co/netwrap"
 	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/network"
 	multiserver "go.mindeco.de/ssb-multiserver"
 )
 
@@ -22,10 +23,16 @@ func (sbot *Sbot) Status() (ssb.Status, error) {
 		return ssb.Status{}, errors.Wrap(err, "failed to get root log sequence")
 	}
 
+	stored, err := sbot.BlobStore.Stats()
+	if err != nil {
+		return ssb.Status{}, errors.Wrap(err, "failed to get blob store stats")
+	}
+
 	s := ssb.Status{
-		PID:   os.Getpid(),
-		Root:  margaret.BaseSeq(v.(margaret.Seq).Seq()),
-		Blobs: sbot.WantManager.AllWants(),
+		PID:    os.Getpid(),
+		Root:   margaret.BaseSeq(v.(margaret.Seq).Seq()),
+		Blobs:  sbot.WantManager.AllWants(),
+		Stored: stored,
 	}
 
 	edps := sbot.Network.GetAllEndpoints()
@@ -38,10 +45,31 @@ func (sbot *Sbot) Status() (ssb.Status, error) {
 		if tcpAddr, ok := netwrap.GetAddr(es.Addr, "tcp").(*net.TCPAddr); ok {
 			ms.Addr = *tcpAddr
 		}
-		s.Peers = append(s.Peers, ssb.PeerStatus{
-			Addr:  ms.String(),
+		addr := ms.String()
+		if hostAddr, ok := network.FormatAddress(es.Addr, es.ID); ok {
+			// onion and other peers we dialed by name
+			addr = hostAddr
+		}
+		ps := ssb.PeerStatus{
+			Addr:  addr,
 			Since: humanize.Time(time.Now().Add(-es.Since)),
-		})
+		}
+		if es.Listener != nil {
+			ps.Listener = es.Listener.String()
+		}
+		s.Peers = append(s.Peers, ps)
+	}
+
+	if sbot.connManager != nil {
+		s.Connections = connectionsStatus(sbot.connManager.State())
+	}
+
+	if dbs, ok := sbot.Network.(ssb.DialBackoffs); ok {
+		s.Dials = dbs.DialBackoffs()
+	}
+
+	if ts, ok := sbot.Network.(ssb.TrafficStats); ok {
+		s.Traffic = ts.Traffic()
 	}
 
 	var idxState ssb.IndexStates
@@ -56,12 +84,50 @@ func (sbot *Sbot) Status() (ssb.Status, error) {
 
 	sbot.indexStateMu.Unlock()
 
+	if sbot.privReplays != nil {
+		for _, job := range sbot.privReplays.Jobs() {
+			idxState = append(idxState, ssb.IndexState{
+				Name:  "privates replay " + job.Name(),
+				State: job.String(),
+			})
+		}
+	}
+
 	sort.Sort(byName(idxState))
 	s.Indicies = idxState
 
 	return s, nil
 }
 
+func connectionsStatus(st network.ConnState) *ssb.ConnectionsStatus {
+	cs := &ssb.ConnectionsStatus{Max: st.Max}
+	for _, o := range st.Open {
+		cs.Open = append(cs.Open, ssb.ManagedPeerStatus{
+			Feed:   o.Feed.Ref(),
+			Since:  humanize.Time(o.Since),
+			Idle:   time.Since(o.LastActive).Round(time.Second).String(),
+			Sticky: o.Sticky,
+		})
+	}
+	for _, k := range st.Known {
+		kp := ssb.KnownPeerStatus{
+			Addr:     k.Addr.String(),
+			Source:   k.Source,
+			Failures: k.Failures,
+			NextTry:  "now",
+		}
+		if time.Now().Before(k.NextTry) {
+			kp.NextTry = humanize.Time(k.NextTry)
+		}
+		if k.LastErr != nil {
+			kp.Failure = string(k.Failure)
+			kp.LastErr = k.LastErr.Error()
+		}
+		cs.Known = append(cs.Known, kp)
+	}
+	return cs
+}
+
 type byConnTime []ssb.EndpointStat
 
 func (bct byConnTime) Len() int { return len(bct) }
@@ -0,0 +1,78 @@
+// SPDX-License-Identifier: MIT
+
+package tests
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/margaret"
+
+	"go.cryptoscope.co/ssb/sbot"
+)
+
+// the js bot runs ssb-ebt and dials us, so it opens the ebt.replicate stream and we answer it
+func TestEBTWithJS(t *testing.T) {
+	r := require.New(t)
+	const n = 23
+
+	ts := newRandomSession(t)
+	ts.jsEnv = []string{"TEST_EBT=1"}
+
+	ts.startGoBot(sbot.EnableEBT(true))
+	bob := ts.gobot
+
+	for i := 0; i < 3; i++ {
+		_, err := bob.PublishLog.Publish(map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+	}
+
+	alice := ts.startJSBot(fmt.Sprintf(`
+	sbot.replicate.request(testBob, true)
+	function mkMsg(msg) {
+		return function(cb) {
+			sbot.publish(msg, cb)
+		}
+	}
+	let msgs = []
+	for (var i = %d; i>0; i--) {
+		msgs.push(mkMsg({type:"test", text:"foo", i:i}))
+	}
+	parallel(msgs, function(err, results) {
+		t.error(err, "parallel of publish")
+		run()
+	})
+`, n), `
+	pull(
+		sbot.createHistoryStream({id: testBob, live: true}),
+		pull.take(3),
+		pull.collect(function(err, msgs) {
+			t.error(err, "got bob's feed")
+			t.equal(msgs.length, 3)
+			// give the go side time for ours
+			setTimeout(exit, 3000)
+		})
+	)
+`)
+
+	bob.Replicate(alice)
+
+	<-ts.doneJS
+
+	uf, ok := bob.GetMultiLog("userFeeds")
+	r.True(ok)
+	aliceLog, err := uf.Get(alice.StoredAddr())
+	r.NoError(err)
+	seq, err := aliceLog.Seq().Value()
+	r.NoError(err)
+	r.Equal(margaret.BaseSeq(n-1), seq)
+
+	// createHistoryStream alone would also get the feeds there, make sure they came over ebt.
+	// The notes are only understood in the encoding of version 3, so this also checks that ssb-ebt speaks it.
+	rep := bob.EBT()
+	r.NotNil(rep)
+	r.NotZero(rep.NotesFrom(alice), "no ebt session ran with the js bot")
+
+	ts.wait()
+}
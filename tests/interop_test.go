@@ -55,6 +55,9 @@ type testSession struct {
 
 	keySHS, keyHMAC []byte
 
+	// extra environment for the js bots, TEST_EBT=1 for example
+	jsEnv []string
+
 	// since we can't pass *testing.T to other goroutines, we use this to collect errors from background taskts
 	backgroundErrs []<-chan error
 
@@ -172,6 +175,7 @@ func (ts *testSession) startJSBotWithName(name, jsbefore, jsafter string) *ssb.F
 		"TEST_AFTER=" + writeFile(ts.t, jsafter),
 	}
 	jsBotCnt++
+	env = append(env, ts.jsEnv...)
 	if ts.keySHS != nil {
 		env = append(env, "TEST_APPKEY="+base64.StdEncoding.EncodeToString(ts.keySHS))
 	}
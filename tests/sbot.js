@@ -18,6 +18,10 @@ const createSbot = require('ssb-server')
   .use(require('ssb-peer-invites'))
   .use(require('ssb-invite'))
 
+if (process.env.TEST_EBT) {
+  createSbot.use(require('ssb-ebt'))
+}
+
 const testName = process.env.TEST_NAME
 const testBob = process.env.TEST_BOB
 const testAddr = process.env.TEST_GOADDR